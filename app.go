@@ -2,16 +2,25 @@ package newMilli
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/google/uuid"
 	"golang.org/x/sync/errgroup"
+	"new-milli/registry"
 	"new-milli/transport"
 )
 
+// defaultSignals are the signals stopping an application by default.
+var defaultSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT}
+
 // AppInfo is application context value.
 type AppInfo interface {
 	ID() string
@@ -21,16 +30,21 @@ type AppInfo interface {
 
 // App is an application lifecycle manager.
 type App struct {
-	opts   options
-	ctx    context.Context
-	cancel func()
+	opts     options
+	ctx      context.Context
+	cancel   func()
+	mu       sync.Mutex
+	instance *registry.ServiceInfo
+	report   ShutdownReport
+	statuses []ServerStatus
+	stopOnce sync.Once
 }
 
 // New creates a new application.
 func New(opts ...Option) (*App, error) {
 	o := options{
 		ctx:              context.Background(),
-		sigs:             []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT},
+		sigs:             defaultSignals,
 		registrarTimeout: 10 * time.Second,
 		stopTimeout:      10 * time.Second,
 		metadata:         make(map[string]string),
@@ -39,23 +53,31 @@ func New(opts ...Option) (*App, error) {
 	for _, opt := range opts {
 		opt(&o)
 	}
+	if o.id == "" {
+		o.id = uuid.NewString()
+	}
+	if _, err := closeOrder(o.components); err != nil {
+		return nil, err
+	}
 
-	for _, srv := range o.servers {
-		srv := srv
-		if err := srv.Init(
+	statuses := make([]ServerStatus, len(o.servers))
+	for i, s := range o.servers {
+		if err := s.srv.Init(
 			transport.ID(o.id),
 			transport.Name(o.name),
 			transport.Version(o.version),
 		); err != nil {
-			return nil, err
+			return nil, &ServerError{Name: s.name, Err: err}
 		}
+		statuses[i] = ServerStatus{Name: s.name, State: ServerPending, Policy: s.policy}
 	}
 
 	ctx, cancel := context.WithCancel(o.ctx)
 	return &App{
-		ctx:    ctx,
-		cancel: cancel,
-		opts:   o,
+		ctx:      ctx,
+		cancel:   cancel,
+		opts:     o,
+		statuses: statuses,
 	}, nil
 }
 
@@ -68,8 +90,44 @@ func (a *App) Name() string { return a.opts.name }
 // Version returns app version.
 func (a *App) Version() string { return a.opts.version }
 
+// Metadata returns service metadata.
+func (a *App) Metadata() map[string]string { return a.opts.metadata }
+
+// Endpoint returns the endpoints registered for the app.
+func (a *App) Endpoint() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.instance == nil {
+		return nil
+	}
+	return a.instance.Endpoints
+}
+
+// ShutdownReport returns the outcome of closing the components, once Run
+// has returned.
+func (a *App) ShutdownReport() ShutdownReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.report
+}
+
 // Run executes all OnStart hooks registered with the application's Lifecycle.
+// The components are closed when it returns.
 func (a *App) Run() error {
+	err := a.run()
+
+	ctx, cancel := context.WithTimeout(NewContext(context.Background(), a), a.opts.stopTimeout)
+	defer cancel()
+	report := closeComponents(ctx, a.opts.components)
+	a.mu.Lock()
+	a.report = report
+	a.mu.Unlock()
+
+	return errors.Join(err, report.Err())
+}
+
+// run starts the servers and waits for them to stop.
+func (a *App) run() error {
 	ctx := NewContext(a.ctx, a)
 	eg, ctx := errgroup.WithContext(ctx)
 	wg := sync.WaitGroup{}
@@ -81,26 +139,89 @@ func (a *App) Run() error {
 		}
 	}
 
-	for _, srv := range a.opts.servers {
-		srv := srv
+	for i, s := range a.opts.servers {
+		i, s := i, s
 		eg.Go(func() error {
 			<-ctx.Done()
 			stopCtx, cancel := context.WithTimeout(NewContext(context.Background(), a), a.opts.stopTimeout)
 			defer cancel()
-			return srv.Stop(stopCtx)
+			err := s.srv.Stop(stopCtx)
+			a.updateServer(i, func(st *ServerStatus) {
+				if st.State != ServerFailed {
+					st.State = ServerStopped
+					st.Err = err
+					st.StoppedAt = time.Now()
+				}
+			})
+			if err != nil {
+				return &ServerError{Name: s.name, Err: err}
+			}
+			return nil
 		})
 		wg.Add(1)
 		eg.Go(func() error {
 			wg.Done()
-			return srv.Start(ctx)
+			a.updateServer(i, func(st *ServerStatus) {
+				st.State = ServerRunning
+				st.StartedAt = time.Now()
+			})
+			err := s.srv.Start(ctx)
+			// Errors of servers returning once the app is stopping aren't failures
+			if err == nil || ctx.Err() != nil {
+				return nil
+			}
+
+			a.updateServer(i, func(st *ServerStatus) {
+				st.State = ServerFailed
+				st.Err = err
+				st.StoppedAt = time.Now()
+			})
+			if s.policy == Continue {
+				klog.Errorf("server %s failed, the app keeps running: %v", s.name, err)
+				return nil
+			}
+			klog.Errorf("server %s failed, stopping the app: %v", s.name, err)
+			return &ServerError{Name: s.name, Err: err}
 		})
 	}
 	wg.Wait()
 
+	// The servers are running: stop them before returning an error, through
+	// Stop once the service is registered so it is deregistered as well
+	registered := false
+	abort := func(err error) error {
+		if registered {
+			err = errors.Join(err, a.Stop())
+		} else if a.cancel != nil {
+			a.cancel()
+		}
+		if werr := eg.Wait(); werr != nil && werr != context.Canceled {
+			return errors.Join(err, werr)
+		}
+		return err
+	}
+
+	// Register the service
+	if a.opts.registrar != nil {
+		instance, err := a.buildInstance()
+		if err != nil {
+			return abort(err)
+		}
+		rctx, rcancel := context.WithTimeout(ctx, a.opts.registrarTimeout)
+		defer rcancel()
+		if err := a.opts.registrar.Register(rctx, instance); err != nil {
+			return abort(err)
+		}
+		a.mu.Lock()
+		a.instance = instance
+		a.mu.Unlock()
+		registered = true
+	}
+
 	// After start
 	for _, fn := range a.opts.afterStart {
 		if err := fn(ctx); err != nil {
-			return err
+			return abort(err)
 		}
 	}
 
@@ -115,29 +236,122 @@ func (a *App) Run() error {
 		}
 	})
 
-	if err := eg.Wait(); err != nil && err != context.Canceled {
-		return err
+	err := eg.Wait()
+	if err == context.Canceled {
+		err = nil
 	}
-	return nil
+	// A failed server or a canceled context stops the app without Stop
+	if registered {
+		err = errors.Join(err, a.Stop())
+	}
+	return err
+}
+
+// ServerStatus returns the status of the servers in registration order.
+func (a *App) ServerStatus() []ServerStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	statuses := make([]ServerStatus, len(a.statuses))
+	copy(statuses, a.statuses)
+	return statuses
 }
 
-// Stop gracefully stops the application.
+// updateServer updates the status of the i-th server.
+func (a *App) updateServer(i int, fn func(*ServerStatus)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fn(&a.statuses[i])
+}
+
+// Stop gracefully stops the application. Only the first call stops it, the
+// later ones return nil.
 func (a *App) Stop() error {
-	ctx := NewContext(a.ctx, a)
-	for _, fn := range a.opts.beforeStop {
-		if err := fn(ctx); err != nil {
-			return err
+	var err error
+	a.stopOnce.Do(func() { err = a.stop() })
+	return err
+}
+
+// stop deregisters the service, runs the stop hooks and cancels the app.
+func (a *App) stop() error {
+	// The app context may already be canceled when a server failed
+	ctx := NewContext(context.WithoutCancel(a.ctx), a)
+
+	// Deregister the service, a failure doesn't prevent the app from stopping
+	var derr error
+	a.mu.Lock()
+	instance := a.instance
+	a.mu.Unlock()
+	if a.opts.registrar != nil && instance != nil {
+		rctx, rcancel := context.WithTimeout(ctx, a.opts.registrarTimeout)
+		defer rcancel()
+		if err := a.opts.registrar.Deregister(rctx, instance); err != nil {
+			klog.Errorf("failed to deregister the service: %v", err)
+			derr = fmt.Errorf("failed to deregister the service: %w", err)
 		}
 	}
+
+	// The stop hooks share the StopTimeout and outlive the app context
+	stopCtx, cancel := context.WithTimeout(NewContext(context.Background(), a), a.opts.stopTimeout)
+	defer cancel()
+	err := runHooks(stopCtx, "before stop", a.opts.beforeStop)
 	if a.cancel != nil {
 		a.cancel()
 	}
-	for _, fn := range a.opts.afterStop {
-		if err := fn(ctx); err != nil {
-			return err
+	return errors.Join(derr, err, runHooks(stopCtx, "after stop", a.opts.afterStop))
+}
+
+// buildInstance builds the registry instance of the app from its endpoints.
+func (a *App) buildInstance() (*registry.ServiceInfo, error) {
+	endpoints := make([]*url.URL, 0, len(a.opts.endpoints))
+	endpoints = append(endpoints, a.opts.endpoints...)
+	if len(endpoints) == 0 {
+		for _, s := range a.opts.servers {
+			e, ok := s.srv.(transport.Endpointer)
+			if !ok {
+				continue
+			}
+			u, err := e.Endpoint()
+			if err != nil {
+				return nil, err
+			}
+			endpoints = append(endpoints, u)
 		}
 	}
-	return nil
+
+	instance := &registry.ServiceInfo{
+		ID:       a.opts.id,
+		Name:     a.opts.name,
+		Version:  a.opts.version,
+		Metadata: a.opts.metadata,
+	}
+	seen := make(map[string]bool, len(endpoints))
+	for i, u := range endpoints {
+		// Every node needs its own id when the app exposes several servers
+		id := a.opts.id
+		if len(endpoints) > 1 {
+			id = fmt.Sprintf("%s-%s", a.opts.id, u.Scheme)
+			if seen[id] {
+				id = fmt.Sprintf("%s-%d", id, i)
+			}
+			seen[id] = true
+		}
+
+		metadata := make(map[string]string, len(a.opts.metadata)+1)
+		for k, v := range a.opts.metadata {
+			metadata[k] = v
+		}
+		metadata["scheme"] = u.Scheme
+
+		instance.Endpoints = append(instance.Endpoints, u.String())
+		instance.Nodes = append(instance.Nodes, &registry.Node{
+			ID:       id,
+			Address:  u.Host,
+			Metadata: metadata,
+		})
+	}
+
+	return instance, nil
 }
 
 type appKey struct{}
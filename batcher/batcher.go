@@ -0,0 +1,222 @@
+package batcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+var (
+	// ErrClosed is returned when adding to a closed batcher.
+	ErrClosed = errors.New("batcher: closed")
+	// ErrFull is returned by TryAdd when the queue is full.
+	ErrFull = errors.New("batcher: queue full")
+)
+
+// FlushFunc is called with a batch of items.
+type FlushFunc[T any] func(ctx context.Context, items []T) error
+
+// ErrorHandler is called when a flush fails.
+type ErrorHandler func(err error, size int)
+
+// Option is batcher option.
+type Option func(*options)
+
+// options is batcher options.
+type options struct {
+	size         int
+	interval     time.Duration
+	queueSize    int
+	flushTimeout time.Duration
+	errorHandler ErrorHandler
+}
+
+// WithSize sets the number of items that triggers a flush.
+func WithSize(size int) Option {
+	return func(o *options) {
+		o.size = size
+	}
+}
+
+// WithInterval sets the maximum time items wait before being flushed, 1s by
+// default. With zero or a negative interval the items are flushed as soon
+// as the queue is empty, without waiting for more.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithQueueSize sets the capacity of the pending item queue.
+func WithQueueSize(size int) Option {
+	return func(o *options) {
+		o.queueSize = size
+	}
+}
+
+// WithFlushTimeout sets the timeout of each flush call.
+func WithFlushTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.flushTimeout = timeout
+	}
+}
+
+// WithErrorHandler sets the handler called when a flush fails.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(o *options) {
+		o.errorHandler = h
+	}
+}
+
+// Batcher collects items and flushes them in batches, either when the batch
+// reaches the configured size or when the interval has elapsed.
+type Batcher[T any] struct {
+	opts  options
+	flush FlushFunc[T]
+	queue chan T
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// New creates a new batcher that calls flush with each batch.
+func New[T any](flush FlushFunc[T], opts ...Option) *Batcher[T] {
+	o := options{
+		size:     100,
+		interval: time.Second,
+		errorHandler: func(err error, size int) {
+			klog.Errorf("batcher: failed to flush %d items: %v", size, err)
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.size <= 0 {
+		o.size = 1
+	}
+	if o.queueSize <= 0 {
+		o.queueSize = o.size * 10
+	}
+
+	b := &Batcher[T]{
+		opts:  o,
+		flush: flush,
+		queue: make(chan T, o.queueSize),
+		done:  make(chan struct{}),
+	}
+	go b.run()
+
+	return b
+}
+
+// Add adds an item, blocking while the queue is full until ctx is done.
+func (b *Batcher[T]) Add(ctx context.Context, item T) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	select {
+	case b.queue <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAdd adds an item without blocking and returns ErrFull if the queue is full.
+func (b *Batcher[T]) TryAdd(item T) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	select {
+	case b.queue <- item:
+		return nil
+	default:
+		return ErrFull
+	}
+}
+
+// Len returns the number of items waiting in the queue.
+func (b *Batcher[T]) Len() int {
+	return len(b.queue)
+}
+
+// Close stops accepting items and flushes everything still pending.
+// It waits for the drain to finish or for ctx to be done.
+func (b *Batcher[T]) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects items from the queue and flushes them.
+func (b *Batcher[T]) run() {
+	defer close(b.done)
+
+	var tick <-chan time.Time
+	if b.opts.interval > 0 {
+		ticker := time.NewTicker(b.opts.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	batch := make([]T, 0, b.opts.size)
+	for {
+		select {
+		case item, ok := <-b.queue:
+			if !ok {
+				b.doFlush(batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= b.opts.size || (tick == nil && len(b.queue) == 0) {
+				b.doFlush(batch)
+				batch = make([]T, 0, b.opts.size)
+			}
+		case <-tick:
+			if len(batch) > 0 {
+				b.doFlush(batch)
+				batch = make([]T, 0, b.opts.size)
+			}
+		}
+	}
+}
+
+// doFlush calls the flush function with a batch.
+func (b *Batcher[T]) doFlush(batch []T) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	if b.opts.flushTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.opts.flushTimeout)
+		defer cancel()
+	}
+
+	if err := b.flush(ctx, batch); err != nil && b.opts.errorHandler != nil {
+		b.opts.errorHandler(err, len(batch))
+	}
+}
@@ -0,0 +1,345 @@
+package bridge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/broker"
+	"new-milli/cache"
+	"new-milli/collector"
+	"new-milli/transport"
+)
+
+var _ transport.Server = (*Bridge)(nil)
+
+// Message headers read and written by the bridge.
+const (
+	// HeaderMessageID identifies a message for the replay protection, the
+	// hash of the topic and body is used when it is missing.
+	HeaderMessageID = "Message-Id"
+	// HeaderTimestamp is the time a message was produced in Unix
+	// milliseconds, the bridge measures its lag when it is set.
+	HeaderTimestamp = "Timestamp"
+	// HeaderSourceTopic records the source topic on the republished message.
+	HeaderSourceTopic = "X-Bridge-Source-Topic"
+)
+
+// Results of the bridged messages.
+const (
+	resultForwarded = "forwarded"
+	resultFiltered  = "filtered"
+	resultDuplicate = "duplicate"
+	resultFailed    = "failed"
+)
+
+// bridgeMetrics is the metrics of the bridges.
+type bridgeMetrics struct {
+	messages *prometheus.CounterVec
+	lag      *prometheus.HistogramVec
+	running  *prometheus.GaugeVec
+}
+
+// newBridgeMetrics creates the bridge metrics registered with registry,
+// reusing the registered ones. They aren't registered when registry is nil.
+func newBridgeMetrics(registry prometheus.Registerer) *bridgeMetrics {
+	m := &bridgeMetrics{
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "bridge",
+			Name:      "messages_total",
+			Help:      "Number of messages received by the bridges by result.",
+		}, []string{"bridge", "topic", "result"}),
+		lag: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "new_milli",
+			Subsystem: "bridge",
+			Name:      "lag_seconds",
+			Help:      "Time between the production of a message and its republication.",
+			Buckets:   []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 30, 60, 300, 900, 3600},
+		}, []string{"bridge", "topic"}),
+		running: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "bridge",
+			Name:      "running",
+			Help:      "Whether the bridge is running.",
+		}, []string{"bridge"}),
+	}
+	if registry != nil {
+		var errs [3]error
+		m.messages, errs[0] = collector.Register(registry, m.messages)
+		m.lag, errs[1] = collector.Register(registry, m.lag)
+		m.running, errs[2] = collector.Register(registry, m.running)
+		if err := errors.Join(errs[:]...); err != nil {
+			klog.Warnf("Failed to register bridge metrics: %v", err)
+		}
+	}
+	return m
+}
+
+// Transform transforms a message before it is republished to the target
+// topic and may change the topic. It returns a nil message to drop it, an
+// error makes the source broker redeliver the message.
+type Transform func(ctx context.Context, target string, msg *broker.Message) (string, *broker.Message, error)
+
+// Option is bridge option.
+type Option func(*options)
+
+// options is bridge options.
+type options struct {
+	routes     map[string]string
+	queue      string
+	transforms []Transform
+	dedup      cache.Store
+	dedupTTL   time.Duration
+	registry   prometheus.Registerer
+}
+
+// WithRoute returns an Option that bridges the source topic from to the
+// target topic to, the same name when to is empty.
+func WithRoute(from, to string) Option {
+	return func(o *options) {
+		if to == "" {
+			to = from
+		}
+		o.routes[from] = to
+	}
+}
+
+// WithQueue returns an Option that sets the queue, or consumer group, the
+// bridge subscribes with, so bridge instances share the messages.
+func WithQueue(queue string) Option {
+	return func(o *options) {
+		o.queue = queue
+	}
+}
+
+// WithTransform returns an Option that adds a transformation, applied in
+// the order added.
+func WithTransform(fn Transform) Option {
+	return func(o *options) {
+		o.transforms = append(o.transforms, fn)
+	}
+}
+
+// WithDedup returns an Option that records the ids of the republished
+// messages in store for ttl and drops redeliveries of them, e.g. with a
+// cache.Redis shared by the bridge instances.
+func WithDedup(store cache.Store, ttl time.Duration) Option {
+	return func(o *options) {
+		o.dedup = store
+		o.dedupTTL = ttl
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the bridge
+// metrics, prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// Status is the status of a bridge.
+type Status struct {
+	Name    string            `json:"name"`
+	Source  string            `json:"source"`
+	Target  string            `json:"target"`
+	Routes  map[string]string `json:"routes"`
+	Running bool              `json:"running"`
+}
+
+// Bridge subscribes to topics on a source broker and republishes the
+// messages to a target broker, e.g. during a migration between brokers. It
+// implements transport.Server, so an App starts and stops it alongside its
+// servers.
+type Bridge struct {
+	name    string
+	source  broker.Broker
+	target  broker.Broker
+	opts    options
+	metrics *bridgeMetrics
+
+	mu   sync.Mutex
+	subs []broker.Subscriber
+}
+
+// New creates a bridge named name from source to target.
+func New(name string, source, target broker.Broker, opts ...Option) *Bridge {
+	o := options{
+		routes:   make(map[string]string),
+		dedupTTL: 24 * time.Hour,
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Bridge{
+		name:    name,
+		source:  source,
+		target:  target,
+		opts:    o,
+		metrics: newBridgeMetrics(o.registry),
+	}
+}
+
+// Name returns the bridge name.
+func (b *Bridge) Name() string {
+	return b.name
+}
+
+// Init implements transport.Server.
+func (b *Bridge) Init(opts ...transport.ServerOption) error {
+	return nil
+}
+
+// Start subscribes to the source topics, it does nothing when the bridge is
+// already running.
+func (b *Bridge) Start(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs != nil {
+		return nil
+	}
+	if len(b.opts.routes) == 0 {
+		return fmt.Errorf("bridge %s: no route", b.name)
+	}
+
+	var subOpts []broker.SubscribeOption
+	if b.opts.queue != "" {
+		subOpts = append(subOpts, broker.Queue(b.opts.queue))
+	}
+	subs := make([]broker.Subscriber, 0, len(b.opts.routes))
+	for from, to := range b.opts.routes {
+		sub, err := b.source.Subscribe(from, b.handler(from, to), subOpts...)
+		if err != nil {
+			for _, s := range subs {
+				s.Unsubscribe()
+			}
+			return fmt.Errorf("bridge %s: failed to subscribe to %s: %w", b.name, from, err)
+		}
+		subs = append(subs, sub)
+	}
+	b.subs = subs
+	b.metrics.running.WithLabelValues(b.name).Set(1)
+	klog.Infof("bridge %s: started %s -> %s", b.name, b.source.String(), b.target.String())
+	return nil
+}
+
+// Stop unsubscribes from the source topics, it does nothing when the bridge
+// isn't running.
+func (b *Bridge) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs == nil {
+		return nil
+	}
+	var errs []error
+	for _, sub := range b.subs {
+		if err := sub.Unsubscribe(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unsubscribe from %s: %w", sub.Topic(), err))
+		}
+	}
+	b.subs = nil
+	b.metrics.running.WithLabelValues(b.name).Set(0)
+	klog.Infof("bridge %s: stopped", b.name)
+	return errors.Join(errs...)
+}
+
+// Status returns the bridge status.
+func (b *Bridge) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	routes := make(map[string]string, len(b.opts.routes))
+	for from, to := range b.opts.routes {
+		routes[from] = to
+	}
+	return Status{
+		Name:    b.name,
+		Source:  b.source.String(),
+		Target:  b.target.String(),
+		Routes:  routes,
+		Running: b.subs != nil,
+	}
+}
+
+// handler returns the handler republishing the messages of topic from.
+func (b *Bridge) handler(from, to string) broker.Handler {
+	return func(ctx context.Context, msg *broker.Message) error {
+		result, err := b.forward(ctx, from, to, msg)
+		b.metrics.messages.WithLabelValues(b.name, from, result).Inc()
+		if err != nil {
+			klog.CtxErrorf(ctx, "bridge %s: failed to forward message from %s: %v", b.name, from, err)
+		}
+		return err
+	}
+}
+
+// forward republishes a message and returns its result.
+func (b *Bridge) forward(ctx context.Context, from, to string, msg *broker.Message) (string, error) {
+	id := messageID(from, msg)
+	if b.opts.dedup != nil {
+		if _, err := b.opts.dedup.Get(ctx, b.dedupKey(id)); err == nil {
+			return resultDuplicate, nil
+		} else if !errors.Is(err, cache.ErrNotFound) {
+			klog.CtxWarnf(ctx, "bridge %s: failed to check message %s: %v", b.name, id, err)
+		}
+	}
+
+	target, out := to, msg
+	for _, transform := range b.opts.transforms {
+		var err error
+		if target, out, err = transform(ctx, target, out); err != nil {
+			return resultFailed, fmt.Errorf("failed to transform message: %w", err)
+		}
+		if out == nil {
+			return resultFiltered, nil
+		}
+	}
+
+	header := make(map[string]string, len(out.Header)+2)
+	for k, v := range out.Header {
+		header[k] = v
+	}
+	header[HeaderMessageID] = id
+	header[HeaderSourceTopic] = from
+	if err := b.target.Publish(ctx, target, &broker.Message{Header: header, Body: out.Body}); err != nil {
+		return resultFailed, fmt.Errorf("failed to publish to %s: %w", target, err)
+	}
+
+	if b.opts.dedup != nil {
+		if err := b.opts.dedup.Set(ctx, b.dedupKey(id), []byte{1}, b.opts.dedupTTL); err != nil {
+			klog.CtxWarnf(ctx, "bridge %s: failed to record message %s: %v", b.name, id, err)
+		}
+	}
+	if ms, err := strconv.ParseInt(msg.Header[HeaderTimestamp], 10, 64); err == nil {
+		b.metrics.lag.WithLabelValues(b.name, from).Observe(time.Since(time.UnixMilli(ms)).Seconds())
+	}
+	return resultForwarded, nil
+}
+
+// dedupKey returns the replay protection key of a message id.
+func (b *Bridge) dedupKey(id string) string {
+	return "bridge:" + b.name + ":" + id
+}
+
+// messageID returns the id header of msg, or the hash of its topic and body.
+func messageID(topic string, msg *broker.Message) string {
+	if id := msg.Header[HeaderMessageID]; id != "" {
+		return id
+	}
+	h := sha256.New()
+	h.Write([]byte(topic))
+	h.Write([]byte{0})
+	h.Write(msg.Body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// Handler returns a Hertz admin handler for bridges. GET returns the Status
+// of every bridge, POST starts the bridge named by the name query parameter,
+// e.g. POST /admin/bridges?name=orders, and DELETE stops it. Mount it
+// behind authentication.
+func Handler(bridges ...*Bridge) app.HandlerFunc {
+	byName := make(map[string]*Bridge, len(bridges))
+	for _, b := range bridges {
+		byName[b.Name()] = b
+	}
+	return func(ctx context.Context, c *app.RequestContext) {
+		method := string(c.Method())
+		if method != http.MethodGet {
+			b, ok := byName[c.Query("name")]
+			if !ok {
+				c.String(http.StatusNotFound, "bridge not found")
+				return
+			}
+
+			var err error
+			switch method {
+			case http.MethodPost, http.MethodPut:
+				err = b.Start(ctx)
+			case http.MethodDelete:
+				err = b.Stop(ctx)
+			default:
+				c.String(http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			if err != nil {
+				c.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		statuses := make([]Status, 0, len(bridges))
+		for _, b := range bridges {
+			statuses = append(statuses, b.Status())
+		}
+		c.JSON(http.StatusOK, statuses)
+	}
+}
@@ -2,6 +2,7 @@ package broker
 
 import (
 	"context"
+	"time"
 )
 
 // Broker is an interface used for asynchronous messaging.
@@ -68,6 +69,8 @@ type PublishOption func(*PublishOptions)
 // PublishOptions is publish options.
 type PublishOptions struct {
 	Context context.Context
+	// Delay delays the delivery of the message, see WithDelay.
+	Delay time.Duration
 }
 
 // SubscribeOption is subscribe option.
@@ -82,6 +85,13 @@ type SubscribeOptions struct {
 	Queue string
 	// Context is the context for the subscription.
 	Context context.Context
+	// DecodeErrorHandler handles the messages the typed subscriptions
+	// fail to decode.
+	DecodeErrorHandler DecodeErrorHandler
+	// Concurrency is the number of workers handling the messages.
+	Concurrency int
+	// OrderingKey keys the messages handled in order.
+	OrderingKey func(*Message) string
 }
 
 // Addrs sets the broker addresses.
@@ -147,3 +157,16 @@ func PublishContext(ctx context.Context) PublishOption {
 		o.Context = ctx
 	}
 }
+
+// WithDelay delays the delivery of the message by d. The brokers implement
+// it natively when they can, see their packages for the precision.
+func WithDelay(d time.Duration) PublishOption {
+	return func(o *PublishOptions) {
+		o.Delay = d
+	}
+}
+
+// WithDeliverAt delays the delivery of the message until t.
+func WithDeliverAt(t time.Time) PublishOption {
+	return WithDelay(time.Until(t))
+}
@@ -0,0 +1,45 @@
+package broker
+
+import (
+	"fmt"
+
+	"new-milli/codec"
+)
+
+// HeaderContentType is the message header holding the content type of the body.
+const HeaderContentType = "Content-Type"
+
+// NewMessage returns a message with v as body, encoded with the codec
+// registered for contentType, JSON when it is empty. The content type is
+// recorded in the header so consumers can decode the message with Decode.
+func NewMessage(v interface{}, contentType string) (*Message, error) {
+	c := codec.JSON
+	if contentType != "" {
+		if c = codec.ForContentType(contentType); c == nil {
+			return nil, fmt.Errorf("broker: unsupported content type %q", contentType)
+		}
+	}
+	body, err := c.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return &Message{
+		Header: map[string]string{HeaderContentType: c.ContentType()},
+		Body:   body,
+	}, nil
+}
+
+// Decode decodes the body of msg into v with the codec registered for its
+// content type header, JSON when it has none.
+func Decode(msg *Message, v interface{}) error {
+	c := codec.JSON
+	if ct := msg.Header[HeaderContentType]; ct != "" {
+		if c = codec.ForContentType(ct); c == nil {
+			return fmt.Errorf("broker: unsupported content type %q", ct)
+		}
+	}
+	if err := c.Unmarshal(msg.Body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// WithConcurrency handles the messages of the subscription on n workers,
+// n messages being in flight at most. The order of the messages is lost
+// unless WithOrderingKey is set too.
+func WithConcurrency(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Concurrency = n
+	}
+}
+
+// WithOrderingKey handles the messages with the same key in order, on the
+// same worker, when the subscription has several workers.
+func WithOrderingKey(fn func(*Message) string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.OrderingKey = fn
+	}
+}
+
+// Dispatcher runs the handling of the messages of a subscription on the
+// workers configured by WithConcurrency and WithOrderingKey. The brokers
+// use it in their subscribers.
+type Dispatcher struct {
+	mu     sync.RWMutex
+	closed bool
+	queues []chan func()
+	key    func(*Message) string
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher for the subscribe options, starting its
+// workers. Without concurrency the messages are handled inline.
+func NewDispatcher(options SubscribeOptions) *Dispatcher {
+	d := &Dispatcher{}
+	n := options.Concurrency
+	if n <= 1 {
+		return d
+	}
+
+	// The workers share a queue unless the messages are ordered by key
+	queues := 1
+	if options.OrderingKey != nil {
+		queues = n
+		d.key = options.OrderingKey
+	}
+	d.queues = make([]chan func(), queues)
+	for i := range d.queues {
+		d.queues[i] = make(chan func())
+	}
+	for i := 0; i < n; i++ {
+		d.wg.Add(1)
+		go d.work(d.queues[i%queues])
+	}
+	return d
+}
+
+// Dispatch runs handle for msg on a worker, blocking while all of them are
+// busy. It runs handle inline without workers or once closed.
+func (d *Dispatcher) Dispatch(msg *Message, handle func()) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed || len(d.queues) == 0 {
+		handle()
+		return
+	}
+
+	queue := d.queues[0]
+	if d.key != nil {
+		h := fnv.New32a()
+		h.Write([]byte(d.key(msg)))
+		queue = d.queues[h.Sum32()%uint32(len(d.queues))]
+	}
+	queue <- handle
+}
+
+// Close stops the workers once they handled the dispatched messages.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+// work runs the handlings of a queue.
+func (d *Dispatcher) work(queue chan func()) {
+	defer d.wg.Done()
+	for handle := range queue {
+		handle()
+	}
+}
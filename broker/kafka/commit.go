@@ -0,0 +1,150 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/segmentio/kafka-go"
+	"new-milli/broker"
+)
+
+// commitTimeout bounds a commit of the subscribers.
+const commitTimeout = 5 * time.Second
+
+// offsets tracks the messages of the partitions being handled, possibly out
+// of order, so a partition is only committed up to the messages handled
+// along with all the previous ones.
+type offsets struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+// partitionOffsets tracks the messages of a partition.
+type partitionOffsets struct {
+	pending []*pendingMessage // in fetch order
+	last    int64             // the last offset fetched
+	commit  *kafka.Message    // the last message to commit, if any
+}
+
+// pendingMessage is a message being handled.
+type pendingMessage struct {
+	msg  kafka.Message
+	done bool
+}
+
+// newOffsets creates a new offset tracker.
+func newOffsets() *offsets {
+	return &offsets{
+		partitions: make(map[int]*partitionOffsets),
+	}
+}
+
+// track tracks a fetched message, the returned function marks it handled.
+func (o *offsets) track(kmsg kafka.Message) func() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// A rebalance restarts a partition at its committed offset, the
+	// messages handled since then are fetched again
+	p := o.partitions[kmsg.Partition]
+	if p == nil || kmsg.Offset <= p.last {
+		p = &partitionOffsets{}
+		o.partitions[kmsg.Partition] = p
+	}
+	p.last = kmsg.Offset
+
+	m := &pendingMessage{msg: kmsg}
+	p.pending = append(p.pending, m)
+
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		// The partition restarted since the message was fetched
+		if o.partitions[kmsg.Partition] != p {
+			return
+		}
+		m.done = true
+		for len(p.pending) > 0 && p.pending[0].done {
+			p.commit = &p.pending[0].msg
+			p.pending = p.pending[1:]
+		}
+	}
+}
+
+// take returns the messages to commit, the last handled one of each
+// partition, and forgets them.
+func (o *offsets) take() []kafka.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var msgs []kafka.Message
+	for _, p := range o.partitions {
+		if p.commit != nil {
+			msgs = append(msgs, *p.commit)
+			p.commit = nil
+		}
+	}
+	return msgs
+}
+
+// commitLoop commits the handled messages every interval until stop is
+// closed, and a last time then.
+func (s *subscriber) commitLoop(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			s.commit()
+			return
+		case <-ticker.C:
+			s.commit()
+		}
+	}
+}
+
+// commit commits the handled messages. The messages of a failed commit,
+// e.g. of partitions revoked by a rebalance, are dropped, the next commit
+// of their partition covering them.
+func (s *subscriber) commit() {
+	msgs := s.offsets.take()
+	if len(msgs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+	if err := s.reader.CommitMessages(ctx, msgs...); err != nil {
+		klog.Warnf("kafka: failed to commit offsets of %s: %v", s.topic, err)
+	}
+}
+
+// handle handles a message until the handler succeeds, backing off between
+// the attempts. It returns false when the subscriber stops first, the
+// message isn't committed then and is fetched again later.
+func (s *subscriber) handle(msg *broker.Message) bool {
+	backoff := s.retry.minBackoff
+	for {
+		err := s.handler(s.options.Context, msg)
+		if err == nil {
+			return true
+		}
+		klog.CtxWarnf(s.options.Context, "kafka: failed to handle message of %s, retrying in %s: %v", s.topic, backoff, err)
+
+		select {
+		case <-s.ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.retry.maxBackoff {
+			backoff = s.retry.maxBackoff
+		}
+	}
+}
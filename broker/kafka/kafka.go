@@ -2,18 +2,74 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"new-milli/broker"
+	"new-milli/config"
 )
 
 var (
 	_ broker.Broker = (*Broker)(nil)
 )
 
+func init() {
+	broker.RegisterFactory("kafka", newFromConfig)
+}
+
+// newFromConfig creates a broker from the config under key, see
+// broker.FromConfig. The delayed messages are configured by the delay_topic
+// and scheduler fields, see WithDelayTopic and WithScheduler, and the
+// subscribers without auto ack by commit_interval, retry_min_backoff and
+// retry_max_backoff, see WithCommitInterval and WithRetryBackoff.
+func newFromConfig(cfg config.Config, key string, opts ...broker.Option) (broker.Broker, error) {
+	var options []broker.Option
+	if cfg.Has(key + ".commit_interval") {
+		interval, err := config.GetDuration(cfg, key+".commit_interval")
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithCommitInterval(interval))
+	}
+	if cfg.Has(key+".retry_min_backoff") || cfg.Has(key+".retry_max_backoff") {
+		ro := retryFromContext(nil)
+		var err error
+		if cfg.Has(key + ".retry_min_backoff") {
+			if ro.minBackoff, err = config.GetDuration(cfg, key+".retry_min_backoff"); err != nil {
+				return nil, err
+			}
+		}
+		if cfg.Has(key + ".retry_max_backoff") {
+			if ro.maxBackoff, err = config.GetDuration(cfg, key+".retry_max_backoff"); err != nil {
+				return nil, err
+			}
+		}
+		options = append(options, WithRetryBackoff(ro.minBackoff, ro.maxBackoff))
+	}
+	if cfg.Has(key + ".delay_topic") {
+		topic, err := cfg.GetString(key + ".delay_topic")
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithDelayTopic(topic))
+	}
+	if cfg.Has(key + ".scheduler") {
+		enabled, err := cfg.GetBool(key + ".scheduler")
+		if err != nil {
+			return nil, err
+		}
+		if enabled {
+			options = append(options, WithScheduler())
+		}
+	}
+	return New(append(options, opts...)...), nil
+}
+
 // Broker is a Kafka broker.
 type Broker struct {
 	sync.RWMutex
@@ -22,6 +78,8 @@ type Broker struct {
 	options   broker.Options
 	writers   map[string]*kafka.Writer
 	readers   map[string]*kafka.Reader
+	schedMu   sync.Mutex
+	scheduler *scheduler
 }
 
 // New creates a new Kafka broker.
@@ -69,12 +127,19 @@ func (b *Broker) Connect() error {
 		return nil
 	}
 
+	if schedulerFromContext(b.options.Context) {
+		b.startScheduler()
+	}
+
 	b.connected = true
 	return nil
 }
 
 // Disconnect disconnects from the broker.
 func (b *Broker) Disconnect() error {
+	// The scheduler uses the writers
+	b.stopScheduler()
+
 	b.Lock()
 	defer b.Unlock()
 
@@ -105,12 +170,6 @@ func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message,
 		o(&options)
 	}
 
-	// Get or create the writer
-	writer, err := b.getWriter(topic)
-	if err != nil {
-		return err
-	}
-
 	// Create the message
 	kmsg := kafka.Message{
 		Key:   []byte(topic),
@@ -125,6 +184,17 @@ func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message,
 		})
 	}
 
+	// Delayed messages wait in the delay topic
+	if options.Delay > 0 {
+		topic = b.delay(&kmsg, topic, options.Delay)
+	}
+
+	// Get or create the writer
+	writer, err := b.getWriter(topic)
+	if err != nil {
+		return err
+	}
+
 	// Write the message
 	return writer.WriteMessages(options.Context, kmsg)
 }
@@ -147,12 +217,22 @@ func (b *Broker) Subscribe(topic string, handler broker.Handler, opts ...broker.
 	}
 
 	// Create the subscriber
+	ctx, cancel := context.WithCancel(options.Context)
 	sub := &subscriber{
-		topic:   topic,
-		handler: handler,
-		reader:  reader,
-		options: options,
-		done:    make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		topic:      topic,
+		handler:    handler,
+		reader:     reader,
+		options:    options,
+		dispatcher: broker.NewDispatcher(options),
+		interval:   commitIntervalFromContext(b.options.Context),
+		retry:      retryFromContext(b.options.Context),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if !options.AutoAck {
+		sub.offsets = newOffsets()
 	}
 
 	// Start the subscriber
@@ -219,11 +299,18 @@ func (b *Broker) getReader(topic, group string) (*kafka.Reader, error) {
 
 // subscriber is a Kafka subscriber.
 type subscriber struct {
-	topic   string
-	handler broker.Handler
-	reader  *kafka.Reader
-	options broker.SubscribeOptions
-	done    chan struct{}
+	topic      string
+	handler    broker.Handler
+	reader     *kafka.Reader
+	options    broker.SubscribeOptions
+	dispatcher *broker.Dispatcher
+	ctx        context.Context // cancelled when the subscriber stops
+	cancel     context.CancelFunc
+	offsets    *offsets
+	interval   time.Duration
+	retry      retryOptions
+	done       chan struct{}
+	stopped    chan struct{}
 }
 
 // Topic returns the topic of the subscriber.
@@ -231,22 +318,53 @@ func (s *subscriber) Topic() string {
 	return s.topic
 }
 
-// Unsubscribe unsubscribes from the topic.
+// Unsubscribe unsubscribes from the topic. It waits for the messages being
+// handled and commits them.
 func (s *subscriber) Unsubscribe() error {
 	close(s.done)
+	s.cancel()
+	<-s.stopped
 	return s.reader.Close()
 }
 
-// run runs the subscriber.
+// run runs the subscriber. Without auto ack the messages are fetched, and
+// committed once handled, see offsets.
 func (s *subscriber) run() {
+	defer close(s.stopped)
+
+	if s.offsets != nil {
+		stop, done := make(chan struct{}), make(chan struct{})
+		go s.commitLoop(s.interval, stop, done)
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	// Wait for the messages being handled
+	defer s.dispatcher.Close()
+
 	for {
 		select {
 		case <-s.done:
 			return
 		default:
-			// Read the message
-			kmsg, err := s.reader.ReadMessage(s.options.Context)
+			// Read the message, committing it with auto ack
+			var (
+				kmsg kafka.Message
+				err  error
+			)
+			if s.offsets == nil {
+				kmsg, err = s.reader.ReadMessage(s.ctx)
+			} else {
+				kmsg, err = s.reader.FetchMessage(s.ctx)
+			}
 			if err != nil {
+				// Unsubscribed, or the reader is closed by Disconnect
+				if s.ctx.Err() != nil || errors.Is(err, io.EOF) {
+					s.cancel()
+					return
+				}
 				continue
 			}
 
@@ -262,16 +380,18 @@ func (s *subscriber) run() {
 			}
 
 			// Handle the message
-			err = s.handler(s.options.Context, msg)
-			if err != nil {
-				// TODO: Handle error
+			if s.offsets == nil {
+				s.dispatcher.Dispatch(msg, func() {
+					s.handler(s.options.Context, msg)
+				})
 				continue
 			}
-
-			// Auto ack
-			if s.options.AutoAck {
-				// TODO: Implement ack
-			}
+			handled := s.offsets.track(kmsg)
+			s.dispatcher.Dispatch(msg, func() {
+				if s.handle(msg) {
+					handled()
+				}
+			})
 		}
 	}
 }
@@ -0,0 +1,107 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"new-milli/broker"
+)
+
+// DefaultDelayTopic is the default topic of the delayed messages.
+const DefaultDelayTopic = "new-milli-delay"
+
+type (
+	delayTopicKey     struct{}
+	schedulerKey      struct{}
+	commitIntervalKey struct{}
+	retryKey          struct{}
+	retryOptions      struct {
+		minBackoff time.Duration
+		maxBackoff time.Duration
+	}
+)
+
+// WithDelayTopic sets the topic the delayed messages wait in until the
+// scheduler forwards them, DefaultDelayTopic by default.
+func WithDelayTopic(topic string) broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, delayTopicKey{}, topic)
+	}
+}
+
+// WithScheduler starts the scheduler forwarding the delayed messages when
+// the broker connects. Otherwise it starts with the first delayed message
+// published, so only the publishing instances run it.
+func WithScheduler() broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, schedulerKey{}, true)
+	}
+}
+
+// WithCommitInterval sets the interval the subscribers without auto ack
+// commit the handled messages at, one second by default. A shorter interval
+// fetches fewer messages again after a restart or a rebalance.
+func WithCommitInterval(interval time.Duration) broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, commitIntervalKey{}, interval)
+	}
+}
+
+// WithRetryBackoff sets the initial and maximum backoff between the attempts
+// of the subscribers without auto ack to handle a message.
+func WithRetryBackoff(min, max time.Duration) broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, retryKey{}, retryOptions{minBackoff: min, maxBackoff: max})
+	}
+}
+
+// setOption stores a Kafka specific option in the broker options context.
+func setOption(o *broker.Options, key, value interface{}) {
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+	o.Context = context.WithValue(o.Context, key, value)
+}
+
+// delayTopicFromContext returns the delay topic stored in ctx or the default.
+func delayTopicFromContext(ctx context.Context) string {
+	if ctx != nil {
+		if topic, ok := ctx.Value(delayTopicKey{}).(string); ok && topic != "" {
+			return topic
+		}
+	}
+	return DefaultDelayTopic
+}
+
+// schedulerFromContext reports whether WithScheduler is set in ctx.
+func schedulerFromContext(ctx context.Context) bool {
+	if ctx != nil {
+		enabled, _ := ctx.Value(schedulerKey{}).(bool)
+		return enabled
+	}
+	return false
+}
+
+// commitIntervalFromContext returns the commit interval stored in ctx or the
+// default.
+func commitIntervalFromContext(ctx context.Context) time.Duration {
+	if ctx != nil {
+		if interval, ok := ctx.Value(commitIntervalKey{}).(time.Duration); ok && interval > 0 {
+			return interval
+		}
+	}
+	return time.Second
+}
+
+// retryFromContext returns the retry options stored in ctx or the defaults.
+func retryFromContext(ctx context.Context) retryOptions {
+	if ctx != nil {
+		if ro, ok := ctx.Value(retryKey{}).(retryOptions); ok {
+			return ro
+		}
+	}
+	return retryOptions{
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 10 * time.Second,
+	}
+}
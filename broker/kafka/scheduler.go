@@ -0,0 +1,171 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers of the messages waiting in the delay topic.
+const (
+	HeaderDeliverAt   = "X-Deliver-At"
+	HeaderTargetTopic = "X-Target-Topic"
+)
+
+// schedulerGroup is the consumer group of the schedulers.
+const schedulerGroup = "new-milli-scheduler"
+
+// scheduler forwards the delayed messages to their topic when they are due.
+type scheduler struct {
+	reader *kafka.Reader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// delay rewrites kmsg, published to topic, to wait in the delay topic for
+// delay. It returns the delay topic.
+func (b *Broker) delay(kmsg *kafka.Message, topic string, delay time.Duration) string {
+	at := time.Now().Add(delay).UnixMilli()
+	kmsg.Headers = append(kmsg.Headers,
+		kafka.Header{Key: HeaderDeliverAt, Value: []byte(strconv.FormatInt(at, 10))},
+		kafka.Header{Key: HeaderTargetTopic, Value: []byte(topic)},
+	)
+	b.startScheduler()
+	return delayTopicFromContext(b.options.Context)
+}
+
+// startScheduler starts the scheduler if it isn't running.
+func (b *Broker) startScheduler() {
+	b.schedMu.Lock()
+	defer b.schedMu.Unlock()
+
+	if b.scheduler != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &scheduler{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: b.addrs,
+			Topic:   delayTopicFromContext(b.options.Context),
+			GroupID: schedulerGroup,
+		}),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	b.scheduler = s
+	go b.schedule(ctx, s)
+}
+
+// stopScheduler stops the scheduler if it is running.
+func (b *Broker) stopScheduler() {
+	b.schedMu.Lock()
+	s := b.scheduler
+	b.scheduler = nil
+	b.schedMu.Unlock()
+
+	if s == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.reader.Close()
+}
+
+// schedule forwards the messages of the delay topic in order, waiting until
+// each one is due. A message is committed once forwarded, so the messages
+// of a stopped scheduler are forwarded by the next one.
+func (b *Broker) schedule(ctx context.Context, s *scheduler) {
+	defer close(s.done)
+
+	for {
+		kmsg, err := s.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			klog.Warnf("kafka scheduler: failed to fetch delayed message: %v", err)
+			if !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+
+		out, topic, at := undelay(kmsg)
+		if topic == "" {
+			klog.Errorf("kafka scheduler: dropping message without %s header at offset %d", HeaderTargetTopic, kmsg.Offset)
+		} else {
+			if !sleep(ctx, time.Until(at)) {
+				return
+			}
+			if !b.forward(ctx, topic, out) {
+				return
+			}
+		}
+
+		if err := s.reader.CommitMessages(ctx, kmsg); err != nil && ctx.Err() == nil {
+			klog.Warnf("kafka scheduler: failed to commit delayed message: %v", err)
+		}
+	}
+}
+
+// forward writes a due message to its topic, retrying until it succeeds.
+// It returns false when ctx is done first.
+func (b *Broker) forward(ctx context.Context, topic string, kmsg kafka.Message) bool {
+	for {
+		writer, err := b.getWriter(topic)
+		if err == nil {
+			if err = writer.WriteMessages(ctx, kmsg); err == nil {
+				return true
+			}
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		klog.Warnf("kafka scheduler: failed to forward delayed message to %s: %v", topic, err)
+		if !sleep(ctx, time.Second) {
+			return false
+		}
+	}
+}
+
+// undelay returns the message to forward, without the delay headers, its
+// topic and its delivery time.
+func undelay(kmsg kafka.Message) (kafka.Message, string, time.Time) {
+	out := kafka.Message{Key: kmsg.Key, Value: kmsg.Value}
+	var (
+		topic string
+		at    time.Time
+	)
+	for _, h := range kmsg.Headers {
+		switch h.Key {
+		case HeaderTargetTopic:
+			topic = string(h.Value)
+		case HeaderDeliverAt:
+			if ms, err := strconv.ParseInt(string(h.Value), 10, 64); err == nil {
+				at = time.UnixMilli(ms)
+			}
+		default:
+			out.Headers = append(out.Headers, h)
+		}
+	}
+	return out, topic, at
+}
+
+// sleep waits for d, it returns false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
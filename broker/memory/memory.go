@@ -0,0 +1,437 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"new-milli/broker"
+)
+
+var (
+	_ broker.Broker = (*Broker)(nil)
+)
+
+// Broker is an in-process broker for tests. A topic delivers a copy of each
+// message to each queue subscribed to it, and a queue delivers its messages
+// in order, one at a time, to its subscribers in turn. The messages wait in
+// a queue while it has no subscriber. With auto ack a message is delivered
+// once, without it a message nacked by its handler is delivered again
+// first, up to WithMaxDeliveries times.
+type Broker struct {
+	mu            sync.Mutex
+	options       broker.Options
+	manual        bool
+	maxDeliveries int
+	connected     bool
+	now           time.Time
+	topics        map[string]map[string]*queue
+	published     map[string][]*broker.Message
+	undeliverable map[string][]*broker.Message
+	inflight      int
+	idle          chan struct{} // closed when the broker becomes idle
+}
+
+// queue is a queue subscribed to a topic.
+type queue struct {
+	topic   string
+	name    string
+	subs    []*subscriber
+	next    int
+	ready   []*envelope
+	delayed []*envelope
+	running bool
+}
+
+// envelope is a message waiting in a queue.
+type envelope struct {
+	msg        *broker.Message
+	due        time.Time
+	deliveries int
+}
+
+// New creates a new in-memory broker. The messages are delivered in the
+// background, unless WithManualDelivery is set.
+func New(opts ...broker.Option) *Broker {
+	options := broker.Options{
+		Context: context.Background(),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	return &Broker{
+		options:       options,
+		manual:        manualFromContext(options.Context),
+		maxDeliveries: maxDeliveriesFromContext(options.Context),
+		now:           time.Unix(0, 0),
+		topics:        make(map[string]map[string]*queue),
+		published:     make(map[string][]*broker.Message),
+		undeliverable: make(map[string][]*broker.Message),
+	}
+}
+
+// Init initializes the broker.
+func (b *Broker) Init(opts ...broker.Option) error {
+	for _, o := range opts {
+		o(&b.options)
+	}
+	return nil
+}
+
+// Options returns the broker options.
+func (b *Broker) Options() broker.Options {
+	return b.options
+}
+
+// Address returns the broker address.
+func (b *Broker) Address() string {
+	return "memory"
+}
+
+// Connect connects to the broker.
+func (b *Broker) Connect() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.connected = true
+	for _, q := range b.queues() {
+		b.kick(q)
+	}
+	b.updateIdle()
+	return nil
+}
+
+// Disconnect disconnects from the broker, closing the subscribers. The
+// messages stay in their queues.
+func (b *Broker) Disconnect() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.connected = false
+	for _, q := range b.queues() {
+		q.subs = nil
+	}
+	b.updateIdle()
+	return nil
+}
+
+// Publish publishes a message to a topic.
+func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message, opts ...broker.PublishOption) error {
+	options := broker.PublishOptions{
+		Context: ctx,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.connected {
+		return errors.New("not connected")
+	}
+
+	b.published[topic] = append(b.published[topic], copyMessage(msg))
+	for _, q := range b.topics[topic] {
+		e := &envelope{msg: copyMessage(msg)}
+		switch {
+		case options.Delay <= 0:
+			q.ready = append(q.ready, e)
+			b.kick(q)
+		case b.manual:
+			e.due = b.now.Add(options.Delay)
+			q.delayed = append(q.delayed, e)
+		default:
+			time.AfterFunc(options.Delay, func() {
+				b.mu.Lock()
+				defer b.mu.Unlock()
+				q.ready = append(q.ready, e)
+				b.kick(q)
+				b.updateIdle()
+			})
+		}
+	}
+	b.updateIdle()
+	return nil
+}
+
+// Subscribe subscribes to a topic.
+func (b *Broker) Subscribe(topic string, handler broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	options := broker.SubscribeOptions{
+		AutoAck: true,
+		Queue:   "default",
+		Context: context.Background(),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.connected {
+		return nil, errors.New("not connected")
+	}
+
+	// Get or create the queue
+	queues, ok := b.topics[topic]
+	if !ok {
+		queues = make(map[string]*queue)
+		b.topics[topic] = queues
+	}
+	q, ok := queues[options.Queue]
+	if !ok {
+		q = &queue{topic: topic, name: options.Queue}
+		queues[options.Queue] = q
+	}
+
+	sub := &subscriber{
+		broker:  b,
+		queue:   q,
+		handler: handler,
+		options: options,
+	}
+	q.subs = append(q.subs, sub)
+	b.kick(q)
+	b.updateIdle()
+	return sub, nil
+}
+
+// String returns the name of the broker.
+func (b *Broker) String() string {
+	return "memory"
+}
+
+// Flush delivers the messages waiting in the queues with subscribers,
+// including those published by the handlers meanwhile, and returns once
+// none is left or being handled. The delayed messages aren't waited for.
+func (b *Broker) Flush(ctx context.Context) error {
+	if b.manual {
+		for b.Step() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+		idle := b.idle
+		b.mu.Unlock()
+		if idle == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle:
+		}
+	}
+}
+
+// Step delivers the next message in the calling goroutine, the queues
+// taken in topic and queue name order, and reports whether there was one.
+// It is meant for manual delivery.
+func (b *Broker) Step() bool {
+	b.mu.Lock()
+	var (
+		sub *subscriber
+		e   *envelope
+	)
+	for _, q := range b.queues() {
+		if sub, e = b.take(q); sub != nil {
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	if sub == nil {
+		return false
+	}
+	b.deliver(sub, e)
+	return true
+}
+
+// Advance moves the virtual clock of manual delivery by d, readying the
+// delayed messages that became due in order.
+func (b *Broker) Advance(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.now = b.now.Add(d)
+	for _, q := range b.queues() {
+		sort.SliceStable(q.delayed, func(i, j int) bool {
+			return q.delayed[i].due.Before(q.delayed[j].due)
+		})
+		n := 0
+		for n < len(q.delayed) && !q.delayed[n].due.After(b.now) {
+			n++
+		}
+		q.ready = append(q.ready, q.delayed[:n]...)
+		q.delayed = q.delayed[n:]
+	}
+	b.updateIdle()
+}
+
+// Published returns the messages published to a topic, in order.
+func (b *Broker) Published(topic string) []*broker.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*broker.Message(nil), b.published[topic]...)
+}
+
+// Undeliverable returns the messages of a topic given up after
+// WithMaxDeliveries deliveries.
+func (b *Broker) Undeliverable(topic string) []*broker.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*broker.Message(nil), b.undeliverable[topic]...)
+}
+
+// queues returns the queues in topic and queue name order.
+// It must be called with the lock held.
+func (b *Broker) queues() []*queue {
+	var queues []*queue
+	for _, topic := range b.topics {
+		for _, q := range topic {
+			queues = append(queues, q)
+		}
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		if queues[i].topic != queues[j].topic {
+			return queues[i].topic < queues[j].topic
+		}
+		return queues[i].name < queues[j].name
+	})
+	return queues
+}
+
+// kick starts delivering the messages of a queue in the background.
+// It must be called with the lock held.
+func (b *Broker) kick(q *queue) {
+	if b.manual || q.running || len(q.ready) == 0 || len(q.subs) == 0 || !b.connected {
+		return
+	}
+	q.running = true
+	go b.run(q)
+}
+
+// run delivers the messages of a queue until none is deliverable.
+func (b *Broker) run(q *queue) {
+	for {
+		b.mu.Lock()
+		sub, e := b.take(q)
+		if sub == nil {
+			q.running = false
+			b.updateIdle()
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		b.deliver(sub, e)
+	}
+}
+
+// take takes the next message of a queue and the subscriber to deliver it
+// to, if any. It must be called with the lock held.
+func (b *Broker) take(q *queue) (*subscriber, *envelope) {
+	if !b.connected || len(q.ready) == 0 || len(q.subs) == 0 {
+		return nil, nil
+	}
+	e := q.ready[0]
+	q.ready = q.ready[1:]
+	sub := q.subs[q.next%len(q.subs)]
+	q.next++
+	e.deliveries++
+	b.inflight++
+	return sub, e
+}
+
+// deliver delivers a message to a subscriber, requeuing it first when
+// nacked.
+func (b *Broker) deliver(sub *subscriber, e *envelope) {
+	err := sub.handler(sub.options.Context, copyMessage(e.msg))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inflight--
+	if err != nil && !sub.options.AutoAck {
+		q := sub.queue
+		if e.deliveries >= b.maxDeliveries {
+			b.undeliverable[q.topic] = append(b.undeliverable[q.topic], e.msg)
+		} else {
+			q.ready = append([]*envelope{e}, q.ready...)
+		}
+	}
+	b.updateIdle()
+}
+
+// updateIdle opens or closes the idle channel after a change.
+// It must be called with the lock held.
+func (b *Broker) updateIdle() {
+	busy := b.inflight > 0
+	if b.connected {
+		for _, topic := range b.topics {
+			for _, q := range topic {
+				busy = busy || (len(q.ready) > 0 && len(q.subs) > 0)
+			}
+		}
+	}
+
+	switch {
+	case busy && b.idle == nil:
+		b.idle = make(chan struct{})
+	case !busy && b.idle != nil:
+		close(b.idle)
+		b.idle = nil
+	}
+}
+
+// copyMessage returns a deep copy of msg.
+func copyMessage(msg *broker.Message) *broker.Message {
+	out := &broker.Message{
+		Header: make(map[string]string, len(msg.Header)),
+		Body:   append([]byte(nil), msg.Body...),
+	}
+	for k, v := range msg.Header {
+		out.Header[k] = v
+	}
+	return out
+}
+
+// subscriber is an in-memory subscriber.
+type subscriber struct {
+	broker  *Broker
+	queue   *queue
+	handler broker.Handler
+	options broker.SubscribeOptions
+}
+
+// Topic returns the topic of the subscriber.
+func (s *subscriber) Topic() string {
+	return s.queue.topic
+}
+
+// Unsubscribe unsubscribes from the topic. The messages stay in the queue.
+func (s *subscriber) Unsubscribe() error {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := s.queue.subs[:0]
+	for _, sub := range s.queue.subs {
+		if sub != s {
+			subs = append(subs, sub)
+		}
+	}
+	s.queue.subs = subs
+	b.updateIdle()
+	return nil
+}
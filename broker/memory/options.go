@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"context"
+
+	"new-milli/broker"
+	"new-milli/config"
+)
+
+func init() {
+	broker.RegisterFactory("memory", newFromConfig)
+}
+
+// newFromConfig creates a broker from the config under key, see
+// broker.FromConfig. The manual field enables WithManualDelivery and
+// max_deliveries sets WithMaxDeliveries.
+func newFromConfig(cfg config.Config, key string, opts ...broker.Option) (broker.Broker, error) {
+	var options []broker.Option
+	if cfg.Has(key + ".manual") {
+		manual, err := cfg.GetBool(key + ".manual")
+		if err != nil {
+			return nil, err
+		}
+		if manual {
+			options = append(options, WithManualDelivery())
+		}
+	}
+	if cfg.Has(key + ".max_deliveries") {
+		n, err := cfg.GetInt(key + ".max_deliveries")
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithMaxDeliveries(n))
+	}
+	return New(append(options, opts...)...), nil
+}
+
+type (
+	manualKey        struct{}
+	maxDeliveriesKey struct{}
+)
+
+// defaultMaxDeliveries is the default number of deliveries of a message.
+const defaultMaxDeliveries = 10
+
+// WithManualDelivery delivers the messages only when the test calls Flush
+// or Step, in the calling goroutine, and delays the messages on a virtual
+// clock moved by Advance.
+func WithManualDelivery() broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, manualKey{}, true)
+	}
+}
+
+// WithMaxDeliveries sets the number of deliveries of a message nacked by
+// its handlers before it is given up, 10 by default. The messages given up
+// are returned by Undeliverable.
+func WithMaxDeliveries(n int) broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, maxDeliveriesKey{}, n)
+	}
+}
+
+// setOption stores an in-memory broker option in the broker options context.
+func setOption(o *broker.Options, key, value interface{}) {
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+	o.Context = context.WithValue(o.Context, key, value)
+}
+
+// manualFromContext reports whether WithManualDelivery is set in ctx.
+func manualFromContext(ctx context.Context) bool {
+	if ctx != nil {
+		manual, _ := ctx.Value(manualKey{}).(bool)
+		return manual
+	}
+	return false
+}
+
+// maxDeliveriesFromContext returns the maximum deliveries stored in ctx or
+// the default.
+func maxDeliveriesFromContext(ctx context.Context) int {
+	if ctx != nil {
+		if n, ok := ctx.Value(maxDeliveriesKey{}).(int); ok && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxDeliveries
+}
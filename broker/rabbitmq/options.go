@@ -0,0 +1,173 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"new-milli/broker"
+	"new-milli/config"
+)
+
+func init() {
+	broker.RegisterFactory("rabbitmq", newFromConfig)
+}
+
+// newFromConfig creates a broker from the config under key, see
+// broker.FromConfig. The reconnection is configured by the reconnect
+// (default true), reconnect_min_interval and reconnect_max_interval fields,
+// and delayed_exchange enables WithDelayedExchange.
+func newFromConfig(cfg config.Config, key string, opts ...broker.Option) (broker.Broker, error) {
+	var options []broker.Option
+	if cfg.Has(key + ".delayed_exchange") {
+		delayed, err := cfg.GetBool(key + ".delayed_exchange")
+		if err != nil {
+			return nil, err
+		}
+		if delayed {
+			options = append(options, WithDelayedExchange())
+		}
+	}
+	if cfg.Has(key + ".reconnect") {
+		reconnect, err := cfg.GetBool(key + ".reconnect")
+		if err != nil {
+			return nil, err
+		}
+		if !reconnect {
+			options = append(options, DisableReconnect())
+		}
+	}
+
+	ro := reconnectFromContext(nil)
+	var err error
+	if cfg.Has(key + ".reconnect_min_interval") {
+		if ro.minInterval, err = config.GetDuration(cfg, key+".reconnect_min_interval"); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Has(key + ".reconnect_max_interval") {
+		if ro.maxInterval, err = config.GetDuration(cfg, key+".reconnect_max_interval"); err != nil {
+			return nil, err
+		}
+	}
+	options = append(options, WithReconnectInterval(ro.minInterval, ro.maxInterval))
+
+	return New(append(options, opts...)...), nil
+}
+
+// ConnState is the state of the RabbitMQ connection.
+type ConnState int
+
+const (
+	// StateDisconnected means the broker is not connected.
+	StateDisconnected ConnState = iota
+	// StateConnected means the broker is connected.
+	StateConnected
+	// StateReconnecting means the connection was lost and the broker is re-dialing.
+	StateReconnecting
+)
+
+// String returns the string representation of the connection state.
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return "unknown"
+	}
+}
+
+type (
+	reconnectKey       struct{}
+	stateHandlerKey    struct{}
+	delayedExchangeKey struct{}
+	reconnectOptions   struct {
+		disabled    bool
+		minInterval time.Duration
+		maxInterval time.Duration
+	}
+)
+
+// StateHandler is called whenever the connection state changes.
+// err is the error that caused the transition, if any.
+type StateHandler func(from, to ConnState, err error)
+
+// WithReconnectInterval sets the initial and maximum backoff between re-dial attempts.
+func WithReconnectInterval(min, max time.Duration) broker.Option {
+	return func(o *broker.Options) {
+		ro := reconnectFromContext(o.Context)
+		ro.minInterval = min
+		ro.maxInterval = max
+		setOption(o, reconnectKey{}, ro)
+	}
+}
+
+// DisableReconnect disables automatic reconnection.
+func DisableReconnect() broker.Option {
+	return func(o *broker.Options) {
+		ro := reconnectFromContext(o.Context)
+		ro.disabled = true
+		setOption(o, reconnectKey{}, ro)
+	}
+}
+
+// WithStateHandler sets the handler called on connection state changes.
+func WithStateHandler(fn StateHandler) broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, stateHandlerKey{}, fn)
+	}
+}
+
+// WithDelayedExchange declares the exchanges with the x-delayed-message type
+// of the delayed message exchange plugin, which must be enabled on the
+// server, and delays the messages with its x-delay header. Without it the
+// delayed messages wait in a queue per topic and delay, with a message TTL
+// and the topic exchange as dead letter exchange.
+func WithDelayedExchange() broker.Option {
+	return func(o *broker.Options) {
+		setOption(o, delayedExchangeKey{}, true)
+	}
+}
+
+// setOption stores a RabbitMQ specific option in the broker options context.
+func setOption(o *broker.Options, key, value interface{}) {
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+	o.Context = context.WithValue(o.Context, key, value)
+}
+
+// reconnectFromContext returns the reconnect options stored in ctx or the defaults.
+func reconnectFromContext(ctx context.Context) reconnectOptions {
+	if ctx != nil {
+		if ro, ok := ctx.Value(reconnectKey{}).(reconnectOptions); ok {
+			return ro
+		}
+	}
+	return reconnectOptions{
+		minInterval: time.Second,
+		maxInterval: time.Second * 30,
+	}
+}
+
+// stateHandlerFromContext returns the state handler stored in ctx, if any.
+func stateHandlerFromContext(ctx context.Context) StateHandler {
+	if ctx != nil {
+		if fn, ok := ctx.Value(stateHandlerKey{}).(StateHandler); ok {
+			return fn
+		}
+	}
+	return nil
+}
+
+// delayedExchangeFromContext reports whether WithDelayedExchange is set in ctx.
+func delayedExchangeFromContext(ctx context.Context) bool {
+	if ctx != nil {
+		delayed, _ := ctx.Value(delayedExchangeKey{}).(bool)
+		return delayed
+	}
+	return false
+}
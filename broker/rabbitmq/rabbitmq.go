@@ -8,24 +8,32 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cloudwego/kitex/pkg/klog"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"new-milli/broker"
 )
 
 var (
 	_ broker.Broker = (*Broker)(nil)
+
+	// errDisconnected is returned by restore when Disconnect was called
+	// while re-dialing.
+	errDisconnected = errors.New("disconnected while reconnecting")
 )
 
 // Broker is a RabbitMQ broker.
 type Broker struct {
 	sync.RWMutex
-	addrs      []string
-	connected  bool
-	options    broker.Options
-	connection *amqp.Connection
-	channel    *amqp.Channel
-	exchanges  map[string]bool
+	addrs       []string
+	connected   bool
+	state       ConnState
+	options     broker.Options
+	connection  *amqp.Connection
+	channel     *amqp.Channel
+	exchanges   map[string]bool
+	delayQueues map[string]bool
 	subscribers map[string]*subscriber
+	done        chan struct{}
 }
 
 // New creates a new RabbitMQ broker.
@@ -42,6 +50,7 @@ func New(opts ...broker.Option) broker.Broker {
 		addrs:       options.Addrs,
 		options:     options,
 		exchanges:   make(map[string]bool),
+		delayQueues: make(map[string]bool),
 		subscribers: make(map[string]*subscriber),
 	}
 }
@@ -64,46 +73,64 @@ func (b *Broker) Address() string {
 	return strings.Join(b.addrs, ",")
 }
 
+// State returns the current connection state.
+func (b *Broker) State() ConnState {
+	b.RLock()
+	defer b.RUnlock()
+	return b.state
+}
+
 // Connect connects to the broker.
 func (b *Broker) Connect() error {
 	b.Lock()
-	defer b.Unlock()
 
 	if b.connected {
+		b.Unlock()
 		return nil
 	}
 
 	// Connect to RabbitMQ
-	conn, err := amqp.Dial(b.addrs[0])
+	conn, ch, err := b.dial()
 	if err != nil {
-		return err
-	}
-
-	// Create a channel
-	ch, err := conn.Channel()
-	if err != nil {
-		conn.Close()
+		b.Unlock()
 		return err
 	}
 
 	b.connection = conn
 	b.channel = ch
 	b.connected = true
+	b.done = make(chan struct{})
+	from := b.state
+	b.state = StateConnected
+
+	// Watch the connection for unexpected closes
+	if !reconnectFromContext(b.options.Context).disabled {
+		b.watch(conn, ch)
+	}
+	b.Unlock()
+
+	b.notify(from, StateConnected, nil)
 	return nil
 }
 
 // Disconnect disconnects from the broker.
 func (b *Broker) Disconnect() error {
 	b.Lock()
-	defer b.Unlock()
 
 	if !b.connected {
+		b.Unlock()
 		return nil
 	}
 
+	// Stop the reconnect loop
+	close(b.done)
+
 	// Close all subscribers
-	for _, sub := range b.subscribers {
-		sub.Unsubscribe()
+	subs := make([]*subscriber, 0, len(b.subscribers))
+	for id, sub := range b.subscribers {
+		sub.close()
+		subs = append(subs, sub)
+		delete(b.subscribers, id)
 	}
 
 	// Close the channel
@@ -116,20 +143,26 @@ func (b *Broker) Disconnect() error {
 		b.connection.Close()
 	}
 
+	b.channel = nil
+	b.connection = nil
+	b.exchanges = make(map[string]bool)
+	b.delayQueues = make(map[string]bool)
 	b.connected = false
+	from := b.state
+	b.state = StateDisconnected
+	b.Unlock()
+
+	// Wait for the messages being handled, which may publish
+	for _, sub := range subs {
+		sub.dispatcher.Close()
+	}
+
+	b.notify(from, StateDisconnected, nil)
 	return nil
 }
 
 // Publish publishes a message to a topic.
 func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message, opts ...broker.PublishOption) error {
-	b.RLock()
-	if !b.connected {
-		b.RUnlock()
-		return errors.New("not connected")
-	}
-	ch := b.channel
-	b.RUnlock()
-
 	options := broker.PublishOptions{
 		Context: ctx,
 	}
@@ -137,8 +170,15 @@ func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message,
 		o(&options)
 	}
 
+	b.Lock()
+	if !b.connected || b.channel == nil {
+		b.Unlock()
+		return errors.New("not connected")
+	}
+
 	// Ensure the exchange exists
 	if err := b.ensureExchange(topic); err != nil {
+		b.Unlock()
 		return err
 	}
 
@@ -148,13 +188,30 @@ func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message,
 		headers[k] = v
 	}
 
+	// Delay the message with the plugin, or through a delay queue
+	exchange, key := topic, ""
+	if delay := options.Delay.Milliseconds(); delay > 0 {
+		if delayedExchangeFromContext(b.options.Context) {
+			headers["x-delay"] = delay
+		} else {
+			queue, err := b.ensureDelayQueue(topic, delay)
+			if err != nil {
+				b.Unlock()
+				return err
+			}
+			exchange, key = "", queue
+		}
+	}
+	ch := b.channel
+	b.Unlock()
+
 	// Publish the message
 	return ch.PublishWithContext(
 		options.Context,
-		topic, // exchange
-		"",    // routing key (empty for fanout)
-		false, // mandatory
-		false, // immediate
+		exchange, // exchange
+		key,      // routing key (the delay queue, or empty for fanout)
+		false,    // mandatory
+		false,    // immediate
 		amqp.Publishing{
 			ContentType: "application/octet-stream",
 			Body:        msg.Body,
@@ -168,7 +225,7 @@ func (b *Broker) Subscribe(topic string, handler broker.Handler, opts ...broker.
 	b.Lock()
 	defer b.Unlock()
 
-	if !b.connected {
+	if !b.connected || b.channel == nil {
 		return nil, errors.New("not connected")
 	}
 
@@ -186,8 +243,281 @@ func (b *Broker) Subscribe(topic string, handler broker.Handler, opts ...broker.
 		return nil, err
 	}
 
+	// Create the subscriber
+	sub := &subscriber{
+		broker:     b,
+		topic:      topic,
+		queue:      options.Queue,
+		handler:    handler,
+		options:    options,
+		dispatcher: broker.NewDispatcher(options),
+		done:       make(chan struct{}),
+	}
+
+	// Start consuming
+	if err := b.consume(sub); err != nil {
+		return nil, err
+	}
+
+	// Save the subscriber
+	b.subscribers[sub.id()] = sub
+
+	return sub, nil
+}
+
+// String returns the name of the broker.
+func (b *Broker) String() string {
+	return "rabbitmq"
+}
+
+// dial opens a new connection and channel.
+func (b *Broker) dial() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(b.addrs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, ch, nil
+}
+
+// watch starts a goroutine that reconnects when the connection or channel is closed unexpectedly.
+// It must be called with the lock held.
+func (b *Broker) watch(conn *amqp.Connection, ch *amqp.Channel) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	done := b.done
+
+	go func() {
+		var cause *amqp.Error
+		select {
+		case <-done:
+			return
+		case cause = <-connClosed:
+		case cause = <-chClosed:
+		}
+
+		// A nil error means the close was requested by us
+		if cause == nil {
+			return
+		}
+
+		b.reconnect(conn, cause, done)
+	}()
+}
+
+// watchConsumer starts a goroutine that reconnects when the channel of a
+// consumer is closed, or the consumer canceled, by the server, e.g. when its
+// queue is deleted. It must be called with the lock held.
+func (b *Broker) watchConsumer(conn *amqp.Connection, ch *amqp.Channel) {
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	canceled := ch.NotifyCancel(make(chan string, 1))
+	done := b.done
+
+	go func() {
+		var cause error
+		select {
+		case <-done:
+			return
+		case err := <-closed:
+			// A nil error means the close was requested by us
+			if err == nil {
+				return
+			}
+			cause = err
+		case tag, ok := <-canceled:
+			if ok {
+				cause = fmt.Errorf("consumer %s canceled by the server", tag)
+			} else if err := <-closed; err != nil {
+				// The cancel notifications end when the channel is closed
+				cause = err
+			} else {
+				return
+			}
+		}
+
+		b.reconnect(conn, cause, done)
+	}()
+}
+
+// reconnect re-dials the broker with backoff, re-declares exchanges and
+// resubscribes all existing subscribers. It does nothing when conn was
+// already replaced, e.g. by the reconnect of another watcher.
+func (b *Broker) reconnect(conn *amqp.Connection, cause error, done chan struct{}) {
+	b.Lock()
+	if !b.connected || b.connection != conn {
+		b.Unlock()
+		return
+	}
+	if b.connection != nil {
+		b.connection.Close()
+	}
+	b.connection = nil
+	b.channel = nil
+	from := b.state
+	b.state = StateReconnecting
+	b.Unlock()
+
+	b.notify(from, StateReconnecting, cause)
+	klog.Warnf("RabbitMQ connection to %s lost: %v", b.Address(), cause)
+
+	ro := reconnectFromContext(b.options.Context)
+	interval := ro.minInterval
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+		}
+
+		err := b.restore()
+		if errors.Is(err, errDisconnected) {
+			return
+		}
+		if err != nil {
+			klog.Warnf("RabbitMQ reconnect to %s failed: %v", b.Address(), err)
+			interval *= 2
+			if interval > ro.maxInterval {
+				interval = ro.maxInterval
+			}
+			continue
+		}
+
+		klog.Infof("RabbitMQ reconnected to %s", b.Address())
+		b.notify(StateReconnecting, StateConnected, nil)
+		return
+	}
+}
+
+// restore dials a new connection and restores exchanges and subscribers on it.
+func (b *Broker) restore() error {
+	conn, ch, err := b.dial()
+	if err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	// Disconnect was called while re-dialing
+	if !b.connected {
+		conn.Close()
+		return errDisconnected
+	}
+
+	b.connection = conn
+	b.channel = ch
+
+	// Re-declare exchanges, the delay queues are declared again on use
+	exchanges := b.exchanges
+	b.exchanges = make(map[string]bool)
+	b.delayQueues = make(map[string]bool)
+	for name := range exchanges {
+		if err := b.ensureExchange(name); err != nil {
+			b.abort(exchanges)
+			return err
+		}
+	}
+
+	// Resubscribe existing subscribers
+	for _, sub := range b.subscribers {
+		if err := b.consume(sub); err != nil {
+			b.abort(exchanges)
+			return err
+		}
+	}
+
+	b.state = StateConnected
+	b.watch(conn, ch)
+	return nil
+}
+
+// abort drops a partially restored connection. It must be called with the lock held.
+func (b *Broker) abort(exchanges map[string]bool) {
+	b.connection.Close()
+	b.connection = nil
+	b.channel = nil
+	for name := range exchanges {
+		b.exchanges[name] = true
+	}
+}
+
+// notify calls the state handler, if any.
+func (b *Broker) notify(from, to ConnState, err error) {
+	if fn := stateHandlerFromContext(b.options.Context); fn != nil {
+		fn(from, to, err)
+	}
+}
+
+// ensureExchange ensures that an exchange exists.
+// It must be called with the lock held.
+func (b *Broker) ensureExchange(name string) error {
+	if _, ok := b.exchanges[name]; ok {
+		return nil
+	}
+
+	// The delayed message exchanges route like fanout ones
+	kind, args := "fanout", amqp.Table(nil)
+	if delayedExchangeFromContext(b.options.Context) {
+		kind, args = "x-delayed-message", amqp.Table{"x-delayed-type": "fanout"}
+	}
+
+	err := b.channel.ExchangeDeclare(
+		name,  // name
+		kind,  // type
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		args,  // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	b.exchanges[name] = true
+	return nil
+}
+
+// ensureDelayQueue ensures that the queue holding the messages of a topic
+// delayed by delay milliseconds exists, and returns its name. Its messages
+// expire after the delay and are dead lettered to the topic exchange.
+// It must be called with the lock held.
+func (b *Broker) ensureDelayQueue(topic string, delay int64) (string, error) {
+	name := fmt.Sprintf("%s.delay.%d", topic, delay)
+	if _, ok := b.delayQueues[name]; ok {
+		return name, nil
+	}
+
+	_, err := b.channel.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{
+			"x-message-ttl":          delay,
+			"x-dead-letter-exchange": topic,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	b.delayQueues[name] = true
+	return name, nil
+}
+
+// consume declares and binds the subscriber queue and starts consuming on a new channel.
+// It must be called with the lock held.
+func (b *Broker) consume(sub *subscriber) error {
 	// Create a queue
-	queueName := fmt.Sprintf("%s-%s", topic, options.Queue)
+	queueName := fmt.Sprintf("%s-%s", sub.topic, sub.queue)
 	q, err := b.channel.QueueDeclare(
 		queueName, // name
 		true,      // durable
@@ -197,99 +527,75 @@ func (b *Broker) Subscribe(topic string, handler broker.Handler, opts ...broker.
 		nil,       // arguments
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Bind the queue to the exchange
 	err = b.channel.QueueBind(
-		q.Name, // queue name
-		"",     // routing key (empty for fanout)
-		topic,  // exchange
-		false,  // no-wait
-		nil,    // arguments
+		q.Name,    // queue name
+		"",        // routing key (empty for fanout)
+		sub.topic, // exchange
+		false,     // no-wait
+		nil,       // arguments
 	)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Create a consumer
 	ch, err := b.connection.Channel()
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// Bound the unacked deliveries to the workers
+	if !sub.options.AutoAck && sub.options.Concurrency > 1 {
+		if err := ch.Qos(sub.options.Concurrency, 0, false); err != nil {
+			ch.Close()
+			return err
+		}
 	}
 
 	// Start consuming
 	deliveries, err := ch.Consume(
-		q.Name,                   // queue
+		q.Name, // queue
 		fmt.Sprintf("%s-%d", q.Name, time.Now().UnixNano()), // consumer
-		options.AutoAck,          // auto-ack
-		false,                    // exclusive
-		false,                    // no-local
-		false,                    // no-wait
-		nil,                      // args
+		sub.options.AutoAck, // auto-ack
+		false,               // exclusive
+		false,               // no-local
+		false,               // no-wait
+		nil,                 // args
 	)
 	if err != nil {
 		ch.Close()
-		return nil, err
+		return err
 	}
 
-	// Create the subscriber
-	sub := &subscriber{
-		topic:      topic,
-		queue:      options.Queue,
-		handler:    handler,
-		channel:    ch,
-		options:    options,
-		deliveries: deliveries,
-		done:       make(chan struct{}),
-	}
+	sub.mu.Lock()
+	sub.channel = ch
+	sub.mu.Unlock()
 
 	// Start the subscriber
-	go sub.run()
-
-	// Save the subscriber
-	b.subscribers[sub.id()] = sub
-
-	return sub, nil
-}
-
-// String returns the name of the broker.
-func (b *Broker) String() string {
-	return "rabbitmq"
-}
-
-// ensureExchange ensures that an exchange exists.
-func (b *Broker) ensureExchange(name string) error {
-	if _, ok := b.exchanges[name]; ok {
-		return nil
-	}
-
-	err := b.channel.ExchangeDeclare(
-		name,     // name
-		"fanout", // type
-		true,     // durable
-		false,    // auto-deleted
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
-	)
-	if err != nil {
-		return err
+	if !reconnectFromContext(b.options.Context).disabled {
+		b.watchConsumer(b.connection, ch)
 	}
+	go sub.run(deliveries)
 
-	b.exchanges[name] = true
 	return nil
 }
 
 // subscriber is a RabbitMQ subscriber.
 type subscriber struct {
+	broker     *Broker
 	topic      string
 	queue      string
 	handler    broker.Handler
-	channel    *amqp.Channel
 	options    broker.SubscribeOptions
-	deliveries <-chan amqp.Delivery
+	dispatcher *broker.Dispatcher
 	done       chan struct{}
+	once       sync.Once
+	mu         sync.Mutex
+	channel    *amqp.Channel
 }
 
 // Topic returns the topic of the subscriber.
@@ -299,8 +605,31 @@ func (s *subscriber) Topic() string {
 
 // Unsubscribe unsubscribes from the topic.
 func (s *subscriber) Unsubscribe() error {
-	close(s.done)
-	return s.channel.Close()
+	s.broker.Lock()
+	if s.broker.subscribers[s.id()] == s {
+		delete(s.broker.subscribers, s.id())
+	}
+	s.broker.Unlock()
+
+	err := s.close()
+	s.dispatcher.Close()
+	return err
+}
+
+// close stops the subscriber and closes its channel.
+func (s *subscriber) close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.channel == nil {
+		return nil
+	}
+	err := s.channel.Close()
+	s.channel = nil
+	return err
 }
 
 // id returns a unique id for the subscriber.
@@ -308,13 +637,14 @@ func (s *subscriber) id() string {
 	return fmt.Sprintf("%s-%s", s.topic, s.queue)
 }
 
-// run runs the subscriber.
-func (s *subscriber) run() {
+// run runs the subscriber until it is closed or the deliveries channel is
+// closed because the connection was lost.
+func (s *subscriber) run(deliveries <-chan amqp.Delivery) {
 	for {
 		select {
 		case <-s.done:
 			return
-		case delivery, ok := <-s.deliveries:
+		case delivery, ok := <-deliveries:
 			if !ok {
 				return
 			}
@@ -333,19 +663,21 @@ func (s *subscriber) run() {
 			}
 
 			// Handle the message
-			err := s.handler(s.options.Context, msg)
-			if err != nil {
-				// Nack the message if auto-ack is disabled
-				if !s.options.AutoAck {
-					delivery.Nack(false, true)
+			s.dispatcher.Dispatch(msg, func() {
+				err := s.handler(s.options.Context, msg)
+				if err != nil {
+					// Nack the message if auto-ack is disabled
+					if !s.options.AutoAck {
+						delivery.Nack(false, true)
+					}
+					return
 				}
-				continue
-			}
 
-			// Ack the message if auto-ack is disabled
-			if !s.options.AutoAck {
-				delivery.Ack(false)
-			}
+				// Ack the message if auto-ack is disabled
+				if !s.options.AutoAck {
+					delivery.Ack(false)
+				}
+			})
 		}
 	}
 }
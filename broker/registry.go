@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry is a registry of named brokers.
+type Registry struct {
+	mu      sync.RWMutex
+	brokers map[string]Broker
+}
+
+// NewRegistry creates a new registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		brokers: make(map[string]Broker),
+	}
+}
+
+// Register registers a broker.
+func (r *Registry) Register(name string, b Broker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.brokers[name] = b
+}
+
+// Get returns a broker by name.
+func (r *Registry) Get(name string) (Broker, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.brokers[name]
+	return b, ok
+}
+
+// List returns all registered brokers.
+func (r *Registry) List() map[string]Broker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	brokers := make(map[string]Broker, len(r.brokers))
+	for name, b := range r.brokers {
+		brokers[name] = b
+	}
+	return brokers
+}
+
+// Connect connects the brokers in name order, stopping at the first
+// failure. Its signature fits the start hooks of the application.
+func (r *Registry) Connect(ctx context.Context) error {
+	for _, name := range r.names() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b, _ := r.Get(name)
+		if err := b.Connect(); err != nil {
+			return fmt.Errorf("failed to connect broker %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Disconnect disconnects all the brokers. Its signature fits the
+// components of the application.
+func (r *Registry) Disconnect(ctx context.Context) error {
+	var errs []error
+	for _, name := range r.names() {
+		b, _ := r.Get(name)
+		if err := b.Disconnect(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to disconnect broker %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// names returns the names of the brokers in order.
+func (r *Registry) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.brokers))
+	for name := range r.brokers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// global is the global registry.
+var global = NewRegistry()
+
+// Register registers a broker in the global registry.
+func Register(name string, b Broker) {
+	global.Register(name, b)
+}
+
+// Get returns a broker by name from the global registry.
+func Get(name string) (Broker, bool) {
+	return global.Get(name)
+}
+
+// List returns all registered brokers from the global registry.
+func List() map[string]Broker {
+	return global.List()
+}
+
+// Default returns the global registry, e.g. to connect its brokers with the
+// application.
+func Default() *Registry {
+	return global
+}
@@ -6,18 +6,26 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/apache/rocketmq-client-go/v2"
 	"github.com/apache/rocketmq-client-go/v2/consumer"
 	"github.com/apache/rocketmq-client-go/v2/primitive"
 	"github.com/apache/rocketmq-client-go/v2/producer"
 	"new-milli/broker"
+	"new-milli/config"
 )
 
 var (
 	_ broker.Broker = (*Broker)(nil)
 )
 
+func init() {
+	broker.RegisterFactory("rocketmq", func(_ config.Config, _ string, opts ...broker.Option) (broker.Broker, error) {
+		return New(opts...), nil
+	})
+}
+
 // Broker is a RocketMQ broker.
 type Broker struct {
 	sync.RWMutex
@@ -144,6 +152,11 @@ func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message,
 		rmsg.WithProperty(k, v)
 	}
 
+	// Delay the message
+	if options.Delay > 0 {
+		rmsg.WithDelayTimeLevel(DelayLevel(options.Delay))
+	}
+
 	// Send the message
 	_, err := p.SendSync(options.Context, rmsg)
 	return err
@@ -237,6 +250,27 @@ func (b *Broker) String() string {
 	return "rocketmq"
 }
 
+// delayLevels are the default delay levels of the RocketMQ brokers, level
+// n being delayLevels[n-1].
+var delayLevels = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute,
+	5 * time.Minute, 6 * time.Minute, 7 * time.Minute, 8 * time.Minute,
+	9 * time.Minute, 10 * time.Minute, 20 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour,
+}
+
+// DelayLevel returns the delay level of d, the first default level not
+// shorter than d, or the last one (2h) for longer delays.
+func DelayLevel(d time.Duration) int {
+	for i, level := range delayLevels {
+		if d <= level {
+			return i + 1
+		}
+	}
+	return len(delayLevels)
+}
+
 // subscriber is a RocketMQ subscriber.
 type subscriber struct {
 	topic    string
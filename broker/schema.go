@@ -0,0 +1,127 @@
+package broker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"new-milli/codec"
+)
+
+// ErrInvalidSchemaFrame is returned when a message encoded by a schema
+// codec doesn't start with the magic byte and the schema id.
+var ErrInvalidSchemaFrame = errors.New("broker: invalid schema registry frame")
+
+// SchemaRegistry stores the schemas of the messages encoded by the schema
+// codecs, e.g. a Confluent schema registry client.
+type SchemaRegistry interface {
+	// Register registers schema under subject and returns its id.
+	Register(ctx context.Context, subject, schema string) (int, error)
+	// Schema returns the schema registered with id.
+	Schema(ctx context.Context, id int) (string, error)
+}
+
+// SchemaUnmarshaler is implemented by the codecs decoding with the schema
+// the data was written with, e.g. Avro codecs. The schema codecs pass them
+// the writer schema fetched from the registry.
+type SchemaUnmarshaler interface {
+	UnmarshalSchema(schema string, data []byte, v interface{}) error
+}
+
+// schemaCodec frames the encoding of a codec with the id of its schema.
+type schemaCodec struct {
+	codec.Codec
+	registry SchemaRegistry
+	subject  string
+	schema   string
+
+	mu      sync.Mutex
+	id      int
+	schemas map[int]string
+}
+
+// NewSchemaCodec returns a codec encoding with c, the data prefixed by a
+// zero magic byte and the big-endian 4 bytes id of schema in the registry,
+// the Confluent wire format. The schema is registered under subject on
+// first use. Decoding checks the frame and fetches the writer schema for c
+// when it implements SchemaUnmarshaler. c is typically an Avro codec built
+// on an Avro library, the result can be registered with codec.Register to
+// use it by content type:
+//
+//	codec.Register(broker.NewSchemaCodec(avroCodec, registry, "orders-value", schema), "avro/binary")
+func NewSchemaCodec(c codec.Codec, registry SchemaRegistry, subject, schema string) codec.Codec {
+	return &schemaCodec{
+		Codec:    c,
+		registry: registry,
+		subject:  subject,
+		schema:   schema,
+		id:       -1,
+		schemas:  make(map[int]string),
+	}
+}
+
+// Marshal returns the framed encoding of v.
+func (c *schemaCodec) Marshal(v interface{}) ([]byte, error) {
+	id, err := c.schemaID()
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(id))
+	return append(frame, data...), nil
+}
+
+// Unmarshal parses framed data into v.
+func (c *schemaCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) < 5 || data[0] != 0 {
+		return ErrInvalidSchemaFrame
+	}
+	id := int(binary.BigEndian.Uint32(data[1:5]))
+
+	u, ok := c.Codec.(SchemaUnmarshaler)
+	if !ok {
+		return c.Codec.Unmarshal(data[5:], v)
+	}
+	schema, err := c.writerSchema(id)
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalSchema(schema, data[5:], v)
+}
+
+// schemaID returns the id of the schema, registering it the first time.
+func (c *schemaCodec) schemaID() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.id >= 0 {
+		return c.id, nil
+	}
+	id, err := c.registry.Register(context.Background(), c.subject, c.schema)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register schema of %s: %w", c.subject, err)
+	}
+	c.id = id
+	c.schemas[id] = c.schema
+	return id, nil
+}
+
+// writerSchema returns the schema with id, fetched from the registry once.
+func (c *schemaCodec) writerSchema(id int) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if schema, ok := c.schemas[id]; ok {
+		return schema, nil
+	}
+	schema, err := c.registry.Schema(context.Background(), id)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch schema %d: %w", id, err)
+	}
+	c.schemas[id] = schema
+	return schema, nil
+}
@@ -0,0 +1,329 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+	"new-milli/broker"
+	"new-milli/collector"
+	"new-milli/middleware/circuitbreaker"
+)
+
+var _ broker.Broker = (*Broker)(nil)
+
+// spoolMetrics is the metrics of the spooled messages.
+type spoolMetrics struct {
+	spooled  *prometheus.CounterVec
+	replayed *prometheus.CounterVec
+	dropped  *prometheus.CounterVec
+}
+
+// newSpoolMetrics creates the spool metrics registered with registry,
+// reusing the registered ones. They aren't registered when registry is nil.
+func newSpoolMetrics(registry prometheus.Registerer) *spoolMetrics {
+	m := &spoolMetrics{
+		spooled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "broker_spool",
+			Name:      "spooled_total",
+			Help:      "Number of messages spooled while the broker was unavailable.",
+		}, []string{"broker", "topic"}),
+		replayed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "broker_spool",
+			Name:      "replayed_total",
+			Help:      "Number of spooled messages published once the broker recovered.",
+		}, []string{"broker", "topic"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "broker_spool",
+			Name:      "dropped_total",
+			Help:      "Number of messages dropped because the spool was full or failed.",
+		}, []string{"broker", "topic"}),
+	}
+	if registry != nil {
+		var errs [3]error
+		m.spooled, errs[0] = collector.Register(registry, m.spooled)
+		m.replayed, errs[1] = collector.Register(registry, m.replayed)
+		m.dropped, errs[2] = collector.Register(registry, m.dropped)
+		if err := errors.Join(errs[:]...); err != nil {
+			klog.Warnf("Failed to register spool metrics: %v", err)
+		}
+	}
+	return m
+}
+
+// Option is spool option.
+type Option func(*options)
+
+// options is spool options.
+type options struct {
+	breakerOpts    []circuitbreaker.Option
+	replayInterval time.Duration
+	publishTimeout time.Duration
+	registry       prometheus.Registerer
+}
+
+// WithBreaker returns an Option that configures the publish circuit breaker,
+// which trips after 5 consecutive failures by default.
+func WithBreaker(opts ...circuitbreaker.Option) Option {
+	return func(o *options) {
+		o.breakerOpts = append(o.breakerOpts, opts...)
+	}
+}
+
+// WithReplayInterval returns an Option that sets how often the spool is
+// replayed while it holds messages.
+func WithReplayInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.replayInterval = interval
+	}
+}
+
+// WithPublishTimeout returns an Option that bounds the publishes of the
+// replayed messages.
+func WithPublishTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.publishTimeout = timeout
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the spool
+// metrics, prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// Broker wraps a broker so publishes failing or rejected by its circuit
+// breaker are spooled to a store and replayed in order once the broker
+// recovers. Subscriptions are passed through.
+type Broker struct {
+	broker.Broker
+	store   Store
+	opts    options
+	breaker *gobreaker.CircuitBreaker
+	metrics *spoolMetrics
+
+	// mu orders publishes against the replay, pending is set while the
+	// store may hold messages so new ones are queued behind them
+	mu      sync.Mutex
+	pending bool
+
+	runMu  sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New wraps b with a publish circuit breaker spooling messages to store.
+func New(b broker.Broker, store Store, opts ...Option) *Broker {
+	o := options{
+		replayInterval: time.Second,
+		publishTimeout: 5 * time.Second,
+		registry:       prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	breakerOpts := append([]circuitbreaker.Option{
+		circuitbreaker.WithReadyToTrip(func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 5
+		}),
+		circuitbreaker.WithTimeout(10 * time.Second),
+	}, o.breakerOpts...)
+
+	return &Broker{
+		Broker:  b,
+		store:   store,
+		opts:    o,
+		breaker: circuitbreaker.NewCircuitBreaker("broker_publish_"+b.String(), breakerOpts...),
+		metrics: newSpoolMetrics(o.registry),
+		pending: true,
+	}
+}
+
+// Connect connects the broker and starts replaying the spool, including the
+// messages left by a previous process in a persistent store.
+func (b *Broker) Connect() error {
+	if err := b.Broker.Connect(); err != nil {
+		return err
+	}
+
+	b.runMu.Lock()
+	defer b.runMu.Unlock()
+
+	if b.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		b.cancel = cancel
+		b.done = make(chan struct{})
+		go b.replayLoop(ctx)
+	}
+	return nil
+}
+
+// Disconnect stops replaying the spool and disconnects the broker. Spooled
+// messages stay in the store.
+func (b *Broker) Disconnect() error {
+	b.runMu.Lock()
+	if b.cancel != nil {
+		b.cancel()
+		<-b.done
+		b.cancel = nil
+	}
+	b.runMu.Unlock()
+
+	return b.Broker.Disconnect()
+}
+
+// Publish publishes msg, or spools it when the breaker is open, the publish
+// fails or older messages are still spooled. A spooled message returns nil,
+// a message that can't be spooled returns the error.
+func (b *Broker) Publish(ctx context.Context, topic string, msg *broker.Message, opts ...broker.PublishOption) error {
+	b.mu.Lock()
+	pending := b.pending
+	b.mu.Unlock()
+
+	if !pending {
+		_, err := b.breaker.Execute(func() (interface{}, error) {
+			return nil, b.Broker.Publish(ctx, topic, msg, opts...)
+		})
+		if err == nil {
+			return nil
+		}
+		// The caller gave up, don't publish behind its back
+		if ctx.Err() != nil {
+			return err
+		}
+		klog.CtxWarnf(ctx, "spool: failed to publish to %s, spooling: %v", topic, err)
+	}
+
+	// Keep the delivery time of a delayed message across the spool
+	e := &Entry{Topic: topic, Message: msg}
+	var options broker.PublishOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.Delay > 0 {
+		e.DeliverAt = time.Now().Add(options.Delay)
+	}
+	return b.spool(ctx, e)
+}
+
+// spool appends an entry to the store.
+func (b *Broker) spool(ctx context.Context, e *Entry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topic := e.Topic
+	if err := b.store.Push(ctx, e); err != nil {
+		b.metrics.dropped.WithLabelValues(b.String(), topic).Inc()
+		return fmt.Errorf("failed to spool message: %w", err)
+	}
+	b.pending = true
+	b.metrics.spooled.WithLabelValues(b.String(), topic).Inc()
+	return nil
+}
+
+// Spooled returns the number of messages waiting to be replayed.
+func (b *Broker) Spooled(ctx context.Context) (int64, error) {
+	return b.store.Len(ctx)
+}
+
+// replayLoop replays the spool until ctx is done.
+func (b *Broker) replayLoop(ctx context.Context) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.opts.replayInterval)
+	defer ticker.Stop()
+
+	for {
+		b.replay(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay publishes the spooled messages in order until the spool is empty
+// or a publish fails.
+func (b *Broker) replay(ctx context.Context) {
+	b.mu.Lock()
+	pending := b.pending
+	b.mu.Unlock()
+	if !pending || b.breaker.State() == gobreaker.StateOpen {
+		return
+	}
+
+	for ctx.Err() == nil {
+		e, err := b.peek(ctx)
+		if errors.Is(err, ErrEmpty) {
+			return
+		}
+		if err != nil {
+			klog.Errorf("spool: failed to read spool: %v", err)
+			return
+		}
+
+		if e != nil {
+			pubCtx, cancel := context.WithTimeout(ctx, b.opts.publishTimeout)
+			_, err = b.breaker.Execute(func() (interface{}, error) {
+				return nil, b.Broker.Publish(pubCtx, e.Topic, e.Message, replayOptions(e)...)
+			})
+			cancel()
+			if err != nil {
+				klog.Warnf("spool: failed to replay message to %s: %v", e.Topic, err)
+				return
+			}
+			b.metrics.replayed.WithLabelValues(b.String(), e.Topic).Inc()
+		}
+
+		if err := b.store.Pop(ctx); err != nil {
+			klog.Errorf("spool: failed to remove replayed message: %v", err)
+			return
+		}
+	}
+}
+
+// replayOptions returns the publish options of a spooled entry, the
+// remaining delay of a delayed message. A message that became due while
+// spooled is published right away.
+func replayOptions(e *Entry) []broker.PublishOption {
+	if e.DeliverAt.IsZero() {
+		return nil
+	}
+	if delay := time.Until(e.DeliverAt); delay > 0 {
+		return []broker.PublishOption{broker.WithDelay(delay)}
+	}
+	return nil
+}
+
+// peek returns the oldest spooled message. It clears the pending flag and
+// returns ErrEmpty when the spool is drained, and drops a message that
+// can't be read so it doesn't block the others, returning a nil entry.
+func (b *Broker) peek(ctx context.Context) (*Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, err := b.store.Peek(ctx)
+	if errors.Is(err, ErrEmpty) {
+		b.pending = false
+		return nil, err
+	}
+	if errors.Is(err, ErrCorrupt) {
+		klog.Errorf("spool: dropping unreadable message: %v", err)
+		b.metrics.dropped.WithLabelValues(b.String(), "").Inc()
+		return nil, nil
+	}
+	return e, err
+}
@@ -0,0 +1,240 @@
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"new-milli/broker"
+)
+
+var (
+	// ErrFull is returned when a store holds its limit of messages.
+	ErrFull = errors.New("spool: store is full")
+	// ErrEmpty is returned by Peek when a store holds no message.
+	ErrEmpty = errors.New("spool: store is empty")
+	// ErrCorrupt is returned by Peek when the oldest entry can't be decoded.
+	ErrCorrupt = errors.New("spool: corrupt entry")
+)
+
+// Entry is a spooled message.
+type Entry struct {
+	Topic   string          `json:"topic"`
+	Message *broker.Message `json:"message"`
+	// DeliverAt is the delivery time of a delayed message.
+	DeliverAt time.Time `json:"deliver_at"`
+}
+
+// Store is a bounded FIFO buffer of messages.
+type Store interface {
+	// Push appends an entry, it returns ErrFull when the store is full.
+	Push(ctx context.Context, e *Entry) error
+	// Peek returns the oldest entry, it returns ErrEmpty when the store is
+	// empty and ErrCorrupt when the entry can't be decoded.
+	Peek(ctx context.Context) (*Entry, error)
+	// Pop removes the oldest entry.
+	Pop(ctx context.Context) error
+	// Len returns the number of entries.
+	Len(ctx context.Context) (int64, error)
+}
+
+var (
+	_ Store = (*FileStore)(nil)
+	_ Store = (*RedisStore)(nil)
+)
+
+// FileStore is a store keeping one file per entry in a directory, so
+// spooled messages survive a restart of the process.
+type FileStore struct {
+	mu    sync.Mutex
+	dir   string
+	limit int64
+	seqs  []uint64
+	next  uint64
+}
+
+// fileExt is the extension of the entry files.
+const fileExt = ".msg"
+
+// NewFileStore creates a store in dir holding up to limit entries, picking up
+// the entries left by a previous process.
+func NewFileStore(dir string, limit int64) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	s := &FileStore{dir: dir, limit: limit}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, fileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.seqs = append(s.seqs, seq)
+	}
+	sort.Slice(s.seqs, func(i, j int) bool { return s.seqs[i] < s.seqs[j] })
+	if n := len(s.seqs); n > 0 {
+		s.next = s.seqs[n-1] + 1
+	}
+	return s, nil
+}
+
+// Push implements Store.
+func (s *FileStore) Push(ctx context.Context, e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.limit > 0 && int64(len(s.seqs)) >= s.limit {
+		return ErrFull
+	}
+
+	// Write then rename so a crash never leaves a partial entry
+	path := s.path(s.next)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
+	s.seqs = append(s.seqs, s.next)
+	s.next++
+	return nil
+}
+
+// Peek implements Store.
+func (s *FileStore) Peek(ctx context.Context) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.seqs) == 0 {
+		return nil, ErrEmpty
+	}
+	data, err := os.ReadFile(s.path(s.seqs[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry: %w", err)
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return &e, nil
+}
+
+// Pop implements Store.
+func (s *FileStore) Pop(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.seqs) == 0 {
+		return nil
+	}
+	if err := os.Remove(s.path(s.seqs[0])); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove entry: %w", err)
+	}
+	s.seqs = s.seqs[1:]
+	return nil
+}
+
+// Len implements Store.
+func (s *FileStore) Len(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.seqs)), nil
+}
+
+// path returns the path of the entry file of seq, zero-padded so the files
+// list in order.
+func (s *FileStore) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, fileExt))
+}
+
+// pushScript appends to a list unless it holds ARGV[1] entries.
+var pushScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+if limit > 0 and redis.call("LLEN", KEYS[1]) >= limit then
+	return 0
+end
+redis.call("RPUSH", KEYS[1], ARGV[2])
+return 1
+`)
+
+// RedisStore is a store keeping entries in a Redis list. Each instance
+// replays its store on its own, so instances must not share a key.
+type RedisStore struct {
+	client redis.UniversalClient
+	key    string
+	limit  int64
+}
+
+// NewRedisStore creates a store in the list key holding up to limit entries.
+func NewRedisStore(client redis.UniversalClient, key string, limit int64) *RedisStore {
+	return &RedisStore{client: client, key: key, limit: limit}
+}
+
+// Push implements Store.
+func (s *RedisStore) Push(ctx context.Context, e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %w", err)
+	}
+	ok, err := pushScript.Run(ctx, s.client, []string{s.key}, s.limit, data).Bool()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrFull
+	}
+	return nil
+}
+
+// Peek implements Store.
+func (s *RedisStore) Peek(ctx context.Context) (*Entry, error) {
+	data, err := s.client.LIndex(ctx, s.key, 0).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return &e, nil
+}
+
+// Pop implements Store.
+func (s *RedisStore) Pop(ctx context.Context) error {
+	if err := s.client.LPop(ctx, s.key).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	return nil
+}
+
+// Len implements Store.
+func (s *RedisStore) Len(ctx context.Context) (int64, error) {
+	return s.client.LLen(ctx, s.key).Result()
+}
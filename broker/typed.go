@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/codec"
+)
+
+// Headers set on the messages sent to a dead letter topic by DeadLetter.
+const (
+	HeaderDecodeError   = "X-Decode-Error"
+	HeaderOriginalTopic = "X-Original-Topic"
+)
+
+// DecodeErrorHandler handles a message of topic that failed to decode. Its
+// error is returned to the broker, so the message is not acked.
+type DecodeErrorHandler func(ctx context.Context, topic string, msg *Message, err error) error
+
+// OnDecodeError sets the handler of the messages the typed subscriptions
+// fail to decode. By default they are logged and dropped, since they would
+// fail again when redelivered.
+func OnDecodeError(handler DecodeErrorHandler) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.DecodeErrorHandler = handler
+	}
+}
+
+// DeadLetter sends the messages the typed subscriptions fail to decode to
+// topic with b, recording the error and the original topic in the header.
+func DeadLetter(b Broker, topic string) SubscribeOption {
+	return OnDecodeError(func(ctx context.Context, from string, msg *Message, err error) error {
+		header := make(map[string]string, len(msg.Header)+2)
+		for k, v := range msg.Header {
+			header[k] = v
+		}
+		header[HeaderDecodeError] = err.Error()
+		header[HeaderOriginalTopic] = from
+		if perr := b.Publish(ctx, topic, &Message{Header: header, Body: msg.Body}); perr != nil {
+			return fmt.Errorf("failed to send message to dead letter topic %s: %w", topic, perr)
+		}
+		return nil
+	})
+}
+
+// PublishJSON publishes v encoded in JSON to topic.
+func PublishJSON[T any](ctx context.Context, b Broker, topic string, v T, opts ...PublishOption) error {
+	return PublishWith(ctx, b, codec.JSON, topic, v, opts...)
+}
+
+// PublishWith publishes v encoded with c to topic, the content type of c
+// recorded in the header.
+func PublishWith[T any](ctx context.Context, b Broker, c codec.Codec, topic string, v T, opts ...PublishOption) error {
+	body, err := c.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	msg := &Message{
+		Header: map[string]string{HeaderContentType: c.ContentType()},
+		Body:   body,
+	}
+	return b.Publish(ctx, topic, msg, opts...)
+}
+
+// SubscribeJSON subscribes handler to topic, the messages decoded from JSON
+// into T. The messages that fail to decode go to the handler set by
+// OnDecodeError or DeadLetter.
+func SubscribeJSON[T any](b Broker, topic string, handler func(context.Context, T) error, opts ...SubscribeOption) (Subscriber, error) {
+	return SubscribeWith(b, codec.JSON, topic, handler, opts...)
+}
+
+// SubscribeWith subscribes handler to topic, the messages decoded with c
+// into T, or with the codec of their content type header like Decode when
+// c is nil. The messages that fail to decode go to the handler set by
+// OnDecodeError or DeadLetter.
+func SubscribeWith[T any](b Broker, c codec.Codec, topic string, handler func(context.Context, T) error, opts ...SubscribeOption) (Subscriber, error) {
+	var options SubscribeOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	onError := options.DecodeErrorHandler
+	if onError == nil {
+		onError = dropMessage
+	}
+
+	return b.Subscribe(topic, func(ctx context.Context, msg *Message) error {
+		v, err := decodeAs[T](c, msg)
+		if err != nil {
+			return onError(ctx, topic, msg, err)
+		}
+		return handler(ctx, v)
+	}, opts...)
+}
+
+// decodeAs decodes msg into a new T, allocating the value T points to when
+// it is a pointer type, e.g. a protobuf message.
+func decodeAs[T any](c codec.Codec, msg *Message) (T, error) {
+	var v T
+	target := interface{}(&v)
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Pointer {
+		v = reflect.New(t.Elem()).Interface().(T)
+		target = v
+	}
+
+	if c == nil {
+		return v, Decode(msg, target)
+	}
+	if err := c.Unmarshal(msg.Body, target); err != nil {
+		return v, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return v, nil
+}
+
+// dropMessage is the default DecodeErrorHandler.
+func dropMessage(ctx context.Context, topic string, msg *Message, err error) error {
+	klog.CtxErrorf(ctx, "broker: dropping message of %s: %v", topic, err)
+	return nil
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"golang.org/x/sync/singleflight"
+	"new-milli/codec"
+)
+
+// ErrNotFound is returned when a key is not in the cache.
+var ErrNotFound = errors.New("cache: key not found")
+
+// Store is a cache backend storing encoded values.
+type Store interface {
+	// Get returns the value of key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set sets the value of key, expiring after ttl, 0 means never.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete deletes keys.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Adder is implemented by the stores able to set a value only when its key
+// isn't set, atomically, e.g. to take a lock.
+type Adder interface {
+	// Add sets the value of key, expiring after ttl, unless key is set, and
+	// reports whether it did.
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+}
+
+// Option is cache option.
+type Option func(*options)
+
+// options is cache options.
+type options struct {
+	codec  codec.Codec
+	prefix string
+	ttl    time.Duration
+}
+
+// WithCodec returns an Option that sets the codec of the values, JSON by default.
+func WithCodec(c codec.Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithPrefix returns an Option that sets the prefix of the keys.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithTTL returns an Option that sets the TTL of the values set with a 0 TTL.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// Cache stores values encoded with a codec in a Store.
+type Cache struct {
+	store Store
+	opts  options
+	group singleflight.Group
+}
+
+// New creates a cache backed by store.
+func New(store Store, opts ...Option) *Cache {
+	o := options{
+		codec: codec.JSON,
+		ttl:   time.Minute * 10,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Cache{store: store, opts: o}
+}
+
+// Store returns the backend of the cache.
+func (c *Cache) Store() Store {
+	return c.store
+}
+
+// Get decodes the value of key into v, or returns ErrNotFound.
+func (c *Cache) Get(ctx context.Context, key string, v interface{}) error {
+	data, err := c.store.Get(ctx, c.opts.prefix+key)
+	if err != nil {
+		return err
+	}
+	if err := c.opts.codec.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode cached %s: %w", key, err)
+	}
+	return nil
+}
+
+// Set sets the value of key, expiring after ttl, the cache TTL when 0.
+func (c *Cache) Set(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	data, err := c.opts.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	if ttl == 0 {
+		ttl = c.opts.ttl
+	}
+	return c.store.Set(ctx, c.opts.prefix+key, data, ttl)
+}
+
+// Delete deletes keys.
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if c.opts.prefix != "" {
+		prefixed := make([]string, len(keys))
+		for i, key := range keys {
+			prefixed[i] = c.opts.prefix + key
+		}
+		keys = prefixed
+	}
+	return c.store.Delete(ctx, keys...)
+}
+
+// GetOrLoad returns the cached value of key, or loads it with load and caches
+// it for ttl. Concurrent misses of the same key share a single load. A cache
+// failure doesn't fail the call, the value is loaded instead.
+func GetOrLoad[T any](ctx context.Context, c *Cache, key string, ttl time.Duration, load func(ctx context.Context) (T, error)) (T, error) {
+	var v T
+	err := c.Get(ctx, key, &v)
+	if err == nil {
+		return v, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		klog.CtxWarnf(ctx, "cache: failed to get %s: %v", key, err)
+	}
+
+	res, err, _ := c.group.Do(key, func() (interface{}, error) {
+		loaded, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Set(ctx, key, loaded, ttl); err != nil {
+			klog.CtxWarnf(ctx, "cache: failed to set %s: %v", key, err)
+		}
+		return loaded, nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return res.(T), nil
+}
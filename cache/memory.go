@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	_ Store = (*Memory)(nil)
+	_ Adder = (*Memory)(nil)
+)
+
+// Memory is an in-process LRU store, evicting the least recently used
+// values once it holds size values.
+type Memory struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// entry is a value of the memory store.
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory creates an LRU store holding up to size values, 10000 when size
+// isn't positive.
+func NewMemory(size int) *Memory {
+	if size <= 0 {
+		size = 10000
+	}
+	return &Memory{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get implements Store.
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		m.remove(el)
+		return nil, ErrNotFound
+	}
+	m.ll.MoveToFront(el)
+	return e.value, nil
+}
+
+// Set implements Store.
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, value, expires)
+	return nil
+}
+
+// Add implements Adder.
+func (m *Memory) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		e := el.Value.(*entry)
+		if e.expires.IsZero() || time.Now().Before(e.expires) {
+			return false, nil
+		}
+	}
+	m.set(key, value, expires)
+	return true, nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := m.items[key]; ok {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of values held, including expired ones not yet evicted.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ll.Len()
+}
+
+// set sets the value of key, m.mu must be held.
+func (m *Memory) set(key string, value []byte, expires time.Time) {
+	if el, ok := m.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		e.expires = expires
+		m.ll.MoveToFront(el)
+		return
+	}
+
+	m.items[key] = m.ll.PushFront(&entry{key: key, value: value, expires: expires})
+	for m.ll.Len() > m.size {
+		m.remove(m.ll.Back())
+	}
+}
+
+// remove removes an element, m.mu must be held.
+func (m *Memory) remove(el *list.Element) {
+	m.ll.Remove(el)
+	delete(m.items, el.Value.(*entry).key)
+}
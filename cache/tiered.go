@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ Store = (*Tiered)(nil)
+
+// TieredOption is two-tier store option.
+type TieredOption func(*tieredOptions)
+
+// tieredOptions is two-tier store options.
+type tieredOptions struct {
+	localTTL time.Duration
+	client   redis.UniversalClient
+	channel  string
+}
+
+// WithLocalTTL returns a TieredOption that caps how long values are kept in
+// the local tier, bounding their staleness when an invalidation is missed.
+func WithLocalTTL(ttl time.Duration) TieredOption {
+	return func(o *tieredOptions) {
+		o.localTTL = ttl
+	}
+}
+
+// WithInvalidation returns a TieredOption that publishes the keys set or
+// deleted on channel and evicts the keys published by the other instances
+// from the local tier.
+func WithInvalidation(client redis.UniversalClient, channel string) TieredOption {
+	return func(o *tieredOptions) {
+		o.client = client
+		o.channel = channel
+	}
+}
+
+// Tiered is a two-tier store reading from a local store, e.g. Memory, before
+// a shared remote store, e.g. Redis.
+type Tiered struct {
+	local  Store
+	remote Store
+	opts   tieredOptions
+	id     string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewTiered creates a two-tier store. With WithInvalidation it subscribes to
+// the invalidation channel until Close is called.
+func NewTiered(local, remote Store, opts ...TieredOption) *Tiered {
+	o := tieredOptions{
+		localTTL: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	t := &Tiered{
+		local:  local,
+		remote: remote,
+		opts:   o,
+		id:     uuid.NewString(),
+	}
+	if o.client != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.cancel = cancel
+		t.done = make(chan struct{})
+		go t.subscribe(ctx)
+	}
+	return t
+}
+
+// Get implements Store.
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	if data, err := t.local.Get(ctx, key); err == nil {
+		return data, nil
+	}
+
+	data, err := t.remote.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.local.Set(ctx, key, data, t.opts.localTTL); err != nil {
+		klog.CtxWarnf(ctx, "cache: failed to set %s locally: %v", key, err)
+	}
+	return data, nil
+}
+
+// Set implements Store.
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := t.remote.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	localTTL := t.opts.localTTL
+	if ttl > 0 && ttl < localTTL {
+		localTTL = ttl
+	}
+	if err := t.local.Set(ctx, key, value, localTTL); err != nil {
+		klog.CtxWarnf(ctx, "cache: failed to set %s locally: %v", key, err)
+	}
+	return t.publish(ctx, key)
+}
+
+// Delete implements Store.
+func (t *Tiered) Delete(ctx context.Context, keys ...string) error {
+	err := t.remote.Delete(ctx, keys...)
+	return errors.Join(err, t.local.Delete(ctx, keys...), t.publish(ctx, keys...))
+}
+
+// Close stops listening for invalidations.
+func (t *Tiered) Close() error {
+	if t.cancel != nil {
+		t.cancel()
+		<-t.done
+	}
+	return nil
+}
+
+// publish notifies the other instances that keys changed. A message is the
+// id of the instance followed by the keys, one per line.
+func (t *Tiered) publish(ctx context.Context, keys ...string) error {
+	if t.opts.client == nil || len(keys) == 0 {
+		return nil
+	}
+	msg := t.id + "\n" + strings.Join(keys, "\n")
+	return t.opts.client.Publish(ctx, t.opts.channel, msg).Err()
+}
+
+// subscribe evicts the keys changed by the other instances until ctx is done.
+func (t *Tiered) subscribe(ctx context.Context) {
+	defer close(t.done)
+
+	sub := t.opts.client.Subscribe(ctx, t.opts.channel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			lines := strings.Split(msg.Payload, "\n")
+			if len(lines) < 2 || lines[0] == t.id {
+				continue
+			}
+			if err := t.local.Delete(ctx, lines[1:]...); err != nil {
+				klog.Warnf("cache: failed to evict invalidated keys: %v", err)
+			}
+		}
+	}
+}
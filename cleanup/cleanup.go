@@ -0,0 +1,99 @@
+package cleanup
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// Scope holds the finalizers of a request.
+// Finalizers run in LIFO order, like deferred calls.
+type Scope struct {
+	mu   sync.Mutex
+	fns  []func()
+	done bool
+}
+
+// NewScope creates a new cleanup scope.
+func NewScope() *Scope {
+	return &Scope{}
+}
+
+// Add registers a finalizer. If the scope has already run,
+// the finalizer runs immediately.
+func (s *Scope) Add(fn func()) {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		call(fn)
+		return
+	}
+	s.fns = append(s.fns, fn)
+	s.mu.Unlock()
+}
+
+// Len returns the number of pending finalizers.
+func (s *Scope) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.fns)
+}
+
+// Run runs the pending finalizers in LIFO order.
+// A panicking finalizer doesn't prevent the others from running.
+func (s *Scope) Run() {
+	s.mu.Lock()
+	fns := s.fns
+	s.fns = nil
+	s.done = true
+	s.mu.Unlock()
+
+	for i := len(fns) - 1; i >= 0; i-- {
+		call(fns[i])
+	}
+}
+
+// call runs a finalizer and recovers from its panic.
+func call(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("cleanup: finalizer panic: %v", r)
+		}
+	}()
+	fn()
+}
+
+type scopeKey struct{}
+
+// NewContext returns a new Context that carries the scope.
+func NewContext(ctx context.Context, s *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+// FromContext returns the Scope value stored in ctx, if any.
+func FromContext(ctx context.Context) (s *Scope, ok bool) {
+	s, ok = ctx.Value(scopeKey{}).(*Scope)
+	return
+}
+
+// Register registers a finalizer on the scope of ctx.
+// It returns false if ctx carries no scope, the caller then owns the cleanup.
+func Register(ctx context.Context, fn func()) bool {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return false
+	}
+	s.Add(fn)
+	return true
+}
+
+// RegisterCloser registers c to be closed with the scope of ctx.
+func RegisterCloser(ctx context.Context, c io.Closer) bool {
+	return Register(ctx, func() {
+		if err := c.Close(); err != nil {
+			klog.Warnf("cleanup: failed to close %T: %v", c, err)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sony/gobreaker"
+	"new-milli/config"
+	"new-milli/middleware"
+	"new-milli/middleware/circuitbreaker"
+	"new-milli/middleware/retry"
+	"new-milli/middleware/timeout"
+	"new-milli/registry"
+	"new-milli/transport/http"
+)
+
+var (
+	// ErrUnknownDownstream is returned when a downstream isn't declared.
+	ErrUnknownDownstream = errors.New("unknown downstream")
+	// ErrNoFactory is returned by ForDownstream before SetDefault is called.
+	ErrNoFactory = errors.New("no default client factory")
+)
+
+// Option is client factory option.
+type Option func(*options)
+
+// options is client factory options.
+type options struct {
+	discovery     registry.Registry
+	middleware    []middleware.Middleware
+	clientOptions []http.ClientOption
+}
+
+// WithDiscovery sets the registry resolving "discovery:///" endpoints.
+func WithDiscovery(r registry.Registry) Option {
+	return func(o *options) {
+		o.discovery = r
+	}
+}
+
+// WithMiddleware sets the middleware wrapping the downstream policies,
+// e.g. tracing and metrics.
+func WithMiddleware(m ...middleware.Middleware) Option {
+	return func(o *options) {
+		o.middleware = m
+	}
+}
+
+// WithClientOptions sets HTTP client options applied to every downstream client.
+func WithClientOptions(opts ...http.ClientOption) Option {
+	return func(o *options) {
+		o.clientOptions = opts
+	}
+}
+
+// Factory creates the clients of the declared downstreams, configured with
+// their endpoint, timeout, retry and breaker policies.
+type Factory struct {
+	downstreams map[string]*Downstream
+	opts        options
+
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+// New creates a factory for the downstreams.
+func New(downstreams map[string]*Downstream, opts ...Option) *Factory {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Factory{
+		downstreams: downstreams,
+		opts:        o,
+		clients:     make(map[string]*http.Client),
+	}
+}
+
+// FromConfig creates a factory for the downstreams declared in the config.
+func FromConfig(cfg config.Config, opts ...Option) (*Factory, error) {
+	downstreams, err := Parse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return New(downstreams, opts...), nil
+}
+
+// Downstream returns the settings of a downstream.
+func (f *Factory) Downstream(name string) (*Downstream, bool) {
+	d, ok := f.downstreams[name]
+	return d, ok
+}
+
+// ForDownstream returns the client of a downstream, created on first use and
+// shared afterwards.
+func (f *Factory) ForDownstream(name string) (*http.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if c, ok := f.clients[name]; ok {
+		return c, nil
+	}
+
+	d, ok := f.downstreams[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDownstream, name)
+	}
+
+	opts := []http.ClientOption{
+		http.WithEndpoint(d.Endpoint),
+		http.WithMiddleware(f.middleware(d)...),
+	}
+	if d.Timeout > 0 {
+		opts = append(opts, http.WithTimeout(d.Timeout))
+	}
+	if f.opts.discovery != nil {
+		opts = append(opts, http.WithDiscovery(f.opts.discovery))
+	}
+	opts = append(opts, f.opts.clientOptions...)
+
+	c, err := http.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client of downstream %s: %w", name, err)
+	}
+	f.clients[name] = c
+	return c, nil
+}
+
+// middleware returns the middleware enforcing the policies of a downstream.
+// The timeout bounds the retries, and each attempt goes through the breaker.
+func (f *Factory) middleware(d *Downstream) []middleware.Middleware {
+	m := append([]middleware.Middleware(nil), f.opts.middleware...)
+
+	if d.Timeout > 0 {
+		m = append(m, timeout.Client(timeout.WithTimeout(d.Timeout)))
+	}
+
+	if d.Retry.Attempts > 1 {
+		opts := []retry.Option{retry.WithAttempts(d.Retry.Attempts)}
+		if d.Retry.Backoff > 0 || d.Retry.MaxBackoff > 0 {
+			opts = append(opts, retry.WithBackoff(d.Retry.Backoff, d.Retry.MaxBackoff))
+		}
+		m = append(m, retry.Client(opts...))
+	}
+
+	if !d.Breaker.Disabled {
+		name := "downstream_" + d.Name
+		opts := []circuitbreaker.Option{
+			circuitbreaker.WithName(name),
+			circuitbreaker.WithCircuitBreakerName(func(ctx context.Context) string { return name }),
+		}
+		b := d.Breaker
+		if b.MaxRequests > 0 {
+			opts = append(opts, circuitbreaker.WithMaxRequests(b.MaxRequests))
+		}
+		if b.Interval > 0 {
+			opts = append(opts, circuitbreaker.WithInterval(b.Interval))
+		}
+		if b.Timeout > 0 {
+			opts = append(opts, circuitbreaker.WithTimeout(b.Timeout))
+		}
+		if b.MinRequests > 0 || b.FailureRatio > 0 {
+			minRequests, ratio := b.MinRequests, b.FailureRatio
+			if minRequests == 0 {
+				minRequests = 10
+			}
+			if ratio == 0 {
+				ratio = 0.5
+			}
+			opts = append(opts, circuitbreaker.WithReadyToTrip(func(counts gobreaker.Counts) bool {
+				failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+				return counts.Requests >= minRequests && failureRatio >= ratio
+			}))
+		}
+		m = append(m, circuitbreaker.Client(opts...))
+	}
+
+	return m
+}
+
+// Close closes the created clients.
+func (f *Factory) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errs []error
+	for name, c := range f.clients {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(f.clients, name)
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultFactory *Factory
+)
+
+// SetDefault sets the factory used by ForDownstream.
+func SetDefault(f *Factory) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultFactory = f
+}
+
+// ForDownstream returns the client of a downstream from the default factory.
+func ForDownstream(name string) (*http.Client, error) {
+	defaultMu.RLock()
+	f := defaultFactory
+	defaultMu.RUnlock()
+
+	if f == nil {
+		return nil, ErrNoFactory
+	}
+	return f.ForDownstream(name)
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"new-milli/config"
+)
+
+// Section is the config section declaring the downstreams.
+const Section = "downstreams"
+
+// Retry is the retry policy of a downstream.
+type Retry struct {
+	// Attempts is the maximum number of attempts, 0 or 1 disables retries.
+	Attempts int
+	// Backoff is the initial backoff between attempts.
+	Backoff time.Duration
+	// MaxBackoff is the maximum backoff between attempts.
+	MaxBackoff time.Duration
+}
+
+// Breaker is the circuit breaker policy of a downstream.
+type Breaker struct {
+	// Disabled disables the circuit breaker.
+	Disabled bool
+	// MaxRequests is the number of requests allowed through when half-open.
+	MaxRequests uint32
+	// Interval is the period the counts are cleared at when closed.
+	Interval time.Duration
+	// Timeout is how long the breaker stays open.
+	Timeout time.Duration
+	// MinRequests is the number of requests before the breaker may trip.
+	MinRequests uint32
+	// FailureRatio is the failure ratio tripping the breaker.
+	FailureRatio float64
+}
+
+// Downstream is a dependency of the service.
+type Downstream struct {
+	// Name identifies the downstream.
+	Name string
+	// Endpoint is a base URL such as "https://payments.internal:8443",
+	// a comma separated list of addresses, or "discovery:///service-name".
+	Endpoint string
+	// Timeout bounds each call, retries included.
+	Timeout time.Duration
+	// Retry is the retry policy.
+	Retry Retry
+	// Breaker is the circuit breaker policy.
+	Breaker Breaker
+}
+
+// Validate checks the downstream settings.
+func (d *Downstream) Validate() error {
+	var errs []error
+	if d.Endpoint == "" {
+		errs = append(errs, errors.New("missing endpoint"))
+	}
+	if d.Timeout < 0 {
+		errs = append(errs, errors.New("negative timeout"))
+	}
+	if d.Retry.Attempts < 0 || d.Retry.Backoff < 0 || d.Retry.MaxBackoff < 0 {
+		errs = append(errs, errors.New("negative retry setting"))
+	}
+	if d.Breaker.FailureRatio < 0 || d.Breaker.FailureRatio > 1 {
+		errs = append(errs, errors.New("failure ratio must be between 0 and 1"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("downstream %s: %w", d.Name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Parse reads the downstreams declared in the config section, e.g.
+//
+//	downstreams:
+//	  payments:
+//	    endpoint: https://payments.internal:8443
+//	    timeout: 800ms
+//	    retry:
+//	      attempts: 3
+//	      backoff: 50ms
+//	      max_backoff: 500ms
+//	    breaker:
+//	      min_requests: 20
+//	      failure_ratio: 0.5
+//	      timeout: 30s
+//
+// Durations are Go duration strings, plain numbers are milliseconds.
+// The config must be able to list its keys, like config.DefaultConfig.
+func Parse(cfg config.Config) (map[string]*Downstream, error) {
+	lister, ok := cfg.(interface{ Keys() []string })
+	if !ok {
+		return nil, fmt.Errorf("config %T can't list its keys", cfg)
+	}
+
+	fields := make(map[string]map[string]interface{})
+	for _, key := range lister.Keys() {
+		rest, ok := strings.CutPrefix(key, Section+".")
+		if !ok {
+			continue
+		}
+		name, field, ok := strings.Cut(rest, ".")
+		if !ok {
+			continue
+		}
+		value, err := cfg.Get(key)
+		if err != nil {
+			continue
+		}
+		if fields[name] == nil {
+			fields[name] = make(map[string]interface{})
+		}
+		fields[name][field] = value
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	downstreams := make(map[string]*Downstream, len(fields))
+	var errs []error
+	for _, name := range names {
+		d, err := decode(name, fields[name])
+		if err == nil {
+			err = d.Validate()
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		downstreams[name] = d
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid downstreams: %w", errors.Join(errs...))
+	}
+	return downstreams, nil
+}
+
+// decode builds a downstream from its flattened fields.
+func decode(name string, fields map[string]interface{}) (*Downstream, error) {
+	d := &Downstream{Name: name}
+	var errs []error
+	for _, field := range sortedFields(fields) {
+		value := fields[field]
+		var err error
+		switch field {
+		case "endpoint":
+			d.Endpoint, err = toString(value)
+		case "timeout":
+			d.Timeout, err = toDuration(value)
+		case "retry.attempts":
+			d.Retry.Attempts, err = toInt(value)
+		case "retry.backoff":
+			d.Retry.Backoff, err = toDuration(value)
+		case "retry.max_backoff":
+			d.Retry.MaxBackoff, err = toDuration(value)
+		case "breaker.disabled":
+			d.Breaker.Disabled, err = toBool(value)
+		case "breaker.max_requests":
+			var n int
+			n, err = toInt(value)
+			d.Breaker.MaxRequests = uint32(n)
+		case "breaker.interval":
+			d.Breaker.Interval, err = toDuration(value)
+		case "breaker.timeout":
+			d.Breaker.Timeout, err = toDuration(value)
+		case "breaker.min_requests":
+			var n int
+			n, err = toInt(value)
+			d.Breaker.MinRequests = uint32(n)
+		case "breaker.failure_ratio":
+			d.Breaker.FailureRatio, err = toFloat(value)
+		default:
+			err = errors.New("unknown field")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("downstream %s: %w", name, errors.Join(errs...))
+	}
+	return d, nil
+}
+
+// sortedFields returns the field names in order.
+func sortedFields(fields map[string]interface{}) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// toString converts a config value to a string.
+func toString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", config.ErrInvalidType
+}
+
+// toInt converts a config value to an int.
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case uint64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	}
+	return 0, config.ErrInvalidType
+}
+
+// toFloat converts a config value to a float64.
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	}
+	return 0, config.ErrInvalidType
+}
+
+// toBool converts a config value to a bool.
+func toBool(v interface{}) (bool, error) {
+	if b, ok := v.(bool); ok {
+		return b, nil
+	}
+	return false, config.ErrInvalidType
+}
+
+// toDuration converts a duration string or a number of milliseconds.
+func toDuration(v interface{}) (time.Duration, error) {
+	if s, ok := v.(string); ok {
+		return time.ParseDuration(s)
+	}
+	ms, err := toFloat(v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
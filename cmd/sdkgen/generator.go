@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"text/template"
+)
+
+// clientTemplate is the template of the generated client.
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by sdkgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{- if .NeedsFmt}}
+	"fmt"
+{{- end}}
+{{- if .NeedsURL}}
+	"net/url"
+{{- end}}
+
+	"new-milli/middleware/circuitbreaker"
+	"new-milli/middleware/retry"
+	"new-milli/middleware/tracing"
+	mhttp "new-milli/transport/http"
+)
+
+// ServiceName is the registry name of the {{.Service}} service.
+const ServiceName = "{{.Service}}"
+{{range .Types}}
+// {{.Name}} is the {{.Name}} schema.{{if .Description}}
+// {{.Description}}{{end}}
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}} {{.Tag}}
+{{- end}}
+}
+{{end}}
+{{- range .Operations}}{{if .HasRequest}}
+// {{.Name}}Request is the request of {{.Name}}.
+type {{.Name}}Request struct {
+{{- range .Params}}
+	{{.Name}} {{.Type}}
+{{- end}}
+{{- if .BodyType}}
+	Body {{.BodyType}}
+{{- end}}
+}
+{{end}}{{end}}
+// Client is a client of the {{.Service}} service.
+type Client struct {
+	cc *mhttp.Client
+}
+
+// NewClient creates a client of the {{.Service}} service resolved through discovery.
+// Tracing, retries and circuit breaking are enabled by default, the
+// requests of non-idempotent methods are only retried for the operations
+// marked with x-idempotent. opts are applied afterwards and may replace them.
+func NewClient(ctx context.Context, opts ...mhttp.ClientOption) (*Client, error) {
+	options := []mhttp.ClientOption{
+		mhttp.WithEndpoint("discovery:///" + ServiceName),
+		mhttp.WithMiddleware(
+			tracing.Client(),
+			retry.Client(),
+			circuitbreaker.Client(),
+		),
+	}
+	cc, err := mhttp.NewClient(ctx, append(options, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{cc: cc}, nil
+}
+
+// Close closes the client.
+func (c *Client) Close() error {
+	return c.cc.Close()
+}
+{{range .Operations}}
+// {{.Name}} calls {{.Method}} {{.Path}}.{{if .Summary}}
+// {{.Summary}}{{end}}
+func (c *Client) {{.Name}}(ctx context.Context{{if .HasRequest}}, in *{{.Name}}Request{{end}}) ({{if .ReplyType}}{{.ReplyType}}, {{end}}error) {
+{{- if .Idempotent}}
+	ctx = retry.Idempotent(ctx)
+{{- end}}
+	path := {{.PathExpr}}
+{{- if .QueryParams}}
+	query := url.Values{}
+{{- range .QueryParams}}
+	{{.QueryStmt}}
+{{- end}}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+{{- end}}
+{{- if .OptionParams}}
+	var opts []mhttp.CallOption
+{{- range .OptionParams}}
+	{{.OptionStmt}}
+{{- end}}
+{{- end}}
+{{- if and .BodyType (not .BodyNillable)}}
+	var body interface{} = in.Body
+{{- else}}
+	var body interface{}
+{{- if .BodyType}}
+	if in.Body != nil {
+		body = in.Body
+	}
+{{- end}}
+{{- end}}
+{{- if .ReplyType}}
+	var out {{.ReplyType}}
+	err := c.cc.Invoke(ctx, "{{.Method}}", path, body, &out{{if .OptionParams}}, opts...{{end}})
+	return out, err
+{{- else}}
+	return c.cc.Invoke(ctx, "{{.Method}}", path, body, nil{{if .OptionParams}}, opts...{{end}})
+{{- end}}
+}
+{{end}}`))
+
+// NeedsFmt reports whether the generated code uses fmt, derived from the
+// statements the operations emit.
+func (m *model) NeedsFmt() bool {
+	for _, o := range m.Operations {
+		if strings.Contains(o.PathExpr(), "fmt.") {
+			return true
+		}
+		for _, p := range o.QueryParams() {
+			if strings.Contains(p.QueryStmt(), "fmt.") {
+				return true
+			}
+		}
+		for _, p := range o.OptionParams() {
+			if strings.Contains(p.OptionStmt(), "fmt.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NeedsURL reports whether the generated code uses net/url, to escape the
+// path parameters or encode the query.
+func (m *model) NeedsURL() bool {
+	for _, o := range m.Operations {
+		for _, p := range o.Params {
+			if p.In == "path" || p.In == "query" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// QueryParams returns the query parameters of the operation.
+func (o *operationModel) QueryParams() []*fieldModel {
+	var params []*fieldModel
+	for _, p := range o.Params {
+		if p.In == "query" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// OptionParams returns the header and cookie parameters of the operation,
+// sent with call options.
+func (o *operationModel) OptionParams() []*fieldModel {
+	var params []*fieldModel
+	for _, p := range o.Params {
+		if p.In == "header" || p.In == "cookie" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// BodyNillable reports whether the body type can be nil, the scalar bodies
+// are always sent.
+func (o *operationModel) BodyNillable() bool {
+	t := o.BodyType
+	return strings.HasPrefix(t, "*") || strings.HasPrefix(t, "[]") || strings.HasPrefix(t, "map[") || t == "interface{}"
+}
+
+// PathExpr returns the Go expression building the request path.
+func (o *operationModel) PathExpr() string {
+	var parts []string
+	rest := o.Path
+	for {
+		start := strings.Index(rest, "{")
+		end := strings.Index(rest, "}")
+		if start < 0 || end < start {
+			break
+		}
+		if start > 0 {
+			parts = append(parts, fmt.Sprintf("%q", rest[:start]))
+		}
+		name := rest[start+1 : end]
+		for _, p := range o.Params {
+			if p.In == "path" && p.Param == name {
+				parts = append(parts, fmt.Sprintf("url.PathEscape(fmt.Sprint(in.%s))", p.Name))
+			}
+		}
+		rest = rest[end+1:]
+	}
+	if rest != "" || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%q", rest))
+	}
+	return strings.Join(parts, " + ")
+}
+
+// QueryStmt returns the Go statement adding the parameter to the query.
+func (p *fieldModel) QueryStmt() string {
+	if strings.HasPrefix(p.Type, "[]") {
+		return p.setStmt("query.Add(%q, %s)")
+	}
+	return p.setStmt("query.Set(%q, %s)")
+}
+
+// OptionStmt returns the Go statement adding the header or cookie
+// parameter to the call options.
+func (p *fieldModel) OptionStmt() string {
+	if p.In == "cookie" {
+		return p.setStmt("opts = append(opts, mhttp.Cookie(%q, %s))")
+	}
+	return p.setStmt("opts = append(opts, mhttp.Header(%q, %s))")
+}
+
+// setStmt returns the Go statement setting the parameter with set, a format
+// taking the parameter name and its string value. The zero values of the
+// optional parameters are left out.
+func (p *fieldModel) setStmt(set string) string {
+	field := "in." + p.Name
+	switch {
+	case strings.HasPrefix(p.Type, "[]"):
+		return fmt.Sprintf("for _, v := range %s {\n\t\t%s\n\t}", field, fmt.Sprintf(set, p.Param, "fmt.Sprint(v)"))
+	case p.Required && p.Type == "string":
+		return fmt.Sprintf(set, p.Param, field)
+	case p.Required:
+		return fmt.Sprintf(set, p.Param, "fmt.Sprint("+field+")")
+	case p.Type == "string":
+		return fmt.Sprintf("if %s != \"\" {\n\t\t%s\n\t}", field, fmt.Sprintf(set, p.Param, field))
+	case p.Type == "bool":
+		return fmt.Sprintf("if %s {\n\t\t%s\n\t}", field, fmt.Sprintf(set, p.Param, `"true"`))
+	case strings.HasPrefix(p.Type, "int"), strings.HasPrefix(p.Type, "float"):
+		return fmt.Sprintf("if %s != 0 {\n\t\t%s\n\t}", field, fmt.Sprintf(set, p.Param, "fmt.Sprint("+field+")"))
+	default:
+		return fmt.Sprintf("if %s != nil {\n\t\t%s\n\t}", field, fmt.Sprintf(set, p.Param, "fmt.Sprint("+field+")"))
+	}
+}
+
+// generate generates the client source of an OpenAPI document.
+func generate(spec []byte, pkg, service string) ([]byte, error) {
+	doc, err := parseDocument(spec)
+	if err != nil {
+		return nil, err
+	}
+	if service == "" {
+		service = doc.Info.Title
+	}
+	if service == "" {
+		return nil, fmt.Errorf("service name is required")
+	}
+
+	m, err := buildModel(doc, pkg, service)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := clientTemplate.Execute(&buf, m); err != nil {
+		return nil, fmt.Errorf("failed to render client: %w", err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format client: %w", err)
+	}
+	return src, nil
+}
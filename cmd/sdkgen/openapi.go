@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// document is the subset of an OpenAPI 3 document used by the generator.
+type document struct {
+	Info struct {
+		Title string `yaml:"title"`
+	} `yaml:"info"`
+	Paths      map[string]*pathItem `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// pathItem is an OpenAPI path item.
+type pathItem struct {
+	Parameters []*parameter `yaml:"parameters"`
+	Get        *operation   `yaml:"get"`
+	Put        *operation   `yaml:"put"`
+	Post       *operation   `yaml:"post"`
+	Delete     *operation   `yaml:"delete"`
+	Patch      *operation   `yaml:"patch"`
+}
+
+// operation is an OpenAPI operation.
+type operation struct {
+	OperationID string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Parameters  []*parameter         `yaml:"parameters"`
+	RequestBody *body                `yaml:"requestBody"`
+	Responses   map[string]*response `yaml:"responses"`
+	// Idempotent marks a POST or PATCH operation as safe to retry.
+	Idempotent bool `yaml:"x-idempotent"`
+}
+
+// parameter is an OpenAPI parameter.
+type parameter struct {
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *schema `yaml:"schema"`
+}
+
+// body is an OpenAPI request body.
+type body struct {
+	Content map[string]*mediaType `yaml:"content"`
+}
+
+// response is an OpenAPI response.
+type response struct {
+	Content map[string]*mediaType `yaml:"content"`
+}
+
+// mediaType is an OpenAPI media type.
+type mediaType struct {
+	Schema *schema `yaml:"schema"`
+}
+
+// schema is an OpenAPI schema.
+type schema struct {
+	Ref         string             `yaml:"$ref"`
+	Type        string             `yaml:"type"`
+	Format      string             `yaml:"format"`
+	Description string             `yaml:"description"`
+	Items       *schema            `yaml:"items"`
+	Properties  map[string]*schema `yaml:"properties"`
+	Required    []string           `yaml:"required"`
+}
+
+// parseDocument parses a JSON or YAML OpenAPI document.
+func parseDocument(data []byte) (*document, error) {
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	if len(doc.Paths) == 0 {
+		return nil, fmt.Errorf("OpenAPI document has no paths")
+	}
+	return &doc, nil
+}
+
+// model is the input of the client template.
+type model struct {
+	Package    string
+	Service    string
+	Types      []*typeModel
+	Operations []*operationModel
+}
+
+// typeModel is a generated struct.
+type typeModel struct {
+	Name        string
+	Description string
+	Fields      []*fieldModel
+}
+
+// fieldModel is a field of a generated struct.
+type fieldModel struct {
+	Name     string
+	Type     string
+	Tag      string
+	Param    string
+	In       string
+	Required bool
+}
+
+// operationModel is a generated client method.
+type operationModel struct {
+	Name       string
+	Summary    string
+	Method     string
+	Path       string
+	Params     []*fieldModel
+	BodyType   string
+	ReplyType  string
+	Idempotent bool
+}
+
+// HasRequest reports whether the method takes a request struct.
+func (o *operationModel) HasRequest() bool {
+	return len(o.Params) > 0 || o.BodyType != ""
+}
+
+// buildModel converts the document into the template model.
+func buildModel(doc *document, pkg, service string) (*model, error) {
+	m := &model{
+		Package: pkg,
+		Service: service,
+	}
+
+	// Schemas
+	for _, name := range sortedKeys(doc.Components.Schemas) {
+		s := doc.Components.Schemas[name]
+		t := &typeModel{
+			Name:        exportName(name),
+			Description: s.Description,
+		}
+		for _, prop := range sortedKeys(s.Properties) {
+			omit := ",omitempty"
+			if contains(s.Required, prop) {
+				omit = ""
+			}
+			t.Fields = append(t.Fields, &fieldModel{
+				Name: exportName(prop),
+				Type: goType(s.Properties[prop], true),
+				Tag:  fmt.Sprintf("`json:\"%s%s\"`", prop, omit),
+			})
+		}
+		m.Types = append(m.Types, t)
+	}
+
+	// Operations
+	seen := make(map[string]bool)
+	for _, path := range sortedKeys(doc.Paths) {
+		item := doc.Paths[path]
+		for _, entry := range []struct {
+			method string
+			op     *operation
+		}{
+			{"GET", item.Get},
+			{"PUT", item.Put},
+			{"POST", item.Post},
+			{"DELETE", item.Delete},
+			{"PATCH", item.Patch},
+		} {
+			if entry.op == nil {
+				continue
+			}
+			op, err := buildOperation(entry.method, path, item, entry.op)
+			if err != nil {
+				return nil, err
+			}
+			if seen[op.Name] {
+				return nil, fmt.Errorf("duplicate operation name %s", op.Name)
+			}
+			seen[op.Name] = true
+			m.Operations = append(m.Operations, op)
+		}
+	}
+
+	return m, nil
+}
+
+// buildOperation converts an OpenAPI operation into a client method.
+func buildOperation(method, path string, item *pathItem, op *operation) (*operationModel, error) {
+	name := op.OperationID
+	if name == "" {
+		name = strings.ToLower(method) + " " + path
+	}
+
+	o := &operationModel{
+		Name:       exportName(name),
+		Summary:    op.Summary,
+		Method:     method,
+		Path:       path,
+		Idempotent: op.Idempotent && (method == "POST" || method == "PATCH"),
+	}
+
+	// Path level parameters apply to every operation
+	params := append(append([]*parameter{}, item.Parameters...), op.Parameters...)
+	for _, p := range params {
+		switch p.In {
+		case "path", "query", "header", "cookie":
+		default:
+			return nil, fmt.Errorf("%s %s: parameter %s has unsupported location %q", method, path, p.Name, p.In)
+		}
+		o.Params = append(o.Params, &fieldModel{
+			Name:     exportName(p.Name),
+			Type:     goType(p.Schema, false),
+			Param:    p.Name,
+			In:       p.In,
+			Required: p.Required || p.In == "path",
+		})
+	}
+	for _, p := range o.Params {
+		if p.In == "path" && !strings.Contains(path, "{"+p.Param+"}") {
+			return nil, fmt.Errorf("%s %s: path parameter %s not in path", method, path, p.Param)
+		}
+	}
+
+	if op.RequestBody != nil {
+		if mt := jsonContent(op.RequestBody.Content); mt != nil && mt.Schema != nil {
+			o.BodyType = goType(mt.Schema, true)
+		}
+	}
+
+	for _, code := range []string{"200", "201", "202", "default"} {
+		resp, ok := op.Responses[code]
+		if !ok {
+			continue
+		}
+		if mt := jsonContent(resp.Content); mt != nil && mt.Schema != nil {
+			o.ReplyType = goType(mt.Schema, true)
+		}
+		break
+	}
+
+	return o, nil
+}
+
+// jsonContent returns the JSON media type of a content map.
+func jsonContent(content map[string]*mediaType) *mediaType {
+	for ct, mt := range content {
+		if strings.HasPrefix(ct, "application/json") {
+			return mt
+		}
+	}
+	return nil
+}
+
+// goType returns the Go type of a schema.
+// References are pointers when ref is true.
+func goType(s *schema, ref bool) string {
+	if s == nil {
+		return "interface{}"
+	}
+	if s.Ref != "" {
+		name := exportName(s.Ref[strings.LastIndex(s.Ref, "/")+1:])
+		if ref {
+			return "*" + name
+		}
+		return name
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer":
+		if s.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		if s.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + goType(s.Items, ref)
+	case "object":
+		return "map[string]interface{}"
+	}
+	return "interface{}"
+}
+
+// exportName converts an identifier such as "get-user_by id" into "GetUserById".
+func exportName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
+
+// contains reports whether s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Built-in codecs, registered by default.
+var (
+	// JSON is the JSON codec.
+	JSON Codec = jsonCodec{}
+	// Proto is the protobuf codec, it only handles proto.Message values.
+	Proto Codec = protoCodec{}
+	// Msgpack is the MessagePack codec.
+	Msgpack Codec = msgpackCodec{}
+	// Form is the URL-encoded form codec.
+	Form Codec = formCodec{}
+)
+
+func init() {
+	// The built-in codecs with the common aliases of their content types
+	Register(JSON, "text/json")
+	Register(Proto, "application/x-protobuf", "application/vnd.google.protobuf")
+	Register(Msgpack, "application/x-msgpack", "application/vnd.msgpack")
+	Register(Form)
+}
+
+// jsonCodec is the JSON codec.
+type jsonCodec struct{}
+
+// Marshal implements Codec.
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements Codec.
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name implements Codec.
+func (jsonCodec) Name() string { return "json" }
+
+// ContentType implements Codec.
+func (jsonCodec) ContentType() string { return "application/json" }
+
+// protoCodec is the protobuf codec.
+type protoCodec struct{}
+
+// Marshal implements Codec.
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal implements Codec.
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// Name implements Codec.
+func (protoCodec) Name() string { return "proto" }
+
+// ContentType implements Codec.
+func (protoCodec) ContentType() string { return "application/protobuf" }
+
+// msgpackCodec is the MessagePack codec.
+type msgpackCodec struct{}
+
+// Marshal implements Codec.
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal implements Codec.
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// Name implements Codec.
+func (msgpackCodec) Name() string { return "msgpack" }
+
+// ContentType implements Codec.
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
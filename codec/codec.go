@@ -0,0 +1,114 @@
+package codec
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Codec marshals and unmarshals values in a serialization format.
+type Codec interface {
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal parses data into v.
+	Unmarshal(data []byte, v interface{}) error
+	// Name returns the name of the codec, e.g. "json".
+	Name() string
+	// ContentType returns the media type of the encoding, e.g. "application/json".
+	ContentType() string
+}
+
+// registry is the registered codecs.
+var registry = struct {
+	sync.RWMutex
+	byName        map[string]Codec
+	byContentType map[string]Codec
+}{
+	byName:        make(map[string]Codec),
+	byContentType: make(map[string]Codec),
+}
+
+// Register registers c under its name and content type, replacing the codec
+// registered before with the same name or content type. Extra content types
+// c is also used for, e.g. "application/x-protobuf", may be given.
+func Register(c Codec, contentTypes ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.byName[c.Name()] = c
+	registry.byContentType[mediaType(c.ContentType())] = c
+	for _, ct := range contentTypes {
+		registry.byContentType[mediaType(ct)] = c
+	}
+}
+
+// Get returns the codec registered under name, or nil.
+func Get(name string) Codec {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.byName[name]
+}
+
+// ForContentType returns the codec registered for the media type of
+// contentType, parameters such as charset are ignored. Structured syntax
+// suffixes fall back to their base format, e.g. "application/problem+json"
+// uses the JSON codec. It returns nil when no codec matches.
+func ForContentType(contentType string) Codec {
+	mt := mediaType(contentType)
+
+	registry.RLock()
+	defer registry.RUnlock()
+	if c, ok := registry.byContentType[mt]; ok {
+		return c
+	}
+	if i := strings.LastIndexByte(mt, '+'); i >= 0 {
+		return registry.byName[mt[i+1:]]
+	}
+	return nil
+}
+
+// Negotiate returns the registered codec preferred by an Accept header, or
+// fallback when the header is empty, accepts anything or matches no codec.
+func Negotiate(accept string, fallback Codec) Codec {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mediaType: mt, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if r.mediaType == "*/*" || strings.HasSuffix(r.mediaType, "/*") {
+			return fallback
+		}
+		if c := ForContentType(r.mediaType); c != nil {
+			return c
+		}
+	}
+	return fallback
+}
+
+// mediaType returns the lower-cased media type of a content type.
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
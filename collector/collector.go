@@ -0,0 +1,27 @@
+package collector
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers c with registry, the default registerer when nil, and
+// returns it. When an equal collector of the same type is already
+// registered, e.g. by another server or client built with the same
+// options, that collector is returned instead so both share the series.
+func Register[C prometheus.Collector](registry prometheus.Registerer, c C) (C, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	if err := registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
@@ -2,13 +2,18 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 )
 
 var (
-	// ErrNotFound is returned when a key is not found
+	// ErrNotFound is returned when a key is not found, getters return a KeyError
+	// wrapping it
 	ErrNotFound = errors.New("key not found in config")
-	// ErrInvalidType is returned when a type assertion fails
+	// ErrInvalidType is returned when a type assertion fails, getters return a
+	// TypeError wrapping it
 	ErrInvalidType = errors.New("invalid type assertion")
 )
 
@@ -34,6 +39,9 @@ type Config interface {
 	GetStringMapString(key string) (map[string]string, error)
 	// Has checks if the key exists
 	Has(key string) bool
+	// Origin returns the name of the source supplying the value of the key,
+	// e.g. "env:APP_", or the names of the sources merged into it
+	Origin(key string) (string, error)
 	// Load loads configuration from a source
 	Load() error
 	// Watch watches for changes in the configuration
@@ -45,15 +53,43 @@ type Config interface {
 // DefaultConfig is the default implementation of Config
 type DefaultConfig struct {
 	sync.RWMutex
-	values map[string]interface{}
-	source Source
+	values  map[string]interface{}
+	origins map[string][]string
+	source  Source
+	options *configOptions
+}
+
+// Option is a function that configures a Config
+type Option func(*configOptions)
+
+type configOptions struct {
+	decryptors map[string]Decryptor
+}
+
+// WithDecryptor makes Load decrypt the ENC(scheme:payload) values of the
+// scheme with d, e.g. WithDecryptor(SchemeAESGCM, d). Once a decryptor is
+// set, a value of a scheme without one fails the load
+func WithDecryptor(scheme string, d Decryptor) Option {
+	return func(o *configOptions) {
+		if o.decryptors == nil {
+			o.decryptors = make(map[string]Decryptor)
+		}
+		o.decryptors[scheme] = d
+	}
 }
 
 // NewConfig creates a new Config with the given source
-func NewConfig(source Source) Config {
+func NewConfig(source Source, opts ...Option) Config {
+	options := &configOptions{}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	return &DefaultConfig{
-		values: make(map[string]interface{}),
-		source: source,
+		values:  make(map[string]interface{}),
+		source:  source,
+		options: options,
 	}
 }
 
@@ -66,7 +102,7 @@ func (c *DefaultConfig) Get(key string) (interface{}, error) {
 		return value, nil
 	}
 
-	return nil, ErrNotFound
+	return nil, &KeyError{Key: key}
 }
 
 // Set sets the value for the key
@@ -75,6 +111,10 @@ func (c *DefaultConfig) Set(key string, value interface{}) error {
 	defer c.Unlock()
 
 	c.values[key] = value
+	if c.origins == nil {
+		c.origins = make(map[string][]string)
+	}
+	c.origins[key] = []string{"set"}
 	return nil
 }
 
@@ -89,7 +129,7 @@ func (c *DefaultConfig) GetString(key string) (string, error) {
 		return str, nil
 	}
 
-	return "", ErrInvalidType
+	return "", newTypeError(key, value, "string")
 }
 
 // GetInt returns the value associated with the key as an int
@@ -108,9 +148,14 @@ func (c *DefaultConfig) GetInt(key string) (int, error) {
 		return int(v), nil
 	case float64:
 		return int(v), nil
+	case string:
+		// Values from the environment are strings
+		if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return i, nil
+		}
 	}
 
-	return 0, ErrInvalidType
+	return 0, newTypeError(key, value, "int")
 }
 
 // GetBool returns the value associated with the key as a bool
@@ -120,11 +165,16 @@ func (c *DefaultConfig) GetBool(key string) (bool, error) {
 		return false, err
 	}
 
-	if b, ok := value.(bool); ok {
-		return b, nil
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b, nil
+		}
 	}
 
-	return false, ErrInvalidType
+	return false, newTypeError(key, value, "bool")
 }
 
 // GetFloat returns the value associated with the key as a float64
@@ -145,9 +195,13 @@ func (c *DefaultConfig) GetFloat(key string) (float64, error) {
 		return float64(v), nil
 	case int64:
 		return float64(v), nil
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f, nil
+		}
 	}
 
-	return 0, ErrInvalidType
+	return 0, newTypeError(key, value, "float64")
 }
 
 // GetStringMap returns the value associated with the key as a map[string]interface{}
@@ -161,7 +215,7 @@ func (c *DefaultConfig) GetStringMap(key string) (map[string]interface{}, error)
 		return m, nil
 	}
 
-	return nil, ErrInvalidType
+	return nil, newTypeError(key, value, "map[string]interface{}")
 }
 
 // GetStringSlice returns the value associated with the key as a []string
@@ -180,13 +234,13 @@ func (c *DefaultConfig) GetStringSlice(key string) ([]string, error) {
 			if str, ok := item.(string); ok {
 				result[i] = str
 			} else {
-				return nil, ErrInvalidType
+				return nil, newTypeError(fmt.Sprintf("%s[%d]", key, i), item, "string")
 			}
 		}
 		return result, nil
 	}
 
-	return nil, ErrInvalidType
+	return nil, newTypeError(key, value, "[]string")
 }
 
 // GetStringMapString returns the value associated with the key as a map[string]string
@@ -205,13 +259,13 @@ func (c *DefaultConfig) GetStringMapString(key string) (map[string]string, error
 			if str, ok := val.(string); ok {
 				result[k] = str
 			} else {
-				return nil, ErrInvalidType
+				return nil, newTypeError(key+"."+k, val, "string")
 			}
 		}
 		return result, nil
 	}
 
-	return nil, ErrInvalidType
+	return nil, newTypeError(key, value, "map[string]string")
 }
 
 // Has checks if the key exists
@@ -223,20 +277,65 @@ func (c *DefaultConfig) Has(key string) bool {
 	return ok
 }
 
+// Keys returns the loaded keys, e.g. to enumerate the entries of a section
+func (c *DefaultConfig) Keys() []string {
+	c.RLock()
+	defer c.RUnlock()
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 // Load loads configuration from a source
 func (c *DefaultConfig) Load() error {
 	c.Lock()
 	defer c.Unlock()
 
-	values, err := c.source.Read()
+	var (
+		values  map[string]interface{}
+		origins map[string][]string
+		err     error
+	)
+	if r, ok := c.source.(originReader); ok {
+		values, origins, err = r.readOrigins()
+	} else {
+		values, err = c.source.Read()
+	}
 	if err != nil {
 		return err
 	}
 
-	c.values = values
+	if c.options != nil && len(c.options.decryptors) > 0 {
+		decrypted, err := decryptValues(values, c.options.decryptors)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt config: %w", err)
+		}
+		values = decrypted.(map[string]interface{})
+	}
+
+	c.values, c.origins = values, origins
 	return nil
 }
 
+// Origin returns the name of the source supplying the value of the key. The
+// values merged from several sources of a CompositeSource return their names
+// joined by commas, highest priority first, and the values of Set return "set"
+func (c *DefaultConfig) Origin(key string) (string, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if _, ok := c.values[key]; !ok {
+		return "", &KeyError{Key: key}
+	}
+	if names, ok := c.origins[key]; ok {
+		return strings.Join(names, ", "), nil
+	}
+	return sourceName(c.source), nil
+}
+
 // Watch watches for changes in the configuration
 func (c *DefaultConfig) Watch() (<-chan struct{}, error) {
 	return c.source.Watch()
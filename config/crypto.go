@@ -0,0 +1,157 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SchemeAESGCM is the scheme of the values encrypted with EncryptAESGCM
+const SchemeAESGCM = "AES-GCM"
+
+// Decryptor decrypts the payload of the ENC(scheme:payload) values of a
+// scheme, e.g. with a key from the environment or a KMS
+type Decryptor interface {
+	// Decrypt returns the plaintext of payload
+	Decrypt(payload string) (string, error)
+}
+
+// DecryptorFunc is a function implementing Decryptor
+type DecryptorFunc func(payload string) (string, error)
+
+// Decrypt implements Decryptor
+func (f DecryptorFunc) Decrypt(payload string) (string, error) {
+	return f(payload)
+}
+
+// aesGCM decrypts the values encrypted with EncryptAESGCM
+type aesGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCMDecryptor creates a Decryptor of the SchemeAESGCM values
+// encrypted with key, a 16, 24 or 32 byte AES key
+func NewAESGCMDecryptor(key []byte) (Decryptor, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCM{aead: aead}, nil
+}
+
+// NewAESGCMDecryptorFromEnv creates a Decryptor of the SchemeAESGCM values
+// with the base64 encoded key of the environment variable name
+func NewAESGCMDecryptorFromEnv(name string) (Decryptor, error) {
+	encoded, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key of %s: %w", name, err)
+	}
+	return NewAESGCMDecryptor(key)
+}
+
+// Decrypt implements Decryptor
+func (d *aesGCM) Decrypt(payload string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode payload: %w", err)
+	}
+	size := d.aead.NonceSize()
+	if len(data) < size {
+		return "", errors.New("payload too short")
+	}
+	plaintext, err := d.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// EncryptAESGCM encrypts plaintext with key and returns the
+// ENC(AES-GCM:payload) value to write in a configuration file
+func EncryptAESGCM(key []byte, plaintext string) (string, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	data := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "ENC(" + SchemeAESGCM + ":" + base64.StdEncoding.EncodeToString(data) + ")", nil
+}
+
+// newAESGCM returns the AES-GCM cipher of key
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypted returns the scheme and payload of an ENC(scheme:payload) value
+func encrypted(s string) (scheme, payload string, ok bool) {
+	if !strings.HasPrefix(s, "ENC(") || !strings.HasSuffix(s, ")") {
+		return "", "", false
+	}
+	return strings.Cut(s[len("ENC("):len(s)-1], ":")
+}
+
+// decryptValues returns the configuration with the ENC(...) strings
+// replaced with their plaintext. Maps and slices are copied, so the values
+// of the source are left encrypted
+func decryptValues(v interface{}, decryptors map[string]Decryptor) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		scheme, payload, ok := encrypted(v)
+		if !ok {
+			return v, nil
+		}
+		d, ok := decryptors[scheme]
+		if !ok {
+			return nil, fmt.Errorf("no decryptor for scheme %q", scheme)
+		}
+		return d.Decrypt(payload)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, sv := range v {
+			decrypted, err := decryptValues(sv, decryptors)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			result[k] = decrypted
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, sv := range v {
+			decrypted, err := decryptValues(sv, decryptors)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			result[i] = decrypted
+		}
+		return result, nil
+	case []string:
+		result := make([]string, len(v))
+		for i, sv := range v {
+			decrypted, err := decryptValues(sv, decryptors)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			result[i] = decrypted.(string)
+		}
+		return result, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirSource is a source that reads every configuration file of a directory
+type DirSource struct {
+	dir           string
+	expandEnv     bool
+	profile       string
+	watchInterval time.Duration
+	done          chan struct{}
+	mu            sync.RWMutex
+	watching      bool
+}
+
+// NewDirSource creates a new DirSource. The YAML, JSON and TOML files of dir,
+// e.g. conf.d/db.yaml and conf.d/broker.yaml, are loaded in lexical order and
+// deep-merged, later files overriding earlier ones. Each file may include
+// others and be filtered by profile like a FileSource
+func NewDirSource(dir string, opts ...FileOption) Source {
+	options := defaultFileOptions()
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &DirSource{
+		dir:           dir,
+		expandEnv:     options.expandEnv,
+		profile:       options.profile,
+		watchInterval: options.watchInterval,
+		done:          make(chan struct{}),
+	}
+}
+
+// Read reads and merges the configuration files of the directory
+func (s *DirSource) Read() (map[string]interface{}, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	for _, file := range files {
+		l := &fileLoader{
+			expandEnv: s.expandEnv,
+			profile:   s.profile,
+			seen:      make(map[string]bool),
+		}
+		values, err := l.load(file, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		mergeValues(result, values)
+	}
+
+	return result, nil
+}
+
+// files returns the configuration files of the directory in lexical order
+func (s *DirSource) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch formatFromPath(entry.Name()) {
+		case "json", "yaml", "yml", "toml":
+			files = append(files, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Watch watches for added, removed and modified files in the directory with
+// fsnotify, the directory is polled every watch interval when fsnotify is
+// unavailable
+func (s *DirSource) Watch() (<-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watching {
+		return nil, errors.New("already watching")
+	}
+
+	if _, err := s.snapshot(); err != nil {
+		return nil, err
+	}
+
+	s.watching = true
+	snapshot := func() string {
+		current, err := s.snapshot()
+		if err != nil {
+			return ""
+		}
+		return current
+	}
+	return watchFiles([]string{s.dir}, s.watchInterval, snapshot, s.done), nil
+}
+
+// snapshot returns the names, resolved paths, modification times and sizes
+// of the files
+func (s *DirSource) snapshot() (string, error) {
+	files, err := s.files()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fileSnapshot(&b, files...)
+	return b.String(), nil
+}
+
+// Close stops watching the directory
+func (s *DirSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watching {
+		close(s.done)
+		s.watching = false
+	}
+
+	return nil
+}
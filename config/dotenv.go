@@ -0,0 +1,220 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DotenvSource is a source that reads the variables of a dotenv file
+type DotenvSource struct {
+	path          string
+	prefix        string
+	watchInterval time.Duration
+	done          chan struct{}
+	mu            sync.RWMutex
+	watching      bool
+}
+
+// NewDotenvSource creates a new DotenvSource reading path, e.g. ".env". The
+// variables are converted to keys like an EnvSource, APP_DB_HOST=localhost
+// is db.host with the prefix APP_. A missing file is empty, so the same
+// sources work where no dotenv file is deployed
+func NewDotenvSource(path string, opts ...EnvOption) Source {
+	options := &envOptions{
+		watchInterval: 5 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &DotenvSource{
+		path:          path,
+		prefix:        options.prefix,
+		watchInterval: options.watchInterval,
+		done:          make(chan struct{}),
+	}
+}
+
+// Read reads the configuration from the file
+func (s *DotenvSource) Read() (map[string]interface{}, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]interface{}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := parseDotenv(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+
+	result := make(map[string]interface{})
+	for name, value := range vars {
+		if key, ok := envKey(s.prefix, name); ok {
+			result[key] = value
+		}
+	}
+
+	return result, nil
+}
+
+// Watch watches for changes in the file, including its creation and
+// removal. Its directory is watched with fsnotify, the file is polled every
+// watch interval when fsnotify is unavailable
+func (s *DotenvSource) Watch() (<-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watching {
+		return nil, errors.New("already watching")
+	}
+
+	s.watching = true
+	snapshot := func() string {
+		var b strings.Builder
+		fileSnapshot(&b, s.path)
+		return b.String()
+	}
+	return watchFiles([]string{filepath.Dir(s.path)}, s.watchInterval, snapshot, s.done), nil
+}
+
+// Close stops watching the file
+func (s *DotenvSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watching {
+		close(s.done)
+		s.watching = false
+	}
+
+	return nil
+}
+
+// parseDotenv parses the NAME=value lines of a dotenv file. Lines may start
+// with export, # starts a comment. Single-quoted values are literal,
+// double-quoted values support \n, \t, \" and \\ escapes, and both may span
+// lines. Unquoted and double-quoted values expand ${VAR} and ${VAR:default}
+// references to the previous variables of the file or the environment
+func parseDotenv(data string) (map[string]string, error) {
+	vars := make(map[string]string)
+	lookup := func(name string) (string, bool) {
+		if value, ok := vars[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+
+	rest := strings.ReplaceAll(data, "\r\n", "\n")
+	lineNo := 0
+	for rest != "" {
+		var line string
+		line, rest, _ = strings.Cut(rest, "\n")
+		lineNo++
+		start := lineNo
+
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable %q", start, line)
+		}
+		value = strings.TrimSpace(value)
+
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			quote := value[0]
+			text := value[1:]
+			if rest != "" {
+				text += "\n" + rest
+			}
+			end := closingQuote(text, quote)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value of %s", start, name)
+			}
+			value = text[:end]
+			lineNo += strings.Count(value, "\n")
+
+			// Only a comment may follow the closing quote
+			var trailing string
+			trailing, rest, _ = strings.Cut(text[end+1:], "\n")
+			if trailing = strings.TrimSpace(trailing); trailing != "" && trailing[0] != '#' {
+				return nil, fmt.Errorf("line %d: unexpected %q after the value of %s", lineNo, trailing, name)
+			}
+
+			if quote == '\'' {
+				vars[name] = value
+				continue
+			}
+			value = unescapeDotenv(value)
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+
+		expanded, err := expandEnv(value, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", start, err)
+		}
+		vars[name] = expanded
+	}
+
+	return vars, nil
+}
+
+// closingQuote returns the index of the quote closing text, skipping the
+// escaped quotes of double-quoted values, or -1
+func closingQuote(text string, quote byte) int {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeDotenv replaces the escapes of a double-quoted value
+func unescapeDotenv(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
@@ -1,62 +1,175 @@
 package config
 
 import (
+	"maps"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 // EnvSource is a source that reads from environment variables
 type EnvSource struct {
-	prefix string
+	prefix        string
+	watchInterval time.Duration
+	ch            chan struct{}
+	done          chan struct{}
+	mu            sync.Mutex
+	watching      bool
+	closed        bool
 }
 
 // NewEnvSource creates a new EnvSource
-func NewEnvSource(prefix string) Source {
+func NewEnvSource(prefix string, opts ...EnvOption) Source {
+	options := &envOptions{}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	return &EnvSource{
-		prefix: prefix,
+		prefix:        prefix,
+		watchInterval: options.watchInterval,
+		ch:            make(chan struct{}, 1),
+		done:          make(chan struct{}),
 	}
 }
 
 // Read reads the configuration from environment variables
 func (s *EnvSource) Read() (map[string]interface{}, error) {
 	result := make(map[string]interface{})
-	
+
+	for key, value := range s.environ() {
+		result[key] = value
+	}
+
+	return result, nil
+}
+
+// environ returns the environment variables with the prefix by config key
+func (s *EnvSource) environ() map[string]string {
+	result := make(map[string]string)
+
 	for _, env := range os.Environ() {
-		parts := strings.SplitN(env, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		
-		key := parts[0]
-		value := parts[1]
-		
-		// Check if the key has the prefix
-		if s.prefix != "" && !strings.HasPrefix(key, s.prefix) {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok {
 			continue
 		}
-		
-		// Remove the prefix
-		if s.prefix != "" {
-			key = strings.TrimPrefix(key, s.prefix)
+
+		if key, ok = envKey(s.prefix, key); ok {
+			result[key] = value
 		}
-		
-		// Convert to lowercase and replace underscores with dots
-		key = strings.ToLower(key)
-		key = strings.ReplaceAll(key, "_", ".")
-		
-		result[key] = value
 	}
-	
-	return result, nil
+
+	return result
+}
+
+// envKey converts the name of an environment variable to a config key,
+// reporting whether it has the prefix
+func envKey(prefix, name string) (string, bool) {
+	// Check if the key has the prefix
+	if prefix != "" && !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+
+	// Remove the prefix
+	name = strings.TrimPrefix(name, prefix)
+
+	// Convert to lowercase and replace underscores with dots
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "_", ".")
+
+	return name, true
 }
 
-// Watch watches for changes in environment variables
-// Note: This is a no-op as environment variables don't change during runtime
+// Watch watches for changes in environment variables. The environment is
+// polled with WithEnvWatchInterval, otherwise the channel only receives the
+// notifications of Reload
 func (s *EnvSource) Watch() (<-chan struct{}, error) {
-	return nil, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.watching || s.closed || s.watchInterval <= 0 {
+		return s.ch, nil
+	}
+
+	s.watching = true
+	last := s.environ()
+
+	go func() {
+		ticker := time.NewTicker(s.watchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				current := s.environ()
+				if maps.Equal(current, last) {
+					continue
+				}
+				last = current
+				s.Reload()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s.ch, nil
+}
+
+// Reload notifies the watchers that the environment changed, e.g. after
+// os.Setenv, so the configs watching the source reload it
+func (s *EnvSource) Reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- struct{}{}:
+	default:
+		// A notification is already pending
+	}
 }
 
-// Close closes the source
+// Close stops watching the environment
 func (s *EnvSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+		close(s.ch)
+	}
+
 	return nil
 }
+
+// EnvOption is a function that configures an EnvSource or a DotenvSource
+type EnvOption func(*envOptions)
+
+type envOptions struct {
+	prefix        string
+	watchInterval time.Duration
+}
+
+// WithEnvPrefix sets the prefix of the variables of a DotenvSource, the
+// variables without it are skipped and it is removed from the keys
+func WithEnvPrefix(prefix string) EnvOption {
+	return func(o *envOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithEnvWatchInterval sets the interval for polling the variables. An
+// EnvSource doesn't poll by default, a DotenvSource polls its file every 5s
+// when fsnotify is unavailable
+func WithEnvWatchInterval(interval time.Duration) EnvOption {
+	return func(o *envOptions) {
+		o.watchInterval = interval
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeyError is returned when a key is not found, it matches ErrNotFound with errors.Is
+type KeyError struct {
+	// Key is the missing key
+	Key string
+}
+
+// Error implements error
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("config: key %q not found", e.Key)
+}
+
+// Unwrap returns ErrNotFound
+func (e *KeyError) Unwrap() error {
+	return ErrNotFound
+}
+
+// TypeError is returned when the value of a key can't be converted to the
+// requested type, it matches ErrInvalidType with errors.Is
+type TypeError struct {
+	// Key is the key of the value, with the index or map key of the
+	// offending element for slices and maps, e.g. "hosts[2]"
+	Key string
+	// Actual is the Go type of the value
+	Actual string
+	// Expected is the requested type
+	Expected string
+	// Value is the value, included in the message when it is a string that
+	// failed to parse as a number, a bool, a duration or a time
+	Value interface{}
+}
+
+// Error implements error
+func (e *TypeError) Error() string {
+	if s, ok := e.Value.(string); ok && parsed(e.Expected) {
+		return fmt.Sprintf("config: key %q: cannot parse %q as %s", e.Key, s, e.Expected)
+	}
+	return fmt.Sprintf("config: key %q has type %s, want %s", e.Key, e.Actual, e.Expected)
+}
+
+// parsed reports whether string values are parsed as expected
+func parsed(expected string) bool {
+	switch expected {
+	case "int", "bool", "float64", "duration", "time":
+		return true
+	}
+	return false
+}
+
+// Unwrap returns ErrInvalidType
+func (e *TypeError) Unwrap() error {
+	return ErrInvalidType
+}
+
+// newTypeError returns a TypeError for value of key
+func newTypeError(key string, value interface{}, expected string) *TypeError {
+	return &TypeError{
+		Key:      key,
+		Actual:   fmt.Sprintf("%T", value),
+		Expected: expected,
+		Value:    value,
+	}
+}
+
+// GetOrDefault returns the value of key, or def when the key is not found
+func GetOrDefault(c Config, key string, def interface{}) interface{} {
+	value, err := c.Get(key)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// GetStringOrDefault returns the value of key as a string, or def when the
+// key is not found. A value of another type returns def and a TypeError
+func GetStringOrDefault(c Config, key, def string) (string, error) {
+	return orDefault(c.GetString(key))(def)
+}
+
+// GetIntOrDefault returns the value of key as an int, or def when the key is
+// not found. A value of another type returns def and a TypeError
+func GetIntOrDefault(c Config, key string, def int) (int, error) {
+	return orDefault(c.GetInt(key))(def)
+}
+
+// GetBoolOrDefault returns the value of key as a bool, or def when the key is
+// not found. A value of another type returns def and a TypeError
+func GetBoolOrDefault(c Config, key string, def bool) (bool, error) {
+	return orDefault(c.GetBool(key))(def)
+}
+
+// GetFloatOrDefault returns the value of key as a float64, or def when the
+// key is not found. A value of another type returns def and a TypeError
+func GetFloatOrDefault(c Config, key string, def float64) (float64, error) {
+	return orDefault(c.GetFloat(key))(def)
+}
+
+// GetStringSliceOrDefault returns the value of key as a []string, or def when
+// the key is not found. A value of another type returns def and a TypeError
+func GetStringSliceOrDefault(c Config, key string, def []string) ([]string, error) {
+	return orDefault(c.GetStringSlice(key))(def)
+}
+
+// orDefault returns a function choosing between a getter result and def
+func orDefault[T any](value T, err error) func(def T) (T, error) {
+	return func(def T) (T, error) {
+		switch {
+		case err == nil:
+			return value, nil
+		case errors.Is(err, ErrNotFound):
+			return def, nil
+		}
+		return def, err
+	}
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandEnv replaces the ${VAR} and ${VAR:default} references in s with the
+// value of the environment variable VAR, or default when it is unset.
+// $${VAR} is kept as the literal ${VAR}. A variable that is unset and has no
+// default is an error, so a missing secret fails the load
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+
+		// $${ is an escaped reference
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String(), nil
+			}
+			b.WriteString(s[i : i+end+1])
+			s = s[i+end+1:]
+			continue
+		}
+
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+		ref := s[i+2 : i+end]
+		name, def, hasDefault := strings.Cut(ref, ":")
+		if name == "" {
+			return "", fmt.Errorf("empty reference in %q", s)
+		}
+
+		if value, ok := lookup(name); ok {
+			b.WriteString(value)
+		} else if hasDefault {
+			b.WriteString(def)
+		} else {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		s = s[i+end+1:]
+	}
+}
+
+// expandValues expands the environment variable references in the strings
+// of a parsed configuration, in place
+func expandValues(v interface{}, lookup func(string) (string, bool)) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnv(v, lookup)
+	case map[string]interface{}:
+		for k, sv := range v {
+			expanded, err := expandValues(sv, lookup)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			v[k] = expanded
+		}
+	case map[interface{}]interface{}:
+		for k, sv := range v {
+			expanded, err := expandValues(sv, lookup)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", k, err)
+			}
+			v[k] = expanded
+		}
+	case []interface{}:
+		for i, sv := range v {
+			expanded, err := expandValues(sv, lookup)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			v[i] = expanded
+		}
+	}
+	return v, nil
+}
+
+// expandFileValues expands the references of a parsed configuration file
+func expandFileValues(nested map[string]interface{}) error {
+	_, err := expandValues(nested, os.LookupEnv)
+	return err
+}
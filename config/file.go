@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,13 +19,20 @@ type FileSource struct {
 	path          string
 	format        string
 	watchInterval time.Duration
+	expandEnv     bool
+	profile       string
 	done          chan struct{}
 	mu            sync.RWMutex
 	watching      bool
 }
 
-// NewFileSource creates a new FileSource
+// NewFileSource creates a new FileSource. A directory path creates a
+// DirSource merging the files of the directory
 func NewFileSource(path string, opts ...FileOption) Source {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return NewDirSource(path, opts...)
+	}
+
 	options := defaultFileOptions()
 
 	for _, opt := range opts {
@@ -42,21 +48,29 @@ func NewFileSource(path string, opts ...FileOption) Source {
 		path:          path,
 		format:        options.format,
 		watchInterval: options.watchInterval,
+		expandEnv:     options.expandEnv,
+		profile:       options.profile,
 		done:          make(chan struct{}),
 	}
 }
 
-// Read reads the configuration from the file
+// Read reads the configuration from the file and the files it includes
 func (s *FileSource) Read() (map[string]interface{}, error) {
-	data, err := ioutil.ReadFile(s.path)
-	if err != nil {
-		return nil, err
-	}
+	return s.loader().load(s.path, s.format)
+}
 
-	return s.unmarshal(data)
+// loader returns the loader of the file
+func (s *FileSource) loader() *fileLoader {
+	return &fileLoader{
+		expandEnv: s.expandEnv,
+		profile:   s.profile,
+		seen:      make(map[string]bool),
+	}
 }
 
-// Watch watches for changes in the file
+// Watch watches for changes in the file. Its directory is watched with
+// fsnotify, the file is polled every watch interval when fsnotify is
+// unavailable
 func (s *FileSource) Watch() (<-chan struct{}, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -71,38 +85,12 @@ func (s *FileSource) Watch() (<-chan struct{}, error) {
 	}
 
 	s.watching = true
-	ch := make(chan struct{})
-
-	go func() {
-		defer close(ch)
-
-		lastModTime := time.Time{}
-		ticker := time.NewTicker(s.watchInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				info, err := os.Stat(s.path)
-				if err != nil {
-					continue
-				}
-
-				if info.ModTime().After(lastModTime) {
-					lastModTime = info.ModTime()
-					select {
-					case ch <- struct{}{}:
-					default:
-						// Non-blocking send to prevent goroutine leak
-					}
-				}
-			case <-s.done:
-				return
-			}
-		}
-	}()
-
-	return ch, nil
+	snapshot := func() string {
+		var b strings.Builder
+		fileSnapshot(&b, s.path)
+		return b.String()
+	}
+	return watchFiles([]string{filepath.Dir(s.path)}, s.watchInterval, snapshot, s.done), nil
 }
 
 // Close stops watching the file
@@ -118,11 +106,11 @@ func (s *FileSource) Close() error {
 	return nil
 }
 
-// unmarshal unmarshals the data based on the format
-func (s *FileSource) unmarshal(data []byte) (map[string]interface{}, error) {
+// decode decodes the data based on the format
+func decode(data []byte, format string) (map[string]interface{}, error) {
 	var nested map[string]interface{}
 
-	switch s.format {
+	switch format {
 	case "json":
 		if err := json.Unmarshal(data, &nested); err != nil {
 			return nil, err
@@ -136,10 +124,13 @@ func (s *FileSource) unmarshal(data []byte) (map[string]interface{}, error) {
 			return nil, err
 		}
 	default:
-		return nil, fmt.Errorf("unsupported format: %s", s.format)
+		return nil, fmt.Errorf("unsupported format: %s", format)
 	}
 
-	return flattenMap(nested, ""), nil
+	if nested == nil {
+		nested = make(map[string]interface{})
+	}
+	return nested, nil
 }
 
 // flattenMap takes a nested map and flattens it, prefixing keys with dot notation.
@@ -194,11 +185,15 @@ type FileOption func(*fileOptions)
 type fileOptions struct {
 	format        string
 	watchInterval time.Duration
+	expandEnv     bool
+	profile       string
 }
 
 func defaultFileOptions() *fileOptions {
 	return &fileOptions{
 		watchInterval: 5 * time.Second,
+		expandEnv:     true,
+		profile:       os.Getenv(ProfileEnv),
 	}
 }
 
@@ -209,9 +204,26 @@ func WithFormat(format string) FileOption {
 	}
 }
 
-// WithWatchInterval sets the interval for watching the file
+// WithWatchInterval sets the interval for polling the file when fsnotify is
+// unavailable
 func WithWatchInterval(interval time.Duration) FileOption {
 	return func(o *fileOptions) {
 		o.watchInterval = interval
 	}
 }
+
+// WithExpandEnv sets whether ${VAR} and ${VAR:default} references in the
+// values of the file are replaced with environment variables, enabled by default
+func WithExpandEnv(expand bool) FileOption {
+	return func(o *fileOptions) {
+		o.expandEnv = expand
+	}
+}
+
+// WithProfile sets the active profile, files whose profiles key doesn't list
+// it are skipped. Defaults to the NEW_MILLI_PROFILE environment variable
+func WithProfile(profile string) FileOption {
+	return func(o *fileOptions) {
+		o.profile = profile
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Keys of the directives read from configuration files
+const (
+	// KeyInclude lists the files, or glob patterns, a file includes. Paths
+	// are relative to the including file, its own values override theirs
+	KeyInclude = "include"
+	// KeyProfiles lists the profiles a file is loaded for
+	KeyProfiles = "profiles"
+)
+
+// ProfileEnv is the environment variable holding the default active profile
+const ProfileEnv = "NEW_MILLI_PROFILE"
+
+// fileLoader loads configuration files with their includes
+type fileLoader struct {
+	expandEnv bool
+	profile   string
+	seen      map[string]bool
+}
+
+// load loads the file at path, format is taken from its extension when empty
+func (l *fileLoader) load(path, format string) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if l.seen[abs] {
+		return nil, fmt.Errorf("include cycle at %s", path)
+	}
+	l.seen[abs] = true
+	defer delete(l.seen, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = formatFromPath(path)
+	}
+	nested, err := decode(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.expandEnv {
+		if err := expandFileValues(nested); err != nil {
+			return nil, fmt.Errorf("failed to expand %s: %w", path, err)
+		}
+	}
+
+	profiles, err := stringList(nested[KeyProfiles])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %w", KeyProfiles, path, err)
+	}
+	if !l.active(profiles) {
+		return make(map[string]interface{}), nil
+	}
+	includes, err := stringList(nested[KeyInclude])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %w", KeyInclude, path, err)
+	}
+	delete(nested, KeyProfiles)
+	delete(nested, KeyInclude)
+
+	result := make(map[string]interface{})
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %s in %s: %w", pattern, path, err)
+		}
+		if len(matches) == 0 {
+			// A missing file is an error, a pattern may match nothing
+			if _, err := os.Stat(pattern); err != nil && !hasGlobMeta(pattern) {
+				return nil, fmt.Errorf("failed to include %s: %w", pattern, err)
+			}
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			values, err := l.load(match, "")
+			if err != nil {
+				return nil, fmt.Errorf("failed to include %s: %w", match, err)
+			}
+			mergeValues(result, values)
+		}
+	}
+
+	mergeValues(result, flattenMap(nested, ""))
+	return result, nil
+}
+
+// active reports whether a file listing profiles is loaded, files listing
+// none always are
+func (l *fileLoader) active(profiles []string) bool {
+	if len(profiles) == 0 {
+		return true
+	}
+	for _, p := range profiles {
+		if p == l.profile {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeValues deep-merges flattened values into dst, src wins
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// stringList returns a string or a list of strings as a list
+func stringList(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %T", item)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("expected a string or a list, got %T", v)
+}
+
+// hasGlobMeta reports whether a path is a glob pattern
+func hasGlobMeta(path string) bool {
+	for _, c := range path {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
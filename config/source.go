@@ -1,5 +1,11 @@
 package config
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // Source is the interface for configuration sources
 type Source interface {
 	// Read reads the configuration from the source
@@ -10,48 +16,223 @@ type Source interface {
 	Close() error
 }
 
+// MergeStrategy is how the values of a source of a CompositeSource combine
+// with the values of the sources of lower priority
+type MergeStrategy int
+
+const (
+	// MergeOverride replaces the values of lower priority
+	MergeOverride MergeStrategy = iota
+	// MergeDeep merges maps recursively, other values are replaced
+	MergeDeep
+	// MergeAppend merges maps recursively and appends slices to the slices
+	// of lower priority
+	MergeAppend
+)
+
+// String returns the name of the strategy
+func (m MergeStrategy) String() string {
+	switch m {
+	case MergeOverride:
+		return "override"
+	case MergeDeep:
+		return "deep"
+	case MergeAppend:
+		return "append"
+	}
+	return fmt.Sprintf("MergeStrategy(%d)", int(m))
+}
+
+// SourceOption is a function that configures a source of a CompositeSource
+type SourceOption func(*LayerSource)
+
+// WithSourceName sets the name of the source reported by Config.Origin
+func WithSourceName(name string) SourceOption {
+	return func(l *LayerSource) {
+		l.name = name
+	}
+}
+
+// WithPriority sets the priority of the source, the values of higher
+// priorities override the lower ones
+func WithPriority(priority int) SourceOption {
+	return func(l *LayerSource) {
+		l.priority = priority
+		l.prioritized = true
+	}
+}
+
+// WithMergeStrategy sets how the values of the source combine with the
+// values of lower priority, MergeOverride by default
+func WithMergeStrategy(strategy MergeStrategy) SourceOption {
+	return func(l *LayerSource) {
+		l.strategy = strategy
+	}
+}
+
+// LayerSource is a source with a name, a priority and a merge strategy in a
+// CompositeSource
+type LayerSource struct {
+	Source
+	name        string
+	priority    int
+	prioritized bool
+	strategy    MergeStrategy
+}
+
+// Layer wraps source with options for a CompositeSource, e.g.
+// config.Layer(envSource, config.WithPriority(100))
+func Layer(source Source, opts ...SourceOption) Source {
+	l := &LayerSource{Source: source}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.name == "" {
+		l.name = sourceName(source)
+	}
+	return l
+}
+
+// readOrigins reads the configuration of the source with the names of the
+// sources supplying each key
+func (l *LayerSource) readOrigins() (map[string]interface{}, map[string][]string, error) {
+	if r, ok := l.Source.(originReader); ok {
+		return r.readOrigins()
+	}
+
+	values, err := l.Source.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	origins := make(map[string][]string, len(values))
+	for k := range values {
+		origins[k] = []string{l.name}
+	}
+	return values, origins, nil
+}
+
+// originReader is a source reporting the sources supplying each key
+type originReader interface {
+	readOrigins() (map[string]interface{}, map[string][]string, error)
+}
+
 // CompositeSource is a source that combines multiple sources
 type CompositeSource struct {
-	sources []Source
+	sources []*LayerSource
 }
 
-// NewCompositeSource creates a new CompositeSource
+// NewCompositeSource creates a new CompositeSource. Sources without a
+// priority set with Layer have the priority of their position, so later
+// sources override earlier ones, and sources of equal priority keep their
+// order
 func NewCompositeSource(sources ...Source) Source {
+	layers := make([]*LayerSource, len(sources))
+	for i, source := range sources {
+		l, ok := source.(*LayerSource)
+		if !ok {
+			l = Layer(source).(*LayerSource)
+		}
+		if !l.prioritized {
+			clone := *l
+			clone.priority = i
+			l = &clone
+		}
+		layers[i] = l
+	}
+
+	// Read from the lowest priority to the highest
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].priority < layers[j].priority
+	})
+
 	return &CompositeSource{
-		sources: sources,
+		sources: layers,
 	}
 }
 
 // Read reads the configuration from all sources
 func (s *CompositeSource) Read() (map[string]interface{}, error) {
+	result, _, err := s.readOrigins()
+	return result, err
+}
+
+// readOrigins reads the configuration from all sources with the names of
+// the sources supplying each key, highest priority first
+func (s *CompositeSource) readOrigins() (map[string]interface{}, map[string][]string, error) {
 	result := make(map[string]interface{})
+	origins := make(map[string][]string)
 
-	// Read from each source in order, later sources override earlier ones
 	for _, source := range s.sources {
-		values, err := source.Read()
+		values, sourceOrigins, err := source.readOrigins()
 		if err != nil {
-			return nil, err
+			return nil, nil, fmt.Errorf("failed to read %s: %w", source.name, err)
 		}
 
-		// Merge values
 		for k, v := range values {
-			result[k] = v
+			prev, ok := result[k]
+			if !ok || source.strategy == MergeOverride {
+				result[k] = v
+				origins[k] = sourceOrigins[k]
+				continue
+			}
+
+			merged, combined := mergeValue(prev, v, source.strategy)
+			result[k] = merged
+			if combined {
+				origins[k] = append(append([]string(nil), sourceOrigins[k]...), origins[k]...)
+			} else {
+				origins[k] = sourceOrigins[k]
+			}
 		}
 	}
 
-	return result, nil
+	return result, origins, nil
+}
+
+// mergeValue merges v over prev, reporting whether prev was kept in part
+func mergeValue(prev, v interface{}, strategy MergeStrategy) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		p, ok := prev.(map[string]interface{})
+		if !ok {
+			return v, false
+		}
+		merged := make(map[string]interface{}, len(p)+len(v))
+		for k, pv := range p {
+			merged[k] = pv
+		}
+		for k, nv := range v {
+			if pv, ok := merged[k]; ok {
+				merged[k], _ = mergeValue(pv, nv, strategy)
+			} else {
+				merged[k] = nv
+			}
+		}
+		return merged, true
+	case []interface{}:
+		if p, ok := prev.([]interface{}); ok && strategy == MergeAppend {
+			return append(append(make([]interface{}, 0, len(p)+len(v)), p...), v...), true
+		}
+	case []string:
+		if p, ok := prev.([]string); ok && strategy == MergeAppend {
+			return append(append(make([]string, 0, len(p)+len(v)), p...), v...), true
+		}
+	}
+	return v, false
 }
 
 // Watch watches for changes in any source
 func (s *CompositeSource) Watch() (<-chan struct{}, error) {
 	ch := make(chan struct{})
-	
+
 	for _, source := range s.sources {
 		sourceCh, err := source.Watch()
 		if err != nil {
 			return nil, err
 		}
-		
+
 		if sourceCh != nil {
 			go func(sourceCh <-chan struct{}) {
 				for range sourceCh {
@@ -65,7 +246,7 @@ func (s *CompositeSource) Watch() (<-chan struct{}, error) {
 			}(sourceCh)
 		}
 	}
-	
+
 	return ch, nil
 }
 
@@ -76,6 +257,34 @@ func (s *CompositeSource) Close() error {
 			return err
 		}
 	}
-	
+
 	return nil
 }
+
+// sourceName returns the default name of a source in the origins
+func sourceName(source Source) string {
+	switch s := source.(type) {
+	case *LayerSource:
+		return s.name
+	case *FileSource:
+		return "file:" + s.path
+	case *DirSource:
+		return "dir:" + s.dir
+	case *DotenvSource:
+		return "dotenv:" + s.path
+	case *EnvSource:
+		if s.prefix != "" {
+			return "env:" + s.prefix
+		}
+		return "env"
+	case *MemorySource:
+		return "memory"
+	case *CompositeSource:
+		names := make([]string, len(s.sources))
+		for i, l := range s.sources {
+			names[i] = l.name
+		}
+		return "composite(" + strings.Join(names, ", ") + ")"
+	}
+	return fmt.Sprintf("%T", source)
+}
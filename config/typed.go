@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Get returns the value of key as a T. The types of the Config getters,
+// time.Duration and time.Time are converted like their getters, other types
+// must match the value type
+func Get[T any](c Config, key string) (T, error) {
+	var (
+		zero  T
+		value interface{}
+		err   error
+	)
+	switch any(zero).(type) {
+	case string:
+		value, err = c.GetString(key)
+	case int:
+		value, err = c.GetInt(key)
+	case bool:
+		value, err = c.GetBool(key)
+	case float64:
+		value, err = c.GetFloat(key)
+	case []string:
+		value, err = c.GetStringSlice(key)
+	case map[string]interface{}:
+		value, err = c.GetStringMap(key)
+	case map[string]string:
+		value, err = c.GetStringMapString(key)
+	case time.Duration:
+		value, err = GetDuration(c, key)
+	case time.Time:
+		value, err = GetTime(c, key)
+	default:
+		value, err = c.Get(key)
+	}
+	if err != nil {
+		return zero, err
+	}
+
+	v, ok := value.(T)
+	if !ok {
+		return zero, newTypeError(key, value, fmt.Sprintf("%T", zero))
+	}
+	return v, nil
+}
+
+// GetDuration returns the value of key as a time.Duration. Strings are
+// parsed with time.ParseDuration, e.g. "5s", numbers are milliseconds
+func GetDuration(c Config, key string) (time.Duration, error) {
+	value, err := c.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d, nil
+		}
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(ms) && !math.IsInf(ms, 0) {
+			return time.Duration(ms * float64(time.Millisecond)), nil
+		}
+	case int:
+		return time.Duration(v) * time.Millisecond, nil
+	case int64:
+		return time.Duration(v) * time.Millisecond, nil
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return time.Duration(v * float64(time.Millisecond)), nil
+		}
+	}
+	return 0, newTypeError(key, value, "duration")
+}
+
+// GetTime returns the value of key as a time.Time. Strings are parsed with
+// layouts, time.RFC3339 and time.DateOnly by default, numbers are Unix
+// seconds
+func GetTime(c Config, key string, layouts ...string) (time.Time, error) {
+	value, err := c.Get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(layouts) == 0 {
+		layouts = []string{time.RFC3339, time.DateOnly}
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		// Environment variables are strings, accept Unix seconds too
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(n, 0), nil
+		}
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			sec, frac := math.Modf(v)
+			return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+		}
+	}
+	return time.Time{}, newTypeError(key, value, "time")
+}
+
+// MustGet is like Get but panics on error, for required keys checked at
+// startup
+func MustGet[T any](c Config, key string) T {
+	v, err := Get[T](c, key)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// MustGetString returns the value of key as a string and panics on error
+func MustGetString(c Config, key string) string {
+	return MustGet[string](c, key)
+}
+
+// MustGetInt returns the value of key as an int and panics on error
+func MustGetInt(c Config, key string) int {
+	return MustGet[int](c, key)
+}
+
+// MustGetBool returns the value of key as a bool and panics on error
+func MustGetBool(c Config, key string) bool {
+	return MustGet[bool](c, key)
+}
+
+// MustGetDuration returns the value of key as a time.Duration and panics on
+// error
+func MustGetDuration(c Config, key string) time.Duration {
+	return MustGet[time.Duration](c, key)
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is the delay between a file event and the snapshot, so the
+// events of a single write or symlink swap notify once
+const watchDebounce = 100 * time.Millisecond
+
+// watchFiles notifies the changes of snapshot until done is closed. The
+// directories dirs are watched with fsnotify, so the symlink swaps of
+// Kubernetes ConfigMaps are seen too, or polled every interval when
+// fsnotify is unavailable
+func watchFiles(dirs []string, interval time.Duration, snapshot func() string, done <-chan struct{}) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	last := snapshot()
+
+	notify := func() {
+		current := snapshot()
+		if current == last {
+			return
+		}
+		last = current
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A notification is already pending
+		}
+	}
+
+	// Watch before returning, so the changes following Watch are seen
+	watcher, err := newDirWatcher(dirs)
+	if err != nil {
+		go func() {
+			defer close(ch)
+			pollFiles(interval, notify, done)
+		}()
+		return ch
+	}
+
+	go func() {
+		defer close(ch)
+		defer watcher.Close()
+
+		debounce := time.NewTimer(watchDebounce)
+		debounce.Stop()
+		defer debounce.Stop()
+
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					pollFiles(interval, notify, done)
+					return
+				}
+				debounce.Reset(watchDebounce)
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					pollFiles(interval, notify, done)
+					return
+				}
+				// Events may be lost, compare the snapshot
+				debounce.Reset(watchDebounce)
+			case <-debounce.C:
+				notify()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// newDirWatcher returns a fsnotify watcher of dirs
+func newDirWatcher(dirs []string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	return watcher, nil
+}
+
+// pollFiles calls notify every interval until done is closed
+func pollFiles(interval time.Duration, notify func(), done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			notify()
+		case <-done:
+			return
+		}
+	}
+}
+
+// fileSnapshot writes the resolved path, modification time and size of the
+// files, so a symlink swapped to a file of the same time is a change
+func fileSnapshot(b *strings.Builder, files ...string) {
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(file)
+		if err != nil {
+			resolved = file
+		}
+		fmt.Fprintf(b, "%s:%s:%d:%d;", file, resolved, info.ModTime().UnixNano(), info.Size())
+	}
+}
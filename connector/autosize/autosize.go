@@ -0,0 +1,273 @@
+// Package autosize adjusts the size of connection pools to the observed load.
+//
+// The controller grows a pool additively while callers wait for connections
+// and shrinks it multiplicatively while the queries get slower, which keeps
+// the pool from overloading a struggling database (AIMD).
+package autosize
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+	"new-milli/connector"
+)
+
+// Stats are the cumulative statistics of a pool.
+type Stats struct {
+	// Size is the current pool size.
+	Size int
+	// WaitCount is the number of times a caller waited for a connection.
+	WaitCount int64
+	// WaitDuration is the total time callers waited for a connection.
+	WaitDuration time.Duration
+	// QueryCount is the number of queries run.
+	QueryCount int64
+	// QueryDuration is the total time spent running queries.
+	QueryDuration time.Duration
+}
+
+// Pool is a resizable connection pool.
+type Pool interface {
+	// Stats returns the cumulative statistics of the pool.
+	Stats() Stats
+	// Resize sets the pool size.
+	Resize(size int)
+}
+
+// Option is autosize option.
+type Option func(*options)
+
+// options is autosize options.
+type options struct {
+	name       string
+	min        int
+	max        int
+	interval   time.Duration
+	maxWait    time.Duration
+	maxLatency time.Duration
+	step       int
+	backoff    float64
+	registry   prometheus.Registerer
+}
+
+// WithName returns an Option that sets the pool name used in logs and metrics.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithBounds returns an Option that sets the minimum and maximum pool size.
+func WithBounds(min, max int) Option {
+	return func(o *options) {
+		o.min = min
+		o.max = max
+	}
+}
+
+// WithInterval returns an Option that sets how often the pool is adjusted.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithMaxWait returns an Option that sets the average wait for a connection
+// above which the pool grows.
+func WithMaxWait(d time.Duration) Option {
+	return func(o *options) {
+		o.maxWait = d
+	}
+}
+
+// WithMaxLatency returns an Option that sets the average query latency above
+// which the pool shrinks, 0 disables shrinking on latency.
+func WithMaxLatency(d time.Duration) Option {
+	return func(o *options) {
+		o.maxLatency = d
+	}
+}
+
+// WithStep returns an Option that sets how many connections are added at a time.
+func WithStep(step int) Option {
+	return func(o *options) {
+		o.step = step
+	}
+}
+
+// WithBackoff returns an Option that sets the factor, between 0 and 1,
+// the pool size is multiplied by when it shrinks.
+func WithBackoff(factor float64) Option {
+	return func(o *options) {
+		o.backoff = factor
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the target size gauge,
+// nil disables it.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// newTargetGauge returns the target size gauge, reusing the registered one.
+func newTargetGauge(registry prometheus.Registerer) *prometheus.GaugeVec {
+	gauge, err := collector.Register(registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "new_milli",
+		Subsystem: "connector",
+		Name:      "pool_target_size",
+		Help:      "Target size of the connection pool set by autosizing.",
+	}, []string{"name"}))
+	if err != nil {
+		connector.Log(context.Background()).Warnf("Failed to register pool autosize gauge: %v", err)
+	}
+	return gauge
+}
+
+// Controller periodically resizes a pool within its bounds.
+type Controller struct {
+	pool   Pool
+	opts   options
+	gauge  prometheus.Gauge
+	last   Stats
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a controller of pool. Without bounds the pool may shrink to a
+// quarter and grow to twice its current size.
+func New(pool Pool, opts ...Option) *Controller {
+	size := pool.Stats().Size
+	o := options{
+		name:       "pool",
+		min:        max(1, size/4),
+		max:        max(1, size*2),
+		interval:   time.Second * 10,
+		maxWait:    time.Millisecond * 5,
+		maxLatency: 0,
+		step:       2,
+		backoff:    0.75,
+		registry:   prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.max < o.min {
+		o.max = o.min
+	}
+
+	c := &Controller{
+		pool: pool,
+		opts: o,
+	}
+	if o.registry != nil {
+		c.gauge = newTargetGauge(o.registry).WithLabelValues(o.name)
+	}
+	return c
+}
+
+// Bounds returns the minimum and maximum pool size.
+func (c *Controller) Bounds() (min, max int) {
+	return c.opts.min, c.opts.max
+}
+
+// Start clamps the pool into its bounds and adjusts it in the background.
+func (c *Controller) Start(ctx context.Context) error {
+	c.last = c.pool.Stats()
+	if size := clamp(c.last.Size, c.opts.min, c.opts.max); size != c.last.Size {
+		c.pool.Resize(size)
+		c.last.Size = size
+	}
+	c.setGauge(c.last.Size)
+
+	ctx, c.cancel = context.WithCancel(context.Background())
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.opts.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.adjust()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops adjusting the pool.
+func (c *Controller) Stop(ctx context.Context) error {
+	if c.cancel == nil {
+		return nil
+	}
+	c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// adjust resizes the pool from the statistics of the last interval.
+func (c *Controller) adjust() {
+	stats := c.pool.Stats()
+	waits := stats.WaitCount - c.last.WaitCount
+	waited := stats.WaitDuration - c.last.WaitDuration
+	queries := stats.QueryCount - c.last.QueryCount
+	spent := stats.QueryDuration - c.last.QueryDuration
+	c.last = stats
+
+	var avgWait, avgLatency time.Duration
+	if waits > 0 {
+		avgWait = waited / time.Duration(waits)
+	}
+	if queries > 0 {
+		avgLatency = spent / time.Duration(queries)
+	}
+
+	size := stats.Size
+	switch {
+	case c.opts.maxLatency > 0 && avgLatency > c.opts.maxLatency:
+		// The database is struggling, more connections would make it worse
+		size = int(float64(size) * c.opts.backoff)
+	case avgWait > c.opts.maxWait:
+		size += c.opts.step
+	default:
+		return
+	}
+	size = clamp(size, c.opts.min, c.opts.max)
+	if size == stats.Size {
+		return
+	}
+
+	connector.Log(context.Background()).Infof("Resizing %s pool from %d to %d (avg wait %s, avg latency %s)",
+		c.opts.name, stats.Size, size, avgWait, avgLatency)
+	c.pool.Resize(size)
+	c.last.Size = size
+	c.setGauge(size)
+}
+
+// setGauge exports the target size.
+func (c *Controller) setGauge(size int) {
+	if c.gauge != nil {
+		c.gauge.Set(float64(size))
+	}
+}
+
+// clamp returns n bounded by min and max.
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,165 @@
+package autosize
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisPool limits the commands a go-redis client runs concurrently.
+//
+// go-redis pools can't be resized once created, so the client is created with
+// the maximum pool size and the limit gates how many connections are in use.
+type RedisPool struct {
+	mu      sync.Mutex
+	limit   int
+	inUse   int
+	waiters []chan struct{}
+
+	waits   atomic.Int64
+	waited  atomic.Int64
+	queries atomic.Int64
+	spent   atomic.Int64
+}
+
+// NewRedisPool creates a pool limiting the client to size concurrent commands,
+// add its Hook to the client.
+func NewRedisPool(size int) *RedisPool {
+	return &RedisPool{limit: size}
+}
+
+// Stats implements Pool.
+func (p *RedisPool) Stats() Stats {
+	p.mu.Lock()
+	size := p.limit
+	p.mu.Unlock()
+	return Stats{
+		Size:          size,
+		WaitCount:     p.waits.Load(),
+		WaitDuration:  time.Duration(p.waited.Load()),
+		QueryCount:    p.queries.Load(),
+		QueryDuration: time.Duration(p.spent.Load()),
+	}
+}
+
+// Resize implements Pool.
+func (p *RedisPool) Resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = size
+	for p.inUse < p.limit && len(p.waiters) > 0 {
+		p.grant()
+	}
+}
+
+// Hook returns the go-redis hook gating and observing the commands.
+func (p *RedisPool) Hook() redis.Hook {
+	return redisHook{pool: p}
+}
+
+// acquire waits for a free slot.
+func (p *RedisPool) acquire(ctx context.Context) error {
+	p.mu.Lock()
+	if p.inUse < p.limit {
+		p.inUse++
+		p.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	p.waiters = append(p.waiters, ch)
+	p.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		p.waits.Add(1)
+		p.waited.Add(int64(time.Since(start)))
+	}()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, w := range p.waiters {
+			if w == ch {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// The slot was granted while giving up, pass it on
+		p.releaseLocked()
+		return ctx.Err()
+	}
+}
+
+// release frees a slot.
+func (p *RedisPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.releaseLocked()
+}
+
+// releaseLocked frees a slot, handing it to the first waiter if the limit allows.
+func (p *RedisPool) releaseLocked() {
+	p.inUse--
+	if p.inUse < p.limit && len(p.waiters) > 0 {
+		p.grant()
+	}
+}
+
+// grant hands a slot to the first waiter.
+func (p *RedisPool) grant() {
+	ch := p.waiters[0]
+	p.waiters = p.waiters[1:]
+	p.inUse++
+	close(ch)
+}
+
+// redisHook gates and observes the commands of a client.
+type redisHook struct {
+	pool *RedisPool
+}
+
+// DialHook implements redis.Hook.
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// ProcessHook implements redis.Hook.
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := h.pool.acquire(ctx); err != nil {
+			return err
+		}
+		defer h.pool.release()
+
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.pool.queries.Add(1)
+		h.pool.spent.Add(int64(time.Since(start)))
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook.
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := h.pool.acquire(ctx); err != nil {
+			return err
+		}
+		defer h.pool.release()
+
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.pool.queries.Add(1)
+		h.pool.spent.Add(int64(time.Since(start)))
+		return err
+	}
+}
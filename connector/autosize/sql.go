@@ -0,0 +1,98 @@
+package autosize
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SQLPool adapts a database/sql pool, resizing it with SetMaxOpenConns.
+type SQLPool struct {
+	db      *sql.DB
+	queries atomic.Int64
+	spent   atomic.Int64
+}
+
+// NewSQLPool creates a pool of db. Query latency is observed through Observe
+// or the plugin returned by GormPlugin.
+func NewSQLPool(db *sql.DB) *SQLPool {
+	return &SQLPool{db: db}
+}
+
+// Stats implements Pool.
+func (p *SQLPool) Stats() Stats {
+	s := p.db.Stats()
+	return Stats{
+		Size:          s.MaxOpenConnections,
+		WaitCount:     s.WaitCount,
+		WaitDuration:  s.WaitDuration,
+		QueryCount:    p.queries.Load(),
+		QueryDuration: time.Duration(p.spent.Load()),
+	}
+}
+
+// Resize implements Pool.
+func (p *SQLPool) Resize(size int) {
+	p.db.SetMaxOpenConns(size)
+}
+
+// Observe records the latency of a query.
+func (p *SQLPool) Observe(d time.Duration) {
+	p.queries.Add(1)
+	p.spent.Add(int64(d))
+}
+
+// GormPlugin returns a GORM plugin observing the latency of every statement.
+func (p *SQLPool) GormPlugin() gorm.Plugin {
+	return &gormPlugin{pool: p}
+}
+
+// gormPlugin observes the latency of GORM statements.
+type gormPlugin struct {
+	pool *SQLPool
+}
+
+// startKey is the statement setting holding the start time.
+const startKey = "autosize:start"
+
+// Name implements gorm.Plugin.
+func (g *gormPlugin) Name() string {
+	return "autosize"
+}
+
+// Initialize implements gorm.Plugin.
+func (g *gormPlugin) Initialize(db *gorm.DB) error {
+	before := func(db *gorm.DB) {
+		db.InstanceSet(startKey, time.Now())
+	}
+	after := func(db *gorm.DB) {
+		if v, ok := db.InstanceGet(startKey); ok {
+			if start, ok := v.(time.Time); ok {
+				g.pool.Observe(time.Since(start))
+			}
+		}
+	}
+
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("autosize:before_create", before),
+		cb.Create().After("gorm:create").Register("autosize:after_create", after),
+		cb.Query().Before("gorm:query").Register("autosize:before_query", before),
+		cb.Query().After("gorm:query").Register("autosize:after_query", after),
+		cb.Update().Before("gorm:update").Register("autosize:before_update", before),
+		cb.Update().After("gorm:update").Register("autosize:after_update", after),
+		cb.Delete().Before("gorm:delete").Register("autosize:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("autosize:after_delete", after),
+		cb.Row().Before("gorm:row").Register("autosize:before_row", before),
+		cb.Row().After("gorm:row").Register("autosize:after_row", after),
+		cb.Raw().Before("gorm:raw").Register("autosize:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("autosize:after_raw", after),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
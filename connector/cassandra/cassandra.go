@@ -0,0 +1,462 @@
+package cassandra
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"new-milli/connector"
+)
+
+// Config is the configuration for the Cassandra connector.
+type Config struct {
+	connector.Config
+	// Port is the port of the hosts without one in Address.
+	Port int
+	// Keyspace is the default keyspace of the session.
+	Keyspace string
+	// Consistency is the default consistency level of the queries.
+	Consistency gocql.Consistency
+	// SerialConsistency is the consistency level of the lightweight transactions.
+	SerialConsistency gocql.SerialConsistency
+	// NumConns is the number of connections per host.
+	NumConns int
+	// ProtoVersion is the native protocol version, 0 negotiates it.
+	ProtoVersion int
+	// LocalDC is the local datacenter queries are routed to first.
+	LocalDC string
+	// RetryPolicy is the default retry policy of the queries.
+	RetryPolicy gocql.RetryPolicy
+	// DisableInitialHostLookup connects to the hosts in Address only.
+	DisableInitialHostLookup bool
+}
+
+// DefaultConfig returns the default configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Config: connector.Config{
+			Name:           "cassandra",
+			Address:        "localhost",
+			Username:       "",
+			Password:       "",
+			ConnectTimeout: time.Second * 10,
+			ReadTimeout:    time.Second * 10,
+			WriteTimeout:   time.Second * 10,
+			EnableTLS:      false,
+			TLSSkipVerify:  false,
+		},
+		Port:              9042,
+		Keyspace:          "",
+		Consistency:       gocql.LocalQuorum,
+		SerialConsistency: gocql.LocalSerial,
+		NumConns:          2,
+		RetryPolicy: &gocql.ExponentialBackoffRetryPolicy{
+			NumRetries: 3,
+			Min:        time.Millisecond * 100,
+			Max:        time.Second * 10,
+		},
+	}
+}
+
+// Connector is a Cassandra/ScyllaDB connector.
+type Connector struct {
+	config    *Config
+	session   *gocql.Session
+	mu        sync.RWMutex
+	connected bool
+	tlsConfig *tls.Config
+	events    connector.Notifier
+}
+
+// New creates a new Cassandra connector.
+func New(opts ...connector.Option) connector.Connector {
+	config := DefaultConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	return &Connector{
+		config: config,
+	}
+}
+
+// Connect connects to the database.
+func (c *Connector) Connect(ctx context.Context) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connected {
+		return connector.ErrAlreadyConnected
+	}
+
+	c.events.Notify(c.config.Name, connector.Connecting, nil)
+	defer func() { c.events.Connected(c.config.Name, err) }()
+
+	// Setup TLS if enabled
+	if c.config.EnableTLS {
+		if err := c.setupTLS(); err != nil {
+			return err
+		}
+	}
+
+	cluster := c.buildCluster()
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Cassandra: %w", err)
+	}
+
+	// Ping the cluster
+	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
+	defer cancel()
+	if err := ping(ctx, session); err != nil {
+		session.Close()
+		return fmt.Errorf("failed to ping Cassandra: %w", err)
+	}
+
+	c.session = session
+	c.connected = true
+	connector.Log(ctx).Infof("Connected to Cassandra at %s", c.config.Address)
+	return nil
+}
+
+// buildCluster builds the gocql cluster configuration.
+func (c *Connector) buildCluster() *gocql.ClusterConfig {
+	hosts := strings.Split(c.config.Address, ",")
+	for i := range hosts {
+		hosts[i] = strings.TrimSpace(hosts[i])
+	}
+
+	cluster := gocql.NewCluster(hosts...)
+	cluster.Port = c.config.Port
+	cluster.Keyspace = c.config.Keyspace
+	cluster.Consistency = c.config.Consistency
+	cluster.SerialConsistency = c.config.SerialConsistency
+	cluster.ConnectTimeout = c.config.ConnectTimeout
+	cluster.Timeout = c.config.ReadTimeout
+	cluster.WriteTimeout = c.config.WriteTimeout
+	cluster.NumConns = c.config.NumConns
+	cluster.ProtoVersion = c.config.ProtoVersion
+	cluster.RetryPolicy = c.config.RetryPolicy
+	cluster.DisableInitialHostLookup = c.config.DisableInitialHostLookup
+
+	if c.config.LocalDC != "" {
+		cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.DCAwareRoundRobinPolicy(c.config.LocalDC))
+	} else {
+		cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+	}
+
+	if c.config.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: c.config.Username,
+			Password: c.config.Password,
+		}
+	}
+
+	if c.config.EnableTLS {
+		cluster.SslOpts = &gocql.SslOptions{
+			Config:                 c.tlsConfig,
+			EnableHostVerification: !c.config.TLSSkipVerify,
+		}
+	}
+	return cluster
+}
+
+// Disconnect disconnects from the database.
+func (c *Connector) Disconnect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		return connector.ErrNotConnected
+	}
+
+	c.session.Close()
+
+	c.session = nil
+	c.connected = false
+	connector.Log(ctx).Infof("Disconnected from Cassandra at %s", c.config.Address)
+	c.events.Disconnected(c.config.Name)
+	return nil
+}
+
+// Ping checks if the database is reachable.
+func (c *Connector) Ping(ctx context.Context) (err error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.connected {
+		return connector.ErrNotConnected
+	}
+
+	defer func() { c.events.Ping(c.config.Name, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
+	defer cancel()
+	if err := ping(ctx, c.session); err != nil {
+		return fmt.Errorf("failed to ping Cassandra: %w", err)
+	}
+
+	return nil
+}
+
+// ping queries the local node of session.
+func ping(ctx context.Context, session *gocql.Session) error {
+	return session.Query("SELECT release_version FROM system.local").WithContext(ctx).Consistency(gocql.One).Exec()
+}
+
+// IsConnected returns true if the connector is connected.
+func (c *Connector) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected
+}
+
+// Name returns the name of the connector.
+func (c *Connector) Name() string {
+	return c.config.Name
+}
+
+// Client returns the underlying client.
+func (c *Connector) Client() interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.session
+}
+
+// Session returns the underlying gocql session.
+func (c *Connector) Session() *gocql.Session {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.session
+}
+
+// setupTLS sets up TLS for the Cassandra connection.
+func (c *Connector) setupTLS() error {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.config.TLSSkipVerify,
+	}
+
+	if !c.config.TLSSkipVerify {
+		// Load CA certificate
+		if c.config.TLSCAPath != "" {
+			caCert, err := os.ReadFile(c.config.TLSCAPath)
+			if err != nil {
+				return fmt.Errorf("failed to read CA certificate: %w", err)
+			}
+
+			caCertPool := x509.NewCertPool()
+			if !caCertPool.AppendCertsFromPEM(caCert) {
+				return fmt.Errorf("failed to append CA certificate")
+			}
+
+			tlsConfig.RootCAs = caCertPool
+		}
+	}
+
+	// Load client certificate and key
+	if c.config.TLSCertPath != "" && c.config.TLSKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(c.config.TLSCertPath, c.config.TLSKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate and key: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	c.tlsConfig = tlsConfig
+	return nil
+}
+
+// WithConfig sets the configuration.
+func WithConfig(config *Config) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			*conn = *config
+		}
+	}
+}
+
+// WithAddress sets the comma-separated hosts.
+func WithAddress(address string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Address = address
+		}
+	}
+}
+
+// WithUsername sets the username.
+func WithUsername(username string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Username = username
+		}
+	}
+}
+
+// WithPassword sets the password.
+func WithPassword(password string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Password = password
+		}
+	}
+}
+
+// WithConnectTimeout sets the connect timeout.
+func WithConnectTimeout(timeout time.Duration) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.ConnectTimeout = timeout
+		}
+	}
+}
+
+// WithReadTimeout sets the query timeout.
+func WithReadTimeout(timeout time.Duration) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.ReadTimeout = timeout
+		}
+	}
+}
+
+// WithWriteTimeout sets the write timeout.
+func WithWriteTimeout(timeout time.Duration) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.WriteTimeout = timeout
+		}
+	}
+}
+
+// WithTLS enables TLS for the connection.
+func WithTLS(enable bool) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.EnableTLS = enable
+		}
+	}
+}
+
+// WithTLSSkipVerify sets whether to skip TLS verification.
+func WithTLSSkipVerify(skip bool) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.TLSSkipVerify = skip
+		}
+	}
+}
+
+// WithTLSCertPath sets the path to the TLS certificate.
+func WithTLSCertPath(path string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.TLSCertPath = path
+		}
+	}
+}
+
+// WithTLSKeyPath sets the path to the TLS key.
+func WithTLSKeyPath(path string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.TLSKeyPath = path
+		}
+	}
+}
+
+// WithTLSCAPath sets the path to the TLS CA certificate.
+func WithTLSCAPath(path string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.TLSCAPath = path
+		}
+	}
+}
+
+// WithPort sets the port of the hosts without one.
+func WithPort(port int) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Port = port
+		}
+	}
+}
+
+// WithKeyspace sets the default keyspace.
+func WithKeyspace(keyspace string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Keyspace = keyspace
+		}
+	}
+}
+
+// WithConsistency sets the default consistency level.
+func WithConsistency(consistency gocql.Consistency) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Consistency = consistency
+		}
+	}
+}
+
+// WithSerialConsistency sets the consistency level of the lightweight transactions.
+func WithSerialConsistency(consistency gocql.SerialConsistency) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.SerialConsistency = consistency
+		}
+	}
+}
+
+// WithNumConns sets the number of connections per host.
+func WithNumConns(n int) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.NumConns = n
+		}
+	}
+}
+
+// WithProtoVersion sets the native protocol version.
+func WithProtoVersion(version int) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.ProtoVersion = version
+		}
+	}
+}
+
+// WithLocalDC sets the local datacenter queries are routed to first.
+func WithLocalDC(dc string) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.LocalDC = dc
+		}
+	}
+}
+
+// WithRetryPolicy sets the default retry policy.
+func WithRetryPolicy(policy gocql.RetryPolicy) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.RetryPolicy = policy
+		}
+	}
+}
+
+// WithDisableInitialHostLookup sets whether to connect to the configured hosts only.
+func WithDisableInitialHostLookup(disable bool) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.DisableInitialHostLookup = disable
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package cassandra
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/gocql/gocql"
+	"new-milli/connector"
+)
+
+func init() {
+	connector.RegisterFactory("cassandra", newFromParams)
+	connector.RegisterFactory("scylla", newFromParams)
+}
+
+// newFromParams creates a new Cassandra connector from configuration
+// parameters, see connector.FromConfig. The address is a comma separated
+// list of hosts, the database is the keyspace, and the parameters are those
+// of connector.ApplyParams, port, keyspace, consistency, serial_consistency, num_conns,
+// proto_version, local_dc and disable_initial_host_lookup.
+func newFromParams(params url.Values) (connector.Connector, error) {
+	config := DefaultConfig()
+	if err := connector.ApplyParams(params, &config.Config); err != nil {
+		return nil, err
+	}
+
+	// The database is the keyspace
+	if config.Database != "" {
+		config.Keyspace = config.Database
+	}
+
+	var err error
+	if value, ok := connector.PopParam(params, "keyspace"); ok {
+		config.Keyspace = value
+	}
+	if value, ok := connector.PopParam(params, "local_dc"); ok {
+		config.LocalDC = value
+	}
+	if value, ok := connector.PopParam(params, "consistency"); ok {
+		if config.Consistency, err = gocql.ParseConsistencyWrapper(value); err != nil {
+			return nil, fmt.Errorf("invalid consistency: %w", err)
+		}
+	}
+	if value, ok := connector.PopParam(params, "serial_consistency"); ok {
+		if err = config.SerialConsistency.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid serial_consistency: %w", err)
+		}
+	}
+
+	ints := map[string]*int{
+		"port":          &config.Port,
+		"num_conns":     &config.NumConns,
+		"proto_version": &config.ProtoVersion,
+	}
+	for key, n := range ints {
+		if value, ok := connector.PopParam(params, key); ok {
+			if *n, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+	if value, ok := connector.PopParam(params, "disable_initial_host_lookup"); ok {
+		if config.DisableInitialHostLookup, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid disable_initial_host_lookup: %w", err)
+		}
+	}
+
+	if len(params) > 0 {
+		return nil, fmt.Errorf("%w: unknown Cassandra parameters %s", connector.ErrInvalidConfig, params.Encode())
+	}
+	return New(WithConfig(config)), nil
+}
@@ -0,0 +1,275 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/batcher"
+	"new-milli/collector"
+	"new-milli/connector"
+)
+
+var (
+	// ErrBufferFull is returned when writing to a BatchWriter whose buffer is
+	// full, the row is dropped. It is batcher.ErrFull.
+	ErrBufferFull = batcher.ErrFull
+	// ErrWriterClosed is returned when writing to a closed BatchWriter. It is
+	// batcher.ErrClosed.
+	ErrWriterClosed = batcher.ErrClosed
+)
+
+// batchMetrics is the metrics of the batch inserts.
+type batchMetrics struct {
+	flushes *prometheus.HistogramVec
+	rows    *prometheus.CounterVec
+}
+
+// newBatchMetrics creates the ClickHouse batch metrics registered with
+// registry, reusing the registered ones. They aren't registered when
+// registry is nil.
+func newBatchMetrics(registry prometheus.Registerer) *batchMetrics {
+	m := &batchMetrics{
+		flushes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "new_milli",
+			Subsystem: "clickhouse",
+			Name:      "batch_flush_duration_seconds",
+			Help:      "Duration of the batch inserts by table and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"table", "status"}),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "clickhouse",
+			Name:      "batch_rows_total",
+			Help:      "Number of rows of the batch writers by table and result (inserted, failed or dropped).",
+		}, []string{"table", "result"}),
+	}
+	if registry != nil {
+		var errs [2]error
+		m.flushes, errs[0] = collector.Register(registry, m.flushes)
+		m.rows, errs[1] = collector.Register(registry, m.rows)
+		if err := errors.Join(errs[:]...); err != nil {
+			connector.Log(context.Background()).Warnf("Failed to register ClickHouse batch metrics: %v", err)
+		}
+	}
+	return m
+}
+
+// BatchInsert inserts rows into table, "table" or "database.table", with a
+// native batch. rows is a slice
+// of structs, or pointers to structs, whose fields are mapped to the
+// columns with the ch tag, or a slice of []interface{} holding the values
+// of the columns in order.
+func (c *Connector) BatchInsert(ctx context.Context, table string, rows interface{}) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return fmt.Errorf("rows must be a slice, got %T", rows)
+	}
+	if v.Len() == 0 {
+		return nil
+	}
+
+	c.mu.RLock()
+	conn, connected := c.conn, c.connected
+	c.mu.RUnlock()
+	if !connected {
+		return connector.ErrNotConnected
+	}
+
+	quoted, err := quoteTable(table)
+	if err != nil {
+		return err
+	}
+	batch, err := conn.PrepareBatch(ctx, "INSERT INTO "+quoted)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		row := v.Index(i).Interface()
+		if values, ok := row.([]interface{}); ok {
+			err = batch.Append(values...)
+		} else {
+			err = batch.AppendStruct(row)
+		}
+		if err != nil {
+			batch.Abort()
+			return fmt.Errorf("failed to append row %d: %w", i, err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+	return nil
+}
+
+// BatchOption is an option of a BatchWriter.
+type BatchOption func(*batchOptions)
+
+// batchOptions is the options of a BatchWriter.
+type batchOptions struct {
+	flushRows     int
+	flushInterval time.Duration
+	maxRows       int
+	timeout       time.Duration
+	errorHandler  func(error)
+	registry      prometheus.Registerer
+}
+
+// WithFlushRows sets the number of buffered rows triggering a flush, 10000
+// by default.
+func WithFlushRows(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.flushRows = n
+	}
+}
+
+// WithFlushInterval sets the interval of the flushes, 1s by default.
+func WithFlushInterval(interval time.Duration) BatchOption {
+	return func(o *batchOptions) {
+		o.flushInterval = interval
+	}
+}
+
+// WithMaxBufferedRows sets the number of buffered rows above which the rows
+// are dropped, 10 times the flush rows by default.
+func WithMaxBufferedRows(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.maxRows = n
+	}
+}
+
+// WithFlushTimeout sets the timeout of the flushes, 30s by default.
+func WithFlushTimeout(timeout time.Duration) BatchOption {
+	return func(o *batchOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithFlushErrorHandler sets the handler of the failed background flushes,
+// whose rows are lost. The errors are logged by default.
+func WithFlushErrorHandler(handler func(error)) BatchOption {
+	return func(o *batchOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// WithBatchRegistry sets the registry of the writer metrics,
+// prometheus.DefaultRegisterer by default, nil disables them.
+func WithBatchRegistry(registry prometheus.Registerer) BatchOption {
+	return func(o *batchOptions) {
+		o.registry = registry
+	}
+}
+
+// BatchWriter buffers rows and inserts them into a table in batches, when
+// enough rows are buffered or at every interval.
+type BatchWriter struct {
+	connector *Connector
+	table     string
+	opts      batchOptions
+	metrics   *batchMetrics
+	batcher   *batcher.Batcher[interface{}]
+}
+
+// NewBatchWriter creates a writer inserting rows into table in the
+// background. The rows are those accepted by BatchInsert.
+func (c *Connector) NewBatchWriter(table string, opts ...BatchOption) *BatchWriter {
+	options := batchOptions{
+		flushRows:     10000,
+		flushInterval: time.Second,
+		timeout:       30 * time.Second,
+		errorHandler: func(err error) {
+			connector.Log(context.Background()).Errorf("%v", err)
+		},
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.flushRows <= 0 {
+		options.flushRows = 1
+	}
+	if options.maxRows <= 0 {
+		options.maxRows = 10 * options.flushRows
+	}
+
+	w := &BatchWriter{
+		connector: c,
+		table:     table,
+		opts:      options,
+		metrics:   newBatchMetrics(options.registry),
+	}
+	w.batcher = batcher.New(w.flush,
+		batcher.WithSize(options.flushRows),
+		batcher.WithInterval(options.flushInterval),
+		batcher.WithQueueSize(options.maxRows),
+		batcher.WithFlushTimeout(options.timeout),
+		batcher.WithErrorHandler(func(err error, _ int) {
+			if options.errorHandler != nil {
+				options.errorHandler(err)
+			}
+		}),
+	)
+	return w
+}
+
+// Write buffers a row. It doesn't block, ErrBufferFull is returned and the
+// row dropped if too many rows are buffered.
+func (w *BatchWriter) Write(row interface{}) error {
+	err := w.batcher.TryAdd(row)
+	if errors.Is(err, batcher.ErrFull) {
+		w.metrics.rows.WithLabelValues(w.table, "dropped").Inc()
+	}
+	return err
+}
+
+// Close stops the writer and inserts the buffered rows, waiting until ctx
+// is done.
+func (w *BatchWriter) Close(ctx context.Context) error {
+	return w.batcher.Close(ctx)
+}
+
+// flush inserts a batch of rows.
+func (w *BatchWriter) flush(ctx context.Context, rows []interface{}) error {
+	start := time.Now()
+	err := w.connector.BatchInsert(ctx, w.table, rows)
+
+	status, result := "success", "inserted"
+	if err != nil {
+		status, result = "error", "failed"
+	}
+	w.metrics.flushes.WithLabelValues(w.table, status).Observe(time.Since(start).Seconds())
+	w.metrics.rows.WithLabelValues(w.table, result).Add(float64(len(rows)))
+
+	if err != nil {
+		return fmt.Errorf("failed to insert %d rows into %s: %w", len(rows), w.table, err)
+	}
+	return nil
+}
+
+// identifier matches the unquoted ClickHouse identifiers.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// quoteTable returns the quoted name of a table, "table" or
+// "database.table", rejecting the names that aren't plain identifiers.
+func quoteTable(table string) (string, error) {
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("invalid table name %q", table)
+	}
+	for i, part := range parts {
+		part = strings.Trim(part, "`")
+		if !identifier.MatchString(part) {
+			return "", fmt.Errorf("invalid table name %q", table)
+		}
+		parts[i] = "`" + part + "`"
+	}
+	return strings.Join(parts, "."), nil
+}
@@ -13,7 +13,6 @@ import (
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
-	"github.com/cloudwego/kitex/pkg/klog"
 	"new-milli/connector"
 )
 
@@ -82,6 +81,7 @@ type Connector struct {
 	mu         sync.RWMutex
 	connected  bool
 	tlsConfig  *tls.Config
+	events    connector.Notifier
 }
 
 // New creates a new ClickHouse connector.
@@ -96,7 +96,7 @@ func New(opts ...connector.Option) connector.Connector {
 }
 
 // Connect connects to the database.
-func (c *Connector) Connect(ctx context.Context) error {
+func (c *Connector) Connect(ctx context.Context) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -104,6 +104,9 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return connector.ErrAlreadyConnected
 	}
 
+	c.events.Notify(c.config.Name, connector.Connecting, nil)
+	defer func() { c.events.Connected(c.config.Name, err) }()
+
 	// Setup TLS if enabled
 	if c.config.EnableTLS {
 		if err := c.setupTLS(); err != nil {
@@ -119,6 +122,20 @@ func (c *Connector) Connect(ctx context.Context) error {
 		addresses = []string{c.config.Address}
 	}
 
+	compression, err := compressionMethod(c.config.Compression)
+	if err != nil {
+		return err
+	}
+
+	// MaxExecutionTime is a server setting
+	settings := make(clickhouse.Settings, len(c.config.Settings)+1)
+	for key, value := range c.config.Settings {
+		settings[key] = value
+	}
+	if _, ok := settings["max_execution_time"]; !ok && c.config.MaxExecutionTime > 0 {
+		settings["max_execution_time"] = int(c.config.MaxExecutionTime.Seconds())
+	}
+
 	// Create ClickHouse options
 	options := &clickhouse.Options{
 		Addr: addresses,
@@ -127,9 +144,9 @@ func (c *Connector) Connect(ctx context.Context) error {
 			Username: c.config.Username,
 			Password: c.config.Password,
 		},
-		Settings: c.config.Settings,
+		Settings: settings,
 		Compression: &clickhouse.Compression{
-			Method: c.config.Compression,
+			Method: compression,
 		},
 		Debug:                c.config.Debug,
 		DialTimeout:          c.config.DialTimeout,
@@ -140,8 +157,6 @@ func (c *Connector) Connect(ctx context.Context) error {
 		BlockBufferSize:      c.config.BlockBufferSize,
 		MaxCompressionBuffer: c.config.MaxCompressionBuffer,
 		ReadTimeout:          c.config.ReadTimeout,
-		WriteTimeout:         c.config.WriteTimeout,
-		MaxExecutionTime:     c.config.MaxExecutionTime,
 	}
 
 	// Set TLS config if enabled
@@ -173,7 +188,7 @@ func (c *Connector) Connect(ctx context.Context) error {
 	c.conn = conn
 	c.db = db
 	c.connected = true
-	klog.Infof("Connected to ClickHouse at %s", c.config.Address)
+	connector.Log(ctx).Infof("Connected to ClickHouse at %s", c.config.Address)
 	return nil
 }
 
@@ -197,12 +212,13 @@ func (c *Connector) Disconnect(ctx context.Context) error {
 	c.conn = nil
 	c.db = nil
 	c.connected = false
-	klog.Infof("Disconnected from ClickHouse at %s", c.config.Address)
+	connector.Log(ctx).Infof("Disconnected from ClickHouse at %s", c.config.Address)
+	c.events.Disconnected(c.config.Name)
 	return nil
 }
 
 // Ping checks if the database is reachable.
-func (c *Connector) Ping(ctx context.Context) error {
+func (c *Connector) Ping(ctx context.Context) (err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -210,6 +226,8 @@ func (c *Connector) Ping(ctx context.Context) error {
 		return connector.ErrNotConnected
 	}
 
+	defer func() { c.events.Ping(c.config.Name, err) }()
+
 	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer cancel()
 	if err := c.conn.Ping(ctx); err != nil {
@@ -219,6 +237,24 @@ func (c *Connector) Ping(ctx context.Context) error {
 	return nil
 }
 
+// Stats returns the connection pool statistics.
+func (c *Connector) Stats() connector.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.connected {
+		return connector.Stats{}
+	}
+	// The native connection and the database/sql DB have separate pools
+	s := c.conn.Stats()
+	stats := connector.SQLStats(c.db.Stats())
+	stats.MaxOpen += s.MaxOpenConns
+	stats.Open += s.Open
+	stats.Idle += s.Idle
+	stats.InUse += s.Open - s.Idle
+	return stats
+}
+
 // IsConnected returns true if the connector is connected.
 func (c *Connector) IsConnected() bool {
 	c.mu.RLock()
@@ -252,6 +288,25 @@ func (c *Connector) DB() *sql.DB {
 	return c.db
 }
 
+// compressionMethod returns the compression method named name.
+func compressionMethod(name string) (clickhouse.CompressionMethod, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return clickhouse.CompressionNone, nil
+	case "lz4":
+		return clickhouse.CompressionLZ4, nil
+	case "zstd":
+		return clickhouse.CompressionZSTD, nil
+	case "gzip":
+		return clickhouse.CompressionGZIP, nil
+	case "deflate":
+		return clickhouse.CompressionDeflate, nil
+	case "br", "brotli":
+		return clickhouse.CompressionBrotli, nil
+	}
+	return clickhouse.CompressionNone, fmt.Errorf("unsupported compression method: %s", name)
+}
+
 // setupTLS sets up TLS for the ClickHouse connection.
 func (c *Connector) setupTLS() error {
 	tlsConfig := &tls.Config{
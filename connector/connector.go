@@ -3,6 +3,8 @@ package connector
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 )
 
@@ -102,6 +104,28 @@ func (r *Registry) List() map[string]Connector {
 	return r.connectors
 }
 
+// Connect connects the connectors not connected yet in name order, stopping
+// at the first failure. Its signature fits the start hooks of the
+// application.
+func (r *Registry) Connect(ctx context.Context) error {
+	names := make([]string, 0, len(r.connectors))
+	for name := range r.connectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		c := r.connectors[name]
+		if c.IsConnected() {
+			continue
+		}
+		if err := c.Connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect connector %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 // Close closes all registered connectors.
 func (r *Registry) Close(ctx context.Context) error {
 	var lastErr error
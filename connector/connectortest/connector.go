@@ -0,0 +1,262 @@
+// Package connectortest provides fakes of the connectors and of the service
+// registry for unit tests, e.g. of health checks, discovery-aware clients
+// and reconnect logic, without real infrastructure.
+package connectortest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"new-milli/connector"
+)
+
+var (
+	_ connector.Connector = (*Connector)(nil)
+)
+
+// Config is the configuration of a fake connector.
+type Config struct {
+	connector.Config
+	// Client is returned by Client.
+	Client interface{}
+	// Latency delays Connect and Ping.
+	Latency time.Duration
+	// ConnectErr fails Connect.
+	ConnectErr error
+	// PingErr fails Ping.
+	PingErr error
+}
+
+// Connector is a fake connector. It emits the connector events like the
+// real connectors, and its failures and latency can be changed while it is
+// used.
+type Connector struct {
+	mu          sync.Mutex
+	config      *Config
+	connected   bool
+	failPings   int
+	failErr     error
+	connects    int
+	disconnects int
+	pings       int
+	events      connector.Notifier
+}
+
+// New creates a new fake connector named "fake" by default.
+func New(opts ...connector.Option) *Connector {
+	config := &Config{
+		Config: connector.Config{Name: "fake"},
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return &Connector{
+		config: config,
+	}
+}
+
+// Connect connects the connector, after the latency, unless it is set to
+// fail.
+func (c *Connector) Connect(ctx context.Context) (err error) {
+	c.mu.Lock()
+	if c.connected {
+		c.mu.Unlock()
+		return connector.ErrAlreadyConnected
+	}
+	c.connects++
+	latency, connectErr := c.config.Latency, c.config.ConnectErr
+	c.mu.Unlock()
+
+	c.events.Notify(c.config.Name, connector.Connecting, nil)
+	defer func() { c.events.Connected(c.config.Name, err) }()
+
+	if err := wait(ctx, latency); err != nil {
+		return err
+	}
+	if connectErr != nil {
+		return connectErr
+	}
+
+	c.mu.Lock()
+	c.connected = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Disconnect disconnects the connector.
+func (c *Connector) Disconnect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		return connector.ErrNotConnected
+	}
+
+	c.disconnects++
+	c.connected = false
+	c.events.Disconnected(c.config.Name)
+	return nil
+}
+
+// Ping pings the connector, after the latency, unless it is set to fail.
+func (c *Connector) Ping(ctx context.Context) (err error) {
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		return connector.ErrNotConnected
+	}
+	c.pings++
+	latency, pingErr := c.config.Latency, c.config.PingErr
+	if c.failPings > 0 {
+		c.failPings--
+		pingErr = c.failErr
+	}
+	c.mu.Unlock()
+
+	defer func() { c.events.Ping(c.config.Name, err) }()
+
+	if err := wait(ctx, latency); err != nil {
+		return err
+	}
+	return pingErr
+}
+
+// IsConnected returns true if the connector is connected.
+func (c *Connector) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// Name returns the name of the connector.
+func (c *Connector) Name() string {
+	return c.config.Name
+}
+
+// Client returns the client set by WithClient.
+func (c *Connector) Client() interface{} {
+	return c.config.Client
+}
+
+// SetConnectError sets the error of the next connections, nil to succeed.
+func (c *Connector) SetConnectError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.ConnectErr = err
+}
+
+// SetPingError sets the error of the next pings, nil to succeed.
+func (c *Connector) SetPingError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.PingErr = err
+	c.failPings = 0
+}
+
+// FailPings fails the next n pings with err, the following ones succeed.
+func (c *Connector) FailPings(n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.PingErr = nil
+	c.failPings = n
+	c.failErr = err
+}
+
+// SetLatency sets the latency of the next connections and pings.
+func (c *Connector) SetLatency(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.Latency = latency
+}
+
+// Connects returns the number of calls of Connect while disconnected.
+func (c *Connector) Connects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connects
+}
+
+// Disconnects returns the number of disconnections.
+func (c *Connector) Disconnects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.disconnects
+}
+
+// Pings returns the number of calls of Ping while connected.
+func (c *Connector) Pings() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pings
+}
+
+// wait waits for latency, it returns the error of ctx when it is done
+// first.
+func wait(ctx context.Context, latency time.Duration) error {
+	if latency <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WithConfig sets the configuration.
+func WithConfig(config *Config) connector.Option {
+	return func(c interface{}) {
+		if cfg, ok := c.(*Config); ok {
+			*cfg = *config
+		}
+	}
+}
+
+// WithName sets the name.
+func WithName(name string) connector.Option {
+	return func(c interface{}) {
+		if cfg, ok := c.(*Config); ok {
+			cfg.Name = name
+		}
+	}
+}
+
+// WithClient sets the client returned by Client.
+func WithClient(client interface{}) connector.Option {
+	return func(c interface{}) {
+		if cfg, ok := c.(*Config); ok {
+			cfg.Client = client
+		}
+	}
+}
+
+// WithLatency sets the latency of the connections and pings.
+func WithLatency(latency time.Duration) connector.Option {
+	return func(c interface{}) {
+		if cfg, ok := c.(*Config); ok {
+			cfg.Latency = latency
+		}
+	}
+}
+
+// WithConnectError fails the connections with err.
+func WithConnectError(err error) connector.Option {
+	return func(c interface{}) {
+		if cfg, ok := c.(*Config); ok {
+			cfg.ConnectErr = err
+		}
+	}
+}
+
+// WithPingError fails the pings with err.
+func WithPingError(err error) connector.Option {
+	return func(c interface{}) {
+		if cfg, ok := c.(*Config); ok {
+			cfg.PingErr = err
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package connectortest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"new-milli/registry"
+)
+
+var (
+	_ registry.Registry = (*Registry)(nil)
+	_ registry.Watcher  = (*watcher)(nil)
+)
+
+// Registry is an in-memory service registry. The instances are identified
+// by their ID and listed in ID order.
+type Registry struct {
+	mu       sync.Mutex
+	services map[string]map[string]*registry.ServiceInfo
+	watchers map[string]map[*watcher]struct{}
+	err      error
+}
+
+// NewRegistry creates a new in-memory service registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		services: make(map[string]map[string]*registry.ServiceInfo),
+		watchers: make(map[string]map[*watcher]struct{}),
+	}
+}
+
+// Register registers a service instance, replacing the one with its ID.
+func (r *Registry) Register(ctx context.Context, service *registry.ServiceInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	instances, ok := r.services[service.Name]
+	if !ok {
+		instances = make(map[string]*registry.ServiceInfo)
+		r.services[service.Name] = instances
+	}
+	instances[service.ID] = service
+	r.notify(service.Name)
+	return nil
+}
+
+// Deregister deregisters a service instance.
+func (r *Registry) Deregister(ctx context.Context, service *registry.ServiceInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	delete(r.services[service.Name], service.ID)
+	r.notify(service.Name)
+	return nil
+}
+
+// GetService returns the instances of a service.
+func (r *Registry) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	services := r.list(serviceName)
+	if len(services) == 0 {
+		return nil, registry.ErrNotFound
+	}
+	return services, nil
+}
+
+// Watch creates a watcher of a service, stopped when ctx is done.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watcher{
+		ctx:    ctx,
+		cancel: cancel,
+		ch:     make(chan []*registry.ServiceInfo, 1),
+	}
+	if services := r.list(serviceName); len(services) > 0 {
+		w.ch <- services
+	}
+
+	watchers, ok := r.watchers[serviceName]
+	if !ok {
+		watchers = make(map[*watcher]struct{})
+		r.watchers[serviceName] = watchers
+	}
+	watchers[w] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.watchers[serviceName], w)
+	}()
+	return w, nil
+}
+
+// SetError fails the next calls with err, nil to succeed. The watchers
+// already created keep working.
+func (r *Registry) SetError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// list returns the instances of a service in ID order.
+// It must be called with the lock held.
+func (r *Registry) list(serviceName string) []*registry.ServiceInfo {
+	instances := r.services[serviceName]
+	services := make([]*registry.ServiceInfo, 0, len(instances))
+	for _, service := range instances {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ID < services[j].ID
+	})
+	return services
+}
+
+// notify sends the instances of a service to its watchers, replacing the
+// update they didn't read yet. It must be called with the lock held.
+func (r *Registry) notify(serviceName string) {
+	services := r.list(serviceName)
+	for w := range r.watchers[serviceName] {
+		select {
+		case <-w.ch:
+		default:
+		}
+		w.ch <- services
+	}
+}
+
+// watcher is an in-memory service watcher.
+type watcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	ch     chan []*registry.ServiceInfo
+}
+
+// Next returns the next service update.
+func (w *watcher) Next() ([]*registry.ServiceInfo, error) {
+	select {
+	case <-w.ctx.Done():
+		return nil, registry.ErrWatchCanceled
+	case services := <-w.ch:
+		return services, nil
+	}
+}
+
+// Stop stops the watcher.
+func (w *watcher) Stop() error {
+	w.cancel()
+	return nil
+}
@@ -11,7 +11,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/elastic/go-elasticsearch/v8"
 	"new-milli/connector"
 )
@@ -86,6 +85,7 @@ type Connector struct {
 	mu        sync.RWMutex
 	connected bool
 	tlsConfig *tls.Config
+	events    connector.Notifier
 }
 
 // New creates a new Elasticsearch connector.
@@ -100,7 +100,7 @@ func New(opts ...connector.Option) connector.Connector {
 }
 
 // Connect connects to the database.
-func (c *Connector) Connect(ctx context.Context) error {
+func (c *Connector) Connect(ctx context.Context) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -108,6 +108,9 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return connector.ErrAlreadyConnected
 	}
 
+	c.events.Notify(c.config.Name, connector.Connecting, nil)
+	defer func() { c.events.Connected(c.config.Name, err) }()
+
 	// Setup TLS if enabled
 	if c.config.EnableTLS {
 		if err := c.setupTLS(); err != nil {
@@ -179,7 +182,7 @@ func (c *Connector) Connect(ctx context.Context) error {
 
 	c.client = client
 	c.connected = true
-	klog.Infof("Connected to Elasticsearch at %s", c.config.Address)
+	connector.Log(ctx).Infof("Connected to Elasticsearch at %s", c.config.Address)
 	return nil
 }
 
@@ -195,12 +198,13 @@ func (c *Connector) Disconnect(ctx context.Context) error {
 	// Elasticsearch client doesn't have a disconnect method
 	c.client = nil
 	c.connected = false
-	klog.Infof("Disconnected from Elasticsearch at %s", c.config.Address)
+	connector.Log(ctx).Infof("Disconnected from Elasticsearch at %s", c.config.Address)
+	c.events.Disconnected(c.config.Name)
 	return nil
 }
 
 // Ping checks if the database is reachable.
-func (c *Connector) Ping(ctx context.Context) error {
+func (c *Connector) Ping(ctx context.Context) (err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -208,6 +212,8 @@ func (c *Connector) Ping(ctx context.Context) error {
 		return connector.ErrNotConnected
 	}
 
+	defer func() { c.events.Ping(c.config.Name, err) }()
+
 	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer cancel()
 	res, err := c.client.Ping(
@@ -0,0 +1,443 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"new-milli/connector"
+)
+
+// Error is an error returned by Elasticsearch, decoded from the error
+// envelope of the response.
+type Error struct {
+	// Status is the HTTP status of the response.
+	Status int
+	// Type is the type of the error, e.g. index_not_found_exception.
+	Type string
+	// Reason is the description of the error.
+	Reason string
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("elasticsearch: status %d: %s", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("elasticsearch: status %d: %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// IsNotFound reports whether err is a not found error from Elasticsearch,
+// e.g. a missing index or document.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// errorBody is the error envelope of the responses.
+type errorBody struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// decodeResponse decodes the body of res into v, or the error envelope if
+// the request failed, and closes the body.
+func decodeResponse(res *esapi.Response, v interface{}) error {
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		e := &Error{Status: res.StatusCode}
+
+		var envelope struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(body, &envelope); err == nil && len(envelope.Error) > 0 {
+			// The error is an object, or a string for some APIs
+			var details errorBody
+			if err := json.Unmarshal(envelope.Error, &details); err == nil {
+				e.Type, e.Reason = details.Type, details.Reason
+			} else {
+				json.Unmarshal(envelope.Error, &e.Reason)
+			}
+		}
+		if e.Reason == "" && e.Type == "" {
+			e.Reason = string(bytes.TrimSpace(body))
+		}
+		return e
+	}
+
+	if v == nil {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// requestBody returns the body of a request: readers, bytes and strings as
+// is, other values encoded as JSON.
+func requestBody(v interface{}) (io.Reader, error) {
+	switch v := v.(type) {
+	case io.Reader:
+		return v, nil
+	case []byte:
+		return bytes.NewReader(v), nil
+	case json.RawMessage:
+		return bytes.NewReader(v), nil
+	case string:
+		return bytes.NewReader([]byte(v)), nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+// es returns the client if connected.
+func (c *Connector) es() (*elasticsearch.Client, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.connected {
+		return nil, connector.ErrNotConnected
+	}
+	return c.client, nil
+}
+
+// IndexOption is an option of Index.
+type IndexOption func(*indexOptions)
+
+// indexOptions is the options of Index.
+type indexOptions struct {
+	id      string
+	refresh string
+}
+
+// WithDocumentID sets the ID of the document, generated by Elasticsearch by
+// default.
+func WithDocumentID(id string) IndexOption {
+	return func(o *indexOptions) {
+		o.id = id
+	}
+}
+
+// WithRefresh sets when the change is visible to searches: "true", "false"
+// or "wait_for".
+func WithRefresh(refresh string) IndexOption {
+	return func(o *indexOptions) {
+		o.refresh = refresh
+	}
+}
+
+// Index indexes doc, encoded as JSON, and returns its ID.
+func (c *Connector) Index(ctx context.Context, index string, doc interface{}, opts ...IndexOption) (string, error) {
+	options := &indexOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	client, err := c.es()
+	if err != nil {
+		return "", err
+	}
+
+	body, err := requestBody(doc)
+	if err != nil {
+		return "", err
+	}
+
+	reqOpts := []func(*esapi.IndexRequest){client.Index.WithContext(ctx)}
+	if options.id != "" {
+		reqOpts = append(reqOpts, client.Index.WithDocumentID(options.id))
+	}
+	if options.refresh != "" {
+		reqOpts = append(reqOpts, client.Index.WithRefresh(options.refresh))
+	}
+
+	res, err := client.Index(index, body, reqOpts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to index document: %w", err)
+	}
+
+	var result struct {
+		ID string `json:"_id"`
+	}
+	if err := decodeResponse(res, &result); err != nil {
+		return "", fmt.Errorf("failed to index document: %w", err)
+	}
+	return result.ID, nil
+}
+
+// SearchResponse is the response of a search.
+type SearchResponse struct {
+	// Took is the duration of the search in milliseconds.
+	Took int64 `json:"took"`
+	// TimedOut reports whether the search timed out.
+	TimedOut bool `json:"timed_out"`
+	// Hits are the matching documents.
+	Hits struct {
+		Total struct {
+			Value    int64  `json:"value"`
+			Relation string `json:"relation"`
+		} `json:"total"`
+		MaxScore *float64 `json:"max_score"`
+		Hits     []Hit    `json:"hits"`
+	} `json:"hits"`
+	// Aggregations are the results of the aggregations by name.
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+}
+
+// Hit is a matching document.
+type Hit struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Score  *float64        `json:"_score"`
+	Source json.RawMessage `json:"_source"`
+	Sort   []interface{}   `json:"sort"`
+}
+
+// Search searches index with query, the request body, e.g.
+// map[string]interface{}{"query": ...}, and decodes the sources of the hits
+// into result, a pointer to a slice, if not nil.
+func (c *Connector) Search(ctx context.Context, index string, query interface{}, result interface{}) (*SearchResponse, error) {
+	client, err := c.es()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := requestBody(query)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Search(
+		client.Search.WithContext(ctx),
+		client.Search.WithIndex(index),
+		client.Search.WithBody(body),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	response := &SearchResponse{}
+	if err := decodeResponse(res, response); err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	if result != nil {
+		// Decode the sources as one JSON array into the slice
+		var sources bytes.Buffer
+		sources.WriteByte('[')
+		for i, hit := range response.Hits.Hits {
+			if i > 0 {
+				sources.WriteByte(',')
+			}
+			if len(hit.Source) == 0 {
+				sources.WriteString("null")
+				continue
+			}
+			sources.Write(hit.Source)
+		}
+		sources.WriteByte(']')
+
+		if err := json.Unmarshal(sources.Bytes(), result); err != nil {
+			return nil, fmt.Errorf("failed to decode hits: %w", err)
+		}
+	}
+
+	return response, nil
+}
+
+// BulkOptions is the options of BulkIndex.
+type BulkOptions struct {
+	// ID returns the ID of a document, generated by Elasticsearch when nil
+	// or empty.
+	ID func(doc interface{}) string
+	// BatchSize is the number of documents per request, 500 by default.
+	BatchSize int
+	// Refresh sets when the changes are visible to searches: "true",
+	// "false" or "wait_for".
+	Refresh string
+	// MaxRetries is the number of retries of the documents rejected because
+	// Elasticsearch is overloaded (status 429), 3 by default, -1 to disable.
+	MaxRetries int
+	// RetryBackoff is the backoff before the first retry, doubled after
+	// every retry, 100ms by default.
+	RetryBackoff time.Duration
+}
+
+// BulkItemError is a document that failed to be indexed.
+type BulkItemError struct {
+	// Position is the position of the document in the docs.
+	Position int
+	// ID is the ID of the document.
+	ID string
+	// Err is the error of the document.
+	Err *Error
+}
+
+// BulkResult is the result of BulkIndex.
+type BulkResult struct {
+	// Indexed is the number of documents indexed.
+	Indexed int
+	// Failed are the documents that failed to be indexed.
+	Failed []BulkItemError
+}
+
+// bulkResponse is the response of the bulk API.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string     `json:"_id"`
+		Status int        `json:"status"`
+		Error  *errorBody `json:"error"`
+	} `json:"items"`
+}
+
+// BulkIndex indexes docs, a slice of documents encoded as JSON, with the
+// bulk API. The documents rejected with status 429 are retried. It returns
+// an error if a request fails, or if some documents failed to be indexed,
+// which are listed in the result.
+func (c *Connector) BulkIndex(ctx context.Context, index string, docs interface{}, opts *BulkOptions) (*BulkResult, error) {
+	options := BulkOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = 3
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = 100 * time.Millisecond
+	}
+
+	v := reflect.ValueOf(docs)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("docs must be a slice, got %T", docs)
+	}
+
+	client, err := c.es()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkResult{}
+	for start := 0; start < v.Len(); start += options.BatchSize {
+		end := min(start+options.BatchSize, v.Len())
+
+		positions := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			positions = append(positions, i)
+		}
+
+		if err := c.bulk(ctx, client, index, v, positions, &options, result); err != nil {
+			return result, err
+		}
+	}
+
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("failed to index %d of %d documents: %w", len(result.Failed), v.Len(), result.Failed[0].Err)
+	}
+	return result, nil
+}
+
+// bulk indexes the documents at positions, retrying the rejected ones.
+func (c *Connector) bulk(ctx context.Context, client *elasticsearch.Client, index string, docs reflect.Value, positions []int, options *BulkOptions, result *BulkResult) error {
+	backoff := options.RetryBackoff
+
+	for attempt := 0; len(positions) > 0; attempt++ {
+		var body bytes.Buffer
+		ids := make([]string, len(positions))
+		for i, pos := range positions {
+			doc := docs.Index(pos).Interface()
+			if options.ID != nil {
+				ids[i] = options.ID(doc)
+			}
+
+			action := map[string]map[string]string{"index": {"_index": index}}
+			if ids[i] != "" {
+				action["index"]["_id"] = ids[i]
+			}
+			meta, err := json.Marshal(action)
+			if err != nil {
+				return fmt.Errorf("failed to marshal bulk action: %w", err)
+			}
+			source, err := json.Marshal(doc)
+			if err != nil {
+				return fmt.Errorf("failed to marshal document %d: %w", pos, err)
+			}
+			body.Write(meta)
+			body.WriteByte('\n')
+			body.Write(source)
+			body.WriteByte('\n')
+		}
+
+		reqOpts := []func(*esapi.BulkRequest){client.Bulk.WithContext(ctx)}
+		if options.Refresh != "" {
+			reqOpts = append(reqOpts, client.Bulk.WithRefresh(options.Refresh))
+		}
+
+		res, err := client.Bulk(&body, reqOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to send bulk request: %w", err)
+		}
+
+		var response bulkResponse
+		if err := decodeResponse(res, &response); err != nil {
+			return fmt.Errorf("failed to send bulk request: %w", err)
+		}
+		if len(response.Items) != len(positions) {
+			return fmt.Errorf("failed to send bulk request: got %d items for %d documents", len(response.Items), len(positions))
+		}
+
+		var retry []int
+		for i, item := range response.Items {
+			r := item["index"]
+			switch {
+			case r.Error == nil && r.Status < http.StatusMultipleChoices:
+				result.Indexed++
+			case r.Status == http.StatusTooManyRequests && attempt < options.MaxRetries:
+				retry = append(retry, positions[i])
+			default:
+				e := &Error{Status: r.Status}
+				if r.Error != nil {
+					e.Type, e.Reason = r.Error.Type, r.Error.Reason
+				}
+				id := r.ID
+				if id == "" {
+					id = ids[i]
+				}
+				result.Failed = append(result.Failed, BulkItemError{Position: positions[i], ID: id, Err: e})
+			}
+		}
+
+		positions = retry
+		if len(positions) == 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package elasticsearch
+
+import (
+	"context"
+
+	"new-milli/batcher"
+)
+
+// BulkIndexer indexes documents into an index in the background, with a
+// bulk request when enough documents are queued or at every interval.
+type BulkIndexer struct {
+	b *batcher.Batcher[interface{}]
+}
+
+// NewBulkIndexer creates an indexer of the documents added to it into
+// index. The bulk requests are sent with BulkIndex and opts, in batches of
+// opts.BatchSize documents, 500 by default, unless set with
+// batcher.WithSize in batchOpts. The failed batches are logged unless a
+// batcher.WithErrorHandler is set.
+func (c *Connector) NewBulkIndexer(index string, opts *BulkOptions, batchOpts ...batcher.Option) *BulkIndexer {
+	size := 500
+	if opts != nil && opts.BatchSize > 0 {
+		size = opts.BatchSize
+	}
+	flush := func(ctx context.Context, docs []interface{}) error {
+		_, err := c.BulkIndex(ctx, index, docs, opts)
+		return err
+	}
+	return &BulkIndexer{
+		b: batcher.New(flush, append([]batcher.Option{batcher.WithSize(size)}, batchOpts...)...),
+	}
+}
+
+// Add queues a document, blocking while the queue is full until ctx is
+// done.
+func (i *BulkIndexer) Add(ctx context.Context, doc interface{}) error {
+	return i.b.Add(ctx, doc)
+}
+
+// TryAdd queues a document without blocking, it returns batcher.ErrFull
+// and drops the document when the queue is full.
+func (i *BulkIndexer) TryAdd(doc interface{}) error {
+	return i.b.TryAdd(doc)
+}
+
+// Close stops the indexer and indexes the queued documents, waiting until
+// ctx is done.
+func (i *BulkIndexer) Close(ctx context.Context) error {
+	return i.b.Close(ctx)
+}
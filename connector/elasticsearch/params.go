@@ -0,0 +1,72 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"new-milli/connector"
+)
+
+func init() {
+	connector.RegisterFactory("elasticsearch", newFromParams)
+}
+
+// newFromParams creates a new Elasticsearch connector from configuration
+// parameters, see connector.FromConfig. The address is a comma separated
+// list of node URLs, and the parameters are those of connector.ApplyParams,
+// cloud_id, api_key, service_token, ca_cert, max_retries,
+// compress_request_body, discover_nodes_on_start, discover_nodes_interval,
+// enable_metrics and enable_debug_logger.
+func newFromParams(params url.Values) (connector.Connector, error) {
+	config := DefaultConfig()
+	if address, ok := connector.PopURL(params); ok {
+		config.Address = address
+	}
+	if err := connector.ApplyParams(params, &config.Config); err != nil {
+		return nil, err
+	}
+
+	strs := map[string]*string{
+		"cloud_id":      &config.CloudID,
+		"api_key":       &config.APIKey,
+		"service_token": &config.ServiceToken,
+		"ca_cert":       &config.CACert,
+	}
+	for key, s := range strs {
+		if value, ok := connector.PopParam(params, key); ok {
+			*s = value
+		}
+	}
+
+	var err error
+	bools := map[string]*bool{
+		"compress_request_body":   &config.CompressRequestBody,
+		"discover_nodes_on_start": &config.DiscoverNodesOnStart,
+		"enable_metrics":          &config.EnableMetrics,
+		"enable_debug_logger":     &config.EnableDebugLogger,
+	}
+	for key, b := range bools {
+		if value, ok := connector.PopParam(params, key); ok {
+			if *b, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+
+	if value, ok := connector.PopParam(params, "max_retries"); ok {
+		if config.MaxRetries, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid max_retries: %w", err)
+		}
+	}
+	if value, ok := connector.PopParam(params, "discover_nodes_interval"); ok {
+		if config.DiscoverNodesInterval, err = connector.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid discover_nodes_interval: %w", err)
+		}
+	}
+
+	if len(params) > 0 {
+		return nil, fmt.Errorf("%w: unknown Elasticsearch parameters %s", connector.ErrInvalidConfig, params.Encode())
+	}
+	return New(WithConfig(config)), nil
+}
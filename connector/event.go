@@ -0,0 +1,188 @@
+package connector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the type of a connector lifecycle event.
+type EventType int
+
+const (
+	// Connecting is emitted when a connector starts connecting.
+	Connecting EventType = iota
+	// Connected is emitted when a connector is connected.
+	Connected
+	// Disconnected is emitted when a connector is disconnected, or failed to
+	// connect in which case the event carries the error.
+	Disconnected
+	// PingFailed is emitted whenever the ping of a connected connector fails.
+	PingFailed
+	// Reconnected is emitted when the ping of a connector succeeds again after failing.
+	Reconnected
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case PingFailed:
+		return "ping_failed"
+	case Reconnected:
+		return "reconnected"
+	}
+	return "unknown"
+}
+
+// Event is a connector lifecycle event.
+type Event struct {
+	Type EventType
+	// Connector is the name of the connector.
+	Connector string
+	// Err is the error that caused the event, if any.
+	Err  error
+	Time time.Time
+}
+
+// Observer receives connector lifecycle events.
+type Observer interface {
+	OnEvent(event Event)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(event Event)
+
+// OnEvent implements Observer.
+func (f ObserverFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// eventQueueSize is the number of events queued before new ones are dropped.
+const eventQueueSize = 256
+
+// observers dispatches the events to the registered observers in order, from
+// a single goroutine so observers may call back into the connectors.
+var observers = struct {
+	sync.RWMutex
+	list  map[int]Observer
+	next  int
+	queue chan Event
+	once  sync.Once
+}{
+	list:  make(map[int]Observer),
+	queue: make(chan Event, eventQueueSize),
+}
+
+// Observe registers an observer of the events of all connectors and returns
+// a function removing it. Observers are called one event at a time and
+// should not block.
+func Observe(o Observer) (remove func()) {
+	observers.Lock()
+	defer observers.Unlock()
+
+	id := observers.next
+	observers.next++
+	observers.list[id] = o
+	observers.once.Do(func() { go dispatch() })
+
+	return func() {
+		observers.Lock()
+		defer observers.Unlock()
+		delete(observers.list, id)
+	}
+}
+
+// Emit sends an event to the observers. Events are dropped when the
+// observers fall too far behind.
+func Emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	observers.RLock()
+	empty := len(observers.list) == 0
+	observers.RUnlock()
+	if empty {
+		return
+	}
+
+	select {
+	case observers.queue <- event:
+	default:
+		Log(context.Background()).Warnf("connector: dropped %s event of %s, observers are too slow", event.Type, event.Connector)
+	}
+}
+
+// dispatch feeds the queued events to the observers.
+func dispatch() {
+	for event := range observers.queue {
+		observers.RLock()
+		list := make([]Observer, 0, len(observers.list))
+		for _, o := range observers.list {
+			list = append(list, o)
+		}
+		observers.RUnlock()
+
+		for _, o := range list {
+			notify(o, event)
+		}
+	}
+}
+
+// notify calls an observer, recovering from its panics.
+func notify(o Observer, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			Log(context.Background()).Errorf("connector: observer panicked on %s event of %s: %v", event.Type, event.Connector, r)
+		}
+	}()
+	o.OnEvent(event)
+}
+
+// Notifier emits the events of a connector and tracks its ping failures,
+// the zero value is ready to use.
+type Notifier struct {
+	failing atomic.Bool
+}
+
+// Notify emits an event of the named connector.
+func (n *Notifier) Notify(name string, typ EventType, err error) {
+	Emit(Event{Type: typ, Connector: name, Err: err})
+}
+
+// Connected emits Connected, or Disconnected with the error when connecting failed.
+func (n *Notifier) Connected(name string, err error) {
+	n.failing.Store(false)
+	if err != nil {
+		n.Notify(name, Disconnected, err)
+		return
+	}
+	n.Notify(name, Connected, nil)
+}
+
+// Disconnected emits Disconnected.
+func (n *Notifier) Disconnected(name string) {
+	n.failing.Store(false)
+	n.Notify(name, Disconnected, nil)
+}
+
+// Ping emits PingFailed when err isn't nil, or Reconnected when a ping
+// succeeds after failing, and returns err.
+func (n *Notifier) Ping(name string, err error) error {
+	if err != nil {
+		n.failing.Store(true)
+		n.Notify(name, PingFailed, err)
+		return err
+	}
+	if n.failing.Swap(false) {
+		n.Notify(name, Reconnected, nil)
+	}
+	return nil
+}
@@ -8,14 +8,17 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/cloudwego/kitex/pkg/klog"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"new-milli/connector"
+	"new-milli/logger"
 )
 
 // Config is the configuration for the MongoDB connector.
@@ -47,10 +50,21 @@ type Config struct {
 	WriteConcern string
 	// AppName is the application name.
 	AppName string
+	// PoolMonitor receives the connection pool events, e.g. metrics.MongoPoolMonitor.
+	PoolMonitor *event.PoolMonitor
+	// CommandMonitor receives the command events after they are logged.
+	CommandMonitor *event.CommandMonitor
+	// Logger is the logger for the connector.
+	Logger logger.Logger
+	// SlowThreshold is the threshold for slow commands.
+	SlowThreshold time.Duration
 }
 
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
+	// Create a database-specific logger
+	mongoLogger := logger.New(nil).WithFields(logger.F("component", "mongo"))
+
 	return &Config{
 		Config: connector.Config{
 			Name:            "mongo",
@@ -81,6 +95,8 @@ func DefaultConfig() *Config {
 		ReadConcern:     "local",
 		WriteConcern:    "majority",
 		AppName:         "new-milli",
+		Logger:          mongoLogger,
+		SlowThreshold:   time.Second,
 	}
 }
 
@@ -92,6 +108,8 @@ type Connector struct {
 	mu        sync.RWMutex
 	connected bool
 	tlsConfig *tls.Config
+	events    connector.Notifier
+	pool      *poolStats
 }
 
 // New creates a new MongoDB connector.
@@ -106,7 +124,7 @@ func New(opts ...connector.Option) connector.Connector {
 }
 
 // Connect connects to the database.
-func (c *Connector) Connect(ctx context.Context) error {
+func (c *Connector) Connect(ctx context.Context) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -114,6 +132,9 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return connector.ErrAlreadyConnected
 	}
 
+	c.events.Notify(c.config.Name, connector.Connecting, nil)
+	defer func() { c.events.Connected(c.config.Name, err) }()
+
 	// Setup TLS if enabled
 	if c.config.EnableTLS {
 		if err := c.setupTLS(); err != nil {
@@ -173,11 +194,30 @@ func (c *Connector) Connect(ctx context.Context) error {
 		clientOptions.SetReadConcern(&readconcern.ReadConcern{Level: c.config.ReadConcern})
 	}
 
-	// Set write concern
-	if c.config.WriteConcern != "" {
+	// Set write concern, a number of nodes or a mode such as majority
+	if n, err := strconv.Atoi(c.config.WriteConcern); err == nil {
+		clientOptions.SetWriteConcern(&writeconcern.WriteConcern{W: n})
+	} else if c.config.WriteConcern != "" {
 		clientOptions.SetWriteConcern(&writeconcern.WriteConcern{W: c.config.WriteConcern})
 	}
 
+	// Track the pool statistics, forwarding the events to the configured monitor
+	c.pool = &poolStats{}
+	pool, monitor := c.pool, c.config.PoolMonitor
+	clientOptions.SetPoolMonitor(&event.PoolMonitor{Event: func(e *event.PoolEvent) {
+		pool.observe(e)
+		if monitor != nil && monitor.Event != nil {
+			monitor.Event(e)
+		}
+	}})
+
+	// Log the commands, forwarding the events to the configured monitor
+	if c.config.Logger != nil {
+		clientOptions.SetMonitor(newCommandMonitor(c.config.Logger, c.config.SlowThreshold, c.config.CommandMonitor))
+	} else if c.config.CommandMonitor != nil {
+		clientOptions.SetMonitor(c.config.CommandMonitor)
+	}
+
 	// Connect to MongoDB
 	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer cancel()
@@ -201,7 +241,9 @@ func (c *Connector) Connect(ctx context.Context) error {
 	c.client = client
 	c.db = db
 	c.connected = true
-	klog.Infof("Connected to MongoDB at %s", c.config.Address)
+	if c.config.Logger != nil {
+		c.config.Logger.Infof("Connected to MongoDB at %s", c.config.Address)
+	}
 	return nil
 }
 
@@ -223,12 +265,15 @@ func (c *Connector) Disconnect(ctx context.Context) error {
 	c.client = nil
 	c.db = nil
 	c.connected = false
-	klog.Infof("Disconnected from MongoDB at %s", c.config.Address)
+	if c.config.Logger != nil {
+		c.config.Logger.Infof("Disconnected from MongoDB at %s", c.config.Address)
+	}
+	c.events.Disconnected(c.config.Name)
 	return nil
 }
 
 // Ping checks if the database is reachable.
-func (c *Connector) Ping(ctx context.Context) error {
+func (c *Connector) Ping(ctx context.Context) (err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -236,6 +281,8 @@ func (c *Connector) Ping(ctx context.Context) error {
 		return connector.ErrNotConnected
 	}
 
+	defer func() { c.events.Ping(c.config.Name, err) }()
+
 	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer cancel()
 	if err := c.client.Ping(ctx, readpref.Primary()); err != nil {
@@ -245,6 +292,62 @@ func (c *Connector) Ping(ctx context.Context) error {
 	return nil
 }
 
+// Stats returns the connection pool statistics.
+func (c *Connector) Stats() connector.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.connected {
+		return connector.Stats{}
+	}
+	return c.pool.stats(int(c.config.MaxPoolSize))
+}
+
+// poolStats counts the connection pool events of the client.
+type poolStats struct {
+	open     atomic.Int64
+	inUse    atomic.Int64
+	waits    atomic.Int64
+	timeouts atomic.Int64
+}
+
+// observe updates the counts from a pool event.
+func (p *poolStats) observe(e *event.PoolEvent) {
+	switch e.Type {
+	case event.ConnectionCreated:
+		p.open.Add(1)
+	case event.ConnectionClosed:
+		p.open.Add(-1)
+	case event.GetStarted:
+		p.waits.Add(1)
+	case event.GetSucceeded:
+		p.inUse.Add(1)
+	case event.ConnectionReturned:
+		p.inUse.Add(-1)
+	case event.GetFailed:
+		if e.Reason == event.ReasonTimedOut {
+			p.timeouts.Add(1)
+		}
+	}
+}
+
+// stats returns the pool statistics.
+func (p *poolStats) stats(maxOpen int) connector.Stats {
+	open, inUse := int(p.open.Load()), int(p.inUse.Load())
+	idle := open - inUse
+	if idle < 0 {
+		idle = 0
+	}
+	return connector.Stats{
+		MaxOpen:   maxOpen,
+		Open:      open,
+		Idle:      idle,
+		InUse:     inUse,
+		WaitCount: p.waits.Load(),
+		Timeouts:  p.timeouts.Load(),
+	}
+}
+
 // IsConnected returns true if the connector is connected.
 func (c *Connector) IsConnected() bool {
 	c.mu.RLock()
@@ -558,3 +661,39 @@ func WithAppName(appName string) connector.Option {
 		}
 	}
 }
+
+// WithPoolMonitor sets the monitor of the connection pool events.
+func WithPoolMonitor(monitor *event.PoolMonitor) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.PoolMonitor = monitor
+		}
+	}
+}
+
+// WithCommandMonitor sets the monitor of the command events.
+func WithCommandMonitor(monitor *event.CommandMonitor) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.CommandMonitor = monitor
+		}
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(log logger.Logger) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Logger = log
+		}
+	}
+}
+
+// WithSlowThreshold sets the threshold for slow commands.
+func WithSlowThreshold(threshold time.Duration) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.SlowThreshold = threshold
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package mongo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"new-milli/logger"
+)
+
+// commandLogger logs the commands sent to MongoDB.
+type commandLogger struct {
+	logger        logger.Logger
+	slowThreshold time.Duration
+	// collections holds the collection of the running commands by request ID,
+	// the finished events don't have it
+	collections sync.Map
+}
+
+// newCommandMonitor creates a monitor logging every command at debug level,
+// the failed commands at error level and the commands taking longer than
+// slowThreshold at warn level. The events are forwarded to next if not nil.
+func newCommandMonitor(log logger.Logger, slowThreshold time.Duration, next *event.CommandMonitor) *event.CommandMonitor {
+	l := &commandLogger{
+		logger:        log,
+		slowThreshold: slowThreshold,
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			l.started(e)
+			if next != nil && next.Started != nil {
+				next.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			l.finished(ctx, &e.CommandFinishedEvent, "")
+			if next != nil && next.Succeeded != nil {
+				next.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			l.finished(ctx, &e.CommandFinishedEvent, e.Failure)
+			if next != nil && next.Failed != nil {
+				next.Failed(ctx, e)
+			}
+		},
+	}
+}
+
+// started records the collection of a command, the value of its first
+// element, e.g. {"find": "users", ...}.
+func (l *commandLogger) started(e *event.CommandStartedEvent) {
+	elements, err := e.Command.Elements()
+	if err != nil || len(elements) == 0 {
+		return
+	}
+	if collection, ok := elements[0].Value().StringValueOK(); ok {
+		l.collections.Store(e.RequestID, collection)
+	}
+}
+
+// finished logs a command, failure is empty if it succeeded.
+func (l *commandLogger) finished(ctx context.Context, e *event.CommandFinishedEvent, failure string) {
+	fields := []logger.Field{
+		logger.F("command", e.CommandName),
+		logger.F("database", e.DatabaseName),
+		logger.F("elapsed", e.Duration),
+	}
+	if collection, ok := l.collections.LoadAndDelete(e.RequestID); ok {
+		fields = append(fields, logger.F("collection", collection))
+	}
+
+	log := l.logger.WithContext(ctx).WithFields(fields...)
+	switch {
+	case failure != "":
+		log.WithFields(logger.F("error", failure)).Error("MongoDB command failed")
+	case l.slowThreshold > 0 && e.Duration > l.slowThreshold:
+		log.WithFields(logger.F("threshold", l.slowThreshold)).Warn("MongoDB slow command")
+	default:
+		log.Debug("MongoDB command")
+	}
+}
@@ -5,16 +5,23 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
+	"errors"
 	"fmt"
 	gormlogger "gorm.io/gorm/logger"
+	"net/url"
 	"os"
 
 	"sync"
 	"time"
 
+	gomysql "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"new-milli/connector"
+	"new-milli/connector/autosize"
+	"new-milli/connector/replica"
+	"new-milli/connector/sqltx"
+	"new-milli/diagnostics"
 	"new-milli/logger"
 )
 
@@ -47,6 +54,15 @@ type Config struct {
 	LogLevel logger.Level
 	// SlowThreshold is the threshold for slow queries.
 	SlowThreshold time.Duration
+	// Autosize adjusts MaxOpenConns to the observed load.
+	Autosize bool
+	// AutosizeOptions are the options of the pool autosize controller.
+	AutosizeOptions []autosize.Option
+	// Replicas are the addresses of the read replicas, reads are routed to
+	// them and writes to Address.
+	Replicas []string
+	// ReplicaOptions are the options of the replica router.
+	ReplicaOptions []replica.Option
 }
 
 // DefaultConfig returns the default configuration.
@@ -95,6 +111,9 @@ type Connector struct {
 	connected bool
 	tlsConfig *tls.Config
 	dsn       string
+	autosize  *autosize.Controller
+	replicas  *replica.Router
+	events    connector.Notifier
 }
 
 // New creates a new MySQL connector.
@@ -109,7 +128,7 @@ func New(opts ...connector.Option) connector.Connector {
 }
 
 // Connect connects to the database.
-func (c *Connector) Connect(ctx context.Context) error {
+func (c *Connector) Connect(ctx context.Context) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -117,8 +136,11 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return connector.ErrAlreadyConnected
 	}
 
+	c.events.Notify(c.config.Name, connector.Connecting, nil)
+	defer func() { c.events.Connected(c.config.Name, err) }()
+
 	// Build DSN
-	c.dsn = c.buildDSN()
+	c.dsn = c.buildDSN(c.config.Address)
 
 	// Setup TLS if enabled
 	if c.config.EnableTLS {
@@ -172,6 +194,44 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return fmt.Errorf("failed to ping MySQL: %w", err)
 	}
 
+	// Record the statements of diagnosed requests
+	if err := db.Use(diagnostics.GormPlugin()); err != nil {
+		sqlDB.Close()
+		return fmt.Errorf("failed to register diagnostics plugin: %w", err)
+	}
+
+	// Route reads to the replicas
+	if len(c.config.Replicas) > 0 {
+		dialectors := make([]gorm.Dialector, 0, len(c.config.Replicas))
+		for _, address := range c.config.Replicas {
+			dialectors = append(dialectors, mysql.Open(c.buildDSN(address)))
+		}
+		pool := replica.Pool{
+			MaxIdleConns:    c.config.MaxIdleConns,
+			MaxOpenConns:    c.config.MaxOpenConns,
+			MaxConnLifetime: c.config.MaxConnLifetime,
+			MaxIdleTime:     c.config.MaxIdleTime,
+		}
+		router, err := replica.Use(db, c.config.Replicas, dialectors, pool, c.config.ReplicaOptions...)
+		if err != nil {
+			sqlDB.Close()
+			return err
+		}
+		c.replicas = router
+	}
+
+	// Autosize the connection pool
+	if c.config.Autosize {
+		pool := autosize.NewSQLPool(sqlDB)
+		if err := db.Use(pool.GormPlugin()); err != nil {
+			sqlDB.Close()
+			return fmt.Errorf("failed to register autosize plugin: %w", err)
+		}
+		opts := append([]autosize.Option{autosize.WithName(c.config.Name)}, c.config.AutosizeOptions...)
+		c.autosize = autosize.New(pool, opts...)
+		c.autosize.Start(ctx)
+	}
+
 	c.db = db
 	c.sqlDB = sqlDB
 	c.connected = true
@@ -188,6 +248,16 @@ func (c *Connector) Disconnect(ctx context.Context) error {
 		return connector.ErrNotConnected
 	}
 
+	if c.autosize != nil {
+		c.autosize.Stop(ctx)
+		c.autosize = nil
+	}
+
+	if c.replicas != nil {
+		c.replicas.Stop()
+		c.replicas = nil
+	}
+
 	if err := c.sqlDB.Close(); err != nil {
 		return fmt.Errorf("failed to close MySQL connection: %w", err)
 	}
@@ -196,11 +266,12 @@ func (c *Connector) Disconnect(ctx context.Context) error {
 	c.sqlDB = nil
 	c.connected = false
 	c.config.Logger.Infof("Disconnected from MySQL at %s", c.config.Address)
+	c.events.Disconnected(c.config.Name)
 	return nil
 }
 
 // Ping checks if the database is reachable.
-func (c *Connector) Ping(ctx context.Context) error {
+func (c *Connector) Ping(ctx context.Context) (err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -208,6 +279,8 @@ func (c *Connector) Ping(ctx context.Context) error {
 		return connector.ErrNotConnected
 	}
 
+	defer func() { c.events.Ping(c.config.Name, err) }()
+
 	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer cancel()
 	if err := c.sqlDB.PingContext(ctx); err != nil {
@@ -217,6 +290,17 @@ func (c *Connector) Ping(ctx context.Context) error {
 	return nil
 }
 
+// Stats returns the connection pool statistics.
+func (c *Connector) Stats() connector.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.connected {
+		return connector.Stats{}
+	}
+	return connector.SQLStats(c.sqlDB.Stats())
+}
+
 // IsConnected returns true if the connector is connected.
 func (c *Connector) IsConnected() bool {
 	c.mu.RLock()
@@ -243,13 +327,35 @@ func (c *Connector) DB() *gorm.DB {
 	return c.db
 }
 
-// buildDSN builds the MySQL DSN.
-func (c *Connector) buildDSN() string {
+// Transaction runs fn in a transaction, retrying it on the failures IsRetryable
+// reports. Calling it with a context carrying a transaction, e.g.
+// tx.Statement.Context, runs fn in a savepoint instead, see sqltx.Run.
+func (c *Connector) Transaction(ctx context.Context, fn func(tx *gorm.DB) error, opts ...sqltx.Option) error {
+	db := c.DB()
+	if db == nil {
+		return connector.ErrNotConnected
+	}
+	return sqltx.Run(ctx, db, IsRetryable, fn, append([]sqltx.Option{sqltx.WithName("mysql")}, opts...)...)
+}
+
+// IsRetryable reports whether a transaction failed with a deadlock or a lock
+// wait timeout and may succeed when run again.
+func IsRetryable(err error) bool {
+	var mysqlErr *gomysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	// ER_LOCK_DEADLOCK and ER_LOCK_WAIT_TIMEOUT
+	return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+}
+
+// buildDSN builds the MySQL DSN of the server at address.
+func (c *Connector) buildDSN(address string) string {
 	// Format: [username[:password]@][protocol[(address)]]/dbname[?param1=value1&...&paramN=valueN]
 	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s",
 		c.config.Username,
 		c.config.Password,
-		c.config.Address,
+		address,
 		c.config.Database)
 
 	// Add parameters
@@ -260,7 +366,7 @@ func (c *Connector) buildDSN() string {
 	params["readTimeout"] = c.config.ReadTimeout.String()
 	params["writeTimeout"] = c.config.WriteTimeout.String()
 	params["parseTime"] = fmt.Sprintf("%t", c.config.ParseTime)
-	params["loc"] = c.config.Loc.String()
+	params["loc"] = url.QueryEscape(c.config.Loc.String())
 	params["collation"] = c.config.Collation
 	params["allowNativePasswords"] = fmt.Sprintf("%t", c.config.AllowNativePasswords)
 	params["allowOldPasswords"] = fmt.Sprintf("%t", c.config.AllowOldPasswords)
@@ -616,3 +722,27 @@ func WithLogger(log logger.Logger) connector.Option {
 		}
 	}
 }
+
+// WithAutosize enables adjusting MaxOpenConns to the observed pool wait time and
+// query latency, within the bounds set by the options.
+func WithAutosize(opts ...autosize.Option) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Autosize = true
+			conn.AutosizeOptions = opts
+		}
+	}
+}
+
+// WithReplicas routes reads to the replicas at the addresses, which share the
+// credentials, database and pool settings of the primary. Replicas failing
+// their health checks are evicted until they recover. Use replica.Primary
+// to force a query to the primary.
+func WithReplicas(addresses []string, opts ...replica.Option) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Replicas = addresses
+			conn.ReplicaOptions = opts
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"new-milli/logger"
+)
+
+// logHook logs the failed and slow commands.
+type logHook struct {
+	logger        logger.Logger
+	slowThreshold time.Duration
+}
+
+// newLogHook creates a hook logging to log the commands failing or taking
+// longer than slowThreshold, zero to log the failures only.
+func newLogHook(log logger.Logger, slowThreshold time.Duration) redis.Hook {
+	return &logHook{
+		logger:        log,
+		slowThreshold: slowThreshold,
+	}
+}
+
+// DialHook implements redis.Hook.
+func (h *logHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.logger.WithContext(ctx).WithFields(
+				logger.F("addr", addr),
+				logger.F("error", err),
+			).Error("Redis dial failed")
+		}
+		return conn, err
+	}
+}
+
+// ProcessHook implements redis.Hook.
+func (h *logHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.log(ctx, commandString(cmd), time.Since(start), err)
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook.
+func (h *logHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+
+		names := make([]string, 0, len(cmds))
+		for _, cmd := range cmds {
+			names = append(names, commandString(cmd))
+		}
+		h.log(ctx, fmt.Sprintf("pipeline(%s)", strings.Join(names, ", ")), time.Since(start), err)
+		return err
+	}
+}
+
+// log logs a command if it failed or was slow. Cache misses aren't failures.
+func (h *logHook) log(ctx context.Context, command string, elapsed time.Duration, err error) {
+	if err == redis.Nil {
+		err = nil
+	}
+
+	switch {
+	case err != nil:
+		h.logger.WithContext(ctx).WithFields(
+			logger.F("command", command),
+			logger.F("elapsed", elapsed),
+			logger.F("error", err),
+		).Error("Redis command failed")
+	case h.slowThreshold > 0 && elapsed > h.slowThreshold:
+		h.logger.WithContext(ctx).WithFields(
+			logger.F("command", command),
+			logger.F("elapsed", elapsed),
+			logger.F("threshold", h.slowThreshold),
+		).Warn("Redis slow command")
+	}
+}
+
+// commandString returns the name and the key of a command, leaving out the
+// values which may hold credentials or personal data, e.g. "get user:1".
+func commandString(cmd redis.Cmder) string {
+	args := cmd.Args()
+	name := cmd.FullName()
+
+	switch strings.ToLower(cmd.Name()) {
+	case "auth", "hello":
+		return name
+	}
+
+	// The key follows the name, and the subcommand of full names
+	i := 1
+	if strings.Contains(name, " ") {
+		i = 2
+	}
+	if len(args) > i {
+		return fmt.Sprintf("%s %v", name, args[i])
+	}
+	return name
+}
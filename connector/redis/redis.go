@@ -10,9 +10,11 @@ import (
 	"sync"
 	"time"
 
-	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/redis/go-redis/v9"
 	"new-milli/connector"
+	"new-milli/connector/autosize"
+	"new-milli/diagnostics"
+	"new-milli/logger"
 )
 
 // Config is the configuration for the Redis connector.
@@ -44,10 +46,24 @@ type Config struct {
 	MinRetryBackoff time.Duration
 	// MaxRetryBackoff is the maximum backoff between retries.
 	MaxRetryBackoff time.Duration
+	// Autosize adjusts the number of connections in use to the observed load.
+	Autosize bool
+	// AutosizeOptions are the options of the pool autosize controller.
+	AutosizeOptions []autosize.Option
+	// Logger is the logger for the connector.
+	Logger logger.Logger
+	// SlowThreshold is the threshold for slow commands, zero disables the
+	// slow command logs.
+	SlowThreshold time.Duration
+	// Hooks are added to the client after the built-in hooks.
+	Hooks []redis.Hook
 }
 
 // DefaultConfig returns the default configuration.
 func DefaultConfig() *Config {
+	// Create a database-specific logger
+	redisLogger := logger.New(nil).WithFields(logger.F("component", "redis"))
+
 	return &Config{
 		Config: connector.Config{
 			Name:            "redis",
@@ -78,16 +94,20 @@ func DefaultConfig() *Config {
 		MaxRetries:      3,
 		MinRetryBackoff: time.Millisecond * 8,
 		MaxRetryBackoff: time.Millisecond * 512,
+		Logger:          redisLogger,
+		SlowThreshold:   time.Millisecond * 100,
 	}
 }
 
 // Connector is a Redis connector.
 type Connector struct {
-	config     *Config
-	client     redis.UniversalClient
-	mu         sync.RWMutex
-	connected  bool
-	tlsConfig  *tls.Config
+	config    *Config
+	client    redis.UniversalClient
+	mu        sync.RWMutex
+	connected bool
+	tlsConfig *tls.Config
+	autosize  *autosize.Controller
+	events    connector.Notifier
 }
 
 // New creates a new Redis connector.
@@ -102,7 +122,7 @@ func New(opts ...connector.Option) connector.Connector {
 }
 
 // Connect connects to the database.
-func (c *Connector) Connect(ctx context.Context) error {
+func (c *Connector) Connect(ctx context.Context) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -110,6 +130,9 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return connector.ErrAlreadyConnected
 	}
 
+	c.events.Notify(c.config.Name, connector.Connecting, nil)
+	defer func() { c.events.Connected(c.config.Name, err) }()
+
 	// Setup TLS if enabled
 	if c.config.EnableTLS {
 		if err := c.setupTLS(); err != nil {
@@ -150,6 +173,16 @@ func (c *Connector) Connect(ctx context.Context) error {
 		opts.TLSConfig = c.tlsConfig
 	}
 
+	// go-redis pools can't be resized, so the pool is created with the largest
+	// size the controller may pick and the connections in use are gated instead
+	var pool *autosize.RedisPool
+	var ctl *autosize.Controller
+	if c.config.Autosize {
+		pool = autosize.NewRedisPool(c.config.PoolSize)
+		ctl = autosize.New(pool, append([]autosize.Option{autosize.WithName(c.config.Name)}, c.config.AutosizeOptions...)...)
+		_, opts.PoolSize = ctl.Bounds()
+	}
+
 	// Create Redis client based on mode
 	var client redis.UniversalClient
 	switch strings.ToLower(c.config.Mode) {
@@ -219,6 +252,17 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return fmt.Errorf("unsupported Redis mode: %s", c.config.Mode)
 	}
 
+	if pool != nil {
+		client.AddHook(pool.Hook())
+	}
+	client.AddHook(diagnostics.RedisHook())
+	if c.config.Logger != nil {
+		client.AddHook(newLogHook(c.config.Logger, c.config.SlowThreshold))
+	}
+	for _, hook := range c.config.Hooks {
+		client.AddHook(hook)
+	}
+
 	// Ping the Redis server
 	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer cancel()
@@ -227,9 +271,16 @@ func (c *Connector) Connect(ctx context.Context) error {
 		return fmt.Errorf("failed to ping Redis: %w", err)
 	}
 
+	if ctl != nil {
+		ctl.Start(ctx)
+		c.autosize = ctl
+	}
+
 	c.client = client
 	c.connected = true
-	klog.Infof("Connected to Redis at %s", c.config.Address)
+	if c.config.Logger != nil {
+		c.config.Logger.Infof("Connected to Redis at %s", c.config.Address)
+	}
 	return nil
 }
 
@@ -242,18 +293,26 @@ func (c *Connector) Disconnect(ctx context.Context) error {
 		return connector.ErrNotConnected
 	}
 
+	if c.autosize != nil {
+		c.autosize.Stop(ctx)
+		c.autosize = nil
+	}
+
 	if err := c.client.Close(); err != nil {
 		return fmt.Errorf("failed to close Redis connection: %w", err)
 	}
 
 	c.client = nil
 	c.connected = false
-	klog.Infof("Disconnected from Redis at %s", c.config.Address)
+	if c.config.Logger != nil {
+		c.config.Logger.Infof("Disconnected from Redis at %s", c.config.Address)
+	}
+	c.events.Disconnected(c.config.Name)
 	return nil
 }
 
 // Ping checks if the database is reachable.
-func (c *Connector) Ping(ctx context.Context) error {
+func (c *Connector) Ping(ctx context.Context) (err error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -261,6 +320,8 @@ func (c *Connector) Ping(ctx context.Context) error {
 		return connector.ErrNotConnected
 	}
 
+	defer func() { c.events.Ping(c.config.Name, err) }()
+
 	ctx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
 	defer cancel()
 	if err := c.client.Ping(ctx).Err(); err != nil {
@@ -270,6 +331,24 @@ func (c *Connector) Ping(ctx context.Context) error {
 	return nil
 }
 
+// Stats returns the connection pool statistics.
+func (c *Connector) Stats() connector.Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.connected {
+		return connector.Stats{}
+	}
+	s := c.client.PoolStats()
+	return connector.Stats{
+		MaxOpen:  c.config.PoolSize,
+		Open:     int(s.TotalConns),
+		Idle:     int(s.IdleConns),
+		InUse:    int(s.TotalConns - s.IdleConns),
+		Timeouts: int64(s.Timeouts),
+	}
+}
+
 // IsConnected returns true if the connector is connected.
 func (c *Connector) IsConnected() bool {
 	c.mu.RLock()
@@ -296,6 +375,18 @@ func (c *Connector) Redis() redis.UniversalClient {
 	return c.client
 }
 
+// AddHook adds a hook to the client, and to the clients of later
+// connections. Hooks added before Connect run after the built-in ones.
+func (c *Connector) AddHook(hook redis.Hook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config.Hooks = append(c.config.Hooks, hook)
+	if c.client != nil {
+		c.client.AddHook(hook)
+	}
+}
+
 // setupTLS sets up TLS for the Redis connection.
 func (c *Connector) setupTLS() error {
 	tlsConfig := &tls.Config{
@@ -539,3 +630,41 @@ func WithMaxRetryBackoff(d time.Duration) connector.Option {
 		}
 	}
 }
+
+// WithAutosize enables adjusting the number of connections in use to the observed
+// wait time and command latency, within the bounds set by the options.
+func WithAutosize(opts ...autosize.Option) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Autosize = true
+			conn.AutosizeOptions = opts
+		}
+	}
+}
+
+// WithLogger sets the logger.
+func WithLogger(log logger.Logger) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Logger = log
+		}
+	}
+}
+
+// WithSlowThreshold sets the threshold for slow commands.
+func WithSlowThreshold(threshold time.Duration) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.SlowThreshold = threshold
+		}
+	}
+}
+
+// WithHooks adds hooks to the client, e.g. for tracing or metrics.
+func WithHooks(hooks ...redis.Hook) connector.Option {
+	return func(c interface{}) {
+		if conn, ok := c.(*Config); ok {
+			conn.Hooks = append(conn.Hooks, hooks...)
+		}
+	}
+}
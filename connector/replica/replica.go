@@ -0,0 +1,222 @@
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+	"new-milli/connector"
+)
+
+// Option is read/write splitting option.
+type Option func(*options)
+
+// options is read/write splitting options.
+type options struct {
+	checkInterval time.Duration
+	checkTimeout  time.Duration
+}
+
+// WithCheckInterval returns an Option that sets how often the replicas are
+// pinged, 0 disables the health checks.
+func WithCheckInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.checkInterval = interval
+	}
+}
+
+// WithCheckTimeout returns an Option that sets the timeout of a replica ping.
+func WithCheckTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.checkTimeout = timeout
+	}
+}
+
+// Pool is the connection pool settings applied to the replicas.
+type Pool struct {
+	MaxIdleConns    int
+	MaxOpenConns    int
+	MaxConnLifetime time.Duration
+	MaxIdleTime     time.Duration
+}
+
+// pinger is a connection pool that can be health checked.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Router routes the reads of a GORM DB to its healthy replicas in turn and
+// the writes and transactions to the primary. Replicas failing their health
+// check are evicted until they answer again, reads go to the primary when
+// no replica is left.
+type Router struct {
+	opts      options
+	primary   gorm.ConnPool
+	addresses []string
+
+	mu      sync.RWMutex
+	pools   []gorm.ConnPool
+	healthy map[gorm.ConnPool]bool
+	next    atomic.Uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Use registers read/write splitting on db, the primary, with one dialector
+// per replica address, and starts the health checks.
+func Use(db *gorm.DB, addresses []string, dialectors []gorm.Dialector, pool Pool, opts ...Option) (*Router, error) {
+	o := options{
+		checkInterval: time.Second * 10,
+		checkTimeout:  time.Second * 2,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r := &Router{
+		opts:      o,
+		primary:   db.Config.ConnPool,
+		addresses: addresses,
+		healthy:   make(map[gorm.ConnPool]bool),
+	}
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   r,
+	}).
+		SetMaxIdleConns(pool.MaxIdleConns).
+		SetMaxOpenConns(pool.MaxOpenConns).
+		SetConnMaxLifetime(pool.MaxConnLifetime).
+		SetConnMaxIdleTime(pool.MaxIdleTime)
+	if err := db.Use(resolver); err != nil {
+		return nil, fmt.Errorf("failed to register replicas: %w", err)
+	}
+
+	if o.checkInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.cancel = cancel
+		r.done = make(chan struct{})
+		go r.watch(ctx)
+	}
+	return r, nil
+}
+
+// Resolve implements dbresolver.Policy.
+func (r *Router) Resolve(pools []gorm.ConnPool) gorm.ConnPool {
+	r.mu.RLock()
+	known := len(r.pools) > 0
+	candidates := make([]gorm.ConnPool, 0, len(pools))
+	for _, p := range pools {
+		if healthy, ok := r.healthy[p]; !ok || healthy {
+			candidates = append(candidates, p)
+		}
+	}
+	r.mu.RUnlock()
+
+	if !known {
+		r.mu.Lock()
+		r.pools = pools
+		r.mu.Unlock()
+	}
+
+	if len(candidates) == 0 {
+		return r.primary
+	}
+	return candidates[r.next.Add(1)%uint64(len(candidates))]
+}
+
+// Healthy returns the addresses of the replicas reads are routed to.
+func (r *Router) Healthy() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var addresses []string
+	for i, p := range r.pools {
+		if healthy, ok := r.healthy[p]; (!ok || healthy) && i < len(r.addresses) {
+			addresses = append(addresses, r.addresses[i])
+		}
+	}
+	return addresses
+}
+
+// Stop stops the health checks and closes the replica connections.
+func (r *Router) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.pools {
+		if db, ok := p.(*sql.DB); ok {
+			db.Close()
+		}
+	}
+}
+
+// watch pings the replicas until ctx is done.
+func (r *Router) watch(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.opts.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.check(ctx)
+		}
+	}
+}
+
+// check pings the replicas and evicts or restores them.
+func (r *Router) check(ctx context.Context) {
+	r.mu.RLock()
+	pools := r.pools
+	r.mu.RUnlock()
+
+	for i, p := range pools {
+		pp, ok := p.(pinger)
+		if !ok {
+			continue
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, r.opts.checkTimeout)
+		err := pp.PingContext(pingCtx)
+		cancel()
+
+		address := fmt.Sprintf("#%d", i)
+		if i < len(r.addresses) {
+			address = r.addresses[i]
+		}
+
+		r.mu.Lock()
+		was, seen := r.healthy[p]
+		r.healthy[p] = err == nil
+		r.mu.Unlock()
+
+		switch {
+		case err != nil && (!seen || was):
+			connector.Log(ctx).Warnf("replica: evicted replica %s: %v", address, err)
+		case err == nil && seen && !was:
+			connector.Log(ctx).Infof("replica: restored replica %s", address)
+		}
+	}
+}
+
+// Primary returns a session of db forced to the primary, e.g. to read a
+// record just written.
+func Primary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write)
+}
+
+// Replica returns a session of db forced to a replica.
+func Replica(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Read)
+}
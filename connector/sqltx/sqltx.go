@@ -0,0 +1,173 @@
+package sqltx
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"new-milli/connector"
+)
+
+const tracerName = "new-milli/connector/sqltx"
+
+// RetryableFunc reports whether a failed transaction may succeed when run
+// again, e.g. after a serialization failure or a deadlock.
+type RetryableFunc func(err error) bool
+
+// Option is transaction option.
+type Option func(*options)
+
+// options is transaction options.
+type options struct {
+	name      string
+	retries   int
+	backoff   time.Duration
+	txOptions *sql.TxOptions
+}
+
+// WithName returns an Option that names the transaction in traces and logs.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithRetries returns an Option that sets how many times a transaction is
+// run again after a retryable failure, 0 disables retries.
+func WithRetries(retries int) Option {
+	return func(o *options) {
+		o.retries = retries
+	}
+}
+
+// WithBackoff returns an Option that sets the initial wait between retries,
+// doubled after each attempt and jittered.
+func WithBackoff(backoff time.Duration) Option {
+	return func(o *options) {
+		o.backoff = backoff
+	}
+}
+
+// WithIsolation returns an Option that sets the isolation level of the transaction.
+func WithIsolation(level sql.IsolationLevel) Option {
+	return func(o *options) {
+		if o.txOptions == nil {
+			o.txOptions = &sql.TxOptions{}
+		}
+		o.txOptions.Isolation = level
+	}
+}
+
+// WithReadOnly returns an Option that starts a read-only transaction.
+func WithReadOnly(readOnly bool) Option {
+	return func(o *options) {
+		if o.txOptions == nil {
+			o.txOptions = &sql.TxOptions{}
+		}
+		o.txOptions.ReadOnly = readOnly
+	}
+}
+
+type txKey struct{}
+
+// NewContext returns a context carrying a transaction.
+func NewContext(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// FromContext returns the transaction carried by ctx, if any.
+func FromContext(ctx context.Context) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(txKey{}).(*gorm.DB)
+	return tx, ok
+}
+
+// Run runs fn in a transaction of db, committed when fn returns nil and
+// rolled back otherwise. The context of tx carries the transaction, so Run
+// called again with it, e.g. tx.Statement.Context, opens a savepoint in the
+// same transaction instead of a new one. Transactions failing with an error
+// retryable reports are run again, fn must then be safe to repeat.
+// Commits, rollbacks and retries are recorded on a span and logged.
+func Run(ctx context.Context, db *gorm.DB, retryable RetryableFunc, fn func(tx *gorm.DB) error, opts ...Option) error {
+	o := options{
+		name:    "transaction",
+		retries: 3,
+		backoff: time.Millisecond * 50,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if tx, ok := FromContext(ctx); ok {
+		return savepoint(ctx, tx, o, fn)
+	}
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "db.transaction "+o.name, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	backoff := o.backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			txCtx := NewContext(ctx, tx)
+			return fn(tx.WithContext(txCtx))
+		}, txOptions(o)...)
+		duration := time.Since(start)
+
+		attrs := trace.WithAttributes(
+			attribute.Int("db.transaction.attempt", attempt),
+			attribute.Int64("db.transaction.duration_ms", duration.Milliseconds()),
+		)
+		if err == nil {
+			span.AddEvent("commit", attrs)
+			connector.Log(ctx).Debugf("sqltx: %s committed in %s (attempt %d)", o.name, duration, attempt)
+			return nil
+		}
+
+		span.AddEvent("rollback", attrs)
+		if attempt > o.retries || retryable == nil || !retryable(err) || ctx.Err() != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			connector.Log(ctx).Infof("sqltx: %s rolled back after %s (attempt %d): %v", o.name, duration, attempt, err)
+			return err
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1))
+		connector.Log(ctx).Warnf("sqltx: retrying %s in %s (attempt %d): %v", o.name, wait, attempt, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// savepoint runs fn in a savepoint of the transaction tx.
+func savepoint(ctx context.Context, tx *gorm.DB, o options, fn func(tx *gorm.DB) error) error {
+	span := trace.SpanFromContext(ctx)
+	err := tx.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(tx.WithContext(NewContext(ctx, tx)))
+	})
+	name := attribute.String("db.savepoint", o.name)
+	if err != nil {
+		span.AddEvent("rollback to savepoint", trace.WithAttributes(name))
+		connector.Log(ctx).Debugf("sqltx: %s rolled back to savepoint: %v", o.name, err)
+		return err
+	}
+	span.AddEvent("release savepoint", trace.WithAttributes(name))
+	return nil
+}
+
+// txOptions returns the options passed to GORM.
+func txOptions(o options) []*sql.TxOptions {
+	if o.txOptions == nil {
+		return nil
+	}
+	return []*sql.TxOptions{o.txOptions}
+}
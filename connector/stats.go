@@ -0,0 +1,59 @@
+package connector
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Stats is the normalized connection pool statistics of a connector.
+type Stats struct {
+	// MaxOpen is the maximum number of open connections, 0 if unlimited or unknown.
+	MaxOpen int
+	// Open is the number of open connections.
+	Open int
+	// Idle is the number of idle connections.
+	Idle int
+	// InUse is the number of connections in use.
+	InUse int
+	// WaitCount is the number of times a connection was waited for.
+	WaitCount int64
+	// WaitDuration is the total time spent waiting for a connection.
+	WaitDuration time.Duration
+	// Timeouts is the number of times getting a connection timed out.
+	Timeouts int64
+}
+
+// StatsProvider is implemented by connectors exposing their pool statistics.
+// The MySQL, PostgreSQL, Redis, MongoDB and ClickHouse connectors implement it.
+type StatsProvider interface {
+	// Stats returns the pool statistics, zero when not connected.
+	Stats() Stats
+}
+
+// SQLStats converts database/sql pool statistics.
+func SQLStats(s sql.DBStats) Stats {
+	return Stats{
+		MaxOpen:      s.MaxOpenConnections,
+		Open:         s.OpenConnections,
+		Idle:         s.Idle,
+		InUse:        s.InUse,
+		WaitCount:    s.WaitCount,
+		WaitDuration: s.WaitDuration,
+	}
+}
+
+// Stats returns the pool statistics of the registered connectors implementing StatsProvider.
+func (r *Registry) Stats() map[string]Stats {
+	stats := make(map[string]Stats)
+	for name, c := range r.connectors {
+		if p, ok := c.(StatsProvider); ok {
+			stats[name] = p.Stats()
+		}
+	}
+	return stats
+}
+
+// ListStats returns the pool statistics of the connectors in the global registry.
+func ListStats() map[string]Stats {
+	return global.Stats()
+}
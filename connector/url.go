@@ -0,0 +1,171 @@
+package connector
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseURL parses a connection URL of the form
+// scheme://[username[:password]@]host[:port][,host[:port]...][/database][?params]
+// into config. The query parameters are returned to be handled by the
+// connector, which applies the shared ones with ApplyParams.
+func ParseURL(rawURL string, config *Config) (*url.URL, url.Values, error) {
+	hosts, rest, err := splitHosts(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := url.Parse(rest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse connection URL: %w", err)
+	}
+	u.Host = hosts
+
+	config.Address = hosts
+	if u.User != nil {
+		config.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			config.Password = password
+		}
+	}
+	if database := strings.TrimPrefix(u.Path, "/"); database != "" {
+		config.Database = database
+	}
+
+	return u, u.Query(), nil
+}
+
+// splitHosts splits the hosts out of a connection URL, which url.Parse
+// rejects when there are several of them, and returns the URL without them.
+func splitHosts(rawURL string) (string, string, error) {
+	i := strings.Index(rawURL, "://")
+	if i < 0 {
+		return "", "", fmt.Errorf("%w: missing scheme in connection URL", ErrInvalidConfig)
+	}
+	start := i + len("://")
+
+	end := len(rawURL)
+	if j := strings.IndexAny(rawURL[start:], "/?#"); j >= 0 {
+		end = start + j
+	}
+	if j := strings.LastIndex(rawURL[start:end], "@"); j >= 0 {
+		start += j + 1
+	}
+
+	hosts := rawURL[start:end]
+	if hosts == "" {
+		return "", "", fmt.Errorf("%w: missing host in connection URL", ErrInvalidConfig)
+	}
+	return hosts, rawURL[:start] + rawURL[end:], nil
+}
+
+// ApplyParams applies the connection parameters shared by the connectors to
+// config and removes them from params:
+//
+//	name, address, username, password, database: strings, mostly set in
+//	configuration files as URLs carry them already
+//	connect_timeout, read_timeout, write_timeout: durations, e.g. 5s, or seconds
+//	max_idle_conns, max_open_conns: numbers of connections
+//	max_conn_lifetime, max_idle_time: durations
+//	tls: true, false or skip-verify
+//	tls_skip_verify: true or false
+//	tls_cert, tls_key, tls_ca: paths of the TLS files
+func ApplyParams(params url.Values, config *Config) error {
+	strs := map[string]*string{
+		"name":     &config.Name,
+		"address":  &config.Address,
+		"username": &config.Username,
+		"password": &config.Password,
+		"database": &config.Database,
+	}
+	for key, s := range strs {
+		if value, ok := PopParam(params, key); ok {
+			*s = value
+		}
+	}
+
+	var err error
+	durations := map[string]*time.Duration{
+		"connect_timeout":   &config.ConnectTimeout,
+		"read_timeout":      &config.ReadTimeout,
+		"write_timeout":     &config.WriteTimeout,
+		"max_conn_lifetime": &config.MaxConnLifetime,
+		"max_idle_time":     &config.MaxIdleTime,
+	}
+	for key, d := range durations {
+		if value, ok := PopParam(params, key); ok {
+			if *d, err = ParseDuration(value); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+
+	ints := map[string]*int{
+		"max_idle_conns": &config.MaxIdleConns,
+		"max_open_conns": &config.MaxOpenConns,
+	}
+	for key, n := range ints {
+		if value, ok := PopParam(params, key); ok {
+			if *n, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+
+	if value, ok := PopParam(params, "tls"); ok {
+		if strings.EqualFold(value, "skip-verify") {
+			config.EnableTLS, config.TLSSkipVerify = true, true
+		} else if config.EnableTLS, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid tls: %w", err)
+		}
+	}
+	if value, ok := PopParam(params, "tls_skip_verify"); ok {
+		if config.TLSSkipVerify, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid tls_skip_verify: %w", err)
+		}
+	}
+	if value, ok := PopParam(params, "tls_cert"); ok {
+		config.TLSCertPath = value
+	}
+	if value, ok := PopParam(params, "tls_key"); ok {
+		config.TLSKeyPath = value
+	}
+	if value, ok := PopParam(params, "tls_ca"); ok {
+		config.TLSCAPath = value
+	}
+
+	return nil
+}
+
+// ParseDuration parses a duration such as 1m30s, a plain number is a
+// number of seconds.
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// PopURL returns and removes the connection URL from params, the url, dsn
+// or uri parameter.
+func PopURL(params url.Values) (string, bool) {
+	for _, key := range []string{"url", "dsn", "uri"} {
+		if value, ok := PopParam(params, key); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// PopParam returns and removes the parameter key from params, the last
+// value is used if the parameter is repeated.
+func PopParam(params url.Values, key string) (string, bool) {
+	values, ok := params[key]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	delete(params, key)
+	return values[len(values)-1], true
+}
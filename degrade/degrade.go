@@ -0,0 +1,267 @@
+package degrade
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/config"
+	"new-milli/transport"
+)
+
+// ErrDegraded is returned when a feature is skipped in degraded mode.
+var ErrDegraded = transport.NewStatusError(http.StatusServiceUnavailable, "", "degrade: feature disabled in degraded mode")
+
+// Features consulted by the built-in middleware and components.
+const (
+	// FeatureDetailedLogging is the logging of successful, fast requests.
+	FeatureDetailedLogging = "detailed_logging"
+	// FeatureDownstream is the non-critical downstream calls, see Client.
+	FeatureDownstream = "downstream"
+	// FeatureEnrichment is heavy request or response enrichment.
+	FeatureEnrichment = "enrichment"
+)
+
+// Config keys read by Load.
+const (
+	KeyEnabled  = "degrade.enabled"
+	KeyReason   = "degrade.reason"
+	KeyFeatures = "degrade.features"
+)
+
+// Hook is called when a feature enters or leaves degraded mode.
+type Hook func(degraded bool)
+
+// State is the state of a degraded mode switch.
+type State struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+	// Features lists the degraded features, empty when all of them are.
+	Features []string `json:"features,omitempty"`
+}
+
+// Switch is a degraded mode flag consulted by components to turn off
+// expensive features during incidents.
+type Switch struct {
+	mu       sync.RWMutex
+	enabled  bool
+	reason   string
+	since    time.Time
+	features map[string]bool
+	hooks    map[string][]Hook
+}
+
+// New creates a switch in normal mode.
+func New() *Switch {
+	return &Switch{hooks: make(map[string][]Hook)}
+}
+
+// Enable enters degraded mode for the listed features, or all features when
+// none is listed. Enabling again replaces the features and the reason.
+func (s *Switch) Enable(reason string, features ...string) {
+	var set map[string]bool
+	if len(features) > 0 {
+		set = make(map[string]bool, len(features))
+		for _, f := range features {
+			set[f] = true
+		}
+	}
+
+	s.update(func() {
+		if !s.enabled {
+			s.since = time.Now()
+		}
+		s.enabled = true
+		s.reason = reason
+		s.features = set
+	})
+	klog.Warnf("degrade: degraded mode enabled for %s: %s", describe(features), reason)
+}
+
+// Disable leaves degraded mode.
+func (s *Switch) Disable() {
+	wasEnabled := s.Enabled()
+	s.update(func() {
+		s.enabled = false
+		s.reason = ""
+		s.since = time.Time{}
+		s.features = nil
+	})
+	if wasEnabled {
+		klog.Infof("degrade: degraded mode disabled")
+	}
+}
+
+// Enabled reports whether the switch is in degraded mode.
+func (s *Switch) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enabled
+}
+
+// Allowed reports whether a feature may run, false while it is degraded.
+func (s *Switch) Allowed(feature string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.degraded(feature)
+}
+
+// OnChange registers a hook called when a feature enters or leaves degraded
+// mode, e.g. to stop a background enrichment job. An empty feature follows
+// the switch itself. Hooks are called synchronously by Enable and Disable.
+func (s *Switch) OnChange(feature string, hook Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks[feature] = append(s.hooks[feature], hook)
+}
+
+// State returns the state of the switch.
+func (s *Switch) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := State{Enabled: s.enabled, Reason: s.reason, Since: s.since}
+	for f := range s.features {
+		state.Features = append(state.Features, f)
+	}
+	sort.Strings(state.Features)
+	return state
+}
+
+// Load applies the degraded mode set in the configuration: degrade.enabled,
+// degrade.reason and degrade.features. Call it again when the configuration
+// changes; missing keys leave the switch untouched.
+func (s *Switch) Load(cfg config.Config) error {
+	if !cfg.Has(KeyEnabled) {
+		return nil
+	}
+	enabled, err := cfg.GetBool(KeyEnabled)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		s.Disable()
+		return nil
+	}
+
+	reason, _ := cfg.GetString(KeyReason)
+	if reason == "" {
+		reason = "config"
+	}
+	var features []string
+	if cfg.Has(KeyFeatures) {
+		if features, err = cfg.GetStringSlice(KeyFeatures); err != nil {
+			return err
+		}
+	}
+
+	// Don't reset the start time or call the hooks when nothing changed
+	state := s.State()
+	if state.Enabled && state.Reason == reason && equal(state.Features, features) {
+		return nil
+	}
+	s.Enable(reason, features...)
+	return nil
+}
+
+// degraded reports whether a feature is degraded, the lock must be held.
+func (s *Switch) degraded(feature string) bool {
+	if !s.enabled {
+		return false
+	}
+	return feature == "" || s.features == nil || s.features[feature]
+}
+
+// update changes the state and calls the hooks of the features whose mode changed.
+func (s *Switch) update(change func()) {
+	s.mu.Lock()
+	before := make(map[string]bool, len(s.hooks))
+	for f := range s.hooks {
+		before[f] = s.degraded(f)
+	}
+	change()
+
+	var calls []func()
+	for f, hooks := range s.hooks {
+		degraded := s.degraded(f)
+		if degraded == before[f] {
+			continue
+		}
+		for _, hook := range hooks {
+			hook := hook
+			calls = append(calls, func() { hook(degraded) })
+		}
+	}
+	s.mu.Unlock()
+
+	for _, call := range calls {
+		call()
+	}
+}
+
+// describe returns a description of the degraded features for the logs.
+func describe(features []string) string {
+	if len(features) == 0 {
+		return "all features"
+	}
+	sorted := append([]string(nil), features...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
+
+// equal reports whether two feature lists hold the same features.
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	b = append([]string(nil), b...)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// global is the global switch.
+var global = New()
+
+// Default returns the global switch.
+func Default() *Switch {
+	return global
+}
+
+// Enable enters degraded mode on the global switch.
+func Enable(reason string, features ...string) {
+	global.Enable(reason, features...)
+}
+
+// Disable leaves degraded mode on the global switch.
+func Disable() {
+	global.Disable()
+}
+
+// Enabled reports whether the global switch is in degraded mode.
+func Enabled() bool {
+	return global.Enabled()
+}
+
+// Allowed reports whether a feature may run according to the global switch.
+func Allowed(feature string) bool {
+	return global.Allowed(feature)
+}
+
+// OnChange registers a hook on the global switch.
+func OnChange(feature string, hook Hook) {
+	global.OnChange(feature, hook)
+}
+
+// Load applies the degraded mode set in the configuration to the global switch.
+func Load(cfg config.Config) error {
+	return global.Load(cfg)
+}
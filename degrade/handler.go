@@ -0,0 +1,39 @@
+package degrade
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// Handler returns a Hertz admin handler for the switch, nil for the global one.
+// GET returns the State, POST enters degraded mode with the reason and feature
+// query parameters, e.g. POST /admin/degrade?reason=incident-42&feature=enrichment,
+// and DELETE leaves it. Mount it behind authentication.
+func Handler(s *Switch) app.HandlerFunc {
+	if s == nil {
+		s = global
+	}
+	return func(ctx context.Context, c *app.RequestContext) {
+		switch string(c.Method()) {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			reason := c.Query("reason")
+			if reason == "" {
+				reason = "admin"
+			}
+			var features []string
+			for _, f := range c.QueryArgs().PeekAll("feature") {
+				features = append(features, string(f))
+			}
+			s.Enable(reason, features...)
+		case http.MethodDelete:
+			s.Disable()
+		default:
+			c.String(http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		c.JSON(http.StatusOK, s.State())
+	}
+}
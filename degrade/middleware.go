@@ -0,0 +1,74 @@
+package degrade
+
+import (
+	"context"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// MiddlewareOption is degrade middleware option.
+type MiddlewareOption func(*middlewareOptions)
+
+// middlewareOptions is degrade middleware options.
+type middlewareOptions struct {
+	sw       *Switch
+	fallback middleware.Handler
+}
+
+// WithSwitch returns a MiddlewareOption that sets the switch consulted, the global one by default.
+func WithSwitch(s *Switch) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.sw = s
+	}
+}
+
+// WithFallback returns a MiddlewareOption that answers degraded requests with
+// the fallback instead of ErrDegraded, e.g. a cached or empty reply.
+func WithFallback(fallback middleware.Handler) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.fallback = fallback
+	}
+}
+
+// Server returns a middleware that turns off the endpoints it wraps while
+// feature is degraded, e.g. on a route group of expensive reports.
+func Server(feature string, opts ...MiddlewareOption) middleware.Middleware {
+	return guard("server", feature, opts)
+}
+
+// Client returns a middleware that skips the calls of a non-critical
+// downstream while feature, usually FeatureDownstream, is degraded.
+func Client(feature string, opts ...MiddlewareOption) middleware.Middleware {
+	return guard("client", feature, opts)
+}
+
+// guard returns a middleware short-circuiting requests while feature is degraded.
+func guard(side, feature string, opts []MiddlewareOption) middleware.Middleware {
+	cfg := middlewareOptions{sw: global}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			if cfg.sw.Allowed(feature) {
+				return handler(ctx, req)
+			}
+
+			var operation string
+			if tr, ok := transport.FromServerContext(ctx); ok && side == "server" {
+				operation = tr.Operation()
+			} else if tr, ok := transport.FromClientContext(ctx); ok {
+				operation = tr.Operation()
+			}
+			klog.CtxDebugf(ctx, "degrade: %s %s skipped, %s is degraded", side, operation, feature)
+
+			if cfg.fallback != nil {
+				return cfg.fallback(ctx, req)
+			}
+			return nil, ErrDegraded
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package diagnostics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kinds of the calls recorded by the built-in instrumentation.
+const (
+	// KindMiddleware is the time spent inside a middleware.
+	KindMiddleware = "middleware"
+	// KindSQL is a SQL statement.
+	KindSQL = "sql"
+	// KindRedis is a Redis command or pipeline.
+	KindRedis = "redis"
+)
+
+// maxNameLength is the length names, e.g. SQL statements, are truncated to.
+const maxNameLength = 256
+
+// Call is a timed call made while handling a request.
+type Call struct {
+	Kind string
+	Name string
+	// Offset is the time between the start of the request and the call.
+	Offset   time.Duration
+	Duration time.Duration
+	Err      error
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c Call) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Kind     string `json:"kind"`
+		Name     string `json:"name"`
+		Offset   string `json:"offset"`
+		Duration string `json:"duration"`
+		Error    string `json:"error,omitempty"`
+	}{
+		Kind:     c.Kind,
+		Name:     c.Name,
+		Offset:   c.Offset.String(),
+		Duration: c.Duration.String(),
+	}
+	if c.Err != nil {
+		v.Error = c.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// Bundle collects the calls of a request and, once it is slow, the stack of
+// its handler. It is safe for concurrent use.
+type Bundle struct {
+	start    time.Time
+	maxCalls int
+
+	mu      sync.Mutex
+	calls   []Call
+	dropped int
+	stack   string
+}
+
+// newBundle creates a bundle keeping up to maxCalls calls.
+func newBundle(maxCalls int) *Bundle {
+	return &Bundle{start: time.Now(), maxCalls: maxCalls}
+}
+
+// Add records a call started at start.
+func (b *Bundle) Add(kind, name string, start time.Time, err error) {
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength] + "..."
+	}
+	call := Call{
+		Kind:     kind,
+		Name:     name,
+		Offset:   start.Sub(b.start),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.calls) >= b.maxCalls {
+		b.dropped++
+		return
+	}
+	b.calls = append(b.calls, call)
+}
+
+// Calls returns the recorded calls and the number of calls dropped.
+func (b *Bundle) Calls() ([]Call, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	calls := make([]Call, len(b.calls))
+	copy(calls, b.calls)
+	return calls, b.dropped
+}
+
+// Stack returns the stack of the handler captured when the request became slow.
+func (b *Bundle) Stack() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stack
+}
+
+// setStack sets the stack of the handler.
+func (b *Bundle) setStack(stack string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stack = stack
+}
+
+type bundleKey struct{}
+
+// NewContext returns a context carrying b.
+func NewContext(ctx context.Context, b *Bundle) context.Context {
+	return context.WithValue(ctx, bundleKey{}, b)
+}
+
+// FromContext returns the bundle carried by ctx, if any.
+func FromContext(ctx context.Context) (*Bundle, bool) {
+	b, ok := ctx.Value(bundleKey{}).(*Bundle)
+	return b, ok
+}
+
+// Record records a call started at start in the bundle of ctx, it does
+// nothing when the request isn't diagnosed.
+func Record(ctx context.Context, kind, name string, start time.Time, err error) {
+	if ctx == nil {
+		return
+	}
+	if b, ok := FromContext(ctx); ok {
+		b.Add(kind, name, start, err)
+	}
+}
+
+// goroutineID returns the id of the calling goroutine.
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	line := bytes.TrimPrefix(buf[:n], []byte("goroutine "))
+	if i := bytes.IndexByte(line, ' '); i > 0 {
+		id, _ := strconv.ParseUint(string(line[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+// goroutineStack returns the stack of the goroutine id.
+func goroutineStack(id uint64) string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 16<<20 {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	prefix := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return string(stack)
+		}
+	}
+	return ""
+}
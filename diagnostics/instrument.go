@@ -0,0 +1,109 @@
+package diagnostics
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// GormPlugin returns a GORM plugin recording every statement in the bundle
+// of its context.
+func GormPlugin() gorm.Plugin {
+	return gormPlugin{}
+}
+
+// gormPlugin records GORM statements.
+type gormPlugin struct{}
+
+// startKey is the statement setting holding the start time.
+const startKey = "diagnostics:start"
+
+// Name implements gorm.Plugin.
+func (gormPlugin) Name() string {
+	return "diagnostics"
+}
+
+// Initialize implements gorm.Plugin.
+func (gormPlugin) Initialize(db *gorm.DB) error {
+	before := func(db *gorm.DB) {
+		if _, ok := FromContext(db.Statement.Context); ok {
+			db.InstanceSet(startKey, time.Now())
+		}
+	}
+	after := func(db *gorm.DB) {
+		if v, ok := db.InstanceGet(startKey); ok {
+			if start, ok := v.(time.Time); ok {
+				Record(db.Statement.Context, KindSQL, db.Statement.SQL.String(), start, db.Error)
+			}
+		}
+	}
+
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("diagnostics:before_create", before),
+		cb.Create().After("gorm:create").Register("diagnostics:after_create", after),
+		cb.Query().Before("gorm:query").Register("diagnostics:before_query", before),
+		cb.Query().After("gorm:query").Register("diagnostics:after_query", after),
+		cb.Update().Before("gorm:update").Register("diagnostics:before_update", before),
+		cb.Update().After("gorm:update").Register("diagnostics:after_update", after),
+		cb.Delete().Before("gorm:delete").Register("diagnostics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("diagnostics:after_delete", after),
+		cb.Row().Before("gorm:row").Register("diagnostics:before_row", before),
+		cb.Row().After("gorm:row").Register("diagnostics:after_row", after),
+		cb.Raw().Before("gorm:raw").Register("diagnostics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("diagnostics:after_raw", after),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedisHook returns a go-redis hook recording every command in the bundle of
+// its context. Only the command names are recorded, not their arguments.
+func RedisHook() redis.Hook {
+	return redisHook{}
+}
+
+// redisHook records Redis commands.
+type redisHook struct{}
+
+// DialHook implements redis.Hook.
+func (redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// ProcessHook implements redis.Hook.
+func (redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		Record(ctx, KindRedis, cmd.FullName(), start, redisError(err))
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook.
+func (redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		Record(ctx, KindRedis, "pipeline("+strconv.Itoa(len(cmds))+")", start, redisError(err))
+		return err
+	}
+}
+
+// redisError returns err unless it is a cache miss.
+func redisError(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// Option is diagnostics option.
+type Option func(*options)
+
+// options is diagnostics options.
+type options struct {
+	disabled  bool
+	threshold time.Duration
+	maxCalls  int
+	stack     bool
+}
+
+// WithDisabled returns an Option that disables the diagnostics.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithThreshold returns an Option that sets the duration above which a
+// request is slow.
+func WithThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.threshold = threshold
+	}
+}
+
+// WithMaxCalls returns an Option that sets how many calls a bundle keeps.
+func WithMaxCalls(n int) Option {
+	return func(o *options) {
+		o.maxCalls = n
+	}
+}
+
+// WithStack returns an Option that sets whether the stack of the handler is
+// captured when the request becomes slow.
+func WithStack(enabled bool) Option {
+	return func(o *options) {
+		o.stack = enabled
+	}
+}
+
+// Report is the diagnostic bundle of a slow request.
+type Report struct {
+	Kind       string `json:"kind"`
+	Operation  string `json:"operation"`
+	Duration   string `json:"duration"`
+	Error      string `json:"error,omitempty"`
+	Middleware []Call `json:"middleware,omitempty"`
+	Calls      []Call `json:"calls,omitempty"`
+	Dropped    int    `json:"dropped,omitempty"`
+	Stack      string `json:"stack,omitempty"`
+}
+
+// Server returns a middleware that captures a diagnostic bundle of the
+// requests slower than the threshold: the middleware timings and the SQL and
+// Redis calls recorded in the context, and the stack of the handler once the
+// threshold is crossed. The bundle is logged and added to the span of the
+// request as a slow_request event.
+func Server(opts ...Option) middleware.Middleware {
+	cfg := options{
+		threshold: time.Second,
+		maxCalls:  100,
+		stack:     true,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			b := newBundle(cfg.maxCalls)
+			if cfg.stack {
+				id := goroutineID()
+				timer := time.AfterFunc(cfg.threshold, func() {
+					b.setStack(goroutineStack(id))
+				})
+				defer timer.Stop()
+			}
+
+			reply, err := handler(NewContext(ctx, b), req)
+
+			if duration := time.Since(b.start); duration > cfg.threshold {
+				report(ctx, b, duration, err)
+			}
+			return reply, err
+		}
+	}
+}
+
+// report logs the bundle of a slow request and adds it to its span.
+func report(ctx context.Context, b *Bundle, duration time.Duration, err error) {
+	r := Report{Duration: duration.String(), Stack: b.Stack()}
+	if tr, ok := transport.FromServerContext(ctx); ok {
+		r.Kind = tr.Kind().String()
+		r.Operation = tr.Operation()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	var calls []Call
+	calls, r.Dropped = b.Calls()
+	for _, call := range calls {
+		if call.Kind == KindMiddleware {
+			r.Middleware = append(r.Middleware, call)
+		} else {
+			r.Calls = append(r.Calls, call)
+		}
+	}
+
+	data, jerr := json.Marshal(r)
+	if jerr != nil {
+		klog.CtxErrorf(ctx, "diagnostics: failed to marshal slow request report: %v", jerr)
+		return
+	}
+
+	klog.CtxWarnf(ctx, "slow request [%s] %s took %s: %s", r.Kind, r.Operation, duration, data)
+
+	span := trace.SpanFromContext(ctx)
+	if span.IsRecording() {
+		span.AddEvent("slow_request", trace.WithAttributes(
+			attribute.Int64("diagnostics.duration_ms", duration.Milliseconds()),
+			attribute.Int("diagnostics.calls", len(calls)),
+			attribute.String("diagnostics.bundle", string(data)),
+		))
+	}
+}
@@ -0,0 +1,451 @@
+package errorreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"new-milli/collector"
+	"new-milli/logger"
+	"new-milli/middleware/recovery"
+	"new-milli/requestctx"
+	"new-milli/transport"
+)
+
+// Level is the severity of an event.
+type Level string
+
+// Levels of the events.
+const (
+	LevelWarning Level = "warning"
+	LevelError   Level = "error"
+	LevelFatal   Level = "fatal"
+)
+
+// Sources of the events.
+const (
+	// SourceCapture is the source of the events captured by the application.
+	SourceCapture = "capture"
+	// SourceRecovery is the source of the panics recovered by the recovery
+	// middleware.
+	SourceRecovery = "recovery"
+	// SourceLogger is the source of the entries of the loggers.
+	SourceLogger = "logger"
+	// SourceBroker is the source of the failures of the broker handlers.
+	SourceBroker = "broker"
+)
+
+// Exception is an error of an event.
+type Exception struct {
+	// Type is the type of the error, e.g. "*fs.PathError".
+	Type  string
+	Value string
+	// Stack is the stack where the error was captured, innermost frame
+	// first, empty when unknown.
+	Stack []recovery.Frame
+}
+
+// Event is an error reported to a provider.
+type Event struct {
+	ID     string
+	Time   time.Time
+	Level  Level
+	Source string
+	// Message describes the event, the message of the log entry or the
+	// error.
+	Message string
+	// Exceptions is the chain of errors of the event, outermost first.
+	Exceptions []Exception
+
+	Service     string
+	Environment string
+	Release     string
+
+	RequestID string
+	TraceID   string
+	SpanID    string
+	UserID    string
+	Tenant    string
+	// Request is the request being handled, nil outside requests.
+	Request *recovery.Request
+
+	Tags  map[string]string
+	Extra map[string]interface{}
+}
+
+// Provider sends the events to an error tracking service, e.g. Sentry.
+type Provider interface {
+	// Send sends an event.
+	Send(ctx context.Context, e *Event) error
+}
+
+// newEventsCounter creates the counter of the reported events registered
+// with registry, reusing the registered one. It isn't registered when
+// registry is nil.
+func newEventsCounter(registry prometheus.Registerer) *prometheus.CounterVec {
+	events := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "new_milli",
+		Subsystem: "errorreport",
+		Name:      "events_total",
+		Help:      "Number of reported error events by source and status.",
+	}, []string{"source", "status"})
+	if registry != nil {
+		var err error
+		if events, err = collector.Register(registry, events); err != nil {
+			klog.Warnf("Failed to register errorreport metrics: %v", err)
+		}
+	}
+	return events
+}
+
+// Option is reporter option.
+type Option func(*options)
+
+// options is reporter options.
+type options struct {
+	service     string
+	environment string
+	release     string
+	tags        map[string]string
+	sampleRate  float64
+	beforeSend  func(e *Event) *Event
+	queueSize   int
+	sendTimeout time.Duration
+	registry    prometheus.Registerer
+}
+
+// WithService sets the service name of the events, the one of their trace
+// information by default.
+func WithService(name string) Option {
+	return func(o *options) {
+		o.service = name
+	}
+}
+
+// WithEnvironment sets the environment of the events, e.g. "production",
+// the one of their trace information by default.
+func WithEnvironment(env string) Option {
+	return func(o *options) {
+		o.environment = env
+	}
+}
+
+// WithRelease sets the release of the events, e.g. the service version.
+func WithRelease(release string) Option {
+	return func(o *options) {
+		o.release = release
+	}
+}
+
+// WithTags sets tags added to every event.
+func WithTags(tags map[string]string) Option {
+	return func(o *options) {
+		o.tags = tags
+	}
+}
+
+// WithSampleRate sets the rate of the events sent, from 0 to 1, 1 by
+// default. The fatal events are always sent.
+func WithSampleRate(rate float64) Option {
+	return func(o *options) {
+		o.sampleRate = rate
+	}
+}
+
+// WithBeforeSend sets a function called on the events before they are
+// sent, e.g. to scrub data. It returns the event to send, nil to drop it.
+func WithBeforeSend(fn func(e *Event) *Event) Option {
+	return func(o *options) {
+		o.beforeSend = fn
+	}
+}
+
+// WithQueueSize sets the number of events waiting to be sent, 100 by
+// default. The events are dropped when the queue is full.
+func WithQueueSize(size int) Option {
+	return func(o *options) {
+		o.queueSize = size
+	}
+}
+
+// WithSendTimeout bounds the sending of an event, 10 seconds by default.
+func WithSendTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.sendTimeout = timeout
+	}
+}
+
+// WithRegistry sets the registry of the reporter metrics,
+// prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// Reporter enriches the errors of the application with their trace
+// information, service and environment and sends them to a provider in the
+// background. It receives the panics of the recovery middleware, the error
+// entries of the loggers and the failures of the broker handlers:
+//
+//	reporter := errorreport.New(sentry, errorreport.WithService("order"))
+//	defer reporter.Close(context.Background())
+//	logger.AddHook(reporter.LoggerHook())
+//	recovery.Server(recovery.WithReporter(reporter))
+//	b = reporter.Broker(b)
+type Reporter struct {
+	provider Provider
+	opts     options
+	events   *prometheus.CounterVec
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *Event
+	done   chan struct{}
+}
+
+// New creates a reporter sending the events to provider.
+func New(provider Provider, opts ...Option) *Reporter {
+	o := options{
+		sampleRate:  1,
+		queueSize:   100,
+		sendTimeout: 10 * time.Second,
+		registry:    prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r := &Reporter{
+		provider: provider,
+		opts:     o,
+		events:   newEventsCounter(o.registry),
+		queue:    make(chan *Event, o.queueSize),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// CaptureError reports err with the stack of the caller and returns the
+// id of the event, empty when it is dropped.
+func (r *Reporter) CaptureError(ctx context.Context, err error) string {
+	if err == nil {
+		return ""
+	}
+	e := &Event{
+		Level:      LevelError,
+		Source:     SourceCapture,
+		Message:    err.Error(),
+		Exceptions: exceptions(err, callers(3)),
+	}
+	return r.Capture(ctx, e)
+}
+
+// CaptureMessage reports a message and returns the id of the event, empty
+// when it is dropped.
+func (r *Reporter) CaptureMessage(ctx context.Context, level Level, message string) string {
+	return r.Capture(ctx, &Event{Level: level, Source: SourceCapture, Message: message})
+}
+
+// Capture enriches e with the request of ctx and the options of the
+// reporter, and queues it. It returns the id of the event, empty when it is
+// dropped. The fatal events are sent before Capture returns, since the
+// process is probably exiting.
+func (r *Reporter) Capture(ctx context.Context, e *Event) string {
+	if e.Source == "" {
+		e.Source = SourceCapture
+	}
+	if e.Level != LevelFatal && r.opts.sampleRate < 1 && rand.Float64() >= r.opts.sampleRate {
+		r.events.WithLabelValues(e.Source, "sampled").Inc()
+		return ""
+	}
+	r.enrich(ctx, e)
+	if r.opts.beforeSend != nil {
+		source := e.Source
+		if e = r.opts.beforeSend(e); e == nil {
+			r.events.WithLabelValues(source, "dropped").Inc()
+			return ""
+		}
+	}
+
+	if e.Level == LevelFatal {
+		r.send(e)
+		return e.ID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.events.WithLabelValues(e.Source, "dropped").Inc()
+		return ""
+	}
+	select {
+	case r.queue <- e:
+		return e.ID
+	default:
+		r.events.WithLabelValues(e.Source, "dropped").Inc()
+		return ""
+	}
+}
+
+// Close sends the queued events, waiting until ctx is done.
+func (r *Reporter) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run sends the queued events.
+func (r *Reporter) run() {
+	defer close(r.done)
+	for e := range r.queue {
+		r.send(e)
+	}
+}
+
+// send sends an event to the provider.
+func (r *Reporter) send(e *Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.sendTimeout)
+	defer cancel()
+
+	if err := r.provider.Send(ctx, e); err != nil {
+		r.events.WithLabelValues(e.Source, "failed").Inc()
+		// Not logged as an error, it would be reported again
+		klog.Warnf("errorreport: failed to send event %s: %v", e.ID, err)
+		return
+	}
+	r.events.WithLabelValues(e.Source, "sent").Inc()
+}
+
+// enrich sets the fields of e left empty from ctx and the options.
+func (r *Reporter) enrich(ctx context.Context, e *Event) {
+	if e.ID == "" {
+		e.ID = strings.ReplaceAll(uuid.NewString(), "-", "")
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Level == "" {
+		e.Level = LevelError
+	}
+
+	if ctx != nil {
+		if traceInfo, ok := logger.LookupTraceInfo(ctx); ok {
+			setTraceInfo(e, traceInfo)
+		}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			e.TraceID, e.SpanID = sc.TraceID().String(), sc.SpanID().String()
+		}
+		setIfEmpty(&e.RequestID, requestctx.RequestID(ctx))
+		setIfEmpty(&e.UserID, requestctx.UserID(ctx))
+		setIfEmpty(&e.Tenant, requestctx.Tenant(ctx))
+		if e.Request == nil {
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				e.Request = &recovery.Request{
+					Side:      "server",
+					Kind:      tr.Kind().String(),
+					Operation: tr.Operation(),
+				}
+				if m, ok := tr.(interface{ Method() string }); ok {
+					e.Request.Method = m.Method()
+				}
+			}
+		}
+	}
+
+	if r.opts.service != "" {
+		e.Service = r.opts.service
+	}
+	if r.opts.environment != "" {
+		e.Environment = r.opts.environment
+	}
+	setIfEmpty(&e.Release, r.opts.release)
+	if len(r.opts.tags) > 0 {
+		tags := make(map[string]string, len(r.opts.tags)+len(e.Tags))
+		for k, v := range r.opts.tags {
+			tags[k] = v
+		}
+		for k, v := range e.Tags {
+			tags[k] = v
+		}
+		e.Tags = tags
+	}
+}
+
+// setTraceInfo sets the empty fields of e from the trace information of a
+// logger.
+func setTraceInfo(e *Event, traceInfo *logger.TraceInfo) {
+	for _, f := range traceInfo.ToFields() {
+		v, _ := f.Value.(string)
+		switch logger.TraceKey(f.Key) {
+		case logger.RequestIDKey:
+			setIfEmpty(&e.RequestID, v)
+		case logger.TraceIDKey:
+			setIfEmpty(&e.TraceID, v)
+		case logger.SpanIDKey:
+			setIfEmpty(&e.SpanID, v)
+		case logger.ServiceNameKey:
+			setIfEmpty(&e.Service, v)
+		case logger.EnvironmentKey:
+			setIfEmpty(&e.Environment, v)
+		}
+	}
+}
+
+// setIfEmpty sets *field to v when it is empty.
+func setIfEmpty(field *string, v string) {
+	if *field == "" {
+		*field = v
+	}
+}
+
+// exceptions returns the chain of err, outermost first, the stack set on
+// the outermost error.
+func exceptions(err error, stack []recovery.Frame) []Exception {
+	var list []Exception
+	for err != nil {
+		list = append(list, Exception{Type: fmt.Sprintf("%T", err), Value: err.Error()})
+		err = errors.Unwrap(err)
+	}
+	if len(list) > 0 {
+		list[0].Stack = stack
+	}
+	return list
+}
+
+// callers returns the stack of the caller, skip frames up.
+func callers(skip int) []recovery.Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []recovery.Frame
+	for {
+		f, more := frames.Next()
+		stack = append(stack, recovery.Frame{Function: f.Function, File: f.File, Line: f.Line})
+		if !more {
+			break
+		}
+	}
+	return stack
+}
@@ -0,0 +1,150 @@
+package errorreport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"new-milli/broker"
+	"new-milli/logger"
+	"new-milli/middleware/recovery"
+)
+
+// Report implements recovery.Reporter, reporting the recovered panics.
+func (r *Reporter) Report(ctx context.Context, p *recovery.Panic) {
+	req := p.Request
+	e := &Event{
+		Time:    p.Time,
+		Level:   LevelError,
+		Source:  SourceRecovery,
+		Message: fmt.Sprintf("panic: %v", p.Value),
+		Exceptions: []Exception{{
+			Type:  panicType(p.Value),
+			Value: fmt.Sprint(p.Value),
+			Stack: p.Stack,
+		}},
+		RequestID: req.RequestID,
+		TraceID:   req.TraceID,
+		UserID:    req.UserID,
+		Tenant:    req.Tenant,
+		Request:   &req,
+	}
+	if req.Operation != "" {
+		e.Tags = map[string]string{"operation": req.Operation}
+	}
+	r.Capture(ctx, e)
+}
+
+// panicType returns the exception type of a panic value.
+func panicType(v interface{}) string {
+	if _, ok := v.(error); ok {
+		return fmt.Sprintf("%T", v)
+	}
+	return "panic"
+}
+
+// LoggerHook returns a hook reporting the entries of levels, the error and
+// fatal ones by default, to add with logger.AddHook. The fields of the
+// entries are reported as extra data.
+func (r *Reporter) LoggerHook(levels ...logger.Level) logger.Hook {
+	if len(levels) == 0 {
+		levels = []logger.Level{logger.ErrorLevel, logger.FatalLevel}
+	}
+	return &loggerHook{r: r, levels: levels}
+}
+
+// loggerHook reports the entries of the loggers.
+type loggerHook struct {
+	r      *Reporter
+	levels []logger.Level
+}
+
+// Levels implements logger.Hook.
+func (h *loggerHook) Levels() []logger.Level {
+	return h.levels
+}
+
+// Fire implements logger.Hook.
+func (h *loggerHook) Fire(entry *logger.HookEntry) {
+	e := &Event{
+		Time:    entry.Time,
+		Level:   levelOf(entry.Level),
+		Source:  SourceLogger,
+		Message: entry.Message,
+	}
+	if entry.Caller != "" {
+		e.Tags = map[string]string{"caller": entry.Caller}
+	}
+	if len(entry.Fields) > 0 {
+		e.Extra = make(map[string]interface{}, len(entry.Fields))
+		for _, f := range entry.Fields {
+			if err, ok := f.Value.(error); ok && len(e.Exceptions) == 0 {
+				e.Exceptions = exceptions(err, nil)
+			}
+			e.Extra[f.Key] = fmt.Sprint(f.Value)
+		}
+	}
+	if entry.TraceInfo != nil {
+		setTraceInfo(e, entry.TraceInfo)
+	}
+	h.r.Capture(entry.Context, e)
+}
+
+// levelOf returns the event level of a log level.
+func levelOf(level logger.Level) Level {
+	switch {
+	case level >= logger.FatalLevel:
+		return LevelFatal
+	case level >= logger.ErrorLevel:
+		return LevelError
+	default:
+		return LevelWarning
+	}
+}
+
+// BrokerHandler returns a handler reporting the errors of h, a handler of
+// the messages of topic. The errors are still returned to the broker.
+func (r *Reporter) BrokerHandler(topic string, h broker.Handler) broker.Handler {
+	return func(ctx context.Context, msg *broker.Message) error {
+		err := h(ctx, msg)
+		if err == nil {
+			return nil
+		}
+
+		e := &Event{
+			Level:      LevelError,
+			Source:     SourceBroker,
+			Message:    err.Error(),
+			Exceptions: exceptions(err, nil),
+			Tags:       map[string]string{"topic": topic},
+		}
+		if len(msg.Header) > 0 {
+			headers := make(map[string]string, len(msg.Header))
+			for k, v := range msg.Header {
+				if !strings.EqualFold(k, "Authorization") {
+					headers[k] = v
+				}
+			}
+			e.Extra = map[string]interface{}{"headers": headers}
+		}
+		r.Capture(ctx, e)
+		return err
+	}
+}
+
+// Broker returns b with the handlers it subscribes reporting their errors,
+// see BrokerHandler.
+func (r *Reporter) Broker(b broker.Broker) broker.Broker {
+	return &reportingBroker{Broker: b, r: r}
+}
+
+// reportingBroker is a broker whose handlers report their errors.
+type reportingBroker struct {
+	broker.Broker
+	r *Reporter
+}
+
+// Subscribe implements broker.Broker.
+func (b *reportingBroker) Subscribe(topic string, h broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	return b.Broker.Subscribe(topic, b.r.BrokerHandler(topic, h), opts...)
+}
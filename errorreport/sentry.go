@@ -0,0 +1,294 @@
+package errorreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRateLimited is returned by Sentry.Send while Sentry rejects the
+// events of the project.
+var ErrRateLimited = errors.New("errorreport: rate limited by sentry")
+
+// SentryOption is Sentry provider option.
+type SentryOption func(*Sentry)
+
+// WithHTTPClient sets the client sending the events, http.DefaultClient
+// by default.
+func WithHTTPClient(client *http.Client) SentryOption {
+	return func(s *Sentry) {
+		s.client = client
+	}
+}
+
+// WithServerName sets the server name of the events, the hostname by
+// default.
+func WithServerName(name string) SentryOption {
+	return func(s *Sentry) {
+		s.serverName = name
+	}
+}
+
+// Sentry is a provider sending the events to Sentry, or a server with a
+// compatible API such as GlitchTip, through its envelope endpoint.
+type Sentry struct {
+	dsn        string
+	endpoint   string
+	auth       string
+	client     *http.Client
+	serverName string
+	// retryAfter is the unix nanoseconds until which the events are
+	// rejected.
+	retryAfter atomic.Int64
+}
+
+// NewSentry creates a provider sending to the project of dsn, e.g.
+// "https://<key>@o0.ingest.sentry.io/<project>".
+func NewSentry(dsn string, opts ...SentryOption) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sentry dsn: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sentry dsn has no public key")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndexByte(path, '/')
+	project := path[i+1:]
+	if project == "" {
+		return nil, errors.New("sentry dsn has no project")
+	}
+	prefix := ""
+	if i > 0 {
+		prefix = "/" + path[:i]
+	}
+
+	auth := "Sentry sentry_version=7, sentry_client=new-milli/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	s := &Sentry{
+		dsn:      dsn,
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		auth:     auth,
+		client:   http.DefaultClient,
+	}
+	s.serverName, _ = os.Hostname()
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Send implements Provider.
+func (s *Sentry) Send(ctx context.Context, e *Event) error {
+	if time.Now().UnixNano() < s.retryAfter.Load() {
+		return ErrRateLimited
+	}
+
+	payload, err := json.Marshal(s.event(e))
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	var body bytes.Buffer
+	header, _ := json.Marshal(map[string]string{
+		"event_id": e.ID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+		"dsn":      s.dsn,
+	})
+	body.Write(header)
+	body.WriteString("\n")
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`+"\n", len(payload))
+	body.Write(payload)
+	body.WriteString("\n")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		delay := time.Minute
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		}
+		s.retryAfter.Store(time.Now().Add(delay).UnixNano())
+		return ErrRateLimited
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// sentryFrame is a stack frame of a Sentry event.
+type sentryFrame struct {
+	Function string `json:"function,omitempty"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	AbsPath  string `json:"abs_path,omitempty"`
+	Lineno   int    `json:"lineno,omitempty"`
+	InApp    bool   `json:"in_app"`
+}
+
+// event returns the Sentry payload of e.
+func (s *Sentry) event(e *Event) map[string]interface{} {
+	event := map[string]interface{}{
+		"event_id":    e.ID,
+		"timestamp":   e.Time.UTC().Format(time.RFC3339Nano),
+		"level":       string(e.Level),
+		"platform":    "go",
+		"logger":      e.Source,
+		"message":     map[string]string{"formatted": e.Message},
+		"server_name": s.serverName,
+	}
+	if e.Environment != "" {
+		event["environment"] = e.Environment
+	}
+	if e.Release != "" {
+		event["release"] = e.Release
+	}
+
+	// Sentry lists the chained exceptions from the innermost
+	if len(e.Exceptions) > 0 {
+		values := make([]map[string]interface{}, 0, len(e.Exceptions))
+		for i := len(e.Exceptions) - 1; i >= 0; i-- {
+			ex := e.Exceptions[i]
+			value := map[string]interface{}{"type": ex.Type, "value": ex.Value}
+			if len(ex.Stack) > 0 {
+				// and the frames from the outermost
+				frames := make([]sentryFrame, 0, len(ex.Stack))
+				for j := len(ex.Stack) - 1; j >= 0; j-- {
+					f := ex.Stack[j]
+					module, function := splitFunction(f.Function)
+					frames = append(frames, sentryFrame{
+						Function: function,
+						Module:   module,
+						Filename: fileName(f.File),
+						AbsPath:  f.File,
+						Lineno:   f.Line,
+						InApp:    inApp(f.File),
+					})
+				}
+				value["stacktrace"] = map[string]interface{}{"frames": frames}
+			}
+			values = append(values, value)
+		}
+		event["exception"] = map[string]interface{}{"values": values}
+	}
+
+	tags := map[string]string{}
+	for k, v := range e.Tags {
+		tags[k] = v
+	}
+	if e.Service != "" {
+		tags["service"] = e.Service
+	}
+	if e.RequestID != "" {
+		tags["request_id"] = e.RequestID
+	}
+	if e.Tenant != "" {
+		tags["tenant"] = e.Tenant
+	}
+	if len(tags) > 0 {
+		event["tags"] = tags
+	}
+	if len(e.Extra) > 0 {
+		event["extra"] = e.Extra
+	}
+	if e.UserID != "" {
+		event["user"] = map[string]string{"id": e.UserID}
+	}
+
+	// The trace context needs W3C ids, other ids are kept as tags
+	if len(e.TraceID) == 32 && len(e.SpanID) == 16 {
+		event["contexts"] = map[string]interface{}{
+			"trace": map[string]string{"trace_id": e.TraceID, "span_id": e.SpanID},
+		}
+	} else if e.TraceID != "" {
+		tags["trace_id"] = e.TraceID
+		event["tags"] = tags
+	}
+
+	if req := e.Request; req != nil {
+		request := map[string]interface{}{}
+		if req.Method != "" {
+			request["method"] = req.Method
+		}
+		if req.Operation != "" {
+			request["url"] = req.Operation
+		}
+		if len(req.Header) > 0 {
+			request["headers"] = req.Header
+		}
+		if len(request) > 0 {
+			event["request"] = request
+		}
+		if req.ClientIP != "" {
+			user, _ := event["user"].(map[string]string)
+			if user == nil {
+				user = map[string]string{}
+			}
+			user["ip_address"] = req.ClientIP
+			event["user"] = user
+		}
+	}
+	return event
+}
+
+// goroot is the root of the Go tree, whose frames aren't part of the
+// application.
+var goroot = runtime.GOROOT()
+
+// inApp reports whether a file belongs to the application rather than the
+// standard library or a dependency.
+func inApp(file string) bool {
+	if goroot != "" && strings.HasPrefix(file, goroot+"/") {
+		return false
+	}
+	return !strings.Contains(file, "/pkg/mod/")
+}
+
+// splitFunction splits a Go function name into its package and name, e.g.
+// "new-milli/order.(*Service).Create" into "new-milli/order" and
+// "(*Service).Create".
+func splitFunction(name string) (string, string) {
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
+
+// fileName returns the last two elements of a path, e.g. "order/service.go".
+func fileName(path string) string {
+	i := strings.LastIndexByte(path, '/')
+	if i <= 0 {
+		return path
+	}
+	if j := strings.LastIndexByte(path[:i], '/'); j >= 0 {
+		return path[j+1:]
+	}
+	return path
+}
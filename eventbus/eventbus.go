@@ -0,0 +1,350 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// ErrClosed is returned when publishing on a closed bus.
+var ErrClosed = errors.New("eventbus: closed")
+
+// Event is an event published on a bus.
+type Event struct {
+	// Topic is the topic the event was published to.
+	Topic string
+	// Payload is the published value.
+	Payload interface{}
+	// Metadata is free-form data carried along, e.g. forwarded as broker
+	// message headers by Forward.
+	Metadata map[string]string
+	// Time is the publication time.
+	Time time.Time
+}
+
+// Handler handles the events of a topic.
+type Handler func(ctx context.Context, e *Event) error
+
+// Middleware wraps the handlers of a bus, e.g. Logging or Tracing.
+type Middleware func(Handler) Handler
+
+// ErrorHandler is called with the errors of the asynchronous handlers,
+// which have no publisher to return them to.
+type ErrorHandler func(ctx context.Context, e *Event, err error)
+
+// Option is bus option.
+type Option func(*options)
+
+// options is bus options.
+type options struct {
+	middleware   []Middleware
+	workers      int
+	queueSize    int
+	errorHandler ErrorHandler
+}
+
+// WithMiddleware returns an Option that wraps every handler with mw, the
+// first one outermost.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, mw...)
+	}
+}
+
+// WithWorkers returns an Option that sets the number of goroutines running
+// the asynchronous handlers, 1 by default. The events of a topic are always
+// handled by the same worker, in order of publication.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
+// WithQueueSize returns an Option that sets the number of asynchronous
+// events each worker queues, 1024 by default. Publishing blocks while the
+// queue is full.
+func WithQueueSize(size int) Option {
+	return func(o *options) {
+		o.queueSize = size
+	}
+}
+
+// WithErrorHandler returns an Option that sets the handler of the errors of
+// the asynchronous handlers, they are logged by default.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(o *options) {
+		o.errorHandler = h
+	}
+}
+
+// SubscribeOption is subscription option.
+type SubscribeOption func(*subscription)
+
+// WithPriority returns a SubscribeOption that orders the handler among
+// those of its topic: the handlers run by decreasing priority, then in
+// order of subscription. The priority is 0 by default.
+func WithPriority(priority int) SubscribeOption {
+	return func(s *subscription) {
+		s.priority = priority
+	}
+}
+
+// Async returns a SubscribeOption that always runs the handler on the
+// workers of the bus, even for the events published with Publish, so a slow
+// handler doesn't hold the publisher.
+func Async() SubscribeOption {
+	return func(s *subscription) {
+		s.async = true
+	}
+}
+
+// subscription is a handler subscribed to a topic.
+type subscription struct {
+	topic    string
+	handler  Handler
+	priority int
+	async    bool
+	seq      uint64
+}
+
+// job is an event queued for the asynchronous handlers.
+type job struct {
+	ctx      context.Context
+	event    *Event
+	handlers []*subscription
+}
+
+// Bus dispatches the events published in the process to the handlers
+// subscribed to their topic. A handler returning an error or panicking
+// doesn't prevent the following ones from running.
+type Bus struct {
+	opts options
+
+	mu     sync.RWMutex
+	subs   map[string][]*subscription
+	seq    uint64
+	closed bool
+
+	queues []chan job
+	wg     sync.WaitGroup
+}
+
+// New creates a bus and starts its workers, Close stops them.
+func New(opts ...Option) *Bus {
+	o := options{
+		workers:   1,
+		queueSize: 1024,
+		errorHandler: func(ctx context.Context, e *Event, err error) {
+			klog.CtxErrorf(ctx, "eventbus: failed to handle event of %s: %v", e.Topic, err)
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.workers <= 0 {
+		o.workers = 1
+	}
+
+	b := &Bus{
+		opts:   o,
+		subs:   make(map[string][]*subscription),
+		queues: make([]chan job, o.workers),
+	}
+	for i := range b.queues {
+		b.queues[i] = make(chan job, o.queueSize)
+		b.wg.Add(1)
+		go b.work(b.queues[i])
+	}
+	return b
+}
+
+// Subscribe subscribes handler to topic, it returns the function
+// unsubscribing it.
+func (b *Bus) Subscribe(topic string, handler Handler, opts ...SubscribeOption) (unsubscribe func()) {
+	s := &subscription{topic: topic, handler: handler}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	s.seq = b.seq
+
+	// Copy on write, so dispatching can iterate without the lock
+	subs := append(make([]*subscription, 0, len(b.subs[topic])+1), b.subs[topic]...)
+	subs = append(subs, s)
+	sort.SliceStable(subs, func(i, j int) bool {
+		return subs[i].priority > subs[j].priority
+	})
+	b.subs[topic] = subs
+
+	return func() {
+		b.unsubscribe(s)
+	}
+}
+
+// unsubscribe removes a subscription.
+func (b *Bus) unsubscribe(s *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[s.topic]
+	for i, sub := range subs {
+		if sub == s {
+			rest := make([]*subscription, 0, len(subs)-1)
+			rest = append(rest, subs[:i]...)
+			b.subs[s.topic] = append(rest, subs[i+1:]...)
+			break
+		}
+	}
+	if len(b.subs[s.topic]) == 0 {
+		delete(b.subs, s.topic)
+	}
+}
+
+// Publish publishes payload to topic and runs the handlers in order before
+// returning their errors joined. The handlers subscribed with Async are
+// queued instead.
+func (b *Bus) Publish(ctx context.Context, topic string, payload interface{}) error {
+	return b.PublishEvent(ctx, &Event{Topic: topic, Payload: payload})
+}
+
+// PublishEvent is like Publish with the metadata of e.
+func (b *Bus) PublishEvent(ctx context.Context, e *Event) error {
+	subs, err := b.subscribers(e)
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs  []error
+		async []*subscription
+	)
+	for _, s := range subs {
+		if s.async {
+			async = append(async, s)
+			continue
+		}
+		if err := b.call(ctx, s, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(async) > 0 {
+		if err := b.enqueue(ctx, e, async); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PublishAsync publishes payload to topic and queues it for the handlers,
+// which run in order on a worker of the bus. It blocks while the queue is
+// full until ctx is done. The errors of the handlers go to the ErrorHandler.
+func (b *Bus) PublishAsync(ctx context.Context, topic string, payload interface{}) error {
+	return b.PublishEventAsync(ctx, &Event{Topic: topic, Payload: payload})
+}
+
+// PublishEventAsync is like PublishAsync with the metadata of e.
+func (b *Bus) PublishEventAsync(ctx context.Context, e *Event) error {
+	subs, err := b.subscribers(e)
+	if err != nil || len(subs) == 0 {
+		return err
+	}
+	return b.enqueue(ctx, e, subs)
+}
+
+// subscribers stamps an event and returns the subscriptions of its topic.
+func (b *Bus) subscribers(e *Event) ([]*subscription, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	return b.subs[e.Topic], nil
+}
+
+// enqueue queues an event for the asynchronous handlers, on the worker of
+// its topic.
+func (b *Bus) enqueue(ctx context.Context, e *Event, subs []*subscription) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(e.Topic))
+	queue := b.queues[h.Sum32()%uint32(len(b.queues))]
+
+	// The handlers outlive the publisher but keep its values, e.g. the trace
+	j := job{ctx: context.WithoutCancel(ctx), event: e, handlers: subs}
+	select {
+	case queue <- j:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to queue event of %s: %w", e.Topic, ctx.Err())
+	}
+}
+
+// work runs the asynchronous handlers of the events of a queue.
+func (b *Bus) work(queue chan job) {
+	defer b.wg.Done()
+	for j := range queue {
+		for _, s := range j.handlers {
+			if err := b.call(j.ctx, s, j.event); err != nil {
+				b.opts.errorHandler(j.ctx, j.event, err)
+			}
+		}
+	}
+}
+
+// call runs a handler wrapped with the middleware, turning a panic into an
+// error.
+func (b *Bus) call(ctx context.Context, s *subscription, e *Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	h := s.handler
+	for i := len(b.opts.middleware) - 1; i >= 0; i-- {
+		h = b.opts.middleware[i](h)
+	}
+	return h(ctx, e)
+}
+
+// Close stops accepting events and waits until the queued ones are handled
+// or ctx is done.
+func (b *Bus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, queue := range b.queues {
+			close(queue)
+		}
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
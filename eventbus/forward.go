@@ -0,0 +1,104 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+
+	"new-milli/broker"
+	"new-milli/codec"
+)
+
+// HeaderEventTopic records the bus topic on the messages sent by Forward.
+const HeaderEventTopic = "X-Event-Topic"
+
+// ForwardOption is forwarding option.
+type ForwardOption func(*forwardOptions)
+
+// forwardOptions is forwarding options.
+type forwardOptions struct {
+	brokerTopic string
+	codec       codec.Codec
+	filter      func(e *Event) bool
+	sync        bool
+	publishOpts []broker.PublishOption
+}
+
+// WithBrokerTopic returns a ForwardOption that sets the broker topic of the
+// messages, the bus topic by default.
+func WithBrokerTopic(topic string) ForwardOption {
+	return func(o *forwardOptions) {
+		o.brokerTopic = topic
+	}
+}
+
+// WithCodec returns a ForwardOption that sets the codec encoding the
+// payloads, codec.JSON by default.
+func WithCodec(c codec.Codec) ForwardOption {
+	return func(o *forwardOptions) {
+		o.codec = c
+	}
+}
+
+// WithFilter returns a ForwardOption that only forwards the events filter
+// returns true for.
+func WithFilter(filter func(e *Event) bool) ForwardOption {
+	return func(o *forwardOptions) {
+		o.filter = filter
+	}
+}
+
+// WithSync returns a ForwardOption that forwards the events published with
+// Publish before it returns, so the publisher gets the broker errors. By
+// default the events are forwarded by the workers of the bus.
+func WithSync() ForwardOption {
+	return func(o *forwardOptions) {
+		o.sync = true
+	}
+}
+
+// WithPublishOptions returns a ForwardOption that sets the options of the
+// broker publications.
+func WithPublishOptions(opts ...broker.PublishOption) ForwardOption {
+	return func(o *forwardOptions) {
+		o.publishOpts = opts
+	}
+}
+
+// Forward republishes the events of topic to b, so other services can
+// subscribe to the selected in-process events. The payload is encoded with
+// the codec and the metadata of the event become the message headers, along
+// with the content type and HeaderEventTopic. It returns the function
+// stopping the forwarding.
+func Forward(bus *Bus, b broker.Broker, topic string, opts ...ForwardOption) (stop func()) {
+	o := forwardOptions{
+		brokerTopic: topic,
+		codec:       codec.JSON,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var subOpts []SubscribeOption
+	if !o.sync {
+		subOpts = append(subOpts, Async())
+	}
+	return bus.Subscribe(topic, func(ctx context.Context, e *Event) error {
+		if o.filter != nil && !o.filter(e) {
+			return nil
+		}
+		body, err := o.codec.Marshal(e.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event of %s: %w", e.Topic, err)
+		}
+		header := make(map[string]string, len(e.Metadata)+2)
+		for k, v := range e.Metadata {
+			header[k] = v
+		}
+		header[broker.HeaderContentType] = o.codec.ContentType()
+		header[HeaderEventTopic] = e.Topic
+		if err := b.Publish(ctx, o.brokerTopic, &broker.Message{Header: header, Body: body}, o.publishOpts...); err != nil {
+			return fmt.Errorf("failed to forward event of %s to %s: %w", e.Topic, o.brokerTopic, err)
+		}
+		return nil
+	}, subOpts...)
+}
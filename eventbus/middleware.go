@@ -0,0 +1,53 @@
+package eventbus
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer of the event handlers.
+const tracerName = "new-milli/eventbus"
+
+// Logging returns a Middleware logging the failed handlers at error level
+// and the others at debug level, with their duration.
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e *Event) error {
+			start := time.Now()
+			err := next(ctx, e)
+			if err != nil {
+				klog.CtxErrorf(ctx, "eventbus: handler of %s failed after %s: %v", e.Topic, time.Since(start), err)
+			} else {
+				klog.CtxDebugf(ctx, "eventbus: handled %s in %s", e.Topic, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// Tracing returns a Middleware running each handler in a span named
+// "eventbus <topic>", a child of the span of the publisher.
+func Tracing() Middleware {
+	tracer := otel.Tracer(tracerName)
+	return func(next Handler) Handler {
+		return func(ctx context.Context, e *Event) error {
+			ctx, span := tracer.Start(ctx, "eventbus "+e.Topic,
+				trace.WithSpanKind(trace.SpanKindInternal),
+				trace.WithAttributes(attribute.String("eventbus.topic", e.Topic)))
+			defer span.End()
+
+			err := next(ctx, e)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	}
+}
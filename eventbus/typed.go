@@ -0,0 +1,53 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+)
+
+// Topic is a topic whose events carry a T, so publishers and handlers
+// agree on the payload at compile time:
+//
+//	var OrderPlaced = eventbus.NewTopic[OrderPlacedEvent]("order.placed")
+//
+//	OrderPlaced.Subscribe(bus, func(ctx context.Context, e OrderPlacedEvent) error {
+//		return mailer.SendConfirmation(ctx, e.OrderID)
+//	})
+//	err := OrderPlaced.Publish(ctx, bus, OrderPlacedEvent{OrderID: id})
+type Topic[T any] struct {
+	name string
+}
+
+// NewTopic returns the topic named name with payloads of type T.
+func NewTopic[T any](name string) Topic[T] {
+	return Topic[T]{name: name}
+}
+
+// Name returns the name of the topic.
+func (t Topic[T]) Name() string {
+	return t.name
+}
+
+// Publish publishes v like Bus.Publish.
+func (t Topic[T]) Publish(ctx context.Context, b *Bus, v T) error {
+	return b.Publish(ctx, t.name, v)
+}
+
+// PublishAsync publishes v like Bus.PublishAsync.
+func (t Topic[T]) PublishAsync(ctx context.Context, b *Bus, v T) error {
+	return b.PublishAsync(ctx, t.name, v)
+}
+
+// Subscribe subscribes handler to the topic, it returns the function
+// unsubscribing it. The events published to the name of the topic with
+// another payload type fail with an error.
+func (t Topic[T]) Subscribe(b *Bus, handler func(ctx context.Context, v T) error, opts ...SubscribeOption) (unsubscribe func()) {
+	return b.Subscribe(t.name, func(ctx context.Context, e *Event) error {
+		v, ok := e.Payload.(T)
+		if !ok {
+			var want T
+			return fmt.Errorf("eventbus: topic %s expects %T, got %T", t.name, want, e.Payload)
+		}
+		return handler(ctx, v)
+	}, opts...)
+}
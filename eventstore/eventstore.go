@@ -0,0 +1,366 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+const (
+	// AnyVersion skips the concurrency check of Append.
+	AnyVersion int64 = -1
+	// NoStream expects the stream not to exist yet.
+	NoStream int64 = 0
+)
+
+var (
+	// ErrConcurrency is returned when the stream isn't at the expected version.
+	ErrConcurrency = errors.New("eventstore: wrong expected version")
+	// ErrNoSnapshot is returned when a stream has no snapshot.
+	ErrNoSnapshot = errors.New("eventstore: no snapshot")
+)
+
+// Event is an event of a stream.
+type Event struct {
+	// Position orders the events of all streams, set by Append.
+	Position int64
+	// StreamID identifies the aggregate the event belongs to.
+	StreamID string
+	// Version is the position of the event in its stream, starting at 1.
+	Version int64
+	// Type names the event.
+	Type string
+	// Data is the JSON encoded payload.
+	Data json.RawMessage
+	// Metadata carries e.g. the correlation and causation IDs.
+	Metadata map[string]string
+	// CreatedAt is when the event was appended.
+	CreatedAt time.Time
+}
+
+// NewEvent creates an event with a JSON encoded payload.
+func NewEvent(typ string, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to marshal event %s: %w", typ, err)
+	}
+	return Event{Type: typ, Data: raw}, nil
+}
+
+// Decode decodes the payload into v.
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Snapshot is the state of an aggregate at a version of its stream.
+type Snapshot struct {
+	StreamID  string
+	Version   int64
+	Data      json.RawMessage
+	CreatedAt time.Time
+}
+
+// Option is event store option.
+type Option func(*options)
+
+// options is event store options.
+type options struct {
+	prefix       string
+	pollInterval time.Duration
+	batchSize    int
+	notify       bool
+}
+
+// WithTablePrefix sets the prefix of the tables and the notification channel.
+func WithTablePrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithPollInterval sets the interval subscriptions poll for new events at.
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+	}
+}
+
+// WithBatchSize sets the number of events subscriptions read at once.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithNotify sets whether appends are notified through LISTEN/NOTIFY so
+// subscriptions don't wait for the next poll.
+func WithNotify(notify bool) Option {
+	return func(o *options) {
+		o.notify = notify
+	}
+}
+
+// Store is an append-only event store on PostgreSQL, e.g. on the DB of the
+// postgres connector.
+type Store struct {
+	db   *gorm.DB
+	opts options
+
+	events      string
+	snapshots   string
+	checkpoints string
+	channel     string
+}
+
+var prefixPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// New creates an event store.
+func New(db *gorm.DB, opts ...Option) (*Store, error) {
+	o := options{
+		prefix:       "es_",
+		pollInterval: time.Second,
+		batchSize:    100,
+		notify:       true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !prefixPattern.MatchString(o.prefix) {
+		return nil, fmt.Errorf("invalid table prefix %q", o.prefix)
+	}
+	return &Store{
+		db:          db,
+		opts:        o,
+		events:      o.prefix + "events",
+		snapshots:   o.prefix + "snapshots",
+		checkpoints: o.prefix + "checkpoints",
+		channel:     o.prefix + "events",
+	}, nil
+}
+
+// Migrate creates the event, snapshot and projection checkpoint tables.
+func (s *Store) Migrate(ctx context.Context) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ` + s.events + ` (
+			position BIGSERIAL PRIMARY KEY,
+			stream_id TEXT NOT NULL,
+			version BIGINT NOT NULL,
+			type TEXT NOT NULL,
+			data JSONB NOT NULL,
+			metadata JSONB,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			UNIQUE (stream_id, version)
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + s.snapshots + ` (
+			stream_id TEXT PRIMARY KEY,
+			version BIGINT NOT NULL,
+			data JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + s.checkpoints + ` (
+			name TEXT PRIMARY KEY,
+			position BIGINT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+	}
+	for _, stmt := range stmts {
+		if err := s.db.WithContext(ctx).Exec(stmt).Error; err != nil {
+			return fmt.Errorf("failed to migrate event store: %w", err)
+		}
+	}
+	return nil
+}
+
+// Append appends events to a stream and returns its new version. The stream
+// must be at expectedVersion, NoStream for a new stream, unless it is AnyVersion.
+// Appends are serialized so positions become visible in order, which lets
+// subscriptions track a single position.
+func (s *Store) Append(ctx context.Context, streamID string, expectedVersion int64, events ...Event) (int64, error) {
+	var version int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", s.events).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Raw("SELECT COALESCE(MAX(version), 0) FROM "+s.events+" WHERE stream_id = ?", streamID).
+			Scan(&version).Error; err != nil {
+			return err
+		}
+		if expectedVersion != AnyVersion && version != expectedVersion {
+			return fmt.Errorf("%w: stream %s is at version %d, expected %d", ErrConcurrency, streamID, version, expectedVersion)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for i := range events {
+			version++
+			metadata, err := json.Marshal(events[i].Metadata)
+			if err != nil {
+				return err
+			}
+			row := tx.Raw("INSERT INTO "+s.events+" (stream_id, version, type, data, metadata) VALUES (?, ?, ?, ?, ?) RETURNING position, created_at",
+				streamID, version, events[i].Type, string(events[i].Data), string(metadata)).Row()
+			if err := row.Scan(&events[i].Position, &events[i].CreatedAt); err != nil {
+				return err
+			}
+			events[i].StreamID = streamID
+			events[i].Version = version
+		}
+
+		if s.opts.notify {
+			return tx.Exec("SELECT pg_notify(?, ?)", s.channel, streamID).Error
+		}
+		return nil
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, fmt.Errorf("%w: stream %s", ErrConcurrency, streamID)
+		}
+		if errors.Is(err, ErrConcurrency) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to append to stream %s: %w", streamID, err)
+	}
+	return version, nil
+}
+
+// eventRow is the row of an event.
+type eventRow struct {
+	Position  int64
+	StreamID  string
+	Version   int64
+	Type      string
+	Data      string
+	Metadata  *string
+	CreatedAt time.Time
+}
+
+// toEvent converts a row into an event.
+func (r *eventRow) toEvent() Event {
+	e := Event{
+		Position:  r.Position,
+		StreamID:  r.StreamID,
+		Version:   r.Version,
+		Type:      r.Type,
+		Data:      json.RawMessage(r.Data),
+		CreatedAt: r.CreatedAt,
+	}
+	if r.Metadata != nil {
+		_ = json.Unmarshal([]byte(*r.Metadata), &e.Metadata)
+	}
+	return e
+}
+
+// query returns the events selected by a query.
+func (s *Store) query(ctx context.Context, sql string, args ...interface{}) ([]Event, error) {
+	var rows []eventRow
+	if err := s.db.WithContext(ctx).Raw(sql, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(rows))
+	for i := range rows {
+		events = append(events, rows[i].toEvent())
+	}
+	return events, nil
+}
+
+// Load returns the events of a stream after a version, 0 for all of them.
+func (s *Store) Load(ctx context.Context, streamID string, afterVersion int64) ([]Event, error) {
+	events, err := s.query(ctx, "SELECT position, stream_id, version, type, data, metadata, created_at FROM "+s.events+
+		" WHERE stream_id = ? AND version > ? ORDER BY version", streamID, afterVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stream %s: %w", streamID, err)
+	}
+	return events, nil
+}
+
+// ReadAll returns up to limit events of all streams after a position.
+func (s *Store) ReadAll(ctx context.Context, afterPosition int64, limit int) ([]Event, error) {
+	events, err := s.query(ctx, "SELECT position, stream_id, version, type, data, metadata, created_at FROM "+s.events+
+		" WHERE position > ? ORDER BY position LIMIT ?", afterPosition, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events: %w", err)
+	}
+	return events, nil
+}
+
+// Head returns the position of the last appended event, 0 if there is none.
+func (s *Store) Head(ctx context.Context) (int64, error) {
+	var position int64
+	if err := s.db.WithContext(ctx).Raw("SELECT COALESCE(MAX(position), 0) FROM " + s.events).
+		Scan(&position).Error; err != nil {
+		return 0, fmt.Errorf("failed to get head position: %w", err)
+	}
+	return position, nil
+}
+
+// Version returns the current version of a stream, NoStream if it doesn't exist.
+func (s *Store) Version(ctx context.Context, streamID string) (int64, error) {
+	var version int64
+	if err := s.db.WithContext(ctx).Raw("SELECT COALESCE(MAX(version), 0) FROM "+s.events+" WHERE stream_id = ?", streamID).
+		Scan(&version).Error; err != nil {
+		return 0, fmt.Errorf("failed to get version of stream %s: %w", streamID, err)
+	}
+	return version, nil
+}
+
+// SaveSnapshot saves the snapshot of a stream, replacing the previous one.
+func (s *Store) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	err := s.db.WithContext(ctx).Exec("INSERT INTO "+s.snapshots+" (stream_id, version, data, created_at) VALUES (?, ?, ?, now()) "+
+		"ON CONFLICT (stream_id) DO UPDATE SET version = EXCLUDED.version, data = EXCLUDED.data, created_at = EXCLUDED.created_at "+
+		"WHERE "+s.snapshots+".version <= EXCLUDED.version",
+		snapshot.StreamID, snapshot.Version, string(snapshot.Data)).Error
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot of stream %s: %w", snapshot.StreamID, err)
+	}
+	return nil
+}
+
+// LoadSnapshot returns the snapshot of a stream, ErrNoSnapshot if there is none.
+func (s *Store) LoadSnapshot(ctx context.Context, streamID string) (*Snapshot, error) {
+	var rows []struct {
+		StreamID  string
+		Version   int64
+		Data      string
+		CreatedAt time.Time
+	}
+	if err := s.db.WithContext(ctx).Raw("SELECT stream_id, version, data, created_at FROM "+s.snapshots+" WHERE stream_id = ?", streamID).
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load snapshot of stream %s: %w", streamID, err)
+	}
+	if len(rows) == 0 {
+		return nil, ErrNoSnapshot
+	}
+	return &Snapshot{
+		StreamID:  rows[0].StreamID,
+		Version:   rows[0].Version,
+		Data:      json.RawMessage(rows[0].Data),
+		CreatedAt: rows[0].CreatedAt,
+	}, nil
+}
+
+// LoadAggregate returns the snapshot of a stream, nil if there is none,
+// and the events appended after it.
+func (s *Store) LoadAggregate(ctx context.Context, streamID string) (*Snapshot, []Event, error) {
+	snapshot, err := s.LoadSnapshot(ctx, streamID)
+	if err != nil && !errors.Is(err, ErrNoSnapshot) {
+		return nil, nil, err
+	}
+	var after int64
+	if snapshot != nil {
+		after = snapshot.Version
+	}
+	events, err := s.Load(ctx, streamID, after)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, events, nil
+}
@@ -0,0 +1,375 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+)
+
+// ErrUnknownProjection is returned when a projection isn't registered.
+var ErrUnknownProjection = errors.New("eventstore: unknown projection")
+
+// Projection builds a read model from the events of all streams.
+type Projection interface {
+	// Name identifies the projection and its checkpoint.
+	Name() string
+	// Handle applies an event to the read model. Events may be handled again
+	// after a crash, so handlers should be idempotent.
+	Handle(ctx context.Context, event Event) error
+}
+
+// Resetter is implemented by projections clearing their read model before a rebuild.
+type Resetter interface {
+	Reset(ctx context.Context) error
+}
+
+// funcProjection adapts a function to a Projection.
+type funcProjection struct {
+	name   string
+	handle Handler
+}
+
+// ProjectionFunc returns a Projection calling fn.
+func ProjectionFunc(name string, fn Handler) Projection {
+	return &funcProjection{name: name, handle: fn}
+}
+
+// Name implements Projection.
+func (p *funcProjection) Name() string {
+	return p.name
+}
+
+// Handle implements Projection.
+func (p *funcProjection) Handle(ctx context.Context, event Event) error {
+	return p.handle(ctx, event)
+}
+
+// ProjectionStatus is the progress of a projection.
+type ProjectionStatus struct {
+	Name string
+	// Position is the position of the last handled event.
+	Position int64
+	// Lag is the number of positions the projection is behind the head.
+	Lag int64
+	// Err is the last error of the projection, nil once it recovered.
+	Err error
+}
+
+// RunnerOption is projection runner option.
+type RunnerOption func(*runnerOptions)
+
+// runnerOptions is projection runner options.
+type runnerOptions struct {
+	retryInterval time.Duration
+	registry      prometheus.Registerer
+}
+
+// WithRetryInterval returns a RunnerOption that sets how long a failed projection
+// waits before resuming from its checkpoint.
+func WithRetryInterval(interval time.Duration) RunnerOption {
+	return func(o *runnerOptions) {
+		o.retryInterval = interval
+	}
+}
+
+// WithRegistry returns a RunnerOption that sets the registry of the lag metrics, nil disables them.
+func WithRegistry(registry prometheus.Registerer) RunnerOption {
+	return func(o *runnerOptions) {
+		o.registry = registry
+	}
+}
+
+// worker runs a projection.
+type worker struct {
+	projection Projection
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	mu       sync.Mutex
+	position int64
+	lag      int64
+	err      error
+}
+
+// Runner keeps projections up to date, each from its own checkpoint stored
+// next to the events, so projections progress and rebuild in parallel.
+type Runner struct {
+	store *Store
+	opts  runnerOptions
+
+	position *prometheus.GaugeVec
+	lag      *prometheus.GaugeVec
+
+	mu      sync.Mutex
+	workers map[string]*worker
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewRunner creates a projection runner.
+func NewRunner(store *Store, opts ...RunnerOption) *Runner {
+	o := runnerOptions{
+		retryInterval: time.Second * 5,
+		registry:      prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r := &Runner{
+		store:   store,
+		opts:    o,
+		workers: make(map[string]*worker),
+	}
+	if o.registry != nil {
+		r.position = registerGauge(o.registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "projection",
+			Name:      "position",
+			Help:      "Position of the last event handled by the projection.",
+		}, []string{"projection"}))
+		r.lag = registerGauge(o.registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "projection",
+			Name:      "lag_events",
+			Help:      "Number of positions the projection is behind the head of the event store.",
+		}, []string{"projection"}))
+	}
+	return r
+}
+
+// registerGauge registers a gauge, reusing the registered one.
+func registerGauge(registry prometheus.Registerer, gauge *prometheus.GaugeVec) *prometheus.GaugeVec {
+	gauge, err := collector.Register(registry, gauge)
+	if err != nil {
+		klog.Warnf("eventstore: failed to register projection metrics: %v", err)
+		return nil
+	}
+	return gauge
+}
+
+// Register adds a projection. Projections registered after Start run immediately.
+func (r *Runner) Register(p Projection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w := &worker{projection: p}
+	r.workers[p.Name()] = w
+	if r.ctx != nil {
+		r.run(w)
+	}
+}
+
+// Start starts the projections, it suits newMilli.BeforeStart.
+func (r *Runner) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx != nil {
+		return nil
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	for _, w := range r.workers {
+		r.run(w)
+	}
+	return nil
+}
+
+// Stop stops the projections and waits for the batches in progress.
+func (r *Runner) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	if r.ctx == nil {
+		r.mu.Unlock()
+		return nil
+	}
+	r.cancel()
+	r.ctx = nil
+	workers := make([]*worker, 0, len(r.workers))
+	for _, w := range r.workers {
+		workers = append(workers, w)
+	}
+	r.mu.Unlock()
+
+	for _, w := range workers {
+		if w.done == nil {
+			continue
+		}
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Rebuild resets the checkpoints of the named projections, clears their read
+// models when they implement Resetter and replays all events into them.
+// The projections rebuild in parallel while the others keep running.
+func (r *Runner) Rebuild(ctx context.Context, names ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := r.workers[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownProjection, name)
+		}
+	}
+
+	for _, name := range names {
+		w := r.workers[name]
+		if w.cancel != nil {
+			w.cancel()
+			select {
+			case <-w.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if reset, ok := w.projection.(Resetter); ok {
+			if err := reset.Reset(ctx); err != nil {
+				return fmt.Errorf("failed to reset projection %s: %w", name, err)
+			}
+		}
+		if err := r.store.saveCheckpoint(ctx, name, 0); err != nil {
+			return err
+		}
+		klog.Infof("eventstore: rebuilding projection %s", name)
+
+		if r.ctx != nil {
+			r.run(w)
+		}
+	}
+	return nil
+}
+
+// Status returns the progress of the projections, sorted by name.
+func (r *Runner) Status() []ProjectionStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := make([]ProjectionStatus, 0, len(r.workers))
+	for name, w := range r.workers {
+		w.mu.Lock()
+		status = append(status, ProjectionStatus{Name: name, Position: w.position, Lag: w.lag, Err: w.err})
+		w.mu.Unlock()
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+// run starts the goroutine of a worker, the runner lock must be held.
+func (r *Runner) run(w *worker) {
+	ctx, cancel := context.WithCancel(r.ctx)
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+		for ctx.Err() == nil {
+			err := r.project(ctx, w)
+			if ctx.Err() != nil {
+				return
+			}
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+			klog.Errorf("eventstore: projection %s failed: %v", w.projection.Name(), err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.opts.retryInterval):
+			}
+		}
+	}()
+}
+
+// project feeds the events after the checkpoint to a projection, saving the
+// checkpoint after each batch.
+func (r *Runner) project(ctx context.Context, w *worker) error {
+	name := w.projection.Name()
+	checkpoint, err := r.store.loadCheckpoint(ctx, name)
+	if err != nil {
+		return err
+	}
+	r.progress(ctx, w, checkpoint)
+
+	return r.store.feed(ctx, checkpoint, func(ctx context.Context, events []Event) (int64, error) {
+		var position int64
+		for _, e := range events {
+			if err := w.projection.Handle(ctx, e); err != nil {
+				if position > 0 {
+					if err := r.store.saveCheckpoint(ctx, name, position); err != nil {
+						klog.Warnf("eventstore: %v", err)
+					}
+				}
+				return position, fmt.Errorf("failed to handle event %d: %w", e.Position, err)
+			}
+			position = e.Position
+		}
+		if position > 0 {
+			if err := r.store.saveCheckpoint(ctx, name, position); err != nil {
+				return position, err
+			}
+			checkpoint = position
+		}
+		r.progress(ctx, w, checkpoint)
+		return position, nil
+	})
+}
+
+// progress records the position and lag of a projection.
+func (r *Runner) progress(ctx context.Context, w *worker, position int64) {
+	head, err := r.store.Head(ctx)
+	if err != nil {
+		return
+	}
+	lag := head - position
+	if lag < 0 {
+		lag = 0
+	}
+
+	w.mu.Lock()
+	w.position, w.lag, w.err = position, lag, nil
+	w.mu.Unlock()
+
+	name := w.projection.Name()
+	if r.position != nil {
+		r.position.WithLabelValues(name).Set(float64(position))
+	}
+	if r.lag != nil {
+		r.lag.WithLabelValues(name).Set(float64(lag))
+	}
+}
+
+// loadCheckpoint returns the checkpoint of a projection, 0 if it has none.
+func (s *Store) loadCheckpoint(ctx context.Context, name string) (int64, error) {
+	var positions []int64
+	if err := s.db.WithContext(ctx).Raw("SELECT position FROM "+s.checkpoints+" WHERE name = ?", name).
+		Scan(&positions).Error; err != nil {
+		return 0, fmt.Errorf("failed to load checkpoint of %s: %w", name, err)
+	}
+	if len(positions) == 0 {
+		return 0, nil
+	}
+	return positions[0], nil
+}
+
+// saveCheckpoint saves the checkpoint of a projection.
+func (s *Store) saveCheckpoint(ctx context.Context, name string, position int64) error {
+	err := s.db.WithContext(ctx).Exec("INSERT INTO "+s.checkpoints+" (name, position, updated_at) VALUES (?, ?, now()) "+
+		"ON CONFLICT (name) DO UPDATE SET position = EXCLUDED.position, updated_at = EXCLUDED.updated_at",
+		name, position).Error
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint of %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package eventstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Handler handles the events of a subscription.
+type Handler func(ctx context.Context, event Event) error
+
+// Subscribe feeds the events after a position to handler, in order, then
+// waits for new ones until ctx is done. It returns the error of the handler,
+// the subscription may then be resumed from the position of the last handled event.
+func (s *Store) Subscribe(ctx context.Context, afterPosition int64, handler Handler) error {
+	return s.feed(ctx, afterPosition, func(ctx context.Context, events []Event) (int64, error) {
+		var position int64
+		for _, e := range events {
+			if err := handler(ctx, e); err != nil {
+				return position, fmt.Errorf("failed to handle event %d: %w", e.Position, err)
+			}
+			position = e.Position
+		}
+		return position, nil
+	})
+}
+
+// batchHandler handles a batch of events and returns the position of the
+// last handled one, 0 if none was.
+type batchHandler func(ctx context.Context, events []Event) (int64, error)
+
+// feed reads the events after a position in batches until ctx is done or
+// the handler fails. An empty batch is passed whenever the feed is idle.
+func (s *Store) feed(ctx context.Context, afterPosition int64, handler batchHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wake := make(chan struct{}, 1)
+	if s.opts.notify {
+		go s.listen(ctx, wake)
+	}
+
+	ticker := time.NewTicker(s.opts.pollInterval)
+	defer ticker.Stop()
+
+	position := afterPosition
+	for {
+		events, err := s.ReadAll(ctx, position, s.opts.batchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			klog.CtxWarnf(ctx, "eventstore: %v", err)
+		}
+		last, err := handler(ctx, events)
+		if last > 0 {
+			position = last
+		}
+		if err != nil {
+			return err
+		}
+		if len(events) == s.opts.batchSize {
+			// More events are probably waiting
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// listen signals wake whenever events are appended, until ctx is done.
+// Polling keeps the subscription going while it is disconnected.
+func (s *Store) listen(ctx context.Context, wake chan<- struct{}) {
+	for ctx.Err() == nil {
+		if err := s.waitForNotifications(ctx, wake); err != nil && ctx.Err() == nil {
+			klog.CtxWarnf(ctx, "eventstore: failed to listen on %s: %v", s.channel, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.opts.pollInterval):
+			}
+		}
+	}
+}
+
+// waitForNotifications holds a connection listening on the notification channel.
+func (s *Store) waitForNotifications(ctx context.Context, wake chan<- struct{}) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unsupported driver connection %T", driverConn)
+		}
+		pc := c.Conn()
+		if _, err := pc.Exec(ctx, "LISTEN "+pgx.Identifier{s.channel}.Sanitize()); err != nil {
+			return err
+		}
+		defer pc.Exec(context.Background(), "UNLISTEN *")
+
+		for {
+			if _, err := pc.WaitForNotification(ctx); err != nil {
+				return err
+			}
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	})
+}
@@ -73,12 +73,7 @@ func main() {
 		httpAddress = ":8000"
 	}
 	
-	httpTimeoutStr, err := cfg.GetString("server.http.timeout")
-	if err != nil {
-		httpTimeoutStr = "5s"
-	}
-	
-	httpTimeout, err := time.ParseDuration(httpTimeoutStr)
+	httpTimeout, err := config.GetDuration(cfg, "server.http.timeout")
 	if err != nil {
 		httpTimeout = 5 * time.Second
 	}
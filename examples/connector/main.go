@@ -94,10 +94,13 @@ func main() {
 	switch connType {
 	case "mysql":
 		// Get the MySQL client
-		db := conn.(*mysql.Connector).DB()
+		db, err := conn.(*mysql.Connector).DB().DB()
+		if err != nil {
+			log.Fatalf("Failed to get SQL DB: %v", err)
+		}
 
 		// Create a table
-		_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS users (id INT AUTO_INCREMENT PRIMARY KEY, name VARCHAR(255), created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)")
+		_, err = db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS users (id INT AUTO_INCREMENT PRIMARY KEY, name VARCHAR(255), created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)")
 		if err != nil {
 			log.Fatalf("Failed to create table: %v", err)
 		}
@@ -129,10 +132,13 @@ func main() {
 
 	case "postgres":
 		// Get the PostgreSQL client
-		db := conn.(*postgres.Connector).DB()
+		db, err := conn.(*postgres.Connector).DB().DB()
+		if err != nil {
+			log.Fatalf("Failed to get SQL DB: %v", err)
+		}
 
 		// Create a table
-		_, err := db.Exec("CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, name VARCHAR(255), created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)")
+		_, err = db.Exec("CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, name VARCHAR(255), created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)")
 		if err != nil {
 			log.Fatalf("Failed to create table: %v", err)
 		}
@@ -181,8 +187,7 @@ func main() {
 		fmt.Printf("Redis key 'greeting': %s\n", val)
 
 	case "mongo":
-		// Get the MongoDB client
-		client := conn.(*mongo.Connector).Mongo()
+		// Get the MongoDB database
 		db := conn.(*mongo.Connector).Database()
 
 		// Create a collection
@@ -221,7 +226,8 @@ func main() {
 
 	case "elasticsearch":
 		// Get the Elasticsearch client
-		client := conn.(*elasticsearch.Connector).Elasticsearch()
+		es := conn.(*elasticsearch.Connector)
+		client := es.Elasticsearch()
 
 		// Create an index
 		res, err := client.Indices.Create("users")
@@ -235,11 +241,9 @@ func main() {
 			"name":       "Alice Johnson",
 			"created_at": time.Now().Format(time.RFC3339),
 		}
-		res, err = client.Index("users", doc)
-		if err != nil {
+		if _, err := es.Index(ctx, "users", doc, elasticsearch.WithRefresh("true")); err != nil {
 			log.Fatalf("Failed to index document: %v", err)
 		}
-		defer res.Body.Close()
 
 		// Search for documents
 		queryJSON := `{"query":{"match_all":{}}}`
@@ -256,7 +260,8 @@ func main() {
 
 	case "clickhouse":
 		// Get the ClickHouse client
-		conn := conn.(*clickhouse.Connector).Conn()
+		ch := conn.(*clickhouse.Connector)
+		conn := ch.Conn()
 
 		// Create a table
 		err := conn.Exec(ctx, `
@@ -271,11 +276,13 @@ func main() {
 			log.Fatalf("Failed to create table: %v", err)
 		}
 
-		// Insert a row
-		err = conn.Exec(ctx, "INSERT INTO users (id, name, created_at) VALUES (?, ?, ?)",
-			1, "Charlie Brown", time.Now())
+		// Insert rows with a native batch
+		err = ch.BatchInsert(ctx, "users", [][]interface{}{
+			{uint64(1), "Charlie Brown", time.Now()},
+			{uint64(2), "Lucy van Pelt", time.Now()},
+		})
 		if err != nil {
-			log.Fatalf("Failed to insert row: %v", err)
+			log.Fatalf("Failed to insert rows: %v", err)
 		}
 
 		// Query rows
@@ -0,0 +1,127 @@
+package experiment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"new-milli/config"
+)
+
+// KeyPrefix is the prefix of the config keys read by Load.
+const KeyPrefix = "experiment."
+
+// Load replaces the experiments of the set with the ones of the
+// configuration, one per name under KeyPrefix:
+//
+//	experiment.checkout_v2.variants = ["control:50", "treatment:50"]
+//	experiment.checkout_v2.unit = "user"
+//	experiment.checkout_v2.traffic = 20
+//	experiment.checkout_v2.salt = "checkout_v2"
+//	experiment.checkout_v2.enabled = true
+//	experiment.checkout_v2.overrides.qa-user-1 = "treatment"
+//
+// A variant without a weight has weight 1. Call it again when the
+// configuration changes; nothing is replaced when an experiment is invalid.
+func (s *Set) Load(cfg config.Config) error {
+	lister, ok := cfg.(interface{ Keys() []string })
+	if !ok {
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, k := range lister.Keys() {
+		if rest, ok := strings.CutPrefix(k, KeyPrefix); ok {
+			if name, _, ok := strings.Cut(rest, "."); ok {
+				names[name] = true
+			}
+		}
+	}
+
+	experiments := make(map[string]*Experiment, len(names))
+	for name := range names {
+		e, err := load(cfg, name)
+		if err != nil {
+			return fmt.Errorf("failed to load experiment %s: %w", name, err)
+		}
+		if err := e.validate(); err != nil {
+			return err
+		}
+		if _, ok := s.units[e.Unit]; !ok {
+			return fmt.Errorf("experiment %s has unknown unit %s", e.Name, e.Unit)
+		}
+		experiments[name] = e
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.experiments = experiments
+	return nil
+}
+
+// load reads an experiment from the configuration.
+func load(cfg config.Config, name string) (*Experiment, error) {
+	key := KeyPrefix + name
+	e := &Experiment{Name: name}
+
+	variants, err := cfg.GetStringSlice(key + ".variants")
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range variants {
+		arm := Arm{Name: v, Weight: 1}
+		if n, w, ok := strings.Cut(v, ":"); ok {
+			weight, err := strconv.Atoi(strings.TrimSpace(w))
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight of variant %s: %w", n, err)
+			}
+			arm = Arm{Name: strings.TrimSpace(n), Weight: weight}
+		}
+		e.Arms = append(e.Arms, arm)
+	}
+
+	if cfg.Has(key + ".unit") {
+		if e.Unit, err = cfg.GetString(key + ".unit"); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Has(key + ".salt") {
+		if e.Salt, err = cfg.GetString(key + ".salt"); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Has(key + ".traffic") {
+		if e.Traffic, err = cfg.GetFloat(key + ".traffic"); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Has(key + ".enabled") {
+		enabled, err := cfg.GetBool(key + ".enabled")
+		if err != nil {
+			return nil, err
+		}
+		e.Disabled = !enabled
+	}
+
+	lister := cfg.(interface{ Keys() []string })
+	for _, k := range lister.Keys() {
+		id, ok := strings.CutPrefix(k, key+".overrides.")
+		if !ok {
+			continue
+		}
+		v, err := cfg.GetString(k)
+		if err != nil {
+			return nil, err
+		}
+		if e.Overrides == nil {
+			e.Overrides = make(map[string]string)
+		}
+		e.Overrides[id] = v
+	}
+	return e, nil
+}
+
+// Load replaces the experiments of the global set with the ones of the
+// configuration.
+func Load(cfg config.Config) error {
+	return global.Load(cfg)
+}
@@ -0,0 +1,417 @@
+package experiment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+	"new-milli/logger"
+	"new-milli/requestctx"
+)
+
+// Units the units of an experiment are bucketed by.
+const (
+	// UnitUser buckets by the id of the authenticated caller.
+	UnitUser = "user"
+	// UnitTenant buckets by the tenant of the request.
+	UnitTenant = "tenant"
+)
+
+// buckets is the number of buckets the units are hashed into, a traffic or
+// weight step of 0.01%.
+const buckets = 10000
+
+// Arm is a variant of an experiment.
+type Arm struct {
+	Name string
+	// Weight is the share of the units assigned to the variant, relative to
+	// the weights of the other variants.
+	Weight int
+}
+
+// Experiment is a feature flag splitting its units between variants.
+type Experiment struct {
+	Name string
+	// Arms are the variants, the first one is the default variant, served
+	// to the units outside the experiment, e.g. "control".
+	Arms []Arm
+	// Unit is the unit bucketed, UnitUser by default.
+	Unit string
+	// Salt changes the bucketing, the name of the experiment by default.
+	// Changing it reshuffles the units.
+	Salt string
+	// Traffic is the percentage of the units in the experiment, 100 by
+	// default. Raising it adds units without moving the ones already in.
+	Traffic float64
+	// Disabled turns the experiment off, every unit gets the default
+	// variant.
+	Disabled bool
+	// Overrides forces the variant of some units, by unit id, e.g. for QA
+	// accounts.
+	Overrides map[string]string
+}
+
+// Default returns the default variant of the experiment.
+func (e *Experiment) Default() string {
+	if len(e.Arms) == 0 {
+		return ""
+	}
+	return e.Arms[0].Name
+}
+
+// Bucket returns the variant of the unit id, and whether it is in the
+// experiment. It is deterministic: a unit keeps its variant as long as the
+// salt and the weights don't change.
+func (e *Experiment) Bucket(id string) (string, bool) {
+	if v, ok := e.Overrides[id]; ok {
+		return v, true
+	}
+	if e.Disabled || id == "" {
+		return e.Default(), false
+	}
+	if e.Traffic < 100 && float64(hash(e.salt(), "traffic", id)) >= e.Traffic*buckets/100 {
+		return e.Default(), false
+	}
+
+	total := 0
+	for _, a := range e.Arms {
+		total += a.Weight
+	}
+	if total <= 0 {
+		return e.Default(), false
+	}
+	point := int(hash(e.salt(), "variant", id)) * total / buckets
+	for _, a := range e.Arms {
+		if point < a.Weight {
+			return a.Name, true
+		}
+		point -= a.Weight
+	}
+	return e.Default(), false
+}
+
+// salt returns the salt of the experiment.
+func (e *Experiment) salt() string {
+	if e.Salt != "" {
+		return e.Salt
+	}
+	return e.Name
+}
+
+// validate checks the experiment and sets its defaults.
+func (e *Experiment) validate() error {
+	if e.Name == "" {
+		return errors.New("experiment has no name")
+	}
+	if len(e.Arms) == 0 {
+		return fmt.Errorf("experiment %s has no variants", e.Name)
+	}
+	seen := make(map[string]bool, len(e.Arms))
+	for _, a := range e.Arms {
+		if a.Name == "" || seen[a.Name] {
+			return fmt.Errorf("experiment %s has an empty or duplicate variant %q", e.Name, a.Name)
+		}
+		if a.Weight < 0 {
+			return fmt.Errorf("experiment %s has a negative weight for variant %s", e.Name, a.Name)
+		}
+		seen[a.Name] = true
+	}
+	for id, v := range e.Overrides {
+		if !seen[v] {
+			return fmt.Errorf("experiment %s overrides %s with unknown variant %s", e.Name, id, v)
+		}
+	}
+	if e.Unit == "" {
+		e.Unit = UnitUser
+	}
+	if e.Traffic <= 0 || e.Traffic > 100 {
+		e.Traffic = 100
+	}
+	return nil
+}
+
+// hash returns the bucket of id in [0, buckets).
+func hash(salt, kind, id string) uint64 {
+	sum := sha256.Sum256([]byte(salt + "/" + kind + "/" + id))
+	return binary.BigEndian.Uint64(sum[:8]) % buckets
+}
+
+// Exposure is the assignment of a unit to a variant, recorded when the
+// variant is served.
+type Exposure struct {
+	Experiment string    `json:"experiment"`
+	Variant    string    `json:"variant"`
+	Unit       string    `json:"unit"`
+	UnitID     string    `json:"unit_id"`
+	Time       time.Time `json:"time"`
+	// Forced is true when the variant is set with ContextWithVariant or an
+	// override instead of the bucketing.
+	Forced bool `json:"forced,omitempty"`
+}
+
+// newExposuresCounter creates the counter of the exposures registered
+// with registry, reusing the registered one. It isn't registered when
+// registry is nil.
+func newExposuresCounter(registry prometheus.Registerer) *prometheus.CounterVec {
+	exposures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "new_milli",
+		Subsystem: "experiment",
+		Name:      "exposures_total",
+		Help:      "Number of experiment exposures by experiment and variant.",
+	}, []string{"experiment", "variant"})
+	if registry != nil {
+		var err error
+		if exposures, err = collector.Register(registry, exposures); err != nil {
+			logger.Warnf("Failed to register experiment metrics: %v", err)
+		}
+	}
+	return exposures
+}
+
+// Option is set option.
+type Option func(*Set)
+
+// WithLogger sets the logger of the exposures, the global logger by
+// default.
+func WithLogger(l logger.Logger) Option {
+	return func(s *Set) {
+		s.logger = l
+	}
+}
+
+// WithExposureLogging turns the logging of the exposures on or off, on by
+// default. The exposures are still counted and passed to the handlers.
+func WithExposureLogging(enabled bool) Option {
+	return func(s *Set) {
+		s.logExposures = enabled
+	}
+}
+
+// WithExposureHandler adds a function receiving the exposures, e.g. to
+// publish them to an analytics pipeline.
+func WithExposureHandler(fn func(ctx context.Context, e Exposure)) Option {
+	return func(s *Set) {
+		s.handlers = append(s.handlers, fn)
+	}
+}
+
+// WithUnit sets how the ids of a unit are read from the request context,
+// e.g. a device id from a header. It replaces the built-in units.
+func WithUnit(unit string, id func(ctx context.Context) string) Option {
+	return func(s *Set) {
+		s.units[unit] = id
+	}
+}
+
+// WithRegistry sets the registry of the exposure metrics,
+// prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(s *Set) {
+		s.registry = registry
+	}
+}
+
+// Set holds the running experiments and assigns their variants.
+type Set struct {
+	mu          sync.RWMutex
+	experiments map[string]*Experiment
+
+	logger       logger.Logger
+	logExposures bool
+	handlers     []func(ctx context.Context, e Exposure)
+	units        map[string]func(ctx context.Context) string
+	registry     prometheus.Registerer
+	exposures    *prometheus.CounterVec
+}
+
+// New creates a set without experiments.
+func New(opts ...Option) *Set {
+	s := &Set{
+		experiments:  make(map[string]*Experiment),
+		logExposures: true,
+		units: map[string]func(ctx context.Context) string{
+			UnitUser:   requestctx.UserID,
+			UnitTenant: requestctx.Tenant,
+		},
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.exposures = newExposuresCounter(s.registry)
+	return s
+}
+
+// Register adds an experiment, replacing the one with the same name.
+func (s *Set) Register(e Experiment) error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+	if _, ok := s.units[e.Unit]; !ok {
+		return fmt.Errorf("experiment %s has unknown unit %s", e.Name, e.Unit)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.experiments[e.Name] = &e
+	return nil
+}
+
+// Remove removes an experiment.
+func (s *Set) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.experiments, name)
+}
+
+// Get returns an experiment.
+func (s *Set) Get(name string) (Experiment, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.experiments[name]
+	if !ok {
+		return Experiment{}, false
+	}
+	return *e, true
+}
+
+// Names returns the names of the experiments, sorted.
+func (s *Set) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.experiments))
+	for name := range s.experiments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Variant returns the variant of the experiment name for the unit of ctx,
+// recording an exposure the first time it is served in the request. Units
+// outside the experiment, anonymous requests included, get its default
+// variant; it is empty for unknown experiments.
+func (s *Set) Variant(ctx context.Context, name string) string {
+	s.mu.RLock()
+	e, ok := s.experiments[name]
+	s.mu.RUnlock()
+	if !ok {
+		return ""
+	}
+
+	if v, ok := forced(ctx, e); ok {
+		s.expose(ctx, e, Exposure{Experiment: name, Variant: v, Unit: e.Unit, Forced: true})
+		return v
+	}
+
+	id := s.units[e.Unit](ctx)
+	v, in := e.Bucket(id)
+	if in {
+		_, override := e.Overrides[id]
+		s.expose(ctx, e, Exposure{Experiment: name, Variant: v, Unit: e.Unit, UnitID: id, Forced: override})
+	}
+	return v
+}
+
+// Is reports whether the unit of ctx gets variant of the experiment name.
+func (s *Set) Is(ctx context.Context, name, variant string) bool {
+	return s.Variant(ctx, name) == variant
+}
+
+// expose records an exposure, once per request under Server.
+func (s *Set) expose(ctx context.Context, e *Experiment, x Exposure) {
+	if st, ok := ctx.Value(stateKey{}).(*state); ok && !st.assign(e.Name, x.Variant) {
+		return
+	}
+	x.Time = time.Now()
+	s.exposures.WithLabelValues(x.Experiment, x.Variant).Inc()
+
+	if s.logExposures {
+		l := s.logger
+		if l == nil {
+			l = logger.WithContext(ctx)
+		} else {
+			l = l.WithContext(ctx)
+		}
+		l.WithFields(
+			logger.F("experiment", x.Experiment),
+			logger.F("variant", x.Variant),
+			logger.F("unit", x.Unit),
+			logger.F("unit_id", x.UnitID),
+			logger.F("forced", x.Forced),
+		).Info("experiment exposure")
+	}
+	for _, fn := range s.handlers {
+		fn(ctx, x)
+	}
+}
+
+type variantsKey struct{}
+
+// ContextWithVariant returns a new Context forcing the variant of the
+// experiment name, e.g. in tests or from the override header of Server.
+// Variants unknown to the experiment are ignored.
+func ContextWithVariant(ctx context.Context, name, variant string) context.Context {
+	variants := map[string]string{name: variant}
+	if parent, ok := ctx.Value(variantsKey{}).(map[string]string); ok {
+		for k, v := range parent {
+			if k != name {
+				variants[k] = v
+			}
+		}
+	}
+	return context.WithValue(ctx, variantsKey{}, variants)
+}
+
+// forced returns the variant of e forced in ctx, if any.
+func forced(ctx context.Context, e *Experiment) (string, bool) {
+	variants, ok := ctx.Value(variantsKey{}).(map[string]string)
+	if !ok {
+		return "", false
+	}
+	v, ok := variants[e.Name]
+	if !ok {
+		return "", false
+	}
+	for _, a := range e.Arms {
+		if a.Name == v {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// global is the global set.
+var global = New()
+
+// Default returns the global set.
+func Default() *Set {
+	return global
+}
+
+// Register adds an experiment to the global set.
+func Register(e Experiment) error {
+	return global.Register(e)
+}
+
+// Variant returns the variant of the experiment name of the global set for
+// the unit of ctx:
+//
+//	if experiment.Variant(ctx, "checkout_v2") == "treatment" {
+//		return s.checkoutV2(ctx, req)
+//	}
+func Variant(ctx context.Context, name string) string {
+	return global.Variant(ctx, name)
+}
+
+// Is reports whether the unit of ctx gets variant of the experiment name of
+// the global set.
+func Is(ctx context.Context, name, variant string) bool {
+	return global.Is(ctx, name, variant)
+}
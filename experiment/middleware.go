@@ -0,0 +1,100 @@
+package experiment
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// MiddlewareOption is experiment middleware option.
+type MiddlewareOption func(*middlewareOptions)
+
+// middlewareOptions is experiment middleware options.
+type middlewareOptions struct {
+	overrideHeader string
+}
+
+// WithOverrideHeader returns a MiddlewareOption that forces the variants
+// listed in the request header, e.g. "X-Experiment: checkout_v2=treatment",
+// for QA and demos. It is off by default since it lets callers choose their
+// variant.
+func WithOverrideHeader(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.overrideHeader = header
+	}
+}
+
+// Server returns a middleware recording the exposure of each experiment
+// once per request, however many times its variant is read.
+func Server(opts ...MiddlewareOption) middleware.Middleware {
+	cfg := middlewareOptions{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			ctx = context.WithValue(ctx, stateKey{}, &state{})
+			if cfg.overrideHeader != "" {
+				if tr, ok := transport.FromServerContext(ctx); ok {
+					ctx = withOverrides(ctx, tr.RequestHeader().Get(cfg.overrideHeader))
+				}
+			}
+			return handler(ctx, req)
+		}
+	}
+}
+
+// withOverrides forces the variants of a "name=variant, ..." header value.
+func withOverrides(ctx context.Context, header string) context.Context {
+	for _, pair := range strings.Split(header, ",") {
+		name, variant, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && name != "" && variant != "" {
+			ctx = ContextWithVariant(ctx, name, variant)
+		}
+	}
+	return ctx
+}
+
+type stateKey struct{}
+
+// state is the variants served during a request.
+type state struct {
+	mu       sync.Mutex
+	variants map[string]string
+}
+
+// assign records the variant served for an experiment, it reports whether
+// it is the first time in the request.
+func (s *state) assign(name, variant string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.variants[name]; ok && v == variant {
+		return false
+	}
+	if s.variants == nil {
+		s.variants = make(map[string]string)
+	}
+	s.variants[name] = variant
+	return true
+}
+
+// Assigned returns the variants served during the request of ctx by
+// experiment, e.g. to add them to the logs or the reply. It is nil outside
+// Server.
+func Assigned(ctx context.Context) map[string]string {
+	s, ok := ctx.Value(stateKey{}).(*state)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	variants := make(map[string]string, len(s.variants))
+	for k, v := range s.variants {
+		variants[k] = v
+	}
+	return variants
+}
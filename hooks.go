@@ -0,0 +1,138 @@
+package newMilli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// HookOption is stop hook option.
+type HookOption func(*hook)
+
+// hook is a stop hook.
+type hook struct {
+	name     string
+	priority int
+	timeout  time.Duration
+	fn       func(context.Context) error
+}
+
+// HookName sets the name of the hook in the logs, the function name by default.
+func HookName(name string) HookOption {
+	return func(h *hook) {
+		h.name = name
+	}
+}
+
+// HookPriority sets the priority of the hook. Hooks run from the highest
+// priority to the lowest, hooks of the same priority run concurrently.
+// The default priority is 0.
+func HookPriority(priority int) HookOption {
+	return func(h *hook) {
+		h.priority = priority
+	}
+}
+
+// HookTimeout sets how long the hook may run. A hook exceeding it is logged
+// and abandoned so the next hooks still run within the StopTimeout.
+func HookTimeout(timeout time.Duration) HookOption {
+	return func(h *hook) {
+		h.timeout = timeout
+	}
+}
+
+// newHook creates a stop hook.
+func newHook(fn func(context.Context) error, opts []HookOption) hook {
+	h := hook{fn: fn}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	if h.name == "" {
+		if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+			h.name = f.Name()
+		}
+	}
+	return h
+}
+
+// runHooks runs the hooks of a stop phase by priority and returns their errors.
+func runHooks(ctx context.Context, phase string, hooks []hook) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	sorted := make([]hook, len(hooks))
+	copy(sorted, hooks)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].priority > sorted[j].priority })
+
+	var errs []error
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].priority == sorted[start].priority {
+			end++
+		}
+
+		group := sorted[start:end]
+		results := make([]error, len(group))
+		var wg sync.WaitGroup
+		for i := range group {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = runHook(ctx, phase, group[i])
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range results {
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		start = end
+	}
+	return errors.Join(errs...)
+}
+
+// runHook runs a hook within its timeout.
+func runHook(ctx context.Context, phase string, h hook) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- h.fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			klog.Errorf("%s hook %s failed after %s: %v", phase, h.name, time.Since(start), err)
+			return fmt.Errorf("%s hook %s: %w", phase, h.name, err)
+		}
+		return nil
+	case <-ctx.Done():
+		budget := "the stop timeout"
+		if h.timeout > 0 {
+			budget = h.timeout.String()
+		}
+		klog.Errorf("%s hook %s exceeded its budget of %s", phase, h.name, budget)
+		return fmt.Errorf("%s hook %s: %w", phase, h.name, ctx.Err())
+	}
+}
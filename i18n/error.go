@@ -0,0 +1,91 @@
+package i18n
+
+import (
+	"context"
+	"errors"
+
+	"new-milli/requestctx"
+)
+
+// Localizer is an error whose message can be translated, e.g. by
+// http.RespondError.
+type Localizer interface {
+	// Localize returns the message of the error in the locale of ctx.
+	Localize(ctx context.Context) string
+}
+
+// Error is an error with a translated message. Its Error method returns the
+// message in the fallback locale of the bundle, for the logs.
+type Error struct {
+	Key  string
+	Args []interface{}
+
+	err    error
+	bundle *Bundle
+}
+
+// NewError returns an error with the message of key of the global bundle
+// formatted with args.
+func NewError(key string, args ...interface{}) *Error {
+	return &Error{Key: key, Args: args}
+}
+
+// Wrap returns err with the message of key of the global bundle formatted
+// with args. The errors.Is and errors.As matching of err, e.g. by the
+// mappings of http.RegisterError, keep working:
+//
+//	return i18n.Wrap(ErrOrderNotFound, "order.not_found", id)
+func Wrap(err error, key string, args ...interface{}) *Error {
+	return &Error{Key: key, Args: args, err: err}
+}
+
+// WithBundle returns a copy of the error translated with b instead of the
+// global bundle.
+func (e *Error) WithBundle(b *Bundle) *Error {
+	out := *e
+	out.bundle = b
+	return &out
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.translate(e.b().fallback)
+}
+
+// Unwrap returns the wrapped error, if any.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Localize implements Localizer.
+func (e *Error) Localize(ctx context.Context) string {
+	return e.translate(requestctx.Locale(ctx))
+}
+
+// translate returns the message of the error in a locale, the message of
+// the wrapped error when the key has none.
+func (e *Error) translate(locale string) string {
+	b := e.b()
+	if _, ok := b.Lookup(locale, e.Key); !ok && e.err != nil {
+		return e.err.Error()
+	}
+	return b.Translate(locale, e.Key, e.Args...)
+}
+
+// b returns the bundle of the error.
+func (e *Error) b() *Bundle {
+	if e.bundle != nil {
+		return e.bundle
+	}
+	return global
+}
+
+// Localize returns the message of the first Localizer in the chain of err
+// in the locale of ctx, and whether there is one.
+func Localize(ctx context.Context, err error) (string, bool) {
+	var l Localizer
+	if errors.As(err, &l) {
+		return l.Localize(ctx), true
+	}
+	return "", false
+}
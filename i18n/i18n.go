@@ -0,0 +1,216 @@
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"new-milli/config"
+	"new-milli/requestctx"
+)
+
+// Option is bundle option.
+type Option func(*Bundle)
+
+// WithFallback sets the locale of the messages missing in the requested
+// locale, requestctx.DefaultLocale by default.
+func WithFallback(locale string) Option {
+	return func(b *Bundle) {
+		b.fallback = locale
+	}
+}
+
+// Bundle holds the messages of the supported locales, by key.
+type Bundle struct {
+	fallback string
+
+	mu sync.RWMutex
+	// locales holds the messages of the locales, by lower-cased locale.
+	locales map[string]*locale
+}
+
+// locale is the messages of a locale.
+type locale struct {
+	name     string
+	messages map[string]string
+}
+
+// NewBundle creates an empty bundle.
+func NewBundle(opts ...Option) *Bundle {
+	b := &Bundle{
+		fallback: requestctx.DefaultLocale,
+		locales:  make(map[string]*locale),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// AddMessages adds messages to a locale, replacing the ones with the same
+// keys.
+func (b *Bundle) AddMessages(name string, messages map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := strings.ToLower(name)
+	l, ok := b.locales[key]
+	if !ok {
+		l = &locale{name: name, messages: make(map[string]string, len(messages))}
+		b.locales[key] = l
+	}
+	for k, v := range messages {
+		l.messages[k] = v
+	}
+}
+
+// LoadFile adds the messages of a YAML, JSON or TOML file to a locale,
+// nested keys being joined with dots:
+//
+//	order:
+//	  not_found: "Order %s not found"
+//
+// is the message "order.not_found".
+func (b *Bundle) LoadFile(name, path string) error {
+	cfg := config.NewConfig(config.NewFileSource(path))
+	if err := cfg.Load(); err != nil {
+		return fmt.Errorf("failed to load messages %s: %w", path, err)
+	}
+
+	lister, ok := cfg.(interface{ Keys() []string })
+	if !ok {
+		return nil
+	}
+	messages := make(map[string]string)
+	for _, k := range lister.Keys() {
+		v, err := cfg.Get(k)
+		if err != nil {
+			return err
+		}
+		switch v := v.(type) {
+		case string:
+			messages[k] = v
+		case bool, int, int64, float64:
+			messages[k] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("message %s of %s is not a string", k, path)
+		}
+	}
+	b.AddMessages(name, messages)
+	return nil
+}
+
+// LoadDir adds the messages of the files of dir named after their locale,
+// e.g. "en.yaml", "zh-CN.json" or "fr.toml".
+func (b *Bundle) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read messages directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		switch strings.ToLower(ext) {
+		case ".yaml", ".yml", ".json", ".toml":
+		default:
+			continue
+		}
+		if err := b.LoadFile(strings.TrimSuffix(entry.Name(), ext), filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Locales returns the locales of the bundle, sorted.
+func (b *Bundle) Locales() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.locales))
+	for _, l := range b.locales {
+		names = append(names, l.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the message of key in a locale, falling back to its
+// parent locales, e.g. "zh-Hant-TW" to "zh-Hant" then "zh", and then to the
+// fallback locale.
+func (b *Bundle) Lookup(name, key string) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, candidate := range [2]string{name, b.fallback} {
+		tag := strings.ToLower(candidate)
+		for tag != "" {
+			if l, ok := b.locales[tag]; ok {
+				if msg, ok := l.messages[key]; ok {
+					return msg, true
+				}
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return "", false
+}
+
+// Translate returns the message of key in a locale formatted with args as
+// with fmt.Sprintf, the key itself when there is no message.
+func (b *Bundle) Translate(name, key string, args ...interface{}) string {
+	msg, ok := b.Lookup(name, key)
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// T returns the message of key in the locale of the request, see
+// Translate.
+func (b *Bundle) T(ctx context.Context, key string, args ...interface{}) string {
+	return b.Translate(requestctx.Locale(ctx), key, args...)
+}
+
+// global is the global bundle.
+var global = NewBundle()
+
+// Default returns the global bundle.
+func Default() *Bundle {
+	return global
+}
+
+// SetDefault replaces the global bundle.
+func SetDefault(b *Bundle) {
+	global = b
+}
+
+// LoadDir adds the messages of the files of dir to the global bundle.
+func LoadDir(dir string) error {
+	return global.LoadDir(dir)
+}
+
+// AddMessages adds messages to a locale of the global bundle.
+func AddMessages(locale string, messages map[string]string) {
+	global.AddMessages(locale, messages)
+}
+
+// T returns the message of key of the global bundle in the locale of the
+// request, formatted with args:
+//
+//	msg := i18n.T(ctx, "order.created", order.ID)
+func T(ctx context.Context, key string, args ...interface{}) string {
+	return global.T(ctx, key, args...)
+}
@@ -0,0 +1,98 @@
+package i18n
+
+import (
+	"context"
+
+	"new-milli/middleware"
+	"new-milli/requestctx"
+	"new-milli/transport"
+)
+
+// HeaderContentLanguage is the reply header set to the negotiated locale.
+const HeaderContentLanguage = "Content-Language"
+
+// MiddlewareOption is i18n middleware option.
+type MiddlewareOption func(*middlewareOptions)
+
+// middlewareOptions is i18n middleware options.
+type middlewareOptions struct {
+	bundle *Bundle
+	header string
+	claim  string
+}
+
+// WithBundle returns a MiddlewareOption that sets the bundle whose locales
+// are negotiated, the global one by default.
+func WithBundle(b *Bundle) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.bundle = b
+	}
+}
+
+// WithHeader returns a MiddlewareOption that reads the locale chosen by the
+// caller from a request header, e.g. "X-Locale", before Accept-Language.
+func WithHeader(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.header = header
+	}
+}
+
+// WithClaim returns a MiddlewareOption that reads the locale of the user
+// from a claim of their token, e.g. "locale", before the header and
+// Accept-Language. The middleware must then follow the authentication one.
+func WithClaim(claim string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.claim = claim
+	}
+}
+
+// Server returns a middleware negotiating the locale of the request among
+// the locales of the bundle, from the claim, the header and then the
+// Accept-Language header. The locale is stored with
+// requestctx.ContextWithLocale, read by T, and set in the
+// Content-Language reply header. Requests matching no locale get
+// requestctx.DefaultLocale.
+func Server(opts ...MiddlewareOption) middleware.Middleware {
+	cfg := middlewareOptions{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			bundle := cfg.bundle
+			if bundle == nil {
+				bundle = global
+			}
+			supported := bundle.Locales()
+
+			var candidates []string
+			if cfg.claim != "" {
+				if v, ok := requestctx.User(ctx).Claims[cfg.claim].(string); ok {
+					candidates = append(candidates, v)
+				}
+			}
+			tr, ok := transport.FromServerContext(ctx)
+			if ok {
+				if cfg.header != "" {
+					candidates = append(candidates, tr.RequestHeader().Get(cfg.header))
+				}
+				candidates = append(candidates, tr.RequestHeader().Get(requestctx.HeaderAcceptLanguage))
+			}
+
+			for _, c := range candidates {
+				if c == "" {
+					continue
+				}
+				if locale := requestctx.Negotiate(c, supported); locale != "" {
+					ctx = requestctx.ContextWithLocale(ctx, locale)
+					break
+				}
+			}
+			if ok {
+				tr.ReplyHeader().Set(HeaderContentLanguage, requestctx.Locale(ctx))
+			}
+			return handler(ctx, req)
+		}
+	}
+}
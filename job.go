@@ -0,0 +1,200 @@
+package newMilli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+)
+
+// Exit codes of jobs.
+const (
+	// ExitOK is the exit code of a job that succeeded.
+	ExitOK = 0
+	// ExitFailure is the exit code of a job that failed.
+	ExitFailure = 1
+	// ExitInterrupted is the exit code of a job stopped by a signal.
+	ExitInterrupted = 130
+)
+
+// ExitCoder is implemented by errors choosing the exit code of a job.
+type ExitCoder interface {
+	ExitCode() int
+}
+
+// exitError is an error with an exit code.
+type exitError struct {
+	err  error
+	code int
+}
+
+// Error implements error.
+func (e *exitError) Error() string { return e.err.Error() }
+
+// Unwrap returns the wrapped error.
+func (e *exitError) Unwrap() error { return e.err }
+
+// ExitCode implements ExitCoder.
+func (e *exitError) ExitCode() int { return e.code }
+
+// WithExitCode returns an error making a job exit with code.
+func WithExitCode(err error, code int) error {
+	return &exitError{err: err, code: code}
+}
+
+// ExitCode returns the exit code of a job that returned err.
+func ExitCode(err error) int {
+	var ec ExitCoder
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &ec):
+		return ec.ExitCode()
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	}
+	return ExitFailure
+}
+
+// JobFunc is the function of a job.
+type JobFunc func(ctx context.Context) error
+
+// Job runs a function to completion with the wiring of an app, for
+// migrations, backfills and cron-launched batch jobs.
+type Job struct {
+	opts    options
+	name    string
+	fn      JobFunc
+	metrics *jobMetrics
+}
+
+// jobMetrics is the metrics of the jobs.
+type jobMetrics struct {
+	duration    *prometheus.HistogramVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+// newJobMetrics creates the job metrics registered with registry, reusing
+// the registered ones. They aren't registered when registry is nil.
+func newJobMetrics(registry prometheus.Registerer) *jobMetrics {
+	m := &jobMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "new_milli",
+			Subsystem: "job",
+			Name:      "duration_seconds",
+			Help:      "Duration of the job runs.",
+			Buckets:   []float64{1, 5, 15, 60, 300, 900, 1800, 3600, 7200, 14400},
+		}, []string{"job", "status"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "job",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful job run.",
+		}, []string{"job"}),
+	}
+	if registry != nil {
+		var errs [2]error
+		m.duration, errs[0] = collector.Register(registry, m.duration)
+		m.lastSuccess, errs[1] = collector.Register(registry, m.lastSuccess)
+		if err := errors.Join(errs[:]...); err != nil {
+			klog.Warnf("Failed to register job metrics: %v", err)
+		}
+	}
+	return m
+}
+
+// NewJob creates a job named name running fn. It takes the same options as
+// New: the BeforeStart hooks set up config, logger, connectors and tracing
+// before fn runs and the stop hooks and components clean up after it, while
+// servers, the registrar and the AfterStart hooks are ignored.
+func NewJob(name string, fn JobFunc, opts ...Option) (*Job, error) {
+	o := options{
+		ctx:         context.Background(),
+		sigs:        defaultSignals,
+		stopTimeout: 10 * time.Second,
+		metadata:    make(map[string]string),
+		metrics:     prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.id == "" {
+		o.id = uuid.NewString()
+	}
+	if o.name == "" {
+		o.name = name
+	}
+	if _, err := closeOrder(o.components); err != nil {
+		return nil, err
+	}
+	return &Job{opts: o, name: name, fn: fn, metrics: newJobMetrics(o.metrics)}, nil
+}
+
+// ID returns job instance id.
+func (j *Job) ID() string { return j.opts.id }
+
+// Name returns the service name, the job name unless set with Name.
+func (j *Job) Name() string { return j.opts.name }
+
+// Version returns job version.
+func (j *Job) Version() string { return j.opts.version }
+
+// Run runs the start hooks, the job function and the stop hooks, and returns
+// the first error. The context of the job is canceled on a signal.
+func (j *Job) Run() error {
+	ctx, stop := signal.NotifyContext(NewContext(j.opts.ctx, j), j.opts.sigs...)
+	defer stop()
+
+	start := time.Now()
+	klog.Infof("job %s started", j.name)
+
+	err := j.run(ctx)
+
+	// Clean up even when the job was interrupted
+	stopCtx, cancel := context.WithTimeout(NewContext(context.Background(), j), j.opts.stopTimeout)
+	defer cancel()
+	if herr := errors.Join(
+		runHooks(stopCtx, "before stop", j.opts.beforeStop),
+		runHooks(stopCtx, "after stop", j.opts.afterStop),
+		closeComponents(stopCtx, j.opts.components).Err(),
+	); herr != nil && err == nil {
+		err = herr
+	}
+
+	duration := time.Since(start)
+	status := "success"
+	switch code := ExitCode(err); code {
+	case ExitOK:
+		j.metrics.lastSuccess.WithLabelValues(j.name).SetToCurrentTime()
+		klog.Infof("job %s succeeded in %s", j.name, duration)
+	case ExitInterrupted:
+		status = "interrupted"
+		klog.Warnf("job %s interrupted after %s: %v", j.name, duration, err)
+	default:
+		status = "failure"
+		klog.Errorf("job %s failed after %s with exit code %d: %v", j.name, duration, code, err)
+	}
+	j.metrics.duration.WithLabelValues(j.name, status).Observe(duration.Seconds())
+	return err
+}
+
+// run runs the start hooks and the job function.
+func (j *Job) run(ctx context.Context) error {
+	for _, fn := range j.opts.beforeStart {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+	return j.fn(ctx)
+}
+
+// Exit runs the job and exits the process with its exit code, it suits main.
+func (j *Job) Exit() {
+	os.Exit(ExitCode(j.Run()))
+}
@@ -0,0 +1,194 @@
+package newMilli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// ComponentOption is component option.
+type ComponentOption func(*component)
+
+// component is a resource closed when the application stops, such as a
+// connector, a broker, a config watcher, a scheduler or a worker pool.
+type component struct {
+	name      string
+	priority  int
+	timeout   time.Duration
+	dependsOn []string
+	close     func(context.Context) error
+}
+
+// DependsOn declares the components the component uses. It is closed before
+// them, so a broker spooling to Redis is closed before the Redis connector.
+func DependsOn(names ...string) ComponentOption {
+	return func(c *component) {
+		c.dependsOn = append(c.dependsOn, names...)
+	}
+}
+
+// ComponentPriority sets the priority of the component. Among the
+// components whose dependents are closed, the highest priority closes
+// first, then the last registered. The default priority is 0.
+func ComponentPriority(priority int) ComponentOption {
+	return func(c *component) {
+		c.priority = priority
+	}
+}
+
+// ComponentTimeout sets how long the component may take to close. A
+// component exceeding it is reported and abandoned so the next components
+// still close within the StopTimeout.
+func ComponentTimeout(timeout time.Duration) ComponentOption {
+	return func(c *component) {
+		c.timeout = timeout
+	}
+}
+
+// ComponentReport is the outcome of closing a component.
+type ComponentReport struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	TimedOut bool
+}
+
+// ShutdownReport is the outcome of closing the components of an application.
+type ShutdownReport struct {
+	Components []ComponentReport
+	Duration   time.Duration
+}
+
+// Err returns the errors of the components that failed to close.
+func (r ShutdownReport) Err() error {
+	var errs []error
+	for _, c := range r.Components {
+		if c.Err != nil {
+			errs = append(errs, fmt.Errorf("close %s: %w", c.Name, c.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String formats the report, one component per line.
+func (r ShutdownReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "closed %d components in %s", len(r.Components), r.Duration)
+	for _, c := range r.Components {
+		status := "ok"
+		switch {
+		case c.TimedOut:
+			status = "timed out"
+		case c.Err != nil:
+			status = "failed: " + c.Err.Error()
+		}
+		fmt.Fprintf(&b, "\n  %s: %s in %s", c.Name, status, c.Duration)
+	}
+	return b.String()
+}
+
+// closeOrder returns the components in closing order: a component closes
+// after the components depending on it. It fails on an unknown dependency,
+// a duplicate name or a cycle.
+func closeOrder(components []component) ([]component, error) {
+	index := make(map[string]int, len(components))
+	for i, c := range components {
+		if _, ok := index[c.name]; ok {
+			return nil, fmt.Errorf("duplicate component %s", c.name)
+		}
+		index[c.name] = i
+	}
+
+	// dependents counts the components still open that depend on each one
+	dependents := make([]int, len(components))
+	for _, c := range components {
+		for _, dep := range c.dependsOn {
+			i, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("component %s depends on unknown component %s", c.name, dep)
+			}
+			dependents[i]++
+		}
+	}
+
+	order := make([]component, 0, len(components))
+	closed := make([]bool, len(components))
+	for len(order) < len(components) {
+		next := -1
+		for i := len(components) - 1; i >= 0; i-- {
+			if closed[i] || dependents[i] > 0 {
+				continue
+			}
+			if next < 0 || components[i].priority > components[next].priority {
+				next = i
+			}
+		}
+		if next < 0 {
+			return nil, errors.New("components have a dependency cycle")
+		}
+
+		closed[next] = true
+		order = append(order, components[next])
+		for _, dep := range components[next].dependsOn {
+			dependents[index[dep]]--
+		}
+	}
+	return order, nil
+}
+
+// closeComponents closes the components in order and logs the report.
+func closeComponents(ctx context.Context, components []component) ShutdownReport {
+	start := time.Now()
+	var report ShutdownReport
+	if len(components) == 0 {
+		return report
+	}
+
+	// The order was validated when the application was created
+	order, _ := closeOrder(components)
+	for _, c := range order {
+		report.Components = append(report.Components, closeComponent(ctx, c))
+	}
+	report.Duration = time.Since(start)
+
+	if err := report.Err(); err != nil {
+		klog.Errorf("shutdown: %s", report)
+	} else {
+		klog.Infof("shutdown: %s", report)
+	}
+	return report
+}
+
+// closeComponent closes a component within its timeout.
+func closeComponent(ctx context.Context, c component) ComponentReport {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- c.close(ctx)
+	}()
+
+	report := ComponentReport{Name: c.name}
+	select {
+	case report.Err = <-done:
+	case <-ctx.Done():
+		report.Err = ctx.Err()
+		report.TimedOut = true
+	}
+	report.Duration = time.Since(start)
+	return report
+}
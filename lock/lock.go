@@ -0,0 +1,69 @@
+package lock
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotAcquired is returned when a permit could not be acquired.
+	ErrNotAcquired = errors.New("lock: not acquired")
+	// ErrLeaseLost is returned when a lease expired before it was released.
+	ErrLeaseLost = errors.New("lock: lease lost")
+	// ErrExceedsCapacity is returned when taking more tokens than a token
+	// pool holds, which can never succeed.
+	ErrExceedsCapacity = errors.New("lock: exceeds capacity")
+	// ErrInvalidTokens is returned when taking zero or a negative number of
+	// tokens from a token pool.
+	ErrInvalidTokens = errors.New("lock: invalid number of tokens")
+)
+
+// Option is lock option.
+type Option func(*options)
+
+// options is lock options.
+type options struct {
+	prefix        string
+	ttl           time.Duration
+	retryInterval time.Duration
+	autoRenew     bool
+}
+
+// defaultOptions returns the default options.
+func defaultOptions() options {
+	return options{
+		prefix:        "lock:",
+		ttl:           time.Second * 30,
+		retryInterval: time.Millisecond * 100,
+		autoRenew:     true,
+	}
+}
+
+// WithPrefix sets the prefix of the Redis keys.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithTTL sets how long a permit is held without being renewed.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithRetryInterval sets the interval between attempts of blocking calls.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.retryInterval = interval
+	}
+}
+
+// WithoutAutoRenew disables the background lease renewal,
+// leases must then be renewed by calling Renew.
+func WithoutAutoRenew() Option {
+	return func(o *options) {
+		o.autoRenew = false
+	}
+}
@@ -0,0 +1,120 @@
+package lock
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript implements a token bucket stored in a hash with the current
+// number of tokens and the time of the last refill.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + (now - ts) * rate / 1000)
+
+local allowed = 0
+local wait = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+elseif rate > 0 then
+	wait = math.ceil((n - tokens) * 1000 / rate)
+else
+	wait = -1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "ts", now)
+if rate > 0 then
+	redis.call("PEXPIRE", KEYS[1], math.ceil(capacity * 1000 / rate) + 1000)
+end
+return {allowed, wait}
+`)
+
+// TokenPool is a token bucket shared across instances through Redis.
+// It holds up to capacity tokens and is refilled at rate tokens per second,
+// so all instances together never take more than the configured rate.
+type TokenPool struct {
+	client   redis.UniversalClient
+	key      string
+	capacity int64
+	rate     float64
+	opts     options
+}
+
+// NewTokenPool creates a token pool named name.
+func NewTokenPool(client redis.UniversalClient, name string, capacity int64, rate float64, opts ...Option) *TokenPool {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &TokenPool{
+		client:   client,
+		key:      o.prefix + "pool:" + name,
+		capacity: capacity,
+		rate:     rate,
+		opts:     o,
+	}
+}
+
+// Take takes n tokens without waiting and returns ErrNotAcquired
+// when there are not enough tokens, ErrExceedsCapacity when n is more
+// than the capacity and ErrInvalidTokens when n isn't positive.
+func (p *TokenPool) Take(ctx context.Context, n int64) error {
+	_, err := p.take(ctx, n)
+	return err
+}
+
+// Wait takes n tokens, waiting until they are available or ctx is done.
+// It returns ErrExceedsCapacity right away when n is more than the
+// capacity and ErrInvalidTokens when n isn't positive.
+func (p *TokenPool) Wait(ctx context.Context, n int64) error {
+	for {
+		wait, err := p.take(ctx, n)
+		if err != ErrNotAcquired {
+			return err
+		}
+		if wait <= 0 {
+			wait = p.opts.retryInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// take tries to take n tokens and returns how long to wait before they are available.
+func (p *TokenPool) take(ctx context.Context, n int64) (time.Duration, error) {
+	// A negative n would add tokens to the pool
+	if n <= 0 {
+		return 0, ErrInvalidTokens
+	}
+	if n > p.capacity {
+		return 0, ErrExceedsCapacity
+	}
+
+	res, err := takeScript.Run(ctx, p.client, []string{p.key}, p.capacity, p.rate, n).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if res[0] == 1 {
+		return 0, nil
+	}
+	return time.Duration(res[1]) * time.Millisecond, ErrNotAcquired
+}
@@ -0,0 +1,202 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/redis/go-redis/v9"
+)
+
+// Holders are kept in a sorted set scored by their expiry time in milliseconds,
+// the Redis server clock is used so instances don't have to agree on time.
+var (
+	acquireScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[2]), ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return 1
+`)
+
+	renewScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local score = redis.call("ZSCORE", KEYS[1], ARGV[2])
+if not score or tonumber(score) < now then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[1]), ARGV[2])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+)
+
+// Semaphore is a distributed counting semaphore backed by Redis.
+// At most limit leases can be held at the same time across all instances.
+type Semaphore struct {
+	client redis.UniversalClient
+	key    string
+	limit  int64
+	opts   options
+}
+
+// NewSemaphore creates a semaphore named name allowing limit concurrent holders.
+func NewSemaphore(client redis.UniversalClient, name string, limit int64, opts ...Option) *Semaphore {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Semaphore{
+		client: client,
+		key:    o.prefix + "sem:" + name,
+		limit:  limit,
+		opts:   o,
+	}
+}
+
+// TryAcquire acquires a permit without waiting and returns ErrNotAcquired
+// when all permits are taken.
+func (s *Semaphore) TryAcquire(ctx context.Context) (*Lease, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := acquireScript.Run(ctx, s.client, []string{s.key},
+		s.limit, s.opts.ttl.Milliseconds(), token).Bool()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotAcquired
+	}
+
+	return newLease(s, token), nil
+}
+
+// Acquire acquires a permit, waiting until one is available or ctx is done.
+func (s *Semaphore) Acquire(ctx context.Context) (*Lease, error) {
+	for {
+		lease, err := s.TryAcquire(ctx)
+		if err != ErrNotAcquired {
+			return lease, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(s.opts.retryInterval):
+		}
+	}
+}
+
+// Holders returns the number of permits currently held.
+func (s *Semaphore) Holders(ctx context.Context) (int64, error) {
+	now, err := s.client.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	return s.client.ZCount(ctx, s.key, "("+formatMillis(now), "+inf").Result()
+}
+
+// Lease is a permit held on a semaphore.
+type Lease struct {
+	sem   *Semaphore
+	token string
+
+	once   sync.Once
+	stop   chan struct{}
+	lost   chan struct{}
+	lostMu sync.Once
+}
+
+// newLease creates a lease and starts renewing it if enabled.
+func newLease(s *Semaphore, token string) *Lease {
+	l := &Lease{
+		sem:   s,
+		token: token,
+		stop:  make(chan struct{}),
+		lost:  make(chan struct{}),
+	}
+	if s.opts.autoRenew {
+		go l.keepAlive()
+	}
+	return l
+}
+
+// Done returns a channel that is closed when the lease is lost,
+// work guarded by the lease should stop when it fires.
+func (l *Lease) Done() <-chan struct{} {
+	return l.lost
+}
+
+// Renew extends the lease by the semaphore TTL.
+func (l *Lease) Renew(ctx context.Context) error {
+	ok, err := renewScript.Run(ctx, l.sem.client, []string{l.sem.key},
+		l.sem.opts.ttl.Milliseconds(), l.token).Bool()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		l.lostMu.Do(func() { close(l.lost) })
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release releases the permit.
+func (l *Lease) Release(ctx context.Context) error {
+	l.once.Do(func() { close(l.stop) })
+	return l.sem.client.ZRem(ctx, l.sem.key, l.token).Err()
+}
+
+// keepAlive renews the lease until it is released or lost.
+func (l *Lease) keepAlive() {
+	ticker := time.NewTicker(l.sem.opts.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.lost:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.sem.opts.ttl/3)
+			err := l.Renew(ctx)
+			cancel()
+			if err == ErrLeaseLost {
+				klog.Warnf("lock: lease on %s lost", l.sem.key)
+				return
+			}
+			if err != nil {
+				klog.Warnf("lock: failed to renew lease on %s: %v", l.sem.key, err)
+			}
+		}
+	}
+}
+
+// newToken returns a random lease token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// formatMillis formats t as unix milliseconds.
+func formatMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
@@ -0,0 +1,377 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Entry is a log entry to encode.
+type Entry struct {
+	// Time is the time of the entry, zero when time information is disabled.
+	Time time.Time
+	// Level is the level of the entry.
+	Level Level
+	// Caller is the file and line of the caller, empty when caller
+	// information is disabled.
+	Caller string
+	// Message is the message of the entry.
+	Message string
+	// Fields are the fields of the logger and its trace information.
+	Fields []Field
+}
+
+// Encoder encodes log entries, one line per entry.
+type Encoder interface {
+	// Encode returns the encoded entry, ending with a newline.
+	Encode(e *Entry) ([]byte, error)
+}
+
+// NewEncoder returns the encoder named name, for choosing the format in a
+// configuration file: "text", "json", "logfmt", "gelf" or "cef".
+func NewEncoder(name string) (Encoder, error) {
+	switch strings.ToLower(name) {
+	case "", "text":
+		return &TextEncoder{TimeFormat: time.RFC3339}, nil
+	case "json":
+		return &JSONEncoder{TimeFormat: time.RFC3339}, nil
+	case "logfmt":
+		return &LogfmtEncoder{TimeFormat: time.RFC3339}, nil
+	case "gelf":
+		return &GELFEncoder{}, nil
+	case "cef":
+		return &CEFEncoder{}, nil
+	}
+	return nil, fmt.Errorf("unknown log encoder %q", name)
+}
+
+// TextEncoder encodes entries as human readable text, e.g.
+// 2024-01-02T15:04:05Z [INFO] main.go:12 started port=8080
+type TextEncoder struct {
+	// TimeFormat is the time format.
+	TimeFormat string
+	// Color enables ANSI colors.
+	Color bool
+}
+
+// Encode implements Encoder.
+func (enc *TextEncoder) Encode(e *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+
+	// colored writes s in color when colors are enabled
+	colored := func(color, s string) {
+		if enc.Color {
+			buf.WriteString(color)
+		}
+		buf.WriteString(s)
+		if enc.Color {
+			buf.WriteString("\033[0m")
+		}
+	}
+
+	if !e.Time.IsZero() {
+		colored("\033[90m", e.Time.Format(enc.TimeFormat))
+		buf.WriteByte(' ')
+	}
+	colored(e.Level.Color(), "["+e.Level.String()+"]")
+	buf.WriteByte(' ')
+	if e.Caller != "" {
+		colored("\033[90m", e.Caller)
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(e.Message)
+
+	if len(e.Fields) > 0 {
+		buf.WriteByte(' ')
+		for i, field := range e.Fields {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			colored("\033[36m", field.Key+"=")
+			fmt.Fprintf(&buf, "%v", field.Value)
+		}
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// JSONEncoder encodes entries as JSON objects with the keys of the
+// JSONLogger defaults.
+type JSONEncoder struct {
+	// TimeFormat is the time format.
+	TimeFormat string
+}
+
+// Encode implements Encoder.
+func (enc *JSONEncoder) Encode(e *Entry) ([]byte, error) {
+	entry := make(map[string]interface{}, len(e.Fields)+4)
+	for _, field := range e.Fields {
+		entry[field.Key] = jsonValue(field.Value)
+	}
+	if !e.Time.IsZero() {
+		entry["time"] = e.Time.Format(enc.TimeFormat)
+	}
+	entry["level"] = e.Level.String()
+	entry["message"] = e.Message
+	if e.Caller != "" {
+		entry["caller"] = e.Caller
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// LogfmtEncoder encodes entries as logfmt key=value pairs, which Loki and
+// most log processors parse without configuration, e.g.
+// time=2024-01-02T15:04:05Z level=info caller=main.go:12 msg="server started" port=8080
+type LogfmtEncoder struct {
+	// TimeFormat is the time format.
+	TimeFormat string
+}
+
+// Encode implements Encoder.
+func (enc *LogfmtEncoder) Encode(e *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if !e.Time.IsZero() {
+		writeLogfmt(&buf, "time", e.Time.Format(enc.TimeFormat))
+	}
+	writeLogfmt(&buf, "level", strings.ToLower(e.Level.String()))
+	if e.Caller != "" {
+		writeLogfmt(&buf, "caller", e.Caller)
+	}
+	writeLogfmt(&buf, "msg", e.Message)
+	for _, field := range e.Fields {
+		writeLogfmt(&buf, logfmtKey(field.Key), stringValue(field.Value))
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// writeLogfmt writes a logfmt pair, quoting the value when needed.
+func writeLogfmt(buf *bytes.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " =\"\\") || strings.IndexFunc(value, isControl) >= 0 || !utf8.ValidString(value) {
+		buf.WriteString(strconv.Quote(value))
+		return
+	}
+	buf.WriteString(value)
+}
+
+// logfmtKey replaces the characters keys can't contain.
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// isControl reports whether r is a control character.
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
+
+// GELFEncoder encodes entries as GELF 1.1 messages for Graylog. The fields
+// become additional fields prefixed with an underscore.
+type GELFEncoder struct {
+	// Host is the host of the messages, the hostname by default.
+	Host string
+}
+
+// Encode implements Encoder.
+func (enc *GELFEncoder) Encode(e *Entry) ([]byte, error) {
+	host := enc.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	msg := make(map[string]interface{}, len(e.Fields)+6)
+	for _, field := range e.Fields {
+		key := gelfKey(field.Key)
+		if key == "_id" {
+			// _id is reserved by Graylog
+			key = "_field_id"
+		}
+		msg[key] = jsonValue(field.Value)
+	}
+	msg["version"] = "1.1"
+	msg["host"] = host
+	msg["short_message"] = e.Message
+	msg["level"] = syslogSeverity(e.Level)
+	if !e.Time.IsZero() {
+		msg["timestamp"] = math.Round(float64(e.Time.UnixNano())/1e6) / 1e3
+	}
+	if e.Caller != "" {
+		msg["_caller"] = e.Caller
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// gelfKey returns the additional field name of key, allowed characters are
+// letters, digits, underscores, dashes and dots.
+func gelfKey(key string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// syslogSeverity returns the syslog severity of level.
+func syslogSeverity(level Level) int {
+	switch level {
+	case DebugLevel:
+		return 7
+	case InfoLevel:
+		return 6
+	case WarnLevel:
+		return 4
+	case ErrorLevel:
+		return 3
+	case FatalLevel:
+		return 2
+	}
+	return 5
+}
+
+// CEFEncoder encodes entries in the ArcSight Common Event Format for SIEM
+// systems, e.g.
+// CEF:0|new-milli|order-service|1.0|ERROR|payment failed|8|rt=1704207845000 userid=42
+type CEFEncoder struct {
+	// Vendor is the device vendor, "new-milli" by default.
+	Vendor string
+	// Product is the device product, e.g. the service name.
+	Product string
+	// Version is the device version.
+	Version string
+	// EventClassKey is the field holding the signature ID of the events,
+	// the level name when empty or missing.
+	EventClassKey string
+}
+
+// Encode implements Encoder.
+func (enc *CEFEncoder) Encode(e *Entry) ([]byte, error) {
+	vendor := enc.Vendor
+	if vendor == "" {
+		vendor = "new-milli"
+	}
+	signature := e.Level.String()
+
+	var ext bytes.Buffer
+	if !e.Time.IsZero() {
+		writeCEF(&ext, "rt", strconv.FormatInt(e.Time.UnixMilli(), 10))
+	}
+	if e.Caller != "" {
+		writeCEF(&ext, "caller", e.Caller)
+	}
+	for _, field := range e.Fields {
+		value := stringValue(field.Value)
+		if enc.EventClassKey != "" && field.Key == enc.EventClassKey {
+			signature = value
+			continue
+		}
+		writeCEF(&ext, cefKey(field.Key), value)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("CEF:0|")
+	for _, header := range []string{vendor, enc.Product, enc.Version, signature, e.Message} {
+		buf.WriteString(cefHeader(header))
+		buf.WriteByte('|')
+	}
+	buf.WriteString(strconv.Itoa(cefSeverity(e.Level)))
+	buf.WriteByte('|')
+	buf.Write(ext.Bytes())
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// cefHeader escapes a header value.
+func cefHeader(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r", " ", "\n", " ").Replace(s)
+	return s
+}
+
+// writeCEF writes an escaped extension pair.
+func writeCEF(buf *bytes.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	buf.WriteString(strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", `\r`, "\n", `\n`).Replace(value))
+}
+
+// cefKey returns the extension key of key, alphanumeric only.
+func cefKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return -1
+	}, key)
+}
+
+// cefSeverity returns the CEF severity, 0 to 10, of level.
+func cefSeverity(level Level) int {
+	switch level {
+	case DebugLevel:
+		return 1
+	case InfoLevel:
+		return 3
+	case WarnLevel:
+		return 6
+	case ErrorLevel:
+		return 8
+	case FatalLevel:
+		return 10
+	}
+	return 5
+}
+
+// stringValue formats a field value.
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// jsonValue returns a field value encodable as JSON, errors as their
+// message.
+func jsonValue(v interface{}) interface{} {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return v
+}
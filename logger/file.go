@@ -1,9 +1,12 @@
 package logger
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -114,7 +117,7 @@ func (w *FileWriter) openFile() error {
 	}
 
 	// Open the file
-	file, err := os.OpenFile(w.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	file, err := openAppend(w.Path)
 	if err != nil {
 		return err
 	}
@@ -191,7 +194,8 @@ func (w *FileWriter) rotate() error {
 type RotatingFileWriter struct {
 	// Path is the path to the log file.
 	Path string
-	// MaxSize is the maximum size of the log file in bytes.
+	// MaxSize is the maximum size of the log file in bytes, zero to rotate
+	// on time only.
 	MaxSize int64
 	// MaxBackups is the maximum number of old log files to retain.
 	MaxBackups int
@@ -203,10 +207,24 @@ type RotatingFileWriter struct {
 	// Compress determines if the rotated log files should be compressed
 	// using gzip.
 	Compress bool
-
-	mu   sync.Mutex
-	file *os.File
-	size int64
+	// RotateEvery rotates the file at the start of every period, e.g.
+	// 24 * time.Hour for daily or time.Hour for hourly files. Zero rotates
+	// on size only.
+	RotateEvery time.Duration
+	// BackupTimeFormat is the time format in the names of the backup files,
+	// e.g. app.log.2006-01-02. It defaults to 2006-01-02 for daily,
+	// 2006-01-02T15 for hourly and 2006-01-02T15-04-05 for size rotation.
+	BackupTimeFormat string
+
+	mu          sync.Mutex
+	file        *os.File
+	size        int64
+	periodStart time.Time
+	periodEnd   time.Time
+
+	// background compresses and removes the backups in order
+	background sync.Mutex
+	pending    sync.WaitGroup
 }
 
 // NewRotatingFileWriter creates a new rotating file writer.
@@ -234,10 +252,10 @@ func (w *RotatingFileWriter) Write(p []byte) (n int, err error) {
 	}
 
 	// Check if the file needs to be rotated
-	if w.size+int64(len(p)) > w.MaxSize {
-		if err := w.rotate(); err != nil {
-			return 0, err
-		}
+	now := w.now()
+	if (w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize) ||
+		(w.RotateEvery > 0 && !now.Before(w.periodEnd)) {
+		w.rotate(now)
 	}
 
 	// Write to the file
@@ -246,18 +264,27 @@ func (w *RotatingFileWriter) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
-// Close closes the file.
+// Close closes the file and waits for the backups to be compressed.
 func (w *RotatingFileWriter) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	var err error
 	if w.file != nil {
-		err := w.file.Close()
+		err = w.file.Close()
 		w.file = nil
-		return err
 	}
 
-	return nil
+	w.pending.Wait()
+	return err
+}
+
+// now returns the current time in the time zone of the backups.
+func (w *RotatingFileWriter) now() time.Time {
+	if w.LocalTime {
+		return time.Now()
+	}
+	return time.Now().UTC()
 }
 
 // openFile opens the log file.
@@ -269,7 +296,7 @@ func (w *RotatingFileWriter) openFile() error {
 	}
 
 	// Open the file
-	file, err := os.OpenFile(w.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	file, err := openAppend(w.Path)
 	if err != nil {
 		return err
 	}
@@ -283,43 +310,149 @@ func (w *RotatingFileWriter) openFile() error {
 
 	w.file = file
 	w.size = info.Size()
+
+	// A file left by a previous run belongs to the period it was written in
+	if w.RotateEvery > 0 {
+		t := w.now()
+		if w.size > 0 {
+			t = info.ModTime().In(t.Location())
+		}
+		w.periodStart, w.periodEnd = w.period(t)
+	}
+
 	return nil
 }
 
-// rotate rotates the log file.
-func (w *RotatingFileWriter) rotate() error {
-	// Close the current file
-	if w.file != nil {
-		if err := w.file.Close(); err != nil {
-			return err
+// period returns the rotation period containing t. Periods of a day or more
+// start at midnight, shorter ones are aligned on midnight.
+func (w *RotatingFileWriter) period(t time.Time) (start, end time.Time) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	if days := int(w.RotateEvery / (24 * time.Hour)); days > 0 {
+		return midnight, midnight.AddDate(0, 0, days)
+	}
+
+	start = midnight.Add(t.Sub(midnight) / w.RotateEvery * w.RotateEvery)
+	return start, start.Add(w.RotateEvery)
+}
+
+// rotate rotates the log file. The file is renamed while it's still open and
+// only replaced once the new file is open, so if either step fails, e.g.
+// because another process holds the file on Windows, the lines keep going
+// to the current file and the rotation is retried on the next write.
+func (w *RotatingFileWriter) rotate(now time.Time) {
+	backupPath := w.backupPath(now)
+	if err := os.Rename(w.Path, backupPath); err != nil {
+		return
+	}
+
+	// Open a new log file
+	current, size, start, end := w.file, w.size, w.periodStart, w.periodEnd
+	if err := w.openFile(); err != nil {
+		w.file, w.size, w.periodStart, w.periodEnd = current, size, start, end
+		return
+	}
+	current.Close()
+
+	// Compress the backup file and remove old backup files
+	if !w.Compress {
+		if w.MaxBackups > 0 || w.MaxAge > 0 {
+			w.removeOldBackups()
 		}
-		w.file = nil
+		return
 	}
 
-	// Generate the timestamp
-	var timestamp string
-	if w.LocalTime {
-		timestamp = time.Now().Format("2006-01-02T15-04-05")
-	} else {
-		timestamp = time.Now().UTC().Format("2006-01-02T15-04-05")
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+
+		w.background.Lock()
+		defer w.background.Unlock()
+
+		// The backup may have been removed by a previous cleanup already
+		if err := compressFile(backupPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to compress log file %s: %v\n", backupPath, err)
+		}
+		if w.MaxBackups > 0 || w.MaxAge > 0 {
+			w.removeOldBackups()
+		}
+	}()
+}
+
+// backupPath returns a free backup path for the current file, named after
+// its period with time rotation and after now otherwise.
+func (w *RotatingFileWriter) backupPath(now time.Time) string {
+	t, layout := now, "2006-01-02T15-04-05"
+	if w.RotateEvery > 0 {
+		t = w.periodStart
+		switch {
+		case w.RotateEvery >= 24*time.Hour:
+			layout = "2006-01-02"
+		case w.RotateEvery >= time.Hour:
+			layout = "2006-01-02T15"
+		default:
+			layout = "2006-01-02T15-04"
+		}
+	}
+	if w.BackupTimeFormat != "" {
+		layout = w.BackupTimeFormat
 	}
 
-	// Rename the current log file
-	backupPath := fmt.Sprintf("%s.%s", w.Path, timestamp)
-	os.Rename(w.Path, backupPath)
+	// Size rotation may rotate several files in the same period
+	path := fmt.Sprintf("%s.%s", w.Path, t.Format(layout))
+	for i := 1; exists(path) || exists(path+".gz"); i++ {
+		path = fmt.Sprintf("%s.%s.%d", w.Path, t.Format(layout), i)
+	}
+	return path
+}
+
+// exists reports whether a file exists at path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// compressFile compresses path to path.gz and removes it.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
 
-	// Compress the backup file if needed
-	if w.Compress {
-		// TODO: Implement compression
+	info, err := src.Stat()
+	if err != nil {
+		return err
 	}
 
-	// Remove old backup files
-	if w.MaxBackups > 0 || w.MaxAge > 0 {
-		w.removeOldBackups()
+	// Write to a temporary file so a partial archive is never left behind
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
 	}
 
-	// Open a new log file
-	return w.openFile()
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Keep the modification time for MaxAge
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
 }
 
 // removeOldBackups removes old backup files.
@@ -341,13 +474,15 @@ func (w *RotatingFileWriter) removeOldBackups() {
 	}
 	var backups []backupFile
 	for _, match := range matches {
+		if strings.HasSuffix(match, ".tmp") {
+			continue
+		}
 		info, err := os.Stat(match)
 		if err != nil {
 			continue
 		}
 		backups = append(backups, backupFile{Path: match, ModTime: info.ModTime()})
 	}
-
 	// Sort by modification time (newest first)
 	for i := 0; i < len(backups); i++ {
 		for j := i + 1; j < len(backups); j++ {
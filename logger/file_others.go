@@ -0,0 +1,10 @@
+//go:build !windows
+
+package logger
+
+import "os"
+
+// openAppend opens path for appending, creating it if needed.
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// levelBody is the body of the level handler.
+type levelBody struct {
+	Level Level `json:"level"`
+}
+
+// LevelHandler returns a Hertz admin handler for level, nil for the level of
+// the global logger. GET returns the level, e.g. {"level":"INFO"}, and PUT
+// or POST sets it, e.g. PUT /log/level {"level":"debug"}. Mount it behind
+// authentication.
+func LevelHandler(level *AtomicLevel) app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		l := level
+		if l == nil {
+			l = LevelOf(global)
+		}
+		if l == nil {
+			c.String(http.StatusNotImplemented, "the global logger has no dynamic level")
+			return
+		}
+
+		switch string(c.Method()) {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var body struct {
+				Level *Level `json:"level"`
+			}
+			if err := json.Unmarshal(c.Request.Body(), &body); err != nil {
+				c.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			if body.Level == nil {
+				c.String(http.StatusBadRequest, "missing level")
+				return
+			}
+			l.SetLevel(*body.Level)
+		default:
+			c.String(http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		c.JSON(http.StatusOK, levelBody{Level: l.Level()})
+	}
+}
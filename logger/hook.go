@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HookEntry is a log entry passed to the hooks.
+type HookEntry struct {
+	// Context is the context of the logger, set with WithContext.
+	Context context.Context
+	Time    time.Time
+	Level   Level
+	// Caller is the file and line of the caller, empty when caller
+	// information is disabled.
+	Caller  string
+	Message string
+	// Fields are the fields of the logger, without its trace information.
+	Fields []Field
+	// TraceInfo is the trace information of the logger, nil if it has none.
+	TraceInfo *TraceInfo
+}
+
+// Hook receives the entries written by the loggers of this package, e.g.
+// to report the errors to an error tracking service. Fire is called after
+// the entry is written, before the process exits for the fatal entries.
+type Hook interface {
+	// Levels returns the levels of the entries the hook receives.
+	Levels() []Level
+	// Fire handles an entry, it must not log with the logger firing it at
+	// one of its levels.
+	Fire(e *HookEntry)
+}
+
+// hook is a hook added with AddHook, its address identifies it.
+type hook struct {
+	Hook
+}
+
+// hooks is the hooks added with AddHook.
+var hooks = struct {
+	sync.RWMutex
+	list []*hook
+}{}
+
+// AddHook adds a hook receiving the entries of every logger, it returns a
+// function removing it.
+func AddHook(h Hook) (remove func()) {
+	added := &hook{h}
+	hooks.Lock()
+	hooks.list = append(hooks.list[:len(hooks.list):len(hooks.list)], added)
+	hooks.Unlock()
+
+	return func() {
+		hooks.Lock()
+		defer hooks.Unlock()
+		for i, h := range hooks.list {
+			if h == added {
+				list := make([]*hook, 0, len(hooks.list)-1)
+				hooks.list = append(append(list, hooks.list[:i]...), hooks.list[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// fireHooks passes the entry built by entry to the hooks of level, entry is
+// only called when there are some.
+func fireHooks(level Level, entry func() *HookEntry) {
+	hooks.RLock()
+	list := hooks.list
+	hooks.RUnlock()
+
+	var e *HookEntry
+	for _, h := range list {
+		for _, l := range h.Levels() {
+			if l != level {
+				continue
+			}
+			if e == nil {
+				e = entry()
+			}
+			h.Fire(e)
+			break
+		}
+	}
+}
+
+// mapFields returns the fields of a map sorted by key.
+func mapFields(m map[string]interface{}) []Field {
+	fields := make([]Field, 0, len(m))
+	for k, v := range m {
+		fields = append(fields, F(k, v))
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	return fields
+}
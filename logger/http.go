@@ -0,0 +1,145 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPEncoding encodes a batch of entries as the body of a request.
+type HTTPEncoding func(batch []SinkEntry) (body []byte, contentType string, err error)
+
+// NDJSONEncoding sends the entries as they were written, one per line. It
+// suits the JSON encoders, e.g. for Elasticsearch or Vector HTTP sources.
+func NDJSONEncoding(batch []SinkEntry) ([]byte, string, error) {
+	var buf bytes.Buffer
+	for _, e := range batch {
+		buf.Write(formatLine(e, true))
+	}
+	return buf.Bytes(), "application/x-ndjson", nil
+}
+
+// LokiEncoding sends the entries to the Loki push API
+// (/loki/api/v1/push) as a stream with labels.
+func LokiEncoding(labels map[string]string) HTTPEncoding {
+	return func(batch []SinkEntry) ([]byte, string, error) {
+		values := make([][2]string, 0, len(batch))
+		for _, e := range batch {
+			values = append(values, [2]string{
+				strconv.FormatInt(e.Time.UnixNano(), 10),
+				string(bytes.TrimRight(ansi.ReplaceAll(e.Data, nil), "\n")),
+			})
+		}
+
+		type stream struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		}
+		body, err := json.Marshal(map[string][]stream{
+			"streams": {{Stream: labels, Values: values}},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return body, "application/json", nil
+	}
+}
+
+// WithHTTPEncoding sets the encoding of the requests, NDJSONEncoding by
+// default.
+func WithHTTPEncoding(encoding HTTPEncoding) SinkOption {
+	return func(o *sinkOptions) {
+		o.encoding = encoding
+	}
+}
+
+// WithHTTPClient sets the client sending the requests,
+// http.DefaultClient by default.
+func WithHTTPClient(client *http.Client) SinkOption {
+	return func(o *sinkOptions) {
+		o.client = client
+	}
+}
+
+// WithHTTPHeader adds a header to the requests, e.g. for authentication or
+// the X-Scope-OrgID of Loki.
+func WithHTTPHeader(key, value string) SinkOption {
+	return func(o *sinkOptions) {
+		o.header.Add(key, value)
+	}
+}
+
+// HTTPWriter is a writer that ships logs in batches to an HTTP endpoint.
+// Write queues the entries, a background goroutine posts them.
+type HTTPWriter struct {
+	url  string
+	opts *sinkOptions
+	sink *sink
+}
+
+// NewHTTPWriter creates a writer posting batches of entries to url, e.g.
+// NewHTTPWriter("http://loki:3100/loki/api/v1/push",
+// WithHTTPEncoding(LokiEncoding(map[string]string{"service": "order"}))).
+// A batch waits up to 1s for more entries.
+func NewHTTPWriter(url string, opts ...SinkOption) *HTTPWriter {
+	options := newSinkOptions(url, time.Second, opts)
+	w := &HTTPWriter{
+		url:  url,
+		opts: options,
+	}
+	w.sink = newSink(options, w.deliver)
+	return w
+}
+
+// Write queues p for shipping. It doesn't block, p is dropped if the queue
+// is full.
+func (w *HTTPWriter) Write(p []byte) (int, error) {
+	return w.sink.write(p)
+}
+
+// Close ships the queued entries.
+func (w *HTTPWriter) Close() error {
+	return w.sink.close()
+}
+
+// deliver posts a batch.
+func (w *HTTPWriter) deliver(batch []SinkEntry) error {
+	body, contentType, err := w.opts.encoding(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	ctx := context.Background()
+	if w.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.opts.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, values := range w.opts.header {
+		req.Header[key] = values
+	}
+
+	resp, err := w.opts.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -5,22 +5,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"runtime"
 	"sync"
 	"time"
+
+	"new-milli/mask"
 )
 
 // JSONLogger is a logger that outputs JSON.
 type JSONLogger struct {
-	config *JSONConfig
-	mu     sync.Mutex
-	ctx    context.Context
+	config    *JSONConfig
+	mu        sync.Mutex
+	ctx       context.Context
+	traceInfo *TraceInfo
 }
 
 // JSONConfig is the configuration for the JSON logger.
 type JSONConfig struct {
 	// Level is the log level.
 	Level Level
+	// AtomicLevel is the level shared by the logger and the loggers derived
+	// from it, created from Level by NewJSONLogger when nil.
+	AtomicLevel *AtomicLevel
 	// Output is the log output.
 	Output io.Writer
 	// Fields are the default fields.
@@ -29,10 +36,16 @@ type JSONConfig struct {
 	EnableCaller bool
 	// EnableTime enables time information.
 	EnableTime bool
+	// EnableTrace enables trace information.
+	EnableTrace bool
 	// TimeFormat is the time format.
 	TimeFormat string
 	// CallerSkip is the number of stack frames to skip when getting caller information.
 	CallerSkip int
+	// ServiceName is the name of the service.
+	ServiceName string
+	// Environment is the environment (e.g., production, staging, development).
+	Environment string
 	// TimeKey is the key for the time field.
 	TimeKey string
 	// LevelKey is the key for the level field.
@@ -55,8 +68,11 @@ func DefaultJSONConfig() *JSONConfig {
 		Fields:        make(map[string]interface{}),
 		EnableCaller:  true,
 		EnableTime:    true,
+		EnableTrace:   true,
 		TimeFormat:    time.RFC3339,
 		CallerSkip:    2,
+		ServiceName:   "unknown",
+		Environment:   "development",
 		TimeKey:       "time",
 		LevelKey:      "level",
 		MessageKey:    "message",
@@ -71,12 +87,28 @@ func NewJSONLogger(config *JSONConfig) Logger {
 	if config == nil {
 		config = DefaultJSONConfig()
 	}
+	c := *config
+	config = &c
+	if config.AtomicLevel == nil {
+		config.AtomicLevel = NewAtomicLevel(config.Level)
+	}
 	if config.Output == nil {
 		config.Output = DefaultConfig().Output
 	}
+
+	// 创建跟踪信息，只包含服务名称和环境，请求的跟踪信息来自上下文
+	traceInfo := withTraceInfo(nil)
+	if config.ServiceName != "" {
+		traceInfo.WithServiceName(config.ServiceName)
+	}
+	if config.Environment != "" {
+		traceInfo.WithEnvironment(config.Environment)
+	}
+
 	return &JSONLogger{
-		config: config,
-		ctx:    context.Background(),
+		config:    config,
+		ctx:       context.Background(),
+		traceInfo: traceInfo,
 	}
 }
 
@@ -123,13 +155,13 @@ func (l *JSONLogger) Errorf(format string, args ...interface{}) {
 // Fatal logs a fatal message and exits.
 func (l *JSONLogger) Fatal(args ...interface{}) {
 	l.log(FatalLevel, fmt.Sprint(args...))
-	panic(fmt.Sprint(args...))
+	os.Exit(1)
 }
 
 // Fatalf logs a formatted fatal message and exits.
 func (l *JSONLogger) Fatalf(format string, args ...interface{}) {
 	l.log(FatalLevel, fmt.Sprintf(format, args...))
-	panic(fmt.Sprintf(format, args...))
+	os.Exit(1)
 }
 
 // WithFields returns a new logger with the given fields.
@@ -140,30 +172,42 @@ func (l *JSONLogger) WithFields(fields ...Field) Logger {
 		newFields[k] = v
 	}
 	for _, field := range fields {
-		newFields[field.Key] = field.Value
+		newFields[field.Key] = mask.Log(field.Value)
 	}
 	config.Fields = newFields
 	return &JSONLogger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
 // WithContext returns a new logger with the given context.
 func (l *JSONLogger) WithContext(ctx context.Context) Logger {
-	return &JSONLogger{
-		config: l.config,
-		ctx:    ctx,
+	newLogger := &JSONLogger{
+		config:    l.config,
+		ctx:       ctx,
+		traceInfo: l.traceInfo,
+	}
+
+	// 从上下文中获取跟踪信息
+	if traceInfo, ok := ctx.Value(traceKey).(*TraceInfo); ok && traceInfo != nil {
+		newLogger.traceInfo = traceInfo
 	}
+
+	return newLogger
 }
 
-// WithLevel returns a new logger with the given level.
+// WithLevel returns a new logger with the given level. Unlike the other
+// derived loggers, it doesn't share the level of l.
 func (l *JSONLogger) WithLevel(level Level) Logger {
 	config := *l.config
 	config.Level = level
+	config.AtomicLevel = NewAtomicLevel(level)
 	return &JSONLogger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -172,8 +216,9 @@ func (l *JSONLogger) WithOutput(output io.Writer) Logger {
 	config := *l.config
 	config.Output = output
 	return &JSONLogger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -182,8 +227,9 @@ func (l *JSONLogger) WithCaller(enabled bool) Logger {
 	config := *l.config
 	config.EnableCaller = enabled
 	return &JSONLogger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -192,8 +238,9 @@ func (l *JSONLogger) WithTime(enabled bool) Logger {
 	config := *l.config
 	config.EnableTime = enabled
 	return &JSONLogger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -203,12 +250,82 @@ func (l *JSONLogger) WithColor(enabled bool) Logger {
 	return l
 }
 
+// WithTrace returns a new logger with trace information.
+func (l *JSONLogger) WithTrace(enabled bool) Logger {
+	config := *l.config
+	config.EnableTrace = enabled
+	return &JSONLogger{
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
+	}
+}
+
+// WithServiceName returns a new logger with the given service name.
+func (l *JSONLogger) WithServiceName(serviceName string) Logger {
+	config := *l.config
+	config.ServiceName = serviceName
+	return &JSONLogger{
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: withTraceInfo(l.traceInfo).WithServiceName(serviceName),
+	}
+}
+
+// WithEnvironment returns a new logger with the given environment.
+func (l *JSONLogger) WithEnvironment(environment string) Logger {
+	config := *l.config
+	config.Environment = environment
+	return &JSONLogger{
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: withTraceInfo(l.traceInfo).WithEnvironment(environment),
+	}
+}
+
+// WithTraceInfo returns a new logger with the given trace information.
+func (l *JSONLogger) WithTraceInfo(traceInfo *TraceInfo) Logger {
+	return &JSONLogger{
+		config:    l.config,
+		ctx:       l.ctx,
+		traceInfo: traceInfo,
+	}
+}
+
+// atomicLevel returns the level of the logger.
+func (l *JSONLogger) atomicLevel() *AtomicLevel {
+	return l.config.AtomicLevel
+}
+
+// enabled reports whether messages of level are logged.
+func (c *JSONConfig) enabled(level Level) bool {
+	if c.AtomicLevel != nil {
+		return c.AtomicLevel.Enabled(level)
+	}
+	return level >= c.Level
+}
+
 // log logs a message with the given level.
 func (l *JSONLogger) log(level Level, message string) {
-	if level < l.config.Level {
+	if !l.config.enabled(level) {
 		return
 	}
 
+	var now time.Time
+	var caller string
+	// The hooks are fired once the entry is written, without the lock
+	defer fireHooks(level, func() *HookEntry {
+		return &HookEntry{
+			Context:   l.ctx,
+			Time:      now,
+			Level:     level,
+			Caller:    caller,
+			Message:   message,
+			Fields:    mapFields(l.config.Fields),
+			TraceInfo: l.traceInfo,
+		}
+	})
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -217,7 +334,8 @@ func (l *JSONLogger) log(level Level, message string) {
 
 	// Add time
 	if l.config.EnableTime {
-		entry[l.config.TimeKey] = time.Now().Format(l.config.TimeFormat)
+		now = time.Now()
+		entry[l.config.TimeKey] = now.Format(l.config.TimeFormat)
 	}
 
 	// Add level
@@ -230,10 +348,27 @@ func (l *JSONLogger) log(level Level, message string) {
 	if l.config.EnableCaller {
 		_, file, line, ok := runtime.Caller(l.config.CallerSkip)
 		if ok {
-			entry[l.config.CallerKey] = fmt.Sprintf("%s:%d", file, line)
+			caller = fmt.Sprintf("%s:%d", file, line)
+			entry[l.config.CallerKey] = caller
+		}
+	}
+
+	// Add trace fields if enabled
+	if l.config.EnableTrace && l.traceInfo != nil {
+		for _, field := range l.traceInfo.ToFields() {
+			entry[field.Key] = field.Value
 		}
 	}
 
+	// Add service fields, also when the trace information comes from a
+	// context without them
+	if _, ok := entry[string(ServiceNameKey)]; !ok && l.config.ServiceName != "" {
+		entry[string(ServiceNameKey)] = l.config.ServiceName
+	}
+	if _, ok := entry[string(EnvironmentKey)]; !ok && l.config.Environment != "" {
+		entry[string(EnvironmentKey)] = l.config.Environment
+	}
+
 	// Add fields
 	for k, v := range l.config.Fields {
 		entry[k] = v
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// ParseLevel parses a level name, e.g. "debug" or "WARN".
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBUG":
+		return DebugLevel, nil
+	case "INFO":
+		return InfoLevel, nil
+	case "WARN", "WARNING":
+		return WarnLevel, nil
+	case "ERROR":
+		return ErrorLevel, nil
+	case "FATAL":
+		return FatalLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// AtomicLevel is a level that can be changed at runtime. A logger and the
+// loggers derived from it with WithFields, WithContext and the like share
+// their AtomicLevel, so changing it changes the level of all of them.
+type AtomicLevel struct {
+	level atomic.Int32
+}
+
+// NewAtomicLevel creates an AtomicLevel set to level.
+func NewAtomicLevel(level Level) *AtomicLevel {
+	l := &AtomicLevel{}
+	l.SetLevel(level)
+	return l
+}
+
+// Level returns the level.
+func (l *AtomicLevel) Level() Level {
+	return Level(l.level.Load())
+}
+
+// SetLevel sets the level.
+func (l *AtomicLevel) SetLevel(level Level) {
+	l.level.Store(int32(level))
+}
+
+// Enabled reports whether messages of level are logged.
+func (l *AtomicLevel) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// String returns the name of the level.
+func (l *AtomicLevel) String() string {
+	return l.Level().String()
+}
+
+// leveled is a logger with an AtomicLevel.
+type leveled interface {
+	atomicLevel() *AtomicLevel
+}
+
+// LevelOf returns the AtomicLevel of l, nil if l is not a logger of this
+// package.
+func LevelOf(l Logger) *AtomicLevel {
+	if l, ok := l.(leveled); ok {
+		return l.atomicLevel()
+	}
+	return nil
+}
+
+// SetLevel sets the level of the global logger and the loggers derived
+// from it.
+func SetLevel(level Level) {
+	if l := LevelOf(global); l != nil {
+		l.SetLevel(level)
+	}
+}
+
+// GetLevel returns the level of the global logger.
+func GetLevel() Level {
+	if l := LevelOf(global); l != nil {
+		return l.Level()
+	}
+	return InfoLevel
+}
+
+// ToggleDebugOnSignal switches level between DebugLevel and its current
+// level on every SIGHUP, or sigs, until the returned function is called.
+// A nil level toggles the level of the global logger.
+func ToggleDebugOnSignal(level *AtomicLevel, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		previous := InfoLevel
+		for {
+			select {
+			case <-ch:
+				l := level
+				if l == nil {
+					if l = LevelOf(global); l == nil {
+						continue
+					}
+				}
+				if current := l.Level(); current != DebugLevel {
+					previous = current
+					l.SetLevel(DebugLevel)
+				} else {
+					l.SetLevel(previous)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var closed atomic.Bool
+	return func() {
+		if closed.CompareAndSwap(false, true) {
+			signal.Stop(ch)
+			close(done)
+		}
+	}
+}
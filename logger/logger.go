@@ -7,9 +7,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
+
+	"new-milli/mask"
 )
 
 // Level represents the log level.
@@ -75,6 +76,16 @@ func F(key string, value interface{}) Field {
 	return Field{Key: key, Value: value}
 }
 
+// maskFields returns fields with the mask tags of their values applied, see
+// mask.Log.
+func maskFields(fields []Field) []Field {
+	masked := make([]Field, len(fields))
+	for i, f := range fields {
+		masked[i] = Field{Key: f.Key, Value: mask.Log(f.Value)}
+	}
+	return masked
+}
+
 // Logger is the interface for logging.
 type Logger interface {
 	// Debug logs a debug message.
@@ -126,6 +137,9 @@ type Logger interface {
 type Config struct {
 	// Level is the log level.
 	Level Level
+	// AtomicLevel is the level shared by the logger and the loggers derived
+	// from it, created from Level by New when nil.
+	AtomicLevel *AtomicLevel
 	// Output is the log output.
 	Output io.Writer
 	// Fields are the default fields.
@@ -140,6 +154,9 @@ type Config struct {
 	EnableTrace bool
 	// TimeFormat is the time format.
 	TimeFormat string
+	// Encoder encodes the entries, a TextEncoder using EnableColor and
+	// TimeFormat when nil.
+	Encoder Encoder
 	// CallerSkip is the number of stack frames to skip when getting caller information.
 	CallerSkip int
 	// ServiceName is the name of the service.
@@ -178,6 +195,11 @@ func New(config *Config) Logger {
 	if config == nil {
 		config = DefaultConfig()
 	}
+	c := *config
+	config = &c
+	if config.AtomicLevel == nil {
+		config.AtomicLevel = NewAtomicLevel(config.Level)
+	}
 
 	// 创建跟踪信息
 	traceInfo := NewTraceInfo()
@@ -250,10 +272,11 @@ func (l *logger) Fatalf(format string, args ...interface{}) {
 // WithFields returns a new logger with the given fields.
 func (l *logger) WithFields(fields ...Field) Logger {
 	config := *l.config
-	config.Fields = append(append([]Field{}, config.Fields...), fields...)
+	config.Fields = append(append([]Field{}, config.Fields...), maskFields(fields)...)
 	return &logger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -273,13 +296,16 @@ func (l *logger) WithContext(ctx context.Context) Logger {
 	return newLogger
 }
 
-// WithLevel returns a new logger with the given level.
+// WithLevel returns a new logger with the given level. Unlike the other
+// derived loggers, it doesn't share the level of l.
 func (l *logger) WithLevel(level Level) Logger {
 	config := *l.config
 	config.Level = level
+	config.AtomicLevel = NewAtomicLevel(level)
 	return &logger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -288,8 +314,9 @@ func (l *logger) WithOutput(output io.Writer) Logger {
 	config := *l.config
 	config.Output = output
 	return &logger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -298,8 +325,9 @@ func (l *logger) WithCaller(enabled bool) Logger {
 	config := *l.config
 	config.EnableCaller = enabled
 	return &logger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -308,8 +336,9 @@ func (l *logger) WithTime(enabled bool) Logger {
 	config := *l.config
 	config.EnableTime = enabled
 	return &logger{
-		config: &config,
-		ctx:    l.ctx,
+		config:    &config,
+		ctx:       l.ctx,
+		traceInfo: l.traceInfo,
 	}
 }
 
@@ -340,14 +369,10 @@ func (l *logger) WithServiceName(serviceName string) Logger {
 	config := *l.config
 	config.ServiceName = serviceName
 
-	// 更新跟踪信息
-	newTraceInfo := *l.traceInfo
-	newTraceInfo.WithServiceName(serviceName)
-
 	return &logger{
 		config:    &config,
 		ctx:       l.ctx,
-		traceInfo: &newTraceInfo,
+		traceInfo: withTraceInfo(l.traceInfo).WithServiceName(serviceName),
 	}
 }
 
@@ -356,14 +381,10 @@ func (l *logger) WithEnvironment(environment string) Logger {
 	config := *l.config
 	config.Environment = environment
 
-	// 更新跟踪信息
-	newTraceInfo := *l.traceInfo
-	newTraceInfo.WithEnvironment(environment)
-
 	return &logger{
 		config:    &config,
 		ctx:       l.ctx,
-		traceInfo: &newTraceInfo,
+		traceInfo: withTraceInfo(l.traceInfo).WithEnvironment(environment),
 	}
 }
 
@@ -376,95 +397,94 @@ func (l *logger) WithTraceInfo(traceInfo *TraceInfo) Logger {
 	}
 }
 
+// atomicLevel returns the level of the logger.
+func (l *logger) atomicLevel() *AtomicLevel {
+	return l.config.AtomicLevel
+}
+
+// enabled reports whether messages of level are logged.
+func (c *Config) enabled(level Level) bool {
+	if c.AtomicLevel != nil {
+		return c.AtomicLevel.Enabled(level)
+	}
+	return level >= c.Level
+}
+
+// withTraceInfo returns a copy of traceInfo to update, an empty one if nil.
+func withTraceInfo(traceInfo *TraceInfo) *TraceInfo {
+	if traceInfo == nil {
+		return &TraceInfo{CustomFields: make(map[string]string)}
+	}
+	return traceInfo.clone()
+}
+
 // log logs a message with the given level.
 func (l *logger) log(level Level, message string) {
-	if level < l.config.Level {
+	if !l.config.enabled(level) {
 		return
 	}
 
+	entry := &Entry{
+		Level:   level,
+		Message: message,
+	}
+	// The hooks are fired once the entry is written, without the lock
+	defer fireHooks(level, func() *HookEntry {
+		return &HookEntry{
+			Context:   l.ctx,
+			Time:      entry.Time,
+			Level:     level,
+			Caller:    entry.Caller,
+			Message:   message,
+			Fields:    l.config.Fields,
+			TraceInfo: l.traceInfo,
+		}
+	})
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	var builder strings.Builder
-
 	// Add time
 	if l.config.EnableTime {
-		timeStr := time.Now().Format(l.config.TimeFormat)
-		if l.config.EnableColor {
-			builder.WriteString("\033[90m")
-		}
-		builder.WriteString(timeStr)
-		if l.config.EnableColor {
-			builder.WriteString("\033[0m")
-		}
-		builder.WriteString(" ")
+		entry.Time = time.Now()
 	}
 
-	// Add level
-	if l.config.EnableColor {
-		builder.WriteString(level.Color())
-	}
-	builder.WriteString("[")
-	builder.WriteString(level.String())
-	builder.WriteString("]")
-	if l.config.EnableColor {
-		builder.WriteString("\033[0m")
-	}
-	builder.WriteString(" ")
-
 	// Add caller
 	if l.config.EnableCaller {
 		_, file, line, ok := runtime.Caller(l.config.CallerSkip)
 		if ok {
-			file = filepath.Base(file)
-			if l.config.EnableColor {
-				builder.WriteString("\033[90m")
-			}
-			builder.WriteString(file)
-			builder.WriteString(":")
-			builder.WriteString(fmt.Sprintf("%d", line))
-			if l.config.EnableColor {
-				builder.WriteString("\033[0m")
-			}
-			builder.WriteString(" ")
+			entry.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
 		}
 	}
 
-	// Add message
-	builder.WriteString(message)
-
 	// Add fields
-	fields := l.config.Fields
+	entry.Fields = l.config.Fields
 
 	// Add trace fields if enabled
 	if l.config.EnableTrace && l.traceInfo != nil {
 		traceFields := l.traceInfo.ToFields()
-		fields = append(fields, traceFields...)
+		entry.Fields = append(entry.Fields[:len(entry.Fields):len(entry.Fields)], traceFields...)
 	}
 
-	if len(fields) > 0 {
-		builder.WriteString(" ")
-		for i, field := range fields {
-			if i > 0 {
-				builder.WriteString(" ")
-			}
-			if l.config.EnableColor {
-				builder.WriteString("\033[36m")
-			}
-			builder.WriteString(field.Key)
-			builder.WriteString("=")
-			if l.config.EnableColor {
-				builder.WriteString("\033[0m")
-			}
-			builder.WriteString(fmt.Sprintf("%v", field.Value))
-		}
+	data, err := l.encoder().Encode(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode log entry: %v\n", err)
+		return
 	}
 
-	// Add newline
-	builder.WriteString("\n")
-
 	// Write to output
-	l.config.Output.Write([]byte(builder.String()))
+	l.config.Output.Write(data)
+}
+
+// encoder returns the encoder of the logger, a TextEncoder if not set.
+func (l *logger) encoder() Encoder {
+	if l.config.Encoder != nil {
+		return l.config.Encoder
+	}
+	return &TextEncoder{
+		TimeFormat: l.config.TimeFormat,
+		Color:      l.config.EnableColor,
+	}
 }
 
 // global is the global logger.
@@ -574,8 +594,3 @@ func WithServiceName(serviceName string) Logger {
 func WithEnvironment(environment string) Logger {
 	return global.WithEnvironment(environment)
 }
-
-// WithTraceInfo returns a new logger with the given trace information.
-func WithTraceInfo(traceInfo *TraceInfo) Logger {
-	return global.WithTraceInfo(traceInfo)
-}
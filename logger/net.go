@@ -0,0 +1,221 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NetWriter is a writer that ships logs to a TCP, UDP or Unix socket, or to
+// a syslog server. Write queues the entries, a background goroutine ships
+// them and reconnects when the connection fails.
+type NetWriter struct {
+	network string
+	addr    string
+	opts    *sinkOptions
+	format  func(e SinkEntry, stream bool) []byte
+	sink    *sink
+
+	// conn is only used by the goroutine of the sink
+	conn net.Conn
+}
+
+// NewNetWriter creates a writer shipping every entry as a line to addr,
+// e.g. NewNetWriter("tcp", "logstash:5000").
+func NewNetWriter(network, addr string, opts ...SinkOption) *NetWriter {
+	options := newSinkOptions(network+"://"+addr, 0, opts)
+	w := &NetWriter{
+		network: network,
+		addr:    addr,
+		opts:    options,
+		format:  formatLine,
+	}
+	w.sink = newSink(options, w.deliver)
+	return w
+}
+
+// NewSyslogWriter creates a writer shipping the entries as RFC 5424 syslog
+// messages to addr, e.g. NewSyslogWriter("udp", "localhost:514"). The
+// severity is detected from the level of the entries. TCP messages are
+// framed by octet counting (RFC 6587).
+func NewSyslogWriter(network, addr string, opts ...SinkOption) *NetWriter {
+	options := newSinkOptions("syslog+"+network+"://"+addr, 0, opts)
+	if options.hostname == "" {
+		options.hostname, _ = os.Hostname()
+	}
+	if options.appName == "" {
+		options.appName = filepath.Base(os.Args[0])
+	}
+
+	w := &NetWriter{
+		network: network,
+		addr:    addr,
+		opts:    options,
+	}
+	w.format = w.formatSyslog
+	w.sink = newSink(options, w.deliver)
+	return w
+}
+
+// WithSyslogFacility sets the syslog facility, 1 (user) by default, e.g. 16
+// to 23 for local0 to local7.
+func WithSyslogFacility(facility int) SinkOption {
+	return func(o *sinkOptions) {
+		o.facility = facility
+	}
+}
+
+// WithSyslogAppName sets the APP-NAME of the syslog messages, the name of
+// the executable by default.
+func WithSyslogAppName(name string) SinkOption {
+	return func(o *sinkOptions) {
+		o.appName = name
+	}
+}
+
+// WithSyslogHostname sets the HOSTNAME of the syslog messages, the hostname
+// by default.
+func WithSyslogHostname(hostname string) SinkOption {
+	return func(o *sinkOptions) {
+		o.hostname = hostname
+	}
+}
+
+// Write queues p for shipping. It doesn't block, p is dropped if the queue
+// is full.
+func (w *NetWriter) Write(p []byte) (int, error) {
+	return w.sink.write(p)
+}
+
+// Close ships the queued entries and closes the connection.
+func (w *NetWriter) Close() error {
+	err := w.sink.close()
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// deliver writes a batch, connecting first if needed. The connection is
+// dropped on failure so the next attempt reconnects.
+func (w *NetWriter) deliver(batch []SinkEntry) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.addr, w.opts.timeout)
+		if err != nil {
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		w.conn = conn
+	}
+
+	if w.opts.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.opts.timeout))
+	}
+
+	var err error
+	if stream := isStream(w.network); stream {
+		var buf []byte
+		for _, e := range batch {
+			buf = append(buf, w.format(e, true)...)
+		}
+		_, err = w.conn.Write(buf)
+	} else {
+		// One datagram per entry
+		for _, e := range batch {
+			if _, err = w.conn.Write(w.format(e, false)); err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return fmt.Errorf("failed to write: %w", err)
+	}
+
+	return nil
+}
+
+// isStream reports whether network is connection oriented.
+func isStream(network string) bool {
+	return strings.HasPrefix(network, "tcp") || network == "unix"
+}
+
+// formatLine formats an entry as a line on streams.
+func formatLine(e SinkEntry, stream bool) []byte {
+	if !stream {
+		return bytes.TrimRight(e.Data, "\n")
+	}
+	if bytes.HasSuffix(e.Data, []byte("\n")) {
+		return e.Data
+	}
+	return append(e.Data, '\n')
+}
+
+// ansi matches the ANSI color codes of the text encoder.
+var ansi = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// formatSyslog formats an entry as an RFC 5424 message, e.g.
+// <14>1 2024-01-02T15:04:05.000000Z host app 42 - - [INFO] started
+func (w *NetWriter) formatSyslog(e SinkEntry, stream bool) []byte {
+	pri := w.opts.facility*8 + syslogSeverity(detectLevel(e.Data))
+
+	msg := fmt.Appendf(nil, "<%d>1 %s %s %s %d - - ",
+		pri,
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeader(w.opts.hostname, 255),
+		syslogHeader(w.opts.appName, 48),
+		os.Getpid(),
+	)
+	msg = append(msg, bytes.TrimRight(ansi.ReplaceAll(e.Data, nil), "\n")...)
+
+	if stream {
+		return append(strconv.AppendInt(nil, int64(len(msg)), 10), append([]byte{' '}, msg...)...)
+	}
+	return msg
+}
+
+// syslogHeader returns a header field of at most n printable characters,
+// "-" if empty.
+func syslogHeader(s string, n int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > n {
+		s = s[:n]
+	}
+	return s
+}
+
+// detectLevel returns the level of an entry encoded by the encoders of this
+// package or the JSONLogger, InfoLevel if not found.
+func detectLevel(data []byte) Level {
+	head := data
+	if len(head) > 64 {
+		head = head[:64]
+	}
+
+	for _, level := range []Level{FatalLevel, ErrorLevel, WarnLevel, DebugLevel, InfoLevel} {
+		name := level.String()
+		if bytes.Contains(head, []byte("["+name+"]")) ||
+			bytes.Contains(data, []byte("level="+strings.ToLower(name))) ||
+			bytes.Contains(data, []byte(`"level":"`+name+`"`)) {
+			return level
+		}
+	}
+
+	return InfoLevel
+}
@@ -0,0 +1,301 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/batcher"
+	"new-milli/collector"
+)
+
+// ErrWriterClosed is returned when writing to a closed remote writer.
+var ErrWriterClosed = errors.New("log writer closed")
+
+// SinkEntry is a log entry queued for shipping.
+type SinkEntry struct {
+	// Time is the time the entry was written.
+	Time time.Time
+	// Data is the encoded entry.
+	Data []byte
+}
+
+// sinkMetrics is the metrics of the remote writers.
+type sinkMetrics struct {
+	entries *prometheus.CounterVec
+	retries *prometheus.CounterVec
+	queued  *prometheus.GaugeVec
+}
+
+// newSinkMetrics creates the log sink metrics registered with registry,
+// reusing the registered ones. They aren't registered when registry is nil,
+// a registration failure is passed to errorHandler.
+func newSinkMetrics(registry prometheus.Registerer, errorHandler func(error)) *sinkMetrics {
+	m := &sinkMetrics{
+		entries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "log_sink",
+			Name:      "entries_total",
+			Help:      "Number of log entries by sink and result (sent, failed or dropped).",
+		}, []string{"sink", "result"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "log_sink",
+			Name:      "retries_total",
+			Help:      "Number of retried deliveries by sink.",
+		}, []string{"sink"}),
+		queued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "log_sink",
+			Name:      "queue_length",
+			Help:      "Number of log entries waiting to be shipped by sink.",
+		}, []string{"sink"}),
+	}
+	if registry != nil {
+		var errs [3]error
+		m.entries, errs[0] = collector.Register(registry, m.entries)
+		m.retries, errs[1] = collector.Register(registry, m.retries)
+		m.queued, errs[2] = collector.Register(registry, m.queued)
+		if err := errors.Join(errs[:]...); err != nil && errorHandler != nil {
+			errorHandler(fmt.Errorf("failed to register log sink metrics: %w", err))
+		}
+	}
+	return m
+}
+
+// SinkOption is a function that configures a remote writer.
+type SinkOption func(*sinkOptions)
+
+// sinkOptions is the options of the remote writers.
+type sinkOptions struct {
+	name          string
+	queueSize     int
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+	timeout       time.Duration
+	errorHandler  func(error)
+	registry      prometheus.Registerer
+
+	// syslog
+	facility int
+	appName  string
+	hostname string
+
+	// http
+	client   *http.Client
+	header   http.Header
+	encoding HTTPEncoding
+}
+
+// newSinkOptions returns the options with the defaults of a remote writer.
+func newSinkOptions(name string, flushInterval time.Duration, opts []SinkOption) *sinkOptions {
+	options := &sinkOptions{
+		name:          name,
+		queueSize:     1024,
+		batchSize:     100,
+		flushInterval: flushInterval,
+		maxRetries:    3,
+		retryBackoff:  100 * time.Millisecond,
+		timeout:       5 * time.Second,
+		errorHandler: func(err error) {
+			fmt.Fprintln(os.Stderr, err)
+		},
+		registry: prometheus.DefaultRegisterer,
+		facility: 1,
+		client:   http.DefaultClient,
+		header:   make(http.Header),
+		encoding: NDJSONEncoding,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.queueSize <= 0 {
+		options.queueSize = 1
+	}
+	if options.batchSize <= 0 {
+		options.batchSize = 1
+	}
+	return options
+}
+
+// WithSinkName sets the name of the writer in the metrics, the address by
+// default.
+func WithSinkName(name string) SinkOption {
+	return func(o *sinkOptions) {
+		o.name = name
+	}
+}
+
+// WithQueueSize sets the number of entries waiting to be shipped, 1024 by
+// default. Entries written while the queue is full are dropped.
+func WithQueueSize(size int) SinkOption {
+	return func(o *sinkOptions) {
+		o.queueSize = size
+	}
+}
+
+// WithBatchSize sets the maximum number of entries shipped at once, 100 by
+// default.
+func WithBatchSize(size int) SinkOption {
+	return func(o *sinkOptions) {
+		o.batchSize = size
+	}
+}
+
+// WithFlushInterval sets how long entries wait for more before being
+// shipped. TCP, UDP and syslog writers ship what is queued right away
+// by default, HTTP writers wait 1s.
+func WithFlushInterval(interval time.Duration) SinkOption {
+	return func(o *sinkOptions) {
+		o.flushInterval = interval
+	}
+}
+
+// WithRetry sets the number of retries of a failed batch, 3 by default, and
+// the backoff before the first retry, 100ms by default and doubled after
+// every retry. The queue fills up while a batch is retried.
+func WithRetry(maxRetries int, backoff time.Duration) SinkOption {
+	return func(o *sinkOptions) {
+		o.maxRetries = maxRetries
+		o.retryBackoff = backoff
+	}
+}
+
+// WithSinkTimeout sets the timeout of connecting and shipping a batch, 5s
+// by default. Close waits as long for the queue to be shipped.
+func WithSinkTimeout(timeout time.Duration) SinkOption {
+	return func(o *sinkOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithSinkErrorHandler sets the handler of the batches that failed after all
+// retries. The errors are printed to stderr by default.
+func WithSinkErrorHandler(handler func(error)) SinkOption {
+	return func(o *sinkOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// WithSinkRegistry sets the registry of the writer metrics,
+// prometheus.DefaultRegisterer by default, nil disables them.
+func WithSinkRegistry(registry prometheus.Registerer) SinkOption {
+	return func(o *sinkOptions) {
+		o.registry = registry
+	}
+}
+
+// sink ships the written entries in the background.
+type sink struct {
+	opts    *sinkOptions
+	metrics *sinkMetrics
+	deliver func(batch []SinkEntry) error
+	batcher *batcher.Batcher[SinkEntry]
+
+	abortOnce sync.Once
+	abort     chan struct{}
+}
+
+// newSink creates a sink shipping the entries with deliver and starts it.
+func newSink(opts *sinkOptions, deliver func(batch []SinkEntry) error) *sink {
+	s := &sink{
+		opts:    opts,
+		metrics: newSinkMetrics(opts.registry, opts.errorHandler),
+		deliver: deliver,
+		abort:   make(chan struct{}),
+	}
+	s.batcher = batcher.New(s.flush,
+		batcher.WithSize(opts.batchSize),
+		batcher.WithInterval(opts.flushInterval),
+		batcher.WithQueueSize(opts.queueSize),
+		batcher.WithErrorHandler(nil),
+	)
+	return s
+}
+
+// write queues a copy of p, dropping it if the queue is full.
+func (s *sink) write(p []byte) (int, error) {
+	entry := SinkEntry{Time: time.Now(), Data: append([]byte(nil), p...)}
+	switch err := s.batcher.TryAdd(entry); {
+	case err == nil:
+		s.metrics.queued.WithLabelValues(s.opts.name).Inc()
+	case errors.Is(err, batcher.ErrFull):
+		s.metrics.entries.WithLabelValues(s.opts.name, "dropped").Inc()
+	default:
+		return 0, ErrWriterClosed
+	}
+	return len(p), nil
+}
+
+// close stops the sink after shipping the queued entries, giving up on them
+// after the timeout.
+func (s *sink) close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.timeout)
+	defer cancel()
+	if err := s.batcher.Close(ctx); err == nil {
+		return nil
+	}
+
+	// Stop retrying and wait for the batch being shipped
+	s.abortOnce.Do(func() { close(s.abort) })
+	_ = s.batcher.Close(context.Background())
+	return fmt.Errorf("failed to ship the queued log entries to %s before the timeout", s.opts.name)
+}
+
+// flush ships a batch collected by the batcher, the failures are handled by
+// send.
+func (s *sink) flush(_ context.Context, batch []SinkEntry) error {
+	s.dequeued(len(batch))
+	s.send(batch)
+	return nil
+}
+
+// send ships a batch, retrying with backoff.
+func (s *sink) send(batch []SinkEntry) {
+	backoff := s.opts.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := s.deliver(batch)
+		if err == nil {
+			s.metrics.entries.WithLabelValues(s.opts.name, "sent").Add(float64(len(batch)))
+			return
+		}
+
+		if attempt < s.opts.maxRetries && s.wait(backoff) {
+			s.metrics.retries.WithLabelValues(s.opts.name).Inc()
+			backoff = min(2*backoff, 30*time.Second)
+			continue
+		}
+
+		s.metrics.entries.WithLabelValues(s.opts.name, "failed").Add(float64(len(batch)))
+		if s.opts.errorHandler != nil {
+			s.opts.errorHandler(fmt.Errorf("failed to ship %d log entries to %s: %w", len(batch), s.opts.name, err))
+		}
+		return
+	}
+}
+
+// wait waits for d, reporting false if the sink is aborted meanwhile.
+func (s *sink) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.abort:
+		return false
+	}
+}
+
+// dequeued updates the queue length.
+func (s *sink) dequeued(n int) {
+	s.metrics.queued.WithLabelValues(s.opts.name).Sub(float64(n))
+}
@@ -138,6 +138,27 @@ func (t *TraceInfo) NewChildSpan() *TraceInfo {
 	return child
 }
 
+// clone 复制跟踪信息
+func (t *TraceInfo) clone() *TraceInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	c := &TraceInfo{
+		RequestID:    t.RequestID,
+		TraceID:      t.TraceID,
+		SpanID:       t.SpanID,
+		ParentSpanID: t.ParentSpanID,
+		ServiceName:  t.ServiceName,
+		Environment:  t.Environment,
+		CustomFields: make(map[string]string, len(t.CustomFields)),
+	}
+	for k, v := range t.CustomFields {
+		c.CustomFields[k] = v
+	}
+
+	return c
+}
+
 // ToFields 将跟踪信息转换为日志字段
 func (t *TraceInfo) ToFields() []Field {
 	t.mu.RLock()
@@ -231,6 +252,16 @@ func TraceInfoFromContext(ctx context.Context) *TraceInfo {
 	return NewTraceInfo()
 }
 
+// LookupTraceInfo returns the trace information of ctx, false when it has
+// none, unlike TraceInfoFromContext which then creates one.
+func LookupTraceInfo(ctx context.Context) (*TraceInfo, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	traceInfo, ok := ctx.Value(traceKey).(*TraceInfo)
+	return traceInfo, ok && traceInfo != nil
+}
+
 // WithTraceContext 创建一个带有跟踪信息的上下文
 func WithTraceContext(ctx context.Context) context.Context {
 	if ctx == nil {
@@ -0,0 +1,120 @@
+package mask
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces the values masked entirely.
+const Placeholder = "***"
+
+// Masker masks a value.
+type Masker func(s string) string
+
+// Rules of the built-in maskers, the values of the mask struct tag.
+const (
+	RuleEmail = "email"
+	RulePhone = "phone"
+	RuleCard  = "card"
+	RuleFull  = "full"
+)
+
+// rules is the maskers by rule name.
+var rules = struct {
+	sync.RWMutex
+	m map[string]Masker
+}{m: map[string]Masker{
+	RuleEmail: Email,
+	RulePhone: Phone,
+	RuleCard:  Card,
+	RuleFull:  Full,
+}}
+
+// Register adds a rule usable in the mask struct tags, replacing the one
+// with the same name, e.g. a regular expression:
+//
+//	mask.Register("iban", mask.Pattern(regexp.MustCompile(`[A-Z]{2}\d{2}[A-Z0-9]{11,30}`)))
+func Register(rule string, m Masker) {
+	rules.Lock()
+	defer rules.Unlock()
+	rules.m[rule] = m
+}
+
+// lookup returns the masker of a rule, Full for unknown rules so a typo
+// doesn't leak the value.
+func lookup(rule string) Masker {
+	rules.RLock()
+	defer rules.RUnlock()
+	if m, ok := rules.m[rule]; ok {
+		return m
+	}
+	return Full
+}
+
+// String masks s with the masker of rule.
+func String(rule, s string) string {
+	return lookup(rule)(s)
+}
+
+// Full masks the whole value.
+func Full(s string) string {
+	if s == "" {
+		return ""
+	}
+	return Placeholder
+}
+
+// Email keeps the first character of the local part and the domain of an
+// email address, e.g. "j***@example.com".
+func Email(s string) string {
+	at := strings.LastIndexByte(s, '@')
+	if at <= 0 {
+		return Full(s)
+	}
+	return s[:1] + Placeholder + s[at:]
+}
+
+// Phone keeps the last 4 digits of a phone number and its formatting, e.g.
+// "+* ***-***-1234".
+func Phone(s string) string {
+	return keepLastDigits(s, 4)
+}
+
+// Card keeps the last 4 digits of a card number and its formatting, e.g.
+// "**** **** **** 1111".
+func Card(s string) string {
+	return keepLastDigits(s, 4)
+}
+
+// keepLastDigits replaces the digits of s but the last n with '*', s is
+// masked entirely when it has n digits or less.
+func keepLastDigits(s string, n int) string {
+	digits := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			digits++
+		}
+	}
+	if digits <= n {
+		return Full(s)
+	}
+
+	b := []byte(s)
+	for i := range b {
+		if b[i] >= '0' && b[i] <= '9' {
+			if digits > n {
+				b[i] = '*'
+			}
+			digits--
+		}
+	}
+	return string(b)
+}
+
+// Pattern returns a masker replacing the matches of re with Placeholder.
+func Pattern(re *regexp.Regexp) Masker {
+	return func(s string) string {
+		return re.ReplaceAllString(s, Placeholder)
+	}
+}
@@ -0,0 +1,251 @@
+package mask
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Scope is where a value is written.
+type Scope int
+
+// Scopes of the masking.
+const (
+	// ScopeLog is the logs: the logger fields and the bodies captured by
+	// the logging middleware.
+	ScopeLog Scope = iota
+	// ScopeResponse is the responses written by the HTTP transport.
+	ScopeResponse
+)
+
+// maxDepth bounds the walk of the values, e.g. against pointer cycles.
+const maxDepth = 32
+
+// Log returns v with its tagged fields masked for the logs, see Apply.
+func Log(v interface{}) interface{} {
+	return Apply(v, ScopeLog)
+}
+
+// Response returns v with its tagged fields masked for the responses, see
+// Apply.
+func Response(v interface{}) interface{} {
+	return Apply(v, ScopeResponse)
+}
+
+// Apply returns a copy of v with the struct fields tagged with mask masked
+// for scope, at any depth. The tag is the rule of the field, optionally
+// restricted to a scope:
+//
+//	type User struct {
+//		Email string `json:"email" mask:"email"`
+//		Phone string `json:"phone" mask:"phone,log"` // masked in the logs only
+//		Token string `json:"token" mask:"full,response"`
+//	}
+//
+// String, *string and []string fields are masked with the masker of the
+// rule, fields of other types are zeroed. v itself is returned when it has
+// nothing to mask, it is never modified.
+func Apply(v interface{}, scope Scope) interface{} {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if !maskable(rv.Type()) {
+		return v
+	}
+	if out, ok := walk(rv, scope, 0); ok {
+		return out.Interface()
+	}
+	return v
+}
+
+// walk returns a copy of v with its tagged fields masked, and whether
+// anything was masked. v is returned as is otherwise.
+func walk(v reflect.Value, scope Scope, depth int) (reflect.Value, bool) {
+	if depth > maxDepth {
+		return v, false
+	}
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, false
+		}
+		inner, ok := walk(v.Elem(), scope, depth+1)
+		if !ok {
+			return v, false
+		}
+		out := reflect.New(t).Elem()
+		out.Set(inner)
+		return out, true
+
+	case reflect.Ptr:
+		if v.IsNil() || !maskable(t.Elem()) {
+			return v, false
+		}
+		inner, ok := walk(v.Elem(), scope, depth+1)
+		if !ok {
+			return v, false
+		}
+		out := reflect.New(t.Elem())
+		out.Elem().Set(inner)
+		return out, true
+
+	case reflect.Struct:
+		if !maskable(t) {
+			return v, false
+		}
+		var out reflect.Value
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			var (
+				masked reflect.Value
+				ok     bool
+			)
+			if rule, tagged := ruleOf(f, scope); tagged {
+				masked, ok = maskField(v.Field(i), rule)
+			} else {
+				masked, ok = walk(v.Field(i), scope, depth+1)
+			}
+			if !ok {
+				continue
+			}
+			if !out.IsValid() {
+				out = reflect.New(t).Elem()
+				out.Set(v)
+			}
+			out.Field(i).Set(masked)
+		}
+		return out, out.IsValid()
+
+	case reflect.Slice, reflect.Array:
+		if (t.Kind() == reflect.Slice && v.IsNil()) || !maskable(t.Elem()) {
+			return v, false
+		}
+		var out reflect.Value
+		for i := 0; i < v.Len(); i++ {
+			masked, ok := walk(v.Index(i), scope, depth+1)
+			if !ok {
+				continue
+			}
+			if !out.IsValid() {
+				if t.Kind() == reflect.Slice {
+					out = reflect.MakeSlice(t, v.Len(), v.Len())
+					reflect.Copy(out, v)
+				} else {
+					out = reflect.New(t).Elem()
+					out.Set(v)
+				}
+			}
+			out.Index(i).Set(masked)
+		}
+		return out, out.IsValid()
+
+	case reflect.Map:
+		if v.IsNil() || !maskable(t.Elem()) {
+			return v, false
+		}
+		var out reflect.Value
+		iter := v.MapRange()
+		for iter.Next() {
+			masked, ok := walk(iter.Value(), scope, depth+1)
+			if !ok {
+				continue
+			}
+			if !out.IsValid() {
+				out = reflect.MakeMapWithSize(t, v.Len())
+				for _, k := range v.MapKeys() {
+					out.SetMapIndex(k, v.MapIndex(k))
+				}
+			}
+			out.SetMapIndex(iter.Key(), masked)
+		}
+		return out, out.IsValid()
+	}
+	return v, false
+}
+
+// ruleOf returns the rule of a struct field for scope, and whether the
+// field is masked in scope.
+func ruleOf(f reflect.StructField, scope Scope) (string, bool) {
+	tag, ok := f.Tag.Lookup("mask")
+	if !ok {
+		return "", false
+	}
+	rule, restriction, _ := strings.Cut(tag, ",")
+	switch restriction {
+	case "log":
+		return rule, scope == ScopeLog
+	case "response":
+		return rule, scope == ScopeResponse
+	}
+	return rule, true
+}
+
+// maskField returns the masked value of a tagged field.
+func maskField(v reflect.Value, rule string) (reflect.Value, bool) {
+	if v.IsZero() {
+		return v, false
+	}
+	t := v.Type()
+	m := lookup(rule)
+	switch {
+	case t.Kind() == reflect.String:
+		return reflect.ValueOf(m(v.String())).Convert(t), true
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.String:
+		out := reflect.New(t.Elem())
+		out.Elem().Set(reflect.ValueOf(m(v.Elem().String())).Convert(t.Elem()))
+		return out, true
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		out := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(reflect.ValueOf(m(v.Index(i).String())).Convert(t.Elem()))
+		}
+		return out, true
+	}
+	return reflect.Zero(t), true
+}
+
+// types caches whether the values of a type may have tagged fields.
+var types sync.Map
+
+// maskable reports whether the values of t may have tagged fields, which
+// is the case of the interfaces.
+func maskable(t reflect.Type) bool {
+	if m, ok := types.Load(t); ok {
+		return m.(bool)
+	}
+	m := inspect(t, make(map[reflect.Type]bool))
+	types.Store(t, m)
+	return m
+}
+
+// inspect reports whether the values of t may have tagged fields, seen
+// holding the types being inspected.
+func inspect(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return inspect(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if _, ok := f.Tag.Lookup("mask"); ok || inspect(f.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+var (
+	// ErrMissingToken is returned when the request carries no bearer token.
+	ErrMissingToken = transport.NewStatusError(http.StatusUnauthorized, "", "missing bearer token")
+	// ErrInvalidToken is returned when the token fails validation.
+	ErrInvalidToken = transport.NewStatusError(http.StatusUnauthorized, "", "invalid token")
+	// ErrMissingKey is returned when no key is configured to verify tokens.
+	ErrMissingKey = errors.New("no verification key configured")
+)
+
+// HeaderAuthorization is the header carrying the bearer token.
+const HeaderAuthorization = "Authorization"
+
+// Claims are the claims of a validated token.
+type Claims = jwtv5.MapClaims
+
+// Validator checks the claims of a token after its signature and registered
+// claims were verified, e.g. to require a scope or a tenant.
+type Validator func(ctx context.Context, claims Claims) error
+
+// Option is JWT option.
+type Option func(*options)
+
+// options is JWT options.
+type options struct {
+	disabled   bool
+	secret     []byte
+	publicKey  crypto.PublicKey
+	jwks       *JWKS
+	algorithms []string
+	audience   string
+	issuer     string
+	leeway     time.Duration
+	validators []Validator
+	skip       func(ctx context.Context) bool
+}
+
+// WithDisabled returns an Option that disables authentication.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithSecret returns an Option that verifies HS256/HS384/HS512 tokens with secret.
+func WithSecret(secret []byte) Option {
+	return func(o *options) {
+		o.secret = secret
+	}
+}
+
+// WithPublicKey returns an Option that verifies RS*, PS* or ES* tokens with key,
+// an *rsa.PublicKey or *ecdsa.PublicKey.
+func WithPublicKey(key crypto.PublicKey) Option {
+	return func(o *options) {
+		o.publicKey = key
+	}
+}
+
+// WithJWKS returns an Option that verifies tokens with the key set matching their "kid".
+func WithJWKS(jwks *JWKS) Option {
+	return func(o *options) {
+		o.jwks = jwks
+	}
+}
+
+// WithAlgorithms returns an Option that restricts the accepted signing algorithms.
+func WithAlgorithms(algorithms ...string) Option {
+	return func(o *options) {
+		o.algorithms = algorithms
+	}
+}
+
+// WithAudience returns an Option that requires the "aud" claim to contain audience.
+func WithAudience(audience string) Option {
+	return func(o *options) {
+		o.audience = audience
+	}
+}
+
+// WithIssuer returns an Option that requires the "iss" claim to be issuer.
+func WithIssuer(issuer string) Option {
+	return func(o *options) {
+		o.issuer = issuer
+	}
+}
+
+// WithLeeway returns an Option that sets the clock skew tolerated on "exp", "nbf" and "iat".
+func WithLeeway(leeway time.Duration) Option {
+	return func(o *options) {
+		o.leeway = leeway
+	}
+}
+
+// WithValidator returns an Option that adds a claims validator.
+func WithValidator(v Validator) Option {
+	return func(o *options) {
+		o.validators = append(o.validators, v)
+	}
+}
+
+// WithSkip returns an Option that lets requests matching fn through unauthenticated,
+// e.g. health checks.
+func WithSkip(fn func(ctx context.Context) bool) Option {
+	return func(o *options) {
+		o.skip = fn
+	}
+}
+
+type claimsKey struct{}
+
+// NewContext returns a new Context that carries the claims.
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims stored in ctx, if any.
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// Subject returns the "sub" claim of the token in ctx.
+func Subject(ctx context.Context) string {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	sub, _ := claims.GetSubject()
+	return sub
+}
+
+// Scopes returns the scopes of the token in ctx, read from the space separated
+// "scope" claim or the "scp" list. It suits policy.WithScopeFunc.
+func Scopes(ctx context.Context) []string {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	switch scp := claims["scp"].(type) {
+	case string:
+		return strings.Fields(scp)
+	case []interface{}:
+		scopes := make([]string, 0, len(scp))
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
+
+// Server returns a middleware that validates the bearer token of requests and
+// stores its claims in the context.
+func Server(opts ...Option) middleware.Middleware {
+	cfg := options{
+		leeway: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	algorithms := cfg.algorithms
+	if len(algorithms) == 0 {
+		if cfg.secret != nil {
+			algorithms = append(algorithms, "HS256", "HS384", "HS512")
+		}
+		if cfg.publicKey != nil || cfg.jwks != nil {
+			algorithms = append(algorithms, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+		}
+	}
+	parserOpts := []jwtv5.ParserOption{
+		jwtv5.WithValidMethods(algorithms),
+		jwtv5.WithLeeway(cfg.leeway),
+		jwtv5.WithExpirationRequired(),
+	}
+	if cfg.audience != "" {
+		parserOpts = append(parserOpts, jwtv5.WithAudience(cfg.audience))
+	}
+	if cfg.issuer != "" {
+		parserOpts = append(parserOpts, jwtv5.WithIssuer(cfg.issuer))
+	}
+	parser := jwtv5.NewParser(parserOpts...)
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			if cfg.skip != nil && cfg.skip(ctx) {
+				return handler(ctx, req)
+			}
+
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return nil, ErrMissingToken
+			}
+			raw, ok := bearerToken(tr.RequestHeader())
+			if !ok {
+				return nil, ErrMissingToken
+			}
+
+			claims := Claims{}
+			if _, err := parser.ParseWithClaims(raw, claims, cfg.keyFunc(ctx)); err != nil {
+				middleware.Log(ctx).Debugf("Rejected token for %s: %v", tr.Operation(), err)
+				return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+			}
+			for _, validate := range cfg.validators {
+				if err := validate(ctx, claims); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+				}
+			}
+
+			return handler(NewContext(ctx, claims), req)
+		}
+	}
+}
+
+// keyFunc returns the key verifying a token.
+func (o *options) keyFunc(ctx context.Context) jwtv5.Keyfunc {
+	return func(token *jwtv5.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwtv5.SigningMethodHMAC:
+			if o.secret == nil {
+				return nil, ErrMissingKey
+			}
+			return o.secret, nil
+		}
+		if o.jwks != nil {
+			kid, _ := token.Header["kid"].(string)
+			return o.jwks.Key(ctx, kid)
+		}
+		if o.publicKey != nil {
+			return o.publicKey, nil
+		}
+		return nil, ErrMissingKey
+	}
+}
+
+// bearerToken returns the bearer token of a request header.
+func bearerToken(header transport.Header) (string, bool) {
+	value := header.Get(HeaderAuthorization)
+	if value == "" {
+		// gRPC metadata keys are lower case
+		value = header.Get(strings.ToLower(HeaderAuthorization))
+	}
+	scheme, token, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// TokenSource supplies the tokens attached by the client middleware.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+// Token implements TokenSource.
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a TokenSource always supplying token.
+func StaticToken(token string) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// Client returns a middleware that attaches a bearer token from source to requests.
+func Client(source TokenSource) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			token, err := source.Token(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get token: %w", err)
+			}
+			if tr, ok := transport.FromClientContext(ctx); ok && token != "" {
+				tr.RequestHeader().Set(HeaderAuthorization, "Bearer "+token)
+			}
+			return handler(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package authz
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"new-milli/middleware"
+	"new-milli/middleware/auth/jwt"
+	"new-milli/transport"
+)
+
+var (
+	// ErrUnauthenticated is returned when the request carries no subject.
+	ErrUnauthenticated = transport.NewStatusError(http.StatusUnauthorized, "", "unauthenticated")
+	// ErrForbidden is returned when the subject isn't allowed to perform the operation.
+	ErrForbidden = transport.NewStatusError(http.StatusForbidden, "", "forbidden")
+)
+
+// ActionCall is the action of requests whose transport has no method, e.g. gRPC calls.
+const ActionCall = "call"
+
+// Subject is the caller of an operation.
+type Subject struct {
+	// ID identifies the caller, e.g. the "sub" claim.
+	ID string
+	// Roles are the roles granted to the caller by the token.
+	Roles []string
+	// Claims are the claims of the token, if any.
+	Claims map[string]interface{}
+}
+
+// Request is an authorization request.
+type Request struct {
+	Subject Subject
+	// Operation is the transport operation, e.g. the HTTP path.
+	Operation string
+	// Action is the HTTP method, or ActionCall for other transports.
+	Action string
+}
+
+// Engine decides whether requests are allowed.
+type Engine interface {
+	Authorize(ctx context.Context, req *Request) (bool, error)
+}
+
+// EngineFunc adapts a function to an Engine.
+type EngineFunc func(ctx context.Context, req *Request) (bool, error)
+
+// Authorize implements Engine.
+func (f EngineFunc) Authorize(ctx context.Context, req *Request) (bool, error) {
+	return f(ctx, req)
+}
+
+// Option is authorization option.
+type Option func(*options)
+
+// options is authorization options.
+type options struct {
+	disabled    bool
+	engine      Engine
+	subjectFunc func(ctx context.Context) (Subject, bool)
+	skip        func(ctx context.Context) bool
+}
+
+// WithDisabled returns an Option that disables authorization.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithEngine returns an Option that sets the policy engine.
+func WithEngine(engine Engine) Option {
+	return func(o *options) {
+		o.engine = engine
+	}
+}
+
+// WithSubjectFunc returns an Option that sets the function returning the subject of a request.
+func WithSubjectFunc(fn func(ctx context.Context) (Subject, bool)) Option {
+	return func(o *options) {
+		o.subjectFunc = fn
+	}
+}
+
+// WithSkip returns an Option that lets requests matching fn through unchecked.
+func WithSkip(fn func(ctx context.Context) bool) Option {
+	return func(o *options) {
+		o.skip = fn
+	}
+}
+
+// SubjectFromClaims returns a subject function reading the JWT claims stored
+// by the auth middleware, with the roles taken from the rolesClaim claim,
+// either a list or a space separated string.
+func SubjectFromClaims(rolesClaim string) func(ctx context.Context) (Subject, bool) {
+	return func(ctx context.Context) (Subject, bool) {
+		claims, ok := jwt.FromContext(ctx)
+		if !ok {
+			return Subject{}, false
+		}
+		sub, _ := claims.GetSubject()
+		s := Subject{ID: sub, Claims: claims}
+		switch roles := claims[rolesClaim].(type) {
+		case string:
+			s.Roles = strings.Fields(roles)
+		case []interface{}:
+			for _, r := range roles {
+				if r, ok := r.(string); ok {
+					s.Roles = append(s.Roles, r)
+				}
+			}
+		}
+		return s, true
+	}
+}
+
+type subjectKey struct{}
+
+// NewContext returns a new Context that carries the subject.
+func NewContext(ctx context.Context, s Subject) context.Context {
+	return context.WithValue(ctx, subjectKey{}, s)
+}
+
+// FromContext returns the authorized subject stored in ctx, if any.
+func FromContext(ctx context.Context) (Subject, bool) {
+	s, ok := ctx.Value(subjectKey{}).(Subject)
+	return s, ok
+}
+
+// Server returns a middleware that checks the caller is allowed to perform
+// the operation and stores the subject in the context.
+func Server(opts ...Option) middleware.Middleware {
+	cfg := options{
+		subjectFunc: SubjectFromClaims("roles"),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled || cfg.engine == nil {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			if cfg.skip != nil && cfg.skip(ctx) {
+				return handler(ctx, req)
+			}
+
+			subject, ok := cfg.subjectFunc(ctx)
+			if !ok {
+				return nil, ErrUnauthenticated
+			}
+
+			r := &Request{Subject: subject, Action: ActionCall}
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				r.Operation = tr.Operation()
+				if m, ok := tr.(interface{ Method() string }); ok && m.Method() != "" {
+					r.Action = m.Method()
+				}
+			}
+
+			allowed, err := cfg.engine.Authorize(ctx, r)
+			if err != nil {
+				middleware.Log(ctx).Errorf("authz: failed to authorize %s %s for %s: %v", r.Action, r.Operation, subject.ID, err)
+				return nil, ErrForbidden
+			}
+			if !allowed {
+				middleware.Log(ctx).Debugf("authz: denied %s %s for %s", r.Action, r.Operation, subject.ID)
+				return nil, ErrForbidden
+			}
+
+			return handler(NewContext(ctx, subject), req)
+		}
+	}
+}
+
+// permission is an operation pattern and the actions allowed on it.
+type permission struct {
+	pattern string
+	actions []string
+}
+
+// allows reports whether the permission covers the request.
+func (p *permission) allows(operation, action string) bool {
+	if ok, _ := path.Match(p.pattern, operation); !ok {
+		return false
+	}
+	if len(p.actions) == 0 {
+		return true
+	}
+	for _, a := range p.actions {
+		if a == "*" || strings.EqualFold(a, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// RBAC is an in-memory role based Engine. Permissions are granted to roles,
+// and roles to subjects in addition to the roles carried by their token.
+type RBAC struct {
+	mu          sync.RWMutex
+	permissions map[string][]permission
+	roles       map[string][]string
+}
+
+// NewRBAC creates an empty RBAC engine.
+func NewRBAC() *RBAC {
+	return &RBAC{
+		permissions: make(map[string][]permission),
+		roles:       make(map[string][]string),
+	}
+}
+
+// Grant allows role to perform actions on the operations matching a path.Match
+// pattern, all actions when none are given.
+func (e *RBAC) Grant(role, pattern string, actions ...string) *RBAC {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.permissions[role] = append(e.permissions[role], permission{pattern: pattern, actions: actions})
+	return e
+}
+
+// Assign grants roles to the subject with the given ID.
+func (e *RBAC) Assign(subject string, roles ...string) *RBAC {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roles[subject] = append(e.roles[subject], roles...)
+	return e
+}
+
+// Authorize implements Engine.
+func (e *RBAC) Authorize(ctx context.Context, req *Request) (bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, roles := range [][]string{req.Subject.Roles, e.roles[req.Subject.ID]} {
+		for _, role := range roles {
+			for i := range e.permissions[role] {
+				if e.permissions[role][i].allows(req.Operation, req.Action) {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,55 @@
+package casbin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/casbin/casbin/v2"
+	"new-milli/middleware/authz"
+)
+
+var _ authz.Engine = (*Engine)(nil)
+
+// Engine is an authz.Engine enforcing a Casbin model with (sub, obj, act)
+// requests, the operation being the object.
+type Engine struct {
+	enforcer casbin.IEnforcer
+}
+
+// New creates an engine backed by enforcer.
+func New(enforcer casbin.IEnforcer) *Engine {
+	return &Engine{enforcer: enforcer}
+}
+
+// NewFromFiles creates an engine from a model and a CSV policy file.
+func NewFromFiles(model, policy string) (*Engine, error) {
+	enforcer, err := casbin.NewEnforcer(model, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create casbin enforcer: %w", err)
+	}
+	return New(enforcer), nil
+}
+
+// Enforcer returns the underlying enforcer, e.g. to manage the policy.
+func (e *Engine) Enforcer() casbin.IEnforcer {
+	return e.enforcer
+}
+
+// Authorize implements authz.Engine. The subject is enforced first, then each
+// role carried by its token, so roles don't have to be linked in the policy.
+func (e *Engine) Authorize(ctx context.Context, req *authz.Request) (bool, error) {
+	subjects := append([]string{req.Subject.ID}, req.Subject.Roles...)
+	for _, sub := range subjects {
+		if sub == "" {
+			continue
+		}
+		ok, err := e.enforcer.Enforce(sub, req.Operation, req.Action)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
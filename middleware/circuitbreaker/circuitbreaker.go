@@ -2,10 +2,9 @@ package circuitbreaker
 
 import (
 	"context"
-	"errors"
+	"net/http"
 	"time"
 
-	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/sony/gobreaker"
 	"new-milli/middleware"
 	"new-milli/transport"
@@ -13,7 +12,7 @@ import (
 
 var (
 	// ErrCircuitOpen is returned when the circuit breaker is open.
-	ErrCircuitOpen = errors.New("circuit breaker is open")
+	ErrCircuitOpen = transport.NewStatusError(http.StatusServiceUnavailable, "", "circuit breaker is open")
 )
 
 // Option is circuit breaker option.
@@ -31,6 +30,7 @@ type options struct {
 	isSuccessful       func(err error) bool
 	fallbackHandler    func(ctx context.Context, req interface{}) (interface{}, error)
 	circuitBreakerName func(ctx context.Context) string
+	manager            *Manager
 }
 
 // WithDisabled returns an Option that disables circuit breaking.
@@ -103,6 +103,14 @@ func WithCircuitBreakerName(fn func(ctx context.Context) string) Option {
 	}
 }
 
+// WithManager returns an Option that sets the manager keeping the breakers,
+// DefaultManager by default.
+func WithManager(m *Manager) Option {
+	return func(o *options) {
+		o.manager = m
+	}
+}
+
 // Server returns a middleware that enables circuit breaking for server.
 func Server(opts ...Option) middleware.Middleware {
 	cfg := options{
@@ -115,7 +123,7 @@ func Server(opts ...Option) middleware.Middleware {
 			return counts.Requests >= 10 && failureRatio >= 0.5
 		},
 		onStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			klog.Infof("Circuit breaker %s changed from %s to %s", name, from, to)
+			middleware.Log(context.Background()).Infof("Circuit breaker %s changed from %s to %s", name, from, to)
 		},
 		isSuccessful: func(err error) bool {
 			return err == nil
@@ -123,6 +131,7 @@ func Server(opts ...Option) middleware.Middleware {
 		fallbackHandler: func(ctx context.Context, req interface{}) (interface{}, error) {
 			return nil, ErrCircuitOpen
 		},
+		manager: DefaultManager,
 		circuitBreakerName: func(ctx context.Context) string {
 			if tr, ok := transport.FromServerContext(ctx); ok {
 				return "server_" + tr.Operation()
@@ -140,9 +149,6 @@ func Server(opts ...Option) middleware.Middleware {
 		}
 	}
 
-	// Create a circuit breaker registry
-	registry := make(map[string]*gobreaker.CircuitBreaker)
-
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			var (
@@ -159,20 +165,15 @@ func Server(opts ...Option) middleware.Middleware {
 			name := cfg.circuitBreakerName(ctx)
 
 			// Get or create the circuit breaker
-			var cb *gobreaker.CircuitBreaker
-			var ok bool
-			if cb, ok = registry[name]; !ok {
-				cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
-					Name:          name,
-					MaxRequests:   cfg.maxRequests,
-					Interval:      cfg.interval,
-					Timeout:       cfg.timeout,
-					ReadyToTrip:   cfg.readyToTrip,
-					OnStateChange: cfg.onStateChange,
-					IsSuccessful:  cfg.isSuccessful,
-				})
-				registry[name] = cb
-			}
+			cb := cfg.manager.getOrCreate(gobreaker.Settings{
+				Name:          name,
+				MaxRequests:   cfg.maxRequests,
+				Interval:      cfg.interval,
+				Timeout:       cfg.timeout,
+				ReadyToTrip:   cfg.readyToTrip,
+				OnStateChange: cfg.onStateChange,
+				IsSuccessful:  cfg.isSuccessful,
+			})
 
 			// Execute the request with the circuit breaker
 			result, err := cb.Execute(func() (interface{}, error) {
@@ -181,7 +182,7 @@ func Server(opts ...Option) middleware.Middleware {
 
 			// If the circuit is open, use the fallback handler
 			if err == gobreaker.ErrOpenState {
-				klog.CtxWarnf(ctx, "[%s] %s %s circuit breaker is open", kind, "server", operation)
+				middleware.Log(ctx).Warnf("[%s] %s %s circuit breaker is open", kind, "server", operation)
 				return cfg.fallbackHandler(ctx, req)
 			}
 
@@ -202,7 +203,7 @@ func Client(opts ...Option) middleware.Middleware {
 			return counts.Requests >= 10 && failureRatio >= 0.5
 		},
 		onStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			klog.Infof("Circuit breaker %s changed from %s to %s", name, from, to)
+			middleware.Log(context.Background()).Infof("Circuit breaker %s changed from %s to %s", name, from, to)
 		},
 		isSuccessful: func(err error) bool {
 			return err == nil
@@ -210,6 +211,7 @@ func Client(opts ...Option) middleware.Middleware {
 		fallbackHandler: func(ctx context.Context, req interface{}) (interface{}, error) {
 			return nil, ErrCircuitOpen
 		},
+		manager: DefaultManager,
 		circuitBreakerName: func(ctx context.Context) string {
 			if tr, ok := transport.FromClientContext(ctx); ok {
 				return "client_" + tr.Operation()
@@ -227,9 +229,6 @@ func Client(opts ...Option) middleware.Middleware {
 		}
 	}
 
-	// Create a circuit breaker registry
-	registry := make(map[string]*gobreaker.CircuitBreaker)
-
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			var (
@@ -246,20 +245,15 @@ func Client(opts ...Option) middleware.Middleware {
 			name := cfg.circuitBreakerName(ctx)
 
 			// Get or create the circuit breaker
-			var cb *gobreaker.CircuitBreaker
-			var ok bool
-			if cb, ok = registry[name]; !ok {
-				cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
-					Name:          name,
-					MaxRequests:   cfg.maxRequests,
-					Interval:      cfg.interval,
-					Timeout:       cfg.timeout,
-					ReadyToTrip:   cfg.readyToTrip,
-					OnStateChange: cfg.onStateChange,
-					IsSuccessful:  cfg.isSuccessful,
-				})
-				registry[name] = cb
-			}
+			cb := cfg.manager.getOrCreate(gobreaker.Settings{
+				Name:          name,
+				MaxRequests:   cfg.maxRequests,
+				Interval:      cfg.interval,
+				Timeout:       cfg.timeout,
+				ReadyToTrip:   cfg.readyToTrip,
+				OnStateChange: cfg.onStateChange,
+				IsSuccessful:  cfg.isSuccessful,
+			})
 
 			// Execute the request with the circuit breaker
 			result, err := cb.Execute(func() (interface{}, error) {
@@ -268,7 +262,7 @@ func Client(opts ...Option) middleware.Middleware {
 
 			// If the circuit is open, use the fallback handler
 			if err == gobreaker.ErrOpenState {
-				klog.CtxWarnf(ctx, "[%s] %s %s circuit breaker is open", kind, "client", operation)
+				middleware.Log(ctx).Warnf("[%s] %s %s circuit breaker is open", kind, "client", operation)
 				return cfg.fallbackHandler(ctx, req)
 			}
 
@@ -289,7 +283,7 @@ func NewCircuitBreaker(name string, opts ...Option) *gobreaker.CircuitBreaker {
 			return counts.Requests >= 10 && failureRatio >= 0.5
 		},
 		onStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			klog.Infof("Circuit breaker %s changed from %s to %s", name, from, to)
+			middleware.Log(context.Background()).Infof("Circuit breaker %s changed from %s to %s", name, from, to)
 		},
 		isSuccessful: func(err error) bool {
 			return err == nil
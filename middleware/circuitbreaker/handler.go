@@ -0,0 +1,44 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// Handler returns a Hertz admin handler for the breakers of m, nil for the
+// DefaultManager. GET returns the Status of every breaker, POST trips the
+// breaker named by the name query parameter, e.g.
+// POST /admin/breakers?name=client_/payment.Pay, and DELETE resets it. Mount
+// it behind authentication.
+func Handler(m *Manager) app.HandlerFunc {
+	if m == nil {
+		m = DefaultManager
+	}
+	return func(ctx context.Context, c *app.RequestContext) {
+		var err error
+		switch string(c.Method()) {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			err = m.Trip(c.Query("name"))
+		case http.MethodDelete:
+			err = m.Reset(c.Query("name"))
+		default:
+			c.String(http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if errors.Is(err, ErrNotFound) {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		breakers := m.List()
+		statuses := make([]Status, 0, len(breakers))
+		for _, b := range breakers {
+			statuses = append(statuses, b.Status())
+		}
+		c.JSON(http.StatusOK, statuses)
+	}
+}
@@ -0,0 +1,374 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker"
+	"new-milli/collector"
+)
+
+// ErrNotFound is returned when no breaker has the given name.
+var ErrNotFound = errors.New("circuit breaker not found")
+
+// breakerMetrics is the metrics of the managed breakers.
+type breakerMetrics struct {
+	state       *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+	rejected    *prometheus.CounterVec
+}
+
+// newBreakerMetrics creates the circuit breaker metrics registered with
+// registry, reusing the registered ones. They aren't registered when
+// registry is nil.
+func newBreakerMetrics(registry prometheus.Registerer) *breakerMetrics {
+	m := &breakerMetrics{
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "circuitbreaker",
+			Name:      "state",
+			Help:      "State of the circuit breakers: 0 closed, 1 half-open, 2 open.",
+		}, []string{"breaker"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "circuitbreaker",
+			Name:      "state_changes_total",
+			Help:      "Total number of circuit breaker state changes.",
+		}, []string{"breaker", "from", "to"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "circuitbreaker",
+			Name:      "rejected_total",
+			Help:      "Total number of requests rejected by open circuit breakers.",
+		}, []string{"breaker"}),
+	}
+	if registry != nil {
+		var errs [3]error
+		m.state, errs[0] = collector.Register(registry, m.state)
+		m.transitions, errs[1] = collector.Register(registry, m.transitions)
+		m.rejected, errs[2] = collector.Register(registry, m.rejected)
+		if err := errors.Join(errs[:]...); err != nil {
+			klog.Warnf("Failed to register circuit breaker metrics: %v", err)
+		}
+	}
+	return m
+}
+
+// Event is a state change of a breaker.
+type Event struct {
+	Name string
+	From gobreaker.State
+	To   gobreaker.State
+	Time time.Time
+}
+
+// Breaker is a circuit breaker of a Manager. Unlike a bare gobreaker it can
+// be tripped and reset by operators.
+type Breaker struct {
+	name     string
+	settings gobreaker.Settings
+	manager  *Manager
+
+	mu      sync.RWMutex
+	cb      *gobreaker.CircuitBreaker
+	tripped bool
+}
+
+// Status is the status of a breaker.
+type Status struct {
+	Name    string           `json:"name"`
+	State   string           `json:"state"`
+	Tripped bool             `json:"tripped"`
+	Counts  gobreaker.Counts `json:"counts"`
+}
+
+// newBreaker creates a breaker of m recording its state changes.
+func newBreaker(m *Manager, settings gobreaker.Settings) *Breaker {
+	b := &Breaker{name: settings.Name, settings: settings, manager: m}
+	onStateChange := settings.OnStateChange
+	b.settings.OnStateChange = func(name string, from, to gobreaker.State) {
+		b.changed(from, to)
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+	}
+	b.cb = gobreaker.NewCircuitBreaker(b.settings)
+	m.metrics().state.WithLabelValues(b.name).Set(float64(gobreaker.StateClosed))
+	return b
+}
+
+// Name returns the breaker name.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the breaker state, open while tripped.
+func (b *Breaker) State() gobreaker.State {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.tripped {
+		return gobreaker.StateOpen
+	}
+	return b.cb.State()
+}
+
+// Status returns the breaker status.
+func (b *Breaker) Status() Status {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	state := b.cb.State()
+	if b.tripped {
+		state = gobreaker.StateOpen
+	}
+	return Status{Name: b.name, State: state.String(), Tripped: b.tripped, Counts: b.cb.Counts()}
+}
+
+// Execute runs fn if the breaker accepts the request, it returns
+// gobreaker.ErrOpenState while the breaker is open or tripped.
+func (b *Breaker) Execute(fn func() (interface{}, error)) (interface{}, error) {
+	b.mu.RLock()
+	cb, tripped := b.cb, b.tripped
+	b.mu.RUnlock()
+	if tripped {
+		b.manager.metrics().rejected.WithLabelValues(b.name).Inc()
+		return nil, gobreaker.ErrOpenState
+	}
+	result, err := cb.Execute(fn)
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		b.manager.metrics().rejected.WithLabelValues(b.name).Inc()
+	}
+	return result, err
+}
+
+// Trip opens the breaker until Reset is called.
+func (b *Breaker) Trip() {
+	b.mu.Lock()
+	from := b.cb.State()
+	wasTripped := b.tripped
+	b.tripped = true
+	b.mu.Unlock()
+
+	if !wasTripped && from != gobreaker.StateOpen {
+		b.settings.OnStateChange(b.name, from, gobreaker.StateOpen)
+	}
+}
+
+// Reset closes the breaker and clears its counts.
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	from := b.cb.State()
+	if b.tripped {
+		from = gobreaker.StateOpen
+	}
+	b.tripped = false
+	b.cb = gobreaker.NewCircuitBreaker(b.settings)
+	b.mu.Unlock()
+
+	if from != gobreaker.StateClosed {
+		b.settings.OnStateChange(b.name, from, gobreaker.StateClosed)
+	}
+}
+
+// changed records a state change and notifies the subscribers.
+func (b *Breaker) changed(from, to gobreaker.State) {
+	b.manager.metrics().state.WithLabelValues(b.name).Set(float64(to))
+	b.manager.metrics().transitions.WithLabelValues(b.name, from.String(), to.String()).Inc()
+	b.manager.publish(Event{Name: b.name, From: from, To: to, Time: time.Now()})
+}
+
+// Manager keeps the breakers of the middlewares by name. It is safe for
+// concurrent use.
+type Manager struct {
+	mu       sync.RWMutex
+	breakers map[string]*Breaker
+
+	subMu  sync.RWMutex
+	subs   map[int]func(Event)
+	nextID int
+
+	registry    prometheus.Registerer
+	metricsOnce sync.Once
+	metricsVal  *breakerMetrics
+}
+
+// ManagerOption is a function that configures a Manager.
+type ManagerOption func(*Manager)
+
+// WithManagerRegistry sets the registry of the breaker metrics,
+// prometheus.DefaultRegisterer by default, nil disables them.
+func WithManagerRegistry(registry prometheus.Registerer) ManagerOption {
+	return func(m *Manager) {
+		m.registry = registry
+	}
+}
+
+// NewManager creates a breaker manager.
+func NewManager(opts ...ManagerOption) *Manager {
+	m := &Manager{
+		breakers: make(map[string]*Breaker),
+		subs:     make(map[int]func(Event)),
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// metrics returns the breaker metrics, created when the first breaker is.
+func (m *Manager) metrics() *breakerMetrics {
+	m.metricsOnce.Do(func() {
+		m.metricsVal = newBreakerMetrics(m.registry)
+	})
+	return m.metricsVal
+}
+
+// Subscribe calls fn on every state change of the breakers until the
+// returned function is called. fn is called synchronously while the
+// breaker changes state, so it must neither block nor call the breaker.
+func (m *Manager) Subscribe(fn func(Event)) (unsubscribe func()) {
+	m.subMu.Lock()
+	id := m.nextID
+	m.nextID++
+	m.subs[id] = fn
+	m.subMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.subMu.Lock()
+			delete(m.subs, id)
+			m.subMu.Unlock()
+		})
+	}
+}
+
+// Events returns a channel receiving the state changes of the breakers
+// until the returned function is called. Events are dropped while the
+// channel buffer of size is full.
+func (m *Manager) Events(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	var mu sync.Mutex
+	closed := false
+	unsubscribe := m.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+	return ch, func() {
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// publish notifies the subscribers of a state change.
+func (m *Manager) publish(e Event) {
+	m.subMu.RLock()
+	defer m.subMu.RUnlock()
+	for _, fn := range m.subs {
+		fn(e)
+	}
+}
+
+// DefaultManager is the manager of the middlewares without WithManager.
+var DefaultManager = NewManager()
+
+// Get returns the breaker named name.
+func (m *Manager) Get(name string) (*Breaker, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.breakers[name]
+	return b, ok
+}
+
+// List returns the breakers sorted by name.
+func (m *Manager) List() []*Breaker {
+	m.mu.RLock()
+	breakers := make([]*Breaker, 0, len(m.breakers))
+	for _, b := range m.breakers {
+		breakers = append(breakers, b)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].name < breakers[j].name })
+	return breakers
+}
+
+// Trip opens the breaker named name until it is reset.
+func (m *Manager) Trip(name string) error {
+	b, ok := m.Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	b.Trip()
+	return nil
+}
+
+// Reset closes the breaker named name and clears its counts.
+func (m *Manager) Reset(name string) error {
+	b, ok := m.Get(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	b.Reset()
+	return nil
+}
+
+// getOrCreate returns the breaker named settings.Name, created with
+// settings on first use.
+func (m *Manager) getOrCreate(settings gobreaker.Settings) *Breaker {
+	if b, ok := m.Get(settings.Name); ok {
+		return b
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.breakers[settings.Name]; ok {
+		return b
+	}
+	b := newBreaker(m, settings)
+	m.breakers[settings.Name] = b
+	return b
+}
+
+// Get returns the breaker named name of the DefaultManager.
+func Get(name string) (*Breaker, bool) {
+	return DefaultManager.Get(name)
+}
+
+// List returns the breakers of the DefaultManager sorted by name.
+func List() []*Breaker {
+	return DefaultManager.List()
+}
+
+// Trip opens the breaker named name of the DefaultManager.
+func Trip(name string) error {
+	return DefaultManager.Trip(name)
+}
+
+// Subscribe calls fn on every state change of the breakers of the
+// DefaultManager until the returned function is called.
+func Subscribe(fn func(Event)) (unsubscribe func()) {
+	return DefaultManager.Subscribe(fn)
+}
+
+// Reset closes the breaker named name of the DefaultManager.
+func Reset(name string) error {
+	return DefaultManager.Reset(name)
+}
@@ -0,0 +1,286 @@
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"new-milli/middleware"
+	"new-milli/middleware/session"
+)
+
+var (
+	// ErrMissingToken is the error of the requests without a token.
+	ErrMissingToken = errors.New("csrf: missing token")
+	// ErrInvalidToken is the error of the requests with a wrong token.
+	ErrInvalidToken = errors.New("csrf: invalid token")
+)
+
+// Defaults of the token names.
+const (
+	// DefaultHeader is the request header carrying the token.
+	DefaultHeader = "X-CSRF-Token"
+	// DefaultFormField is the form field carrying the token.
+	DefaultFormField = "csrf_token"
+	// DefaultCookieName is the cookie holding the token in double-submit mode.
+	DefaultCookieName = "csrf_token"
+	// sessionKey is the session key holding the token in synchronizer mode.
+	sessionKey = "_csrf"
+)
+
+// ErrorHandler writes the response of a rejected request, err is
+// ErrMissingToken or ErrInvalidToken.
+type ErrorHandler func(ctx context.Context, c *app.RequestContext, err error)
+
+// Option is CSRF option.
+type Option func(*options)
+
+// options is CSRF options.
+type options struct {
+	header       string
+	formField    string
+	cookieName   string
+	cookiePath   string
+	domain       string
+	secure       bool
+	sameSite     protocol.CookieSameSite
+	maxAge       int
+	useSession   bool
+	safeMethods  map[string]bool
+	exempt       []string
+	errorHandler ErrorHandler
+}
+
+// WithHeader returns an Option that sets the request header carrying the
+// token, DefaultHeader by default.
+func WithHeader(header string) Option {
+	return func(o *options) {
+		o.header = header
+	}
+}
+
+// WithFormField returns an Option that sets the form field carrying the
+// token, DefaultFormField by default.
+func WithFormField(field string) Option {
+	return func(o *options) {
+		o.formField = field
+	}
+}
+
+// WithCookieName returns an Option that sets the cookie holding the token
+// in double-submit mode, DefaultCookieName by default.
+func WithCookieName(name string) Option {
+	return func(o *options) {
+		o.cookieName = name
+	}
+}
+
+// WithCookiePath returns an Option that sets the path of the token cookie,
+// "/" by default.
+func WithCookiePath(path string) Option {
+	return func(o *options) {
+		o.cookiePath = path
+	}
+}
+
+// WithCookieDomain returns an Option that sets the domain of the token cookie.
+func WithCookieDomain(domain string) Option {
+	return func(o *options) {
+		o.domain = domain
+	}
+}
+
+// WithSecure returns an Option that sets whether the token cookie is only
+// sent over HTTPS, true by default.
+func WithSecure(secure bool) Option {
+	return func(o *options) {
+		o.secure = secure
+	}
+}
+
+// WithSameSite returns an Option that sets the SameSite attribute of the
+// token cookie, protocol.CookieSameSiteLaxMode by default.
+func WithSameSite(sameSite protocol.CookieSameSite) Option {
+	return func(o *options) {
+		o.sameSite = sameSite
+	}
+}
+
+// WithMaxAge returns an Option that sets the lifetime of the token cookie
+// in seconds, 12 hours by default.
+func WithMaxAge(seconds int) Option {
+	return func(o *options) {
+		o.maxAge = seconds
+	}
+}
+
+// WithSession returns an Option that keeps the token in the session of the
+// client instead of a cookie (synchronizer token pattern). It requires
+// session.Handler before the CSRF handler.
+func WithSession() Option {
+	return func(o *options) {
+		o.useSession = true
+	}
+}
+
+// WithSafeMethods returns an Option that sets the methods exempted from the
+// check, GET, HEAD, OPTIONS and TRACE by default.
+func WithSafeMethods(methods ...string) Option {
+	return func(o *options) {
+		o.safeMethods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			o.safeMethods[m] = true
+		}
+	}
+}
+
+// WithExempt returns an Option that exempts the paths matching path.Match
+// patterns from the check, e.g. "/webhooks/*" for machine callers.
+func WithExempt(patterns ...string) Option {
+	return func(o *options) {
+		o.exempt = append(o.exempt, patterns...)
+	}
+}
+
+// WithErrorHandler returns an Option that sets the response of the rejected
+// requests, 403 Forbidden by default.
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(o *options) {
+		o.errorHandler = h
+	}
+}
+
+// tokenKey is the key of the token in the context.
+type tokenKey struct{}
+
+// Token returns the token of the request set by Handler, to render in the
+// forms or hand to scripts.
+func Token(ctx context.Context) string {
+	token, _ := ctx.Value(tokenKey{}).(string)
+	return token
+}
+
+// Handler returns a Hertz handler protecting the routes from cross-site
+// request forgery. The requests with an unsafe method must carry the token
+// of the client in the header or the form field, otherwise they are
+// rejected.
+//
+// By default the token is kept in a cookie the scripts can read and
+// compared with the submitted one (double-submit cookie pattern). With
+// WithSession it is kept in the session and never sent in a cookie
+// (synchronizer token pattern). In both modes Token returns it to the
+// following handlers.
+func Handler(opts ...Option) app.HandlerFunc {
+	o := options{
+		header:     DefaultHeader,
+		formField:  DefaultFormField,
+		cookieName: DefaultCookieName,
+		cookiePath: "/",
+		secure:     true,
+		sameSite:   protocol.CookieSameSiteLaxMode,
+		maxAge:     12 * 60 * 60,
+		safeMethods: map[string]bool{
+			http.MethodGet:     true,
+			http.MethodHead:    true,
+			http.MethodOptions: true,
+			http.MethodTrace:   true,
+		},
+		errorHandler: func(ctx context.Context, c *app.RequestContext, err error) {
+			c.AbortWithMsg(err.Error(), http.StatusForbidden)
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		if o.isExempt(string(c.Path())) {
+			c.Next(ctx)
+			return
+		}
+
+		var s *session.Session
+		if o.useSession {
+			if s = session.FromContext(ctx); s == nil {
+				middleware.Log(ctx).Errorf("csrf: no session, add session.Handler before the CSRF handler")
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		token := o.token(c, s)
+		if !o.safeMethods[string(c.Method())] {
+			submitted := string(c.GetHeader(o.header))
+			if submitted == "" {
+				submitted = string(c.PostForm(o.formField))
+			}
+			switch {
+			case submitted == "":
+				o.errorHandler(ctx, c, ErrMissingToken)
+				c.Abort()
+				return
+			case token == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1:
+				o.errorHandler(ctx, c, ErrInvalidToken)
+				c.Abort()
+				return
+			}
+		}
+
+		if token == "" {
+			token = newToken()
+			if s != nil {
+				_ = s.Set(sessionKey, token)
+			} else {
+				o.setCookie(c, token)
+			}
+		}
+		c.Next(context.WithValue(ctx, tokenKey{}, token))
+	}
+}
+
+// isExempt reports whether a path is exempted from the check.
+func (o *options) isExempt(p string) bool {
+	for _, pattern := range o.exempt {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// token returns the token of the client, empty when it has none.
+func (o *options) token(c *app.RequestContext, s *session.Session) string {
+	if s != nil {
+		return s.GetString(sessionKey)
+	}
+	return string(c.Cookie(o.cookieName))
+}
+
+// setCookie sets the token cookie, readable by the scripts.
+func (o *options) setCookie(c *app.RequestContext, token string) {
+	cookie := protocol.AcquireCookie()
+	defer protocol.ReleaseCookie(cookie)
+	cookie.SetKey(o.cookieName)
+	cookie.SetValue(token)
+	cookie.SetMaxAge(o.maxAge)
+	cookie.SetPath(o.cookiePath)
+	cookie.SetDomain(o.domain)
+	cookie.SetSecure(o.secure)
+	cookie.SetSameSite(o.sameSite)
+	c.Response.Header.SetCookie(cookie)
+}
+
+// newToken returns a random token.
+func newToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
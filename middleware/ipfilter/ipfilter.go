@@ -0,0 +1,391 @@
+package ipfilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc/peer"
+	"new-milli/config"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// ErrForbidden is returned for the requests of a filtered client.
+var ErrForbidden = transport.NewStatusError(http.StatusForbidden, "", "ip not allowed")
+
+// Configuration keys read by Load.
+const (
+	KeyAllow          = "ipfilter.allow"
+	KeyDeny           = "ipfilter.deny"
+	KeyTrustedProxies = "ipfilter.trusted_proxies"
+	KeyProxyHeaders   = "ipfilter.proxy_headers"
+	KeyAllowCountries = "ipfilter.allow_countries"
+	KeyDenyCountries  = "ipfilter.deny_countries"
+)
+
+// DefaultProxyHeaders is the headers carrying the client IP set by the
+// trusted proxies, in order of preference.
+var DefaultProxyHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// GeoIP looks up the ISO country code of an IP, e.g. with a MaxMind
+// database:
+//
+//	db, _ := geoip2.Open("GeoLite2-Country.mmdb")
+//	ipfilter.GeoIPFunc(func(ip net.IP) (string, error) {
+//		record, err := db.Country(ip)
+//		if err != nil {
+//			return "", err
+//		}
+//		return record.Country.IsoCode, nil
+//	})
+type GeoIP interface {
+	Country(ip net.IP) (string, error)
+}
+
+// GeoIPFunc is a function implementing GeoIP.
+type GeoIPFunc func(ip net.IP) (string, error)
+
+// Country implements GeoIP.
+func (f GeoIPFunc) Country(ip net.IP) (string, error) {
+	return f(ip)
+}
+
+// Option is IP filter option.
+type Option func(*Rules)
+
+// Rules is the filtering rules, the CIDRs may also be single IPs.
+type Rules struct {
+	// Allow, when not empty, only lets these networks in.
+	Allow []string
+	// Deny rejects these networks, before Allow is checked.
+	Deny []string
+	// TrustedProxies is the networks of the proxies whose headers are
+	// trusted to carry the client IP.
+	TrustedProxies []string
+	// ProxyHeaders is the headers carrying the client IP, DefaultProxyHeaders
+	// when empty.
+	ProxyHeaders []string
+	// AllowCountries, when not empty, only lets these countries in.
+	AllowCountries []string
+	// DenyCountries rejects these countries.
+	DenyCountries []string
+}
+
+// WithAllow returns an Option that only lets the networks in, e.g.
+// "10.0.0.0/8".
+func WithAllow(cidrs ...string) Option {
+	return func(r *Rules) {
+		r.Allow = append(r.Allow, cidrs...)
+	}
+}
+
+// WithDeny returns an Option that rejects the networks.
+func WithDeny(cidrs ...string) Option {
+	return func(r *Rules) {
+		r.Deny = append(r.Deny, cidrs...)
+	}
+}
+
+// WithTrustedProxies returns an Option that trusts the proxy headers set by
+// the networks, e.g. the load balancers. The headers of the other peers are
+// ignored, so clients can't spoof their IP.
+func WithTrustedProxies(cidrs ...string) Option {
+	return func(r *Rules) {
+		r.TrustedProxies = append(r.TrustedProxies, cidrs...)
+	}
+}
+
+// WithProxyHeaders returns an Option that sets the headers carrying the
+// client IP, DefaultProxyHeaders by default.
+func WithProxyHeaders(headers ...string) Option {
+	return func(r *Rules) {
+		r.ProxyHeaders = headers
+	}
+}
+
+// WithAllowCountries returns an Option that only lets the countries in, by
+// ISO code, e.g. "FR". It requires a GeoIP.
+func WithAllowCountries(codes ...string) Option {
+	return func(r *Rules) {
+		r.AllowCountries = append(r.AllowCountries, codes...)
+	}
+}
+
+// WithDenyCountries returns an Option that rejects the countries, by ISO
+// code. It requires a GeoIP.
+func WithDenyCountries(codes ...string) Option {
+	return func(r *Rules) {
+		r.DenyCountries = append(r.DenyCountries, codes...)
+	}
+}
+
+// compiled is the parsed rules.
+type compiled struct {
+	allow, deny, trusted          []netip.Prefix
+	headers                       []string
+	allowCountries, denyCountries map[string]bool
+}
+
+// compile parses the rules.
+func (r *Rules) compile() (*compiled, error) {
+	c := &compiled{
+		headers:        r.ProxyHeaders,
+		allowCountries: countries(r.AllowCountries),
+		denyCountries:  countries(r.DenyCountries),
+	}
+	if len(c.headers) == 0 {
+		c.headers = DefaultProxyHeaders
+	}
+	var err error
+	if c.allow, err = prefixes(r.Allow); err != nil {
+		return nil, err
+	}
+	if c.deny, err = prefixes(r.Deny); err != nil {
+		return nil, err
+	}
+	if c.trusted, err = prefixes(r.TrustedProxies); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// prefixes parses CIDRs and IPs.
+func prefixes(cidrs []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("ipfilter: invalid IP %q: %w", s, err)
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("ipfilter: invalid CIDR %q: %w", s, err)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// countries returns the set of the upper-cased country codes.
+func countries(codes []string) map[string]bool {
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[strings.ToUpper(strings.TrimSpace(code))] = true
+	}
+	return set
+}
+
+// contains reports whether addr is in one of the networks.
+func contains(networks []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range networks {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter filters the requests by client IP. Its rules can be replaced while
+// it is used, e.g. when the configuration changes.
+type Filter struct {
+	rules atomic.Pointer[compiled]
+	geoIP GeoIP
+}
+
+// New creates a filter with the rules of opts. The GeoIP, if any, resolves
+// the countries of the country rules.
+func New(geoIP GeoIP, opts ...Option) (*Filter, error) {
+	f := &Filter{geoIP: geoIP}
+	if err := f.Update(opts...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update replaces the rules with those of opts.
+func (f *Filter) Update(opts ...Option) error {
+	var r Rules
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return f.Set(r)
+}
+
+// Set replaces the rules.
+func (f *Filter) Set(r Rules) error {
+	c, err := r.compile()
+	if err != nil {
+		return err
+	}
+	if (len(c.allowCountries) > 0 || len(c.denyCountries) > 0) && f.geoIP == nil {
+		return errors.New("ipfilter: country rules require a GeoIP")
+	}
+	f.rules.Store(c)
+	return nil
+}
+
+// Load replaces the rules with those of the configuration: ipfilter.allow,
+// ipfilter.deny, ipfilter.trusted_proxies, ipfilter.proxy_headers,
+// ipfilter.allow_countries and ipfilter.deny_countries. Call it again when
+// the configuration changes; without any of the keys the rules are left
+// untouched, the invalid rules are rejected as a whole.
+func (f *Filter) Load(cfg config.Config) error {
+	var (
+		r     Rules
+		found bool
+	)
+	for key, list := range map[string]*[]string{
+		KeyAllow:          &r.Allow,
+		KeyDeny:           &r.Deny,
+		KeyTrustedProxies: &r.TrustedProxies,
+		KeyProxyHeaders:   &r.ProxyHeaders,
+		KeyAllowCountries: &r.AllowCountries,
+		KeyDenyCountries:  &r.DenyCountries,
+	} {
+		if !cfg.Has(key) {
+			continue
+		}
+		found = true
+		values, err := cfg.GetStringSlice(key)
+		if err != nil {
+			return err
+		}
+		*list = values
+	}
+	if !found {
+		return nil
+	}
+	return f.Set(r)
+}
+
+// ClientIP returns the IP of the client of ctx. The proxy headers are read
+// when the peer is a trusted proxy, from the last address to the first,
+// skipping the trusted proxies.
+func (f *Filter) ClientIP(ctx context.Context) (netip.Addr, bool) {
+	tr, ok := transport.FromServerContext(ctx)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return f.rules.Load().clientIP(ctx, tr)
+}
+
+// clientIP returns the IP of the client of a request.
+func (c *compiled) clientIP(ctx context.Context, tr transport.Transporter) (netip.Addr, bool) {
+	remote, ok := remoteIP(ctx, tr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if !contains(c.trusted, remote) {
+		return remote, true
+	}
+	for _, header := range c.headers {
+		value := tr.RequestHeader().Get(header)
+		if value == "" {
+			continue
+		}
+		hops := strings.Split(value, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			addr = addr.Unmap()
+			if i == 0 || !contains(c.trusted, addr) {
+				return addr, true
+			}
+		}
+	}
+	return remote, true
+}
+
+// remoteIP returns the IP of the peer of a request.
+func remoteIP(ctx context.Context, tr transport.Transporter) (netip.Addr, bool) {
+	var addr string
+	if r, ok := tr.(interface{ RemoteAddr() string }); ok {
+		addr = r.RemoteAddr()
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	} else if r, ok := tr.(interface{ ClientIP() string }); ok {
+		addr = r.ClientIP()
+	}
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if a, err := netip.ParseAddr(addr); err == nil {
+		return a.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// Allowed reports whether the rules let addr in, and the reason when they
+// don't.
+func (f *Filter) Allowed(addr netip.Addr) (bool, string) {
+	return f.rules.Load().allowed(f.geoIP, addr)
+}
+
+// allowed reports whether the rules let addr in.
+func (c *compiled) allowed(geoIP GeoIP, addr netip.Addr) (bool, string) {
+	if contains(c.deny, addr) {
+		return false, "denied"
+	}
+	if len(c.allow) > 0 && !contains(c.allow, addr) {
+		return false, "not allowed"
+	}
+	if len(c.allowCountries) == 0 && len(c.denyCountries) == 0 {
+		return true, ""
+	}
+
+	country, err := geoIP.Country(net.IP(addr.AsSlice()))
+	if err != nil || country == "" {
+		// Unknown countries, e.g. private networks, are let in
+		return true, ""
+	}
+	country = strings.ToUpper(country)
+	if c.denyCountries[country] {
+		return false, "country " + country + " denied"
+	}
+	if len(c.allowCountries) > 0 && !c.allowCountries[country] {
+		return false, "country " + country + " not allowed"
+	}
+	return true, ""
+}
+
+// Server returns a middleware rejecting the requests of the filtered
+// clients with ErrForbidden, and those whose IP is unknown when an allow
+// list is set.
+func (f *Filter) Server() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			c := f.rules.Load()
+			addr, ok := c.clientIP(ctx, tr)
+			if !ok {
+				if len(c.allow) > 0 || len(c.allowCountries) > 0 {
+					middleware.Log(ctx).Warnf("[ipfilter] rejected %s: unknown client IP", tr.Operation())
+					return nil, ErrForbidden
+				}
+				return handler(ctx, req)
+			}
+			if allowed, reason := c.allowed(f.geoIP, addr); !allowed {
+				middleware.Log(ctx).Debugf("[ipfilter] rejected %s from %s: %s", tr.Operation(), addr, reason)
+				return nil, ErrForbidden
+			}
+			return handler(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+// Logger is the logger of the middlewares. logger.Logger implements it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// LoggerFunc returns the logger of a request, e.g. logger.FromContext to
+// log with the logger, fields and trace of the request context.
+type LoggerFunc func(ctx context.Context) Logger
+
+// loggerFunc is the LoggerFunc set with SetLogger or SetLoggerFunc.
+var loggerFunc atomic.Pointer[LoggerFunc]
+
+// SetLogger makes the middlewares log to l instead of klog, nil restores
+// klog.
+func SetLogger(l Logger) {
+	if l == nil {
+		loggerFunc.Store(nil)
+		return
+	}
+	SetLoggerFunc(func(context.Context) Logger { return l })
+}
+
+// SetLoggerFunc makes the middlewares log to the logger fn returns for the
+// request context instead of klog, nil restores klog.
+func SetLoggerFunc(fn LoggerFunc) {
+	if fn == nil {
+		loggerFunc.Store(nil)
+		return
+	}
+	loggerFunc.Store(&fn)
+}
+
+// Log returns the logger of the middlewares for ctx, klog unless set with
+// SetLogger or SetLoggerFunc.
+func Log(ctx context.Context) Logger {
+	if fn := loggerFunc.Load(); fn != nil {
+		return (*fn)(ctx)
+	}
+	return klogLogger{ctx: ctx}
+}
+
+// klogLogger is a Logger writing to klog with the context of a request.
+type klogLogger struct {
+	ctx context.Context
+}
+
+// Debugf implements Logger.
+func (l klogLogger) Debugf(format string, args ...interface{}) {
+	klog.CtxDebugf(l.ctx, format, args...)
+}
+
+// Infof implements Logger.
+func (l klogLogger) Infof(format string, args ...interface{}) {
+	klog.CtxInfof(l.ctx, format, args...)
+}
+
+// Warnf implements Logger.
+func (l klogLogger) Warnf(format string, args ...interface{}) {
+	klog.CtxWarnf(l.ctx, format, args...)
+}
+
+// Errorf implements Logger.
+func (l klogLogger) Errorf(format string, args ...interface{}) {
+	klog.CtxErrorf(l.ctx, format, args...)
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"new-milli/codec"
+	"new-milli/mask"
+)
+
+// CaptureOption is body capture option.
+type CaptureOption func(*capture)
+
+// capture captures the request and response bodies.
+type capture struct {
+	maxSize    int
+	redactors  []Redactor
+	sampleRate float64
+}
+
+// WithMaxBodySize returns a CaptureOption that sets the size from which the
+// captured bodies are truncated, 4KB by default.
+func WithMaxBodySize(size int) CaptureOption {
+	return func(c *capture) {
+		c.maxSize = size
+	}
+}
+
+// WithRedactors returns a CaptureOption that sets the redactors applied to
+// the captured bodies in order, DefaultRedactors by default.
+func WithRedactors(redactors ...Redactor) CaptureOption {
+	return func(c *capture) {
+		c.redactors = redactors
+	}
+}
+
+// WithSampleRate returns a CaptureOption that captures the bodies of a
+// fraction of the requests, between 0 and 1, all of them by default.
+func WithSampleRate(rate float64) CaptureOption {
+	return func(c *capture) {
+		c.sampleRate = rate
+	}
+}
+
+// WithBodyCapture returns an Option that logs the request and reply of the
+// requests, encoded as JSON with their mask tags applied, redacted and
+// truncated.
+func WithBodyCapture(opts ...CaptureOption) Option {
+	c := &capture{
+		maxSize:    4 << 10,
+		redactors:  DefaultRedactors,
+		sampleRate: 1,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return func(o *options) {
+		o.capture = c
+	}
+}
+
+// sampled reports whether the bodies of a request are captured.
+func (c *capture) sampled() bool {
+	return c != nil && (c.sampleRate >= 1 || rand.Float64() < c.sampleRate)
+}
+
+// body returns a captured body.
+func (c *capture) body(v interface{}) string {
+	var data []byte
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		var err error
+		if data, err = codec.JSON.Marshal(mask.Log(v)); err != nil {
+			return fmt.Sprintf("<%T>", v)
+		}
+	}
+
+	// Redact before truncating, so truncated JSON is redacted too
+	var jrs []jsonRedactor
+	for _, r := range c.redactors {
+		if jr, ok := r.(jsonRedactor); ok {
+			jrs = append(jrs, jr)
+		}
+	}
+	if len(jrs) > 0 {
+		data = redactJSON(data, jrs...)
+	}
+	for _, r := range c.redactors {
+		if _, ok := r.(jsonRedactor); !ok {
+			data = r.Redact(data)
+		}
+	}
+
+	if c.maxSize > 0 && len(data) > c.maxSize {
+		return string(data[:c.maxSize]) + "...(" + strconv.Itoa(len(data)-c.maxSize) + " bytes truncated)"
+	}
+	return string(data)
+}
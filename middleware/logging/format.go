@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is the log entry of a request.
+type Entry struct {
+	Time      time.Time
+	Kind      string
+	Side      string
+	Operation string
+	Method    string
+	Protocol  string
+	Status    int
+	// Size is the size of the response body, -1 when unknown.
+	Size      int
+	Duration  time.Duration
+	ClientIP  string
+	UserAgent string
+	Referer   string
+	RequestID string
+	TraceID   string
+	Error     string
+	// Captured reports whether Request and Reply were captured.
+	Captured bool
+	Request  string
+	Reply    string
+}
+
+// Formatter formats the log line of an entry.
+type Formatter func(e *Entry) string
+
+// FormatText formats an entry as a text line, e.g.
+// "[http] server /users 200 OK 1.2ms".
+func FormatText(e *Entry) string {
+	reason := "OK"
+	if e.Error != "" {
+		reason = e.Error
+	}
+	line := fmt.Sprintf("[%s] %s %s %d %s %s", e.Kind, e.Side, e.Operation, e.Status, reason, e.Duration)
+	if e.Captured {
+		line += " request=" + strconv.Quote(e.Request) + " reply=" + strconv.Quote(e.Reply)
+	}
+	return line
+}
+
+// jsonEntry is the JSON log line of an entry.
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	Kind       string  `json:"kind,omitempty"`
+	Side       string  `json:"side"`
+	Operation  string  `json:"operation,omitempty"`
+	Method     string  `json:"method,omitempty"`
+	Protocol   string  `json:"protocol,omitempty"`
+	Status     int     `json:"status"`
+	Size       *int    `json:"size,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	ClientIP   string  `json:"client_ip,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Referer    string  `json:"referer,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+	TraceID    string  `json:"trace_id,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Request    *string `json:"request,omitempty"`
+	Reply      *string `json:"reply,omitempty"`
+}
+
+// FormatJSON formats an entry as a JSON object with the fields time
+// (RFC 3339), kind, side, operation, method, protocol, status, size,
+// duration_ms, client_ip, user_agent, referer, request_id, trace_id, error,
+// request and reply, empty ones omitted.
+func FormatJSON(e *Entry) string {
+	j := jsonEntry{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		Kind:       e.Kind,
+		Side:       e.Side,
+		Operation:  e.Operation,
+		Method:     e.Method,
+		Protocol:   e.Protocol,
+		Status:     e.Status,
+		DurationMs: float64(e.Duration.Microseconds()) / 1000,
+		ClientIP:   e.ClientIP,
+		UserAgent:  e.UserAgent,
+		Referer:    e.Referer,
+		RequestID:  e.RequestID,
+		TraceID:    e.TraceID,
+		Error:      e.Error,
+	}
+	if e.Size >= 0 {
+		j.Size = &e.Size
+	}
+	if e.Captured {
+		j.Request, j.Reply = &e.Request, &e.Reply
+	}
+	data, err := json.Marshal(j)
+	if err != nil {
+		return FormatText(e)
+	}
+	return string(data)
+}
+
+// FormatCLF formats an entry in the Apache Common Log Format, e.g.
+// `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /users HTTP/1.1" 200 2326`.
+func FormatCLF(e *Entry) string {
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s`,
+		orDash(e.ClientIP), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		orDash(e.Method), orDash(e.Operation), orDash(e.Protocol), e.Status, size(e.Size))
+}
+
+// FormatCombined formats an entry in the Apache Combined Log Format, the
+// Common Log Format followed by the referer and the user agent.
+func FormatCombined(e *Entry) string {
+	return FormatCLF(e) + " " + strconv.Quote(orDash(e.Referer)) + " " + strconv.Quote(orDash(e.UserAgent))
+}
+
+// orDash returns s, or "-" when empty.
+func orDash(s string) string {
+	if s = strings.TrimSpace(s); s == "" {
+		return "-"
+	}
+	return s
+}
+
+// size returns the CLF response size.
+func size(n int) string {
+	if n <= 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"time"
 
-	"github.com/cloudwego/kitex/pkg/klog"
+	"go.opentelemetry.io/otel/trace"
+	"new-milli/degrade"
+	"new-milli/logger"
 	"new-milli/middleware"
 	"new-milli/transport"
 )
@@ -15,8 +17,11 @@ type Option func(*options)
 // options is logging options.
 type options struct {
 	disabled      bool
-	level         klog.Level
+	level         logger.Level
 	slowThreshold time.Duration
+	capture       *capture
+	formatter     Formatter
+	logger        logger.Logger
 }
 
 // WithDisabled returns an Option that disables logging.
@@ -26,8 +31,10 @@ func WithDisabled(disabled bool) Option {
 	}
 }
 
-// WithLevel returns an Option that sets the log level.
-func WithLevel(level klog.Level) Option {
+// WithLevel returns an Option that sets the level of the request log lines,
+// logger.InfoLevel by default. The slow requests are logged at
+// logger.WarnLevel or above.
+func WithLevel(level logger.Level) Option {
 	return func(o *options) {
 		o.level = level
 	}
@@ -40,11 +47,38 @@ func WithSlowThreshold(threshold time.Duration) Option {
 	}
 }
 
+// WithFormatter returns an Option that sets the format of the log lines,
+// FormatText by default.
+func WithFormatter(f Formatter) Option {
+	return func(o *options) {
+		o.formatter = f
+	}
+}
+
+// WithLogger returns an Option that writes the log lines to l, with the
+// fields of the request context, instead of the logger of the middlewares.
+func WithLogger(l logger.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
 // Server returns a middleware that enables logging for server.
 func Server(opts ...Option) middleware.Middleware {
+	return newMiddleware("server", opts)
+}
+
+// Client returns a middleware that enables logging for client.
+func Client(opts ...Option) middleware.Middleware {
+	return newMiddleware("client", opts)
+}
+
+// newMiddleware returns a logging middleware for side.
+func newMiddleware(side string, opts []Option) middleware.Middleware {
 	cfg := options{
-		level:         klog.LevelInfo,
+		level:         logger.InfoLevel,
 		slowThreshold: time.Millisecond * 500,
+		formatter:     FormatText,
 	}
 	for _, opt := range opts {
 		opt(&cfg)
@@ -59,38 +93,26 @@ func Server(opts ...Option) middleware.Middleware {
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			var (
-				code      int32
-				reason    string
-				kind      string
-				operation string
-				start     = time.Now()
+				start   = time.Now()
+				sampled = cfg.capture.sampled()
 			)
 
-			if tr, ok := transport.FromServerContext(ctx); ok {
-				kind = tr.Kind().String()
-				operation = tr.Operation()
-			}
-
 			// Handle the request
 			reply, err = handler(ctx, req)
 
-			// Calculate the duration
-			duration := time.Since(start)
-
-			// Set the code and reason
-			if err != nil {
-				code = 500
-				reason = err.Error()
-			} else {
-				code = 200
-				reason = "OK"
+			e := newEntry(ctx, side, start, err)
+			if sampled {
+				e.Captured = true
+				e.Request = cfg.capture.body(req)
+				e.Reply = cfg.capture.body(reply)
 			}
 
 			// Log the request
-			if duration > cfg.slowThreshold {
-				klog.CtxWarnf(ctx, "[%s] %s %s %d %s %s", kind, "server", operation, code, reason, duration)
-			} else {
-				klog.CtxInfof(ctx, "[%s] %s %s %d %s %s", kind, "server", operation, code, reason, duration)
+			if e.Duration > cfg.slowThreshold {
+				cfg.write(ctx, max(cfg.level, logger.WarnLevel), cfg.formatter(e))
+			} else if err != nil || degrade.Allowed(degrade.FeatureDetailedLogging) {
+				// Successful fast requests aren't logged in degraded mode
+				cfg.write(ctx, cfg.level, cfg.formatter(e))
 			}
 
 			return reply, err
@@ -98,60 +120,85 @@ func Server(opts ...Option) middleware.Middleware {
 	}
 }
 
-// Client returns a middleware that enables logging for client.
-func Client(opts ...Option) middleware.Middleware {
-	cfg := options{
-		level:         klog.LevelInfo,
-		slowThreshold: time.Millisecond * 500,
-	}
-	for _, opt := range opts {
-		opt(&cfg)
-	}
-
-	if cfg.disabled {
-		return func(handler middleware.Handler) middleware.Handler {
-			return handler
+// write writes a log line to the sink.
+func (o *options) write(ctx context.Context, level logger.Level, line string) {
+	if o.logger == nil {
+		l := middleware.Log(ctx)
+		switch {
+		case level >= logger.ErrorLevel:
+			l.Errorf("%s", line)
+		case level == logger.WarnLevel:
+			l.Warnf("%s", line)
+		case level == logger.InfoLevel:
+			l.Infof("%s", line)
+		default:
+			l.Debugf("%s", line)
 		}
+		return
 	}
 
-	return func(handler middleware.Handler) middleware.Handler {
-		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
-			var (
-				code      int32
-				reason    string
-				kind      string
-				operation string
-				start     = time.Now()
-			)
-
-			if tr, ok := transport.FromClientContext(ctx); ok {
-				kind = tr.Kind().String()
-				operation = tr.Operation()
-			}
-
-			// Handle the request
-			reply, err = handler(ctx, req)
-
-			// Calculate the duration
-			duration := time.Since(start)
+	l := o.logger.WithContext(ctx)
+	switch {
+	case level >= logger.ErrorLevel:
+		l.Error(line)
+	case level == logger.WarnLevel:
+		l.Warn(line)
+	case level == logger.InfoLevel:
+		l.Info(line)
+	default:
+		l.Debug(line)
+	}
+}
 
-			// Set the code and reason
-			if err != nil {
-				code = 500
-				reason = err.Error()
-			} else {
-				code = 200
-				reason = "OK"
-			}
+// newEntry returns the entry of a request handled from start.
+func newEntry(ctx context.Context, side string, start time.Time, err error) *Entry {
+	e := &Entry{
+		Time:     start,
+		Side:     side,
+		Duration: time.Since(start),
+		Status:   200,
+		Size:     -1,
+	}
 
-			// Log the request
-			if duration > cfg.slowThreshold {
-				klog.CtxWarnf(ctx, "[%s] %s %s %d %s %s", kind, "client", operation, code, reason, duration)
-			} else {
-				klog.CtxInfof(ctx, "[%s] %s %s %d %s %s", kind, "client", operation, code, reason, duration)
-			}
+	var tr transport.Transporter
+	if side == "server" {
+		tr, _ = transport.FromServerContext(ctx)
+	} else {
+		tr, _ = transport.FromClientContext(ctx)
+	}
+	if tr != nil {
+		e.Kind = tr.Kind().String()
+		e.Operation = tr.Operation()
+		if m, ok := tr.(interface{ Method() string }); ok {
+			e.Method = m.Method()
+		}
+		if c, ok := tr.(interface{ ClientIP() string }); ok {
+			e.ClientIP = c.ClientIP()
+		}
+		if p, ok := tr.(interface{ Protocol() string }); ok {
+			e.Protocol = p.Protocol()
+		}
+		if s, ok := tr.(interface{ StatusCode() int }); ok && s.StatusCode() > 0 {
+			e.Status = s.StatusCode()
+		}
+		if s, ok := tr.(interface{ ResponseSize() int }); ok {
+			e.Size = s.ResponseSize()
+		}
+		if h := tr.RequestHeader(); h != nil {
+			e.UserAgent = h.Get("User-Agent")
+			e.Referer = h.Get("Referer")
+			e.RequestID = h.Get("X-Request-Id")
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		e.TraceID = sc.TraceID().String()
+	}
 
-			return reply, err
+	if err != nil {
+		e.Error = err.Error()
+		if e.Status < 400 {
+			e.Status = 500
 		}
 	}
+	return e
 }
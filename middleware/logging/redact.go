@@ -0,0 +1,288 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Mask replaces the redacted values.
+const Mask = "***"
+
+// Redactor masks the sensitive data of a captured body.
+type Redactor interface {
+	Redact(body []byte) []byte
+}
+
+// RedactorFunc is a function implementing Redactor.
+type RedactorFunc func(body []byte) []byte
+
+// Redact implements Redactor.
+func (f RedactorFunc) Redact(body []byte) []byte { return f(body) }
+
+// jsonRedactor is implemented by the redactors of decoded JSON values, so a
+// body is decoded once for all of them.
+type jsonRedactor interface {
+	redactJSON(v interface{}) interface{}
+}
+
+// DefaultRedactors is the redactors used when none is set, masking the
+// common credential fields and card numbers.
+var DefaultRedactors = []Redactor{
+	RedactFields("password", "passwd", "secret", "token", "access_token", "refresh_token", "authorization", "api_key", "apikey"),
+	RedactCardNumbers(),
+}
+
+// fieldRedactor masks the JSON fields with given names at any depth.
+type fieldRedactor struct {
+	names map[string]struct{}
+}
+
+// RedactFields returns a Redactor masking the values of the JSON object
+// fields named names at any depth, case-insensitively.
+func RedactFields(names ...string) Redactor {
+	r := &fieldRedactor{names: make(map[string]struct{}, len(names))}
+	for _, name := range names {
+		r.names[strings.ToLower(name)] = struct{}{}
+	}
+	return r
+}
+
+// Redact implements Redactor.
+func (r *fieldRedactor) Redact(body []byte) []byte {
+	return redactJSON(body, r)
+}
+
+func (r *fieldRedactor) redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if _, ok := r.names[strings.ToLower(k)]; ok {
+				v[k] = Mask
+			} else {
+				v[k] = r.redactJSON(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = r.redactJSON(e)
+		}
+	}
+	return v
+}
+
+// segment is a segment of a JSON path.
+type segment struct {
+	name      string // field name, "*" for any field
+	index     int    // array index, -1 for any element
+	array     bool
+	recursive bool
+}
+
+// pathRedactor masks the values at JSON paths.
+type pathRedactor struct {
+	paths [][]segment
+}
+
+// RedactPaths returns a Redactor masking the values at JSON paths such as
+// "$.user.password", "$.cards[*].number", "$.items[0].secret", "$.*.token"
+// or "$..cvv" for a field at any depth. Invalid paths are ignored.
+func RedactPaths(paths ...string) Redactor {
+	r := &pathRedactor{}
+	for _, p := range paths {
+		if segs, ok := parsePath(p); ok {
+			r.paths = append(r.paths, segs)
+		}
+	}
+	return r
+}
+
+// Redact implements Redactor.
+func (r *pathRedactor) Redact(body []byte) []byte {
+	return redactJSON(body, r)
+}
+
+func (r *pathRedactor) redactJSON(v interface{}) interface{} {
+	for _, segs := range r.paths {
+		v = maskPath(v, segs)
+	}
+	return v
+}
+
+// parsePath parses a JSON path.
+func parsePath(p string) ([]segment, bool) {
+	if !strings.HasPrefix(p, "$") {
+		return nil, false
+	}
+	p = p[1:]
+
+	var segs []segment
+	for p != "" {
+		switch {
+		case strings.HasPrefix(p, "["):
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, false
+			}
+			seg := segment{array: true, index: -1}
+			if idx := p[1:end]; idx != "*" {
+				n, err := strconv.Atoi(idx)
+				if err != nil || n < 0 {
+					return nil, false
+				}
+				seg.index = n
+			}
+			segs = append(segs, seg)
+			p = p[end+1:]
+		case strings.HasPrefix(p, "."):
+			seg := segment{}
+			p = p[1:]
+			if strings.HasPrefix(p, ".") {
+				seg.recursive = true
+				p = p[1:]
+			}
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, false
+			}
+			seg.name = p[:end]
+			segs = append(segs, seg)
+			p = p[end:]
+		default:
+			return nil, false
+		}
+	}
+	return segs, len(segs) > 0
+}
+
+// maskPath masks the values of v at the path segs and returns v.
+func maskPath(v interface{}, segs []segment) interface{} {
+	if len(segs) == 0 {
+		return Mask
+	}
+	seg, rest := segs[0], segs[1:]
+
+	if seg.recursive {
+		// The field at this level, then at any level below
+		v = maskPath(v, append([]segment{{name: seg.name}}, rest...))
+		switch c := v.(type) {
+		case map[string]interface{}:
+			for k, e := range c {
+				c[k] = maskPath(e, segs)
+			}
+		case []interface{}:
+			for i, e := range c {
+				c[i] = maskPath(e, segs)
+			}
+		}
+		return v
+	}
+
+	if seg.array {
+		arr, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		for i, e := range arr {
+			if seg.index < 0 || seg.index == i {
+				arr[i] = maskPath(e, rest)
+			}
+		}
+		return v
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	for k, e := range obj {
+		if seg.name == "*" || seg.name == k {
+			obj[k] = maskPath(e, rest)
+		}
+	}
+	return v
+}
+
+// redactJSON decodes body, redacts it with rs and encodes it again. Bodies
+// that aren't JSON are returned unchanged.
+func redactJSON(body []byte, rs ...jsonRedactor) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return body
+	}
+	for _, r := range rs {
+		v = r.redactJSON(v)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// patternRedactor masks the matches of a regular expression.
+type patternRedactor struct {
+	re *regexp.Regexp
+}
+
+// RedactPattern returns a Redactor masking the matches of re.
+func RedactPattern(re *regexp.Regexp) Redactor {
+	return &patternRedactor{re: re}
+}
+
+// Redact implements Redactor.
+func (r *patternRedactor) Redact(body []byte) []byte {
+	return r.re.ReplaceAll(body, []byte(Mask))
+}
+
+// cardNumber matches the candidate card numbers, 13 to 19 digits optionally
+// grouped by spaces or dashes.
+var cardNumber = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+// RedactCardNumbers returns a Redactor masking the card numbers passing the
+// Luhn check but their last 4 digits.
+func RedactCardNumbers() Redactor {
+	return RedactorFunc(func(body []byte) []byte {
+		return cardNumber.ReplaceAllFunc(body, func(m []byte) []byte {
+			digits := make([]byte, 0, len(m))
+			for _, c := range m {
+				if c >= '0' && c <= '9' {
+					digits = append(digits, c)
+				}
+			}
+			if !luhn(digits) {
+				return m
+			}
+			return []byte(Mask + string(digits[len(digits)-4:]))
+		})
+	})
+}
+
+// luhn reports whether digits pass the Luhn check.
+func luhn(digits []byte) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
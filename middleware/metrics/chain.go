@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+	"new-milli/diagnostics"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// MiddlewareBuckets is the default histogram buckets of the middleware
+// durations, finer than DefaultBuckets since most middleware is fast.
+var MiddlewareBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+
+// downstreamKey is the context key of the time spent after a middleware
+// called its next handler.
+type downstreamKey struct{}
+
+// Named is a middleware with the name it is measured under.
+type Named struct {
+	Name       string
+	Middleware middleware.Middleware
+}
+
+// MiddlewareTimer measures the time spent inside middleware, excluding the
+// time spent in the rest of the chain and the handler, and exports it as the
+// middleware_duration_seconds histogram labeled by middleware and operation.
+type MiddlewareTimer struct {
+	disabled bool
+	duration *prometheus.HistogramVec
+}
+
+// NewMiddlewareTimer creates a middleware timer. It accepts WithDisabled,
+// WithNamespace, WithSubsystem, WithBuckets, WithConstLabels and WithRegistry.
+func NewMiddlewareTimer(opts ...Option) *MiddlewareTimer {
+	cfg := options{
+		namespace:   Namespace,
+		buckets:     MiddlewareBuckets,
+		constLabels: prometheus.Labels{},
+		registry:    prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return &MiddlewareTimer{disabled: true}
+	}
+
+	duration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   cfg.namespace,
+			Subsystem:   cfg.subsystem,
+			Name:        "middleware_duration_seconds",
+			Help:        "Time spent inside each middleware in seconds.",
+			Buckets:     cfg.buckets,
+			ConstLabels: cfg.constLabels,
+		},
+		[]string{"middleware", "operation"},
+	)
+	duration, err := collector.Register(cfg.registry, duration)
+	if err != nil {
+		panic(err)
+	}
+
+	return &MiddlewareTimer{duration: duration}
+}
+
+// Wrap returns m measured under name.
+func (t *MiddlewareTimer) Wrap(name string, m middleware.Middleware) middleware.Middleware {
+	if t.disabled {
+		return m
+	}
+
+	return func(next middleware.Handler) middleware.Handler {
+		// next may be called several times, e.g. by retries, or concurrently
+		inner := m(func(ctx context.Context, req interface{}) (interface{}, error) {
+			start := time.Now()
+			reply, err := next(ctx, req)
+			if downstream, ok := ctx.Value(downstreamKey{}).(*atomic.Int64); ok {
+				downstream.Add(int64(time.Since(start)))
+			}
+			return reply, err
+		})
+
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			downstream := new(atomic.Int64)
+			start := time.Now()
+			reply, err := inner(context.WithValue(ctx, downstreamKey{}, downstream), req)
+
+			self := time.Since(start) - time.Duration(downstream.Load())
+			if self < 0 {
+				self = 0
+			}
+			t.duration.WithLabelValues(name, operation(ctx)).Observe(self.Seconds())
+			diagnostics.Record(ctx, diagnostics.KindMiddleware, name, start, nil)
+			return reply, err
+		}
+	}
+}
+
+// Chain returns a middleware chaining the named middleware in order like
+// middleware.Chain, each of them measured.
+func (t *MiddlewareTimer) Chain(ms ...Named) middleware.Middleware {
+	wrapped := make([]middleware.Middleware, 0, len(ms))
+	for _, m := range ms {
+		wrapped = append(wrapped, t.Wrap(m.Name, m.Middleware))
+	}
+	return middleware.Chain(wrapped...)
+}
+
+// operation returns the operation of the server or client transport of ctx.
+func operation(ctx context.Context) string {
+	if tr, ok := transport.FromServerContext(ctx); ok {
+		return tr.Operation()
+	}
+	if tr, ok := transport.FromClientContext(ctx); ok {
+		return tr.Operation()
+	}
+	return "unknown"
+}
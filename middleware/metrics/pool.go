@@ -0,0 +1,237 @@
+package metrics
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/event"
+	"new-milli/connector"
+)
+
+// poolMetrics describes the connection pool metrics.
+type poolMetrics struct {
+	open     *prometheus.Desc
+	inUse    *prometheus.Desc
+	idle     *prometheus.Desc
+	max      *prometheus.Desc
+	waits    *prometheus.Desc
+	waitTime *prometheus.Desc
+	timeouts *prometheus.Desc
+	closed   *prometheus.Desc
+	hits     *prometheus.Desc
+	misses   *prometheus.Desc
+}
+
+// newPoolMetrics creates the descriptions of the pool metrics, either for a
+// single connector labeled with name or, when name is empty, for several
+// connectors with a variable connector label.
+func newPoolMetrics(name string) *poolMetrics {
+	var (
+		variableLabels []string
+		constLabels    prometheus.Labels
+	)
+	if name == "" {
+		variableLabels = []string{"connector"}
+	} else {
+		constLabels = prometheus.Labels{"connector": name}
+	}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "connector", metric), help, variableLabels, constLabels)
+	}
+	return &poolMetrics{
+		open:     desc("pool_open_connections", "Number of open connections."),
+		inUse:    desc("pool_in_use_connections", "Number of connections in use."),
+		idle:     desc("pool_idle_connections", "Number of idle connections."),
+		max:      desc("pool_max_connections", "Maximum number of open connections."),
+		waits:    desc("pool_waits_total", "Number of times a connection was waited for."),
+		waitTime: desc("pool_wait_seconds_total", "Time spent waiting for a connection."),
+		timeouts: desc("pool_timeouts_total", "Number of times getting a connection timed out or failed."),
+		closed:   desc("pool_closed_connections_total", "Number of connections closed."),
+		hits:     desc("pool_hits_total", "Number of times a free connection was found in the pool."),
+		misses:   desc("pool_misses_total", "Number of times no free connection was found in the pool."),
+	}
+}
+
+// gauge sends a gauge value.
+func gauge(ch chan<- prometheus.Metric, d *prometheus.Desc, v float64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+}
+
+// counter sends a counter value.
+func counter(ch chan<- prometheus.Metric, d *prometheus.Desc, v float64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v, labels...)
+}
+
+// sqlCollector exports the pool statistics of a database/sql DB.
+type sqlCollector struct {
+	m  *poolMetrics
+	db *sql.DB
+}
+
+// Describe implements prometheus.Collector.
+func (c *sqlCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.m.open, c.m.inUse, c.m.idle, c.m.max, c.m.waits, c.m.waitTime, c.m.closed} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *sqlCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.db.Stats()
+	gauge(ch, c.m.open, float64(s.OpenConnections))
+	gauge(ch, c.m.inUse, float64(s.InUse))
+	gauge(ch, c.m.idle, float64(s.Idle))
+	gauge(ch, c.m.max, float64(s.MaxOpenConnections))
+	counter(ch, c.m.waits, float64(s.WaitCount))
+	counter(ch, c.m.waitTime, s.WaitDuration.Seconds())
+	counter(ch, c.m.closed, float64(s.MaxIdleClosed+s.MaxIdleTimeClosed+s.MaxLifetimeClosed))
+}
+
+// RegisterSQLPool exports the pool statistics of a database/sql DB, e.g. the
+// one behind the GORM DB of the MySQL or PostgreSQL connector, labeled with name.
+func RegisterSQLPool(registry prometheus.Registerer, name string, db *sql.DB) error {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	return registry.Register(&sqlCollector{m: newPoolMetrics(name), db: db})
+}
+
+// redisCollector exports the pool statistics of a Redis client.
+type redisCollector struct {
+	m      *poolMetrics
+	client redis.UniversalClient
+}
+
+// Describe implements prometheus.Collector.
+func (c *redisCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.m.open, c.m.idle, c.m.hits, c.m.misses, c.m.timeouts, c.m.closed} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *redisCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.client.PoolStats()
+	gauge(ch, c.m.open, float64(s.TotalConns))
+	gauge(ch, c.m.idle, float64(s.IdleConns))
+	counter(ch, c.m.hits, float64(s.Hits))
+	counter(ch, c.m.misses, float64(s.Misses))
+	counter(ch, c.m.timeouts, float64(s.Timeouts))
+	counter(ch, c.m.closed, float64(s.StaleConns))
+}
+
+// RegisterRedisPool exports the pool statistics of a Redis client labeled with name.
+func RegisterRedisPool(registry prometheus.Registerer, name string, client redis.UniversalClient) error {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	return registry.Register(&redisCollector{m: newPoolMetrics(name), client: client})
+}
+
+// mongoCollector counts the connection pool events of a MongoDB client.
+type mongoCollector struct {
+	m *poolMetrics
+
+	mu       sync.Mutex
+	open     float64
+	inUse    float64
+	waits    float64
+	failures float64
+	closed   float64
+}
+
+// Describe implements prometheus.Collector.
+func (c *mongoCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.m.open, c.m.inUse, c.m.waits, c.m.timeouts, c.m.closed} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *mongoCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gauge(ch, c.m.open, c.open)
+	gauge(ch, c.m.inUse, c.inUse)
+	counter(ch, c.m.waits, c.waits)
+	counter(ch, c.m.timeouts, c.failures)
+	counter(ch, c.m.closed, c.closed)
+}
+
+// event updates the counts from a pool event.
+func (c *mongoCollector) event(e *event.PoolEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch e.Type {
+	case event.ConnectionCreated:
+		c.open++
+	case event.ConnectionClosed:
+		c.open--
+		c.closed++
+	case event.GetStarted:
+		c.waits++
+	case event.GetSucceeded:
+		c.inUse++
+	case event.GetFailed:
+		c.failures++
+	case event.ConnectionReturned:
+		c.inUse--
+	}
+}
+
+// MongoPoolMonitor returns a pool monitor exporting the connection pool
+// events of a MongoDB client labeled with name. Pass it to the MongoDB
+// connector with mongo.WithPoolMonitor.
+func MongoPoolMonitor(registry prometheus.Registerer, name string) (*event.PoolMonitor, error) {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	c := &mongoCollector{m: newPoolMetrics(name)}
+	if err := registry.Register(c); err != nil {
+		return nil, err
+	}
+	return &event.PoolMonitor{Event: c.event}, nil
+}
+
+// connectorsCollector exports the pool statistics of the connectors of a registry.
+type connectorsCollector struct {
+	m     *poolMetrics
+	stats func() map[string]connector.Stats
+}
+
+// Describe implements prometheus.Collector.
+func (c *connectorsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.m.open, c.m.inUse, c.m.idle, c.m.max, c.m.waits, c.m.waitTime, c.m.timeouts} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *connectorsCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, s := range c.stats() {
+		gauge(ch, c.m.open, float64(s.Open), name)
+		gauge(ch, c.m.inUse, float64(s.InUse), name)
+		gauge(ch, c.m.idle, float64(s.Idle), name)
+		gauge(ch, c.m.max, float64(s.MaxOpen), name)
+		counter(ch, c.m.waits, float64(s.WaitCount), name)
+		counter(ch, c.m.waitTime, s.WaitDuration.Seconds(), name)
+		counter(ch, c.m.timeouts, float64(s.Timeouts), name)
+	}
+}
+
+// RegisterConnectors exports the pool statistics of the connectors in a
+// connector registry, nil for the global one, on every scrape. Connectors
+// not implementing connector.StatsProvider are skipped.
+func RegisterConnectors(registry prometheus.Registerer, connectors *connector.Registry) error {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	stats := connector.ListStats
+	if connectors != nil {
+		stats = connectors.Stats
+	}
+	return register(registry, &connectorsCollector{m: newPoolMetrics(""), stats: stats})
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"new-milli/collector"
+)
+
+// Namespace is the namespace of the built-in metrics.
+const Namespace = "new_milli"
+
+// runtimeCollector exports the Go runtime statistics.
+type runtimeCollector struct {
+	goroutines  *prometheus.Desc
+	threads     *prometheus.Desc
+	heapAlloc   *prometheus.Desc
+	heapInuse   *prometheus.Desc
+	heapIdle    *prometheus.Desc
+	heapObjects *prometheus.Desc
+	heapSys     *prometheus.Desc
+	gcCycles    *prometheus.Desc
+	gcPause     *prometheus.Desc
+	gcLastPause *prometheus.Desc
+	gcCPU       *prometheus.Desc
+	nextGC      *prometheus.Desc
+}
+
+// newRuntimeCollector creates the runtime collector.
+func newRuntimeCollector() *runtimeCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "runtime", name), help, nil, nil)
+	}
+	return &runtimeCollector{
+		goroutines:  desc("goroutines", "Number of goroutines."),
+		threads:     desc("threads", "Number of OS threads created."),
+		heapAlloc:   desc("heap_alloc_bytes", "Bytes of allocated heap objects."),
+		heapInuse:   desc("heap_inuse_bytes", "Bytes in in-use heap spans."),
+		heapIdle:    desc("heap_idle_bytes", "Bytes in idle heap spans."),
+		heapObjects: desc("heap_objects", "Number of allocated heap objects."),
+		heapSys:     desc("heap_sys_bytes", "Bytes of heap memory obtained from the OS."),
+		gcCycles:    desc("gc_cycles_total", "Number of completed GC cycles."),
+		gcPause:     desc("gc_pause_seconds_total", "Cumulative time the world was stopped by the GC."),
+		gcLastPause: desc("gc_last_pause_seconds", "Duration of the last GC pause."),
+		gcCPU:       desc("gc_cpu_fraction", "Fraction of the available CPU time used by the GC since the program started."),
+		nextGC:      desc("gc_next_heap_bytes", "Heap size target of the next GC cycle."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *runtimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.goroutines, c.threads, c.heapAlloc, c.heapInuse, c.heapIdle, c.heapObjects,
+		c.heapSys, c.gcCycles, c.gcPause, c.gcLastPause, c.gcCPU, c.nextGC,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *runtimeCollector) Collect(ch chan<- prometheus.Metric) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	threads, _ := runtime.ThreadCreateProfile(nil)
+
+	var lastPause time.Duration
+	if ms.NumGC > 0 {
+		lastPause = time.Duration(ms.PauseNs[(ms.NumGC+255)%256])
+	}
+
+	gauge(ch, c.goroutines, float64(runtime.NumGoroutine()))
+	gauge(ch, c.threads, float64(threads))
+	gauge(ch, c.heapAlloc, float64(ms.HeapAlloc))
+	gauge(ch, c.heapInuse, float64(ms.HeapInuse))
+	gauge(ch, c.heapIdle, float64(ms.HeapIdle))
+	gauge(ch, c.heapObjects, float64(ms.HeapObjects))
+	gauge(ch, c.heapSys, float64(ms.HeapSys))
+	counter(ch, c.gcCycles, float64(ms.NumGC))
+	counter(ch, c.gcPause, time.Duration(ms.PauseTotalNs).Seconds())
+	gauge(ch, c.gcLastPause, lastPause.Seconds())
+	gauge(ch, c.gcCPU, ms.GCCPUFraction)
+	gauge(ch, c.nextGC, float64(ms.NextGC))
+}
+
+// EnableRuntimeMetrics registers the Go runtime metrics (goroutines, heap,
+// GC pauses) and the process metrics (CPU, memory, file descriptors) under
+// the new_milli namespace. Collectors already registered are kept.
+func EnableRuntimeMetrics(registry prometheus.Registerer) error {
+	if registry == nil {
+		registry = prometheus.DefaultRegisterer
+	}
+	return register(registry,
+		newRuntimeCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{Namespace: Namespace}),
+	)
+}
+
+// register registers collectors, ignoring those already registered.
+func register(registry prometheus.Registerer, cs ...prometheus.Collector) error {
+	var errs []error
+	for _, c := range cs {
+		if _, err := collector.Register(registry, c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,381 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"gopkg.in/yaml.v3"
+	"new-milli/middleware"
+	"new-milli/middleware/circuitbreaker"
+	"new-milli/middleware/ratelimit"
+	"new-milli/transport"
+)
+
+var (
+	// ErrForbidden is returned when the caller lacks a required scope.
+	ErrForbidden = transport.NewStatusError(http.StatusForbidden, "", "insufficient scope")
+)
+
+// Document is a policy document mapping operations to their policies.
+//
+// Example:
+//
+//	scopes: [users:read, users:write]
+//	limits:
+//	  standard: {rate: 100, capacity: 200}
+//	breakers:
+//	  strict: {max_requests: 10, interval: 1m, timeout: 30s, min_requests: 20, failure_ratio: 0.3}
+//	defaults:
+//	  timeout: 5s
+//	  rate_limit: standard
+//	operations:
+//	  /api/v1/users:
+//	    scopes: [users:read]
+//	    timeout: 2s
+//	    breaker: strict
+//	  /api/v1/admin/*:
+//	    scopes: [users:write]
+type Document struct {
+	// Scopes lists every scope operations may require.
+	Scopes []string `yaml:"scopes"`
+	// Limits are the named rate limits.
+	Limits map[string]RateLimit `yaml:"limits"`
+	// Breakers are the named circuit breaker settings.
+	Breakers map[string]Breaker `yaml:"breakers"`
+	// Defaults applies to operations without a policy and fills unset fields of the others.
+	Defaults Operation `yaml:"defaults"`
+	// Operations maps operations to their policies.
+	// A trailing "*" matches every operation with the given prefix.
+	Operations map[string]Operation `yaml:"operations"`
+}
+
+// RateLimit is a token bucket rate limit.
+// Operations referencing the same named limit share its bucket.
+type RateLimit struct {
+	Rate     float64 `yaml:"rate"`
+	Capacity int64   `yaml:"capacity"`
+	Wait     bool    `yaml:"wait"`
+}
+
+// Breaker is a circuit breaker setting.
+type Breaker struct {
+	MaxRequests  uint32        `yaml:"max_requests"`
+	Interval     time.Duration `yaml:"interval"`
+	Timeout      time.Duration `yaml:"timeout"`
+	MinRequests  uint32        `yaml:"min_requests"`
+	FailureRatio float64       `yaml:"failure_ratio"`
+}
+
+// Operation is the policy of an operation.
+type Operation struct {
+	// Scopes are all required to call the operation.
+	Scopes []string `yaml:"scopes"`
+	// RateLimit references a named limit, "none" disables the default limit.
+	RateLimit string `yaml:"rate_limit"`
+	// Timeout bounds the handling time of the operation.
+	Timeout time.Duration `yaml:"timeout"`
+	// Breaker references a named breaker, "none" disables the default breaker.
+	Breaker string `yaml:"breaker"`
+}
+
+// none disables a reference inherited from the defaults.
+const none = "none"
+
+// Load reads and validates a policy document from a YAML file.
+func Load(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates a YAML policy document.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse policy document: %w", err)
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Validate checks that every referenced scope, limit and breaker is declared.
+func (d *Document) Validate() error {
+	scopes := make(map[string]bool, len(d.Scopes))
+	for _, s := range d.Scopes {
+		scopes[s] = true
+	}
+
+	var errs []error
+	check := func(name string, op Operation) {
+		for _, s := range op.Scopes {
+			if !scopes[s] {
+				errs = append(errs, fmt.Errorf("%s: undeclared scope %q", name, s))
+			}
+		}
+		if op.RateLimit != "" && op.RateLimit != none {
+			if _, ok := d.Limits[op.RateLimit]; !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown rate limit %q", name, op.RateLimit))
+			}
+		}
+		if op.Breaker != "" && op.Breaker != none {
+			if _, ok := d.Breakers[op.Breaker]; !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown breaker %q", name, op.Breaker))
+			}
+		}
+		if op.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("%s: negative timeout", name))
+		}
+	}
+
+	check("defaults", d.Defaults)
+	for _, name := range sortedKeys(d.Operations) {
+		check(name, d.Operations[name])
+	}
+	for _, name := range sortedKeys(d.Limits) {
+		if l := d.Limits[name]; l.Rate <= 0 || l.Capacity <= 0 {
+			errs = append(errs, fmt.Errorf("limit %s: rate and capacity must be positive", name))
+		}
+	}
+	for _, name := range sortedKeys(d.Breakers) {
+		if b := d.Breakers[name]; b.FailureRatio < 0 || b.FailureRatio > 1 {
+			errs = append(errs, fmt.Errorf("breaker %s: failure ratio must be between 0 and 1", name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid policy document: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Option is policy option.
+type Option func(*options)
+
+// options is policy options.
+type options struct {
+	scopeFunc func(ctx context.Context) []string
+}
+
+// WithScopeFunc returns an Option that sets the function returning the scopes
+// granted to the caller, typically read from the authentication claims.
+// Operations requiring scopes are rejected when it is not set.
+func WithScopeFunc(fn func(ctx context.Context) []string) Option {
+	return func(o *options) {
+		o.scopeFunc = fn
+	}
+}
+
+// Policy is a compiled policy document.
+type Policy struct {
+	opts     options
+	exact    map[string]middleware.Middleware
+	prefixes []prefixRule
+	fallback middleware.Middleware
+}
+
+// prefixRule is a compiled wildcard operation.
+type prefixRule struct {
+	prefix string
+	m      middleware.Middleware
+}
+
+// Compile compiles the document into middleware.
+func (d *Document) Compile(opts ...Option) (*Policy, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &Policy{
+		exact: make(map[string]middleware.Middleware),
+	}
+	for _, opt := range opts {
+		opt(&p.opts)
+	}
+
+	// Named limits are compiled once so that operations share their bucket
+	limits := make(map[string]middleware.Middleware, len(d.Limits))
+	for name, l := range d.Limits {
+		limits[name] = ratelimit.Server(
+			ratelimit.WithRate(l.Rate),
+			ratelimit.WithCapacity(l.Capacity),
+			ratelimit.WithWaitIfFull(l.Wait),
+		)
+	}
+	breakers := make(map[string]middleware.Middleware, len(d.Breakers))
+	for name, b := range d.Breakers {
+		breakers[name] = compileBreaker(name, b)
+	}
+
+	compile := func(op Operation) middleware.Middleware {
+		op = d.inherit(op)
+
+		var chain []middleware.Middleware
+		if len(op.Scopes) > 0 {
+			chain = append(chain, p.requireScopes(op.Scopes))
+		}
+		if op.RateLimit != "" && op.RateLimit != none {
+			chain = append(chain, limits[op.RateLimit])
+		}
+		if op.Breaker != "" && op.Breaker != none {
+			chain = append(chain, breakers[op.Breaker])
+		}
+		if op.Timeout > 0 {
+			chain = append(chain, withTimeout(op.Timeout))
+		}
+		return middleware.Chain(chain...)
+	}
+
+	for name, op := range d.Operations {
+		if strings.HasSuffix(name, "*") {
+			p.prefixes = append(p.prefixes, prefixRule{prefix: strings.TrimSuffix(name, "*"), m: compile(op)})
+			continue
+		}
+		p.exact[name] = compile(op)
+	}
+	// Longest prefix wins
+	sort.Slice(p.prefixes, func(i, j int) bool {
+		return len(p.prefixes[i].prefix) > len(p.prefixes[j].prefix)
+	})
+	p.fallback = compile(Operation{})
+
+	return p, nil
+}
+
+// inherit fills the unset fields of op from the defaults.
+func (d *Document) inherit(op Operation) Operation {
+	if op.Scopes == nil {
+		op.Scopes = d.Defaults.Scopes
+	}
+	if op.RateLimit == "" {
+		op.RateLimit = d.Defaults.RateLimit
+	}
+	if op.Timeout == 0 {
+		op.Timeout = d.Defaults.Timeout
+	}
+	if op.Breaker == "" {
+		op.Breaker = d.Defaults.Breaker
+	}
+	return op
+}
+
+// Server returns a middleware that applies the policy of the called operation.
+func (p *Policy) Server() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		// Build every chain around the handler once
+		exact := make(map[string]middleware.Handler, len(p.exact))
+		for name, m := range p.exact {
+			exact[name] = m(handler)
+		}
+		prefixes := make([]middleware.Handler, len(p.prefixes))
+		for i, rule := range p.prefixes {
+			prefixes[i] = rule.m(handler)
+		}
+		fallback := p.fallback(handler)
+
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return fallback(ctx, req)
+			}
+			operation := tr.Operation()
+			if h, ok := exact[operation]; ok {
+				return h(ctx, req)
+			}
+			for i, rule := range p.prefixes {
+				if strings.HasPrefix(operation, rule.prefix) {
+					return prefixes[i](ctx, req)
+				}
+			}
+			return fallback(ctx, req)
+		}
+	}
+}
+
+// requireScopes returns a middleware that rejects callers missing a scope.
+func (p *Policy) requireScopes(required []string) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			var granted []string
+			if p.opts.scopeFunc != nil {
+				granted = p.opts.scopeFunc(ctx)
+			}
+			for _, s := range required {
+				if !contains(granted, s) {
+					middleware.Log(ctx).Warnf("policy: missing scope %s", s)
+					return nil, ErrForbidden
+				}
+			}
+			return handler(ctx, req)
+		}
+	}
+}
+
+// withTimeout returns a middleware that bounds the handling time.
+func withTimeout(timeout time.Duration) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return handler(ctx, req)
+		}
+	}
+}
+
+// compileBreaker converts breaker settings into a circuit breaker middleware.
+func compileBreaker(name string, b Breaker) middleware.Middleware {
+	opts := []circuitbreaker.Option{circuitbreaker.WithName(name)}
+	if b.MaxRequests > 0 {
+		opts = append(opts, circuitbreaker.WithMaxRequests(b.MaxRequests))
+	}
+	if b.Interval > 0 {
+		opts = append(opts, circuitbreaker.WithInterval(b.Interval))
+	}
+	if b.Timeout > 0 {
+		opts = append(opts, circuitbreaker.WithTimeout(b.Timeout))
+	}
+	if b.MinRequests > 0 || b.FailureRatio > 0 {
+		minRequests, ratio := b.MinRequests, b.FailureRatio
+		if minRequests == 0 {
+			minRequests = 10
+		}
+		if ratio == 0 {
+			ratio = 0.5
+		}
+		opts = append(opts, circuitbreaker.WithReadyToTrip(func(counts gobreaker.Counts) bool {
+			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+			return counts.Requests >= minRequests && failureRatio >= ratio
+		}))
+	}
+	return circuitbreaker.Server(opts...)
+}
+
+// contains reports whether s is in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order, for stable error messages.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/juju/ratelimit"
+	"new-milli/snapshot"
+	"new-milli/transport"
+)
+
+// SizeFunc returns the size in bytes of a request.
+type SizeFunc func(ctx context.Context, req interface{}) int64
+
+// RequestSize returns the Content-Length of the request when the transport
+// carries one, e.g. HTTP uploads, otherwise the size of the message:
+// []byte, string or anything with a Size() int method like protobuf messages.
+func RequestSize(ctx context.Context, req interface{}) int64 {
+	var header transport.Header
+	if tr, ok := transport.FromServerContext(ctx); ok {
+		header = tr.RequestHeader()
+	} else if tr, ok := transport.FromClientContext(ctx); ok {
+		header = tr.RequestHeader()
+	}
+	if header != nil {
+		if n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && n >= 0 {
+			return n
+		}
+	}
+
+	switch v := req.(type) {
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	case interface{ Size() int }:
+		return int64(v.Size())
+	}
+	return 0
+}
+
+// WithBandwidth returns an Option that limits the bytes per second of the requests,
+// sized by the SizeFunc, with a bucket of capacity bytes. It applies per key
+// with WithKeyFunc and combines with the request rate, a request must pass both.
+// Set WithRate to 0 to limit the bandwidth only.
+func WithBandwidth(bytesPerSecond float64, capacity int64) Option {
+	return func(o *options) {
+		o.bandwidth = bytesPerSecond
+		o.bandwidthCapacity = capacity
+	}
+}
+
+// WithBandwidthOverride returns an Option that sets the bandwidth and capacity of
+// keys matching a path.Match pattern, e.g. "/api/v1/upload/*". The first matching override wins.
+func WithBandwidthOverride(pattern string, bytesPerSecond float64, capacity int64) Option {
+	return func(o *options) {
+		o.bandwidthOverrides = append(o.bandwidthOverrides, override{
+			pattern:  pattern,
+			rate:     bytesPerSecond,
+			capacity: capacity,
+		})
+	}
+}
+
+// WithSizeFunc returns an Option that sets how the size of a request is
+// determined for the bandwidth limit, RequestSize by default.
+func WithSizeFunc(fn SizeFunc) Option {
+	return func(o *options) {
+		o.sizeFunc = fn
+	}
+}
+
+// newBandwidthTaker returns the function taking the bytes of a request from
+// the bandwidth buckets, nil if the bandwidth isn't limited.
+func newBandwidthTaker(cfg options) func(ctx context.Context, req interface{}) bool {
+	if cfg.bandwidth <= 0 {
+		return nil
+	}
+	if cfg.sizeFunc == nil {
+		cfg.sizeFunc = RequestSize
+	}
+
+	take := func(bucket *ratelimit.Bucket, n int64) bool {
+		if n <= 0 {
+			return true
+		}
+		if cfg.waitIfFull {
+			bucket.Wait(n)
+			return true
+		}
+		// A request larger than the bucket drains it instead of never passing
+		if c := bucket.Capacity(); n > c {
+			n = c
+		}
+		_, ok := bucket.TakeMaxDuration(n, 0)
+		return ok
+	}
+
+	if cfg.keyFunc == nil {
+		bucket := ratelimit.NewBucketWithRate(cfg.bandwidth, cfg.bandwidthCapacity)
+		if cfg.snapshots != nil {
+			cfg.snapshots.Register(snapshot.JSON(cfg.snapshotName+".bytes",
+				func() int64 { return bucket.Available() },
+				func(tokens int64) { drain(bucket, tokens) },
+			))
+		}
+		return func(ctx context.Context, req interface{}) bool {
+			return take(bucket, cfg.sizeFunc(ctx, req))
+		}
+	}
+
+	bcfg := cfg
+	bcfg.rate, bcfg.capacity, bcfg.overrides = cfg.bandwidth, cfg.bandwidthCapacity, cfg.bandwidthOverrides
+	buckets := newStore(bcfg)
+	if cfg.snapshots != nil {
+		cfg.snapshots.Register(snapshot.JSON(cfg.snapshotName+".bytes", buckets.available, buckets.restore))
+	}
+	return func(ctx context.Context, req interface{}) bool {
+		return take(buckets.get(cfg.keyFunc(ctx)), cfg.sizeFunc(ctx, req))
+	}
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// cpuUsage is the smoothed CPU usage of the process in per-mille.
+	cpuUsage       atomic.Int64
+	cpuSamplerOnce sync.Once
+)
+
+// startCPUSampler starts sampling the CPU usage of the process once: the
+// CPU time consumed by the process over the wall time of the samples and
+// GOMAXPROCS.
+func startCPUSampler() {
+	cpuSamplerOnce.Do(func() {
+		prevCPU, ok := processCPUTime()
+		if !ok {
+			return
+		}
+		go func() {
+			prevWall := time.Now()
+			ticker := time.NewTicker(time.Millisecond * 500)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				cpu, ok := processCPUTime()
+				wall := now.Sub(prevWall) * time.Duration(runtime.GOMAXPROCS(0))
+				if !ok || wall <= 0 {
+					continue
+				}
+				usage := math.Min(float64(cpu-prevCPU)/float64(wall), 1) * 1000
+				prevCPU, prevWall = cpu, now
+
+				// Exponential moving average, the decay smooths short spikes
+				const decay = 0.95
+				prev := float64(cpuUsage.Load())
+				cpuUsage.Store(int64(prev*decay + usage*(1-decay)))
+			}
+		}()
+	})
+}
+
+// bucket is a window bucket of the adaptive limiter.
+type bucket struct {
+	start  time.Time
+	passed int64
+	rtSum  int64
+	count  int64
+}
+
+// adaptive is a BBR style limiter. It estimates the capacity of the service as
+// the maximum throughput times the minimum latency seen in the window, and sheds
+// requests beyond it while the CPU is busy or shortly after shedding started.
+type adaptive struct {
+	mu          sync.Mutex
+	buckets     []bucket
+	bucketWidth time.Duration
+	inflight    atomic.Int64
+	threshold   int64
+	coolOff     time.Duration
+	lastDrop    atomic.Int64
+}
+
+// newAdaptive creates an adaptive limiter.
+func newAdaptive(cfg options) *adaptive {
+	startCPUSampler()
+	return &adaptive{
+		buckets:     make([]bucket, cfg.buckets),
+		bucketWidth: cfg.window / time.Duration(cfg.buckets),
+		threshold:   int64(cfg.cpuThreshold * 1000),
+		coolOff:     cfg.coolOff,
+	}
+}
+
+// current returns the bucket of now, resetting it if it is stale.
+func (l *adaptive) current(now time.Time) *bucket {
+	start := now.Truncate(l.bucketWidth)
+	b := &l.buckets[int(start.UnixNano()/int64(l.bucketWidth))%len(l.buckets)]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	return b
+}
+
+// maxInflight returns the estimated number of requests the service handles concurrently.
+func (l *adaptive) maxInflight(now time.Time) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		maxPassed int64 = 1
+		minRT           = math.MaxFloat64
+		window          = l.bucketWidth * time.Duration(len(l.buckets))
+		current         = now.Truncate(l.bucketWidth)
+	)
+	for i := range l.buckets {
+		b := &l.buckets[i]
+		// Skip the bucket still being filled and the expired ones
+		if b.start.Equal(current) || now.Sub(b.start) > window {
+			continue
+		}
+		if b.passed > maxPassed {
+			maxPassed = b.passed
+		}
+		if b.count > 0 {
+			if rt := float64(b.rtSum) / float64(b.count); rt < minRT {
+				minRT = rt
+			}
+		}
+	}
+	if minRT == math.MaxFloat64 {
+		// No latency observed yet
+		return math.MaxInt64
+	}
+
+	perSecond := float64(time.Second) / float64(l.bucketWidth)
+	return int64(math.Ceil(float64(maxPassed) * perSecond * minRT / float64(time.Second)))
+}
+
+// allow reports whether a request may pass, done must be called when it completes.
+func (l *adaptive) allow() (done func(), ok bool) {
+	now := time.Now()
+	if l.shouldDrop(now) {
+		l.lastDrop.Store(now.UnixNano())
+		return nil, false
+	}
+
+	l.inflight.Add(1)
+	return func() {
+		end := time.Now()
+		l.inflight.Add(-1)
+
+		l.mu.Lock()
+		b := l.current(end)
+		b.passed++
+		b.rtSum += int64(end.Sub(now))
+		b.count++
+		l.mu.Unlock()
+	}, true
+}
+
+// shouldDrop reports whether a request should be shed.
+func (l *adaptive) shouldDrop(now time.Time) bool {
+	if cpuUsage.Load() < l.threshold {
+		// Keep shedding for a while after it started so the load does not oscillate
+		lastDrop := l.lastDrop.Load()
+		if lastDrop == 0 || now.Sub(time.Unix(0, lastDrop)) > l.coolOff {
+			return false
+		}
+	}
+	inflight := l.inflight.Load()
+	return inflight > 1 && inflight >= l.maxInflight(now)
+}
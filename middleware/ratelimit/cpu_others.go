@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package ratelimit
+
+import "time"
+
+// processCPUTime reports that the CPU time of the process is unknown, the
+// adaptive limiter then never sees the CPU busy and doesn't shed.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -2,18 +2,18 @@ package ratelimit
 
 import (
 	"context"
-	"errors"
+	"net/http"
 	"time"
 
-	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/juju/ratelimit"
 	"new-milli/middleware"
+	"new-milli/snapshot"
 	"new-milli/transport"
 )
 
 var (
 	// ErrLimitExceed is returned when the rate limit is exceeded.
-	ErrLimitExceed = errors.New("rate limit exceeded")
+	ErrLimitExceed = transport.NewStatusError(http.StatusTooManyRequests, "", "rate limit exceeded")
 )
 
 // Option is rate limit option.
@@ -25,6 +25,24 @@ type options struct {
 	capacity   int64
 	rate       float64
 	waitIfFull bool
+	keyFunc    KeyFunc
+	maxKeys    int
+	keyTTL     time.Duration
+	overrides  []override
+
+	adaptive     bool
+	cpuThreshold float64
+	window       time.Duration
+	buckets      int
+	coolOff      time.Duration
+
+	snapshots    *snapshot.Manager
+	snapshotName string
+
+	bandwidth          float64
+	bandwidthCapacity  int64
+	bandwidthOverrides []override
+	sizeFunc           SizeFunc
 }
 
 // WithDisabled returns an Option that disables rate limiting.
@@ -41,7 +59,8 @@ func WithCapacity(capacity int64) Option {
 	}
 }
 
-// WithRate returns an Option that sets the fill rate.
+// WithRate returns an Option that sets the fill rate, 0 disables the request
+// rate limit when the bandwidth is limited.
 func WithRate(rate float64) Option {
 	return func(o *options) {
 		o.rate = rate
@@ -55,13 +74,177 @@ func WithWaitIfFull(wait bool) Option {
 	}
 }
 
-// Server returns a middleware that enables rate limiting for server.
-func Server(opts ...Option) middleware.Middleware {
-	cfg := options{
+// WithKeyFunc returns an Option that limits requests per key instead of with one global bucket,
+// see KeyByOperation, KeyByClientIP and KeyByHeader.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(o *options) {
+		o.keyFunc = fn
+	}
+}
+
+// WithMaxKeys returns an Option that sets the maximum number of keyed buckets kept,
+// the least recently used bucket is evicted first.
+func WithMaxKeys(n int) Option {
+	return func(o *options) {
+		o.maxKeys = n
+	}
+}
+
+// WithKeyTTL returns an Option that sets how long an unused keyed bucket is kept.
+func WithKeyTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.keyTTL = ttl
+	}
+}
+
+// WithKeyOverride returns an Option that sets the rate and capacity of keys matching
+// a path.Match pattern, e.g. "/api/v1/export/*". The first matching override wins.
+func WithKeyOverride(pattern string, rate float64, capacity int64) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, override{
+			pattern:  pattern,
+			rate:     rate,
+			capacity: capacity,
+		})
+	}
+}
+
+// WithAdaptive returns an Option that replaces the fixed rate with an adaptive limit.
+// Requests are shed when the inflight count exceeds the throughput times the minimum
+// latency observed in the window while the CPU usage is above the threshold.
+// The adaptive limit applies to the whole server, the key options are ignored.
+func WithAdaptive(adaptive bool) Option {
+	return func(o *options) {
+		o.adaptive = adaptive
+	}
+}
+
+// WithCPUThreshold returns an Option that sets the CPU usage, between 0 and 1,
+// above which the adaptive limiter starts shedding requests.
+func WithCPUThreshold(threshold float64) Option {
+	return func(o *options) {
+		o.cpuThreshold = threshold
+	}
+}
+
+// WithWindow returns an Option that sets the window the adaptive limiter observes
+// throughput and latency in, and the number of buckets it is split into.
+func WithWindow(window time.Duration, buckets int) Option {
+	return func(o *options) {
+		o.window = window
+		o.buckets = buckets
+	}
+}
+
+// WithCoolOff returns an Option that sets how long the adaptive limiter keeps
+// shedding requests after the CPU usage drops below the threshold.
+func WithCoolOff(coolOff time.Duration) Option {
+	return func(o *options) {
+		o.coolOff = coolOff
+	}
+}
+
+// WithSnapshot returns an Option that saves the tokens left in the buckets on
+// shutdown and restores them on startup through the snapshot manager, so a
+// restart doesn't refill them. name must be unique among the limiters.
+func WithSnapshot(m *snapshot.Manager, name string) Option {
+	return func(o *options) {
+		o.snapshots = m
+		o.snapshotName = name
+	}
+}
+
+// defaultOptions returns the default rate limit options.
+func defaultOptions() options {
+	return options{
 		capacity:   100,
 		rate:       100,
 		waitIfFull: false,
+		maxKeys:    10000,
+		keyTTL:     time.Minute * 10,
+
+		cpuThreshold: 0.8,
+		window:       time.Second * 10,
+		buckets:      100,
+		coolOff:      time.Second,
+
+		bandwidthCapacity: 1 << 20,
+	}
+}
+
+// newTaker returns the function taking a token and the bytes for a request,
+// done must be called when the request completes.
+func newTaker(cfg options) func(ctx context.Context, req interface{}) (done func(), ok bool) {
+	takeRequest := newRequestTaker(cfg)
+	takeBytes := newBandwidthTaker(cfg)
+	if takeBytes == nil {
+		return takeRequest
+	}
+	return func(ctx context.Context, req interface{}) (func(), bool) {
+		done, ok := takeRequest(ctx, req)
+		if !ok {
+			return done, false
+		}
+		if !takeBytes(ctx, req) {
+			done()
+			return func() {}, false
+		}
+		return done, true
+	}
+}
+
+// newRequestTaker returns the function taking a token for a request.
+func newRequestTaker(cfg options) func(ctx context.Context, req interface{}) (done func(), ok bool) {
+	if cfg.adaptive {
+		if cfg.buckets <= 0 {
+			cfg.buckets = 1
+		}
+		limiter := newAdaptive(cfg)
+		return func(ctx context.Context, req interface{}) (func(), bool) {
+			return limiter.allow()
+		}
+	}
+	if cfg.rate <= 0 && cfg.bandwidth > 0 {
+		return func(ctx context.Context, req interface{}) (func(), bool) {
+			return func() {}, true
+		}
+	}
+
+	take := func(bucket *ratelimit.Bucket) (func(), bool) {
+		if cfg.waitIfFull {
+			// Wait for a token to be available
+			bucket.Wait(1)
+			return func() {}, true
+		}
+		// Try to take a token without waiting
+		return func() {}, bucket.TakeAvailable(1) > 0
 	}
+
+	if cfg.keyFunc == nil {
+		bucket := ratelimit.NewBucketWithRate(cfg.rate, cfg.capacity)
+		if cfg.snapshots != nil {
+			cfg.snapshots.Register(snapshot.JSON(cfg.snapshotName,
+				func() int64 { return bucket.Available() },
+				func(tokens int64) { drain(bucket, tokens) },
+			))
+		}
+		return func(ctx context.Context, req interface{}) (func(), bool) {
+			return take(bucket)
+		}
+	}
+
+	buckets := newStore(cfg)
+	if cfg.snapshots != nil {
+		cfg.snapshots.Register(snapshot.JSON(cfg.snapshotName, buckets.available, buckets.restore))
+	}
+	return func(ctx context.Context, req interface{}) (func(), bool) {
+		return take(buckets.get(cfg.keyFunc(ctx)))
+	}
+}
+
+// Server returns a middleware that enables rate limiting for server.
+func Server(opts ...Option) middleware.Middleware {
+	cfg := defaultOptions()
 	for _, opt := range opts {
 		opt(&cfg)
 	}
@@ -72,8 +255,8 @@ func Server(opts ...Option) middleware.Middleware {
 		}
 	}
 
-	// Create a token bucket
-	bucket := ratelimit.NewBucketWithRate(cfg.rate, cfg.capacity)
+	// Create the token buckets
+	take := newTaker(cfg)
 
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
@@ -87,21 +270,13 @@ func Server(opts ...Option) middleware.Middleware {
 				operation = tr.Operation()
 			}
 
-			// Take a token from the bucket
-			var taken bool
-			if cfg.waitIfFull {
-				// Wait for a token to be available
-				bucket.Wait(1)
-				taken = true
-			} else {
-				// Try to take a token without waiting
-				taken = bucket.TakeAvailable(1) > 0
-			}
-
-			if !taken {
-				klog.CtxWarnf(ctx, "[%s] %s %s rate limit exceeded", kind, "server", operation)
+			// Take a token and the request bytes from the buckets
+			done, ok := take(ctx, req)
+			if !ok {
+				middleware.Log(ctx).Warnf("[%s] %s %s rate limit exceeded", kind, "server", operation)
 				return nil, ErrLimitExceed
 			}
+			defer done()
 
 			// Handle the request
 			return handler(ctx, req)
@@ -111,11 +286,7 @@ func Server(opts ...Option) middleware.Middleware {
 
 // Client returns a middleware that enables rate limiting for client.
 func Client(opts ...Option) middleware.Middleware {
-	cfg := options{
-		capacity:   100,
-		rate:       100,
-		waitIfFull: false,
-	}
+	cfg := defaultOptions()
 	for _, opt := range opts {
 		opt(&cfg)
 	}
@@ -126,8 +297,8 @@ func Client(opts ...Option) middleware.Middleware {
 		}
 	}
 
-	// Create a token bucket
-	bucket := ratelimit.NewBucketWithRate(cfg.rate, cfg.capacity)
+	// Create the token buckets
+	take := newTaker(cfg)
 
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
@@ -141,21 +312,13 @@ func Client(opts ...Option) middleware.Middleware {
 				operation = tr.Operation()
 			}
 
-			// Take a token from the bucket
-			var taken bool
-			if cfg.waitIfFull {
-				// Wait for a token to be available
-				bucket.Wait(1)
-				taken = true
-			} else {
-				// Try to take a token without waiting
-				taken = bucket.TakeAvailable(1) > 0
-			}
-
-			if !taken {
-				klog.CtxWarnf(ctx, "[%s] %s %s rate limit exceeded", kind, "client", operation)
+			// Take a token and the request bytes from the buckets
+			done, ok := take(ctx, req)
+			if !ok {
+				middleware.Log(ctx).Warnf("[%s] %s %s rate limit exceeded", kind, "client", operation)
 				return nil, ErrLimitExceed
 			}
+			defer done()
 
 			// Handle the request
 			return handler(ctx, req)
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+	"new-milli/transport"
+)
+
+// KeyFunc returns the key a request is limited by.
+// Requests with the same key share a bucket.
+type KeyFunc func(ctx context.Context) string
+
+// KeyByOperation limits each operation separately.
+func KeyByOperation(ctx context.Context) string {
+	if tr, ok := transport.FromServerContext(ctx); ok {
+		return tr.Operation()
+	}
+	if tr, ok := transport.FromClientContext(ctx); ok {
+		return tr.Operation()
+	}
+	return ""
+}
+
+// KeyByClientIP limits each caller IP separately.
+func KeyByClientIP(ctx context.Context) string {
+	tr, ok := transport.FromServerContext(ctx)
+	if !ok {
+		return ""
+	}
+	if c, ok := tr.(interface{ ClientIP() string }); ok {
+		if ip := c.ClientIP(); ip != "" {
+			return ip
+		}
+	}
+	if xff := tr.RequestHeader().Get("X-Forwarded-For"); xff != "" {
+		return strings.TrimSpace(strings.Split(xff, ",")[0])
+	}
+	return tr.RequestHeader().Get("X-Real-Ip")
+}
+
+// KeyByHeader limits each value of a request header separately, e.g. an API key.
+func KeyByHeader(name string) KeyFunc {
+	return func(ctx context.Context) string {
+		if tr, ok := transport.FromServerContext(ctx); ok {
+			return tr.RequestHeader().Get(name)
+		}
+		if tr, ok := transport.FromClientContext(ctx); ok {
+			return tr.RequestHeader().Get(name)
+		}
+		return ""
+	}
+}
+
+// override is the rate and capacity of keys matching a pattern.
+type override struct {
+	pattern  string
+	rate     float64
+	capacity int64
+}
+
+// entry is a bucket cached in the store.
+type entry struct {
+	key      string
+	bucket   *ratelimit.Bucket
+	lastUsed time.Time
+}
+
+// store is an LRU of buckets with TTL eviction.
+type store struct {
+	mu        sync.Mutex
+	items     map[string]*list.Element
+	lru       *list.List
+	maxKeys   int
+	ttl       time.Duration
+	rate      float64
+	capacity  int64
+	overrides []override
+}
+
+// newStore creates a bucket store.
+func newStore(cfg options) *store {
+	return &store{
+		items:     make(map[string]*list.Element),
+		lru:       list.New(),
+		maxKeys:   cfg.maxKeys,
+		ttl:       cfg.keyTTL,
+		rate:      cfg.rate,
+		capacity:  cfg.capacity,
+		overrides: cfg.overrides,
+	}
+}
+
+// get returns the bucket of key, creating it if needed.
+func (s *store) get(key string) *ratelimit.Bucket {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictExpired(now)
+
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*entry)
+		e.lastUsed = now
+		s.lru.MoveToFront(el)
+		return e.bucket
+	}
+
+	rate, capacity := s.limits(key)
+	e := &entry{
+		key:      key,
+		bucket:   ratelimit.NewBucketWithRate(rate, capacity),
+		lastUsed: now,
+	}
+	s.items[key] = s.lru.PushFront(e)
+
+	for s.maxKeys > 0 && s.lru.Len() > s.maxKeys {
+		s.remove(s.lru.Back())
+	}
+
+	return e.bucket
+}
+
+// limits returns the rate and capacity of key, the first matching override wins.
+func (s *store) limits(key string) (float64, int64) {
+	for _, o := range s.overrides {
+		if ok, _ := path.Match(o.pattern, key); ok {
+			return o.rate, o.capacity
+		}
+	}
+	return s.rate, s.capacity
+}
+
+// evictExpired removes the buckets unused for longer than the TTL.
+func (s *store) evictExpired(now time.Time) {
+	if s.ttl <= 0 {
+		return
+	}
+	for el := s.lru.Back(); el != nil; el = s.lru.Back() {
+		if now.Sub(el.Value.(*entry).lastUsed) < s.ttl {
+			return
+		}
+		s.remove(el)
+	}
+}
+
+// remove removes an element from the store.
+func (s *store) remove(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.items, el.Value.(*entry).key)
+}
+
+// available returns the tokens left in the buckets.
+func (s *store) available() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make(map[string]int64, len(s.items))
+	for key, el := range s.items {
+		tokens[key] = el.Value.(*entry).bucket.Available()
+	}
+	return tokens
+}
+
+// restore recreates the buckets with the tokens left in them.
+func (s *store) restore(tokens map[string]int64) {
+	for key, n := range tokens {
+		drain(s.get(key), n)
+	}
+}
+
+// drain takes tokens from a bucket until n are left.
+func drain(bucket *ratelimit.Bucket, n int64) {
+	if extra := bucket.Available() - n; extra > 0 {
+		bucket.TakeAvailable(extra)
+	}
+}
@@ -3,12 +3,41 @@ package recovery
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"runtime"
+	"time"
 
-	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/logger"
 	"new-milli/middleware"
+	"new-milli/transport"
 )
 
+// ErrPanic is the error wrapped by the errors of the recovered panics, the
+// HTTP transport answers it with 500 Internal Server Error.
+var ErrPanic = transport.NewStatusError(http.StatusInternalServerError, "INTERNAL", "panic")
+
+// PanicError is the error returned for a recovered panic by the default
+// recovery handler.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack of the panicking goroutine.
+	Stack []Frame
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns ErrPanic, and the panic value when it is an error.
+func (e *PanicError) Unwrap() []error {
+	if err, ok := e.Value.(error); ok {
+		return []error{ErrPanic, err}
+	}
+	return []error{ErrPanic}
+}
+
 // Option is recovery option.
 type Option func(*options)
 
@@ -18,6 +47,9 @@ type options struct {
 	stackSize       int
 	disableStack    bool
 	disablePrint    bool
+	repanic         bool
+	logger          logger.Logger
+	reporters       []Reporter
 	recoveryHandler func(ctx context.Context, err interface{}) error
 }
 
@@ -56,13 +88,46 @@ func WithRecoveryHandler(handler func(ctx context.Context, err interface{}) erro
 	}
 }
 
+// WithLogger returns an Option that logs the panics to l with structured
+// fields: the panic value, the frames of the stack and the request
+// metadata, instead of a stack dump to the logger of the middlewares.
+func WithLogger(l logger.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithReporter returns an Option that sends the panics to reporters, e.g.
+// an error tracking service.
+func WithReporter(r ...Reporter) Option {
+	return func(o *options) {
+		o.reporters = append(o.reporters, r...)
+	}
+}
+
+// WithRepanic returns an Option that panics again once the panic is logged
+// and reported, instead of converting it to an error, e.g. to let a
+// supervisor restart the process.
+func WithRepanic(repanic bool) Option {
+	return func(o *options) {
+		o.repanic = repanic
+	}
+}
+
 // Server returns a middleware that recovers from panics.
 func Server(opts ...Option) middleware.Middleware {
+	return newMiddleware("server", opts)
+}
+
+// Client returns a middleware that recovers from panics.
+func Client(opts ...Option) middleware.Middleware {
+	return newMiddleware("client", opts)
+}
+
+// newMiddleware returns a recovery middleware for side.
+func newMiddleware(side string, opts []Option) middleware.Middleware {
 	cfg := options{
 		stackSize: 4 << 10, // 4KB
-		recoveryHandler: func(ctx context.Context, err interface{}) error {
-			return fmt.Errorf("panic: %v", err)
-		},
 	}
 	for _, opt := range opts {
 		opt(&cfg)
@@ -78,15 +143,28 @@ func Server(opts ...Option) middleware.Middleware {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			defer func() {
 				if r := recover(); r != nil {
-					// Log the stack
-					stack := make([]byte, cfg.stackSize)
-					stack = stack[:runtime.Stack(stack, !cfg.disableStack)]
+					p := &Panic{
+						Value:   r,
+						Stack:   callers(),
+						Request: requestOf(ctx, side),
+						Time:    time.Now(),
+					}
 					if !cfg.disablePrint {
-						klog.CtxErrorf(ctx, "[Recovery] panic: %v\n%s", r, stack)
+						cfg.log(ctx, p)
+					}
+					for _, reporter := range cfg.reporters {
+						report(ctx, reporter, p)
+					}
+					if cfg.repanic {
+						panic(r)
 					}
 
 					// Call the recovery handler
-					err = cfg.recoveryHandler(ctx, r)
+					if cfg.recoveryHandler != nil {
+						err = cfg.recoveryHandler(ctx, r)
+					} else {
+						err = &PanicError{Value: r, Stack: p.Stack}
+					}
 				}
 			}()
 
@@ -95,41 +173,40 @@ func Server(opts ...Option) middleware.Middleware {
 	}
 }
 
-// Client returns a middleware that recovers from panics.
-func Client(opts ...Option) middleware.Middleware {
-	cfg := options{
-		stackSize: 4 << 10, // 4KB
-		recoveryHandler: func(ctx context.Context, err interface{}) error {
-			return fmt.Errorf("panic: %v", err)
-		},
-	}
-	for _, opt := range opts {
-		opt(&cfg)
+// log logs a panic.
+func (o *options) log(ctx context.Context, p *Panic) {
+	if o.logger == nil {
+		// Log the stack
+		stack := make([]byte, o.stackSize)
+		stack = stack[:runtime.Stack(stack, !o.disableStack)]
+		middleware.Log(ctx).Errorf("[Recovery] panic: %v\n%s", p.Value, stack)
+		return
 	}
 
-	if cfg.disabled {
-		return func(handler middleware.Handler) middleware.Handler {
-			return handler
+	fields := []logger.Field{
+		logger.F("panic", fmt.Sprint(p.Value)),
+		logger.F("stack", p.Stack),
+		logger.F("side", p.Request.Side),
+	}
+	for _, f := range [][2]string{
+		{"kind", p.Request.Kind},
+		{"operation", p.Request.Operation},
+		{"method", p.Request.Method},
+		{"request_id", p.Request.RequestID},
+	} {
+		if f[1] != "" {
+			fields = append(fields, logger.F(f[0], f[1]))
 		}
 	}
+	o.logger.WithContext(ctx).WithFields(fields...).Error("[Recovery] panic recovered")
+}
 
-	return func(handler middleware.Handler) middleware.Handler {
-		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
-			defer func() {
-				if r := recover(); r != nil {
-					// Log the stack
-					stack := make([]byte, cfg.stackSize)
-					stack = stack[:runtime.Stack(stack, !cfg.disableStack)]
-					if !cfg.disablePrint {
-						klog.CtxErrorf(ctx, "[Recovery] panic: %v\n%s", r, stack)
-					}
-
-					// Call the recovery handler
-					err = cfg.recoveryHandler(ctx, r)
-				}
-			}()
-
-			return handler(ctx, req)
+// report sends a panic to a reporter, a panicking reporter is logged.
+func report(ctx context.Context, reporter Reporter, p *Panic) {
+	defer func() {
+		if r := recover(); r != nil {
+			middleware.Log(ctx).Errorf("[Recovery] reporter panic: %v", r)
 		}
-	}
+	}()
+	reporter.Report(ctx, p)
 }
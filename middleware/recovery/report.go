@@ -0,0 +1,151 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"new-milli/requestctx"
+	"new-milli/transport"
+)
+
+// Reporter receives the recovered panics, e.g. to send them to an error
+// tracking service such as Sentry. Report is called in the goroutine of
+// the request before the error is returned, so it should not block.
+type Reporter interface {
+	Report(ctx context.Context, p *Panic)
+}
+
+// ReporterFunc is a function implementing Reporter.
+type ReporterFunc func(ctx context.Context, p *Panic)
+
+// Report implements Reporter.
+func (f ReporterFunc) Report(ctx context.Context, p *Panic) {
+	f(ctx, p)
+}
+
+// Panic is a recovered panic.
+type Panic struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack of the panicking goroutine, innermost frame first.
+	Stack []Frame
+	// Request is the request being handled.
+	Request Request
+	Time    time.Time
+}
+
+// Request is the metadata of the request of a panic.
+type Request struct {
+	// Side is "server" or "client".
+	Side      string
+	Kind      string
+	Operation string
+	// Method is the HTTP method, empty for the other transports.
+	Method    string
+	ClientIP  string
+	RequestID string
+	TraceID   string
+	UserID    string
+	Tenant    string
+	// Header is the request header, without the credentials.
+	Header map[string]string
+}
+
+// Frame is a frame of a stack.
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// String formats the frame like a Go stack trace.
+func (f Frame) String() string {
+	return fmt.Sprintf("%s\n\t%s:%d", f.Function, f.File, f.Line)
+}
+
+// maxFrames is the number of frames captured for a panic.
+const maxFrames = 64
+
+// callers returns the stack of the panicking goroutine from the function
+// that panicked, it must be called by the deferred function recovering.
+func callers() []Frame {
+	pcs := make([]uintptr, maxFrames)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var (
+		stack    []Frame
+		panicked bool
+	)
+	for {
+		f, more := frames.Next()
+		switch {
+		case f.Function == "runtime.gopanic":
+			// Drop the frames of the recovery before the panic
+			stack, panicked = stack[:0], true
+		case panicked && len(stack) == 0 && strings.HasPrefix(f.Function, "runtime."):
+			// Drop the runtime frames raising the panic, e.g. runtime.panicmem
+		default:
+			stack = append(stack, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// sensitiveHeaders is the headers left out of the request metadata.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+}
+
+// requestOf returns the metadata of the request of ctx.
+func requestOf(ctx context.Context, side string) Request {
+	r := Request{
+		Side:      side,
+		RequestID: requestctx.RequestID(ctx),
+		UserID:    requestctx.UserID(ctx),
+		Tenant:    requestctx.Tenant(ctx),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		r.TraceID = sc.TraceID().String()
+	}
+
+	var (
+		tr transport.Transporter
+		ok bool
+	)
+	if side == "server" {
+		tr, ok = transport.FromServerContext(ctx)
+	} else {
+		tr, ok = transport.FromClientContext(ctx)
+	}
+	if !ok {
+		return r
+	}
+	r.Kind = tr.Kind().String()
+	r.Operation = tr.Operation()
+	if m, ok := tr.(interface{ Method() string }); ok {
+		r.Method = m.Method()
+	}
+	if c, ok := tr.(interface{ ClientIP() string }); ok {
+		r.ClientIP = c.ClientIP()
+	}
+	if h := tr.RequestHeader(); h != nil {
+		r.Header = make(map[string]string)
+		for _, key := range h.Keys() {
+			if !sensitiveHeaders[strings.ToLower(key)] {
+				r.Header[key] = h.Get(key)
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,168 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// Option is retry option.
+type Option func(*options)
+
+// options is retry options.
+type options struct {
+	disabled   bool
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	retryable  func(err error) bool
+}
+
+// WithDisabled returns an Option that disables retries.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithAttempts returns an Option that sets the maximum number of attempts, including the first one.
+func WithAttempts(attempts int) Option {
+	return func(o *options) {
+		o.attempts = attempts
+	}
+}
+
+// WithBackoff returns an Option that sets the initial and maximum backoff between attempts.
+func WithBackoff(backoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.backoff = backoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithRetryable returns an Option that sets the function that decides whether an error is retried.
+func WithRetryable(fn func(err error) bool) Option {
+	return func(o *options) {
+		o.retryable = fn
+	}
+}
+
+// IsRetryable is the default retry decision. Errors implementing
+// Retryable() bool decide for themselves, network errors are retried
+// and everything else is not.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// idempotentKey is the context key marking a call as safe to repeat.
+type idempotentKey struct{}
+
+// Idempotent returns a new Context marking the call as safe to repeat, so
+// the HTTP requests of the non-idempotent methods, e.g. POST, are retried
+// too.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// idempotent reports whether a request may be sent again: HTTP requests
+// of the idempotent methods or carrying an Idempotency-Key header, the
+// calls marked with Idempotent and the requests of the other transports.
+func idempotent(ctx context.Context, req interface{}) bool {
+	if ok, _ := ctx.Value(idempotentKey{}).(bool); ok {
+		return true
+	}
+	r, ok := req.(*http.Request)
+	if !ok {
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+// Client returns a middleware that retries failed client calls. HTTP
+// requests of the non-idempotent methods, e.g. POST and PATCH, are only
+// retried when they carry an Idempotency-Key header or their context is
+// marked with Idempotent.
+func Client(opts ...Option) middleware.Middleware {
+	cfg := options{
+		attempts:   3,
+		backoff:    time.Millisecond * 50,
+		maxBackoff: time.Second,
+		retryable:  IsRetryable,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled || cfg.attempts <= 1 {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
+			var operation string
+			if tr, ok := transport.FromClientContext(ctx); ok {
+				operation = tr.Operation()
+			}
+
+			attempts := cfg.attempts
+			if !idempotent(ctx, req) {
+				attempts = 1
+			}
+
+			backoff := cfg.backoff
+			for attempt := 1; ; attempt++ {
+				reply, err = handler(ctx, req)
+				if err == nil || attempt >= attempts || !cfg.retryable(err) {
+					return reply, err
+				}
+				discard(reply)
+
+				middleware.Log(ctx).Warnf("[retry] %s attempt %d failed: %v", operation, attempt, err)
+
+				// Full jitter
+				wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(wait):
+				}
+				if backoff *= 2; backoff > cfg.maxBackoff {
+					backoff = cfg.maxBackoff
+				}
+			}
+		}
+	}
+}
+
+// discard releases the reply of a failed attempt.
+func discard(reply interface{}) {
+	switch r := reply.(type) {
+	case *http.Response:
+		if r != nil && r.Body != nil {
+			r.Body.Close()
+		}
+	case io.Closer:
+		r.Close()
+	}
+}
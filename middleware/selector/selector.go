@@ -0,0 +1,124 @@
+package selector
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strings"
+
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// MatchFunc reports whether the middlewares apply to the operation of ctx.
+type MatchFunc func(ctx context.Context, operation string) bool
+
+// Builder builds a middleware applying middlewares to the matching
+// operations only.
+type Builder struct {
+	client   bool
+	ms       []middleware.Middleware
+	patterns []string
+	prefixes []string
+	regexps  []*regexp.Regexp
+	funcs    []MatchFunc
+}
+
+// Server returns a Builder selecting the operations of server middlewares.
+func Server(ms ...middleware.Middleware) *Builder {
+	return &Builder{ms: ms}
+}
+
+// Client returns a Builder selecting the operations of client middlewares.
+func Client(ms ...middleware.Middleware) *Builder {
+	return &Builder{client: true, ms: ms}
+}
+
+// Path selects the operations matching path.Match patterns, e.g.
+// "/api/admin/*".
+func (b *Builder) Path(patterns ...string) *Builder {
+	b.patterns = append(b.patterns, patterns...)
+	return b
+}
+
+// Prefix selects the operations starting with prefixes, e.g. "/api/admin/"
+// for the whole subtree.
+func (b *Builder) Prefix(prefixes ...string) *Builder {
+	b.prefixes = append(b.prefixes, prefixes...)
+	return b
+}
+
+// Regex selects the operations matching regular expressions. It panics if
+// an expression doesn't compile.
+func (b *Builder) Regex(exprs ...string) *Builder {
+	for _, expr := range exprs {
+		b.regexps = append(b.regexps, regexp.MustCompile(expr))
+	}
+	return b
+}
+
+// Func selects the operations fn matches.
+func (b *Builder) Func(fn MatchFunc) *Builder {
+	b.funcs = append(b.funcs, fn)
+	return b
+}
+
+// Match selects the operations matching path.Match patterns and builds the
+// middleware, e.g. selector.Server(jwt.Server()).Match("/api/admin/*").
+func (b *Builder) Match(patterns ...string) middleware.Middleware {
+	return b.Path(patterns...).Build()
+}
+
+// Build builds the middleware. The middlewares apply to the operations
+// selected by any of the rules, the other operations skip them.
+func (b *Builder) Build() middleware.Middleware {
+	chain := middleware.Chain(b.ms...)
+	return func(next middleware.Handler) middleware.Handler {
+		selected := chain(next)
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			if b.match(ctx) {
+				return selected(ctx, req)
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// match reports whether the operation of ctx is selected.
+func (b *Builder) match(ctx context.Context) bool {
+	var (
+		tr transport.Transporter
+		ok bool
+	)
+	if b.client {
+		tr, ok = transport.FromClientContext(ctx)
+	} else {
+		tr, ok = transport.FromServerContext(ctx)
+	}
+	if !ok {
+		return false
+	}
+
+	operation := tr.Operation()
+	for _, p := range b.patterns {
+		if ok, _ := path.Match(p, operation); ok {
+			return true
+		}
+	}
+	for _, p := range b.prefixes {
+		if strings.HasPrefix(operation, p) {
+			return true
+		}
+	}
+	for _, re := range b.regexps {
+		if re.MatchString(operation) {
+			return true
+		}
+	}
+	for _, fn := range b.funcs {
+		if fn(ctx, operation) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"new-milli/cache"
+	"new-milli/middleware"
+)
+
+// Option is session option.
+type Option func(*options)
+
+// options is session options.
+type options struct {
+	cookieName string
+	path       string
+	domain     string
+	maxAge     time.Duration
+	rolling    bool
+	secure     bool
+	httpOnly   bool
+	sameSite   protocol.CookieSameSite
+	prefix     string
+}
+
+// WithCookieName returns an Option that sets the name of the session cookie,
+// "session_id" by default.
+func WithCookieName(name string) Option {
+	return func(o *options) {
+		o.cookieName = name
+	}
+}
+
+// WithPath returns an Option that sets the path of the session cookie, "/"
+// by default.
+func WithPath(path string) Option {
+	return func(o *options) {
+		o.path = path
+	}
+}
+
+// WithDomain returns an Option that sets the domain of the session cookie.
+func WithDomain(domain string) Option {
+	return func(o *options) {
+		o.domain = domain
+	}
+}
+
+// WithMaxAge returns an Option that sets the lifetime of the sessions, 24
+// hours by default. With rolling expiration it is counted from the last
+// request.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithRolling returns an Option that sets whether each request extends the
+// session by its max age, true by default. Without it the sessions expire
+// their max age after their creation.
+func WithRolling(rolling bool) Option {
+	return func(o *options) {
+		o.rolling = rolling
+	}
+}
+
+// WithSecure returns an Option that sets whether the session cookie is only
+// sent over HTTPS, true by default. Disable it for local development only.
+func WithSecure(secure bool) Option {
+	return func(o *options) {
+		o.secure = secure
+	}
+}
+
+// WithHTTPOnly returns an Option that sets whether the session cookie is
+// hidden from scripts, true by default.
+func WithHTTPOnly(httpOnly bool) Option {
+	return func(o *options) {
+		o.httpOnly = httpOnly
+	}
+}
+
+// WithSameSite returns an Option that sets the SameSite attribute of the
+// session cookie, protocol.CookieSameSiteLaxMode by default.
+func WithSameSite(sameSite protocol.CookieSameSite) Option {
+	return func(o *options) {
+		o.sameSite = sameSite
+	}
+}
+
+// WithPrefix returns an Option that sets the prefix of the store keys,
+// "session:" by default.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// Handler returns a Hertz handler loading the session of the client from
+// store, e.g. cache.NewRedis or cache.NewMemory, by the id in its cookie.
+// The session is available to the following handlers with FromContext and
+// saved once they return. New sessions are only saved, and their cookie
+// set, once they hold data.
+func Handler(store cache.Store, opts ...Option) app.HandlerFunc {
+	o := options{
+		cookieName: "session_id",
+		path:       "/",
+		maxAge:     24 * time.Hour,
+		rolling:    true,
+		secure:     true,
+		httpOnly:   true,
+		sameSite:   protocol.CookieSameSiteLaxMode,
+		prefix:     "session:",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		s := o.load(ctx, store, string(c.Cookie(o.cookieName)))
+		c.Next(NewContext(ctx, s))
+		o.save(context.WithoutCancel(ctx), store, c, s)
+	}
+}
+
+// load loads a session, or creates one when it is missing or expired.
+func (o *options) load(ctx context.Context, store cache.Store, id string) *Session {
+	if id == "" {
+		return newSession()
+	}
+	data, err := store.Get(ctx, o.prefix+id)
+	if err != nil {
+		if !errors.Is(err, cache.ErrNotFound) {
+			middleware.Log(ctx).Warnf("Failed to load session: %v", err)
+		}
+		return newSession()
+	}
+
+	s := &Session{id: id}
+	if err := json.Unmarshal(data, &s.record); err != nil {
+		middleware.Log(ctx).Warnf("Failed to decode session: %v", err)
+		return newSession()
+	}
+	if o.ttl(s) <= 0 {
+		return newSession()
+	}
+	return s
+}
+
+// ttl returns the remaining lifetime of a session.
+func (o *options) ttl(s *Session) time.Duration {
+	if o.rolling {
+		return o.maxAge
+	}
+	return time.Until(s.record.Created.Add(o.maxAge))
+}
+
+// save saves a session and sets its cookie.
+func (o *options) save(ctx context.Context, store cache.Store, c *app.RequestContext, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.oldID != "" || s.destroyed {
+		old := s.oldID
+		if old == "" && !s.isNew {
+			old = s.id
+		}
+		if old != "" {
+			if err := store.Delete(ctx, o.prefix+old); err != nil {
+				middleware.Log(ctx).Warnf("Failed to delete session: %v", err)
+			}
+		}
+	}
+	if s.destroyed {
+		if !s.isNew || s.oldID != "" {
+			o.setCookie(c, "", 0)
+		}
+		return
+	}
+	if !s.changed && (s.isNew || !o.rolling) {
+		return
+	}
+
+	ttl := o.ttl(s)
+	data, err := json.Marshal(&s.record)
+	if err == nil {
+		err = store.Set(ctx, o.prefix+s.id, data, ttl)
+	}
+	if err != nil {
+		middleware.Log(ctx).Errorf("Failed to save session: %v", err)
+		return
+	}
+	o.setCookie(c, s.id, ttl)
+}
+
+// setCookie sets the session cookie, expiring after maxAge, or deletes it
+// when maxAge is 0.
+func (o *options) setCookie(c *app.RequestContext, value string, maxAge time.Duration) {
+	cookie := protocol.AcquireCookie()
+	defer protocol.ReleaseCookie(cookie)
+	cookie.SetKey(o.cookieName)
+	cookie.SetValue(value)
+	if maxAge > 0 {
+		cookie.SetMaxAge(int(maxAge / time.Second))
+	} else {
+		cookie.SetExpire(protocol.CookieExpireDelete)
+	}
+	cookie.SetPath(o.path)
+	cookie.SetDomain(o.domain)
+	cookie.SetSecure(o.secure)
+	cookie.SetHTTPOnly(o.httpOnly)
+	cookie.SetSameSite(o.sameSite)
+	c.Response.Header.SetCookie(cookie)
+}
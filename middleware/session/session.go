@@ -0,0 +1,185 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Get when a key isn't in the session.
+var ErrNotFound = errors.New("session: key not found")
+
+// Session is the session of a client, loaded from the store by Handler and
+// saved once the request is handled if it changed, or on every request with
+// rolling expiration. The values are encoded as JSON.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	record    record
+	isNew     bool
+	changed   bool
+	destroyed bool
+	oldID     string // the id replaced by Regenerate
+}
+
+// record is the stored state of a session.
+type record struct {
+	Values  map[string]json.RawMessage `json:"values,omitempty"`
+	Flashes []string                   `json:"flashes,omitempty"`
+	Created time.Time                  `json:"created"`
+}
+
+// newSession creates a new empty session.
+func newSession() *Session {
+	return &Session{
+		id:     newID(),
+		record: record{Created: time.Now()},
+		isNew:  true,
+	}
+}
+
+// newID returns a random session id.
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ID returns the id of the session.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session was created by the request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// Created returns the creation time of the session.
+func (s *Session) Created() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.Created
+}
+
+// Get decodes the value of key into v, it returns ErrNotFound when the
+// key isn't set.
+func (s *Session) Get(key string, v interface{}) error {
+	s.mu.Lock()
+	data, ok := s.record.Values[key]
+	s.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+// GetString returns the string value of key, empty when it isn't set.
+func (s *Session) GetString(key string) string {
+	var v string
+	_ = s.Get(key, &v)
+	return v
+}
+
+// Set sets the value of key.
+func (s *Session) Set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record.Values == nil {
+		s.record.Values = make(map[string]json.RawMessage)
+	}
+	s.record.Values[key] = data
+	s.changed = true
+	return nil
+}
+
+// Delete deletes keys.
+func (s *Session) Delete(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := s.record.Values[key]; ok {
+			delete(s.record.Values, key)
+			s.changed = true
+		}
+	}
+}
+
+// Clear deletes all the values and flashes.
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Values = nil
+	s.record.Flashes = nil
+	s.changed = true
+}
+
+// AddFlash adds a flash message, kept until it is read with Flashes, e.g.
+// to show the outcome of a form after a redirect.
+func (s *Session) AddFlash(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Flashes = append(s.record.Flashes, msg)
+	s.changed = true
+}
+
+// Flashes returns the flash messages and removes them from the session.
+func (s *Session) Flashes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.record.Flashes
+	if len(flashes) > 0 {
+		s.record.Flashes = nil
+		s.changed = true
+	}
+	return flashes
+}
+
+// Regenerate gives the session a new id keeping its values, the old one is
+// deleted. Call it when the privileges of the client change, e.g. on login,
+// to prevent session fixation.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.changed = true
+}
+
+// Destroy deletes the session and expires its cookie, e.g. on logout.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// sessionKey is the key of the session in the context.
+type sessionKey struct{}
+
+// NewContext returns a copy of ctx carrying s.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// FromContext returns the session set by Handler, or nil.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
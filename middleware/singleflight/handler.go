@@ -0,0 +1,113 @@
+package singleflight
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/prometheus/client_golang/prometheus"
+	sf "golang.org/x/sync/singleflight"
+)
+
+// response is a response shared by the collapsed HTTP requests.
+type response struct {
+	status int
+	header [][2]string
+	body   []byte
+	stream bool
+}
+
+// Handler returns a Hertz handler collapsing the concurrent identical
+// requests of the routes it is added to, so a thundering herd hits the
+// following handlers once. The requests are keyed by method, path,
+// normalized query string, body and vary headers; those arriving while one
+// with the same key is handled wait for it and get a copy of its response.
+// Streamed responses can't be shared, the waiting requests are then
+// handled. Add it to idempotent read routes only:
+//
+//	h.GET("/products", singleflight.Handler(), listProducts)
+//
+// The key options are ignored.
+func Handler(opts ...Option) app.HandlerFunc {
+	cfg := options{
+		vary:     DefaultVaryHeaders,
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(ctx context.Context, c *app.RequestContext) {
+			c.Next(ctx)
+		}
+	}
+
+	counter := newCounter(cfg.registry)
+	var group sf.Group
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		executed := false
+		v, _, _ := group.Do(requestKey(c, cfg.vary), func() (interface{}, error) {
+			executed = true
+			c.Next(ctx)
+			return capture(c), nil
+		})
+		observe(counter, "http", c.FullPath(), !executed)
+		if executed {
+			return
+		}
+
+		res := v.(*response)
+		if res.stream {
+			c.Next(ctx)
+			return
+		}
+		c.Abort()
+		for _, h := range res.header {
+			c.Response.Header.Set(h[0], h[1])
+		}
+		c.Response.SetStatusCode(res.status)
+		c.Response.SetBody(res.body)
+	}
+}
+
+// requestKey returns the key of a request.
+func requestKey(c *app.RequestContext, vary []string) string {
+	query, err := url.ParseQuery(string(c.QueryArgs().QueryString()))
+	if err != nil {
+		query = nil
+	}
+
+	var b bytes.Buffer
+	b.Write(c.Method())
+	b.WriteByte(' ')
+	b.Write(c.Path())
+	b.WriteByte('?')
+	b.WriteString(query.Encode())
+	b.WriteByte(0)
+	b.WriteString(hash(c.Request.Body()))
+	b.WriteString(varyKey(vary, func(key string) string {
+		return string(c.GetHeader(key))
+	}))
+	return b.String()
+}
+
+// capture copies the response of a request.
+func capture(c *app.RequestContext) *response {
+	if c.Response.IsBodyStream() || c.Response.GetHijackWriter() != nil {
+		return &response{stream: true}
+	}
+	res := &response{
+		status: c.Response.StatusCode(),
+		body:   append([]byte(nil), c.Response.Body()...),
+	}
+	c.Response.Header.VisitAll(func(k, v []byte) {
+		if string(k) != "Content-Length" {
+			res.header = append(res.header, [2]string{string(k), string(v)})
+		}
+	})
+	res.header = append(res.header, [2]string{"Content-Type", string(c.Response.Header.ContentType())})
+	return res
+}
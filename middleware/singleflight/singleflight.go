@@ -0,0 +1,203 @@
+package singleflight
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sf "golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+	"new-milli/collector"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// KeyFunc returns the key of a request, requests sharing a key are
+// collapsed. It returns false for the requests that must not be collapsed.
+type KeyFunc func(ctx context.Context, operation string, req interface{}) (string, bool)
+
+// Option is singleflight option.
+type Option func(*options)
+
+// options is singleflight options.
+type options struct {
+	disabled bool
+	keyFunc  KeyFunc
+	vary     []string
+	registry prometheus.Registerer
+}
+
+// WithDisabled returns an Option that disables the middleware.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithKeyFunc returns an Option that sets the key of the requests, DefaultKey
+// by default. The vary headers are appended to it.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(o *options) {
+		o.keyFunc = fn
+	}
+}
+
+// WithVaryHeaders returns an Option that sets the request headers the key
+// depends on, DefaultVaryHeaders by default, so callers with different
+// credentials or formats never share a response.
+func WithVaryHeaders(headers ...string) Option {
+	return func(o *options) {
+		o.vary = headers
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the singleflight
+// metrics, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// DefaultVaryHeaders is the request headers the keys depend on by default.
+var DefaultVaryHeaders = []string{"Authorization", "Cookie", "Accept", "Accept-Encoding"}
+
+// DefaultKey keys the requests by operation and request, encoded
+// deterministically: protobuf for proto messages, JSON otherwise. Requests
+// without a body, such as those of the HTTP server middlewares, aren't
+// collapsed, use Handler on the HTTP routes instead.
+func DefaultKey(ctx context.Context, operation string, req interface{}) (string, bool) {
+	if req == nil {
+		return "", false
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if m, ok := req.(proto.Message); ok {
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	} else {
+		data, err = json.Marshal(req)
+	}
+	if err != nil {
+		return "", false
+	}
+	return operation + ":" + hash(data), true
+}
+
+// hash returns the hex SHA-256 of data.
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// varyKey returns the part of a key depending on the vary headers.
+func varyKey(vary []string, get func(key string) string) string {
+	var b strings.Builder
+	for _, h := range vary {
+		b.WriteString("\x00")
+		b.WriteString(get(h))
+	}
+	return b.String()
+}
+
+// newCounter creates the singleflight counter, reusing the registered one.
+func newCounter(registry prometheus.Registerer) *prometheus.CounterVec {
+	if registry == nil {
+		return nil
+	}
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "new_milli",
+		Subsystem: "singleflight",
+		Name:      "requests_total",
+		Help:      "Total number of collapsible requests, by result: miss when executed, hit when sharing the response of a concurrent request.",
+	}, []string{"kind", "operation", "result"})
+	counter, err := collector.Register(registry, counter)
+	if err != nil {
+		middleware.Log(context.Background()).Warnf("Failed to register singleflight metrics: %v", err)
+		return nil
+	}
+	return counter
+}
+
+// observe counts a collapsible request.
+func observe(counter *prometheus.CounterVec, kind, operation string, shared bool) {
+	if counter == nil {
+		return
+	}
+	result := "miss"
+	if shared {
+		result = "hit"
+	}
+	counter.WithLabelValues(kind, operation, result).Inc()
+}
+
+// Server returns a middleware collapsing the concurrent identical requests,
+// so a thundering herd hits the handler once: the requests arriving while
+// one with the same key is handled wait for it and get its reply and
+// error. The reply is shared, the handlers must not modify it. Apply it to
+// idempotent read operations only, e.g. with the selector middleware.
+func Server(opts ...Option) middleware.Middleware {
+	return newMiddleware(false, opts)
+}
+
+// Client returns a middleware collapsing the concurrent identical calls,
+// see Server.
+func Client(opts ...Option) middleware.Middleware {
+	return newMiddleware(true, opts)
+}
+
+// newMiddleware creates the server or client middleware.
+func newMiddleware(client bool, opts []Option) middleware.Middleware {
+	cfg := options{
+		keyFunc:  DefaultKey,
+		vary:     DefaultVaryHeaders,
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	counter := newCounter(cfg.registry)
+	var group sf.Group
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			var (
+				tr transport.Transporter
+				ok bool
+			)
+			if client {
+				tr, ok = transport.FromClientContext(ctx)
+			} else {
+				tr, ok = transport.FromServerContext(ctx)
+			}
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			key, ok := cfg.keyFunc(ctx, tr.Operation(), req)
+			if !ok {
+				return handler(ctx, req)
+			}
+			key = tr.Kind().String() + ":" + key + varyKey(cfg.vary, tr.RequestHeader().Get)
+
+			executed := false
+			reply, err, _ := group.Do(key, func() (interface{}, error) {
+				executed = true
+				return handler(ctx, req)
+			})
+			observe(counter, tr.Kind().String(), tr.Operation(), !executed)
+			return reply, err
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// HeaderTimeout is the header carrying the remaining time of the caller in milliseconds.
+const HeaderTimeout = "X-Request-Timeout"
+
+// Error is returned when an operation runs out of time.
+type Error struct {
+	// Operation is the operation that timed out.
+	Operation string
+	// Duration is the time the operation was given.
+	Duration time.Duration
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %s timed out after %s", e.Operation, e.Duration)
+}
+
+// Unwrap returns context.DeadlineExceeded so errors.Is keeps working.
+func (e *Error) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Timeout reports that the error is a timeout.
+func (e *Error) Timeout() bool {
+	return true
+}
+
+// Retryable reports that another attempt would run out of time as well.
+func (e *Error) Retryable() bool {
+	return false
+}
+
+// Option is timeout option.
+type Option func(*options)
+
+// rule is the timeout of operations matching a pattern.
+type rule struct {
+	pattern string
+	timeout time.Duration
+}
+
+// options is timeout options.
+type options struct {
+	disabled  bool
+	timeout   time.Duration
+	rules     []rule
+	propagate bool
+	registry  prometheus.Registerer
+}
+
+// WithDisabled returns an Option that disables the timeout.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithTimeout returns an Option that sets the timeout of operations without a rule,
+// 0 leaves them unbounded.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithOperation returns an Option that sets the timeout of operations matching
+// a path.Match pattern, e.g. "/api/v1/reports/*". The first matching rule wins.
+func WithOperation(pattern string, timeout time.Duration) Option {
+	return func(o *options) {
+		o.rules = append(o.rules, rule{pattern: pattern, timeout: timeout})
+	}
+}
+
+// WithPropagation returns an Option that sets whether the remaining time is
+// read from and sent in the HeaderTimeout header.
+func WithPropagation(propagate bool) Option {
+	return func(o *options) {
+		o.propagate = propagate
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the timeout metrics, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// lookup returns the timeout of an operation.
+func (o *options) lookup(operation string) time.Duration {
+	for _, r := range o.rules {
+		if ok, _ := path.Match(r.pattern, operation); ok {
+			return r.timeout
+		}
+	}
+	return o.timeout
+}
+
+// newCounter creates the timeout counter of a side, reusing the registered one.
+func newCounter(registry prometheus.Registerer, subsystem string) *prometheus.CounterVec {
+	if registry == nil {
+		return nil
+	}
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "new_milli",
+		Subsystem: subsystem,
+		Name:      "timeouts_total",
+		Help:      "Total number of requests that timed out.",
+	}, []string{"kind", "operation"})
+	counter, err := collector.Register(registry, counter)
+	if err != nil {
+		middleware.Log(context.Background()).Warnf("Failed to register timeout metrics: %v", err)
+		return nil
+	}
+	return counter
+}
+
+// Server returns a middleware that bounds the handling time of operations.
+// The deadline sent by the caller is honored when it is shorter.
+func Server(opts ...Option) middleware.Middleware {
+	cfg := options{
+		timeout:   time.Second * 5,
+		propagate: true,
+		registry:  prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	timeouts := newCounter(cfg.registry, "server")
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			var kind, operation string
+			tr, ok := transport.FromServerContext(ctx)
+			if ok {
+				kind = tr.Kind().String()
+				operation = tr.Operation()
+			}
+
+			timeout := cfg.lookup(operation)
+			if cfg.propagate && ok {
+				if ms, err := strconv.ParseInt(tr.RequestHeader().Get(HeaderTimeout), 10, 64); err == nil && ms > 0 {
+					if caller := time.Duration(ms) * time.Millisecond; timeout <= 0 || caller < timeout {
+						timeout = caller
+					}
+				}
+			}
+			if timeout <= 0 {
+				return handler(ctx, req)
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			reply, err := handler(ctx, req)
+			if err != nil && (errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded) {
+				if timeouts != nil {
+					timeouts.WithLabelValues(kind, operation).Inc()
+				}
+				middleware.Log(ctx).Warnf("[%s] server %s timed out after %s", kind, operation, timeout)
+				return reply, &Error{Operation: operation, Duration: timeout}
+			}
+			return reply, err
+		}
+	}
+}
+
+// Client returns a middleware that bounds the time of calls and sends the
+// remaining time to the server.
+func Client(opts ...Option) middleware.Middleware {
+	cfg := options{
+		propagate: true,
+		registry:  prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	timeouts := newCounter(cfg.registry, "client")
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			var kind, operation string
+			tr, ok := transport.FromClientContext(ctx)
+			if ok {
+				kind = tr.Kind().String()
+				operation = tr.Operation()
+			}
+
+			timeout := cfg.lookup(operation)
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			if deadline, has := ctx.Deadline(); has {
+				remaining := time.Until(deadline)
+				if timeout <= 0 {
+					// Bounded by the deadline of the caller
+					timeout = remaining
+				}
+				if remaining <= 0 {
+					return nil, &Error{Operation: operation, Duration: timeout}
+				}
+				if cfg.propagate && ok {
+					tr.RequestHeader().Set(HeaderTimeout, strconv.FormatInt(remaining.Milliseconds(), 10))
+				}
+			}
+
+			reply, err := handler(ctx, req)
+			if err != nil && (errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded) {
+				if timeouts != nil {
+					timeouts.WithLabelValues(kind, operation).Inc()
+				}
+				return reply, &Error{Operation: operation, Duration: timeout}
+			}
+			return reply, err
+		}
+	}
+}
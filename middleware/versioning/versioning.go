@@ -0,0 +1,305 @@
+package versioning
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+var (
+	// ErrUnsupportedVersion is returned when the requested version is not registered.
+	ErrUnsupportedVersion = transport.NewStatusError(http.StatusBadRequest, "UNSUPPORTED_VERSION", "unsupported api version")
+	// ErrVersionSunset is returned when the requested version is past its sunset date.
+	ErrVersionSunset = transport.NewStatusError(http.StatusGone, "VERSION_SUNSET", "api version has been sunset")
+)
+
+// HeaderVersion is the header carrying the negotiated version in requests and replies.
+const HeaderVersion = "X-Api-Version"
+
+// Extractor returns the version requested by the caller, or "" if it did not request one.
+type Extractor func(ctx context.Context) string
+
+// FromPathPrefix extracts the version from the first operation segment, e.g. "/v2/users" is "v2".
+func FromPathPrefix() Extractor {
+	return func(ctx context.Context) string {
+		tr, ok := transport.FromServerContext(ctx)
+		if !ok {
+			return ""
+		}
+		segment := strings.TrimPrefix(tr.Operation(), "/")
+		if i := strings.IndexByte(segment, '/'); i >= 0 {
+			segment = segment[:i]
+		}
+		if isVersion(segment) {
+			return normalize(segment)
+		}
+		return ""
+	}
+}
+
+// FromHeader extracts the version from a request header, e.g. "X-Api-Version: v2".
+func FromHeader(name string) Extractor {
+	return func(ctx context.Context) string {
+		tr, ok := transport.FromServerContext(ctx)
+		if !ok {
+			return ""
+		}
+		return normalize(tr.RequestHeader().Get(name))
+	}
+}
+
+// FromMediaType extracts the version from the Accept header, either from a vendor
+// media type such as "application/vnd.acme.v2+json" or a version parameter
+// such as "application/json; version=2".
+func FromMediaType() Extractor {
+	return func(ctx context.Context) string {
+		tr, ok := transport.FromServerContext(ctx)
+		if !ok {
+			return ""
+		}
+		for _, accept := range strings.Split(tr.RequestHeader().Get("Accept"), ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+			if err != nil {
+				continue
+			}
+			if v, ok := params["version"]; ok {
+				return normalize(v)
+			}
+			// application/vnd.<vendor>.<version>+json
+			sub := mediaType[strings.IndexByte(mediaType, '/')+1:]
+			if !strings.HasPrefix(sub, "vnd.") {
+				continue
+			}
+			if i := strings.IndexByte(sub, '+'); i >= 0 {
+				sub = sub[:i]
+			}
+			if v := sub[strings.LastIndexByte(sub, '.')+1:]; isVersion(v) {
+				return normalize(v)
+			}
+		}
+		return ""
+	}
+}
+
+// Version is a supported API version and its policy.
+type Version struct {
+	// Name is the version, e.g. "v2".
+	Name string
+	// Deprecated marks the version as deprecated, replies carry a Deprecation header.
+	Deprecated bool
+	// Sunset is when the version stops being served, replies carry a Sunset header
+	// and requests after it fail with ErrVersionSunset.
+	Sunset time.Time
+	// Link points to the migration guide, sent as a deprecation Link header.
+	Link string
+	// Middleware applies only to requests of this version.
+	Middleware []middleware.Middleware
+	// Handler serves the requests of this version instead of the next handler if set.
+	Handler middleware.Handler
+}
+
+// Option is versioning option.
+type Option func(*options)
+
+// options is versioning options.
+type options struct {
+	disabled       bool
+	extractors     []Extractor
+	defaultVersion string
+	versions       []Version
+	registry       prometheus.Registerer
+	namespace      string
+}
+
+// WithDisabled returns an Option that disables versioning.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithExtractors returns an Option that sets the version extractors, the first non-empty result wins.
+func WithExtractors(extractors ...Extractor) Option {
+	return func(o *options) {
+		o.extractors = extractors
+	}
+}
+
+// WithDefault returns an Option that sets the version of requests without one.
+func WithDefault(version string) Option {
+	return func(o *options) {
+		o.defaultVersion = normalize(version)
+	}
+}
+
+// WithVersions returns an Option that registers the supported versions.
+func WithVersions(versions ...Version) Option {
+	return func(o *options) {
+		o.versions = append(o.versions, versions...)
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the version metrics, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// WithNamespace returns an Option that sets the namespace of the version metrics.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+type versionKey struct{}
+
+// NewContext returns a new Context that carries the negotiated version.
+func NewContext(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// FromContext returns the negotiated version stored in ctx, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	version, ok := ctx.Value(versionKey{}).(string)
+	return version, ok
+}
+
+// compiled is a registered version ready to serve requests.
+type compiled struct {
+	Version
+	sunset string
+	chain  middleware.Middleware
+}
+
+// Server returns a middleware that negotiates the API version of requests.
+// Requests without a version use the default version, or the latest registered one.
+func Server(opts ...Option) middleware.Middleware {
+	cfg := options{
+		extractors: []Extractor{FromPathPrefix(), FromHeader(HeaderVersion), FromMediaType()},
+		registry:   prometheus.DefaultRegisterer,
+		namespace:  "new_milli",
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled || len(cfg.versions) == 0 {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	versions := make(map[string]*compiled, len(cfg.versions))
+	for _, v := range cfg.versions {
+		v.Name = normalize(v.Name)
+		c := &compiled{
+			Version: v,
+			chain:   middleware.Chain(v.Middleware...),
+		}
+		if !v.Sunset.IsZero() {
+			c.sunset = v.Sunset.UTC().Format(http.TimeFormat)
+		}
+		versions[v.Name] = c
+	}
+	if cfg.defaultVersion == "" {
+		cfg.defaultVersion = normalize(cfg.versions[len(cfg.versions)-1].Name)
+	}
+
+	var requests *prometheus.CounterVec
+	if cfg.registry != nil {
+		counter := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: cfg.namespace,
+				Subsystem: "server",
+				Name:      "api_version_requests_total",
+				Help:      "Total number of requests per API version.",
+			},
+			[]string{"version", "deprecated"},
+		)
+		// The middleware may be built more than once with the same registry
+		var err error
+		if requests, err = collector.Register(cfg.registry, counter); err != nil {
+			middleware.Log(context.Background()).Warnf("Failed to register api version metrics: %v", err)
+			requests = nil
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			name := cfg.defaultVersion
+			for _, extract := range cfg.extractors {
+				if v := extract(ctx); v != "" {
+					name = v
+					break
+				}
+			}
+
+			v, ok := versions[name]
+			if !ok {
+				return nil, ErrUnsupportedVersion
+			}
+			if !v.Sunset.IsZero() && time.Now().After(v.Sunset) {
+				return nil, ErrVersionSunset
+			}
+
+			deprecated := v.Deprecated || !v.Sunset.IsZero()
+			if requests != nil {
+				requests.WithLabelValues(v.Name, boolLabel(deprecated)).Inc()
+			}
+
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				tr.ReplyHeader().Set(HeaderVersion, v.Name)
+				if deprecated {
+					middleware.Log(ctx).Warnf("Deprecated api version %s called: %s", v.Name, tr.Operation())
+					tr.ReplyHeader().Set("Deprecation", "true")
+					if v.sunset != "" {
+						tr.ReplyHeader().Set("Sunset", v.sunset)
+					}
+					if v.Link != "" {
+						tr.ReplyHeader().Set("Link", "<"+v.Link+">; rel=\"deprecation\"")
+					}
+				}
+			}
+
+			next := handler
+			if v.Handler != nil {
+				next = v.Handler
+			}
+			return v.chain(next)(NewContext(ctx, v.Name), req)
+		}
+	}
+}
+
+// normalize converts "2", "V2" and "v2" into "v2".
+func normalize(version string) string {
+	version = strings.ToLower(strings.TrimSpace(version))
+	if version == "" {
+		return ""
+	}
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return version
+}
+
+// isVersion reports whether s looks like a version such as "v2" or "v1beta1".
+func isVersion(s string) bool {
+	return len(s) > 1 && (s[0] == 'v' || s[0] == 'V') && s[1] >= '0' && s[1] <= '9'
+}
+
+// boolLabel returns the metric label of b.
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
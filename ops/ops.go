@@ -0,0 +1,201 @@
+package ops
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/adaptor"
+	"github.com/cloudwego/hertz/pkg/route"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/middleware/metrics"
+)
+
+// HealthCheck reports whether a dependency of the service is healthy.
+type HealthCheck func(ctx context.Context) error
+
+// Option is ops mux option.
+type Option func(*options)
+
+// options is ops mux options.
+type options struct {
+	prefix   string
+	pprof    bool
+	gatherer prometheus.Gatherer
+	version  string
+	checks   map[string]HealthCheck
+}
+
+// WithPrefix returns an Option that mounts the endpoints under prefix, e.g.
+// "/ops".
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithPprof returns an Option that enables the /debug/pprof endpoints,
+// disabled by default as profiles expose the internals of the service.
+func WithPprof(enabled bool) Option {
+	return func(o *options) {
+		o.pprof = enabled
+	}
+}
+
+// WithGatherer returns an Option that sets the gatherer of /metrics,
+// prometheus.DefaultGatherer by default.
+func WithGatherer(gatherer prometheus.Gatherer) Option {
+	return func(o *options) {
+		o.gatherer = gatherer
+	}
+}
+
+// WithVersion returns an Option that sets the service version reported by
+// /buildinfo, the main module version by default.
+func WithVersion(version string) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
+// WithHealthCheck returns an Option that adds a check to /healthz, which
+// responds with 503 and the failed checks while any of them returns an
+// error.
+func WithHealthCheck(name string, check HealthCheck) Option {
+	return func(o *options) {
+		o.checks[name] = check
+	}
+}
+
+// Register registers the ops endpoints on r, e.g. a Hertz server:
+//
+//   - /metrics: Prometheus metrics
+//   - /buildinfo: version, Go version and VCS revision
+//   - /healthz: health checks
+//   - /debug/pprof/*: profiles, with WithPprof
+func Register(r route.IRouter, opts ...Option) {
+	o := options{
+		gatherer: prometheus.DefaultGatherer,
+		checks:   make(map[string]HealthCheck),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	g := r.Group(o.prefix)
+	g.GET("/metrics", metrics.HandlerFor(o.gatherer))
+	g.GET("/buildinfo", buildInfoHandler(o.version))
+	g.GET("/healthz", healthHandler(o.checks))
+	if o.pprof {
+		g.GET("/debug/pprof/", wrap(http.HandlerFunc(pprof.Index)))
+		g.GET("/debug/pprof/:name", pprofHandler())
+	}
+}
+
+// BuildInfo is the build information of the service.
+type BuildInfo struct {
+	Version     string `json:"version"`
+	GoVersion   string `json:"go_version"`
+	Path        string `json:"path,omitempty"`
+	VCS         string `json:"vcs,omitempty"`
+	VCSRevision string `json:"vcs_revision,omitempty"`
+	VCSTime     string `json:"vcs_time,omitempty"`
+	VCSModified bool   `json:"vcs_modified,omitempty"`
+}
+
+// ReadBuildInfo returns the build information embedded in the binary, with
+// version as the version unless empty.
+func ReadBuildInfo(version string) BuildInfo {
+	info := BuildInfo{Version: version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Path = bi.Main.Path
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs":
+			info.VCS = s.Value
+		case "vcs.revision":
+			info.VCSRevision = s.Value
+		case "vcs.time":
+			info.VCSTime = s.Value
+		case "vcs.modified":
+			info.VCSModified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// buildInfoHandler returns the handler of /buildinfo.
+func buildInfoHandler(version string) app.HandlerFunc {
+	var (
+		once sync.Once
+		info BuildInfo
+	)
+	return func(ctx context.Context, c *app.RequestContext) {
+		once.Do(func() { info = ReadBuildInfo(version) })
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+// healthHandler returns the handler of /healthz.
+func healthHandler(checks map[string]HealthCheck) app.HandlerFunc {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		failures := make(map[string]string)
+		for _, name := range names {
+			if err := checks[name](ctx); err != nil {
+				failures[name] = err.Error()
+			}
+		}
+		if len(failures) > 0 {
+			c.JSON(http.StatusServiceUnavailable, failures)
+			return
+		}
+		c.String(http.StatusOK, "OK")
+	}
+}
+
+// pprofHandler returns the handler of the named profiles.
+func pprofHandler() app.HandlerFunc {
+	handlers := map[string]app.HandlerFunc{
+		"cmdline": wrap(http.HandlerFunc(pprof.Cmdline)),
+		"profile": wrap(http.HandlerFunc(pprof.Profile)),
+		"symbol":  wrap(http.HandlerFunc(pprof.Symbol)),
+		"trace":   wrap(http.HandlerFunc(pprof.Trace)),
+	}
+	return func(ctx context.Context, c *app.RequestContext) {
+		name := c.Param("name")
+		if h, ok := handlers[name]; ok {
+			h(ctx, c)
+			return
+		}
+		wrap(pprof.Handler(name))(ctx, c)
+	}
+}
+
+// wrap adapts a net/http handler to Hertz.
+func wrap(h http.Handler) app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		req, err := adaptor.GetCompatRequest(&c.Request)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		h.ServeHTTP(adaptor.GetCompatResponseWriter(&c.Response), req.WithContext(ctx))
+	}
+}
@@ -2,9 +2,14 @@ package newMilli
 
 import (
 	"context"
+	"net/url"
 	"os"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/broker"
+	"new-milli/connector"
+	"new-milli/registry"
 	"new-milli/transport"
 )
 
@@ -17,15 +22,19 @@ type options struct {
 	name             string
 	version          string
 	metadata         map[string]string
+	endpoints        []*url.URL
 	ctx              context.Context
 	sigs             []os.Signal
+	registrar        registry.Registry
 	registrarTimeout time.Duration
 	stopTimeout      time.Duration
-	servers          []transport.Server
+	servers          []server
 	beforeStart      []func(context.Context) error
 	afterStart       []func(context.Context) error
-	beforeStop       []func(context.Context) error
-	afterStop        []func(context.Context) error
+	beforeStop       []hook
+	afterStop        []hook
+	components       []component
+	metrics          prometheus.Registerer
 }
 
 // ID with service id.
@@ -56,6 +65,14 @@ func Metadata(md map[string]string) Option {
 	}
 }
 
+// Endpoint with service endpoints.
+// By default the endpoints advertised by the servers are used.
+func Endpoint(endpoints ...*url.URL) Option {
+	return func(o *options) {
+		o.endpoints = endpoints
+	}
+}
+
 // Context with service context.
 func Context(ctx context.Context) Option {
 	return func(o *options) {
@@ -70,6 +87,14 @@ func Signal(sigs ...os.Signal) Option {
 	}
 }
 
+// Registrar with service registry.
+// The service is registered after the servers start and deregistered before they stop.
+func Registrar(r registry.Registry) Option {
+	return func(o *options) {
+		o.registrar = r
+	}
+}
+
 // RegistrarTimeout with service registrar timeout.
 func RegistrarTimeout(t time.Duration) Option {
 	return func(o *options) {
@@ -77,6 +102,15 @@ func RegistrarTimeout(t time.Duration) Option {
 	}
 }
 
+// MetricsRegistry with the registry of the job metrics, registered when
+// the job is created. Defaults to prometheus.DefaultRegisterer, nil
+// disables them.
+func MetricsRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.metrics = registry
+	}
+}
+
 // StopTimeout with service stop timeout.
 func StopTimeout(t time.Duration) Option {
 	return func(o *options) {
@@ -84,10 +118,25 @@ func StopTimeout(t time.Duration) Option {
 	}
 }
 
-// Server with transport servers.
+// Server with transport servers, named after their type and index. A
+// failing server stops the application.
 func Server(srv ...transport.Server) Option {
 	return func(o *options) {
-		o.servers = append(o.servers, srv...)
+		for _, s := range srv {
+			o.servers = append(o.servers, server{name: serverName(s, len(o.servers)), srv: s})
+		}
+	}
+}
+
+// NamedServer with a transport server named name in the errors and the
+// ServerStatus, and what the application does when it fails.
+func NamedServer(name string, srv transport.Server, opts ...ServerOption) Option {
+	return func(o *options) {
+		s := server{name: name, srv: srv}
+		for _, opt := range opts {
+			opt(&s)
+		}
+		o.servers = append(o.servers, s)
 	}
 }
 
@@ -105,16 +154,50 @@ func AfterStart(fn func(context.Context) error) Option {
 	}
 }
 
-// BeforeStop with service before stop hooks.
-func BeforeStop(fn func(context.Context) error) Option {
+// BeforeStop with service before stop hooks, ordered by HookPriority and
+// bounded by HookTimeout.
+func BeforeStop(fn func(context.Context) error, opts ...HookOption) Option {
+	return func(o *options) {
+		o.beforeStop = append(o.beforeStop, newHook(fn, opts))
+	}
+}
+
+// AfterStop with service after stop hooks, ordered by HookPriority and
+// bounded by HookTimeout.
+func AfterStop(fn func(context.Context) error, opts ...HookOption) Option {
+	return func(o *options) {
+		o.afterStop = append(o.afterStop, newHook(fn, opts))
+	}
+}
+
+// Component registers a component closed with close once the servers have
+// stopped and the stop hooks have run. Components close one at a time in
+// reverse registration order, after the components declared DependsOn them.
+func Component(name string, close func(context.Context) error, opts ...ComponentOption) Option {
+	return func(o *options) {
+		c := component{name: name, close: close}
+		for _, opt := range opts {
+			opt(&c)
+		}
+		o.components = append(o.components, c)
+	}
+}
+
+// Brokers connects the brokers of r before the servers start, and
+// disconnects them as the "brokers" component once the servers have
+// stopped.
+func Brokers(r *broker.Registry, opts ...ComponentOption) Option {
 	return func(o *options) {
-		o.beforeStop = append(o.beforeStop, fn)
+		o.beforeStart = append(o.beforeStart, r.Connect)
+		Component("brokers", r.Disconnect, opts...)(o)
 	}
 }
 
-// AfterStop with service after stop hooks.
-func AfterStop(fn func(context.Context) error) Option {
+// Connectors connects the connectors of r before the servers start, and
+// closes them as the "connectors" component once the servers have stopped.
+func Connectors(r *connector.Registry, opts ...ComponentOption) Option {
 	return func(o *options) {
-		o.afterStop = append(o.afterStop, fn)
+		o.beforeStart = append(o.beforeStart, r.Connect)
+		Component("connectors", r.Close, opts...)(o)
 	}
 }
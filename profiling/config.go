@@ -0,0 +1,180 @@
+package profiling
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"new-milli/config"
+)
+
+// FromConfig creates the profiler configured under key, e.g.
+//
+//	profiling:
+//	  interval: 10m
+//	  cpu_duration: 10s
+//	  types: [cpu, heap, goroutine]
+//	  labels:
+//	    service: order
+//	  triggers:
+//	    check_interval: 10s
+//	    cooldown: 5m
+//	    cpu: 80
+//	    heap: 1073741824
+//	    goroutines: 10000
+//	    latency: 500ms
+//	    latency_quantile: 0.99
+//	  dir: /var/lib/order/profiles
+//	  max_files: 100
+//	  pyroscope:
+//	    url: http://pyroscope:4040
+//	    app: order
+//	  parca:
+//	    url: http://parca:7070
+//
+// At least one of dir, pyroscope and parca is needed. The labels are read
+// from configs listing their keys, like config.DefaultConfig. opts are
+// applied after the config, e.g. to add an exporter.
+func FromConfig(cfg config.Config, key string, opts ...Option) (*Profiler, error) {
+	var options []Option
+	for name, set := range map[string]func(time.Duration){
+		"interval":                func(d time.Duration) { options = append(options, WithInterval(d)) },
+		"cpu_duration":            func(d time.Duration) { options = append(options, WithCPUDuration(d)) },
+		"triggers.check_interval": func(d time.Duration) { options = append(options, WithCheckInterval(d)) },
+		"triggers.cooldown":       func(d time.Duration) { options = append(options, WithCooldown(d)) },
+	} {
+		if !cfg.Has(key + "." + name) {
+			continue
+		}
+		d, err := config.GetDuration(cfg, key+"."+name)
+		if err != nil {
+			return nil, err
+		}
+		set(d)
+	}
+
+	if cfg.Has(key + ".types") {
+		names, err := cfg.GetStringSlice(key + ".types")
+		if err != nil {
+			return nil, err
+		}
+		types := make([]Type, 0, len(names))
+		for _, name := range names {
+			types = append(types, Type(name))
+		}
+		options = append(options, WithTypes(types...))
+	}
+	labels := make(map[string]string)
+	if lister, ok := cfg.(interface{ Keys() []string }); ok {
+		for _, k := range lister.Keys() {
+			name, ok := strings.CutPrefix(k, key+".labels.")
+			if !ok {
+				continue
+			}
+			value, err := cfg.GetString(k)
+			if err != nil {
+				return nil, err
+			}
+			labels[name] = value
+		}
+	}
+	if len(labels) > 0 {
+		options = append(options, WithLabels(labels))
+	}
+
+	triggers, err := triggerOptions(cfg, key+".triggers")
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, triggers...)
+
+	exporters, err := exporters(cfg, key)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, WithExporter(exporters...))
+	return New(append(options, opts...)...), nil
+}
+
+// triggerOptions returns the options of the triggers configured under key.
+func triggerOptions(cfg config.Config, key string) ([]Option, error) {
+	var options []Option
+	if cfg.Has(key + ".cpu") {
+		percent, err := cfg.GetFloat(key + ".cpu")
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithCPUThreshold(percent))
+	}
+	if cfg.Has(key + ".heap") {
+		bytes, err := cfg.GetInt(key + ".heap")
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithHeapThreshold(uint64(max(bytes, 0))))
+	}
+	if cfg.Has(key + ".goroutines") {
+		n, err := cfg.GetInt(key + ".goroutines")
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, WithGoroutineThreshold(n))
+	}
+	if cfg.Has(key + ".latency") {
+		threshold, err := config.GetDuration(cfg, key+".latency")
+		if err != nil {
+			return nil, err
+		}
+		quantile := 0.99
+		if cfg.Has(key + ".latency_quantile") {
+			if quantile, err = cfg.GetFloat(key + ".latency_quantile"); err != nil {
+				return nil, err
+			}
+		}
+		var minSamples int
+		if cfg.Has(key + ".latency_min_samples") {
+			if minSamples, err = cfg.GetInt(key + ".latency_min_samples"); err != nil {
+				return nil, err
+			}
+		}
+		options = append(options, WithLatencyThreshold(quantile, threshold, minSamples))
+	}
+	return options, nil
+}
+
+// exporters returns the exporters configured under key.
+func exporters(cfg config.Config, key string) ([]Exporter, error) {
+	var exporters []Exporter
+	if cfg.Has(key + ".dir") {
+		dir, err := cfg.GetString(key + ".dir")
+		if err != nil {
+			return nil, err
+		}
+		var maxFiles int
+		if cfg.Has(key + ".max_files") {
+			if maxFiles, err = cfg.GetInt(key + ".max_files"); err != nil {
+				return nil, err
+			}
+		}
+		exporters = append(exporters, NewDirExporter(dir, maxFiles))
+	}
+	if cfg.Has(key + ".pyroscope.url") {
+		endpoint, err := cfg.GetString(key + ".pyroscope.url")
+		if err != nil {
+			return nil, err
+		}
+		app, _ := cfg.GetString(key + ".pyroscope.app")
+		if app == "" {
+			return nil, errors.New("profiling: pyroscope.app is required")
+		}
+		exporters = append(exporters, NewPyroscopeExporter(endpoint, app))
+	}
+	if cfg.Has(key + ".parca.url") {
+		endpoint, err := cfg.GetString(key + ".parca.url")
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, NewParcaExporter(endpoint))
+	}
+	return exporters, nil
+}
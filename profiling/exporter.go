@@ -0,0 +1,244 @@
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DirExporter writes the profiles to a directory, named
+// <type>-<reason>-<time>.pb.gz, for `go tool pprof`.
+type DirExporter struct {
+	dir      string
+	maxFiles int
+}
+
+// NewDirExporter creates an exporter writing to dir and keeping its
+// maxFiles newest profiles, all of them when maxFiles is zero.
+func NewDirExporter(dir string, maxFiles int) *DirExporter {
+	return &DirExporter{dir: dir, maxFiles: maxFiles}
+}
+
+// Export implements Exporter.
+func (e *DirExporter) Export(ctx context.Context, p *Profile) error {
+	if err := os.MkdirAll(e.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.pb.gz", p.Type, p.Reason, p.Start.UTC().Format("20060102T150405.000"))
+	f, err := os.CreateTemp(e.dir, ".profile-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(p.Data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(e.dir, name)); err != nil {
+		return err
+	}
+	return e.prune()
+}
+
+// prune removes the oldest profiles beyond maxFiles.
+func (e *DirExporter) prune() error {
+	if e.maxFiles <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(e.dir, "*.pb.gz"))
+	if err != nil || len(files) <= e.maxFiles {
+		return err
+	}
+
+	type file struct {
+		path    string
+		modTime int64
+	}
+	infos := make([]file, 0, len(files))
+	for _, path := range files {
+		if fi, err := os.Stat(path); err == nil {
+			infos = append(infos, file{path: path, modTime: fi.ModTime().UnixNano()})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].modTime < infos[j].modTime })
+	for _, f := range infos[:max(len(infos)-e.maxFiles, 0)] {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExporterOption is HTTP exporter option.
+type ExporterOption func(*exporterOptions)
+
+// exporterOptions is HTTP exporter options.
+type exporterOptions struct {
+	client *http.Client
+	header http.Header
+}
+
+// WithHTTPClient sets the client sending the profiles, http.DefaultClient
+// by default.
+func WithHTTPClient(client *http.Client) ExporterOption {
+	return func(o *exporterOptions) {
+		o.client = client
+	}
+}
+
+// WithHTTPHeader adds a header to the requests, e.g. for authentication or
+// the X-Scope-OrgID tenant of Pyroscope.
+func WithHTTPHeader(key, value string) ExporterOption {
+	return func(o *exporterOptions) {
+		o.header.Add(key, value)
+	}
+}
+
+// newExporterOptions applies opts to the default options.
+func newExporterOptions(opts []ExporterOption) *exporterOptions {
+	o := &exporterOptions{client: http.DefaultClient, header: make(http.Header)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// post sends a request and checks its status.
+func (o *exporterOptions) post(ctx context.Context, url, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, values := range o.header {
+		req.Header[key] = values
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// PyroscopeExporter pushes the profiles to the ingest API of Pyroscope.
+type PyroscopeExporter struct {
+	endpoint string
+	app      string
+	opts     *exporterOptions
+}
+
+// NewPyroscopeExporter creates an exporter pushing to the Pyroscope server
+// at endpoint, e.g. "http://pyroscope:4040", the profiles named
+// <app>.<type> and tagged with the labels of the profiler.
+func NewPyroscopeExporter(endpoint, app string, opts ...ExporterOption) *PyroscopeExporter {
+	return &PyroscopeExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		app:      app,
+		opts:     newExporterOptions(opts),
+	}
+}
+
+// Export implements Exporter.
+func (e *PyroscopeExporter) Export(ctx context.Context, p *Profile) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(p.Data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	q.Set("name", e.app+"."+string(p.Type)+pyroscopeLabels(p.Labels))
+	q.Set("from", strconv.FormatInt(p.Start.Unix(), 10))
+	q.Set("until", strconv.FormatInt(p.End.Unix(), 10))
+	q.Set("format", "pprof")
+	q.Set("spyName", "gospy")
+	return e.opts.post(ctx, e.endpoint+"/ingest?"+q.Encode(), w.FormDataContentType(), body.Bytes())
+}
+
+// pyroscopeLabels formats labels as the tags of a Pyroscope name,
+// e.g. {env=prod,version=1.2.0}.
+func pyroscopeLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return "{" + strings.Join(tags, ",") + "}"
+}
+
+// ParcaExporter pushes the profiles to the WriteRaw API of Parca.
+type ParcaExporter struct {
+	endpoint string
+	opts     *exporterOptions
+}
+
+// NewParcaExporter creates an exporter pushing to the Parca server at
+// endpoint, e.g. "http://parca:7070", through its HTTP gateway. The profiles
+// are labeled with their type as __name__ and the labels of the profiler.
+func NewParcaExporter(endpoint string, opts ...ExporterOption) *ParcaExporter {
+	return &ParcaExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		opts:     newExporterOptions(opts),
+	}
+}
+
+// Export implements Exporter.
+func (e *ParcaExporter) Export(ctx context.Context, p *Profile) error {
+	type label struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	labels := []label{{Name: "__name__", Value: string(p.Type)}}
+	for k, v := range p.Labels {
+		labels = append(labels, label{Name: k, Value: v})
+	}
+	sort.Slice(labels[1:], func(i, j int) bool { return labels[i+1].Name < labels[j+1].Name })
+
+	type sample struct {
+		RawProfile []byte `json:"rawProfile"`
+	}
+	type series struct {
+		Labels struct {
+			Labels []label `json:"labels"`
+		} `json:"labels"`
+		Samples []sample `json:"samples"`
+	}
+	s := series{Samples: []sample{{RawProfile: p.Data}}}
+	s.Labels.Labels = labels
+	body, err := json.Marshal(map[string]interface{}{"series": []series{s}})
+	if err != nil {
+		return err
+	}
+	return e.opts.post(ctx, e.endpoint+"/profiles/writeraw", "application/json", body)
+}
@@ -0,0 +1,464 @@
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// Type is a profile type.
+type Type string
+
+// Profile types.
+const (
+	// TypeCPU is the CPU profile, sampled for the CPU duration.
+	TypeCPU Type = "cpu"
+	// TypeHeap is the sampled memory of the live objects.
+	TypeHeap Type = "heap"
+	// TypeAllocs is the sampled memory allocations since the start.
+	TypeAllocs Type = "allocs"
+	// TypeGoroutine is the stacks of the goroutines.
+	TypeGoroutine Type = "goroutine"
+	// TypeMutex is the stacks of the contended mutexes, see
+	// runtime.SetMutexProfileFraction.
+	TypeMutex Type = "mutex"
+	// TypeBlock is the stacks blocked on synchronization, see
+	// runtime.SetBlockProfileRate.
+	TypeBlock Type = "block"
+)
+
+// Reasons of the captures.
+const (
+	// ReasonPeriodic is the reason of the captures made every interval.
+	ReasonPeriodic = "periodic"
+	// ReasonManual is the reason of the captures made by Capture.
+	ReasonManual = "manual"
+	// ReasonCPU is the reason of the captures triggered by the CPU usage.
+	ReasonCPU = "cpu"
+	// ReasonHeap is the reason of the captures triggered by the heap size.
+	ReasonHeap = "heap"
+	// ReasonGoroutines is the reason of the captures triggered by the
+	// number of goroutines.
+	ReasonGoroutines = "goroutines"
+	// ReasonLatency is the reason of the captures triggered by the latency
+	// of the requests.
+	ReasonLatency = "latency"
+)
+
+// ErrBusy is returned by Capture while another capture runs.
+var ErrBusy = errors.New("profiling: a capture is already running")
+
+// Profile is a captured profile.
+type Profile struct {
+	Type   Type
+	Reason string
+	Start  time.Time
+	End    time.Time
+	// Data is the profile in the gzipped pprof format.
+	Data []byte
+	// Labels is the labels of the profiler, such as the service name.
+	Labels map[string]string
+}
+
+// Exporter writes the captured profiles.
+type Exporter interface {
+	// Export writes a profile.
+	Export(ctx context.Context, p *Profile) error
+}
+
+// newCapturesCounter creates the counter of the captured profiles
+// registered with registry, reusing the registered one. It isn't
+// registered when registry is nil.
+func newCapturesCounter(registry prometheus.Registerer) *prometheus.CounterVec {
+	captures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "new_milli",
+		Subsystem: "profiling",
+		Name:      "captures_total",
+		Help:      "Number of profile captures by type, reason and status.",
+	}, []string{"type", "reason", "status"})
+	if registry != nil {
+		var err error
+		if captures, err = collector.Register(registry, captures); err != nil {
+			klog.Warnf("Failed to register profiling metrics: %v", err)
+		}
+	}
+	return captures
+}
+
+// Option is profiler option.
+type Option func(*options)
+
+// options is profiler options.
+type options struct {
+	exporters     []Exporter
+	types         []Type
+	labels        map[string]string
+	interval      time.Duration
+	cpuDuration   time.Duration
+	checkInterval time.Duration
+	cooldown      time.Duration
+	exportTimeout time.Duration
+	registry      prometheus.Registerer
+
+	cpuThreshold      float64
+	heapThreshold     uint64
+	goroutineLimit    int
+	latencyThreshold  time.Duration
+	latencyQuantile   float64
+	latencyMinSamples int
+}
+
+// WithExporter adds exporters the profiles are written to, e.g. a
+// DirExporter and a PyroscopeExporter.
+func WithExporter(e ...Exporter) Option {
+	return func(o *options) {
+		o.exporters = append(o.exporters, e...)
+	}
+}
+
+// WithTypes sets the captured profile types, cpu, heap and goroutine by default.
+func WithTypes(types ...Type) Option {
+	return func(o *options) {
+		o.types = types
+	}
+}
+
+// WithLabels sets labels added to the profiles, e.g. the service name and
+// version used by Pyroscope and Parca to group them.
+func WithLabels(labels map[string]string) Option {
+	return func(o *options) {
+		o.labels = labels
+	}
+}
+
+// WithInterval captures the profiles every interval, zero, the default,
+// captures them only when triggered.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithCPUDuration sets how long the CPU is profiled, 10 seconds by default.
+func WithCPUDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.cpuDuration = d
+	}
+}
+
+// WithCheckInterval sets how often the triggers are checked, 10 seconds by
+// default.
+func WithCheckInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.checkInterval = interval
+	}
+}
+
+// WithCooldown sets the minimum time between two triggered captures, so a
+// lasting condition doesn't profile the service continuously, 5 minutes by
+// default.
+func WithCooldown(cooldown time.Duration) Option {
+	return func(o *options) {
+		o.cooldown = cooldown
+	}
+}
+
+// WithExportTimeout bounds the export of a profile, 30 seconds by default.
+func WithExportTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.exportTimeout = timeout
+	}
+}
+
+// WithRegistry sets the registry of the profiling metrics,
+// prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// WithCPUThreshold triggers a capture when the CPU usage of the process
+// exceeds percent of GOMAXPROCS over a check interval.
+func WithCPUThreshold(percent float64) Option {
+	return func(o *options) {
+		o.cpuThreshold = percent
+	}
+}
+
+// WithHeapThreshold triggers a capture when the live heap exceeds bytes.
+func WithHeapThreshold(bytes uint64) Option {
+	return func(o *options) {
+		o.heapThreshold = bytes
+	}
+}
+
+// WithGoroutineThreshold triggers a capture when the number of goroutines
+// exceeds n.
+func WithGoroutineThreshold(n int) Option {
+	return func(o *options) {
+		o.goroutineLimit = n
+	}
+}
+
+// WithLatencyThreshold triggers a capture when the quantile, e.g. 0.99, of
+// the latencies observed over a check interval exceeds threshold. The
+// latencies are observed by the Middleware of the profiler or by Observe,
+// and at least minSamples of them are needed, 10 when it is zero.
+func WithLatencyThreshold(quantile float64, threshold time.Duration, minSamples int) Option {
+	return func(o *options) {
+		o.latencyQuantile = quantile
+		o.latencyThreshold = threshold
+		o.latencyMinSamples = minSamples
+	}
+}
+
+// Profiler captures profiles periodically and when the process is under
+// load, and writes them to its exporters. It implements transport.Server
+// so the application starts and stops it:
+//
+//	profiler := profiling.New(
+//		profiling.WithExporter(profiling.NewDirExporter("/var/lib/app/profiles", 100)),
+//		profiling.WithCPUThreshold(80),
+//	)
+//	app := newMilli.New(newMilli.Server(httpSrv, profiler))
+type Profiler struct {
+	opts     options
+	latency  *window
+	cpu      *cpuSampler
+	captures *prometheus.CounterVec
+
+	busy        atomic.Bool
+	lastTrigger atomic.Int64
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a profiler.
+func New(opts ...Option) *Profiler {
+	o := options{
+		types:             []Type{TypeCPU, TypeHeap, TypeGoroutine},
+		cpuDuration:       10 * time.Second,
+		checkInterval:     10 * time.Second,
+		cooldown:          5 * time.Minute,
+		exportTimeout:     30 * time.Second,
+		latencyMinSamples: 10,
+		registry:          prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.latencyMinSamples <= 0 {
+		o.latencyMinSamples = 10
+	}
+	return &Profiler{
+		opts:     o,
+		latency:  newWindow(latencyWindowSize),
+		cpu:      newCPUSampler(),
+		captures: newCapturesCounter(o.registry),
+	}
+}
+
+// Init implements transport.Server.
+func (p *Profiler) Init(opts ...transport.ServerOption) error {
+	return nil
+}
+
+// Start starts the periodic captures and the trigger checks, it does
+// nothing when the profiler is already started.
+func (p *Profiler) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		return nil
+	}
+	if len(p.opts.exporters) == 0 {
+		return errors.New("profiling: no exporter")
+	}
+
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	p.cancel, p.done = cancel, make(chan struct{})
+	go p.run(runCtx, p.done)
+	return nil
+}
+
+// Stop stops the profiler, cutting short a running CPU profile.
+func (p *Profiler) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel, p.done = nil, nil
+	p.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Observe records the latency of a request for the latency trigger.
+func (p *Profiler) Observe(d time.Duration) {
+	if p.opts.latencyThreshold > 0 {
+		p.latency.add(d)
+	}
+}
+
+// Middleware returns a middleware observing the latency of the requests.
+func (p *Profiler) Middleware() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			start := time.Now()
+			reply, err := handler(ctx, req)
+			p.Observe(time.Since(start))
+			return reply, err
+		}
+	}
+}
+
+// Capture captures the profiles now and writes them to the exporters. It
+// returns ErrBusy while another capture runs.
+func (p *Profiler) Capture(ctx context.Context) error {
+	return p.capture(ctx, ReasonManual)
+}
+
+// run runs the periodic captures and checks the triggers until ctx is done.
+func (p *Profiler) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	var periodic <-chan time.Time
+	if p.opts.interval > 0 {
+		t := time.NewTicker(p.opts.interval)
+		defer t.Stop()
+		periodic = t.C
+	}
+	var check <-chan time.Time
+	if p.triggers() {
+		p.cpu.usage()
+		t := time.NewTicker(p.opts.checkInterval)
+		defer t.Stop()
+		check = t.C
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	start := func(reason string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.capture(ctx, reason); err != nil && !errors.Is(err, ErrBusy) && ctx.Err() == nil {
+				klog.Errorf("profiling: %s capture failed: %v", reason, err)
+			}
+		}()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-periodic:
+			start(ReasonPeriodic)
+		case <-check:
+			reason := p.check()
+			if reason == "" {
+				continue
+			}
+			last := time.Unix(0, p.lastTrigger.Load())
+			if time.Since(last) < p.opts.cooldown {
+				continue
+			}
+			p.lastTrigger.Store(time.Now().UnixNano())
+			klog.Warnf("profiling: %s threshold exceeded, capturing profiles", reason)
+			start(reason)
+		}
+	}
+}
+
+// capture captures the configured profiles and exports them.
+func (p *Profiler) capture(ctx context.Context, reason string) error {
+	if !p.busy.CompareAndSwap(false, true) {
+		return ErrBusy
+	}
+	defer p.busy.Store(false)
+
+	var errs []error
+	for _, typ := range p.opts.types {
+		profile, err := p.profile(ctx, typ, reason)
+		if err != nil {
+			p.captures.WithLabelValues(string(typ), reason, "error").Inc()
+			errs = append(errs, err)
+			continue
+		}
+		if err := p.export(ctx, profile); err != nil {
+			p.captures.WithLabelValues(string(typ), reason, "error").Inc()
+			errs = append(errs, err)
+			continue
+		}
+		p.captures.WithLabelValues(string(typ), reason, "success").Inc()
+	}
+	return errors.Join(errs...)
+}
+
+// profile captures a profile of typ.
+func (p *Profiler) profile(ctx context.Context, typ Type, reason string) (*Profile, error) {
+	profile := &Profile{
+		Type:   typ,
+		Reason: reason,
+		Start:  time.Now(),
+		Labels: p.opts.labels,
+	}
+	var buf bytes.Buffer
+	if typ == TypeCPU {
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return nil, fmt.Errorf("failed to start the CPU profile: %w", err)
+		}
+		t := time.NewTimer(p.opts.cpuDuration)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+		pprof.StopCPUProfile()
+	} else {
+		prof := pprof.Lookup(string(typ))
+		if prof == nil {
+			return nil, fmt.Errorf("unknown profile type %s", typ)
+		}
+		if err := prof.WriteTo(&buf, 0); err != nil {
+			return nil, fmt.Errorf("failed to write the %s profile: %w", typ, err)
+		}
+	}
+	profile.End = time.Now()
+	profile.Data = buf.Bytes()
+	return profile, nil
+}
+
+// export writes a profile to the exporters.
+func (p *Profiler) export(ctx context.Context, profile *Profile) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.opts.exportTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, e := range p.opts.exporters {
+		if err := e.Export(ctx, profile); err != nil {
+			errs = append(errs, fmt.Errorf("failed to export the %s profile: %w", profile.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package profiling
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindowSize is the number of latencies kept per check interval,
+// the oldest are overwritten.
+const latencyWindowSize = 4096
+
+// triggers reports whether a trigger is configured.
+func (p *Profiler) triggers() bool {
+	o := p.opts
+	return o.cpuThreshold > 0 || o.heapThreshold > 0 || o.goroutineLimit > 0 || o.latencyThreshold > 0
+}
+
+// check returns the reason of the first exceeded threshold, empty when none is.
+func (p *Profiler) check() string {
+	o := p.opts
+	if o.cpuThreshold > 0 {
+		if usage, ok := p.cpu.usage(); ok && usage > o.cpuThreshold {
+			return ReasonCPU
+		}
+	}
+	if o.heapThreshold > 0 && heapBytes() > o.heapThreshold {
+		return ReasonHeap
+	}
+	if o.goroutineLimit > 0 && runtime.NumGoroutine() > o.goroutineLimit {
+		return ReasonGoroutines
+	}
+	if o.latencyThreshold > 0 {
+		if q, ok := p.latency.quantile(o.latencyQuantile, o.latencyMinSamples); ok && q > o.latencyThreshold {
+			return ReasonLatency
+		}
+	}
+	return ""
+}
+
+// heapBytes returns the memory of the heap objects, live or not swept yet.
+func heapBytes() uint64 {
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return samples[0].Value.Uint64()
+}
+
+// cpuSampler measures the CPU usage of the process between two calls.
+type cpuSampler struct {
+	mu        sync.Mutex
+	samples   []metrics.Sample
+	prevTotal float64
+	prevIdle  float64
+}
+
+// newCPUSampler creates a CPU sampler.
+func newCPUSampler() *cpuSampler {
+	return &cpuSampler{
+		samples: []metrics.Sample{
+			{Name: "/cpu/classes/total:cpu-seconds"},
+			{Name: "/cpu/classes/idle:cpu-seconds"},
+		},
+	}
+}
+
+// usage returns the CPU usage since the previous call in percent of
+// GOMAXPROCS, false when it is unknown. The runtime updates the CPU
+// metrics on garbage collections, so an idle process reports none.
+func (s *cpuSampler) usage() (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metrics.Read(s.samples)
+	if s.samples[0].Value.Kind() != metrics.KindFloat64 || s.samples[1].Value.Kind() != metrics.KindFloat64 {
+		return 0, false
+	}
+	total, idle := s.samples[0].Value.Float64(), s.samples[1].Value.Float64()
+	if total <= s.prevTotal {
+		return 0, false
+	}
+	usage := (1 - (idle-s.prevIdle)/(total-s.prevTotal)) * 100
+	s.prevTotal, s.prevIdle = total, idle
+	return usage, true
+}
+
+// window keeps the latencies observed since the last quantile.
+type window struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// newWindow creates a window of size latencies.
+func newWindow(size int) *window {
+	return &window{samples: make([]time.Duration, size)}
+}
+
+// add records a latency.
+func (w *window) add(d time.Duration) {
+	w.mu.Lock()
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next, w.full = 0, true
+	}
+	w.mu.Unlock()
+}
+
+// quantile returns the quantile q of the recorded latencies and resets the
+// window, false when fewer than minSamples were recorded.
+func (w *window) quantile(q float64, minSamples int) (time.Duration, bool) {
+	w.mu.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	w.next, w.full = 0, false
+	w.mu.Unlock()
+
+	if n == 0 || n < minSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(q*float64(n))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= n {
+		i = n - 1
+	}
+	return sorted[i], true
+}
@@ -0,0 +1,157 @@
+package newMilli
+
+import (
+	"new-milli/broker"
+	"new-milli/config"
+	"new-milli/connector"
+	"new-milli/logger"
+	"new-milli/transport"
+	"new-milli/transport/grpc"
+	"new-milli/transport/http"
+)
+
+// ConfigPath is the path of the config file loaded by ProvideConfig.
+type ConfigPath string
+
+// ProviderSet is the providers of the framework components, to wire them
+// with uber/fx, see WireSet for google/wire:
+//
+//	fx.New(
+//		fx.Supply(newMilli.ConfigPath("config.yaml")),
+//		fx.Provide(newMilli.ProviderSet...),
+//		fx.Provide(newServers),
+//		fx.Invoke(func(app *newMilli.App) error { return app.Run() }),
+//	)
+//
+// The servers ([]transport.Server) are provided by the application, e.g.
+// with ProvideHTTPServer and ProvideGRPCServer.
+var ProviderSet = []interface{}{
+	ProvideConfig,
+	ProvideLogger,
+	ProvideConnectors,
+	ProvideBrokers,
+	ProvideApp,
+}
+
+// ProvideConfig loads the config file at path.
+func ProvideConfig(path ConfigPath) (config.Config, error) {
+	cfg := config.NewConfig(config.NewFileSource(string(path)))
+	if err := cfg.Load(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ProvideLogger creates the logger configured by the logger.level field,
+// named after app.name in the app.environment environment.
+func ProvideLogger(cfg config.Config) (logger.Logger, error) {
+	c := logger.DefaultConfig()
+	if cfg.Has("logger.level") {
+		s, err := cfg.GetString("logger.level")
+		if err != nil {
+			return nil, err
+		}
+		if c.Level, err = logger.ParseLevel(s); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Has("app.name") {
+		var err error
+		if c.ServiceName, err = cfg.GetString("app.name"); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Has("app.environment") {
+		var err error
+		if c.Environment, err = cfg.GetString("app.environment"); err != nil {
+			return nil, err
+		}
+	}
+	return logger.New(c), nil
+}
+
+// ProvideConnectors creates the connectors declared in the connectors
+// section, see connector.LoadRegistry. The connector packages must be
+// imported to register their types.
+func ProvideConnectors(cfg config.Config) (*connector.Registry, error) {
+	return connector.LoadRegistry(cfg)
+}
+
+// ProvideBrokers creates the brokers declared in the brokers section, see
+// broker.LoadRegistry. The broker packages must be imported to register
+// their types.
+func ProvideBrokers(cfg config.Config) (*broker.Registry, error) {
+	return broker.LoadRegistry(cfg)
+}
+
+// ProvideApp creates the application named by the app.id, app.name and
+// app.version fields, running the servers. The connectors and the brokers
+// are connected before the servers start and closed once they have stopped.
+func ProvideApp(cfg config.Config, connectors *connector.Registry, brokers *broker.Registry, servers []transport.Server) (*App, error) {
+	opts := []Option{
+		Server(servers...),
+		Connectors(connectors),
+		Brokers(brokers, DependsOn("connectors")),
+	}
+	if cfg.Has("app.id") {
+		id, err := cfg.GetString("app.id")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, ID(id))
+	}
+	if cfg.Has("app.name") {
+		name, err := cfg.GetString("app.name")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, Name(name))
+	}
+	if cfg.Has("app.version") {
+		version, err := cfg.GetString("app.version")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, Version(version))
+	}
+	return New(opts...)
+}
+
+// ProvideHTTPServer creates the HTTP server configured by the
+// server.http.address (default ":8000") and server.http.timeout fields.
+func ProvideHTTPServer(cfg config.Config) (*http.Server, error) {
+	opts, err := serverOptions(cfg, "server.http", ":8000")
+	if err != nil {
+		return nil, err
+	}
+	return http.NewServer(opts...), nil
+}
+
+// ProvideGRPCServer creates the gRPC server configured by the
+// server.grpc.address (default ":9000") and server.grpc.timeout fields.
+func ProvideGRPCServer(cfg config.Config) (*grpc.Server, error) {
+	opts, err := serverOptions(cfg, "server.grpc", ":9000")
+	if err != nil {
+		return nil, err
+	}
+	return grpc.NewServer(opts...), nil
+}
+
+// serverOptions returns the options of the server configured under key.
+func serverOptions(cfg config.Config, key, address string) ([]transport.ServerOption, error) {
+	if cfg.Has(key + ".address") {
+		var err error
+		if address, err = cfg.GetString(key + ".address"); err != nil {
+			return nil, err
+		}
+	}
+	opts := []transport.ServerOption{transport.Address(address)}
+	if cfg.Has(key + ".timeout") {
+		timeout, err := config.GetDuration(cfg, key+".timeout")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, transport.Timeout(timeout))
+	}
+	return opts, nil
+}
@@ -0,0 +1,23 @@
+//go:build wireinject
+
+package newMilli
+
+import "github.com/google/wire"
+
+// WireSet is ProviderSet as a google/wire provider set. wire loads the
+// packages with the wireinject build tag, so it is only declared in the
+// injector builds:
+//
+//	//go:build wireinject
+//
+//	func newApp(path newMilli.ConfigPath) (*newMilli.App, error) {
+//		wire.Build(newMilli.WireSet, newServers)
+//		return nil, nil
+//	}
+var WireSet = wire.NewSet(
+	ProvideConfig,
+	ProvideLogger,
+	ProvideConnectors,
+	ProvideBrokers,
+	ProvideApp,
+)
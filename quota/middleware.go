@@ -0,0 +1,175 @@
+package quota
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/broker"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// HeaderTenant is the header carrying the tenant of a request or message.
+const HeaderTenant = "X-Tenant-Id"
+
+type tenantKey struct{}
+
+// NewContext returns a new Context that carries the tenant.
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// FromContext returns the tenant stored in ctx, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// TenantFromHeader returns the tenant from the HeaderTenant request header.
+func TenantFromHeader(ctx context.Context) string {
+	if tr, ok := transport.FromServerContext(ctx); ok {
+		return tr.RequestHeader().Get(HeaderTenant)
+	}
+	return ""
+}
+
+// MiddlewareOption is quota middleware option.
+type MiddlewareOption func(*middlewareOptions)
+
+// middlewareOptions is quota middleware options.
+type middlewareOptions struct {
+	disabled   bool
+	tenantFunc func(ctx context.Context) string
+	sizeFunc   func(msg interface{}) int64
+}
+
+// WithDisabled returns a MiddlewareOption that disables metering.
+func WithDisabled(disabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.disabled = disabled
+	}
+}
+
+// WithTenantFunc returns a MiddlewareOption that sets the function returning
+// the tenant of a request, requests without tenant aren't metered.
+func WithTenantFunc(fn func(ctx context.Context) string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.tenantFunc = fn
+	}
+}
+
+// WithSizeFunc returns a MiddlewareOption that sets the function returning the
+// size in bytes of requests and replies.
+func WithSizeFunc(fn func(msg interface{}) int64) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.sizeFunc = fn
+	}
+}
+
+// Size returns the size of []byte, string and messages with a Size method, 0 otherwise.
+func Size(msg interface{}) int64 {
+	switch v := msg.(type) {
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	case interface{ Size() int }:
+		return int64(v.Size())
+	}
+	return 0
+}
+
+// Server returns a middleware that meters the requests and payload bytes of
+// tenants and rejects requests once a hard quota is used up.
+// Metering errors don't fail requests.
+func Server(m *Meter, opts ...MiddlewareOption) middleware.Middleware {
+	cfg := middlewareOptions{
+		tenantFunc: TenantFromHeader,
+		sizeFunc:   Size,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			tenant := cfg.tenantFunc(ctx)
+			if tenant == "" {
+				return handler(ctx, req)
+			}
+
+			var operation string
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				operation = tr.Operation()
+			}
+
+			err := m.RecordAll(ctx, tenant, operation,
+				Amount{Metric: Requests, N: 1},
+				Amount{Metric: Bytes, N: cfg.sizeFunc(req)},
+			)
+			if errors.Is(err, ErrExceeded) {
+				return nil, err
+			}
+			if err != nil {
+				klog.CtxWarnf(ctx, "quota: failed to meter %s of tenant %s: %v", operation, tenant, err)
+			}
+
+			reply, err := handler(NewContext(ctx, tenant), req)
+			if n := cfg.sizeFunc(reply); n > 0 {
+				if err := m.Add(ctx, tenant, operation, Amount{Metric: Bytes, N: n}); err != nil {
+					klog.CtxWarnf(ctx, "quota: failed to meter %s of tenant %s: %v", operation, tenant, err)
+				}
+			}
+			return reply, err
+		}
+	}
+}
+
+// meteredBroker is a broker metering the published messages.
+type meteredBroker struct {
+	broker.Broker
+	meter *Meter
+}
+
+// WrapBroker returns a broker that meters the messages and bytes published by
+// tenants per topic and rejects messages once a hard quota is used up.
+// The tenant is read from the HeaderTenant message header or the context,
+// and set on the message so consumers can meter it as well.
+func WrapBroker(b broker.Broker, m *Meter) broker.Broker {
+	return &meteredBroker{Broker: b, meter: m}
+}
+
+// Publish implements broker.Broker.
+func (b *meteredBroker) Publish(ctx context.Context, topic string, msg *broker.Message, opts ...broker.PublishOption) error {
+	tenant := msg.Header[HeaderTenant]
+	if tenant == "" {
+		tenant, _ = FromContext(ctx)
+	}
+	if tenant == "" {
+		return b.Broker.Publish(ctx, topic, msg, opts...)
+	}
+
+	if msg.Header == nil {
+		msg.Header = make(map[string]string)
+	}
+	msg.Header[HeaderTenant] = tenant
+
+	err := b.meter.RecordAll(ctx, tenant, topic,
+		Amount{Metric: Messages, N: 1},
+		Amount{Metric: Bytes, N: int64(len(msg.Body))},
+	)
+	if errors.Is(err, ErrExceeded) {
+		return err
+	}
+	if err != nil {
+		klog.CtxWarnf(ctx, "quota: failed to meter %s of tenant %s: %v", topic, tenant, err)
+	}
+	return b.Broker.Publish(ctx, topic, msg, opts...)
+}
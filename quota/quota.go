@@ -0,0 +1,544 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/redis/go-redis/v9"
+	"new-milli/transport"
+)
+
+// ErrExceeded is returned when a hard quota is exceeded.
+var ErrExceeded = transport.NewStatusError(http.StatusTooManyRequests, "QUOTA_EXCEEDED", "quota: exceeded")
+
+// Metric is a metered resource.
+type Metric string
+
+const (
+	// Requests counts the handled requests.
+	Requests Metric = "requests"
+	// Bytes counts the request and reply payload bytes.
+	Bytes Metric = "bytes"
+	// Messages counts the published broker messages.
+	Messages Metric = "messages"
+)
+
+// Period is the accounting period usage is reset after.
+type Period int
+
+const (
+	// Hourly resets usage every hour.
+	Hourly Period = iota + 1
+	// Daily resets usage every day.
+	Daily
+	// Monthly resets usage every calendar month.
+	Monthly
+)
+
+// Start returns the start of the period containing t, in UTC.
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch p {
+	case Hourly:
+		return t.Truncate(time.Hour)
+	case Daily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// End returns the end of the period starting at start.
+func (p Period) End(start time.Time) time.Time {
+	switch p {
+	case Hourly:
+		return start.Add(time.Hour)
+	case Daily:
+		return start.AddDate(0, 0, 1)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// String returns the name of the period.
+func (p Period) String() string {
+	switch p {
+	case Hourly:
+		return "hourly"
+	case Daily:
+		return "daily"
+	default:
+		return "monthly"
+	}
+}
+
+// layout returns the time layout of the period Redis keys.
+func (p Period) layout() string {
+	switch p {
+	case Hourly:
+		return "2006010215"
+	case Daily:
+		return "20060102"
+	default:
+		return "200601"
+	}
+}
+
+// Limit is the quota of a metric. The first limit matching a tenant, operation
+// and metric applies.
+type Limit struct {
+	// Tenant is a path.Match pattern of the tenants the limit applies to, empty for all tenants.
+	Tenant string
+	// Operation is a path.Match pattern of the operations the limit applies to,
+	// each matching operation is counted separately. Empty limits the tenant total.
+	Operation string
+	// Metric is the limited metric.
+	Metric Metric
+	// Soft is the usage past which the soft limit handler is called, 0 disables it.
+	Soft int64
+	// Hard is the usage past which requests are rejected, 0 disables it.
+	Hard int64
+}
+
+// matches reports whether the limit applies to the counter of an operation,
+// an empty operation being the tenant total.
+func (l *Limit) matches(tenant, operation string, metric Metric) bool {
+	if l.Metric != metric {
+		return false
+	}
+	if l.Tenant != "" {
+		if ok, _ := path.Match(l.Tenant, tenant); !ok {
+			return false
+		}
+	}
+	if l.Operation == "" || operation == "" {
+		return l.Operation == operation
+	}
+	ok, _ := path.Match(l.Operation, operation)
+	return ok
+}
+
+// ExceededError is returned when a hard quota is exceeded and passed to the
+// soft limit handler.
+type ExceededError struct {
+	Tenant    string
+	Operation string
+	Metric    Metric
+	Limit     int64
+	Used      int64
+}
+
+// Error implements the error interface.
+func (e *ExceededError) Error() string {
+	if e.Operation == "" {
+		return fmt.Sprintf("quota: tenant %s exceeded %s quota of %d", e.Tenant, e.Metric, e.Limit)
+	}
+	return fmt.Sprintf("quota: tenant %s exceeded %s quota of %d for %s", e.Tenant, e.Metric, e.Limit, e.Operation)
+}
+
+// Unwrap returns ErrExceeded so errors.Is keeps working.
+func (e *ExceededError) Unwrap() error {
+	return ErrExceeded
+}
+
+// Usage is the usage of a metric by a tenant during a period.
+type Usage struct {
+	Tenant string
+	// Operation is the metered operation, empty for the tenant total.
+	Operation string
+	Metric    Metric
+	Period    time.Time
+	Value     int64
+}
+
+// Filter selects the usage returned by queries, zero fields match everything.
+type Filter struct {
+	Tenant    string
+	Operation string
+	Metric    Metric
+	// From and To bound the period start, To being exclusive.
+	From time.Time
+	To   time.Time
+}
+
+// Store persists usage for billing and reporting.
+type Store interface {
+	// Save upserts the usage, values are absolute.
+	Save(ctx context.Context, usage []Usage) error
+	// Query returns the usage matching the filter.
+	Query(ctx context.Context, filter Filter) ([]Usage, error)
+}
+
+// Option is quota option.
+type Option func(*options)
+
+// options is quota options.
+type options struct {
+	prefix        string
+	period        Period
+	retention     time.Duration
+	limits        []Limit
+	store         Store
+	flushInterval time.Duration
+	onSoftLimit   func(ctx context.Context, e *ExceededError)
+}
+
+// WithPrefix sets the prefix of the Redis keys.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithPeriod sets the accounting period.
+func WithPeriod(period Period) Option {
+	return func(o *options) {
+		o.period = period
+	}
+}
+
+// WithRetention sets how long the counters of a period are kept in Redis after it ended.
+func WithRetention(retention time.Duration) Option {
+	return func(o *options) {
+		o.retention = retention
+	}
+}
+
+// WithLimits adds quota limits.
+func WithLimits(limits ...Limit) Option {
+	return func(o *options) {
+		o.limits = append(o.limits, limits...)
+	}
+}
+
+// WithStore sets the store usage is flushed to.
+func WithStore(store Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithFlushInterval sets the interval usage is flushed to the store at.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.flushInterval = interval
+	}
+}
+
+// WithSoftLimitFunc sets the function called when usage crosses a soft limit.
+func WithSoftLimitFunc(fn func(ctx context.Context, e *ExceededError)) Option {
+	return func(o *options) {
+		o.onSoftLimit = fn
+	}
+}
+
+// recordScript checks the hard limits of the counters and increments them
+// when none would be exceeded. ARGV holds the key TTL in milliseconds, whether
+// to check the limits, then a counter field, increment and hard limit triple
+// per counter. A zero increment checks that the counter is below its limit.
+var recordScript = redis.NewScript(`
+local check = ARGV[2] == "1"
+if check then
+	for i = 3, #ARGV, 3 do
+		local n = tonumber(ARGV[i + 1])
+		local hard = tonumber(ARGV[i + 2])
+		if hard > 0 then
+			local cur = tonumber(redis.call("HGET", KEYS[1], ARGV[i]) or "0")
+			if cur + n > hard or (n == 0 and cur >= hard) then
+				return {1, (i - 3) / 3, cur}
+			end
+		end
+	end
+end
+local res = {0}
+for i = 3, #ARGV, 3 do
+	res[#res + 1] = redis.call("HINCRBY", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return res
+`)
+
+// Amount is an increment of a metric.
+type Amount struct {
+	Metric Metric
+	N      int64
+}
+
+// counter is a Redis counter and its limit.
+type counter struct {
+	operation string
+	metric    Metric
+	n         int64
+	limit     *Limit
+}
+
+// bucket identifies the counters of a tenant during a period.
+type bucket struct {
+	tenant string
+	start  time.Time
+}
+
+// Meter meters the usage of tenants in Redis and flushes it to a Store.
+type Meter struct {
+	client redis.UniversalClient
+	opts   options
+
+	mu    sync.Mutex
+	dirty map[string]bucket
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a meter counting usage in Redis.
+func New(client redis.UniversalClient, opts ...Option) *Meter {
+	o := options{
+		prefix:        "quota:",
+		period:        Monthly,
+		retention:     time.Hour * 24,
+		flushInterval: time.Minute,
+		onSoftLimit: func(ctx context.Context, e *ExceededError) {
+			klog.CtxWarnf(ctx, "quota: tenant %s reached %d of %s soft quota %s", e.Tenant, e.Used, e.Metric, e.Operation)
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Meter{
+		client: client,
+		opts:   o,
+		dirty:  make(map[string]bucket),
+	}
+}
+
+// Record adds n to the usage of a metric by a tenant for an operation and
+// returns an *ExceededError without counting it when a hard limit would be exceeded.
+func (m *Meter) Record(ctx context.Context, tenant, operation string, metric Metric, n int64) error {
+	return m.record(ctx, tenant, operation, true, Amount{Metric: metric, N: n})
+}
+
+// RecordAll adds the amounts like Record, all or none of them being counted.
+func (m *Meter) RecordAll(ctx context.Context, tenant, operation string, amounts ...Amount) error {
+	return m.record(ctx, tenant, operation, true, amounts...)
+}
+
+// Add adds the amounts without checking the hard limits, e.g. for usage
+// known once the work is done.
+func (m *Meter) Add(ctx context.Context, tenant, operation string, amounts ...Amount) error {
+	return m.record(ctx, tenant, operation, false, amounts...)
+}
+
+// Check returns an *ExceededError if the tenant already used up a hard quota of the metric.
+func (m *Meter) Check(ctx context.Context, tenant, operation string, metric Metric) error {
+	return m.record(ctx, tenant, operation, true, Amount{Metric: metric})
+}
+
+// record increments the operation and tenant total counters of the amounts.
+func (m *Meter) record(ctx context.Context, tenant, operation string, check bool, amounts ...Amount) error {
+	counters := make([]counter, 0, len(amounts)*2)
+	for _, a := range amounts {
+		if a.N < 0 {
+			continue
+		}
+		if operation != "" {
+			counters = append(counters, counter{operation: operation, metric: a.Metric, n: a.N, limit: m.limit(tenant, operation, a.Metric)})
+		}
+		counters = append(counters, counter{metric: a.Metric, n: a.N, limit: m.limit(tenant, "", a.Metric)})
+	}
+	if len(counters) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	start := m.opts.period.Start(now)
+	key := m.key(tenant, start)
+	ttl := m.opts.period.End(start).Sub(now) + m.opts.retention
+
+	args := make([]interface{}, 0, 2+len(counters)*3)
+	args = append(args, ttl.Milliseconds(), check)
+	for _, c := range counters {
+		var hard int64
+		if c.limit != nil {
+			hard = c.limit.Hard
+		}
+		args = append(args, field(c.metric, c.operation), c.n, hard)
+	}
+
+	res, err := recordScript.Run(ctx, m.client, []string{key}, args...).Int64Slice()
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	if res[0] == 1 {
+		c := counters[res[1]]
+		return &ExceededError{Tenant: tenant, Operation: c.operation, Metric: c.metric, Limit: c.limit.Hard, Used: res[2]}
+	}
+
+	m.mu.Lock()
+	m.dirty[key] = bucket{tenant: tenant, start: start}
+	m.mu.Unlock()
+
+	for i, c := range counters {
+		if c.limit == nil || c.limit.Soft <= 0 || c.n == 0 {
+			continue
+		}
+		// Only notify when the counter crosses the soft limit
+		if used := res[i+1]; used >= c.limit.Soft && used-c.n < c.limit.Soft {
+			m.opts.onSoftLimit(ctx, &ExceededError{Tenant: tenant, Operation: c.operation, Metric: c.metric, Limit: c.limit.Soft, Used: used})
+		}
+	}
+	return nil
+}
+
+// limit returns the limit of a counter, if any.
+func (m *Meter) limit(tenant, operation string, metric Metric) *Limit {
+	for i := range m.opts.limits {
+		if m.opts.limits[i].matches(tenant, operation, metric) {
+			return &m.opts.limits[i]
+		}
+	}
+	return nil
+}
+
+// key returns the Redis key of the counters of a tenant during a period.
+func (m *Meter) key(tenant string, start time.Time) string {
+	return m.opts.prefix + tenant + ":" + start.Format(m.opts.period.layout())
+}
+
+// field returns the Redis hash field of a counter.
+func field(metric Metric, operation string) string {
+	return string(metric) + "|" + operation
+}
+
+// Usage returns the usage of a tenant during the current period.
+func (m *Meter) Usage(ctx context.Context, tenant string) ([]Usage, error) {
+	start := m.opts.period.Start(time.Now())
+	return m.usage(ctx, bucket{tenant: tenant, start: start})
+}
+
+// usage reads the counters of a bucket.
+func (m *Meter) usage(ctx context.Context, b bucket) ([]Usage, error) {
+	values, err := m.client.HGetAll(ctx, m.key(b.tenant, b.start)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage: %w", err)
+	}
+
+	usage := make([]Usage, 0, len(values))
+	for f, v := range values {
+		metric, operation, ok := strings.Cut(f, "|")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		usage = append(usage, Usage{
+			Tenant:    b.tenant,
+			Operation: operation,
+			Metric:    Metric(metric),
+			Period:    b.start,
+			Value:     n,
+		})
+	}
+	return usage, nil
+}
+
+// Query returns the flushed usage matching the filter.
+func (m *Meter) Query(ctx context.Context, filter Filter) ([]Usage, error) {
+	if m.opts.store == nil {
+		return nil, errors.New("quota: no store configured")
+	}
+	return m.opts.store.Query(ctx, filter)
+}
+
+// Flush saves the usage recorded since the last flush to the store.
+func (m *Meter) Flush(ctx context.Context) error {
+	if m.opts.store == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	dirty := m.dirty
+	m.dirty = make(map[string]bucket)
+	m.mu.Unlock()
+
+	var usage []Usage
+	var errs []error
+	for key, b := range dirty {
+		u, err := m.usage(ctx, b)
+		if err != nil {
+			m.requeue(key, b)
+			errs = append(errs, err)
+			continue
+		}
+		usage = append(usage, u...)
+	}
+	if len(usage) == 0 {
+		return errors.Join(errs...)
+	}
+
+	if err := m.opts.store.Save(ctx, usage); err != nil {
+		for key, b := range dirty {
+			m.requeue(key, b)
+		}
+		return fmt.Errorf("failed to save usage: %w", err)
+	}
+	return errors.Join(errs...)
+}
+
+// requeue marks a bucket as dirty again after a failed flush.
+func (m *Meter) requeue(key string, b bucket) {
+	m.mu.Lock()
+	m.dirty[key] = b
+	m.mu.Unlock()
+}
+
+// Start starts flushing the usage periodically.
+func (m *Meter) Start(ctx context.Context) error {
+	if m.opts.store == nil || m.stop != nil {
+		return nil
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.run()
+	return nil
+}
+
+// Stop stops the periodic flush and flushes the remaining usage.
+func (m *Meter) Stop(ctx context.Context) error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	return m.Flush(ctx)
+}
+
+// run flushes the usage until stopped.
+func (m *Meter) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.opts.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), m.opts.flushInterval)
+			if err := m.Flush(ctx); err != nil {
+				klog.Warnf("quota: failed to flush usage: %v", err)
+			}
+			cancel()
+		}
+	}
+}
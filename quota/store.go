@@ -0,0 +1,105 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// usageRecord is the row of a usage counter.
+type usageRecord struct {
+	Tenant      string    `gorm:"primaryKey;size:128"`
+	Operation   string    `gorm:"primaryKey;size:255"`
+	Metric      string    `gorm:"primaryKey;size:32"`
+	PeriodStart time.Time `gorm:"primaryKey"`
+	Value       int64
+	UpdatedAt   time.Time
+}
+
+// GormStore stores usage in a SQL table through GORM, e.g. the DB of the
+// MySQL or PostgreSQL connector.
+type GormStore struct {
+	db    *gorm.DB
+	table string
+}
+
+// NewGormStore creates a store writing to table, "quota_usage" when empty.
+func NewGormStore(db *gorm.DB, table string) *GormStore {
+	if table == "" {
+		table = "quota_usage"
+	}
+	return &GormStore{db: db, table: table}
+}
+
+// Migrate creates or updates the usage table.
+func (s *GormStore) Migrate(ctx context.Context) error {
+	if err := s.db.WithContext(ctx).Table(s.table).AutoMigrate(&usageRecord{}); err != nil {
+		return fmt.Errorf("failed to migrate usage table: %w", err)
+	}
+	return nil
+}
+
+// Save implements Store.
+func (s *GormStore) Save(ctx context.Context, usage []Usage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	records := make([]usageRecord, 0, len(usage))
+	for _, u := range usage {
+		records = append(records, usageRecord{
+			Tenant:      u.Tenant,
+			Operation:   u.Operation,
+			Metric:      string(u.Metric),
+			PeriodStart: u.Period,
+			Value:       u.Value,
+			UpdatedAt:   now,
+		})
+	}
+
+	return s.db.WithContext(ctx).Table(s.table).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant"}, {Name: "operation"}, {Name: "metric"}, {Name: "period_start"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).CreateInBatches(records, 500).Error
+}
+
+// Query implements Store.
+func (s *GormStore) Query(ctx context.Context, filter Filter) ([]Usage, error) {
+	tx := s.db.WithContext(ctx).Table(s.table)
+	if filter.Tenant != "" {
+		tx = tx.Where("tenant = ?", filter.Tenant)
+	}
+	if filter.Operation != "" {
+		tx = tx.Where("operation = ?", filter.Operation)
+	}
+	if filter.Metric != "" {
+		tx = tx.Where("metric = ?", string(filter.Metric))
+	}
+	if !filter.From.IsZero() {
+		tx = tx.Where("period_start >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		tx = tx.Where("period_start < ?", filter.To)
+	}
+
+	var records []usageRecord
+	if err := tx.Order("period_start, tenant, metric, operation").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+
+	usage := make([]Usage, 0, len(records))
+	for _, r := range records {
+		usage = append(usage, Usage{
+			Tenant:    r.Tenant,
+			Operation: r.Operation,
+			Metric:    Metric(r.Metric),
+			Period:    r.PeriodStart.UTC(),
+			Value:     r.Value,
+		})
+	}
+	return usage, nil
+}
@@ -3,9 +3,12 @@ package consul
 import (
 	"context"
 	"fmt"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/cloudwego/kitex/pkg/klog"
 	"github.com/hashicorp/consul/api"
 	"new-milli/registry"
 )
@@ -21,6 +24,8 @@ type Registry struct {
 	options registry.Options
 	sync.RWMutex
 	registrations map[string]*api.AgentServiceRegistration
+	heartbeats    map[string]context.CancelFunc
+	check         checkOptions
 }
 
 // New creates a new consul registry.
@@ -33,6 +38,19 @@ func New(opts ...registry.Option) (registry.Registry, error) {
 		o(&options)
 	}
 
+	check := checkOptionsFromContext(options.Context)
+	if check.typ == CheckTTL {
+		if check.ttl <= 0 {
+			return nil, fmt.Errorf("consul: invalid TTL %s", check.ttl)
+		}
+		if check.heartbeat <= 0 {
+			check.heartbeat = check.ttl / 2
+		}
+		if check.heartbeat <= 0 || check.heartbeat > check.ttl {
+			return nil, fmt.Errorf("consul: invalid heartbeat interval %s for TTL %s", check.heartbeat, check.ttl)
+		}
+	}
+
 	// Default to localhost
 	if len(options.Addrs) == 0 {
 		options.Addrs = []string{"127.0.0.1:8500"}
@@ -60,6 +78,8 @@ func New(opts ...registry.Option) (registry.Registry, error) {
 		client:        client,
 		options:       options,
 		registrations: make(map[string]*api.AgentServiceRegistration),
+		heartbeats:    make(map[string]context.CancelFunc),
+		check:         check,
 	}, nil
 }
 
@@ -69,24 +89,24 @@ func (r *Registry) Register(ctx context.Context, service *registry.ServiceInfo)
 		return fmt.Errorf("require at least one node")
 	}
 
-	// Create check
-	check := &api.AgentServiceCheck{
-		TTL:                            fmt.Sprintf("%ds", 30),
-		DeregisterCriticalServiceAfter: "1m",
-	}
-
 	r.Lock()
 	defer r.Unlock()
 
 	// Register each node
 	for _, node := range service.Nodes {
+		host, port, err := splitHostPort(node.Address)
+		if err != nil {
+			return err
+		}
+
 		registration := &api.AgentServiceRegistration{
 			ID:      node.ID,
 			Name:    service.Name,
 			Tags:    []string{service.Version},
-			Address: node.Address,
+			Address: host,
+			Port:    port,
 			Meta:    node.Metadata,
-			Check:   check,
+			Check:   r.newCheck(host, port, node),
 		}
 
 		// Register the service
@@ -96,6 +116,23 @@ func (r *Registry) Register(ctx context.Context, service *registry.ServiceInfo)
 
 		// Save the registration
 		r.registrations[node.ID] = registration
+
+		if r.check.typ != CheckTTL {
+			continue
+		}
+
+		// Mark the check as passing right away instead of waiting for the first heartbeat
+		if err := r.client.Agent().UpdateTTL(checkID(node.ID), "", api.HealthPassing); err != nil {
+			return err
+		}
+
+		// Keep the TTL check alive
+		if cancel, ok := r.heartbeats[node.ID]; ok {
+			cancel()
+		}
+		hctx, cancel := context.WithCancel(context.Background())
+		r.heartbeats[node.ID] = cancel
+		go r.heartbeat(hctx, registration)
 	}
 
 	return nil
@@ -107,6 +144,12 @@ func (r *Registry) Deregister(ctx context.Context, service *registry.ServiceInfo
 	defer r.Unlock()
 
 	for _, node := range service.Nodes {
+		// Stop the heartbeat
+		if cancel, ok := r.heartbeats[node.ID]; ok {
+			cancel()
+			delete(r.heartbeats, node.ID)
+		}
+
 		// Deregister the service
 		if err := r.client.Agent().ServiceDeregister(node.ID); err != nil {
 			return err
@@ -119,6 +162,79 @@ func (r *Registry) Deregister(ctx context.Context, service *registry.ServiceInfo
 	return nil
 }
 
+// newCheck creates the health check of a node.
+func (r *Registry) newCheck(host string, port int, node *registry.Node) *api.AgentServiceCheck {
+	check := &api.AgentServiceCheck{}
+	if r.check.deregisterTimeout > 0 {
+		check.DeregisterCriticalServiceAfter = r.check.deregisterTimeout.String()
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	switch r.check.typ {
+	case CheckHTTP:
+		scheme := "http"
+		if s := node.Metadata["scheme"]; s == "https" {
+			scheme = s
+		}
+		check.HTTP = scheme + "://" + addr + r.check.httpPath
+	case CheckGRPC:
+		check.GRPC = addr
+		check.GRPCUseTLS = r.check.grpcTLS
+	case CheckTCP:
+		check.TCP = addr
+	default:
+		check.TTL = r.check.ttl.String()
+		return check
+	}
+	check.Interval = r.check.interval.String()
+	check.Timeout = r.check.timeout.String()
+
+	return check
+}
+
+// heartbeat updates the TTL check of a registration until ctx is canceled.
+// If the agent no longer knows the service, for example after an agent
+// restart, the service is registered again.
+func (r *Registry) heartbeat(ctx context.Context, registration *api.AgentServiceRegistration) {
+	ticker := time.NewTicker(r.check.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.client.Agent().UpdateTTL(checkID(registration.ID), "", api.HealthPassing)
+			if err == nil {
+				continue
+			}
+			klog.Warnf("consul: failed to update TTL of %s: %v", registration.ID, err)
+
+			if err := r.client.Agent().ServiceRegister(registration); err != nil {
+				klog.Warnf("consul: failed to register %s again: %v", registration.ID, err)
+			}
+		}
+	}
+}
+
+// checkID returns the id of the health check of a service.
+func checkID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+// splitHostPort splits an address into host and numeric port.
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in address %s: %w", addr, err)
+	}
+	return host, port, nil
+}
+
 // GetService gets a service.
 func (r *Registry) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInfo, error) {
 	services, _, err := r.client.Health().Service(serviceName, "", true, nil)
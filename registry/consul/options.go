@@ -0,0 +1,127 @@
+package consul
+
+import (
+	"context"
+	"time"
+
+	"new-milli/registry"
+)
+
+// CheckType is the type of the consul health check.
+type CheckType string
+
+// Defines the supported health check types
+const (
+	// CheckTTL is a TTL check kept alive by the registry heartbeat.
+	CheckTTL CheckType = "ttl"
+	// CheckHTTP is an HTTP check performed by the consul agent.
+	CheckHTTP CheckType = "http"
+	// CheckGRPC is a gRPC health check performed by the consul agent.
+	CheckGRPC CheckType = "grpc"
+	// CheckTCP is a TCP connect check performed by the consul agent.
+	CheckTCP CheckType = "tcp"
+)
+
+// checkOptions is the health check configuration.
+type checkOptions struct {
+	typ               CheckType
+	ttl               time.Duration
+	heartbeat         time.Duration
+	interval          time.Duration
+	timeout           time.Duration
+	httpPath          string
+	grpcTLS           bool
+	deregisterTimeout time.Duration
+}
+
+// defaultCheckOptions returns the default health check configuration.
+func defaultCheckOptions() checkOptions {
+	return checkOptions{
+		typ:               CheckTTL,
+		ttl:               time.Second * 30,
+		interval:          time.Second * 10,
+		timeout:           time.Second * 5,
+		httpPath:          "/healthz",
+		deregisterTimeout: time.Minute,
+	}
+}
+
+type checkOptionsKey struct{}
+
+// setCheckOption updates the health check configuration in the registry options context.
+func setCheckOption(fn func(*checkOptions)) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		c := checkOptionsFromContext(o.Context)
+		fn(&c)
+		o.Context = context.WithValue(o.Context, checkOptionsKey{}, c)
+	}
+}
+
+// checkOptionsFromContext returns the health check configuration stored in ctx.
+func checkOptionsFromContext(ctx context.Context) checkOptions {
+	if c, ok := ctx.Value(checkOptionsKey{}).(checkOptions); ok {
+		return c
+	}
+	return defaultCheckOptions()
+}
+
+// Check sets the health check type. Defaults to CheckTTL.
+func Check(typ CheckType) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.typ = typ
+	})
+}
+
+// TTL sets the TTL of TTL checks, it must be positive. Defaults to 30s.
+func TTL(ttl time.Duration) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.ttl = ttl
+	})
+}
+
+// HeartbeatInterval sets how often TTL checks are updated, at most the TTL.
+// Defaults to half the TTL.
+func HeartbeatInterval(interval time.Duration) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.heartbeat = interval
+	})
+}
+
+// CheckInterval sets the interval of HTTP, gRPC and TCP checks.
+func CheckInterval(interval time.Duration) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.interval = interval
+	})
+}
+
+// CheckTimeout sets the timeout of HTTP, gRPC and TCP checks.
+func CheckTimeout(timeout time.Duration) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.timeout = timeout
+	})
+}
+
+// HTTPCheckPath sets the path requested by HTTP checks. Defaults to /healthz.
+func HTTPCheckPath(path string) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.httpPath = path
+	})
+}
+
+// GRPCCheckTLS enables TLS for gRPC checks.
+func GRPCCheckTLS(enable bool) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.grpcTLS = enable
+	})
+}
+
+// DeregisterCriticalServiceAfter sets how long a service may stay critical
+// before consul deregisters it. Zero disables the automatic deregistration.
+func DeregisterCriticalServiceAfter(d time.Duration) registry.Option {
+	return setCheckOption(func(c *checkOptions) {
+		c.deregisterTimeout = d
+	})
+}
@@ -0,0 +1,371 @@
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"new-milli/registry"
+)
+
+var (
+	_ registry.Registry = (*Registry)(nil)
+	_ registry.Watcher  = (*watcher)(nil)
+)
+
+const (
+	// VersionLabel is the service label holding the service version.
+	VersionLabel = "app.kubernetes.io/version"
+
+	// namespaceFile is the namespace of the pod when running in-cluster.
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+type (
+	namespaceKey     struct{}
+	labelSelectorKey struct{}
+	portNameKey      struct{}
+	kubeconfigKey    struct{}
+	clientsetKey     struct{}
+	resyncKey        struct{}
+)
+
+// Namespace sets the namespace services are looked up in.
+// Defaults to the namespace of the pod, or "default" outside the cluster.
+func Namespace(ns string) registry.Option {
+	return setOption(namespaceKey{}, ns)
+}
+
+// LabelSelector restricts discovered endpoints to those matching the selector,
+// e.g. "track=stable".
+func LabelSelector(selector string) registry.Option {
+	return setOption(labelSelectorKey{}, selector)
+}
+
+// PortName selects the named service port. Defaults to the first port.
+func PortName(name string) registry.Option {
+	return setOption(portNameKey{}, name)
+}
+
+// Kubeconfig uses the given kubeconfig file instead of the in-cluster config.
+func Kubeconfig(path string) registry.Option {
+	return setOption(kubeconfigKey{}, path)
+}
+
+// Clientset uses an existing Kubernetes client.
+func Clientset(c kubernetes.Interface) registry.Option {
+	return setOption(clientsetKey{}, c)
+}
+
+// Resync sets the informer resync period.
+func Resync(d time.Duration) registry.Option {
+	return setOption(resyncKey{}, d)
+}
+
+// setOption stores a provider specific option in the registry options context.
+func setOption(key, value interface{}) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, key, value)
+	}
+}
+
+// Registry is kubernetes registry.
+// Kubernetes manages endpoint membership through pod readiness,
+// so Register and Deregister are no-ops and discovery reads EndpointSlices.
+type Registry struct {
+	client        kubernetes.Interface
+	options       registry.Options
+	namespace     string
+	labelSelector string
+	portName      string
+	resync        time.Duration
+}
+
+// New creates a new kubernetes registry.
+func New(opts ...registry.Option) (registry.Registry, error) {
+	options := registry.Options{
+		Timeout: time.Second * 10,
+		Context: context.Background(),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	r := &Registry{
+		options:   options,
+		namespace: currentNamespace(),
+		resync:    time.Minute * 10,
+	}
+	if ns, ok := options.Context.Value(namespaceKey{}).(string); ok && ns != "" {
+		r.namespace = ns
+	}
+	if sel, ok := options.Context.Value(labelSelectorKey{}).(string); ok {
+		r.labelSelector = sel
+	}
+	if name, ok := options.Context.Value(portNameKey{}).(string); ok {
+		r.portName = name
+	}
+	if d, ok := options.Context.Value(resyncKey{}).(time.Duration); ok && d > 0 {
+		r.resync = d
+	}
+
+	if c, ok := options.Context.Value(clientsetKey{}).(kubernetes.Interface); ok {
+		r.client = c
+		return r, nil
+	}
+
+	// Create kubernetes client
+	var (
+		config *rest.Config
+		err    error
+	)
+	if path, ok := options.Context.Value(kubeconfigKey{}).(string); ok && path != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", path)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	r.client = client
+
+	return r, nil
+}
+
+// Register is a no-op, endpoints are managed by Kubernetes.
+func (r *Registry) Register(ctx context.Context, service *registry.ServiceInfo) error {
+	return nil
+}
+
+// Deregister is a no-op, endpoints are managed by Kubernetes.
+func (r *Registry) Deregister(ctx context.Context, service *registry.ServiceInfo) error {
+	return nil
+}
+
+// GetService gets a service. The request is bounded by the registry
+// timeout, which isn't set on the client so that the informer watches
+// aren't cut off.
+func (r *Registry) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInfo, error) {
+	if r.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.Timeout)
+		defer cancel()
+	}
+	list, err := r.client.DiscoveryV1().EndpointSlices(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: r.selector(serviceName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices := make([]*discoveryv1.EndpointSlice, 0, len(list.Items))
+	for i := range list.Items {
+		slices = append(slices, &list.Items[i])
+	}
+
+	services := r.toServices(serviceName, slices)
+	if len(services) == 0 {
+		return nil, registry.ErrNotFound
+	}
+	return services, nil
+}
+
+// Watch creates a watcher.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	return newWatcher(ctx, r, serviceName)
+}
+
+// selector returns the label selector of the service endpoint slices.
+func (r *Registry) selector(serviceName string) string {
+	sel := discoveryv1.LabelServiceName + "=" + serviceName
+	if r.labelSelector != "" {
+		sel += "," + r.labelSelector
+	}
+	return sel
+}
+
+// toServices converts endpoint slices into services grouped by version.
+func (r *Registry) toServices(serviceName string, slices []*discoveryv1.EndpointSlice) []*registry.ServiceInfo {
+	serviceMap := make(map[string]*registry.ServiceInfo)
+	var result []*registry.ServiceInfo
+
+	for _, slice := range slices {
+		port, portName, ok := r.port(slice)
+		if !ok {
+			continue
+		}
+
+		version := slice.Labels[VersionLabel]
+		if version == "" {
+			version = "latest"
+		}
+
+		// Get or create the service
+		s, ok := serviceMap[version]
+		if !ok {
+			s = &registry.ServiceInfo{
+				Name:     serviceName,
+				Version:  version,
+				Metadata: slice.Labels,
+			}
+			serviceMap[version] = s
+			result = append(result, s)
+		}
+
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				node := &registry.Node{
+					ID:       addr,
+					Address:  net.JoinHostPort(addr, strconv.Itoa(int(port))),
+					Metadata: map[string]string{"port": portName},
+				}
+				if ep.TargetRef != nil {
+					node.ID = ep.TargetRef.Name
+					node.Metadata["pod"] = ep.TargetRef.Name
+				}
+				if ep.Zone != nil {
+					node.Metadata["zone"] = *ep.Zone
+				}
+				if ep.NodeName != nil {
+					node.Metadata["node"] = *ep.NodeName
+				}
+				s.Nodes = append(s.Nodes, node)
+			}
+		}
+	}
+
+	return result
+}
+
+// port returns the selected port of an endpoint slice.
+func (r *Registry) port(slice *discoveryv1.EndpointSlice) (int32, string, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		name := ""
+		if p.Name != nil {
+			name = *p.Name
+		}
+		if r.portName == "" || r.portName == name {
+			return *p.Port, name, true
+		}
+	}
+	return 0, "", false
+}
+
+// currentNamespace returns the namespace of the pod, or "default".
+func currentNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := os.ReadFile(namespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
+
+// watcher is a service watcher backed by an EndpointSlice informer.
+type watcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	r      *Registry
+	name   string
+	ch     chan []*registry.ServiceInfo
+}
+
+// newWatcher creates a new watcher.
+func newWatcher(ctx context.Context, r *Registry, name string) (*watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watcher{
+		ctx:    ctx,
+		cancel: cancel,
+		r:      r,
+		name:   name,
+		ch:     make(chan []*registry.ServiceInfo, 1),
+	}
+
+	// Create the informer
+	factory := informers.NewSharedInformerFactoryWithOptions(r.client, r.resync,
+		informers.WithNamespace(r.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = r.selector(name)
+		}),
+	)
+	endpointSlices := factory.Discovery().V1().EndpointSlices()
+	lister := endpointSlices.Lister()
+
+	notify := func() {
+		slices, err := lister.EndpointSlices(r.namespace).List(labels.Everything())
+		if err != nil {
+			return
+		}
+		services := r.toServices(name, slices)
+
+		// Only keep the latest update
+		select {
+		case <-w.ch:
+		default:
+		}
+		select {
+		case w.ch <- services:
+		default:
+		}
+	}
+
+	informer := endpointSlices.Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Start the informer
+	factory.Start(ctx.Done())
+
+	return w, nil
+}
+
+// Next returns the next service update.
+func (w *watcher) Next() ([]*registry.ServiceInfo, error) {
+	select {
+	case <-w.ctx.Done():
+		return nil, registry.ErrWatchCanceled
+	case services := <-w.ch:
+		return services, nil
+	}
+}
+
+// Stop stops the watcher.
+func (w *watcher) Stop() error {
+	w.cancel()
+	return nil
+}
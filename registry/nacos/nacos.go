@@ -0,0 +1,439 @@
+package nacos
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nacos-group/nacos-sdk-go/v2/clients"
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	"new-milli/registry"
+)
+
+var (
+	_ registry.Registry = (*Registry)(nil)
+	_ registry.Watcher  = (*watcher)(nil)
+)
+
+const (
+	// versionKey is the instance metadata key holding the service version.
+	versionKey = "version"
+	// idKey is the instance metadata key holding the node id.
+	idKey = "id"
+)
+
+type (
+	namespaceKey struct{}
+	groupKey     struct{}
+	clusterKey   struct{}
+	weightKey    struct{}
+	heartbeatKey struct{}
+	logDirKey    struct{}
+	cacheDirKey  struct{}
+)
+
+// Namespace sets the nacos namespace id.
+func Namespace(ns string) registry.Option {
+	return setOption(namespaceKey{}, ns)
+}
+
+// Group sets the nacos group. Defaults to DEFAULT_GROUP.
+func Group(group string) registry.Option {
+	return setOption(groupKey{}, group)
+}
+
+// Cluster sets the nacos cluster instances are registered in and discovered from.
+func Cluster(cluster string) registry.Option {
+	return setOption(clusterKey{}, cluster)
+}
+
+// Weight sets the weight of registered instances.
+func Weight(weight float64) registry.Option {
+	return setOption(weightKey{}, weight)
+}
+
+// HeartbeatInterval sets the interval at which instances send heartbeats.
+func HeartbeatInterval(d time.Duration) registry.Option {
+	return setOption(heartbeatKey{}, d)
+}
+
+// LogDir sets the directory of the nacos client logs.
+func LogDir(dir string) registry.Option {
+	return setOption(logDirKey{}, dir)
+}
+
+// CacheDir sets the directory of the nacos client cache.
+func CacheDir(dir string) registry.Option {
+	return setOption(cacheDirKey{}, dir)
+}
+
+// setOption stores a provider specific option in the registry options context.
+func setOption(key, value interface{}) registry.Option {
+	return func(o *registry.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, key, value)
+	}
+}
+
+// Registry is nacos registry.
+type Registry struct {
+	client  naming_client.INamingClient
+	options registry.Options
+	group   string
+	cluster string
+	weight  float64
+	sync.RWMutex
+	// registrations holds the registered instances by service name and
+	// node id
+	registrations map[string]vo.RegisterInstanceParam
+}
+
+// New creates a new nacos registry.
+func New(opts ...registry.Option) (registry.Registry, error) {
+	options := registry.Options{
+		Timeout: time.Second * 10,
+		Context: context.Background(),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	// Default to localhost
+	if len(options.Addrs) == 0 {
+		options.Addrs = []string{"127.0.0.1:8848"}
+	}
+
+	// Create server configs
+	var serverConfigs []constant.ServerConfig
+	for _, addr := range options.Addrs {
+		host, port, err := splitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var serverOpts []constant.ServerOption
+		if options.Secure {
+			serverOpts = append(serverOpts, constant.WithScheme("https"))
+		}
+		serverConfigs = append(serverConfigs, *constant.NewServerConfig(host, port, serverOpts...))
+	}
+
+	// Create client config
+	clientOpts := []constant.ClientOption{
+		constant.WithTimeoutMs(uint64(options.Timeout.Milliseconds())),
+		constant.WithNotLoadCacheAtStart(true),
+	}
+	if ns, ok := options.Context.Value(namespaceKey{}).(string); ok {
+		clientOpts = append(clientOpts, constant.WithNamespaceId(ns))
+	}
+	if d, ok := options.Context.Value(heartbeatKey{}).(time.Duration); ok && d > 0 {
+		clientOpts = append(clientOpts, constant.WithBeatInterval(d.Milliseconds()))
+	}
+	if dir, ok := options.Context.Value(logDirKey{}).(string); ok {
+		clientOpts = append(clientOpts, constant.WithLogDir(dir))
+	}
+	if dir, ok := options.Context.Value(cacheDirKey{}).(string); ok {
+		clientOpts = append(clientOpts, constant.WithCacheDir(dir))
+	}
+	if len(options.Username) > 0 && len(options.Password) > 0 {
+		clientOpts = append(clientOpts,
+			constant.WithUsername(options.Username),
+			constant.WithPassword(options.Password),
+		)
+	}
+
+	client, err := clients.NewNamingClient(vo.NacosClientParam{
+		ClientConfig:  constant.NewClientConfig(clientOpts...),
+		ServerConfigs: serverConfigs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		client:        client,
+		options:       options,
+		group:         constant.DEFAULT_GROUP,
+		weight:        100,
+		registrations: make(map[string]vo.RegisterInstanceParam),
+	}
+	if group, ok := options.Context.Value(groupKey{}).(string); ok && group != "" {
+		r.group = group
+	}
+	if cluster, ok := options.Context.Value(clusterKey{}).(string); ok {
+		r.cluster = cluster
+	}
+	if weight, ok := options.Context.Value(weightKey{}).(float64); ok && weight > 0 {
+		r.weight = weight
+	}
+
+	return r, nil
+}
+
+// Register registers a service.
+// Instances are ephemeral, the nacos client keeps them alive and
+// registers them again after reconnecting to the server.
+func (r *Registry) Register(ctx context.Context, service *registry.ServiceInfo) error {
+	if len(service.Nodes) == 0 {
+		return fmt.Errorf("require at least one node")
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// Register each node
+	for _, node := range service.Nodes {
+		host, port, err := splitHostPort(node.Address)
+		if err != nil {
+			return err
+		}
+
+		// Service metadata is propagated to every instance, node metadata wins
+		metadata := make(map[string]string, len(service.Metadata)+len(node.Metadata)+2)
+		for k, v := range service.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range node.Metadata {
+			metadata[k] = v
+		}
+		metadata[versionKey] = service.Version
+		metadata[idKey] = node.ID
+
+		param := vo.RegisterInstanceParam{
+			Ip:          host,
+			Port:        port,
+			Weight:      r.weight,
+			Enable:      true,
+			Healthy:     true,
+			Ephemeral:   true,
+			Metadata:    metadata,
+			ClusterName: r.cluster,
+			ServiceName: service.Name,
+			GroupName:   r.group,
+		}
+
+		// Register the instance
+		if _, err := r.client.RegisterInstance(param); err != nil {
+			return err
+		}
+
+		// Save the registration so Deregister removes this exact instance
+		r.registrations[service.Name+"/"+node.ID] = param
+	}
+
+	return nil
+}
+
+// Deregister deregisters a service.
+func (r *Registry) Deregister(ctx context.Context, service *registry.ServiceInfo) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, node := range service.Nodes {
+		key := service.Name + "/" + node.ID
+		param, ok := r.registrations[key]
+		if !ok {
+			// Not registered through this registry, e.g. by a previous process
+			host, port, err := splitHostPort(node.Address)
+			if err != nil {
+				return err
+			}
+			param = vo.RegisterInstanceParam{
+				Ip:          host,
+				Port:        port,
+				ClusterName: r.cluster,
+				ServiceName: service.Name,
+				GroupName:   r.group,
+			}
+		}
+
+		// Deregister the instance
+		_, err := r.client.DeregisterInstance(vo.DeregisterInstanceParam{
+			Ip:          param.Ip,
+			Port:        param.Port,
+			Cluster:     param.ClusterName,
+			ServiceName: param.ServiceName,
+			GroupName:   param.GroupName,
+			Ephemeral:   true,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Delete the registration
+		delete(r.registrations, key)
+	}
+
+	return nil
+}
+
+// GetService gets a service.
+func (r *Registry) GetService(ctx context.Context, serviceName string) ([]*registry.ServiceInfo, error) {
+	instances, err := r.client.SelectInstances(vo.SelectInstancesParam{
+		Clusters:    r.clusters(),
+		ServiceName: serviceName,
+		GroupName:   r.group,
+		HealthyOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	services := toServices(serviceName, instances)
+	if len(services) == 0 {
+		return nil, registry.ErrNotFound
+	}
+	return services, nil
+}
+
+// Watch creates a watcher.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (registry.Watcher, error) {
+	return newWatcher(ctx, r, serviceName)
+}
+
+// clusters returns the clusters used for discovery.
+func (r *Registry) clusters() []string {
+	if r.cluster == "" {
+		return nil
+	}
+	return []string{r.cluster}
+}
+
+// toServices converts nacos instances into services grouped by version.
+func toServices(serviceName string, instances []model.Instance) []*registry.ServiceInfo {
+	serviceMap := make(map[string]*registry.ServiceInfo)
+	var result []*registry.ServiceInfo
+
+	for _, ins := range instances {
+		if !ins.Enable || !ins.Healthy {
+			continue
+		}
+
+		// Get the version
+		version := ins.Metadata[versionKey]
+		if version == "" {
+			version = "latest"
+		}
+
+		// Get or create the service
+		s, ok := serviceMap[version]
+		if !ok {
+			s = &registry.ServiceInfo{
+				Name:    serviceName,
+				Version: version,
+			}
+			serviceMap[version] = s
+			result = append(result, s)
+		}
+
+		// Add the node
+		id := ins.Metadata[idKey]
+		if id == "" {
+			id = ins.InstanceId
+		}
+		metadata := make(map[string]string, len(ins.Metadata)+1)
+		for k, v := range ins.Metadata {
+			if k == versionKey || k == idKey {
+				continue
+			}
+			metadata[k] = v
+		}
+		metadata["weight"] = strconv.FormatFloat(ins.Weight, 'f', -1, 64)
+
+		s.Nodes = append(s.Nodes, &registry.Node{
+			ID:       id,
+			Address:  net.JoinHostPort(ins.Ip, strconv.FormatUint(ins.Port, 10)),
+			Metadata: metadata,
+		})
+	}
+
+	return result
+}
+
+// splitHostPort splits an address into host and numeric port.
+func splitHostPort(addr string) (string, uint64, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in address %s: %w", addr, err)
+	}
+	return host, port, nil
+}
+
+// watcher is a service watcher.
+type watcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	r      *Registry
+	name   string
+	ch     chan []*registry.ServiceInfo
+	param  *vo.SubscribeParam
+}
+
+// newWatcher creates a new watcher.
+func newWatcher(ctx context.Context, r *Registry, name string) (*watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watcher{
+		ctx:    ctx,
+		cancel: cancel,
+		r:      r,
+		name:   name,
+		ch:     make(chan []*registry.ServiceInfo, 1),
+	}
+
+	w.param = &vo.SubscribeParam{
+		ServiceName: name,
+		Clusters:    r.clusters(),
+		GroupName:   r.group,
+		SubscribeCallback: func(instances []model.Instance, err error) {
+			if err != nil {
+				return
+			}
+			services := toServices(name, instances)
+
+			// Only keep the latest update
+			select {
+			case <-w.ch:
+			default:
+			}
+			select {
+			case w.ch <- services:
+			default:
+			}
+		},
+	}
+
+	// Subscribe to the service
+	if err := r.client.Subscribe(w.param); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Next returns the next service update.
+func (w *watcher) Next() ([]*registry.ServiceInfo, error) {
+	select {
+	case <-w.ctx.Done():
+		return nil, registry.ErrWatchCanceled
+	case services := <-w.ch:
+		return services, nil
+	}
+}
+
+// Stop stops the watcher.
+func (w *watcher) Stop() error {
+	w.cancel()
+	return w.r.client.Unsubscribe(w.param)
+}
@@ -0,0 +1,155 @@
+package requestctx
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"new-milli/middleware"
+	"new-milli/transport"
+)
+
+// Headers read and written by Server.
+const (
+	// HeaderRequestID is the header carrying the request id, echoed in the reply.
+	HeaderRequestID = "X-Request-Id"
+	// HeaderAcceptLanguage is the header carrying the locales accepted by the caller.
+	HeaderAcceptLanguage = "Accept-Language"
+)
+
+// Option is request context middleware option.
+type Option func(*options)
+
+// options is request context middleware options.
+type options struct {
+	disabled  bool
+	header    string
+	generator func() string
+	locales   []string
+}
+
+// WithDisabled returns an Option that disables the middleware.
+func WithDisabled(disabled bool) Option {
+	return func(o *options) {
+		o.disabled = disabled
+	}
+}
+
+// WithRequestIDHeader returns an Option that sets the header carrying the
+// request id, HeaderRequestID by default.
+func WithRequestIDHeader(header string) Option {
+	return func(o *options) {
+		o.header = header
+	}
+}
+
+// WithRequestIDGenerator returns an Option that sets how ids are generated
+// for requests without one, random UUIDs by default.
+func WithRequestIDGenerator(fn func() string) Option {
+	return func(o *options) {
+		o.generator = fn
+	}
+}
+
+// WithLocales returns an Option that sets the supported locales negotiated
+// from the Accept-Language header, requests accepting none of them get
+// DefaultLocale. Without it the preferred locale of the caller is used.
+func WithLocales(locales ...string) Option {
+	return func(o *options) {
+		o.locales = locales
+	}
+}
+
+// Server returns a middleware storing the request id and the locale in the
+// context. The request id is taken from the request header or generated,
+// and echoed in the reply header.
+func Server(opts ...Option) middleware.Middleware {
+	cfg := options{
+		header:    HeaderRequestID,
+		generator: uuid.NewString,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.disabled {
+		return func(handler middleware.Handler) middleware.Handler {
+			return handler
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			var id, accept string
+			tr, ok := transport.FromServerContext(ctx)
+			if ok {
+				id = tr.RequestHeader().Get(cfg.header)
+				accept = tr.RequestHeader().Get(HeaderAcceptLanguage)
+			}
+			if id == "" {
+				id = cfg.generator()
+			}
+			if ok {
+				tr.ReplyHeader().Set(cfg.header, id)
+			}
+
+			ctx = ContextWithRequestID(ctx, id)
+			if locale := Negotiate(accept, cfg.locales); locale != "" {
+				ctx = ContextWithLocale(ctx, locale)
+			}
+			return handler(ctx, req)
+		}
+	}
+}
+
+// Negotiate returns the supported locale preferred by an Accept-Language
+// header, matching "zh-CN" to a supported "zh" and the other way around.
+// Without supported locales it returns the preferred locale, and it is
+// empty when none is accepted.
+func Negotiate(accept string, supported []string) string {
+	type tag struct {
+		name string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" || name == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{name: name, q: q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	if len(supported) == 0 {
+		if len(tags) == 0 {
+			return ""
+		}
+		return tags[0].name
+	}
+	for _, t := range tags {
+		for _, s := range supported {
+			if strings.EqualFold(s, t.name) {
+				return s
+			}
+		}
+		base, _, _ := strings.Cut(t.name, "-")
+		for _, s := range supported {
+			sbase, _, _ := strings.Cut(s, "-")
+			if strings.EqualFold(s, base) || strings.EqualFold(sbase, t.name) {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+package requestctx
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"new-milli/middleware/auth/jwt"
+	"new-milli/middleware/authz"
+	"new-milli/quota"
+	"new-milli/transport"
+)
+
+// DefaultLocale is the locale of requests without a supported locale.
+var DefaultLocale = "en"
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// ID is the subject of the caller, empty for anonymous requests.
+	ID string
+	// Roles are the roles of the caller.
+	Roles []string
+	// Claims are the claims of the token of the caller, if any.
+	Claims map[string]interface{}
+}
+
+// HasRole reports whether the principal has role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Values is a snapshot of the request-scoped values, e.g. for a template or
+// an audit record.
+type Values struct {
+	RequestID string    `json:"request_id,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Roles     []string  `json:"roles,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Locale    string    `json:"locale,omitempty"`
+	Deadline  time.Time `json:"deadline,omitempty"`
+}
+
+type (
+	requestIDKey struct{}
+	principalKey struct{}
+	tenantKey    struct{}
+	localeKey    struct{}
+)
+
+// ContextWithRequestID returns a new Context that carries the request id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// ContextWithPrincipal returns a new Context that carries the principal,
+// taking precedence over the authz subject and the JWT claims.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// ContextWithTenant returns a new Context that carries the tenant, taking
+// precedence over the tenant of the quota middleware.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// ContextWithLocale returns a new Context that carries the locale.
+func ContextWithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// RequestID returns the id of the request set by Server, or the request
+// header HeaderRequestID, empty when there is none.
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	if tr, ok := transport.FromServerContext(ctx); ok {
+		return tr.RequestHeader().Get(HeaderRequestID)
+	}
+	return ""
+}
+
+// TraceID returns the id of the trace of the request, empty when it isn't traced.
+func TraceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// User returns the authenticated caller: the principal set with
+// ContextWithPrincipal, the subject of the authz middleware or the subject
+// of the JWT middleware, in that order. It is the zero Principal for
+// anonymous requests.
+func User(ctx context.Context) Principal {
+	if p, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return p
+	}
+	if s, ok := authz.FromContext(ctx); ok {
+		return Principal{ID: s.ID, Roles: s.Roles, Claims: s.Claims}
+	}
+	if claims, ok := jwt.FromContext(ctx); ok {
+		sub, _ := claims.GetSubject()
+		return Principal{ID: sub, Claims: claims}
+	}
+	return Principal{}
+}
+
+// UserID returns the id of the authenticated caller, empty for anonymous requests.
+func UserID(ctx context.Context) string {
+	return User(ctx).ID
+}
+
+// Authenticated reports whether the request has an authenticated caller.
+func Authenticated(ctx context.Context) bool {
+	return UserID(ctx) != ""
+}
+
+// Tenant returns the tenant set with ContextWithTenant, by the quota
+// middleware or in the quota.HeaderTenant request header, empty when there
+// is none.
+func Tenant(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	if tenant, ok := quota.FromContext(ctx); ok {
+		return tenant
+	}
+	return quota.TenantFromHeader(ctx)
+}
+
+// Locale returns the locale negotiated by Server, DefaultLocale when there is none.
+func Locale(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok && locale != "" {
+		return locale
+	}
+	return DefaultLocale
+}
+
+// Deadline returns the deadline of the request, if any.
+func Deadline(ctx context.Context) (time.Time, bool) {
+	return ctx.Deadline()
+}
+
+// Remaining returns the time left before the deadline of the request, 0
+// when it has passed and -1 when there is none.
+func Remaining(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return -1
+	}
+	if d := time.Until(deadline); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Snapshot returns the request-scoped values of ctx.
+func Snapshot(ctx context.Context) Values {
+	user := User(ctx)
+	deadline, _ := ctx.Deadline()
+	return Values{
+		RequestID: RequestID(ctx),
+		TraceID:   TraceID(ctx),
+		UserID:    user.ID,
+		Roles:     user.Roles,
+		Tenant:    Tenant(ctx),
+		Locale:    Locale(ctx),
+		Deadline:  deadline,
+	}
+}
@@ -0,0 +1,89 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Archiver writes expired records before they are deleted.
+type Archiver interface {
+	// Archive writes the records of target, it must not return before
+	// they are durably stored.
+	Archive(ctx context.Context, target string, records []interface{}) error
+}
+
+// ObjectStore is an object storage bucket, such as S3, GCS or OSS.
+type ObjectStore interface {
+	// Put stores data under key.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+}
+
+// ObjectArchiver archives records to an object store as JSON lines, one
+// object per batch under prefix/target/yyyy/mm/dd/.
+type ObjectArchiver struct {
+	store  ObjectStore
+	prefix string
+}
+
+// NewObjectArchiver creates an archiver writing to store under prefix.
+func NewObjectArchiver(store ObjectStore, prefix string) *ObjectArchiver {
+	return &ObjectArchiver{store: store, prefix: prefix}
+}
+
+// Archive implements Archiver.
+func (a *ObjectArchiver) Archive(ctx context.Context, target string, records []interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("failed to marshal record: %w", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	key := path.Join(a.prefix, target, now.Format("2006/01/02"), strconv.FormatInt(now.UnixNano(), 10)+".jsonl")
+	return a.store.Put(ctx, key, buf.Bytes(), "application/x-ndjson")
+}
+
+// DirStore is an object store in a local directory, e.g. a mounted volume.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore creates an object store in dir.
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+// Put implements ObjectStore.
+func (s *DirStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
@@ -0,0 +1,412 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+	"new-milli/scheduler"
+)
+
+// ErrDuplicatePolicy is returned when two policies target the same name.
+var ErrDuplicatePolicy = errors.New("retention: duplicate policy")
+
+// Batch is a batch of expired records of a target.
+type Batch struct {
+	// Keys identify the records to delete.
+	Keys []interface{}
+	// Records are the records archived before they are deleted.
+	Records []interface{}
+}
+
+// Target is data under a retention policy, such as a table, an index or a
+// topic. Records are removed oldest first.
+type Target interface {
+	// Name identifies the target in logs, metrics and archive keys.
+	Name() string
+	// Expired returns up to limit of the oldest records created before
+	// cutoff, an empty batch when there are none.
+	Expired(ctx context.Context, cutoff time.Time, limit int) (*Batch, error)
+	// Delete deletes the records of a batch.
+	Delete(ctx context.Context, b *Batch) error
+}
+
+// Sizer is implemented by targets supporting a size limit.
+type Sizer interface {
+	// Size returns the size of the target, in the unit of Policy.MaxSize.
+	Size(ctx context.Context) (int64, error)
+}
+
+// Counter is implemented by targets counting their expired records, used by
+// dry runs.
+type Counter interface {
+	// CountExpired returns the number of records created before cutoff.
+	CountExpired(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Policy is the retention policy of a target.
+type Policy struct {
+	// Target is the data under the policy.
+	Target Target
+	// MaxAge removes the records older than it, zero keeps them.
+	MaxAge time.Duration
+	// MaxSize removes the oldest records while the target is larger, zero
+	// disables it. The target must implement Sizer.
+	MaxSize int64
+	// Archive writes the records to the archiver before deleting them.
+	Archive bool
+}
+
+// retentionMetrics is the metrics of the retention runs.
+type retentionMetrics struct {
+	records     *prometheus.CounterVec
+	batches     *prometheus.HistogramVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+// newRetentionMetrics creates the retention metrics registered with
+// registry, reusing the registered ones. They aren't registered when
+// registry is nil.
+func newRetentionMetrics(registry prometheus.Registerer) *retentionMetrics {
+	m := &retentionMetrics{
+		records: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "retention",
+			Name:      "records_total",
+			Help:      "Number of expired records by action.",
+		}, []string{"target", "action"}),
+		batches: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "new_milli",
+			Subsystem: "retention",
+			Name:      "batch_duration_seconds",
+			Help:      "Duration of the retention batches.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"target", "status"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "retention",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful retention run.",
+		}, []string{"target"}),
+	}
+	if registry != nil {
+		var errs [3]error
+		m.records, errs[0] = collector.Register(registry, m.records)
+		m.batches, errs[1] = collector.Register(registry, m.batches)
+		m.lastSuccess, errs[2] = collector.Register(registry, m.lastSuccess)
+		if err := errors.Join(errs[:]...); err != nil {
+			klog.Warnf("Failed to register retention metrics: %v", err)
+		}
+	}
+	return m
+}
+
+// Option is retention manager option.
+type Option func(*options)
+
+// options is retention manager options.
+type options struct {
+	archiver   Archiver
+	batchSize  int
+	batchDelay time.Duration
+	maxBatches int
+	dryRun     bool
+	registry   prometheus.Registerer
+}
+
+// WithArchiver sets where the records of the policies with Archive are written.
+func WithArchiver(a Archiver) Option {
+	return func(o *options) {
+		o.archiver = a
+	}
+}
+
+// WithBatchSize sets the number of records removed per batch, 1000 by default.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithBatchDelay sets the pause between batches, limiting the load on the target.
+func WithBatchDelay(delay time.Duration) Option {
+	return func(o *options) {
+		o.batchDelay = delay
+	}
+}
+
+// WithMaxBatches sets the number of batches of a target per run, unlimited by default.
+func WithMaxBatches(n int) Option {
+	return func(o *options) {
+		o.maxBatches = n
+	}
+}
+
+// WithDryRun reports the records that would be removed without archiving or
+// deleting them.
+func WithDryRun(dryRun bool) Option {
+	return func(o *options) {
+		o.dryRun = dryRun
+	}
+}
+
+// WithRegistry sets the registry of the retention metrics,
+// prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// Result is the outcome of applying a policy.
+type Result struct {
+	Target   string
+	Archived int64
+	Deleted  int64
+	// Expired is the number of records that would be removed by a dry run.
+	Expired  int64
+	Batches  int
+	Duration time.Duration
+}
+
+// Manager applies retention policies.
+type Manager struct {
+	opts    options
+	metrics *retentionMetrics
+
+	mu       sync.Mutex
+	policies []Policy
+}
+
+// New creates a retention manager.
+func New(opts ...Option) *Manager {
+	o := options{
+		batchSize: 1000,
+		registry:  prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Manager{opts: o, metrics: newRetentionMetrics(o.registry)}
+}
+
+// Register registers a policy.
+func (m *Manager) Register(p Policy) error {
+	name := p.Target.Name()
+	if p.MaxSize > 0 {
+		if _, ok := p.Target.(Sizer); !ok {
+			return fmt.Errorf("retention: %s has a size limit but no Size", name)
+		}
+	}
+	if p.Archive && m.opts.archiver == nil {
+		return fmt.Errorf("retention: %s is archived but no archiver is set", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.policies {
+		if existing.Target.Name() == name {
+			return fmt.Errorf("%w: %s", ErrDuplicatePolicy, name)
+		}
+	}
+	m.policies = append(m.policies, p)
+	return nil
+}
+
+// Schedule adds a job per policy to s, named "retention:" followed by the
+// target name and running on spec. Pass scheduler.WithDistributedLock so a
+// single instance applies each policy.
+func (m *Manager) Schedule(s *scheduler.Scheduler, spec string, opts ...scheduler.JobOption) error {
+	m.mu.Lock()
+	policies := append([]Policy(nil), m.policies...)
+	m.mu.Unlock()
+
+	for _, p := range policies {
+		p := p
+		err := s.Add("retention:"+p.Target.Name(), spec, func(ctx context.Context) error {
+			_, err := m.Apply(ctx, p)
+			return err
+		}, opts...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run applies every policy once and returns their results.
+func (m *Manager) Run(ctx context.Context) ([]Result, error) {
+	m.mu.Lock()
+	policies := append([]Policy(nil), m.policies...)
+	m.mu.Unlock()
+
+	results := make([]Result, 0, len(policies))
+	var errs []error
+	for _, p := range policies {
+		r, err := m.Apply(ctx, p)
+		results = append(results, r)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// Apply applies a policy: the records older than MaxAge are removed, then
+// the oldest records while the target exceeds MaxSize.
+func (m *Manager) Apply(ctx context.Context, p Policy) (Result, error) {
+	start := time.Now()
+	r := Result{Target: p.Target.Name()}
+
+	err := m.apply(ctx, p, &r)
+	r.Duration = time.Since(start)
+	if err != nil {
+		klog.CtxErrorf(ctx, "retention: %s failed after %d batches: %v", r.Target, r.Batches, err)
+		return r, fmt.Errorf("retention %s: %w", r.Target, err)
+	}
+
+	m.metrics.lastSuccess.WithLabelValues(r.Target).SetToCurrentTime()
+	if m.opts.dryRun {
+		klog.CtxInfof(ctx, "retention: %s would remove %d records (dry run)", r.Target, r.Expired)
+	} else {
+		klog.CtxInfof(ctx, "retention: %s archived %d and deleted %d records in %d batches (%s)",
+			r.Target, r.Archived, r.Deleted, r.Batches, r.Duration)
+	}
+	return r, nil
+}
+
+// apply applies a policy and accumulates its result.
+func (m *Manager) apply(ctx context.Context, p Policy, r *Result) error {
+	if p.MaxAge > 0 {
+		cutoff := time.Now().Add(-p.MaxAge)
+		if err := m.remove(ctx, p, cutoff, nil, r); err != nil {
+			return err
+		}
+	}
+	if p.MaxSize > 0 {
+		sizer := p.Target.(Sizer)
+		excess := func(ctx context.Context) (int64, error) {
+			size, err := sizer.Size(ctx)
+			return size - p.MaxSize, err
+		}
+		if err := m.remove(ctx, p, time.Now(), excess, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove removes the records created before cutoff in batches. When excess
+// is set, it removes them while the target exceeds its size limit.
+func (m *Manager) remove(ctx context.Context, p Policy, cutoff time.Time, excess func(context.Context) (int64, error), r *Result) error {
+	name := p.Target.Name()
+
+	if m.opts.dryRun {
+		n, err := m.countExpired(ctx, p, cutoff, excess)
+		r.Expired += n
+		m.metrics.records.WithLabelValues(name, "expired").Add(float64(n))
+		return err
+	}
+
+	for batches := 0; m.opts.maxBatches <= 0 || batches < m.opts.maxBatches; batches++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		limit, err := m.limit(ctx, excess)
+		if err != nil || limit == 0 {
+			return err
+		}
+
+		start := time.Now()
+		n, err := m.batch(ctx, p, cutoff, limit, r)
+		status := "success"
+		if err != nil {
+			status = "failure"
+		}
+		m.metrics.batches.WithLabelValues(name, status).Observe(time.Since(start).Seconds())
+		if err != nil || n == 0 {
+			return err
+		}
+		r.Batches++
+
+		if m.opts.batchDelay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(m.opts.batchDelay):
+			}
+		}
+	}
+	return nil
+}
+
+// countExpired returns the number of records remove would remove. Nothing
+// is deleted by a dry run, so only the first batch can be listed when the
+// target can't count its expired records.
+func (m *Manager) countExpired(ctx context.Context, p Policy, cutoff time.Time, excess func(context.Context) (int64, error)) (int64, error) {
+	if excess != nil {
+		n, err := excess(ctx)
+		if err != nil || n <= 0 {
+			return 0, err
+		}
+		return n, nil
+	}
+	if counter, ok := p.Target.(Counter); ok {
+		return counter.CountExpired(ctx, cutoff)
+	}
+	b, err := p.Target.Expired(ctx, cutoff, m.opts.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(b.Keys)), nil
+}
+
+// limit returns the size of the next batch, capped by the excess of the
+// target over its size limit when excess is set.
+func (m *Manager) limit(ctx context.Context, excess func(context.Context) (int64, error)) (int, error) {
+	if excess == nil {
+		return m.opts.batchSize, nil
+	}
+	n, err := excess(ctx)
+	if err != nil || n <= 0 {
+		return 0, err
+	}
+	if n < int64(m.opts.batchSize) {
+		return int(n), nil
+	}
+	return m.opts.batchSize, nil
+}
+
+// batch archives and deletes a batch and returns its size.
+func (m *Manager) batch(ctx context.Context, p Policy, cutoff time.Time, limit int, r *Result) (int, error) {
+	name := p.Target.Name()
+
+	b, err := p.Target.Expired(ctx, cutoff, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired records: %w", err)
+	}
+	if len(b.Keys) == 0 {
+		return 0, nil
+	}
+
+	if p.Archive {
+		if err := m.opts.archiver.Archive(ctx, name, b.Records); err != nil {
+			return 0, fmt.Errorf("failed to archive records: %w", err)
+		}
+		r.Archived += int64(len(b.Records))
+		m.metrics.records.WithLabelValues(name, "archived").Add(float64(len(b.Records)))
+	}
+
+	if err := p.Target.Delete(ctx, b); err != nil {
+		return 0, fmt.Errorf("failed to delete records: %w", err)
+	}
+	r.Deleted += int64(len(b.Keys))
+	m.metrics.records.WithLabelValues(name, "deleted").Add(float64(len(b.Keys)))
+	return len(b.Keys), nil
+}
@@ -0,0 +1,79 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Target  = (*Table)(nil)
+	_ Sizer   = (*Table)(nil)
+	_ Counter = (*Table)(nil)
+)
+
+// Table is a SQL table under a retention policy, its size is its number of rows.
+type Table struct {
+	db         *gorm.DB
+	table      string
+	timeColumn string
+	keyColumn  string
+}
+
+// NewTable creates a target for table, whose records are created at
+// timeColumn and identified by keyColumn. timeColumn should be indexed.
+func NewTable(db *gorm.DB, table, timeColumn, keyColumn string) *Table {
+	return &Table{db: db, table: table, timeColumn: timeColumn, keyColumn: keyColumn}
+}
+
+// Name implements Target.
+func (t *Table) Name() string {
+	return t.table
+}
+
+// Expired implements Target.
+func (t *Table) Expired(ctx context.Context, cutoff time.Time, limit int) (*Batch, error) {
+	var rows []map[string]interface{}
+	err := t.db.WithContext(ctx).Table(t.table).
+		Where(t.db.Statement.Quote(t.timeColumn)+" < ?", cutoff).
+		Order(t.db.Statement.Quote(t.timeColumn)).
+		Limit(limit).
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Batch{
+		Keys:    make([]interface{}, 0, len(rows)),
+		Records: make([]interface{}, 0, len(rows)),
+	}
+	for _, row := range rows {
+		b.Keys = append(b.Keys, row[t.keyColumn])
+		b.Records = append(b.Records, row)
+	}
+	return b, nil
+}
+
+// Delete implements Target.
+func (t *Table) Delete(ctx context.Context, b *Batch) error {
+	q := t.db.Statement.Quote
+	return t.db.WithContext(ctx).
+		Exec("DELETE FROM "+q(t.table)+" WHERE "+q(t.keyColumn)+" IN ?", b.Keys).Error
+}
+
+// Size implements Sizer.
+func (t *Table) Size(ctx context.Context) (int64, error) {
+	var n int64
+	err := t.db.WithContext(ctx).Table(t.table).Count(&n).Error
+	return n, err
+}
+
+// CountExpired implements Counter.
+func (t *Table) CountExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	var n int64
+	err := t.db.WithContext(ctx).Table(t.table).
+		Where(t.db.Statement.Quote(t.timeColumn)+" < ?", cutoff).
+		Count(&n).Error
+	return n, err
+}
@@ -0,0 +1,501 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"new-milli/broker"
+	"new-milli/collector"
+	"new-milli/connector/sqltx"
+	"new-milli/transport"
+)
+
+var _ transport.Server = (*Coordinator)(nil)
+
+// newFinishedCounter creates the counter of the finished instances by saga
+// and status registered with registry, reusing the registered one. It
+// isn't registered when registry is nil.
+func newFinishedCounter(registry prometheus.Registerer) *prometheus.CounterVec {
+	finished := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "new_milli",
+		Subsystem: "saga",
+		Name:      "finished_total",
+		Help:      "Number of finished saga instances by status.",
+	}, []string{"saga", "status"})
+	if registry != nil {
+		var err error
+		if finished, err = collector.Register(registry, finished); err != nil {
+			klog.Warnf("Failed to register saga metrics: %v", err)
+		}
+	}
+	return finished
+}
+
+// Option is coordinator option.
+type Option func(*options)
+
+// options is coordinator options.
+type options struct {
+	prefix       string
+	replyTopic   string
+	queue        string
+	pollInterval time.Duration
+	registry     prometheus.Registerer
+}
+
+// WithTablePrefix returns an Option that sets the prefix of the tables,
+// "saga_" by default.
+func WithTablePrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithReplyTopic returns an Option that sets the topic the step replies are
+// sent to, "saga.replies" by default.
+func WithReplyTopic(topic string) Option {
+	return func(o *options) {
+		o.replyTopic = topic
+	}
+}
+
+// WithQueue returns an Option that sets the queue, or consumer group, the
+// coordinator subscribes to the replies with, so its instances share them.
+func WithQueue(queue string) Option {
+	return func(o *options) {
+		o.queue = queue
+	}
+}
+
+// WithPollInterval returns an Option that sets how often the timed out
+// steps are looked for, every second by default.
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the saga
+// metrics, prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+var prefixPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Coordinator runs the sagas: Begin starts an instance, whose state is
+// saved in a SQL database after each step, and the replies of the awaiting
+// steps received from the broker make it progress. An instance is updated
+// in a transaction holding its row lock, which the context of the actions
+// and compensations carries (see sqltx.FromContext) so their own writes
+// commit along with the progress of the saga. Each of them runs in a
+// savepoint: the writes of a failing one are rolled back before the
+// compensation. The messages they publish, e.g. with Instance.Send, are
+// sent right away, before the transaction commits, so the services
+// receiving them must tolerate a command whose step was rolled back.
+//
+// The Coordinator implements transport.Server, so an App starts and stops
+// it alongside its servers.
+type Coordinator struct {
+	store    store
+	broker   broker.Broker
+	opts     options
+	finished *prometheus.CounterVec
+
+	mu     sync.Mutex
+	sagas  map[string]*Definition
+	sub    broker.Subscriber
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a coordinator storing the instances in db, e.g. the DB of the
+// mysql or postgres connector, and exchanging the step commands and replies
+// through b.
+func New(db *gorm.DB, b broker.Broker, opts ...Option) (*Coordinator, error) {
+	o := options{
+		prefix:       "saga_",
+		replyTopic:   "saga.replies",
+		pollInterval: time.Second,
+		registry:     prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !prefixPattern.MatchString(o.prefix) {
+		return nil, fmt.Errorf("invalid table prefix %q", o.prefix)
+	}
+	return &Coordinator{
+		store:    &sqlStore{db: db, table: o.prefix + "instances"},
+		broker:   b,
+		opts:     o,
+		finished: newFinishedCounter(o.registry),
+		sagas:    make(map[string]*Definition),
+	}, nil
+}
+
+// Register registers a saga so instances of it can begin.
+func (c *Coordinator) Register(d Definition) error {
+	if d.Name == "" || len(d.Steps) == 0 {
+		return errors.New("saga: a saga needs a name and steps")
+	}
+	names := make(map[string]bool, len(d.Steps))
+	for _, s := range d.Steps {
+		if s.Name == "" || names[s.Name] || s.Action == nil {
+			return fmt.Errorf("saga %s: steps need a unique name and an action", d.Name)
+		}
+		names[s.Name] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sagas[d.Name] = &d
+	return nil
+}
+
+// definition returns the registered saga of name.
+func (c *Coordinator) definition(name string) (*Definition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.sagas[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSaga, name)
+	}
+	return d, nil
+}
+
+// Migrate creates the instance table.
+func (c *Coordinator) Migrate(ctx context.Context) error {
+	return c.store.migrate(ctx)
+}
+
+// Begin begins an instance of the saga name identified by id, e.g. the id
+// of the order it processes, with input stored under InputKey. Its steps
+// run until one awaits a reply, the saga ends or a step fails. It returns
+// ErrExists when the instance already exists, so a retried request doesn't
+// run the saga twice.
+func (c *Coordinator) Begin(ctx context.Context, name, id string, input interface{}) (*Instance, error) {
+	d, err := c.definition(name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	inst := &Instance{
+		ID:        id,
+		Saga:      name,
+		Status:    StatusRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if input != nil {
+		if err := inst.Set(InputKey, input); err != nil {
+			return nil, err
+		}
+	}
+	err = c.store.create(ctx, inst, func(ctx context.Context) error {
+		c.bind(inst, d)
+		c.run(ctx, inst)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrExists) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to begin saga %s: %w", name, err)
+	}
+	c.observe(inst)
+	return inst, nil
+}
+
+// Get returns the instance of id, e.g. to report its status.
+func (c *Coordinator) Get(ctx context.Context, id string) (*Instance, error) {
+	return c.store.get(ctx, id)
+}
+
+// Init implements transport.Server.
+func (c *Coordinator) Init(opts ...transport.ServerOption) error {
+	return nil
+}
+
+// Start subscribes to the replies and starts looking for the timed out
+// steps, it does nothing when the coordinator is already started.
+func (c *Coordinator) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sub != nil {
+		return nil
+	}
+
+	var subOpts []broker.SubscribeOption
+	if c.opts.queue != "" {
+		subOpts = append(subOpts, broker.Queue(c.opts.queue))
+	}
+	sub, err := c.broker.Subscribe(c.opts.replyTopic, c.handleReply, subOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to saga replies: %w", err)
+	}
+
+	sweepCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c.sub, c.cancel, c.done = sub, cancel, make(chan struct{})
+	go c.sweep(sweepCtx, c.done)
+	return nil
+}
+
+// Stop unsubscribes from the replies and stops looking for the timed out
+// steps.
+func (c *Coordinator) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	sub, cancel, done := c.sub, c.cancel, c.done
+	c.sub, c.cancel, c.done = nil, nil, nil
+	c.mu.Unlock()
+	if sub == nil {
+		return nil
+	}
+
+	cancel()
+	err := sub.Unsubscribe()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// handleReply makes the instance of a reply progress.
+func (c *Coordinator) handleReply(ctx context.Context, msg *broker.Message) error {
+	id, step := msg.Header[HeaderSagaID], msg.Header[HeaderSagaStep]
+	if id == "" {
+		klog.CtxWarnf(ctx, "saga: dropping reply without %s header", HeaderSagaID)
+		return nil
+	}
+
+	var inst *Instance
+	err := c.store.update(ctx, id, func(ctx context.Context, i *Instance) error {
+		inst = i
+		if err := c.load(i); err != nil {
+			return err
+		}
+		if i.Status != StatusWaiting || i.def.Steps[i.Step].Name != step {
+			// A redelivered or late reply
+			klog.CtxDebugf(ctx, "saga: ignoring reply of %s for %s in status %s", step, id, i.Status)
+			inst = nil
+			return nil
+		}
+
+		if reason, failed := msg.Header[HeaderSagaError]; failed {
+			c.fail(ctx, i, errors.New(reason), false)
+			return nil
+		}
+		if len(msg.Body) > 0 {
+			i.data[step] = json.RawMessage(msg.Body)
+		}
+		i.Step++
+		i.Status = StatusRunning
+		c.run(ctx, i)
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		klog.CtxWarnf(ctx, "saga: dropping reply of %s for unknown instance %s", step, id)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to handle saga reply: %w", err)
+	}
+	if inst != nil {
+		c.observe(inst)
+	}
+	return nil
+}
+
+// sweep fails the timed out steps until ctx is done.
+func (c *Coordinator) sweep(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(c.opts.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ids, err := c.store.expired(ctx, time.Now(), 100)
+		if err != nil {
+			klog.CtxWarnf(ctx, "saga: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			c.timeout(ctx, id)
+		}
+	}
+}
+
+// timeout fails the timed out step of an instance.
+func (c *Coordinator) timeout(ctx context.Context, id string) {
+	var inst *Instance
+	err := c.store.update(ctx, id, func(ctx context.Context, i *Instance) error {
+		if i.Status != StatusWaiting || i.deadline == nil || time.Now().Before(*i.deadline) {
+			return nil
+		}
+		if err := c.load(i); err != nil {
+			return err
+		}
+		inst = i
+		// The service may still perform the step, undo it as well
+		c.fail(ctx, i, fmt.Errorf("%w: %s", ErrTimeout, i.def.Steps[i.Step].Name), true)
+		return nil
+	})
+	if err != nil {
+		klog.CtxWarnf(ctx, "saga: failed to time out %s: %v", id, err)
+		return
+	}
+	if inst != nil {
+		c.observe(inst)
+	}
+}
+
+// load binds a stored instance to its saga.
+func (c *Coordinator) load(inst *Instance) error {
+	d, err := c.definition(inst.Saga)
+	if err != nil {
+		return err
+	}
+	if inst.Step < 0 || inst.Step > len(d.Steps) {
+		return fmt.Errorf("saga %s: instance %s at unknown step %d", inst.Saga, inst.ID, inst.Step)
+	}
+	c.bind(inst, d)
+	return nil
+}
+
+// bind binds an instance to its saga and the coordinator.
+func (c *Coordinator) bind(inst *Instance, d *Definition) {
+	inst.c, inst.def = c, d
+	if inst.data == nil {
+		inst.data = make(map[string]json.RawMessage)
+	}
+}
+
+// run runs the steps of a running instance until one awaits a reply, the
+// saga ends or a step fails.
+func (c *Coordinator) run(ctx context.Context, inst *Instance) {
+	steps := inst.def.Steps
+	for inst.Status == StatusRunning {
+		inst.UpdatedAt = time.Now()
+		if inst.Step >= len(steps) {
+			inst.Status = StatusCompleted
+			inst.deadline = nil
+			return
+		}
+
+		step := steps[inst.Step]
+		if err := c.call(ctx, step.Action, inst); err != nil {
+			c.fail(ctx, inst, fmt.Errorf("step %s: %w", step.Name, err), false)
+			return
+		}
+		if step.Await {
+			inst.Status = StatusWaiting
+			inst.deadline = c.deadline(inst, step)
+			return
+		}
+		inst.Step++
+	}
+}
+
+// deadline returns the deadline of the reply of an awaiting step, bounded
+// by the timeout of the saga.
+func (c *Coordinator) deadline(inst *Instance, step Step) *time.Time {
+	var deadline time.Time
+	if step.Timeout > 0 {
+		deadline = time.Now().Add(step.Timeout)
+	}
+	if t := inst.def.Timeout; t > 0 {
+		if sagaDeadline := inst.CreatedAt.Add(t); deadline.IsZero() || sagaDeadline.Before(deadline) {
+			deadline = sagaDeadline
+		}
+	}
+	if deadline.IsZero() {
+		return nil
+	}
+	return &deadline
+}
+
+// fail records the failure of the current step of an instance and
+// compensates the previous steps in reverse order, and the current one too
+// when its outcome is unknown, e.g. after a timeout.
+func (c *Coordinator) fail(ctx context.Context, inst *Instance, err error, compensateCurrent bool) {
+	klog.CtxWarnf(ctx, "saga: %s %s failed, compensating: %v", inst.Saga, inst.ID, err)
+	inst.Error = err.Error()
+	inst.deadline = nil
+	inst.UpdatedAt = time.Now()
+
+	if !compensateCurrent {
+		inst.Step--
+	}
+	for ; inst.Step >= 0; inst.Step-- {
+		step := inst.def.Steps[inst.Step]
+		if step.Compensate == nil {
+			continue
+		}
+		if cerr := c.call(ctx, step.Compensate, inst); cerr != nil {
+			inst.Status = StatusFailed
+			inst.Error = fmt.Sprintf("%s; compensation of %s failed: %v", inst.Error, step.Name, cerr)
+			klog.CtxErrorf(ctx, "saga: %s %s needs a manual intervention: %s", inst.Saga, inst.ID, inst.Error)
+			return
+		}
+	}
+	inst.Step = 0
+	inst.Status = StatusCompensated
+}
+
+// observe counts a finished instance.
+func (c *Coordinator) observe(inst *Instance) {
+	switch inst.Status {
+	case StatusCompleted, StatusCompensated, StatusFailed:
+		c.finished.WithLabelValues(inst.Saga, string(inst.Status)).Inc()
+	}
+}
+
+// call calls a step function in a savepoint of the transaction of the
+// instance, so a failing function doesn't leave its partial writes, nor
+// its changes to the data of the instance, to be committed along with the
+// compensation.
+func (c *Coordinator) call(ctx context.Context, fn func(context.Context, *Instance) error, inst *Instance) error {
+	tx, ok := sqltx.FromContext(ctx)
+	if !ok {
+		return call(ctx, fn, inst)
+	}
+	data := make(map[string]json.RawMessage, len(inst.data))
+	for k, v := range inst.data {
+		data[k] = v
+	}
+	err := sqltx.Run(ctx, tx, nil, func(tx *gorm.DB) error {
+		return call(tx.Statement.Context, fn, inst)
+	}, sqltx.WithName("saga step"))
+	if err != nil {
+		inst.data = data
+	}
+	return err
+}
+
+// call calls a step function, turning a panic into an error.
+func call(ctx context.Context, fn func(context.Context, *Instance) error, inst *Instance) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(ctx, inst)
+}
@@ -0,0 +1,186 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"new-milli/broker"
+)
+
+// Status is the status of a saga instance.
+type Status string
+
+// Statuses of the saga instances.
+const (
+	// StatusRunning is the status of an instance while its steps run.
+	StatusRunning Status = "running"
+	// StatusWaiting is the status of an instance waiting for the reply of
+	// a step.
+	StatusWaiting Status = "waiting"
+	// StatusCompleted is the status of an instance whose steps all succeeded.
+	StatusCompleted Status = "completed"
+	// StatusCompensated is the status of an instance whose failed step was
+	// compensated by undoing the previous ones.
+	StatusCompensated Status = "compensated"
+	// StatusFailed is the status of an instance whose compensation failed,
+	// it needs a manual intervention.
+	StatusFailed Status = "failed"
+)
+
+// Message headers of the step commands and their replies.
+const (
+	// HeaderSagaID identifies the saga instance of a command or reply.
+	HeaderSagaID = "Saga-Id"
+	// HeaderSagaStep names the step of a command or reply.
+	HeaderSagaStep = "Saga-Step"
+	// HeaderReplyTo is the topic the replies of a command are sent to.
+	HeaderReplyTo = "Reply-To"
+	// HeaderSagaError carries the error of a failed step on its reply.
+	HeaderSagaError = "Saga-Error"
+)
+
+// InputKey is the data key of the input of an instance passed to Begin.
+const InputKey = "input"
+
+var (
+	// ErrUnknownSaga is returned by Begin for a saga that isn't registered.
+	ErrUnknownSaga = errors.New("saga: unknown saga")
+	// ErrExists is returned by Begin when the instance id is taken.
+	ErrExists = errors.New("saga: instance already exists")
+	// ErrNotFound is returned when an instance or a data key doesn't exist.
+	ErrNotFound = errors.New("saga: not found")
+	// ErrTimeout is the error of the steps whose reply didn't arrive in time.
+	ErrTimeout = errors.New("saga: step timed out")
+)
+
+// Step is a step of a saga.
+type Step struct {
+	// Name names the step, it must be unique within the saga.
+	Name string
+	// Action performs the step, e.g. writes to the local database or sends
+	// a command to another service with Instance.Send. An error fails the
+	// step.
+	Action func(ctx context.Context, inst *Instance) error
+	// Compensate undoes the step when a later one fails, nil when there is
+	// nothing to undo.
+	Compensate func(ctx context.Context, inst *Instance) error
+	// Await makes the step wait for the reply of the service its Action
+	// sent a command to, see Reply.
+	Await bool
+	// Timeout fails an awaiting step whose reply doesn't arrive in time,
+	// zero waits forever. The step is then compensated too, since the
+	// service may still perform it.
+	Timeout time.Duration
+}
+
+// Definition defines a saga: its steps run in order, and when one fails
+// the previous ones are compensated in reverse order.
+type Definition struct {
+	// Name names the saga.
+	Name string
+	// Steps is the steps of the saga.
+	Steps []Step
+	// Timeout fails the instances still running after it, zero never does.
+	Timeout time.Duration
+}
+
+// Instance is a run of a saga, persisted by the Coordinator.
+type Instance struct {
+	ID        string
+	Saga      string
+	Status    Status
+	Step      int
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	data     map[string]json.RawMessage
+	deadline *time.Time
+	c        *Coordinator
+	def      *Definition
+}
+
+// Get decodes the data of key into v, e.g. InputKey or the result of an
+// awaiting step stored under its name. It returns ErrNotFound when the key
+// isn't set.
+func (i *Instance) Get(key string, v interface{}) error {
+	raw, ok := i.data[key]
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// Set sets the data of key, saved with the instance so the following steps
+// and the compensations can read it.
+func (i *Instance) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal saga data %s: %w", key, err)
+	}
+	if i.data == nil {
+		i.data = make(map[string]json.RawMessage)
+	}
+	i.data[key] = raw
+	return nil
+}
+
+// Send sends v encoded in JSON to topic as a command of the current step,
+// its reply is expected on the reply topic of the Coordinator. The
+// services handling the commands must be idempotent: a command may be sent
+// again when the coordinator fails to save the instance.
+func (i *Instance) Send(ctx context.Context, topic string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal saga command: %w", err)
+	}
+	msg := &broker.Message{
+		Header: map[string]string{
+			broker.HeaderContentType: "application/json",
+			HeaderSagaID:             i.ID,
+			HeaderSagaStep:           i.def.Steps[i.Step].Name,
+			HeaderReplyTo:            i.c.opts.replyTopic,
+		},
+		Body: body,
+	}
+	if err := i.c.broker.Publish(ctx, topic, msg); err != nil {
+		return fmt.Errorf("failed to send saga command to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Reply answers a step command received from a saga coordinator with
+// result encoded in JSON, or with err when the step failed. It is called by
+// the services taking part in the saga:
+//
+//	b.Subscribe("payment.charge", func(ctx context.Context, msg *broker.Message) error {
+//		receipt, err := charge(ctx, msg)
+//		return saga.Reply(ctx, b, msg, receipt, err)
+//	})
+func Reply(ctx context.Context, b broker.Broker, command *broker.Message, result interface{}, err error) error {
+	replyTo := command.Header[HeaderReplyTo]
+	if replyTo == "" || command.Header[HeaderSagaID] == "" {
+		return errors.New("saga: not a saga command")
+	}
+	msg := &broker.Message{
+		Header: map[string]string{
+			HeaderSagaID:   command.Header[HeaderSagaID],
+			HeaderSagaStep: command.Header[HeaderSagaStep],
+		},
+	}
+	if err != nil {
+		msg.Header[HeaderSagaError] = err.Error()
+	} else if result != nil {
+		if msg.Body, err = json.Marshal(result); err != nil {
+			return fmt.Errorf("failed to marshal saga reply: %w", err)
+		}
+		msg.Header[broker.HeaderContentType] = "application/json"
+	}
+	if err := b.Publish(ctx, replyTo, msg); err != nil {
+		return fmt.Errorf("failed to send saga reply to %s: %w", replyTo, err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"new-milli/connector/sqltx"
+)
+
+// store persists the saga instances.
+type store interface {
+	// migrate creates the tables.
+	migrate(ctx context.Context) error
+	// create saves a new instance after fn ran on it, atomically. It returns
+	// ErrExists when the id is taken.
+	create(ctx context.Context, inst *Instance, fn func(ctx context.Context) error) error
+	// update runs fn on the instance of id, locked against the concurrent
+	// updates, and saves it, atomically.
+	update(ctx context.Context, id string, fn func(ctx context.Context, inst *Instance) error) error
+	// get returns the instance of id.
+	get(ctx context.Context, id string) (*Instance, error)
+	// expired returns the ids of the waiting instances whose deadline passed.
+	expired(ctx context.Context, now time.Time, limit int) ([]string, error)
+}
+
+// instanceRow is the row of an instance.
+type instanceRow struct {
+	ID        string
+	Name      string
+	Status    string
+	Step      int
+	Data      string
+	Error     string
+	Deadline  *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// sqlStore stores the instances in a SQL database, e.g. the DB of the
+// mysql or postgres connector.
+type sqlStore struct {
+	db    *gorm.DB
+	table string
+}
+
+// migrate creates the instance table.
+func (s *sqlStore) migrate(ctx context.Context) error {
+	db := s.db.WithContext(ctx)
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+		id VARCHAR(64) NOT NULL PRIMARY KEY,
+		name VARCHAR(128) NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		step INT NOT NULL,
+		data TEXT,
+		error TEXT,
+		deadline TIMESTAMP NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`).Error
+	if err == nil && !db.Migrator().HasIndex(s.table, "idx_"+s.table+"_deadline") {
+		err = db.Exec("CREATE INDEX idx_" + s.table + "_deadline ON " + s.table + " (status, deadline)").Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate saga store: %w", err)
+	}
+	return nil
+}
+
+// create inserts an instance.
+func (s *sqlStore) create(ctx context.Context, inst *Instance, fn func(ctx context.Context) error) error {
+	return sqltx.Run(ctx, s.db, nil, func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Table(s.table).Where("id = ?", inst.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: %s", ErrExists, inst.ID)
+		}
+		if err := fn(tx.Statement.Context); err != nil {
+			return err
+		}
+		row, err := toRow(inst)
+		if err != nil {
+			return err
+		}
+		return tx.Table(s.table).Create(row).Error
+	}, sqltx.WithName("saga "+inst.Saga))
+}
+
+// update updates an instance in a transaction holding its row lock.
+func (s *sqlStore) update(ctx context.Context, id string, fn func(ctx context.Context, inst *Instance) error) error {
+	return sqltx.Run(ctx, s.db, nil, func(tx *gorm.DB) error {
+		var row instanceRow
+		err := tx.Table(s.table).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Take(&row).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+		inst, err := row.toInstance()
+		if err != nil {
+			return err
+		}
+		if err := fn(tx.Statement.Context, inst); err != nil {
+			return err
+		}
+		updated, err := toRow(inst)
+		if err != nil {
+			return err
+		}
+		return tx.Table(s.table).Where("id = ?", id).Select("*").Updates(updated).Error
+	}, sqltx.WithName("saga update"))
+}
+
+// get reads an instance.
+func (s *sqlStore) get(ctx context.Context, id string) (*Instance, error) {
+	var row instanceRow
+	err := s.db.WithContext(ctx).Table(s.table).Where("id = ?", id).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saga instance %s: %w", id, err)
+	}
+	return row.toInstance()
+}
+
+// expired lists the waiting instances past their deadline.
+func (s *sqlStore) expired(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	var ids []string
+	err := s.db.WithContext(ctx).Table(s.table).
+		Where("status = ? AND deadline < ?", string(StatusWaiting), now).
+		Order("deadline").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired saga instances: %w", err)
+	}
+	return ids, nil
+}
+
+// toRow converts an instance into a row.
+func toRow(inst *Instance) (*instanceRow, error) {
+	data, err := json.Marshal(inst.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal saga data: %w", err)
+	}
+	return &instanceRow{
+		ID:        inst.ID,
+		Name:      inst.Saga,
+		Status:    string(inst.Status),
+		Step:      inst.Step,
+		Data:      string(data),
+		Error:     inst.Error,
+		Deadline:  inst.deadline,
+		CreatedAt: inst.CreatedAt,
+		UpdatedAt: inst.UpdatedAt,
+	}, nil
+}
+
+// toInstance converts a row into an instance.
+func (r *instanceRow) toInstance() (*Instance, error) {
+	inst := &Instance{
+		ID:        r.ID,
+		Saga:      r.Name,
+		Status:    Status(r.Status),
+		Step:      r.Step,
+		Error:     r.Error,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+		deadline:  r.Deadline,
+	}
+	if r.Data != "" {
+		if err := json.Unmarshal([]byte(r.Data), &inst.data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal saga data of %s: %w", r.ID, err)
+		}
+	}
+	return inst, nil
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"new-milli/collector"
+	"new-milli/lock"
+)
+
+const tracerName = "new-milli/scheduler"
+
+// maxLockHold caps how long the lock of a distributed job is held after a
+// short run.
+const maxLockHold = 30 * time.Second
+
+// jobMetrics is the metrics of the scheduled jobs.
+type jobMetrics struct {
+	duration    *prometheus.HistogramVec
+	lastSuccess *prometheus.GaugeVec
+	skipped     *prometheus.CounterVec
+}
+
+// newJobMetrics creates the scheduler job metrics registered with registry,
+// reusing the registered ones. They aren't registered when registry is nil.
+func newJobMetrics(registry prometheus.Registerer) *jobMetrics {
+	m := &jobMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "new_milli",
+			Subsystem: "scheduler",
+			Name:      "duration_seconds",
+			Help:      "Duration of the scheduled job runs.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600},
+		}, []string{"job", "status"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "new_milli",
+			Subsystem: "scheduler",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful scheduled job run.",
+		}, []string{"job"}),
+		skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "scheduler",
+			Name:      "skipped_total",
+			Help:      "Number of scheduled job runs skipped.",
+		}, []string{"job", "reason"}),
+	}
+	if registry != nil {
+		var errs [3]error
+		m.duration, errs[0] = collector.Register(registry, m.duration)
+		m.lastSuccess, errs[1] = collector.Register(registry, m.lastSuccess)
+		m.skipped, errs[2] = collector.Register(registry, m.skipped)
+		if err := errors.Join(errs[:]...); err != nil {
+			klog.Warnf("Failed to register scheduler job metrics: %v", err)
+		}
+	}
+	return m
+}
+
+// run runs a job once. next is the time of the following run, used to bound
+// how long the lock of a distributed job is held.
+func (s *Scheduler) run(ctx context.Context, j *job, next time.Time) {
+	start := time.Now()
+	// ctx is replaced by the run's context below, which is canceled before
+	// the deferred release runs
+	schedCtx := ctx
+
+	if j.lock != nil {
+		lease, err := j.lock.TryAcquire(ctx)
+		if errors.Is(err, lock.ErrNotAcquired) {
+			klog.Debugf("scheduler: job %s is running on another instance", j.name)
+			s.metrics.skipped.WithLabelValues(j.name, "locked").Inc()
+			return
+		}
+		if err != nil {
+			klog.Errorf("scheduler: failed to lock job %s: %v", j.name, err)
+			s.metrics.skipped.WithLabelValues(j.name, "lock_error").Inc()
+			return
+		}
+		defer func() {
+			// Hold the lock a while so instances with skewed clocks don't run
+			// the same occurrence again once it is released
+			hold := maxLockHold
+			if d := next.Sub(start) / 2; d > 0 && d < hold {
+				hold = d
+			}
+			if wait := hold - time.Since(start); wait > 0 {
+				select {
+				case <-schedCtx.Done():
+				case <-time.After(wait):
+				}
+			}
+			releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(schedCtx), time.Second)
+			defer cancel()
+			if err := lease.Release(releaseCtx); err != nil {
+				klog.Warnf("scheduler: failed to unlock job %s: %v", j.name, err)
+			}
+		}()
+
+		// Stop the run when another instance may take over
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-lease.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+		defer cancel()
+	}
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "scheduler "+j.name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("scheduler.job", j.name),
+			attribute.String("scheduler.spec", j.spec),
+		))
+	defer span.End()
+
+	err := call(ctx, j.fn)
+	duration := time.Since(start)
+
+	status := "success"
+	if err != nil {
+		status = "failure"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		klog.CtxErrorf(ctx, "scheduler: job %s failed after %s: %v", j.name, duration, err)
+	} else {
+		s.metrics.lastSuccess.WithLabelValues(j.name).SetToCurrentTime()
+		klog.CtxDebugf(ctx, "scheduler: job %s succeeded in %s", j.name, duration)
+	}
+	s.metrics.duration.WithLabelValues(j.name, status).Observe(duration.Seconds())
+}
+
+// call calls fn, turning a panic into an error.
+func call(ctx context.Context, fn JobFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(ctx)
+}
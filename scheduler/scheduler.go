@@ -0,0 +1,245 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/robfig/cron/v3"
+	"new-milli/lock"
+	"new-milli/transport"
+)
+
+var _ transport.Server = (*Scheduler)(nil)
+
+// ErrDuplicateJob is returned when a job is added twice with the same name.
+var ErrDuplicateJob = errors.New("scheduler: duplicate job")
+
+// parser parses cron expressions, with an optional seconds field, and
+// descriptors such as @hourly or @every 5m.
+var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// JobFunc is the function of a scheduled job.
+type JobFunc func(ctx context.Context) error
+
+// Option is scheduler option.
+type Option func(*options)
+
+// options is scheduler options.
+type options struct {
+	location *time.Location
+	registry prometheus.Registerer
+}
+
+// WithLocation returns an Option that sets the time zone of the cron
+// expressions, the local time zone by default.
+func WithLocation(loc *time.Location) Option {
+	return func(o *options) {
+		o.location = loc
+	}
+}
+
+// WithRegistry returns an Option that sets the registry of the job
+// metrics, prometheus.DefaultRegisterer by default, nil disables them.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// JobOption is job option.
+type JobOption func(*job)
+
+// WithTimeout returns a JobOption that bounds each run of the job.
+func WithTimeout(timeout time.Duration) JobOption {
+	return func(j *job) {
+		j.timeout = timeout
+	}
+}
+
+// WithDistributedLock returns a JobOption that runs each occurrence of the
+// job on a single instance, the one taking the job lock in Redis first.
+func WithDistributedLock(client redis.UniversalClient, opts ...lock.Option) JobOption {
+	return func(j *job) {
+		j.lock = lock.NewSemaphore(client, "scheduler:"+j.name, 1, opts...)
+	}
+}
+
+// job is a scheduled job.
+type job struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	fn       JobFunc
+	timeout  time.Duration
+	lock     *lock.Semaphore
+	running  atomic.Bool
+}
+
+// Scheduler runs jobs on cron schedules or intervals. It implements
+// transport.Server, so an App starts and stops it alongside its servers.
+type Scheduler struct {
+	opts    options
+	metrics *jobMetrics
+
+	mu     sync.Mutex
+	jobs   map[string]*job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a scheduler.
+func New(opts ...Option) *Scheduler {
+	o := options{
+		location: time.Local,
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Scheduler{
+		opts:    o,
+		metrics: newJobMetrics(o.registry),
+		jobs:    make(map[string]*job),
+	}
+}
+
+// Add adds a job named name running on the cron expression spec, e.g.
+// "0 */5 * * * *", "*/5 * * * *" or "@every 1m". Jobs added after Start are
+// scheduled immediately.
+func (s *Scheduler) Add(name, spec string, fn JobFunc, opts ...JobOption) error {
+	schedule, err := parser.Parse(spec)
+	if err != nil {
+		return fmt.Errorf("failed to parse schedule of %s: %w", name, err)
+	}
+	return s.add(&job{name: name, spec: spec, schedule: schedule, fn: fn}, opts)
+}
+
+// Every adds a job named name running every interval, which must be
+// positive.
+func (s *Scheduler) Every(name string, interval time.Duration, fn JobFunc, opts ...JobOption) error {
+	if interval <= 0 {
+		return fmt.Errorf("scheduler: invalid interval %s of %s", interval, name)
+	}
+	return s.add(&job{name: name, spec: "@every " + interval.String(), schedule: every(interval), fn: fn}, opts)
+}
+
+// every is a schedule running at a fixed interval. Unlike cron.Every it
+// doesn't round the interval to seconds.
+type every time.Duration
+
+// Next implements cron.Schedule.
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// add adds a job.
+func (s *Scheduler) add(j *job, opts []JobOption) error {
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[j.name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateJob, j.name)
+	}
+	s.jobs[j.name] = j
+	if s.ctx != nil {
+		s.wg.Add(1)
+		go s.loop(s.ctx, j)
+	}
+	return nil
+}
+
+// Init implements transport.Server.
+func (s *Scheduler) Init(opts ...transport.ServerOption) error {
+	return nil
+}
+
+// Start starts scheduling the jobs.
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx != nil {
+		return errors.New("scheduler: already started")
+	}
+	s.ctx, s.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(s.ctx, j)
+	}
+	klog.Infof("scheduler: started %d jobs", len(s.jobs))
+	return nil
+}
+
+// Stop stops scheduling the jobs, cancels the running ones and waits for
+// them to return until ctx is done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.ctx, s.cancel = nil, nil
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		klog.Infof("scheduler: stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scheduler: jobs still running: %w", ctx.Err())
+	}
+}
+
+// loop runs a job on its schedule until ctx is done.
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	defer s.wg.Done()
+
+	for {
+		now := time.Now().In(s.opts.location)
+		next := j.schedule.Next(now)
+		if next.IsZero() {
+			klog.Warnf("scheduler: job %s has no next run", j.name)
+			return
+		}
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// A run still in progress skips the next ones
+		if !j.running.CompareAndSwap(false, true) {
+			klog.Warnf("scheduler: skipped job %s, previous run still in progress", j.name)
+			s.metrics.skipped.WithLabelValues(j.name, "overlap").Inc()
+			continue
+		}
+		s.wg.Add(1)
+		go func(next time.Time) {
+			defer s.wg.Done()
+			defer j.running.Store(false)
+			s.run(ctx, j, j.schedule.Next(next))
+		}(next)
+	}
+}
@@ -0,0 +1,84 @@
+package newMilli
+
+import (
+	"fmt"
+	"time"
+
+	"new-milli/transport"
+)
+
+// ErrorPolicy is what an application does when one of its servers fails.
+type ErrorPolicy int
+
+const (
+	// FailFast stops the application when the server fails, the default.
+	FailFast ErrorPolicy = iota
+	// Continue keeps the other servers running when the server fails, e.g.
+	// for an optional debug or metrics server.
+	Continue
+)
+
+// String implements fmt.Stringer.
+func (p ErrorPolicy) String() string {
+	if p == Continue {
+		return "continue"
+	}
+	return "fail-fast"
+}
+
+// ServerState is the state of a server of an application.
+type ServerState string
+
+// States of the servers.
+const (
+	ServerPending ServerState = "pending"
+	ServerRunning ServerState = "running"
+	ServerStopped ServerState = "stopped"
+	ServerFailed  ServerState = "failed"
+)
+
+// ServerStatus is the status of a server of an application.
+type ServerStatus struct {
+	Name      string
+	State     ServerState
+	Policy    ErrorPolicy
+	Err       error
+	StartedAt time.Time
+	StoppedAt time.Time
+}
+
+// ServerError is returned by Run when a server fails, naming the server.
+type ServerError struct {
+	Name string
+	Err  error
+}
+
+// Error implements error.
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server %s: %v", e.Name, e.Err)
+}
+
+// Unwrap returns the error of the server.
+func (e *ServerError) Unwrap() error { return e.Err }
+
+// ServerOption is named server option.
+type ServerOption func(*server)
+
+// OnServerError sets what the application does when the server fails.
+func OnServerError(policy ErrorPolicy) ServerOption {
+	return func(s *server) {
+		s.policy = policy
+	}
+}
+
+// server is a server of an application.
+type server struct {
+	name   string
+	srv    transport.Server
+	policy ErrorPolicy
+}
+
+// serverName returns the default name of a server, its type and index.
+func serverName(srv transport.Server, index int) string {
+	return fmt.Sprintf("%T#%d", srv, index)
+}
@@ -0,0 +1,280 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+)
+
+var (
+	// ErrExpired is returned when a snapshot is older than the max age.
+	ErrExpired = errors.New("snapshot: expired")
+	// ErrVersionMismatch is returned when a snapshot was taken by another version.
+	ErrVersionMismatch = errors.New("snapshot: version mismatch")
+	// ErrCorrupted is returned when a snapshot fails its checksum.
+	ErrCorrupted = errors.New("snapshot: corrupted")
+)
+
+// Snapshotter is a component whose in-memory state survives restarts.
+type Snapshotter interface {
+	// Name identifies the component, it names the snapshot file.
+	Name() string
+	// Snapshot serializes the state.
+	Snapshot() ([]byte, error)
+	// Restore loads the state serialized by Snapshot.
+	Restore(data []byte) error
+}
+
+// funcSnapshotter adapts functions to a Snapshotter.
+type funcSnapshotter struct {
+	name    string
+	save    func() ([]byte, error)
+	restore func([]byte) error
+}
+
+// Func returns a Snapshotter calling save and restore.
+func Func(name string, save func() ([]byte, error), restore func([]byte) error) Snapshotter {
+	return &funcSnapshotter{name: name, save: save, restore: restore}
+}
+
+// Name implements Snapshotter.
+func (s *funcSnapshotter) Name() string {
+	return s.name
+}
+
+// Snapshot implements Snapshotter.
+func (s *funcSnapshotter) Snapshot() ([]byte, error) {
+	return s.save()
+}
+
+// Restore implements Snapshotter.
+func (s *funcSnapshotter) Restore(data []byte) error {
+	return s.restore(data)
+}
+
+// header precedes the state in snapshot files.
+type header struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Checksum  uint32    `json:"checksum"`
+}
+
+// Option is snapshot option.
+type Option func(*options)
+
+// options is snapshot options.
+type options struct {
+	maxAge  time.Duration
+	version string
+}
+
+// WithMaxAge sets the age past which snapshots are discarded, 0 keeps them forever.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithVersion sets the version stamped on snapshots, snapshots taken by
+// another version are discarded, e.g. when the state format changed.
+func WithVersion(version string) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
+// Manager saves the state of the registered components to a directory on
+// shutdown and restores it on startup.
+type Manager struct {
+	dir  string
+	opts options
+
+	mu           sync.Mutex
+	snapshotters []Snapshotter
+}
+
+// New creates a manager storing snapshots in dir.
+func New(dir string, opts ...Option) *Manager {
+	o := options{
+		maxAge: time.Minute * 10,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Manager{dir: dir, opts: o}
+}
+
+// Register adds a component. Components registered after Start aren't restored
+// until RestoreOne is called.
+func (m *Manager) Register(s Snapshotter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshotters = append(m.snapshotters, s)
+}
+
+// Start restores the registered components, it suits newMilli.BeforeStart.
+func (m *Manager) Start(ctx context.Context) error {
+	m.Restore(ctx)
+	return nil
+}
+
+// Stop saves the registered components, it suits newMilli.AfterStop.
+func (m *Manager) Stop(ctx context.Context) error {
+	return m.Save(ctx)
+}
+
+// Restore restores the registered components. Missing, expired or invalid
+// snapshots are skipped so a bad snapshot never prevents startup.
+func (m *Manager) Restore(ctx context.Context) {
+	for _, s := range m.list() {
+		if err := m.RestoreOne(s); err != nil && !errors.Is(err, os.ErrNotExist) {
+			klog.CtxWarnf(ctx, "snapshot: skipped %s: %v", s.Name(), err)
+		}
+	}
+}
+
+// RestoreOne restores a component from its snapshot, which is removed
+// afterwards so a stale state isn't restored twice.
+func (m *Manager) RestoreOne(s Snapshotter) error {
+	file := m.path(s.Name())
+	data, err := m.read(file)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			os.Remove(file)
+		}
+		return err
+	}
+	defer os.Remove(file)
+
+	if err := s.Restore(data); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", s.Name(), err)
+	}
+	klog.Infof("snapshot: restored %s", s.Name())
+	return nil
+}
+
+// Save saves the registered components, a failing component doesn't prevent
+// the others from being saved.
+func (m *Manager) Save(ctx context.Context) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	var errs []error
+	for _, s := range m.list() {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if err := m.SaveOne(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SaveOne saves a component. The file is replaced atomically.
+func (m *Manager) SaveOne(s Snapshotter) error {
+	data, err := s.Snapshot()
+	if err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", s.Name(), err)
+	}
+
+	h, err := json.Marshal(header{
+		Version:   m.opts.version,
+		CreatedAt: time.Now(),
+		Checksum:  crc32.ChecksumIEEE(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(m.dir, s.Name()+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot of %s: %w", s.Name(), err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.Write(h)
+	w.WriteByte('\n')
+	w.Write(data)
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot of %s: %w", s.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot of %s: %w", s.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), m.path(s.Name())); err != nil {
+		return fmt.Errorf("failed to write snapshot of %s: %w", s.Name(), err)
+	}
+	return nil
+}
+
+// read reads and validates a snapshot file.
+func (m *Manager) read(file string) ([]byte, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	line, data, ok := bytes.Cut(raw, []byte{'\n'})
+	if !ok {
+		return nil, ErrCorrupted
+	}
+	var h header
+	if err := json.Unmarshal(line, &h); err != nil {
+		return nil, ErrCorrupted
+	}
+	if h.Version != m.opts.version {
+		return nil, fmt.Errorf("%w: %q", ErrVersionMismatch, h.Version)
+	}
+	if m.opts.maxAge > 0 && time.Since(h.CreatedAt) > m.opts.maxAge {
+		return nil, fmt.Errorf("%w: taken at %s", ErrExpired, h.CreatedAt.Format(time.RFC3339))
+	}
+	if crc32.ChecksumIEEE(data) != h.Checksum {
+		return nil, ErrCorrupted
+	}
+	return data, nil
+}
+
+// list returns the registered components.
+func (m *Manager) list() []Snapshotter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Snapshotter(nil), m.snapshotters...)
+}
+
+// path returns the snapshot file of a component.
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.dir, name+".snap")
+}
+
+// JSON returns a Snapshotter encoding the value returned by get as JSON and
+// passing the decoded value to set on restore.
+func JSON[T any](name string, get func() T, set func(T)) Snapshotter {
+	return Func(name,
+		func() ([]byte, error) {
+			return json.Marshal(get())
+		},
+		func(data []byte) error {
+			var v T
+			if err := json.Unmarshal(data, &v); err != nil {
+				return err
+			}
+			set(v)
+			return nil
+		},
+	)
+}
@@ -0,0 +1,70 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"new-milli/registry"
+)
+
+var (
+	// ErrNoAvailable is returned when there is no node to pick from.
+	ErrNoAvailable = errors.New("balancer: no available node")
+)
+
+// DoneInfo is the result of a request made to a picked node.
+type DoneInfo struct {
+	// Err is the error returned by the request, if any.
+	Err error
+}
+
+// DoneFunc is called when the request made to a picked node is done.
+type DoneFunc func(ctx context.Context, di DoneInfo)
+
+// Balancer picks a node for a request.
+type Balancer interface {
+	// Pick picks a node from the given nodes.
+	Pick(ctx context.Context, nodes []*registry.Node) (*registry.Node, DoneFunc, error)
+}
+
+// WeightKey is the node metadata key holding the node weight.
+const WeightKey = "weight"
+
+// defaultWeight is the weight of nodes without a weight in their metadata.
+const defaultWeight = 100
+
+// nodeWeight returns the weight of a node from its metadata.
+func nodeWeight(node *registry.Node) int64 {
+	if node.Metadata == nil {
+		return defaultWeight
+	}
+	w, err := strconv.ParseInt(node.Metadata[WeightKey], 10, 64)
+	if err != nil || w <= 0 {
+		return defaultWeight
+	}
+	return w
+}
+
+// noopDone is a DoneFunc that does nothing.
+func noopDone(context.Context, DoneInfo) {}
+
+// roundRobin is a round-robin balancer.
+type roundRobin struct {
+	next uint64
+}
+
+// NewRoundRobin creates a round-robin balancer.
+func NewRoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+// Pick picks the next node in turn.
+func (b *roundRobin) Pick(ctx context.Context, nodes []*registry.Node) (*registry.Node, DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, ErrNoAvailable
+	}
+	n := atomic.AddUint64(&b.next, 1)
+	return nodes[(n-1)%uint64(len(nodes))], noopDone, nil
+}
@@ -0,0 +1,129 @@
+package balancer
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"new-milli/registry"
+)
+
+const (
+	// decay is the time constant of the latency moving average.
+	decay = 10 * time.Second
+	// penalty is the latency assumed for nodes that have no stats yet or failed.
+	penalty = int64(time.Second)
+)
+
+// nodeStats holds the load statistics of a node.
+type nodeStats struct {
+	inflight int64
+	lag      int64 // moving average of the latency in nanoseconds
+	stamp    int64 // unix nano of the last update
+}
+
+// load returns the load score of the node, lower is better.
+func (s *nodeStats) load() int64 {
+	lag := atomic.LoadInt64(&s.lag)
+	if lag == 0 {
+		lag = penalty
+	}
+	return (atomic.LoadInt64(&s.inflight) + 1) * lag
+}
+
+// observe updates the latency moving average.
+func (s *nodeStats) observe(now time.Time, latency time.Duration) {
+	last := atomic.SwapInt64(&s.stamp, now.UnixNano())
+	td := now.UnixNano() - last
+	if td < 0 {
+		td = 0
+	}
+	w := float64(time.Duration(td)) / float64(decay)
+	beta := 1 / (1 + w)
+	old := atomic.LoadInt64(&s.lag)
+	atomic.StoreInt64(&s.lag, int64(float64(old)*beta+float64(latency)*(1-beta)))
+}
+
+// p2c is a power of two choices balancer.
+// It picks two random nodes and uses the one with the lower load,
+// where load is the number of in-flight requests weighted by latency.
+type p2c struct {
+	mu    sync.Mutex
+	rand  *rand.Rand
+	stats map[string]*nodeStats
+}
+
+// NewP2C creates a power of two choices balancer.
+func NewP2C() Balancer {
+	return &p2c{
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		stats: make(map[string]*nodeStats),
+	}
+}
+
+// Pick picks the less loaded of two random nodes.
+func (b *p2c) Pick(ctx context.Context, nodes []*registry.Node) (*registry.Node, DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, ErrNoAvailable
+	}
+
+	b.mu.Lock()
+	if len(b.stats) > len(nodes) {
+		b.prune(nodes)
+	}
+	var node *registry.Node
+	if len(nodes) == 1 {
+		node = nodes[0]
+	} else {
+		i := b.rand.Intn(len(nodes))
+		j := b.rand.Intn(len(nodes) - 1)
+		if j >= i {
+			j++
+		}
+		a, c := nodes[i], nodes[j]
+		if b.statsOf(c).load() < b.statsOf(a).load() {
+			a = c
+		}
+		node = a
+	}
+	stats := b.statsOf(node)
+	b.mu.Unlock()
+
+	atomic.AddInt64(&stats.inflight, 1)
+	start := time.Now()
+
+	return node, func(ctx context.Context, di DoneInfo) {
+		atomic.AddInt64(&stats.inflight, -1)
+		now := time.Now()
+		latency := now.Sub(start)
+		if di.Err != nil {
+			latency = time.Duration(penalty)
+		}
+		stats.observe(now, latency)
+	}, nil
+}
+
+// statsOf returns the stats of a node, creating them if needed.
+// It must be called with the lock held.
+func (b *p2c) statsOf(node *registry.Node) *nodeStats {
+	stats, ok := b.stats[node.Address]
+	if !ok {
+		stats = &nodeStats{stamp: time.Now().UnixNano()}
+		b.stats[node.Address] = stats
+	}
+	return stats
+}
+
+// prune drops the stats of the nodes that are no longer present.
+// It must be called with the lock held.
+func (b *p2c) prune(nodes []*registry.Node) {
+	stats := make(map[string]*nodeStats, len(nodes))
+	for _, node := range nodes {
+		if s, ok := b.stats[node.Address]; ok {
+			stats[node.Address] = s
+		}
+	}
+	b.stats = stats
+}
@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"context"
+	"sync"
+
+	"new-milli/registry"
+)
+
+// weighted is a smooth weighted round-robin balancer.
+// Node weights are read from the "weight" metadata key.
+type weighted struct {
+	mu      sync.Mutex
+	current map[string]int64
+}
+
+// NewWeighted creates a smooth weighted round-robin balancer.
+func NewWeighted() Balancer {
+	return &weighted{
+		current: make(map[string]int64),
+	}
+}
+
+// Pick picks the node with the highest current weight.
+func (b *weighted) Pick(ctx context.Context, nodes []*registry.Node) (*registry.Node, DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, ErrNoAvailable
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var (
+		total    int64
+		selected *registry.Node
+		alive    = make(map[string]int64, len(nodes))
+	)
+	for _, node := range nodes {
+		w := nodeWeight(node)
+		total += w
+		cur := b.current[node.Address] + w
+		alive[node.Address] = cur
+		if selected == nil || cur > alive[selected.Address] {
+			selected = node
+		}
+	}
+	alive[selected.Address] -= total
+
+	// Only keep the state of nodes that are still present
+	b.current = alive
+
+	return selected, noopDone, nil
+}
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// Endpointer is implemented by servers that can advertise their endpoint.
+type Endpointer interface {
+	// Endpoint returns the address clients should use to reach the server.
+	Endpoint() (*url.URL, error)
+}
+
+// NewEndpoint creates an endpoint URL for the given scheme and listen address.
+// Unspecified hosts such as ":8080" or "0.0.0.0:8080" are replaced with
+// the first private IP of the machine.
+func NewEndpoint(scheme, address string) (*url.URL, error) {
+	host, err := ExtractHost(address)
+	if err != nil {
+		return nil, err
+	}
+	return &url.URL{Scheme: scheme, Host: host}, nil
+}
+
+// ExtractHost returns an advertisable host:port for a listen address.
+func ExtractHost(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return address, nil
+	}
+
+	ip, err := privateIP()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+// privateIP returns the first non-loopback IPv4 address of an up interface,
+// preferring private addresses.
+func privateIP() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	var fallback string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip.IsPrivate() {
+				return ip.String(), nil
+			}
+			if fallback == "" {
+				fallback = ip.String()
+			}
+		}
+	}
+	if fallback == "" {
+		return "", fmt.Errorf("no advertisable IP address found")
+	}
+	return fallback, nil
+}
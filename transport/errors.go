@@ -0,0 +1,32 @@
+package transport
+
+// statusError is an error carrying the status the transports answer with.
+type statusError struct {
+	status  int
+	code    string
+	message string
+}
+
+// NewStatusError returns an error with message whose StatusCode method
+// returns the HTTP status and ErrorCode method the error code, e.g. for the
+// sentinel errors of the middlewares. The code may be empty, the code of
+// the status is then used.
+func NewStatusError(status int, code, message string) error {
+	return &statusError{status: status, code: code, message: message}
+}
+
+// Error implements the error interface.
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// StatusCode returns the HTTP status of the error.
+func (e *statusError) StatusCode() int {
+	return e.status
+}
+
+// ErrorCode returns the code of the error, empty for the code of the
+// status.
+func (e *statusError) ErrorCode() string {
+	return e.code
+}
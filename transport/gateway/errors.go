@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	httptransport "new-milli/transport/http"
+)
+
+// httpStatus maps the gRPC codes to HTTP statuses and error codes.
+var httpStatus = map[codes.Code]struct {
+	status int
+	code   string
+}{
+	codes.Canceled:           {499, "CANCELLED"},
+	codes.Unknown:            {http.StatusInternalServerError, "UNKNOWN"},
+	codes.InvalidArgument:    {http.StatusBadRequest, "INVALID_ARGUMENT"},
+	codes.DeadlineExceeded:   {http.StatusGatewayTimeout, "DEADLINE_EXCEEDED"},
+	codes.NotFound:           {http.StatusNotFound, "NOT_FOUND"},
+	codes.AlreadyExists:      {http.StatusConflict, "ALREADY_EXISTS"},
+	codes.PermissionDenied:   {http.StatusForbidden, "PERMISSION_DENIED"},
+	codes.ResourceExhausted:  {http.StatusTooManyRequests, "RESOURCE_EXHAUSTED"},
+	codes.FailedPrecondition: {http.StatusBadRequest, "FAILED_PRECONDITION"},
+	codes.Aborted:            {http.StatusConflict, "ABORTED"},
+	codes.OutOfRange:         {http.StatusBadRequest, "OUT_OF_RANGE"},
+	codes.Unimplemented:      {http.StatusNotImplemented, "UNIMPLEMENTED"},
+	codes.Internal:           {http.StatusInternalServerError, "INTERNAL"},
+	codes.Unavailable:        {http.StatusServiceUnavailable, "UNAVAILABLE"},
+	codes.DataLoss:           {http.StatusInternalServerError, "DATA_LOSS"},
+	codes.Unauthenticated:    {http.StatusUnauthorized, "UNAUTHENTICATED"},
+}
+
+// toHTTPError converts the errors with a gRPC status into the error model
+// of the HTTP transport, the others are left to its mapping.
+func toHTTPError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	m, ok := httpStatus[st.Code()]
+	if !ok {
+		m = httpStatus[codes.Unknown]
+	}
+	return httptransport.NewError(m.status, m.code, st.Message()).WithCause(err)
+}
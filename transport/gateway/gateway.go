@@ -0,0 +1,269 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/route"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	httptransport "new-milli/transport/http"
+)
+
+// Option is gateway option.
+type Option func(*options)
+
+// options is gateway options.
+type options struct {
+	marshal       protojson.MarshalOptions
+	unmarshal     protojson.UnmarshalOptions
+	interceptor   grpc.UnaryServerInterceptor
+	defaultRoutes bool
+}
+
+// WithMarshalOptions returns an Option that sets how the responses are
+// encoded in JSON.
+func WithMarshalOptions(o protojson.MarshalOptions) Option {
+	return func(opts *options) {
+		opts.marshal = o
+	}
+}
+
+// WithUnmarshalOptions returns an Option that sets how the request bodies
+// are decoded from JSON, the unknown fields are discarded by default.
+func WithUnmarshalOptions(o protojson.UnmarshalOptions) Option {
+	return func(opts *options) {
+		opts.unmarshal = o
+	}
+}
+
+// WithInterceptor returns an Option that runs the methods through a gRPC
+// interceptor, e.g. the one the gRPC server uses, so both protocols share it.
+func WithInterceptor(interceptor grpc.UnaryServerInterceptor) Option {
+	return func(opts *options) {
+		opts.interceptor = interceptor
+	}
+}
+
+// WithDefaultRoutes returns an Option that serves the methods without a
+// google.api.http annotation at POST /<package>.<Service>/<Method>, the
+// request message in the body.
+func WithDefaultRoutes() Option {
+	return func(opts *options) {
+		opts.defaultRoutes = true
+	}
+}
+
+// binding is an HTTP binding of a method.
+type binding struct {
+	service  interface{}
+	method   grpc.MethodDesc
+	fullName string
+	tmpl     *template
+	// body is the request field the body fills, "*" for the whole request.
+	body         string
+	responseBody string
+	opts         *options
+}
+
+// Register serves the unary methods of the gRPC service described by desc,
+// e.g. the generated pb.Greeter_ServiceDesc, and implemented by impl over
+// HTTP on r, e.g. the Hertz server of the HTTP transport. The routes follow
+// the google.api.http annotations of the methods:
+//
+//	rpc GetBook(GetBookRequest) returns (Book) {
+//		option (google.api.http) = {get: "/v1/{name=shelves/*/books/*}"};
+//	}
+//
+// The request message is filled from the JSON body, the path variables and
+// the query parameters, and the response message is written in JSON. The
+// errors with a gRPC status get the matching HTTP status, the others are
+// written by http.RespondError. The request headers are passed to impl as
+// incoming gRPC metadata. The streaming methods aren't served.
+func Register(r route.IRoutes, desc *grpc.ServiceDesc, impl interface{}, opts ...Option) error {
+	o := &options{
+		unmarshal: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(desc.ServiceName))
+	if err != nil {
+		return fmt.Errorf("failed to find the descriptor of %s: %w", desc.ServiceName, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s isn't a service", desc.ServiceName)
+	}
+
+	// The bindings of the same method and route are told apart by their verb
+	routes := make(map[[2]string][]*binding)
+	var order [][2]string
+	for _, m := range desc.Methods {
+		md := sd.Methods().ByName(protoreflect.Name(m.MethodName))
+		if md == nil {
+			return fmt.Errorf("method %s not found in %s", m.MethodName, desc.ServiceName)
+		}
+
+		rules := httpRules(md)
+		if len(rules) == 0 && o.defaultRoutes {
+			rules = []*annotations.HttpRule{{
+				Pattern: &annotations.HttpRule_Post{Post: "/" + desc.ServiceName + "/" + m.MethodName},
+				Body:    "*",
+			}}
+		}
+		for _, rule := range rules {
+			method, path := pattern(rule)
+			if path == "" {
+				return fmt.Errorf("method %s: unsupported http rule", m.MethodName)
+			}
+			tmpl, err := parseTemplate(path)
+			if err != nil {
+				return fmt.Errorf("method %s: %w", m.MethodName, err)
+			}
+			b := &binding{
+				service:      impl,
+				method:       m,
+				fullName:     "/" + desc.ServiceName + "/" + m.MethodName,
+				tmpl:         tmpl,
+				body:         rule.GetBody(),
+				responseBody: rule.GetResponseBody(),
+				opts:         o,
+			}
+			key := [2]string{method, tmpl.route}
+			if _, ok := routes[key]; !ok {
+				order = append(order, key)
+			}
+			routes[key] = append(routes[key], b)
+		}
+	}
+
+	for _, key := range order {
+		bindings := routes[key]
+		r.Handle(key[0], key[1], func(ctx context.Context, c *app.RequestContext) {
+			for _, b := range bindings {
+				if vars, ok := b.tmpl.match(c.Param); ok {
+					b.serve(ctx, c, vars)
+					return
+				}
+			}
+			httptransport.RespondError(ctx, c, httptransport.NewError(http.StatusNotFound, "", "not found"))
+		})
+	}
+	return nil
+}
+
+// httpRules returns the http rules of a method and their additional bindings.
+func httpRules(md protoreflect.MethodDescriptor) []*annotations.HttpRule {
+	rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil || rule.GetPattern() == nil {
+		return nil
+	}
+	rules := []*annotations.HttpRule{rule}
+	return append(rules, rule.GetAdditionalBindings()...)
+}
+
+// pattern returns the HTTP method and path template of a rule.
+func pattern(rule *annotations.HttpRule) (string, string) {
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		return strings.ToUpper(p.Custom.GetKind()), p.Custom.GetPath()
+	}
+	return "", ""
+}
+
+// serve calls the method with the request of c and writes its response.
+func (b *binding) serve(ctx context.Context, c *app.RequestContext, vars map[string]string) {
+	md := metadata.MD{}
+	c.Request.Header.VisitAll(func(k, v []byte) {
+		md.Append(string(k), string(v))
+	})
+	ctx = metadata.NewIncomingContext(ctx, md)
+
+	dec := func(v interface{}) error {
+		req, ok := v.(proto.Message)
+		if !ok {
+			return status.Errorf(codes.Internal, "%T isn't a protobuf message", v)
+		}
+		if err := b.decode(c, vars, req); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil
+	}
+	reply, err := b.method.Handler(b.service, ctx, dec, b.interceptor())
+	if err != nil {
+		httptransport.RespondError(ctx, c, toHTTPError(err))
+		return
+	}
+
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		httptransport.RespondError(ctx, c, fmt.Errorf("gateway: %s returned %T", b.fullName, reply))
+		return
+	}
+	data, err := b.encode(msg)
+	if err != nil {
+		httptransport.RespondError(ctx, c, err)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", data)
+}
+
+// interceptor returns the interceptor the method is called with.
+func (b *binding) interceptor() grpc.UnaryServerInterceptor {
+	if b.opts.interceptor == nil {
+		return nil
+	}
+	info := &grpc.UnaryServerInfo{Server: b.service, FullMethod: b.fullName}
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return b.opts.interceptor(ctx, req, info, handler)
+	}
+}
+
+// encode encodes a response, or its field named by the response body of the
+// rule.
+func (b *binding) encode(msg proto.Message) ([]byte, error) {
+	data, err := b.opts.marshal.Marshal(msg)
+	if err != nil || b.responseBody == "" {
+		return data, err
+	}
+
+	fd := msg.ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(b.responseBody))
+	if fd == nil {
+		return nil, fmt.Errorf("gateway: unknown response body field %s", b.responseBody)
+	}
+	name := fd.JSONName()
+	if b.opts.marshal.UseProtoNames {
+		name = string(fd.Name())
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if field, ok := fields[name]; ok {
+		return field, nil
+	}
+	return []byte("null"), nil
+}
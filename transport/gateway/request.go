@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// errUnknownField is returned for a field path the request doesn't have.
+var errUnknownField = errors.New("unknown field")
+
+// decode fills a request from the body, the path variables and the query
+// parameters, in that order.
+func (b *binding) decode(c *app.RequestContext, vars map[string]string, req proto.Message) error {
+	if body := c.Request.Body(); b.body != "" && len(body) > 0 {
+		if b.body == "*" {
+			if err := b.opts.unmarshal.Unmarshal(body, req); err != nil {
+				return fmt.Errorf("invalid body: %w", err)
+			}
+		} else {
+			wrapped, err := json.Marshal(map[string]json.RawMessage{b.body: body})
+			if err != nil {
+				return fmt.Errorf("invalid body: %w", err)
+			}
+			if err := b.merge(req, wrapped); err != nil {
+				return fmt.Errorf("invalid body: %w", err)
+			}
+		}
+	}
+
+	for path, value := range vars {
+		if err := b.setField(req, strings.Split(path, "."), []string{value}); err != nil {
+			return fmt.Errorf("invalid path variable %s: %w", path, err)
+		}
+	}
+
+	// With a "*" body every field comes from the body
+	if b.body == "*" {
+		return nil
+	}
+	query := make(map[string][]string)
+	c.QueryArgs().VisitAll(func(k, v []byte) {
+		query[string(k)] = append(query[string(k)], string(v))
+	})
+	for key, values := range query {
+		path := strings.Split(key, ".")
+		if _, bound := vars[key]; bound || path[0] == b.body {
+			continue
+		}
+		err := b.setField(req, path, values)
+		if errors.Is(err, errUnknownField) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid query parameter %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// setField sets the field at path, its values parsed according to its kind.
+func (b *binding) setField(req proto.Message, path []string, values []string) error {
+	md := req.ProtoReflect().Descriptor()
+	var fd protoreflect.FieldDescriptor
+	for i, name := range path {
+		if fd = md.Fields().ByName(protoreflect.Name(name)); fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return fmt.Errorf("%w %s", errUnknownField, strings.Join(path[:i+1], "."))
+		}
+		if i < len(path)-1 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("%s isn't a message", name)
+			}
+			md = fd.Message()
+		}
+	}
+	if fd.IsMap() {
+		return fmt.Errorf("map fields aren't supported")
+	}
+
+	var value json.RawMessage
+	if fd.IsList() {
+		items := make([]json.RawMessage, 0, len(values))
+		for _, v := range values {
+			item, err := jsonValue(fd, v)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		value, _ = json.Marshal(items)
+	} else {
+		var err error
+		if value, err = jsonValue(fd, values[len(values)-1]); err != nil {
+			return err
+		}
+	}
+
+	// Encode the value in JSON under its path and merge it, so protojson
+	// parses the scalars and well-known types
+	for i := len(path) - 1; i >= 0; i-- {
+		value, _ = json.Marshal(map[string]json.RawMessage{path[i]: value})
+	}
+	return b.merge(req, value)
+}
+
+// merge merges a JSON encoded message into req.
+func (b *binding) merge(req proto.Message, data []byte) error {
+	m := req.ProtoReflect().New().Interface()
+	if err := b.opts.unmarshal.Unmarshal(data, m); err != nil {
+		return err
+	}
+	proto.Merge(req, m)
+	return nil
+}
+
+// jsonValue encodes the string value of a field as protojson expects it.
+func jsonValue(fd protoreflect.FieldDescriptor, v string) (json.RawMessage, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", v)
+		}
+		return json.RawMessage(strconv.FormatBool(b)), nil
+	case protoreflect.EnumKind:
+		if _, err := strconv.ParseInt(v, 10, 32); err == nil {
+			return json.RawMessage(v), nil
+		}
+	}
+	return json.Marshal(v)
+}
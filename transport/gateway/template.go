@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// part is a piece of the value of a path variable: a literal or the value
+// of a route parameter.
+type part struct {
+	literal string
+	param   string
+}
+
+// variable binds the segments of a path to a request field.
+type variable struct {
+	field []string
+	parts []part
+}
+
+// template is a compiled google.api.http path template, e.g.
+// "/v1/{name=shelves/*/books/*}:publish".
+type template struct {
+	// route is the Hertz route of the template, its parameters named after
+	// their position so templates of the same shape share it.
+	route string
+	vars  []variable
+	// verb is the custom verb, the last route segment then holds
+	// "<last>:<verb>" and is a parameter checked at runtime.
+	verb        string
+	verbParam   string
+	lastLiteral string // the literal last segment of a template with a verb
+	lastParam   string // the parameter of the last segment of a template with a verb
+}
+
+// parseTemplate compiles a path template.
+func parseTemplate(path string) (*template, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path template %q must start with /", path)
+	}
+	rest := path[1:]
+
+	t := &template{}
+	if i := strings.LastIndexByte(rest, ':'); i >= 0 && i > strings.LastIndexByte(rest, '/') && i > strings.LastIndexByte(rest, '}') {
+		t.verb = rest[i+1:]
+		rest = rest[:i]
+	}
+
+	segments, err := splitSegments(rest)
+	if err != nil {
+		return nil, fmt.Errorf("path template %q: %w", path, err)
+	}
+
+	var route []string
+	param := func(catchAll bool) string {
+		name := "p" + strconv.Itoa(len(route))
+		if catchAll {
+			route = append(route, "*"+name)
+		} else {
+			route = append(route, ":"+name)
+		}
+		return name
+	}
+	for _, seg := range segments {
+		if !strings.HasPrefix(seg, "{") {
+			switch seg {
+			case "*":
+				param(false)
+			case "**":
+				param(true)
+			default:
+				route = append(route, seg)
+			}
+			continue
+		}
+
+		field, pattern, found := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(seg, "{"), "}"), "=")
+		if !found {
+			pattern = "*"
+		}
+		v := variable{field: strings.Split(field, ".")}
+		for i, sub := range strings.Split(pattern, "/") {
+			if i > 0 {
+				v.parts = append(v.parts, part{literal: "/"})
+			}
+			switch sub {
+			case "*":
+				v.parts = append(v.parts, part{param: param(false)})
+			case "**":
+				v.parts = append(v.parts, part{param: param(true)})
+			default:
+				route = append(route, sub)
+				v.parts = append(v.parts, part{literal: sub})
+			}
+		}
+		t.vars = append(t.vars, v)
+	}
+
+	for i, seg := range route {
+		if strings.HasPrefix(seg, "*") && i != len(route)-1 {
+			return nil, fmt.Errorf("path template %q: ** must be the last segment", path)
+		}
+	}
+	if t.verb != "" {
+		if len(route) == 0 {
+			return nil, fmt.Errorf("path template %q: verb without segment", path)
+		}
+		last := route[len(route)-1]
+		switch {
+		case strings.HasPrefix(last, "*"):
+			return nil, fmt.Errorf("path template %q: ** can't have a verb", path)
+		case strings.HasPrefix(last, ":"):
+			t.lastParam = last[1:]
+		default:
+			t.lastLiteral = last
+		}
+		t.verbParam = "p" + strconv.Itoa(len(route)-1)
+		route[len(route)-1] = ":" + t.verbParam
+	}
+
+	t.route = "/" + strings.Join(route, "/")
+	return t, nil
+}
+
+// splitSegments splits a path on the slashes outside the variables.
+func splitSegments(path string) ([]string, error) {
+	var (
+		segments []string
+		depth    int
+		start    int
+	)
+	for i, r := range path {
+		switch r {
+		case '{':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("nested variable")
+			}
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced }")
+			}
+		case '/':
+			if depth == 0 {
+				segments = append(segments, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced {")
+	}
+	segments = append(segments, path[start:])
+	for _, seg := range segments {
+		if seg == "" {
+			return nil, fmt.Errorf("empty segment")
+		}
+	}
+	return segments, nil
+}
+
+// match checks the verb of a request and returns the values of the path
+// variables, by field path, from its route parameters.
+func (t *template) match(params func(name string) string) (map[string]string, bool) {
+	values := make(map[string]string)
+	get := params
+	if t.verb != "" {
+		last, ok := strings.CutSuffix(params(t.verbParam), ":"+t.verb)
+		if !ok {
+			return nil, false
+		}
+		if t.lastLiteral != "" && last != t.lastLiteral {
+			return nil, false
+		}
+		get = func(name string) string {
+			if name == t.lastParam {
+				return last
+			}
+			return params(name)
+		}
+	}
+
+	for _, v := range t.vars {
+		var b strings.Builder
+		for _, p := range v.parts {
+			if p.param != "" {
+				b.WriteString(strings.TrimPrefix(get(p.param), "/"))
+			} else {
+				b.WriteString(p.literal)
+			}
+		}
+		values[strings.Join(v.field, ".")] = b.String()
+	}
+	return values, true
+}
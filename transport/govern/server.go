@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	_ "net/http/pprof"
+	"sync"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
@@ -15,8 +16,14 @@ import (
 type Server struct {
 	opts   *transport.Options
 	server *server.Hertz
+
+	mu     sync.RWMutex
+	checks map[string]HealthCheck
 }
 
+// HealthCheck reports whether a dependency of the service is healthy.
+type HealthCheck func(ctx context.Context) error
+
 // NewServer creates a new govern server.
 func NewServer(opts ...transport.ServerOption) *Server {
 	options := &transport.Options{}
@@ -25,7 +32,8 @@ func NewServer(opts ...transport.ServerOption) *Server {
 	}
 
 	srv := &Server{
-		opts: options,
+		opts:   options,
+		checks: make(map[string]HealthCheck),
 	}
 
 	// Create Hertz server for management
@@ -52,6 +60,10 @@ func NewServer(opts ...transport.ServerOption) *Server {
 
 	// Register health check endpoint
 	hertzServer.GET("/health", func(ctx context.Context, c *app.RequestContext) {
+		if failures := srv.runHealthChecks(ctx); len(failures) > 0 {
+			c.JSON(http.StatusServiceUnavailable, failures)
+			return
+		}
 		c.String(http.StatusOK, "OK")
 	})
 
@@ -77,6 +89,28 @@ func (s *Server) Stop(ctx context.Context) error {
 	return s.server.Shutdown(ctx)
 }
 
+// AddHealthCheck adds a check to the health endpoint, which responds with
+// 503 and the failed checks while any of them returns an error.
+func (s *Server) AddHealthCheck(name string, check HealthCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[name] = check
+}
+
+// runHealthChecks runs the health checks and returns the errors of the failed ones.
+func (s *Server) runHealthChecks(ctx context.Context) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	failures := make(map[string]string)
+	for name, check := range s.checks {
+		if err := check(ctx); err != nil {
+			failures[name] = err.Error()
+		}
+	}
+	return failures
+}
+
 // GetHertzServer returns the underlying Hertz server.
 func (s *Server) GetHertzServer() *server.Hertz {
 	return s.server
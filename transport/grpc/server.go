@@ -9,9 +9,9 @@ import (
 	"new-milli/transport"
 )
 
-var (
-	_ transport.Server = (*Server)(nil)
-)
+// The server doesn't listen yet, so it isn't a transport.Endpointer: it
+// mustn't be advertised to the registry.
+var _ transport.Server = (*Server)(nil)
 
 // Server is a gRPC server wrapper based on Kitex.
 type Server struct {
@@ -0,0 +1,181 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server/binding"
+	"new-milli/codec"
+)
+
+// Tags read by Bind.
+const (
+	// TagPath names the path parameter of a field.
+	TagPath = "path"
+	// TagQuery names the query parameter of a field.
+	TagQuery = "query"
+	// TagHeader names the header of a field.
+	TagHeader = "header"
+	// TagDefault is the value of a field left zero by the request.
+	TagDefault = "default"
+	// TagValidate is the validation expression of a field, see the Hertz
+	// binding documentation, e.g. vd:"$>0 && $<=100".
+	TagValidate = "vd"
+)
+
+// CodeInvalidArgument is the code of the errors returned by Bind.
+const CodeInvalidArgument = "INVALID_ARGUMENT"
+
+// Validator is implemented by requests checking themselves once bound.
+type Validator interface {
+	Validate() error
+}
+
+// validationError is a failed validation expression.
+type validationError struct {
+	field, msg string
+}
+
+// Error implements the error interface.
+func (e *validationError) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return "invalid field " + e.field
+}
+
+// validator checks the vd tags.
+var validator = binding.NewValidator(&binding.ValidateConfig{
+	ValidateTag: TagValidate,
+	ErrFactory: func(field, msg string) error {
+		return &validationError{field: field, msg: msg}
+	},
+})
+
+func init() {
+	RegisterError(ErrUnsupportedContentType, http.StatusUnsupportedMediaType, "")
+}
+
+// Bind binds the request into v, a pointer to a struct, and validates it:
+//   - the body is decoded with Decode;
+//   - the fields tagged path, query and header are set from the path
+//     parameters, the query string and the headers, with the form rules;
+//   - the fields still zero are set from their default tag, comma-separated
+//     for slices;
+//   - the vd tags are checked, then Validate is called if v implements
+//     Validator.
+//
+// The errors are *Error with status 400 Bad Request and the code
+// CodeInvalidArgument, the invalid field in the "field" detail, except for
+// bodies of unsupported content types which get 415. They can be written
+// with RespondError as is.
+//
+//	type ListRequest struct {
+//		Org   string `path:"org" vd:"len($)>0"`
+//		Page  int    `query:"page" default:"1" vd:"$>0"`
+//		Size  int    `query:"size" default:"20" vd:"$>0 && $<=100"`
+//		Trace string `header:"X-Trace"`
+//	}
+func Bind(c *app.RequestContext, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("http: can't bind a request into %T", v)
+	}
+
+	if err := Decode(c, v); err != nil {
+		if errors.Is(err, ErrUnsupportedContentType) {
+			return FromError(err)
+		}
+		return invalid("", err)
+	}
+	if err := bindFields(c, rv.Elem()); err != nil {
+		return err
+	}
+
+	if err := validator.ValidateStruct(v); err != nil {
+		var ve *validationError
+		if errors.As(err, &ve) {
+			return invalid(ve.field, ve)
+		}
+		return invalid("", err)
+	}
+	if val, ok := v.(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return invalid("", err)
+		}
+	}
+	return nil
+}
+
+// bindFields sets the fields of rv from the request and their defaults,
+// embedded structs included.
+func bindFields(c *app.RequestContext, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f, fv := rt.Field(i), rv.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := bindFields(c, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		name, vs := fieldValues(c, f)
+		if len(vs) > 0 {
+			if err := codec.SetField(fv, vs); err != nil {
+				return invalid(name, fmt.Errorf("invalid field %s: %w", name, err))
+			}
+		}
+		if def, ok := f.Tag.Lookup(TagDefault); ok && fv.IsZero() {
+			vs := []string{def}
+			if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+				vs = strings.Split(def, ",")
+			}
+			if err := codec.SetField(fv, vs); err != nil {
+				return fmt.Errorf("http: invalid default of field %s: %w", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fieldValues returns the name and the values of a field in the request.
+func fieldValues(c *app.RequestContext, f reflect.StructField) (string, []string) {
+	if name := f.Tag.Get(TagPath); name != "" {
+		if value, ok := c.Params.Get(name); ok {
+			return name, []string{value}
+		}
+		return name, nil
+	}
+	if name := f.Tag.Get(TagQuery); name != "" {
+		var vs []string
+		for _, b := range c.QueryArgs().PeekAll(name) {
+			vs = append(vs, string(b))
+		}
+		return name, vs
+	}
+	if name := f.Tag.Get(TagHeader); name != "" {
+		var vs []string
+		for _, b := range c.Request.Header.PeekAll(name) {
+			vs = append(vs, string(b))
+		}
+		return name, vs
+	}
+	return f.Name, nil
+}
+
+// invalid returns the error of an invalid request.
+func invalid(field string, err error) *Error {
+	e := NewError(http.StatusBadRequest, CodeInvalidArgument, err.Error()).WithCause(err)
+	if field != "" {
+		e = e.WithDetail("field", field)
+	}
+	return e
+}
@@ -0,0 +1,383 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"new-milli/codec"
+	"new-milli/middleware"
+	"new-milli/registry"
+	"new-milli/transport"
+	"new-milli/transport/balancer"
+	"new-milli/transport/resolver"
+	"new-milli/transport/warmer"
+)
+
+// StatusError is returned by the client when the server responds with a 5xx status,
+// with the response body, instead of the response.
+// Invoke also returns it for any other non-2xx status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+// Error implements the error interface.
+func (e *StatusError) Error() string {
+	if len(e.Body) > 0 {
+		return fmt.Sprintf("http: server responded with %s: %s", e.Status, e.Body)
+	}
+	return fmt.Sprintf("http: server responded with %s", e.Status)
+}
+
+// Retryable reports whether the request may succeed on another attempt.
+func (e *StatusError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// ClientOption is HTTP client option.
+type ClientOption func(*clientOptions)
+
+// clientOptions is HTTP client options.
+type clientOptions struct {
+	endpoint   string
+	timeout    time.Duration
+	tlsConf    *tls.Config
+	transport  http.RoundTripper
+	discovery  registry.Registry
+	balancer   balancer.Balancer
+	middleware []middleware.Middleware
+	warmer     *warmer.Warmer
+	codec      codec.Codec
+}
+
+// WithEndpoint sets the client endpoint.
+// Use "discovery:///service-name" together with WithDiscovery to resolve
+// the service through a registry, or a comma separated list of addresses.
+func WithEndpoint(endpoint string) ClientOption {
+	return func(o *clientOptions) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithTimeout sets the client request timeout.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithTLSConfig sets the client TLS config and switches the default scheme to https.
+func WithTLSConfig(c *tls.Config) ClientOption {
+	return func(o *clientOptions) {
+		o.tlsConf = c
+	}
+}
+
+// WithTransport sets the underlying round tripper.
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.transport = rt
+	}
+}
+
+// WithDiscovery sets the registry used to resolve discovery endpoints.
+func WithDiscovery(r registry.Registry) ClientOption {
+	return func(o *clientOptions) {
+		o.discovery = r
+	}
+}
+
+// WithBalancer sets the load balancer. Defaults to p2c.
+func WithBalancer(b balancer.Balancer) ClientOption {
+	return func(o *clientOptions) {
+		o.balancer = b
+	}
+}
+
+// WithMiddleware sets the client middleware.
+func WithMiddleware(m ...middleware.Middleware) ClientOption {
+	return func(o *clientOptions) {
+		o.middleware = m
+	}
+}
+
+// WithWarmer dials through the warmer so the first requests reuse its
+// pre-resolved and pre-established connections.
+func WithWarmer(w *warmer.Warmer) ClientOption {
+	return func(o *clientOptions) {
+		o.warmer = w
+	}
+}
+
+// WithCodec sets the codec Invoke encodes requests with, e.g. codec.Get("msgpack").
+// Responses are decoded by their Content-Type. Defaults to JSON.
+func WithCodec(c codec.Codec) ClientOption {
+	return func(o *clientOptions) {
+		o.codec = c
+	}
+}
+
+// Client is an HTTP client with service discovery and load balancing.
+type Client struct {
+	opts     clientOptions
+	scheme   string
+	cc       *http.Client
+	resolver resolver.Resolver
+	handler  middleware.Handler
+}
+
+// NewClient creates a new HTTP client.
+func NewClient(ctx context.Context, opts ...ClientOption) (*Client, error) {
+	options := clientOptions{
+		timeout:   2 * time.Second,
+		transport: http.DefaultTransport,
+		balancer:  balancer.NewP2C(),
+		codec:     codec.JSON,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	scheme := "http"
+	if options.tlsConf != nil {
+		scheme = "https"
+		if t, ok := options.transport.(*http.Transport); ok {
+			t = t.Clone()
+			t.TLSClientConfig = options.tlsConf
+			options.transport = t
+		}
+	}
+
+	if options.warmer != nil {
+		if t, ok := options.transport.(*http.Transport); ok {
+			t = t.Clone()
+			t.DialContext = options.warmer.DialContext
+			t.DialTLSContext = options.warmer.TLSDialer(t.TLSClientConfig)
+			options.transport = t
+		}
+	}
+
+	r, err := resolver.New(ctx, options.endpoint, options.discovery, resolver.WithFilter(isHTTPNode))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		opts:     options,
+		scheme:   scheme,
+		resolver: r,
+		cc: &http.Client{
+			Timeout:   options.timeout,
+			Transport: options.transport,
+		},
+	}
+	c.handler = middleware.Chain(options.middleware...)(c.invoke)
+
+	return c, nil
+}
+
+// Do sends an HTTP request to one of the resolved nodes.
+// The request URL only needs a path, its scheme and host are set from the picked node.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	reqHeader := &HeaderCarrier{}
+	for k, vs := range req.Header {
+		for _, v := range vs {
+			reqHeader.Add(k, v)
+		}
+	}
+	tr := &Transport{
+		operation:   req.URL.Path,
+		method:      req.Method,
+		reqHeader:   reqHeader,
+		replyHeader: &HeaderCarrier{},
+	}
+
+	ctx := transport.NewClientContext(req.Context(), tr)
+	reply, err := c.handler(ctx, req)
+	resp, _ := reply.(*http.Response)
+	return resp, err
+}
+
+// CallOption is a function that configures a request sent by Invoke.
+type CallOption func(*http.Request)
+
+// Header returns a CallOption adding a value to a header of the request.
+func Header(key, value string) CallOption {
+	return func(req *http.Request) {
+		req.Header.Add(key, value)
+	}
+}
+
+// Cookie returns a CallOption adding a cookie to the request.
+func Cookie(name, value string) CallOption {
+	return func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+}
+
+// Invoke sends a request with args as body, encoded with the client codec,
+// and decodes the response into reply with the codec of its Content-Type.
+// args and reply may be nil. Non-2xx responses are returned as *StatusError.
+func (c *Client) Invoke(ctx context.Context, method, path string, args interface{}, reply interface{}, opts ...CallOption) error {
+	var body io.Reader
+	if args != nil {
+		data, err := c.opts.codec.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if args != nil {
+		req.Header.Set("Content-Type", c.opts.codec.ContentType())
+	}
+	req.Header.Set("Accept", c.opts.codec.ContentType())
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: data}
+	}
+	if reply == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	rc := c.opts.codec
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		if cc := codec.ForContentType(ct); cc != nil {
+			rc = cc
+		}
+	}
+	if err := rc.Unmarshal(data, reply); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Close stops resolving endpoints.
+func (c *Client) Close() error {
+	return c.resolver.Close()
+}
+
+// invoke is the final handler of the middleware chain.
+func (c *Client) invoke(ctx context.Context, in interface{}) (interface{}, error) {
+	req := in.(*http.Request).Clone(ctx)
+
+	// Rewind the body so the request can be sent again, e.g. by a retry middleware
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+
+	node, done, err := c.opts.balancer.Pick(ctx, c.resolver.Nodes())
+	if err != nil {
+		return nil, err
+	}
+	c.target(req, node)
+
+	// Headers may have been set by client middleware
+	if tr, ok := transport.FromClientContext(ctx); ok {
+		header := tr.RequestHeader()
+		for _, k := range header.Keys() {
+			if hc, ok := header.(*HeaderCarrier); ok {
+				req.Header.Del(k)
+				for _, v := range hc.Values(k) {
+					req.Header.Add(k, v)
+				}
+			} else {
+				req.Header.Set(k, header.Get(k))
+			}
+		}
+	}
+
+	resp, err := c.cc.Do(req)
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		err = &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	done(ctx, balancer.DoneInfo{Err: err})
+	if resp == nil {
+		return nil, err
+	}
+
+	if tr, ok := transport.FromClientContext(ctx); ok {
+		header := tr.ReplyHeader()
+		for k, vs := range resp.Header {
+			if hc, ok := header.(*HeaderCarrier); ok {
+				// Replace the values of a previous attempt
+				for i, v := range vs {
+					if i == 0 {
+						hc.Set(k, v)
+					} else {
+						hc.Add(k, v)
+					}
+				}
+			} else {
+				header.Set(k, resp.Header.Get(k))
+			}
+		}
+	}
+
+	// The 5xx responses are returned as errors only
+	if se, ok := err.(*StatusError); ok {
+		se.Body, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, se
+	}
+	return resp, nil
+}
+
+// isHTTPNode reports whether a registry node serves HTTP, the nodes of the
+// other servers of a service, e.g. gRPC, can't be called.
+func isHTTPNode(node *registry.Node) bool {
+	scheme := node.Metadata["scheme"]
+	if i := strings.Index(node.Address, "://"); i >= 0 {
+		scheme = node.Address[:i]
+	}
+	return scheme == "" || scheme == "http" || scheme == "https"
+}
+
+// target points the request at the given node.
+func (c *Client) target(req *http.Request, node *registry.Node) {
+	scheme, addr := c.scheme, node.Address
+	if s := node.Metadata["scheme"]; s == "http" || s == "https" {
+		scheme = s
+	}
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme, addr = addr[:i], addr[i+3:]
+	}
+	req.URL.Scheme = scheme
+	req.URL.Host = addr
+	req.Host = addr
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"new-milli/codec"
+)
+
+// ErrUnsupportedContentType is returned by Decode when no codec is
+// registered for the Content-Type of the request.
+var ErrUnsupportedContentType = errors.New("http: unsupported content type")
+
+// Decode decodes the request body into v with the codec registered for its
+// Content-Type, JSON when the request has none.
+func Decode(c *app.RequestContext, v interface{}) error {
+	cc := codec.JSON
+	if ct := string(c.Request.Header.ContentType()); ct != "" {
+		if cc = codec.ForContentType(ct); cc == nil {
+			return fmt.Errorf("%w %q", ErrUnsupportedContentType, ct)
+		}
+	}
+	body := c.Request.Body()
+	if len(body) == 0 {
+		return nil
+	}
+	if err := cc.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode request: %w", err)
+	}
+	return nil
+}
+
+// DecodeQuery decodes the query string into v with the form codec rules.
+func DecodeQuery(c *app.RequestContext, v interface{}) error {
+	values, err := url.ParseQuery(string(c.QueryArgs().QueryString()))
+	if err != nil {
+		return fmt.Errorf("failed to parse query: %w", err)
+	}
+	return codec.DecodeValues(values, v)
+}
+
+// Encode writes v as the response body with the given status, encoded with
+// the codec the Accept header prefers, JSON by default.
+func Encode(c *app.RequestContext, status int, v interface{}) error {
+	cc := codec.Negotiate(string(c.GetHeader("Accept")), codec.JSON)
+	data, err := cc.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	c.Data(status, cc.ContentType(), data)
+	return nil
+}
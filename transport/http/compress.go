@@ -0,0 +1,343 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+)
+
+// Supported content encodings.
+const (
+	EncodingGzip   = "gzip"
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+)
+
+// Encoder is a streaming compressor.
+type Encoder interface {
+	io.WriteCloser
+	// Flush writes the pending compressed data so the client can decode it.
+	Flush() error
+}
+
+// encoders creates the encoder of each supported encoding.
+var encoders = map[string]func(w io.Writer) (Encoder, error){
+	EncodingGzip: func(w io.Writer) (Encoder, error) {
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	},
+	EncodingZstd: func(w io.Writer) (Encoder, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	},
+	EncodingBrotli: func(w io.Writer) (Encoder, error) {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+	},
+}
+
+// CompressOption is response compression option.
+type CompressOption func(*compressOptions)
+
+// compressOptions is response compression options.
+type compressOptions struct {
+	encodings    []string
+	minSize      int
+	contentTypes []string
+	registry     prometheus.Registerer
+}
+
+// WithEncodings returns a CompressOption that sets the supported encodings in order of preference.
+func WithEncodings(encodings ...string) CompressOption {
+	return func(o *compressOptions) {
+		o.encodings = encodings
+	}
+}
+
+// WithMinSize returns a CompressOption that sets the minimum body size in bytes worth compressing.
+func WithMinSize(size int) CompressOption {
+	return func(o *compressOptions) {
+		o.minSize = size
+	}
+}
+
+// WithContentTypes returns a CompressOption that sets the compressed content types,
+// a trailing "/" matches every subtype, e.g. "text/".
+func WithContentTypes(contentTypes ...string) CompressOption {
+	return func(o *compressOptions) {
+		o.contentTypes = contentTypes
+	}
+}
+
+// WithCompressRegistry returns a CompressOption that sets the registry of the compression metrics,
+// nil disables them.
+func WithCompressRegistry(registry prometheus.Registerer) CompressOption {
+	return func(o *compressOptions) {
+		o.registry = registry
+	}
+}
+
+// compressMetrics are the compression metrics.
+type compressMetrics struct {
+	responses *prometheus.CounterVec
+	saved     *prometheus.CounterVec
+}
+
+// observe records a compressed response.
+func (m *compressMetrics) observe(encoding string, original, compressed int64) {
+	if m == nil {
+		return
+	}
+	m.responses.WithLabelValues(encoding).Inc()
+	m.saved.WithLabelValues(encoding).Add(float64(original - compressed))
+}
+
+// newCompressMetrics creates the compression metrics, reusing the registered ones.
+func newCompressMetrics(registry prometheus.Registerer) *compressMetrics {
+	if registry == nil {
+		return nil
+	}
+	m := &compressMetrics{
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "http",
+			Name:      "compressed_responses_total",
+			Help:      "Total number of compressed responses.",
+		}, []string{"encoding"}),
+		saved: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "http",
+			Name:      "compression_bytes_saved_total",
+			Help:      "Total number of response bytes saved by compression.",
+		}, []string{"encoding"}),
+	}
+	var err error
+	if m.responses, err = collector.Register(registry, m.responses); err != nil {
+		klog.Warnf("Failed to register compression metrics: %v", err)
+	}
+	if m.saved, err = collector.Register(registry, m.saved); err != nil {
+		klog.Warnf("Failed to register compression metrics: %v", err)
+	}
+	return m
+}
+
+// Compress returns a Hertz handler that compresses responses with the encoding
+// negotiated from Accept-Encoding. Streamed bodies are compressed as they are read
+// and flushed after every chunk, so server-sent events are delivered without delay.
+// Responses written through a hijacked writer are not compressed.
+//
+// Register it with GetHertzServer().Use(http.Compress()).
+func Compress(opts ...CompressOption) app.HandlerFunc {
+	cfg := compressOptions{
+		encodings: []string{EncodingBrotli, EncodingZstd, EncodingGzip},
+		minSize:   1024,
+		contentTypes: []string{
+			"text/",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"application/x-ndjson",
+			"image/svg+xml",
+		},
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	m := newCompressMetrics(cfg.registry)
+
+	return func(c context.Context, ctx *app.RequestContext) {
+		encoding := negotiateEncoding(string(ctx.Request.Header.Peek("Accept-Encoding")), cfg.encodings)
+		if encoding == "" || string(ctx.Request.Header.Method()) == http.MethodHead {
+			ctx.Next(c)
+			return
+		}
+
+		ctx.Next(c)
+
+		resp := &ctx.Response
+		resp.Header.Add("Vary", "Accept-Encoding")
+		if !shouldCompress(ctx, cfg) {
+			return
+		}
+
+		if resp.IsBodyStream() {
+			if size := resp.Header.ContentLength(); size >= 0 && size < cfg.minSize {
+				return
+			}
+			r, err := newCompressReader(resp.BodyStream(), encoding, m)
+			if err != nil {
+				klog.CtxWarnf(c, "Failed to create %s encoder: %v", encoding, err)
+				return
+			}
+			resp.SetBodyStreamNoReset(r, -1)
+			resp.Header.Set("Content-Encoding", encoding)
+			return
+		}
+
+		body := resp.Body()
+		if len(body) < cfg.minSize {
+			return
+		}
+		var buf bytes.Buffer
+		enc, err := encoders[encoding](&buf)
+		if err == nil {
+			if _, err = enc.Write(body); err == nil {
+				err = enc.Close()
+			}
+		}
+		if err != nil {
+			klog.CtxWarnf(c, "Failed to compress response with %s: %v", encoding, err)
+			return
+		}
+		if buf.Len() >= len(body) {
+			// Incompressible, send it as is
+			return
+		}
+		m.observe(encoding, int64(len(body)), int64(buf.Len()))
+		resp.SetBodyRaw(buf.Bytes())
+		resp.Header.Set("Content-Encoding", encoding)
+	}
+}
+
+// shouldCompress reports whether the response may be compressed.
+func shouldCompress(ctx *app.RequestContext, cfg compressOptions) bool {
+	resp := &ctx.Response
+	if resp.GetHijackWriter() != nil || resp.MustSkipBody() {
+		return false
+	}
+	if len(resp.Header.Peek("Content-Encoding")) > 0 {
+		return false
+	}
+	if strings.Contains(string(resp.Header.Peek("Cache-Control")), "no-transform") {
+		return false
+	}
+	contentType := string(resp.Header.ContentType())
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(strings.ToLower(contentType))
+	for _, t := range cfg.contentTypes {
+		if contentType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns the supported encoding with the highest quality in the
+// Accept-Encoding header, ties are broken by the order of supported.
+func negotiateEncoding(accept string, supported []string) string {
+	if accept == "" {
+		return ""
+	}
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		quality[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	var (
+		best  string
+		bestQ float64
+	)
+	for _, encoding := range supported {
+		q, ok := quality[encoding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if !ok || q <= bestQ {
+			continue
+		}
+		if _, known := encoders[encoding]; known {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressReader compresses a body stream as it is read.
+type compressReader struct {
+	src        io.Reader
+	enc        Encoder
+	encoding   string
+	buf        bytes.Buffer
+	chunk      []byte
+	done       bool
+	original   int64
+	compressed int64
+	metrics    *compressMetrics
+}
+
+// newCompressReader creates a reader compressing src with encoding.
+func newCompressReader(src io.Reader, encoding string, m *compressMetrics) (*compressReader, error) {
+	r := &compressReader{
+		src:      src,
+		encoding: encoding,
+		chunk:    make([]byte, 32*1024),
+		metrics:  m,
+	}
+	enc, err := encoders[encoding](&r.buf)
+	if err != nil {
+		return nil, err
+	}
+	r.enc = enc
+	return r, nil
+}
+
+// Read implements io.Reader.
+func (r *compressReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 && !r.done {
+		n, err := r.src.Read(r.chunk)
+		if n > 0 {
+			r.original += int64(n)
+			if _, werr := r.enc.Write(r.chunk[:n]); werr != nil {
+				return 0, werr
+			}
+			// Flush every chunk so streamed events reach the client immediately
+			if ferr := r.enc.Flush(); ferr != nil {
+				return 0, ferr
+			}
+		}
+		if err == io.EOF {
+			if cerr := r.enc.Close(); cerr != nil {
+				return 0, cerr
+			}
+			r.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	if r.buf.Len() == 0 && r.done {
+		r.metrics.observe(r.encoding, r.original, r.compressed)
+		r.metrics = nil
+		return 0, io.EOF
+	}
+	n, _ := r.buf.Read(p)
+	r.compressed += int64(n)
+	return n, nil
+}
+
+// Close closes the source stream.
+func (r *compressReader) Close() error {
+	if c, ok := r.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
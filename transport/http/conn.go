@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/network/netpoll"
+)
+
+// connTracker tracks the connections of a server so they can be closed
+// while draining.
+type connTracker struct {
+	mu      sync.Mutex
+	conns   map[net.Conn]*connContext
+	pruneAt int
+	force   chan struct{}
+	once    sync.Once
+}
+
+// newConnTracker creates a connection tracker.
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns:   make(map[net.Conn]*connContext),
+		pruneAt: 1024,
+		force:   make(chan struct{}),
+	}
+}
+
+// onAccept tracks a connection and returns its base context, canceled when
+// the connections are forcibly closed.
+func (t *connTracker) onAccept(conn net.Conn) context.Context {
+	cc := &connContext{Context: context.Background(), accepted: time.Now(), force: t.force}
+
+	t.mu.Lock()
+	t.conns[conn] = cc
+	if len(t.conns) >= t.pruneAt {
+		// Hertz doesn't report closed connections, sweep them now and then
+		for c := range t.conns {
+			if isClosed(c) {
+				delete(t.conns, c)
+			}
+		}
+		t.pruneAt = 2 * len(t.conns)
+		if t.pruneAt < 1024 {
+			t.pruneAt = 1024
+		}
+	}
+	t.mu.Unlock()
+
+	return cc
+}
+
+// closeIdle closes the connections that had no request in progress and
+// started none since the previous call, and returns the number of
+// connections left. A request being read isn't counted as in progress yet,
+// waiting for a second idle sweep leaves it time to reach the handlers.
+func (t *connTracker) closeIdle() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for c, cc := range t.conns {
+		if isClosed(c) {
+			delete(t.conns, c)
+			continue
+		}
+		if cc.active.Load() > 0 {
+			cc.idle = false
+			continue
+		}
+		n := cc.requests.Load()
+		if cc.idle && cc.idleAt == n {
+			c.Close()
+			delete(t.conns, c)
+			continue
+		}
+		cc.idle, cc.idleAt = true, n
+	}
+	return len(t.conns)
+}
+
+// closeAll cancels the contexts of the connections, closes them and
+// returns the number of connections closed.
+func (t *connTracker) closeAll() int {
+	t.once.Do(func() { close(t.force) })
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for c := range t.conns {
+		if !isClosed(c) {
+			c.Close()
+			n++
+		}
+		delete(t.conns, c)
+	}
+	return n
+}
+
+// isClosed reports whether a connection is closed.
+func isClosed(conn net.Conn) bool {
+	if c, ok := conn.(*netpoll.Conn); ok {
+		conn = c.Conn
+	}
+	// netpoll connections
+	if c, ok := conn.(interface{ IsActive() bool }); ok {
+		return !c.IsActive()
+	}
+	// go net connections fail to reach their descriptor once closed
+	if c, ok := conn.(syscall.Conn); ok {
+		rc, err := c.SyscallConn()
+		if err != nil {
+			return true
+		}
+		return rc.Control(func(uintptr) {}) != nil
+	}
+	return false
+}
+
+type connKey struct{}
+
+// connContext is the base context of the requests of a connection. It
+// doesn't register with a parent so connections don't leak cancel funcs.
+type connContext struct {
+	context.Context
+	accepted time.Time
+	force    <-chan struct{}
+	active   atomic.Int32
+	requests atomic.Uint64
+
+	// idle and idleAt are the state of the previous closeIdle sweep,
+	// guarded by the tracker lock
+	idle   bool
+	idleAt uint64
+}
+
+// Done implements context.Context.
+func (c *connContext) Done() <-chan struct{} {
+	return c.force
+}
+
+// Err implements context.Context.
+func (c *connContext) Err() error {
+	select {
+	case <-c.force:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+// Value implements context.Context.
+func (c *connContext) Value(key interface{}) interface{} {
+	if key == (connKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+// connHandler counts the requests in progress on each connection until their
+// response is written and, when
+// maxAge is set, closes keep-alive connections older than maxAge after their
+// current request.
+func connHandler(maxAge time.Duration) app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		cc, ok := c.Value(connKey{}).(*connContext)
+		if !ok {
+			ctx.Next(c)
+			return
+		}
+		cc.active.Add(1)
+		cc.requests.Add(1)
+		// The response is written after the handlers return, Finished is
+		// closed once it is
+		finished := ctx.Finished()
+		go func() {
+			<-finished
+			cc.active.Add(-1)
+		}()
+
+		if maxAge > 0 {
+			// Jitter spreads the reconnections of connections opened together
+			limit := maxAge + time.Duration(rand.Int63n(int64(maxAge)/10+1))
+			if time.Since(cc.accepted) > limit {
+				ctx.SetConnectionClose()
+			}
+		}
+		ctx.Next(c)
+	}
+}
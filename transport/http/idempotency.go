@@ -0,0 +1,253 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/cache"
+)
+
+// Headers of the idempotent requests.
+const (
+	// HeaderIdempotencyKey is the header carrying the idempotency key of a request.
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderIdempotentReplayed is set to "true" on the replayed responses.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// IdempotencyOption is idempotency option.
+type IdempotencyOption func(*idempotencyOptions)
+
+// idempotencyOptions is idempotency options.
+type idempotencyOptions struct {
+	header   string
+	prefix   string
+	ttl      time.Duration
+	lockTTL  time.Duration
+	required bool
+	scope    func(ctx context.Context, c *app.RequestContext) string
+}
+
+// WithIdempotencyHeader returns an IdempotencyOption that sets the header
+// carrying the key, HeaderIdempotencyKey by default.
+func WithIdempotencyHeader(header string) IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.header = header
+	}
+}
+
+// WithIdempotencyPrefix returns an IdempotencyOption that sets the prefix of
+// the store keys, "idempotency:" by default.
+func WithIdempotencyPrefix(prefix string) IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithIdempotencyTTL returns an IdempotencyOption that sets how long the
+// responses are replayed, 24 hours by default.
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithIdempotencyLockTTL returns an IdempotencyOption that sets how long a
+// request in progress holds its key, 1 minute by default. It should exceed
+// the longest request so a crashed instance doesn't hold keys forever.
+func WithIdempotencyLockTTL(ttl time.Duration) IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.lockTTL = ttl
+	}
+}
+
+// WithIdempotencyRequired returns an IdempotencyOption that rejects the
+// requests without a key with 400 Bad Request.
+func WithIdempotencyRequired() IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.required = true
+	}
+}
+
+// WithIdempotencyScope returns an IdempotencyOption that scopes the keys,
+// e.g. by the id of the caller, so different callers can't replay the
+// responses of each other.
+func WithIdempotencyScope(fn func(ctx context.Context, c *app.RequestContext) string) IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.scope = fn
+	}
+}
+
+// unreplayedHeaders are the response headers that aren't stored: the
+// hop-by-hop headers belong to the original connection and the cookies to
+// the original caller.
+var unreplayedHeaders = map[string]bool{
+	"Connection":          true,
+	"Content-Length":      true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Set-Cookie":          true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// idempotentRecord is the stored state of an idempotency key.
+type idempotentRecord struct {
+	Pending     bool                `json:"pending,omitempty"`
+	Fingerprint string              `json:"fingerprint"`
+	Status      int                 `json:"status,omitempty"`
+	Header      map[string][]string `json:"header,omitempty"`
+	Body        []byte              `json:"body,omitempty"`
+}
+
+// Idempotency returns a handler honoring the Idempotency-Key header, for
+// payment-like endpoints that must not run twice. The response of the first
+// successful (2xx) execution of a key is stored in store, e.g. a Redis
+// store, and replayed to the retries within the TTL with the
+// Idempotent-Replayed header, without its hop-by-hop headers and cookies.
+//
+// The keys are scoped by method and path only: without
+// WithIdempotencyScope, a caller sending the key of another gets their
+// response, so endpoints whose responses depend on the caller should scope
+// the keys by caller.
+//
+// A retry arriving while the first request is in progress gets 409
+// Conflict, a key reused with a different request gets 422 Unprocessable
+// Entity. Failed and streamed responses aren't stored, so the request can
+// be retried. The key is taken atomically when store implements
+// cache.Adder. Store failures are logged and the request runs as if it had
+// no key.
+func Idempotency(store cache.Store, opts ...IdempotencyOption) app.HandlerFunc {
+	o := idempotencyOptions{
+		header:  HeaderIdempotencyKey,
+		prefix:  "idempotency:",
+		ttl:     24 * time.Hour,
+		lockTTL: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(c context.Context, ctx *app.RequestContext) {
+		key := string(ctx.GetHeader(o.header))
+		if key == "" {
+			if o.required {
+				ctx.Abort()
+				RespondError(c, ctx, NewError(http.StatusBadRequest, "", "missing "+o.header+" header"))
+				return
+			}
+			ctx.Next(c)
+			return
+		}
+
+		storeKey := o.prefix
+		if o.scope != nil {
+			storeKey += o.scope(c, ctx) + ":"
+		}
+		storeKey += string(ctx.Method()) + ":" + string(ctx.Path()) + ":" + key
+		fingerprint := requestFingerprint(ctx)
+
+		acquired, rec, err := acquireIdempotencyKey(c, store, storeKey, fingerprint, o.lockTTL)
+		if err != nil {
+			klog.CtxWarnf(c, "http: failed to take idempotency key %s: %v", key, err)
+			ctx.Next(c)
+			return
+		}
+		if !acquired {
+			ctx.Abort()
+			switch {
+			case rec == nil || rec.Pending:
+				RespondError(c, ctx, NewError(http.StatusConflict, "", "a request with this idempotency key is in progress"))
+			case rec.Fingerprint != fingerprint:
+				RespondError(c, ctx, NewError(http.StatusUnprocessableEntity, "", "the idempotency key was used with another request"))
+			default:
+				for k, vs := range rec.Header {
+					for _, v := range vs {
+						ctx.Response.Header.Add(k, v)
+					}
+				}
+				ctx.Response.Header.Set(HeaderIdempotentReplayed, "true")
+				ctx.Data(rec.Status, ctx.Response.Header.Get("Content-Type"), rec.Body)
+			}
+			return
+		}
+
+		ctx.Next(c)
+
+		// The request context may be canceled once the response is written
+		c = context.WithoutCancel(c)
+		status := ctx.Response.StatusCode()
+		if status < http.StatusOK || status >= http.StatusMultipleChoices || ctx.Response.IsBodyStream() {
+			if err := store.Delete(c, storeKey); err != nil {
+				klog.CtxWarnf(c, "http: failed to release idempotency key %s: %v", key, err)
+			}
+			return
+		}
+
+		rec = &idempotentRecord{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      make(map[string][]string),
+			Body:        append([]byte(nil), ctx.Response.Body()...),
+		}
+		ctx.Response.Header.VisitAll(func(k, v []byte) {
+			if name := string(k); !unreplayedHeaders[http.CanonicalHeaderKey(name)] {
+				rec.Header[name] = append(rec.Header[name], string(v))
+			}
+		})
+		rec.Header["Content-Type"] = []string{string(ctx.Response.Header.ContentType())}
+		data, err := json.Marshal(rec)
+		if err == nil {
+			err = store.Set(c, storeKey, data, o.ttl)
+		}
+		if err != nil {
+			klog.CtxWarnf(c, "http: failed to store idempotent response of key %s: %v", key, err)
+		}
+	}
+}
+
+// acquireIdempotencyKey takes a key for a request, or returns the record
+// holding it.
+func acquireIdempotencyKey(ctx context.Context, store cache.Store, key, fingerprint string, ttl time.Duration) (bool, *idempotentRecord, error) {
+	data, err := store.Get(ctx, key)
+	switch {
+	case err == nil:
+		var rec idempotentRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return false, nil, err
+		}
+		return false, &rec, nil
+	case !errors.Is(err, cache.ErrNotFound):
+		return false, nil, err
+	}
+
+	pending, err := json.Marshal(&idempotentRecord{Pending: true, Fingerprint: fingerprint})
+	if err != nil {
+		return false, nil, err
+	}
+	if adder, ok := store.(cache.Adder); ok {
+		added, err := adder.Add(ctx, key, pending, ttl)
+		return added, nil, err
+	}
+	return true, nil, store.Set(ctx, key, pending, ttl)
+}
+
+// requestFingerprint returns the hash of the query string and the body of
+// a request.
+func requestFingerprint(ctx *app.RequestContext) string {
+	h := sha256.New()
+	h.Write(ctx.QueryArgs().QueryString())
+	h.Write([]byte{0})
+	h.Write(ctx.Request.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,206 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/prometheus/client_golang/prometheus"
+	"new-milli/collector"
+)
+
+// Error codes of the rejected requests.
+const (
+	CodeBodyTooLarge   = "REQUEST_BODY_TOO_LARGE"
+	CodeHeaderTooLarge = "REQUEST_HEADER_TOO_LARGE"
+	CodeRequestTimeout = "REQUEST_TIMEOUT"
+)
+
+// LimitOption is request limit option.
+type LimitOption func(*limitOptions)
+
+// limitOptions is request limit options.
+type limitOptions struct {
+	maxBodySize   int64
+	maxHeaderSize int
+	readTimeout   time.Duration
+	registry      prometheus.Registerer
+}
+
+// WithMaxBodySize returns a LimitOption that rejects the request bodies over
+// size bytes with 413 Request Entity Too Large.
+func WithMaxBodySize(size int64) LimitOption {
+	return func(o *limitOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// WithMaxHeaderSize returns a LimitOption that rejects the requests whose
+// header fields exceed size bytes with 431 Request Header Fields Too Large.
+func WithMaxHeaderSize(size int) LimitOption {
+	return func(o *limitOptions) {
+		o.maxHeaderSize = size
+	}
+}
+
+// WithBodyReadTimeout returns a LimitOption that rejects the requests whose
+// body isn't received within timeout with 408 Request Timeout. It requires
+// StreamRequestBody, otherwise the body is read by the server before the
+// route is matched and only the server read timeout applies.
+func WithBodyReadTimeout(timeout time.Duration) LimitOption {
+	return func(o *limitOptions) {
+		o.readTimeout = timeout
+	}
+}
+
+// WithLimitRegistry returns a LimitOption that sets the registry of the
+// rejection metrics, nil disables them.
+func WithLimitRegistry(registry prometheus.Registerer) LimitOption {
+	return func(o *limitOptions) {
+		o.registry = registry
+	}
+}
+
+// Limit returns a Hertz handler enforcing the size and read time limits of
+// the requests of the routes it is added to, e.g. the upload endpoints:
+//
+//	h.POST("/ingest", http.Limit(http.WithMaxBodySize(32<<20), http.WithBodyReadTimeout(time.Minute)), ingest)
+//
+// The rejected requests get a structured error written by RespondError and
+// are counted in new_milli_http_rejected_requests_total.
+//
+// Without StreamRequestBody the server reads every body in memory, up to
+// MaxRequestBodySize, before the handlers run: Limit then only rejects it.
+// With it the body is read by Limit, up to the limit of the route and within
+// its read timeout, so a huge or slow upload is cut off before it fills the
+// memory or holds the connection.
+func Limit(opts ...LimitOption) app.HandlerFunc {
+	cfg := limitOptions{
+		registry: prometheus.DefaultRegisterer,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var rejected *prometheus.CounterVec
+	if cfg.registry != nil {
+		var err error
+		rejected, err = collector.Register(cfg.registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "new_milli",
+			Subsystem: "http",
+			Name:      "rejected_requests_total",
+			Help:      "Total number of requests rejected by the request limits.",
+		}, []string{"route", "reason"}))
+		if err != nil {
+			klog.Warnf("Failed to register request limit metrics: %v", err)
+		}
+	}
+
+	return func(ctx context.Context, c *app.RequestContext) {
+		if err := cfg.check(c); err != nil {
+			if rejected != nil {
+				rejected.WithLabelValues(c.FullPath(), strings.ToLower(err.Code)).Inc()
+			}
+			if err.Status != http.StatusRequestHeaderFieldsTooLarge {
+				// The rest of the body is left unread
+				c.SetConnectionClose()
+			}
+			c.Abort()
+			RespondError(ctx, c, err)
+			return
+		}
+		c.Next(ctx)
+	}
+}
+
+// check checks the limits of a request, reading its body when it is streamed.
+func (o *limitOptions) check(c *app.RequestContext) *Error {
+	if o.maxHeaderSize > 0 {
+		size := 0
+		c.Request.Header.VisitAll(func(k, v []byte) {
+			size += len(k) + len(v) + 4 // ": " and CRLF
+		})
+		if size > o.maxHeaderSize {
+			return NewError(http.StatusRequestHeaderFieldsTooLarge, CodeHeaderTooLarge, "request header too large").
+				WithDetail("limit", o.maxHeaderSize)
+		}
+	}
+
+	tooLarge := func() *Error {
+		return NewError(http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "request body too large").
+			WithDetail("limit", o.maxBodySize)
+	}
+	if o.maxBodySize > 0 && int64(c.Request.Header.ContentLength()) > o.maxBodySize {
+		return tooLarge()
+	}
+	if !c.Request.IsBodyStream() {
+		if o.maxBodySize > 0 && int64(len(c.Request.Body())) > o.maxBodySize {
+			return tooLarge()
+		}
+		return nil
+	}
+
+	body, err := o.readBody(c)
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		return tooLarge()
+	case errors.Is(err, errReadTimeout):
+		return NewError(http.StatusRequestTimeout, CodeRequestTimeout, "request body not received in time").
+			WithCause(err)
+	case err != nil:
+		return NewError(http.StatusBadRequest, "", "failed to read request body").WithCause(err)
+	}
+	c.Request.SetBody(body)
+	return nil
+}
+
+var (
+	errBodyTooLarge = errors.New("request body too large")
+	errReadTimeout  = errors.New("request body read timeout")
+)
+
+// readBody reads a streamed body within the size and time limits.
+func (o *limitOptions) readBody(c *app.RequestContext) ([]byte, error) {
+	r := c.RequestBodyStream()
+	conn := c.GetConn()
+	var deadline time.Time
+	if o.readTimeout > 0 {
+		deadline = time.Now().Add(o.readTimeout)
+	}
+
+	var buf bytes.Buffer
+	chunk := make([]byte, 32*1024)
+	for {
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, errReadTimeout
+			}
+			// The connection read timeout applies to each read, bound it by
+			// the time left. The server resets it for the next request.
+			if conn != nil {
+				_ = conn.SetReadTimeout(remaining)
+			}
+		}
+		n, err := r.Read(chunk)
+		buf.Write(chunk[:n])
+		if o.maxBodySize > 0 && int64(buf.Len()) > o.maxBodySize {
+			return nil, errBodyTooLarge
+		}
+		if errors.Is(err, io.EOF) {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			if isTimeout(err) || (!deadline.IsZero() && !time.Now().Before(deadline)) {
+				return nil, errReadTimeout
+			}
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"crypto/tls"
+	"time"
+
+	"new-milli/transport"
+)
+
+// ServerOption is HTTP server option. It implements transport.ServerOption
+// so it can be passed to NewServer along with the transport options, other
+// servers ignore it. The client TLS config is set with WithTLSConfig.
+type ServerOption func(*serverOptions)
+
+// Apply implements transport.ServerOption, the option is applied by NewServer.
+func (f ServerOption) Apply(o *transport.Options) {}
+
+// serverOptions is HTTP server options.
+type serverOptions struct {
+	tlsConf      *tls.Config
+	h2           interface{}
+	h2c          bool
+	maxConnAge   time.Duration
+	drainTimeout time.Duration
+	envelope     Envelope
+	maxBodySize  int
+	readTimeout  time.Duration
+	streamBody   bool
+}
+
+// TLSConfig serves HTTPS with c. Hertz serves TLS with the go net transport
+// instead of netpoll.
+func TLSConfig(c *tls.Config) ServerOption {
+	return func(o *serverOptions) {
+		o.tlsConf = c
+	}
+}
+
+// HTTP2 serves HTTP/2 with factory, the server factory of an HTTP/2
+// implementation for Hertz such as factory.NewServerFactory() of
+// github.com/hertz-contrib/http2. With TLSConfig it is negotiated by ALPN,
+// without it h2c is accepted.
+func HTTP2(factory interface{}) ServerOption {
+	return func(o *serverOptions) {
+		o.h2 = factory
+	}
+}
+
+// MaxConnectionAge closes keep-alive connections once they are older than
+// age, with up to 10% jitter, so clients reconnect and load spreads over new
+// instances.
+func MaxConnectionAge(age time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.maxConnAge = age
+	}
+}
+
+// DrainTimeout bounds the graceful drain of Stop within its context. The
+// server stops accepting connections, closes keep-alive connections once
+// their request is served and waits for the in-flight requests. When the
+// timeout expires, the contexts of the remaining requests are canceled and
+// their connections are closed.
+func DrainTimeout(timeout time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.drainTimeout = timeout
+	}
+}
+
+// ResponseEnvelope wraps the bodies written by Respond and RespondError with
+// e, e.g. StandardEnvelope.
+func ResponseEnvelope(e Envelope) ServerOption {
+	return func(o *serverOptions) {
+		o.envelope = e
+	}
+}
+
+// MaxRequestBodySize rejects the request bodies over size bytes with 413
+// Request Entity Too Large before they are routed, 4MB by default. Use Limit
+// for lower limits on specific routes. With StreamRequestBody the bodies
+// aren't buffered and only Limit bounds them.
+func MaxRequestBodySize(size int) ServerOption {
+	return func(o *serverOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// ReadTimeout bounds the time to read each request from the connection, so
+// slow clients can't hold it. Hertz applies it to each read.
+func ReadTimeout(timeout time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.readTimeout = timeout
+	}
+}
+
+// StreamRequestBody lets the handlers read the request bodies from the
+// connection instead of buffering them before the route is matched, so
+// Limit can cut off the large or slow uploads of a route.
+func StreamRequestBody() ServerOption {
+	return func(o *serverOptions) {
+		o.streamBody = true
+	}
+}
@@ -0,0 +1,330 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/codec"
+	"new-milli/i18n"
+	"new-milli/mask"
+	"new-milli/requestctx"
+)
+
+// Headers set by Respond and RespondError.
+const (
+	// HeaderRequestID is the header carrying the id of the request.
+	HeaderRequestID = requestctx.HeaderRequestID
+	// HeaderTraceID is the header carrying the id of the trace of the request.
+	HeaderTraceID = "X-Trace-Id"
+)
+
+// envelopeKey is the key of the envelope in the request context.
+const envelopeKey = "new-milli.envelope"
+
+// Error is the error model of the HTTP responses, written by RespondError.
+// Errors of other types are mapped to it by FromError.
+type Error struct {
+	// Status is the HTTP status of the response.
+	Status int `json:"-" msgpack:"-"`
+	// Code is the machine-readable code of the error, e.g. "NOT_FOUND".
+	Code string `json:"code" msgpack:"code"`
+	// Message is the human-readable message of the error.
+	Message string `json:"message" msgpack:"message"`
+	// Details holds additional information, e.g. the invalid fields.
+	Details map[string]interface{} `json:"details,omitempty" msgpack:"details,omitempty"`
+
+	cause error
+}
+
+// NewError creates a new error with the given status, code and message.
+// The code defaults to the one of the status, e.g. "NOT_FOUND" for 404.
+func NewError(status int, code, message string) *Error {
+	if code == "" {
+		code = ErrorCode(status)
+	}
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.cause != nil && e.cause.Error() != e.Message {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the cause of the error.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// StatusCode returns the HTTP status of the error.
+func (e *Error) StatusCode() int {
+	return e.Status
+}
+
+// WithCause returns a copy of the error caused by err. The cause is logged
+// but never written to the response.
+func (e *Error) WithCause(err error) *Error {
+	out := *e
+	out.cause = err
+	return &out
+}
+
+// WithDetail returns a copy of the error with the detail key set to value.
+func (e *Error) WithDetail(key string, value interface{}) *Error {
+	out := *e
+	out.Details = make(map[string]interface{}, len(e.Details)+1)
+	for k, v := range e.Details {
+		out.Details[k] = v
+	}
+	out.Details[key] = value
+	return &out
+}
+
+// ErrorCode returns the error code of an HTTP status, its upper-cased
+// status text, e.g. "TOO_MANY_REQUESTS" for 429.
+func ErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "UNKNOWN"
+	}
+	text = strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text)
+	return strings.ToUpper(text)
+}
+
+// mapping is an error mapped to a status by RegisterError.
+type mapping struct {
+	target error
+	status int
+	code   string
+}
+
+// mappings is the errors registered with RegisterError.
+var mappings = struct {
+	sync.RWMutex
+	list []mapping
+}{}
+
+// RegisterError maps the errors matching target with errors.Is to status
+// and code, the code of the status when code is empty. The mappings are
+// matched in registration order. The errors of the framework carry their
+// status instead, see transport.NewStatusError.
+func RegisterError(target error, status int, code string) {
+	mappings.Lock()
+	defer mappings.Unlock()
+	mappings.list = append(mappings.list, mapping{target: target, status: status, code: code})
+}
+
+// FromError maps err to the error model, nil when err is nil:
+//   - an *Error in the chain of err is returned as is;
+//   - the errors registered with RegisterError get their status and code;
+//   - errors with a StatusCode() int method of 400 or more get that status,
+//     and the code of their ErrorCode() string method if any;
+//   - timeouts get 504 Gateway Timeout;
+//   - the other errors get 500 Internal Server Error.
+//
+// The message of the server errors is the status text, the error itself is
+// kept as the cause so internal details aren't leaked to the caller.
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	status, code := http.StatusInternalServerError, ""
+	if m, ok := lookup(err); ok {
+		status, code = m.status, m.code
+	} else if s, ok := statusOf(err); ok {
+		status, code = s, codeOf(err)
+	} else if isTimeout(err) {
+		status = http.StatusGatewayTimeout
+	}
+
+	message := err.Error()
+	if status >= http.StatusInternalServerError {
+		message = http.StatusText(status)
+	}
+	return NewError(status, code, message).WithCause(err)
+}
+
+// lookup returns the mapping registered for err.
+func lookup(err error) (mapping, bool) {
+	mappings.RLock()
+	defer mappings.RUnlock()
+	for _, m := range mappings.list {
+		if errors.Is(err, m.target) {
+			return m, true
+		}
+	}
+	return mapping{}, false
+}
+
+// statusOf returns the HTTP status carried by err.
+func statusOf(err error) (int, bool) {
+	var s interface{ StatusCode() int }
+	if errors.As(err, &s) && s.StatusCode() >= http.StatusBadRequest {
+		return s.StatusCode(), true
+	}
+	return 0, false
+}
+
+// codeOf returns the error code carried by err, empty when there is none.
+func codeOf(err error) string {
+	var c interface{ ErrorCode() string }
+	if errors.As(err, &c) {
+		return c.ErrorCode()
+	}
+	return ""
+}
+
+// isTimeout reports whether err is a timeout.
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	if errors.As(err, &t) && t.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// Envelope wraps the response bodies: data is the value passed to Respond
+// and err is nil, or data is nil and err is the error of RespondError.
+type Envelope func(ctx context.Context, data interface{}, err *Error) interface{}
+
+// StandardEnvelope wraps the response bodies in
+// {"data": ..., "error": ..., "request_id": ...}.
+func StandardEnvelope(ctx context.Context, data interface{}, err *Error) interface{} {
+	return &standardEnvelope{
+		Data:      data,
+		Error:     err,
+		RequestID: requestctx.RequestID(ctx),
+	}
+}
+
+// standardEnvelope is the body written with StandardEnvelope.
+type standardEnvelope struct {
+	Data      interface{} `json:"data,omitempty" msgpack:"data,omitempty"`
+	Error     *Error      `json:"error,omitempty" msgpack:"error,omitempty"`
+	RequestID string      `json:"request_id,omitempty" msgpack:"request_id,omitempty"`
+}
+
+// envelopeHandler stores the envelope in the request context for Respond
+// and RespondError.
+func envelopeHandler(e Envelope) app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		ctx.Set(envelopeKey, e)
+		ctx.Next(c)
+	}
+}
+
+// Respond writes data with status 200 OK, see RespondStatus.
+func Respond(ctx context.Context, c *app.RequestContext, data interface{}) {
+	RespondStatus(ctx, c, http.StatusOK, data)
+}
+
+// RespondStatus writes data with the given status, encoded with the codec
+// the Accept header prefers among JSON, protobuf, MessagePack and the other
+// registered codecs, JSON by default or when data can't be encoded with the
+// preferred one. The body is wrapped by the ResponseEnvelope of the server,
+// without one a nil data writes 204 No Content. The request and trace ids
+// are set in the HeaderRequestID and HeaderTraceID headers.
+func RespondStatus(ctx context.Context, c *app.RequestContext, status int, data interface{}) {
+	setIDs(ctx, c)
+	if e := envelope(c); e != nil {
+		write(ctx, c, status, e(ctx, data, nil))
+		return
+	}
+	if data == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	write(ctx, c, status, data)
+}
+
+// RespondError writes err mapped to the error model by FromError, with its
+// status, wrapped by the ResponseEnvelope of the server. The server errors
+// are logged with their cause. The request and trace ids are set in the
+// HeaderRequestID and HeaderTraceID headers.
+func RespondError(ctx context.Context, c *app.RequestContext, err error) {
+	e := FromError(err)
+	if e == nil {
+		e = NewError(http.StatusInternalServerError, "", http.StatusText(http.StatusInternalServerError))
+	}
+	if e.Status >= http.StatusInternalServerError {
+		klog.CtxErrorf(ctx, "http: %s %s: %v", c.Method(), c.Path(), e)
+	}
+
+	e = localize(ctx, e, err)
+
+	setIDs(ctx, c)
+	var body interface{} = e
+	if env := envelope(c); env != nil {
+		body = env(ctx, nil, e)
+	}
+	write(ctx, c, e.Status, body)
+}
+
+// localize translates the message of e, the error model of err, to the
+// locale of the request: the message of an i18n.Localizer in the chain of
+// err for the client errors, or the "error.<CODE>" message of the global
+// i18n bundle for the messages left to their status text.
+func localize(ctx context.Context, e *Error, err error) *Error {
+	message := e.Message
+	if msg, ok := i18n.Localize(ctx, err); ok && e.Status < http.StatusInternalServerError {
+		message = msg
+	} else if e.Message == http.StatusText(e.Status) {
+		if msg, ok := i18n.Default().Lookup(requestctx.Locale(ctx), "error."+e.Code); ok {
+			message = msg
+		}
+	}
+	if message == e.Message {
+		return e
+	}
+	out := *e
+	out.Message = message
+	return &out
+}
+
+// envelope returns the envelope of the server, or nil.
+func envelope(c *app.RequestContext) Envelope {
+	v, _ := c.Get(envelopeKey)
+	e, _ := v.(Envelope)
+	return e
+}
+
+// setIDs sets the request and trace ids in the response headers.
+func setIDs(ctx context.Context, c *app.RequestContext) {
+	if id := requestctx.RequestID(ctx); id != "" {
+		c.Response.Header.Set(HeaderRequestID, id)
+	}
+	if id := requestctx.TraceID(ctx); id != "" {
+		c.Response.Header.Set(HeaderTraceID, id)
+	}
+}
+
+// write writes v with its mask tags applied with the negotiated codec,
+// falling back to JSON when it can't encode v.
+func write(ctx context.Context, c *app.RequestContext, status int, v interface{}) {
+	v = mask.Response(v)
+	cc := codec.Negotiate(string(c.GetHeader("Accept")), codec.JSON)
+	data, err := cc.Marshal(v)
+	if err != nil && cc != codec.JSON {
+		cc = codec.JSON
+		data, err = cc.Marshal(v)
+	}
+	if err != nil {
+		klog.CtxErrorf(ctx, "http: failed to encode response: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, cc.ContentType(), data)
+}
@@ -2,39 +2,87 @@ package http
 
 import (
 	"context"
-	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/config"
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/cleanup"
 	"new-milli/middleware"
 	"new-milli/transport"
 )
 
 var (
-	_ transport.Server = (*Server)(nil)
+	_ transport.Server     = (*Server)(nil)
+	_ transport.Endpointer = (*Server)(nil)
 )
 
 // Server is an HTTP server wrapper based on Hertz.
 type Server struct {
-	opts   *transport.Options
-	server *server.Hertz
+	opts     *transport.Options
+	httpOpts serverOptions
+	server   *server.Hertz
+	conns    *connTracker
 }
 
-// NewServer creates a new HTTP server.
+// NewServer creates a new HTTP server with transport options and the
+// ServerOptions of this package.
 func NewServer(opts ...transport.ServerOption) *Server {
 	options := &transport.Options{}
+	var httpOpts serverOptions
 	for _, o := range opts {
+		if so, ok := o.(ServerOption); ok {
+			so(&httpOpts)
+			continue
+		}
 		o.Apply(options)
 	}
 
 	srv := &Server{
-		opts: options,
+		opts:     options,
+		httpOpts: httpOpts,
+		conns:    newConnTracker(),
 	}
 
-	// Create Hertz server
-	hertzServer := server.Default(
+	hertzOpts := []config.Option{
 		server.WithHostPorts(options.Address),
-	)
+		server.WithOnAccept(srv.conns.onAccept),
+	}
+	if httpOpts.maxBodySize > 0 {
+		hertzOpts = append(hertzOpts, server.WithMaxRequestBodySize(httpOpts.maxBodySize))
+	}
+	if httpOpts.readTimeout > 0 {
+		hertzOpts = append(hertzOpts, server.WithReadTimeout(httpOpts.readTimeout))
+	}
+	if httpOpts.streamBody {
+		hertzOpts = append(hertzOpts, server.WithStreamBody(true))
+	}
+	if httpOpts.tlsConf != nil {
+		tlsConf := httpOpts.tlsConf.Clone()
+		if httpOpts.h2 != nil {
+			tlsConf.NextProtos = append(tlsConf.NextProtos, "h2")
+			hertzOpts = append(hertzOpts, server.WithALPN(true))
+		}
+		hertzOpts = append(hertzOpts, server.WithTLS(tlsConf))
+	} else if httpOpts.h2 != nil {
+		hertzOpts = append(hertzOpts, server.WithH2C(true))
+	}
+
+	// Create Hertz server
+	hertzServer := server.Default(hertzOpts...)
+	if httpOpts.h2 != nil {
+		hertzServer.AddProtocol("h2", httpOpts.h2)
+	}
+	hertzServer.Use(connHandler(httpOpts.maxConnAge))
+
+	// Attach a cleanup scope to every request
+	hertzServer.Use(cleanupHandler())
+
+	if httpOpts.envelope != nil {
+		hertzServer.Use(envelopeHandler(httpOpts.envelope))
+	}
 
 	// Apply middleware
 	for _, m := range options.Middleware {
@@ -58,9 +106,52 @@ func (s *Server) Start(ctx context.Context) error {
 	return s.server.Run()
 }
 
-// Stop stops the server.
+// Stop drains the server within ctx and the DrainTimeout, then cancels the
+// remaining requests and closes their connections.
 func (s *Server) Stop(ctx context.Context) error {
-	return s.server.Shutdown(ctx)
+	if s.httpOpts.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.httpOpts.drainTimeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.server.Shutdown(ctx)
+	}()
+
+	// Hertz waits for the idle keep-alive connections too, close them as
+	// their requests complete
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.conns.closeIdle()
+		case err := <-done:
+			// Hertz returns nil when the drain times out
+			if ctx.Err() != nil {
+				if n := s.conns.closeAll(); n > 0 {
+					klog.Warnf("http: drain timed out, closed %d connections", n)
+				}
+			}
+			return err
+		}
+	}
+}
+
+// Endpoint returns the advertised endpoint of the server.
+func (s *Server) Endpoint() (*url.URL, error) {
+	addr := s.opts.Address
+	if addr == "" {
+		// Hertz listens on :8888 by default
+		addr = ":8888"
+	}
+	scheme := "http"
+	if s.httpOpts.tlsConf != nil {
+		scheme = "https"
+	}
+	return transport.NewEndpoint(scheme, addr)
 }
 
 // GetHertzServer returns the underlying Hertz server.
@@ -68,12 +159,44 @@ func (s *Server) GetHertzServer() *server.Hertz {
 	return s.server
 }
 
+// cleanupHandler attaches a cleanup scope to the request context and runs
+// the registered finalizers once the response has been written.
+// On panic the finalizers run before the panic is propagated.
+func cleanupHandler() app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		scope := cleanup.NewScope()
+		finished := ctx.Finished()
+
+		defer func() {
+			if r := recover(); r != nil {
+				scope.Run()
+				panic(r)
+			}
+			if scope.Len() == 0 {
+				// Nothing to wait for, later registrations run immediately
+				scope.Run()
+				return
+			}
+			go func() {
+				<-finished
+				scope.Run()
+			}()
+		}()
+
+		ctx.Next(cleanup.NewContext(c, scope))
+	}
+}
+
 // convertMiddleware converts Milli middleware to Hertz middleware.
 func convertMiddleware(m middleware.Middleware) app.HandlerFunc {
 	return func(c context.Context, ctx *app.RequestContext) {
 		// Create transport context
 		tr := &Transport{
 			operation:   string(ctx.Request.URI().Path()),
+			method:      string(ctx.Method()),
+			clientIP:    ctx.ClientIP(),
+			remoteAddr:  ctx.RemoteAddr().String(),
+			proto:       ctx.Request.Header.GetProtocol(),
 			reqHeader:   &HeaderCarrier{},
 			replyHeader: &HeaderCarrier{},
 		}
@@ -90,6 +213,11 @@ func convertMiddleware(m middleware.Middleware) app.HandlerFunc {
 		handler := func(c context.Context, req interface{}) (interface{}, error) {
 			// Continue with next handler
 			ctx.Next(c)
+			tr.status = ctx.Response.StatusCode()
+			tr.size = len(ctx.Response.BodyBytes())
+			if ctx.Response.IsBodyStream() || ctx.Response.GetHijackWriter() != nil {
+				tr.size = -1
+			}
 			return nil, nil
 		}
 
@@ -98,8 +226,15 @@ func convertMiddleware(m middleware.Middleware) app.HandlerFunc {
 
 		// Execute handler
 		_, err := h(newCtx, nil)
+
+		// Copy headers set by middleware to the response
+		for _, key := range tr.replyHeader.Keys() {
+			ctx.Response.Header.Set(key, tr.replyHeader.Get(key))
+		}
+
 		if err != nil {
-			ctx.AbortWithStatus(http.StatusInternalServerError)
+			ctx.Abort()
+			RespondError(newCtx, ctx, err)
 		}
 	}
 }
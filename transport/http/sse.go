@@ -0,0 +1,273 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/http1/resp"
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/codec"
+)
+
+var (
+	// ErrStreamClosed is returned when an event is sent on a closed stream.
+	ErrStreamClosed = errors.New("http: event stream closed")
+	// ErrSlowClient is returned when the client doesn't read the events fast
+	// enough, the stream is closed.
+	ErrSlowClient = errors.New("http: event stream client too slow")
+)
+
+// Event is a server-sent event.
+type Event struct {
+	// ID is the event id, sent back by the client in the Last-Event-ID
+	// header when it reconnects.
+	ID string
+	// Event is the event type, "message" for the client when empty.
+	Event string
+	// Data is the event payload. Strings and byte slices are sent as is,
+	// other values are encoded as JSON.
+	Data interface{}
+	// Retry is the reconnection delay the client should use from now on.
+	Retry time.Duration
+}
+
+// SSEOption is event stream option.
+type SSEOption func(*sseOptions)
+
+// sseOptions is event stream options.
+type sseOptions struct {
+	heartbeat   time.Duration
+	retry       time.Duration
+	bufferSize  int
+	sendTimeout time.Duration
+}
+
+// WithHeartbeat returns an SSEOption that sends a comment when no event was
+// sent for interval, 15s by default, keeping proxies from closing the stream
+// and detecting clients gone away.
+func WithHeartbeat(interval time.Duration) SSEOption {
+	return func(o *sseOptions) {
+		o.heartbeat = interval
+	}
+}
+
+// WithRetry returns an SSEOption that sets the reconnection delay of the
+// client when the stream opens.
+func WithRetry(retry time.Duration) SSEOption {
+	return func(o *sseOptions) {
+		o.retry = retry
+	}
+}
+
+// WithBufferSize returns an SSEOption that sets the number of events queued
+// for a client, 16 by default.
+func WithBufferSize(size int) SSEOption {
+	return func(o *sseOptions) {
+		o.bufferSize = size
+	}
+}
+
+// WithSendTimeout returns an SSEOption that sets how long Send waits for room
+// in a full queue, 10s by default, before closing the stream with
+// ErrSlowClient.
+func WithSendTimeout(timeout time.Duration) SSEOption {
+	return func(o *sseOptions) {
+		o.sendTimeout = timeout
+	}
+}
+
+// Stream is an event stream to a client.
+type Stream struct {
+	lastEventID string
+	events      chan []byte
+	closed      chan struct{}
+	sendTimeout time.Duration
+}
+
+// LastEventID returns the id of the last event the client received before
+// reconnecting, empty on the first connection.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send queues an event. It blocks while the queue is full, until the send
+// timeout expires or ctx is done.
+func (s *Stream) Send(ctx context.Context, e *Event) error {
+	data, err := encodeEvent(e)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-s.closed:
+		return ErrStreamClosed
+	case s.events <- data:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(s.sendTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.closed:
+		return ErrStreamClosed
+	case s.events <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return ErrSlowClient
+	}
+}
+
+// StreamFunc produces the events of a stream until it returns. ctx is
+// canceled when the client goes away.
+type StreamFunc func(ctx context.Context, s *Stream) error
+
+// SSE returns a handler streaming the events sent by fn as server-sent
+// events. The stream ends when fn returns, the client goes away or a Send
+// times out.
+func SSE(fn StreamFunc, opts ...SSEOption) app.HandlerFunc {
+	o := sseOptions{
+		heartbeat:   15 * time.Second,
+		bufferSize:  16,
+		sendTimeout: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(c context.Context, ctx *app.RequestContext) {
+		ctx.SetStatusCode(http.StatusOK)
+		ctx.Response.Header.SetContentType("text/event-stream")
+		ctx.Response.Header.Set("Cache-Control", "no-cache")
+		ctx.Response.Header.Set("X-Accel-Buffering", "no")
+		w := resp.NewChunkedBodyWriter(&ctx.Response, ctx.GetWriter())
+		ctx.Response.HijackWriter(w)
+
+		s := &Stream{
+			lastEventID: string(ctx.Request.Header.Peek("Last-Event-ID")),
+			events:      make(chan []byte, o.bufferSize),
+			closed:      make(chan struct{}),
+			sendTimeout: o.sendTimeout,
+		}
+		streamCtx, cancel := context.WithCancel(c)
+		defer cancel()
+
+		result := make(chan error, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					result <- fmt.Errorf("http: event stream panic: %v", r)
+				}
+			}()
+			result <- fn(streamCtx, s)
+		}()
+
+		write := func(data []byte) error {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+
+		err := func() error {
+			// Send the headers right away so the client sees the stream open
+			head := []byte(":\n\n")
+			if o.retry > 0 {
+				head = []byte("retry: " + strconv.FormatInt(o.retry.Milliseconds(), 10) + "\n\n")
+			}
+			if err := write(head); err != nil {
+				return err
+			}
+
+			heartbeat := time.NewTicker(o.heartbeat)
+			defer heartbeat.Stop()
+			for {
+				select {
+				case data := <-s.events:
+					if err := write(data); err != nil {
+						return err
+					}
+					heartbeat.Reset(o.heartbeat)
+				case <-heartbeat.C:
+					if err := write([]byte(":\n\n")); err != nil {
+						return err
+					}
+				case err := <-result:
+					// Send the events queued before fn returned
+					for {
+						select {
+						case data := <-s.events:
+							if werr := write(data); werr != nil {
+								return werr
+							}
+						default:
+							result <- err
+							return nil
+						}
+					}
+				case <-c.Done():
+					return c.Err()
+				}
+			}
+		}()
+		close(s.closed)
+		cancel()
+
+		if ferr := <-result; ferr != nil && !errors.Is(ferr, context.Canceled) {
+			klog.CtxWarnf(c, "http: event stream %s failed: %v", ctx.Request.URI().Path(), ferr)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			klog.CtxDebugf(c, "http: event stream %s closed: %v", ctx.Request.URI().Path(), err)
+		}
+	}
+}
+
+// encodeEvent encodes an event in the event stream format.
+func encodeEvent(e *Event) ([]byte, error) {
+	var data []byte
+	switch v := e.Data.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		var err error
+		if data, err = codec.JSON.Marshal(v); err != nil {
+			return nil, fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: " + field(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		buf.WriteString("event: " + field(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	// Each line of the payload is a data field
+	for _, line := range bytes.Split(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.ReplaceAll(line, []byte("\r"), nil))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// field strips the line breaks of a single line field.
+func field(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -9,6 +9,12 @@ var _ transport.Transporter = (*Transport)(nil)
 // Transport is an HTTP transport.
 type Transport struct {
 	operation   string
+	method      string
+	clientIP    string
+	remoteAddr  string
+	proto       string
+	status      int
+	size        int
 	reqHeader   transport.Header
 	replyHeader transport.Header
 }
@@ -23,6 +29,39 @@ func (tr *Transport) Operation() string {
 	return tr.operation
 }
 
+// Method returns the HTTP method of the request.
+func (tr *Transport) Method() string {
+	return tr.method
+}
+
+// ClientIP returns the IP of the caller, only set for server transports.
+func (tr *Transport) ClientIP() string {
+	return tr.clientIP
+}
+
+// RemoteAddr returns the address of the peer connection, e.g. a proxy, only
+// set for server transports.
+func (tr *Transport) RemoteAddr() string {
+	return tr.remoteAddr
+}
+
+// Protocol returns the protocol of the request, e.g. "HTTP/1.1".
+func (tr *Transport) Protocol() string {
+	return tr.proto
+}
+
+// StatusCode returns the status code of the response, set once the next
+// handlers returned.
+func (tr *Transport) StatusCode() int {
+	return tr.status
+}
+
+// ResponseSize returns the size of the response body, -1 for streamed
+// responses, set once the next handlers returned.
+func (tr *Transport) ResponseSize() int {
+	return tr.size
+}
+
 // RequestHeader returns the request header.
 func (tr *Transport) RequestHeader() transport.Header {
 	return tr.reqHeader
@@ -35,23 +74,36 @@ func (tr *Transport) ReplyHeader() transport.Header {
 
 // HeaderCarrier is a carrier for HTTP headers.
 type HeaderCarrier struct {
-	header map[string]string
+	header map[string][]string
 }
 
-// Get returns the value associated with the passed key.
+// Get returns the first value associated with the passed key.
 func (hc *HeaderCarrier) Get(key string) string {
-	if hc.header == nil {
-		return ""
+	if vs := hc.header[key]; len(vs) > 0 {
+		return vs[0]
 	}
+	return ""
+}
+
+// Values returns the values associated with the passed key.
+func (hc *HeaderCarrier) Values(key string) []string {
 	return hc.header[key]
 }
 
-// Set stores the key-value pair.
+// Set stores the key-value pair, replacing the values of the key.
 func (hc *HeaderCarrier) Set(key string, value string) {
 	if hc.header == nil {
-		hc.header = make(map[string]string)
+		hc.header = make(map[string][]string)
+	}
+	hc.header[key] = []string{value}
+}
+
+// Add appends a value to the values of the key.
+func (hc *HeaderCarrier) Add(key string, value string) {
+	if hc.header == nil {
+		hc.header = make(map[string][]string)
 	}
-	hc.header[key] = value
+	hc.header[key] = append(hc.header[key], value)
 }
 
 // Keys lists the keys stored in this carrier.
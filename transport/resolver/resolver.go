@@ -0,0 +1,238 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"new-milli/registry"
+)
+
+const (
+	// DiscoveryScheme is the endpoint scheme that resolves targets through a registry.
+	DiscoveryScheme = "discovery"
+)
+
+// defaultGracePeriod is how long the nodes are kept after the registry
+// reports no instances.
+const defaultGracePeriod = 10 * time.Second
+
+var (
+	// ErrMissingRegistry is returned when a discovery endpoint is used without a registry.
+	ErrMissingRegistry = errors.New("resolver: registry is required for discovery endpoints")
+)
+
+// Resolver resolves a target into a list of nodes.
+type Resolver interface {
+	// Nodes returns the latest known nodes of the target.
+	Nodes() []*registry.Node
+	// Close stops watching for updates.
+	Close() error
+}
+
+// Option is a function that configures a discovery resolver.
+type Option func(*discoveryResolver)
+
+// WithGracePeriod sets how long the nodes are kept after the registry
+// reports no instances of the service, 10s by default, so that a registry
+// hiccup doesn't drop every endpoint. The nodes are dropped right away
+// when it is 0.
+func WithGracePeriod(d time.Duration) Option {
+	return func(r *discoveryResolver) {
+		r.grace = d
+	}
+}
+
+// WithFilter only keeps the nodes of the registry for which filter returns
+// true, e.g. those serving the protocol of the client.
+func WithFilter(filter func(*registry.Node) bool) Option {
+	return func(r *discoveryResolver) {
+		r.filter = filter
+	}
+}
+
+// New creates a resolver for the given endpoint.
+// Endpoints of the form "discovery:///service-name" are resolved through reg,
+// anything else is treated as a comma separated list of static addresses.
+func New(ctx context.Context, endpoint string, reg registry.Registry, opts ...Option) (Resolver, error) {
+	if name, ok := parseDiscovery(endpoint); ok {
+		if reg == nil {
+			return nil, ErrMissingRegistry
+		}
+		return NewDiscovery(ctx, reg, name, opts...)
+	}
+	return NewStatic(strings.Split(endpoint, ",")...), nil
+}
+
+// parseDiscovery returns the service name of a discovery endpoint.
+func parseDiscovery(endpoint string) (string, bool) {
+	prefix := DiscoveryScheme + ":///"
+	if !strings.HasPrefix(endpoint, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(endpoint, prefix), true
+}
+
+// staticResolver is a resolver with a fixed list of nodes.
+type staticResolver struct {
+	nodes []*registry.Node
+}
+
+// NewStatic creates a resolver that always returns the given addresses.
+func NewStatic(addrs ...string) Resolver {
+	nodes := make([]*registry.Node, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		nodes = append(nodes, &registry.Node{ID: addr, Address: addr})
+	}
+	return &staticResolver{nodes: nodes}
+}
+
+// Nodes returns the static nodes.
+func (r *staticResolver) Nodes() []*registry.Node {
+	return r.nodes
+}
+
+// Close is a no-op for static resolvers.
+func (r *staticResolver) Close() error {
+	return nil
+}
+
+// discoveryResolver resolves a service through a registry and keeps the
+// nodes up to date from the registry watcher.
+type discoveryResolver struct {
+	name    string
+	grace   time.Duration
+	filter  func(*registry.Node) bool
+	watcher registry.Watcher
+	cancel  context.CancelFunc
+
+	mu    sync.RWMutex
+	nodes []*registry.Node
+	drop  *time.Timer
+}
+
+// NewDiscovery creates a resolver that resolves serviceName through reg and
+// refreshes its nodes whenever the registry reports a change.
+func NewDiscovery(ctx context.Context, reg registry.Registry, serviceName string, opts ...Option) (Resolver, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	watcher, err := reg.Watch(ctx, serviceName)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &discoveryResolver{
+		name:    serviceName,
+		grace:   defaultGracePeriod,
+		watcher: watcher,
+		cancel:  cancel,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	// Not every watcher emits the current instances on start
+	services, err := reg.GetService(ctx, serviceName)
+	if err != nil && err != registry.ErrNotFound {
+		cancel()
+		watcher.Stop()
+		return nil, err
+	}
+	r.update(services)
+
+	go r.watch(ctx, serviceName)
+
+	return r, nil
+}
+
+// Nodes returns the latest known nodes.
+func (r *discoveryResolver) Nodes() []*registry.Node {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.nodes
+}
+
+// Close stops watching the registry.
+func (r *discoveryResolver) Close() error {
+	r.cancel()
+	r.mu.Lock()
+	if r.drop != nil {
+		r.drop.Stop()
+		r.drop = nil
+	}
+	r.mu.Unlock()
+	return r.watcher.Stop()
+}
+
+// watch refreshes the nodes from the registry watcher until the context is canceled.
+func (r *discoveryResolver) watch(ctx context.Context, serviceName string) {
+	for {
+		services, err := r.watcher.Next()
+		if err != nil {
+			if ctx.Err() != nil || err == registry.ErrWatchCanceled {
+				return
+			}
+			klog.Warnf("resolver: watch %s failed: %v", serviceName, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		r.update(services)
+	}
+}
+
+// update replaces the nodes with those of the given services. An empty
+// update drops the nodes after the grace period, unless the registry
+// reports instances again meanwhile.
+func (r *discoveryResolver) update(services []*registry.ServiceInfo) {
+	var nodes []*registry.Node
+	for _, service := range services {
+		for _, node := range service.Nodes {
+			if r.filter == nil || r.filter(node) {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(nodes) > 0 {
+		if r.drop != nil {
+			r.drop.Stop()
+			r.drop = nil
+		}
+		r.nodes = nodes
+		return
+	}
+	if len(r.nodes) == 0 || r.drop != nil {
+		return
+	}
+	if r.grace <= 0 {
+		r.nodes = nil
+		return
+	}
+
+	klog.Warnf("resolver: %s has no instances, dropping its nodes in %s", r.name, r.grace)
+	var drop *time.Timer
+	drop = time.AfterFunc(r.grace, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// A newer update canceled the drop
+		if r.drop == drop {
+			r.nodes = nil
+			r.drop = nil
+		}
+	})
+	r.drop = drop
+}
@@ -0,0 +1,423 @@
+// Package warmer pre-resolves and pre-dials downstream targets so the first
+// requests after a deploy don't pay the DNS lookup and TCP/TLS handshake.
+package warmer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/kitex/pkg/klog"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrNotWarm is returned by Check while a target is not warmed or failed its last refill.
+var ErrNotWarm = errors.New("warmer: targets not warm")
+
+// Target is a downstream to keep warm.
+type Target struct {
+	// Address is the host:port of the downstream.
+	Address string
+	// TLS enables the TLS handshake of warm connections when set.
+	TLS *tls.Config
+}
+
+// Option is warmer option.
+type Option func(*options)
+
+// options is warmer options.
+type options struct {
+	targets     []Target
+	minConns    int
+	interval    time.Duration
+	maxIdle     time.Duration
+	dnsTTL      time.Duration
+	dialTimeout time.Duration
+	resolver    *net.Resolver
+}
+
+// WithTargets returns an Option that adds the targets to keep warm.
+func WithTargets(targets ...Target) Option {
+	return func(o *options) {
+		o.targets = append(o.targets, targets...)
+	}
+}
+
+// WithMinConns returns an Option that sets the number of warm connections kept per target.
+func WithMinConns(n int) Option {
+	return func(o *options) {
+		o.minConns = n
+	}
+}
+
+// WithInterval returns an Option that sets how often the warm set is refilled.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithMaxIdle returns an Option that sets how long a warm connection is kept unused
+// before it is replaced, it must be shorter than the idle timeout of the downstream.
+func WithMaxIdle(d time.Duration) Option {
+	return func(o *options) {
+		o.maxIdle = d
+	}
+}
+
+// WithDNSTTL returns an Option that sets how long resolved addresses are cached.
+func WithDNSTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.dnsTTL = ttl
+	}
+}
+
+// WithDialTimeout returns an Option that sets the timeout of dials and handshakes.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithResolver returns an Option that sets the DNS resolver.
+func WithResolver(r *net.Resolver) Option {
+	return func(o *options) {
+		o.resolver = r
+	}
+}
+
+// conn is a warm connection.
+type conn struct {
+	net.Conn
+	since time.Time
+}
+
+// target is the state of a warmed target.
+type target struct {
+	Target
+	host     string
+	port     string
+	addrs    []string
+	resolved time.Time
+	conns    []conn
+	warmed   bool
+	lastErr  error
+}
+
+// Status is the warm status of a target.
+type Status struct {
+	Address string   `json:"address"`
+	Addrs   []string `json:"addrs"`
+	Conns   int      `json:"conns"`
+	Warm    bool     `json:"warm"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Warmer keeps a minimum set of resolved and connected downstream connections
+// and hands them out to clients through DialContext and DialTLSContext.
+type Warmer struct {
+	opts    options
+	dialer  *net.Dialer
+	mu      sync.Mutex
+	targets map[string]*target
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New creates a warmer.
+func New(opts ...Option) (*Warmer, error) {
+	o := options{
+		minConns:    2,
+		interval:    time.Second * 10,
+		maxIdle:     time.Second * 30,
+		dnsTTL:      time.Minute,
+		dialTimeout: time.Second * 3,
+		resolver:    net.DefaultResolver,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	w := &Warmer{
+		opts:    o,
+		dialer:  &net.Dialer{Timeout: o.dialTimeout, KeepAlive: time.Second * 30},
+		targets: make(map[string]*target, len(o.targets)),
+	}
+	for _, t := range o.targets {
+		host, port, err := net.SplitHostPort(t.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warm target %q: %w", t.Address, err)
+		}
+		w.targets[t.Address] = &target{Target: t, host: host, port: port}
+	}
+	return w, nil
+}
+
+// Start warms every target and keeps refilling the warm set in the background.
+// It returns once every target was warmed once, the failures are reported by Check.
+// Use it as a BeforeStart hook so the servers start with warm downstreams.
+func (w *Warmer) Start(ctx context.Context) error {
+	w.refill(ctx)
+
+	ctx, w.cancel = context.WithCancel(context.Background())
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.opts.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.refill(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops refilling and closes the warm connections.
+func (w *Warmer) Stop(ctx context.Context) error {
+	if w.cancel != nil {
+		w.cancel()
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, t := range w.targets {
+		for _, c := range t.conns {
+			c.Close()
+		}
+		t.conns = nil
+	}
+	return nil
+}
+
+// DialContext dials addr, handing out a warm plain connection when one is available.
+// Set it as the DialContext of an http.Transport.
+func (w *Warmer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c := w.take(addr, false); c != nil {
+		return c, nil
+	}
+	return w.dial(ctx, network, addr)
+}
+
+// DialTLSContext dials addr and performs the TLS handshake, handing out a warm
+// TLS connection when one is available. Set it as the DialTLSContext of an http.Transport.
+func (w *Warmer) DialTLSContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return w.TLSDialer(nil)(ctx, network, addr)
+}
+
+// TLSDialer returns a DialTLSContext function handing out warm TLS connections,
+// addresses without a target TLS config are dialed with conf.
+func (w *Warmer) TLSDialer(conf *tls.Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if c := w.take(addr, true); c != nil {
+			return c, nil
+		}
+		return w.dialTLS(ctx, network, addr, conf)
+	}
+}
+
+// dialTLS dials addr and performs the TLS handshake, with the target TLS config if any.
+func (w *Warmer) dialTLS(ctx context.Context, network, addr string, conf *tls.Config) (net.Conn, error) {
+	w.mu.Lock()
+	if t, ok := w.targets[addr]; ok && t.TLS != nil {
+		conf = t.TLS
+	}
+	w.mu.Unlock()
+	if conf == nil {
+		conf = &tls.Config{}
+	}
+	conf = conf.Clone()
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	raw, err := w.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := tls.Client(raw, conf)
+	if err := c.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Status returns the warm status of every target.
+func (w *Warmer) Status() []Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	statuses := make([]Status, 0, len(w.targets))
+	for _, t := range w.targets {
+		s := Status{
+			Address: t.Address,
+			Addrs:   append([]string(nil), t.addrs...),
+			Conns:   len(t.conns),
+			Warm:    t.warmed && t.lastErr == nil,
+		}
+		if t.lastErr != nil {
+			s.Error = t.lastErr.Error()
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// Check returns ErrNotWarm while a target is not warmed or failed its last refill,
+// it suits a health check.
+func (w *Warmer) Check(ctx context.Context) error {
+	var cold []string
+	for _, s := range w.Status() {
+		if !s.Warm {
+			cold = append(cold, s.Address)
+		}
+	}
+	if len(cold) > 0 {
+		return fmt.Errorf("%w: %s", ErrNotWarm, strings.Join(cold, ", "))
+	}
+	return nil
+}
+
+// take removes a warm connection of addr from the pool.
+func (w *Warmer) take(addr string, secure bool) net.Conn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	t, ok := w.targets[addr]
+	if !ok || (t.TLS != nil) != secure {
+		return nil
+	}
+	for len(t.conns) > 0 {
+		c := t.conns[len(t.conns)-1]
+		t.conns = t.conns[:len(t.conns)-1]
+		if time.Since(c.since) < w.opts.maxIdle {
+			return c.Conn
+		}
+		c.Close()
+	}
+	return nil
+}
+
+// dial connects to addr using the cached addresses of a target.
+func (w *Warmer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	w.mu.Lock()
+	var addrs []string
+	if t, ok := w.targets[addr]; ok && time.Since(t.resolved) < w.opts.dnsTTL {
+		addrs = t.addrs
+	}
+	w.mu.Unlock()
+
+	var lastErr error
+	for _, a := range addrs {
+		c, err := w.dialer.DialContext(ctx, network, a)
+		if err == nil {
+			return c, nil
+		}
+		lastErr = err
+	}
+	if c, err := w.dialer.DialContext(ctx, network, addr); err == nil {
+		return c, nil
+	} else if lastErr == nil {
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// refill resolves the targets and tops up their warm connections.
+func (w *Warmer) refill(ctx context.Context) {
+	w.mu.Lock()
+	targets := make([]*target, 0, len(w.targets))
+	for _, t := range w.targets {
+		// Replace the connections that idled too long
+		alive := t.conns[:0]
+		for _, c := range t.conns {
+			if time.Since(c.since) < w.opts.maxIdle {
+				alive = append(alive, c)
+			} else {
+				c.Close()
+			}
+		}
+		t.conns = alive
+		targets = append(targets, t)
+	}
+	w.mu.Unlock()
+
+	g, ctx := errgroup.WithContext(ctx)
+	for _, t := range targets {
+		t := t
+		g.Go(func() error {
+			err := w.warm(ctx, t)
+			w.mu.Lock()
+			t.lastErr = err
+			if err == nil {
+				t.warmed = true
+			}
+			w.mu.Unlock()
+			if err != nil {
+				klog.CtxWarnf(ctx, "Failed to warm %s: %v", t.Address, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// warm resolves a target and dials it up to the minimum warm set.
+func (w *Warmer) warm(ctx context.Context, t *target) error {
+	w.mu.Lock()
+	stale := time.Since(t.resolved) >= w.opts.dnsTTL
+	missing := w.opts.minConns - len(t.conns)
+	w.mu.Unlock()
+
+	if stale {
+		ctx, cancel := context.WithTimeout(ctx, w.opts.dialTimeout)
+		ips, err := w.opts.resolver.LookupHost(ctx, t.host)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", t.host, err)
+		}
+		addrs := make([]string, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.JoinHostPort(ip, t.port)
+		}
+		w.mu.Lock()
+		t.addrs, t.resolved = addrs, time.Now()
+		w.mu.Unlock()
+	}
+
+	for i := 0; i < missing; i++ {
+		ctx, cancel := context.WithTimeout(ctx, w.opts.dialTimeout)
+		var (
+			c   net.Conn
+			err error
+		)
+		if t.TLS != nil {
+			c, err = w.dialTLS(ctx, "tcp", t.Address, nil)
+		} else {
+			c, err = w.dial(ctx, "tcp", t.Address)
+		}
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to dial %s: %w", t.Address, err)
+		}
+		w.mu.Lock()
+		t.conns = append(t.conns, conn{Conn: c, since: time.Now()})
+		w.mu.Unlock()
+	}
+	return nil
+}